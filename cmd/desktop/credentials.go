@@ -0,0 +1,92 @@
+package main
+
+import "C"
+
+import (
+	"encoding/base64"
+
+	"github.com/kubenav/kubenav/cmd/desktop/cerror"
+	"github.com/kubenav/kubenav/pkg/kube/credentials"
+)
+
+// StoreClusterCredentials stores the JSON encoded credentials of a cluster in memory, so that the cluster can be
+// referenced via the "X-CLUSTER-ID" header in requests to the server. If the credentials could not be stored an error
+// is returned, otherwise an empty string.
+//
+//export StoreClusterCredentials
+func StoreClusterCredentials(clusterIDC *C.char, clusterIDLen C.int, credentialsC *C.char, credentialsLen C.int) *C.char {
+	c, err := credentials.Parse([]byte(C.GoStringN(credentialsC, credentialsLen)))
+	if err != nil {
+		return C.CString(cerror.New(err))
+	}
+
+	if err := credentials.Set(C.GoStringN(clusterIDC, clusterIDLen), c); err != nil {
+		return C.CString(cerror.New(err))
+	}
+
+	return C.CString("")
+}
+
+// RemoveClusterCredentials removes the stored credentials of the cluster with the given id.
+//
+//export RemoveClusterCredentials
+func RemoveClusterCredentials(clusterIDC *C.char, clusterIDLen C.int) {
+	credentials.Delete(C.GoStringN(clusterIDC, clusterIDLen))
+}
+
+// SaveCredentialStore encrypts all stored credentials with the base64 encoded 32 byte key and writes them to the
+// given path. The key should be kept in the keychain of the operating system. If the store could not be saved an
+// error is returned, otherwise an empty string.
+//
+//export SaveCredentialStore
+func SaveCredentialStore(pathC *C.char, pathLen C.int, keyC *C.char, keyLen C.int) *C.char {
+	key, err := base64.StdEncoding.DecodeString(C.GoStringN(keyC, keyLen))
+	if err != nil {
+		return C.CString(cerror.New(credentials.ErrInvalidKey))
+	}
+
+	if err := credentials.Save(C.GoStringN(pathC, pathLen), key); err != nil {
+		return C.CString(cerror.New(err))
+	}
+
+	return C.CString("")
+}
+
+// LoadCredentialStore restores the stored credentials from the file at the given path, which was written via
+// SaveCredentialStore with the same key. If the key is wrong or the file was modified an error is returned.
+//
+//export LoadCredentialStore
+func LoadCredentialStore(pathC *C.char, pathLen C.int, keyC *C.char, keyLen C.int) *C.char {
+	key, err := base64.StdEncoding.DecodeString(C.GoStringN(keyC, keyLen))
+	if err != nil {
+		return C.CString(cerror.New(credentials.ErrInvalidKey))
+	}
+
+	if err := credentials.Load(C.GoStringN(pathC, pathLen), key); err != nil {
+		return C.CString(cerror.New(err))
+	}
+
+	return C.CString("")
+}
+
+// RotateCredentialStoreKey encrypts the persisted store at the given path with a new key. Both keys must be base64
+// encoded 32 byte keys.
+//
+//export RotateCredentialStoreKey
+func RotateCredentialStoreKey(pathC *C.char, pathLen C.int, oldKeyC *C.char, oldKeyLen C.int, newKeyC *C.char, newKeyLen C.int) *C.char {
+	oldKey, err := base64.StdEncoding.DecodeString(C.GoStringN(oldKeyC, oldKeyLen))
+	if err != nil {
+		return C.CString(cerror.New(credentials.ErrInvalidKey))
+	}
+
+	newKey, err := base64.StdEncoding.DecodeString(C.GoStringN(newKeyC, newKeyLen))
+	if err != nil {
+		return C.CString(cerror.New(credentials.ErrInvalidKey))
+	}
+
+	if err := credentials.RotateKey(C.GoStringN(pathC, pathLen), oldKey, newKey); err != nil {
+		return C.CString(cerror.New(err))
+	}
+
+	return C.CString("")
+}
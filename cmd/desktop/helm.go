@@ -5,6 +5,7 @@ import "C"
 import (
 	"github.com/kubenav/kubenav/cmd/desktop/cerror"
 	"github.com/kubenav/kubenav/cmd/desktop/dart_api_dl"
+	"github.com/kubenav/kubenav/pkg/server"
 	"github.com/kubenav/kubenav/pkg/shared"
 )
 
@@ -93,3 +94,208 @@ func helmGetHistory(port int64, contextName, proxy string, timeout int64, namesp
 
 	dart_api_dl.SendToPort(port, history)
 }
+
+// HelmListReleases returns the Helm releases for the given cluster and namespace, if the namespace is empty the
+// releases of all namespaces are returned. By default only the latest revision of each release is returned, when
+// "allRevisions" is 1 all revisions are returned. If an error occures during the process the error is returned.
+//
+//export HelmListReleases
+func HelmListReleases(port C.long, contextNameC *C.char, contextNameLen C.int, proxyC *C.char, proxyLen C.int, timeout C.long, namespaceC *C.char, namespaceLen C.int, allRevisionsC C.int) {
+	contextName := C.GoStringN(contextNameC, contextNameLen)
+	proxy := C.GoStringN(proxyC, proxyLen)
+	namespace := C.GoStringN(namespaceC, namespaceLen)
+	var allRevisions bool
+	if allRevisionsC == 1 {
+		allRevisions = true
+	}
+
+	go helmListReleases(int64(port), contextName, proxy, int64(timeout), namespace, allRevisions)
+}
+
+func helmListReleases(port int64, contextName, proxy string, timeout int64, namespace string, allRevisions bool) {
+	_, clientset, err := kubeClient.GetClient(contextName, "", "", false, "", "", "", "", "", proxy, timeout)
+	if err != nil {
+		dart_api_dl.SendToPort(port, cerror.New(err))
+		return
+	}
+
+	list, err := shared.HelmListReleases(clientset, namespace, allRevisions)
+	if err != nil {
+		dart_api_dl.SendToPort(port, cerror.New(err))
+		return
+	}
+
+	dart_api_dl.SendToPort(port, list)
+}
+
+// HelmGetValues returns the user supplied and the computed values of a revision of a Helm release. If the revision is
+// 0 the values of the latest revision are returned. When "redact" is 1 all values which could contain a credential are
+// replaced. If an error occures during the process the error is returned.
+//
+//export HelmGetValues
+func HelmGetValues(port C.long, contextNameC *C.char, contextNameLen C.int, proxyC *C.char, proxyLen C.int, timeout C.long, namespaceC *C.char, namespaceLen C.int, nameC *C.char, nameLen C.int, revisionC C.long, redactC C.int) {
+	contextName := C.GoStringN(contextNameC, contextNameLen)
+	proxy := C.GoStringN(proxyC, proxyLen)
+	namespace := C.GoStringN(namespaceC, namespaceLen)
+	name := C.GoStringN(nameC, nameLen)
+	var redact bool
+	if redactC == 1 {
+		redact = true
+	}
+
+	go helmGetValues(int64(port), contextName, proxy, int64(timeout), namespace, name, int64(revisionC), redact)
+}
+
+func helmGetValues(port int64, contextName, proxy string, timeout int64, namespace, name string, revision int64, redact bool) {
+	_, clientset, err := kubeClient.GetClient(contextName, "", "", false, "", "", "", "", "", proxy, timeout)
+	if err != nil {
+		dart_api_dl.SendToPort(port, cerror.New(err))
+		return
+	}
+
+	values, err := shared.HelmGetValues(clientset, namespace, name, revision, redact)
+	if err != nil {
+		dart_api_dl.SendToPort(port, cerror.New(err))
+		return
+	}
+
+	dart_api_dl.SendToPort(port, values)
+}
+
+// HelmGetManifest returns the rendered manifest of a revision of a Helm release. If the revision is 0 the manifest of
+// the latest revision is returned. If the manifest is larger than the given limit (in bytes) it is truncated. If an
+// error occures during the process the error is returned.
+//
+//export HelmGetManifest
+func HelmGetManifest(port C.long, contextNameC *C.char, contextNameLen C.int, proxyC *C.char, proxyLen C.int, timeout C.long, namespaceC *C.char, namespaceLen C.int, nameC *C.char, nameLen C.int, revisionC C.long, limitC C.long) {
+	contextName := C.GoStringN(contextNameC, contextNameLen)
+	proxy := C.GoStringN(proxyC, proxyLen)
+	namespace := C.GoStringN(namespaceC, namespaceLen)
+	name := C.GoStringN(nameC, nameLen)
+
+	go helmGetManifest(int64(port), contextName, proxy, int64(timeout), namespace, name, int64(revisionC), int64(limitC))
+}
+
+func helmGetManifest(port int64, contextName, proxy string, timeout int64, namespace, name string, revision, limit int64) {
+	_, clientset, err := kubeClient.GetClient(contextName, "", "", false, "", "", "", "", "", proxy, timeout)
+	if err != nil {
+		dart_api_dl.SendToPort(port, cerror.New(err))
+		return
+	}
+
+	manifest, err := shared.HelmGetManifest(clientset, namespace, name, revision, limit)
+	if err != nil {
+		dart_api_dl.SendToPort(port, cerror.New(err))
+		return
+	}
+
+	dart_api_dl.SendToPort(port, manifest)
+}
+
+// HelmRollback rolls back a Helm release to the given revision, if the revision is 0 the release is rolled back to the
+// previous revision. When "dryRun" is 1 only the diff between the current and the target revision is returned.
+// Rollbacks are recorded in the mutation audit log of the server, when it is enabled. If an error occures during the
+// process the error is returned.
+//
+//export HelmRollback
+func HelmRollback(port C.long, contextNameC *C.char, contextNameLen C.int, proxyC *C.char, proxyLen C.int, timeout C.long, namespaceC *C.char, namespaceLen C.int, nameC *C.char, nameLen C.int, revisionC C.long, dryRunC C.int) {
+	contextName := C.GoStringN(contextNameC, contextNameLen)
+	proxy := C.GoStringN(proxyC, proxyLen)
+	namespace := C.GoStringN(namespaceC, namespaceLen)
+	name := C.GoStringN(nameC, nameLen)
+	var dryRun bool
+	if dryRunC == 1 {
+		dryRun = true
+	}
+
+	go helmRollback(int64(port), contextName, proxy, int64(timeout), namespace, name, int64(revisionC), dryRun)
+}
+
+func helmRollback(port int64, contextName, proxy string, timeout int64, namespace, name string, revision int64, dryRun bool) {
+	restConfig, clientset, err := kubeClient.GetClient(contextName, "", "", false, "", "", "", "", "", proxy, timeout)
+	if err != nil {
+		dart_api_dl.SendToPort(port, cerror.New(err))
+		return
+	}
+
+	result, err := shared.HelmRollback(clientset, restConfig.Host, namespace, name, revision, dryRun)
+	if !dryRun {
+		server.AuditMutation("helm-rollback", restConfig.Host, namespace+"/"+name, err)
+	}
+	if err != nil {
+		dart_api_dl.SendToPort(port, cerror.New(err))
+		return
+	}
+
+	dart_api_dl.SendToPort(port, result)
+}
+
+// HelmUninstall uninstalls a Helm release. When "keepHistory" is 1 the records of the release are kept and the latest
+// revision is marked as uninstalled. When "dryRun" is 1 nothing is changed. Uninstalls are recorded in the mutation
+// audit log of the server, when it is enabled. If an error occures during the process the error is returned.
+//
+//export HelmUninstall
+func HelmUninstall(port C.long, contextNameC *C.char, contextNameLen C.int, proxyC *C.char, proxyLen C.int, timeout C.long, namespaceC *C.char, namespaceLen C.int, nameC *C.char, nameLen C.int, keepHistoryC C.int, dryRunC C.int) {
+	contextName := C.GoStringN(contextNameC, contextNameLen)
+	proxy := C.GoStringN(proxyC, proxyLen)
+	namespace := C.GoStringN(namespaceC, namespaceLen)
+	name := C.GoStringN(nameC, nameLen)
+	var keepHistory bool
+	if keepHistoryC == 1 {
+		keepHistory = true
+	}
+	var dryRun bool
+	if dryRunC == 1 {
+		dryRun = true
+	}
+
+	go helmUninstall(int64(port), contextName, proxy, int64(timeout), namespace, name, keepHistory, dryRun)
+}
+
+func helmUninstall(port int64, contextName, proxy string, timeout int64, namespace, name string, keepHistory, dryRun bool) {
+	restConfig, clientset, err := kubeClient.GetClient(contextName, "", "", false, "", "", "", "", "", proxy, timeout)
+	if err != nil {
+		dart_api_dl.SendToPort(port, cerror.New(err))
+		return
+	}
+
+	result, err := shared.HelmUninstall(clientset, restConfig.Host, namespace, name, keepHistory, dryRun)
+	if !dryRun {
+		server.AuditMutation("helm-uninstall", restConfig.Host, namespace+"/"+name, err)
+	}
+	if err != nil {
+		dart_api_dl.SendToPort(port, cerror.New(err))
+		return
+	}
+
+	dart_api_dl.SendToPort(port, result)
+}
+
+// HelmDiffRevisions returns the diff between the rendered manifests of two revisions of a Helm release. If an error
+// occures during the process the error is returned.
+//
+//export HelmDiffRevisions
+func HelmDiffRevisions(port C.long, contextNameC *C.char, contextNameLen C.int, proxyC *C.char, proxyLen C.int, timeout C.long, namespaceC *C.char, namespaceLen C.int, nameC *C.char, nameLen C.int, fromRevisionC C.long, toRevisionC C.long) {
+	contextName := C.GoStringN(contextNameC, contextNameLen)
+	proxy := C.GoStringN(proxyC, proxyLen)
+	namespace := C.GoStringN(namespaceC, namespaceLen)
+	name := C.GoStringN(nameC, nameLen)
+
+	go helmDiffRevisions(int64(port), contextName, proxy, int64(timeout), namespace, name, int64(fromRevisionC), int64(toRevisionC))
+}
+
+func helmDiffRevisions(port int64, contextName, proxy string, timeout int64, namespace, name string, fromRevision, toRevision int64) {
+	_, clientset, err := kubeClient.GetClient(contextName, "", "", false, "", "", "", "", "", proxy, timeout)
+	if err != nil {
+		dart_api_dl.SendToPort(port, cerror.New(err))
+		return
+	}
+
+	diff, err := shared.HelmDiffRevisions(clientset, namespace, name, fromRevision, toRevision)
+	if err != nil {
+		dart_api_dl.SendToPort(port, cerror.New(err))
+		return
+	}
+
+	dart_api_dl.SendToPort(port, diff)
+}
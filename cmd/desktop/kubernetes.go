@@ -4,11 +4,13 @@ import "C"
 
 import (
 	"encoding/json"
+	"log"
 	"strings"
 
 	"github.com/kubenav/kubenav/cmd/desktop/cerror"
 	"github.com/kubenav/kubenav/cmd/desktop/dart_api_dl"
 	"github.com/kubenav/kubenav/pkg/server"
+	"github.com/kubenav/kubenav/pkg/server/requestcache"
 	"github.com/kubenav/kubenav/pkg/shared"
 )
 
@@ -39,7 +41,8 @@ func KubernetesClusters() *C.char {
 // request can set the "clusterInsecureSkipTLSVerify" argument to true. To handle the authentication against the API
 // server the "user*" arguments can be used.
 // The "requestMethod", "requestURL" and "requestBody" arguments are then used for the actually request. E.g. to get all
-// Pods from the Kubernetes API the method "GET" and the URL "/api/v1/pods" can be used.
+// Pods from the Kubernetes API the method "GET" and the URL "/api/v1/pods" can be used. All other requests are
+// recorded in the mutation audit log of the server, when it is enabled.
 //
 //export KubernetesRequest
 func KubernetesRequest(port C.long, contextNameC *C.char, contextNameLen C.int, proxyC *C.char, proxyLen C.int, timeout C.long, requestMethodC *C.char, requestMethodLen C.int, requestURLC *C.char, requestURLLen C.int, requestBodyC *C.char, requestBodyLen C.int) {
@@ -49,19 +52,49 @@ func KubernetesRequest(port C.long, contextNameC *C.char, contextNameLen C.int,
 	requestURL := C.GoStringN(requestURLC, requestURLLen)
 	requestBody := C.GoStringN(requestBodyC, requestBodyLen)
 
-	go kubernetesRequest(int64(port), contextName, proxy, int64(timeout), requestMethod, requestURL, requestBody)
+	go kubernetesRequest(int64(port), contextName, proxy, int64(timeout), requestMethod, requestURL, requestBody, "")
 }
 
-func kubernetesRequest(port int64, contextName, proxy string, timeout int64, requestMethod, requestURL, requestBody string) {
+// KubernetesRequestWithCache is the same as KubernetesRequest, but GET requests can use the request cache via the
+// "cacheControl" argument. The supported values are "bypass", "prefer-cache" and "max-age=<seconds>".
+//
+//export KubernetesRequestWithCache
+func KubernetesRequestWithCache(port C.long, contextNameC *C.char, contextNameLen C.int, proxyC *C.char, proxyLen C.int, timeout C.long, requestMethodC *C.char, requestMethodLen C.int, requestURLC *C.char, requestURLLen C.int, requestBodyC *C.char, requestBodyLen C.int, cacheControlC *C.char, cacheControlLen C.int) {
+	contextName := C.GoStringN(contextNameC, contextNameLen)
+	proxy := C.GoStringN(proxyC, proxyLen)
+	requestMethod := C.GoStringN(requestMethodC, requestMethodLen)
+	requestURL := C.GoStringN(requestURLC, requestURLLen)
+	requestBody := C.GoStringN(requestBodyC, requestBodyLen)
+	cacheControl := C.GoStringN(cacheControlC, cacheControlLen)
+
+	go kubernetesRequest(int64(port), contextName, proxy, int64(timeout), requestMethod, requestURL, requestBody, cacheControl)
+}
+
+// KubernetesSetMaxResponseSize sets the maximum size in bytes of a response, which is read into memory by
+// KubernetesRequest. Larger responses return a "response too large" error, list requests are fetched page by page
+// instead. If the size is 0 the default of 64 MiB is used.
+//
+//export KubernetesSetMaxResponseSize
+func KubernetesSetMaxResponseSize(size C.long) {
+	shared.SetMaxResponseSize(int64(size))
+}
+
+// KubernetesFlushCache removes all cached responses of the KubernetesRequestWithCache function.
+//
+//export KubernetesFlushCache
+func KubernetesFlushCache() {
+	shared.KubernetesFlushCache()
+}
+
+func kubernetesRequest(port int64, contextName, proxy string, timeout int64, requestMethod, requestURL, requestBody, cacheControl string) {
 	restConfig, clientset, err := kubeClient.GetClient(contextName, "", "", false, "", "", "", "", "", proxy, timeout)
 	if err != nil {
 		dart_api_dl.SendToPort(port, cerror.New(err))
 		return
 	}
 
-	requestURL = strings.TrimRight(restConfig.ServerName, "/") + requestURL
-
-	result, err := shared.KubernetesRequest(clientset, requestMethod, requestURL, requestBody)
+	result, err := shared.KubernetesRequest(clientset, contextName, requestcache.CredentialsKey(restConfig), cacheControl, requestMethod, strings.TrimRight(restConfig.ServerName, "/")+requestURL, requestBody)
+	server.AuditMutation(requestMethod, restConfig.Host, requestURL, err)
 	if err != nil {
 		dart_api_dl.SendToPort(port, cerror.New(err))
 		return
@@ -108,9 +141,86 @@ func kubernetesGetLogs(port int64, contextName, proxy string, timeout int64, nam
 }
 
 // KubernetesStartServer starts an Go server which listens on "14122". The server is responsible for providing the
-// port forwarding and Pod exec feature for kubenav.
+// port forwarding and Pod exec feature for kubenav. Because the auth token can not be returned to the app, the auth
+// token is disabled for this function, KubernetesStartServerWithOptions should be used instead.
 //
 //export KubernetesStartServer
 func KubernetesStartServer() {
-	go server.Start(kubeClient)
+	if _, err := server.Start(kubeClient, server.Options{DisableAuth: true}); err != nil {
+		log.Printf("Could not start server: %s", err.Error())
+	}
+}
+
+// KubernetesStartServerWithOptions starts the Go server with the given options, which must be a JSON encoded
+// "server.Options" object. The function returns the JSON encoded "server.Info" as soon as the server was started, so
+// that the app can also use a port chosen by the operating system (port 0), pin the certificate, when TLS is used, and
+// send the auth token with all requests. The info also contains the results of the self-check, so that the app can
+// disable features which will not work on the device. If the server is already running, the info of the running
+// server is returned.
+//
+//export KubernetesStartServerWithOptions
+func KubernetesStartServerWithOptions(optionsC *C.char, optionsLen C.int) *C.char {
+	options := C.GoStringN(optionsC, optionsLen)
+
+	var serverOptions server.Options
+	if options != "" {
+		if err := json.Unmarshal([]byte(options), &serverOptions); err != nil {
+			return C.CString(cerror.New(err))
+		}
+	}
+
+	info, err := server.Start(kubeClient, serverOptions)
+	if err != nil {
+		return C.CString(cerror.New(err))
+	}
+
+	jsonData, err := json.Marshal(info)
+	if err != nil {
+		return C.CString(cerror.New(err))
+	}
+
+	return C.CString(string(jsonData))
+}
+
+// KubernetesStopServer stops the Go server gracefully. All sessions are closed and the function returns, when the
+// server was stopped. If the server could not be stopped an error is returned, otherwise an empty string.
+//
+//export KubernetesStopServer
+func KubernetesStopServer() *C.char {
+	if err := server.Stop(); err != nil {
+		return C.CString(cerror.New(err))
+	}
+
+	return C.CString("")
+}
+
+// KubernetesRestartServer restarts the Go server with the options from the last start. The server uses the same port
+// and auth token as before and the function returns the JSON encoded "server.Info" of the new server.
+//
+//export KubernetesRestartServer
+func KubernetesRestartServer() *C.char {
+	info, err := server.Restart()
+	if err != nil {
+		return C.CString(cerror.New(err))
+	}
+
+	jsonData, err := json.Marshal(info)
+	if err != nil {
+		return C.CString(cerror.New(err))
+	}
+
+	return C.CString(string(jsonData))
+}
+
+// KubernetesServerStatus returns the JSON encoded "server.Status", which contains if the server is running, the port,
+// the uptime and the number of active sessions.
+//
+//export KubernetesServerStatus
+func KubernetesServerStatus() *C.char {
+	jsonData, err := json.Marshal(server.GetStatus())
+	if err != nil {
+		return C.CString(cerror.New(err))
+	}
+
+	return C.CString(string(jsonData))
 }
@@ -0,0 +1,35 @@
+package main
+
+import "C"
+
+import (
+	"github.com/kubenav/kubenav/cmd/desktop/cerror"
+	"github.com/kubenav/kubenav/cmd/desktop/dart_api_dl"
+	"github.com/kubenav/kubenav/pkg/shared"
+)
+
+// KustomizeRender renders the kustomization in the given directory and returns the rendered manifest. Remote bases
+// are only allowed, when "allowRemoteBases" is 1. If the kustomization can not be rendered, the error contains the
+// kustomization file and the field which caused the error.
+//
+//export KustomizeRender
+func KustomizeRender(port C.long, dirC *C.char, dirLen C.int, allowRemoteBasesC C.int) {
+	dir := C.GoStringN(dirC, dirLen)
+
+	var allowRemoteBases bool
+	if allowRemoteBasesC == 1 {
+		allowRemoteBases = true
+	}
+
+	go kustomizeRender(int64(port), dir, allowRemoteBases)
+}
+
+func kustomizeRender(port int64, dir string, allowRemoteBases bool) {
+	manifest, err := shared.KustomizeRender(dir, allowRemoteBases)
+	if err != nil {
+		dart_api_dl.SendToPort(port, cerror.New(err))
+		return
+	}
+
+	dart_api_dl.SendToPort(port, manifest)
+}
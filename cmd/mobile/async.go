@@ -0,0 +1,107 @@
+package kubenav
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/kubenav/kubenav/pkg/kube"
+	"github.com/kubenav/kubenav/pkg/kube/mobile"
+	"github.com/kubenav/kubenav/pkg/server"
+	"github.com/kubenav/kubenav/pkg/server/requestcache"
+	"github.com/kubenav/kubenav/pkg/server/sessions"
+	"github.com/kubenav/kubenav/pkg/shared"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// RequestCanceledCode is the code, which is passed to the OnError method of a RequestCallback, when the request was
+// canceled via KubernetesCancelRequest. It is the same code as it is used by nginx for requests, which were closed by
+// the client.
+const RequestCanceledCode int64 = 499
+
+// RequestCallback is implemented by the caller of KubernetesRequestAsync, to get the result of the request. Exactly
+// one of the methods is called exactly once for each request. The code of OnError is the status code of the
+// Kubernetes API, RequestCanceledCode for canceled requests or 0 for all other errors.
+type RequestCallback interface {
+	OnSuccess(body string)
+	OnError(message string, code int64)
+}
+
+// asyncRequests contains all running requests by their handle. A request is removed from the
+// store before its callback is called, by the request itself or by KubernetesCancelRequest. Only the caller, which
+// removed the request, calls the callback, so that the callback is never called twice or concurrently and completed
+// requests do not stay in the store.
+var asyncRequests = sessions.New[*asyncRequest]()
+
+// asyncRequest is a running request of KubernetesRequestAsync.
+type asyncRequest struct {
+	cancel   context.CancelFunc
+	callback RequestCallback
+}
+
+// asyncRequestHandle is the last handle, which was returned by KubernetesRequestAsync.
+var asyncRequestHandle atomic.Int64
+
+// KubernetesRequestAsync is the asynchronous variant of KubernetesRequest. It takes the same arguments and a callback,
+// which is called with the result of the request. The function returns immediately with a handle, which can be passed
+// to KubernetesCancelRequest to abort the request.
+func KubernetesRequestAsync(clusterServer, clusterCertificateAuthorityData string, clusterInsecureSkipTLSVerify bool, userClientCertificateData, userClientKeyData, userToken, userUsername, userPassword, proxy string, timeout int64, requestMethod, requestURL, requestBody string, callback RequestCallback) int64 {
+	handle := asyncRequestHandle.Add(1)
+	id := strconv.FormatInt(handle, 10)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	asyncRequests.Set(id, &asyncRequest{cancel: cancel, callback: callback})
+
+	go func() {
+		defer cancel()
+
+		result, err := kubernetesRequestWithContext(ctx, clusterServer, clusterCertificateAuthorityData, clusterInsecureSkipTLSVerify, userClientCertificateData, userClientKeyData, userToken, userUsername, userPassword, proxy, timeout, requestMethod, requestURL, requestBody)
+		if _, ok := asyncRequests.Delete(id); !ok {
+			return
+		}
+
+		if err != nil {
+			callback.OnError(err.Error(), errorCode(err))
+			return
+		}
+		callback.OnSuccess(result)
+	}()
+
+	return handle
+}
+
+// KubernetesCancelRequest cancels the request with the given handle, which was returned by KubernetesRequestAsync.
+// The OnError method of the callback of the request is called with the RequestCanceledCode. If the request is already
+// completed, nothing is done.
+func KubernetesCancelRequest(handle int64) {
+	request, ok := asyncRequests.Delete(strconv.FormatInt(handle, 10))
+	if !ok {
+		return
+	}
+
+	request.cancel()
+	request.callback.OnError(context.Canceled.Error(), RequestCanceledCode)
+}
+
+func kubernetesRequestWithContext(ctx context.Context, clusterServer, clusterCertificateAuthorityData string, clusterInsecureSkipTLSVerify bool, userClientCertificateData, userClientKeyData, userToken, userUsername, userPassword, proxy string, timeout int64, requestMethod, requestURL, requestBody string) (string, error) {
+	restConfig, clientset, err := kube.NewClient(mobile.Platform).GetClient("", clusterServer, clusterCertificateAuthorityData, clusterInsecureSkipTLSVerify, userClientCertificateData, userClientKeyData, userToken, userUsername, userPassword, proxy, timeout)
+	if err != nil {
+		return "", err
+	}
+
+	result, err := shared.KubernetesRequestWithContext(ctx, clientset, clusterServer, requestcache.CredentialsKey(restConfig), "", requestMethod, strings.TrimRight(clusterServer, "/")+requestURL, requestBody)
+	server.AuditMutation(requestMethod, clusterServer, requestURL, err)
+	return result, err
+}
+
+// errorCode returns the status code of an error of the Kubernetes API. For all other errors 0 is returned.
+func errorCode(err error) int64 {
+	var status apierrors.APIStatus
+	if errors.As(err, &status) {
+		return int64(status.Status().Code)
+	}
+	return 0
+}
@@ -3,6 +3,7 @@ package kubenav
 import (
 	"github.com/kubenav/kubenav/pkg/kube"
 	"github.com/kubenav/kubenav/pkg/kube/mobile"
+	"github.com/kubenav/kubenav/pkg/server"
 	"github.com/kubenav/kubenav/pkg/shared"
 )
 
@@ -38,3 +39,83 @@ func HelmGetHistory(clusterServer, clusterCertificateAuthorityData string, clust
 
 	return shared.HelmGetHistory(clientset, namespace, name)
 }
+
+// HelmListReleases returns the Helm releases for the given cluster and namespace, if the namespace is empty the
+// releases of all namespaces are returned. By default only the latest revision of each release is returned, when
+// "allRevisions" is true all revisions are returned. If an error occures during the process the error is returned.
+func HelmListReleases(clusterServer, clusterCertificateAuthorityData string, clusterInsecureSkipTLSVerify bool, userClientCertificateData, userClientKeyData, userToken, userUsername, userPassword, proxy string, timeout int64, namespace string, allRevisions bool) (string, error) {
+	_, clientset, err := kube.NewClient(mobile.Platform).GetClient("", clusterServer, clusterCertificateAuthorityData, clusterInsecureSkipTLSVerify, userClientCertificateData, userClientKeyData, userToken, userUsername, userPassword, proxy, timeout)
+	if err != nil {
+		return "", err
+	}
+
+	return shared.HelmListReleases(clientset, namespace, allRevisions)
+}
+
+// HelmGetValues returns the user supplied and the computed values of a revision of a Helm release. If the revision is
+// 0 the values of the latest revision are returned. When "redact" is true all values which could contain a credential
+// are replaced. If an error occures during the process the error is returned.
+func HelmGetValues(clusterServer, clusterCertificateAuthorityData string, clusterInsecureSkipTLSVerify bool, userClientCertificateData, userClientKeyData, userToken, userUsername, userPassword, proxy string, timeout int64, namespace, name string, revision int64, redact bool) (string, error) {
+	_, clientset, err := kube.NewClient(mobile.Platform).GetClient("", clusterServer, clusterCertificateAuthorityData, clusterInsecureSkipTLSVerify, userClientCertificateData, userClientKeyData, userToken, userUsername, userPassword, proxy, timeout)
+	if err != nil {
+		return "", err
+	}
+
+	return shared.HelmGetValues(clientset, namespace, name, revision, redact)
+}
+
+// HelmGetManifest returns the rendered manifest of a revision of a Helm release. If the revision is 0 the manifest of
+// the latest revision is returned. If the manifest is larger than the given limit (in bytes) it is truncated. If an
+// error occures during the process the error is returned.
+func HelmGetManifest(clusterServer, clusterCertificateAuthorityData string, clusterInsecureSkipTLSVerify bool, userClientCertificateData, userClientKeyData, userToken, userUsername, userPassword, proxy string, timeout int64, namespace, name string, revision, limit int64) (string, error) {
+	_, clientset, err := kube.NewClient(mobile.Platform).GetClient("", clusterServer, clusterCertificateAuthorityData, clusterInsecureSkipTLSVerify, userClientCertificateData, userClientKeyData, userToken, userUsername, userPassword, proxy, timeout)
+	if err != nil {
+		return "", err
+	}
+
+	return shared.HelmGetManifest(clientset, namespace, name, revision, limit)
+}
+
+// HelmRollback rolls back a Helm release to the given revision, if the revision is 0 the release is rolled back to the
+// previous revision. When "dryRun" is true only the diff between the current and the target revision is returned.
+// Rollbacks are recorded in the mutation audit log of the server, when it is enabled. If an error occures during the
+// process the error is returned.
+func HelmRollback(clusterServer, clusterCertificateAuthorityData string, clusterInsecureSkipTLSVerify bool, userClientCertificateData, userClientKeyData, userToken, userUsername, userPassword, proxy string, timeout int64, namespace, name string, revision int64, dryRun bool) (string, error) {
+	_, clientset, err := kube.NewClient(mobile.Platform).GetClient("", clusterServer, clusterCertificateAuthorityData, clusterInsecureSkipTLSVerify, userClientCertificateData, userClientKeyData, userToken, userUsername, userPassword, proxy, timeout)
+	if err != nil {
+		return "", err
+	}
+
+	result, err := shared.HelmRollback(clientset, clusterServer, namespace, name, revision, dryRun)
+	if !dryRun {
+		server.AuditMutation("helm-rollback", clusterServer, namespace+"/"+name, err)
+	}
+	return result, err
+}
+
+// HelmUninstall uninstalls a Helm release. When "keepHistory" is true the records of the release are kept and the
+// latest revision is marked as uninstalled. When "dryRun" is true nothing is changed. Uninstalls are recorded in the
+// mutation audit log of the server, when it is enabled. If an error occures during the process the error is returned.
+func HelmUninstall(clusterServer, clusterCertificateAuthorityData string, clusterInsecureSkipTLSVerify bool, userClientCertificateData, userClientKeyData, userToken, userUsername, userPassword, proxy string, timeout int64, namespace, name string, keepHistory, dryRun bool) (string, error) {
+	_, clientset, err := kube.NewClient(mobile.Platform).GetClient("", clusterServer, clusterCertificateAuthorityData, clusterInsecureSkipTLSVerify, userClientCertificateData, userClientKeyData, userToken, userUsername, userPassword, proxy, timeout)
+	if err != nil {
+		return "", err
+	}
+
+	result, err := shared.HelmUninstall(clientset, clusterServer, namespace, name, keepHistory, dryRun)
+	if !dryRun {
+		server.AuditMutation("helm-uninstall", clusterServer, namespace+"/"+name, err)
+	}
+	return result, err
+}
+
+// HelmDiffRevisions returns the diff between the rendered manifests of two revisions of a Helm release. If an error
+// occures during the process the error is returned.
+func HelmDiffRevisions(clusterServer, clusterCertificateAuthorityData string, clusterInsecureSkipTLSVerify bool, userClientCertificateData, userClientKeyData, userToken, userUsername, userPassword, proxy string, timeout int64, namespace, name string, fromRevision, toRevision int64) (string, error) {
+	_, clientset, err := kube.NewClient(mobile.Platform).GetClient("", clusterServer, clusterCertificateAuthorityData, clusterInsecureSkipTLSVerify, userClientCertificateData, userClientKeyData, userToken, userUsername, userPassword, proxy, timeout)
+	if err != nil {
+		return "", err
+	}
+
+	return shared.HelmDiffRevisions(clientset, namespace, name, fromRevision, toRevision)
+}
@@ -1,29 +1,205 @@
 package kubenav
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
 	"strings"
+	"time"
 
 	"github.com/kubenav/kubenav/pkg/kube"
+	"github.com/kubenav/kubenav/pkg/kube/credentials"
 	"github.com/kubenav/kubenav/pkg/kube/mobile"
 	"github.com/kubenav/kubenav/pkg/server"
+	"github.com/kubenav/kubenav/pkg/server/debugbundle"
+	"github.com/kubenav/kubenav/pkg/server/files"
+	"github.com/kubenav/kubenav/pkg/server/logs"
+	"github.com/kubenav/kubenav/pkg/server/requestcache"
+	"github.com/kubenav/kubenav/pkg/server/resources"
+	"github.com/kubenav/kubenav/pkg/server/snapshots"
+	"github.com/kubenav/kubenav/pkg/server/validation"
 	"github.com/kubenav/kubenav/pkg/shared"
 )
 
+// schemaCache caches the OpenAPI schemas of the clusters for the validation of manifests, so that the schemas are not
+// fetched for every validation.
+var schemaCache = validation.NewSchemaCache()
+
 // KubernetesRequest is used to execute a request against a Kubernetes API. The Kubernetes API server and it's ca are
 // specified via the "clusterServer" and "clusterCertificateAuthorityData" arguments. To skip the tls verification the
 // request can set the "clusterInsecureSkipTLSVerify" argument to true. To handle the authentication against the API
 // server the "user*" arguments can be used.
 // The "requestMethod", "requestURL" and "requestBody" arguments are then used for the actually request. E.g. to get all
-// Pods from the Kubernetes API the method "GET" and the URL "/api/v1/pods" can be used.
+// Pods from the Kubernetes API the method "GET" and the URL "/api/v1/pods" can be used. All other requests are
+// recorded in the mutation audit log of the server, when it is enabled.
 func KubernetesRequest(clusterServer, clusterCertificateAuthorityData string, clusterInsecureSkipTLSVerify bool, userClientCertificateData, userClientKeyData, userToken, userUsername, userPassword, proxy string, timeout int64, requestMethod, requestURL, requestBody string) (string, error) {
+	restConfig, clientset, err := kube.NewClient(mobile.Platform).GetClient("", clusterServer, clusterCertificateAuthorityData, clusterInsecureSkipTLSVerify, userClientCertificateData, userClientKeyData, userToken, userUsername, userPassword, proxy, timeout)
+	if err != nil {
+		return "", err
+	}
+
+	result, err := shared.KubernetesRequest(clientset, clusterServer, requestcache.CredentialsKey(restConfig), "", requestMethod, strings.TrimRight(clusterServer, "/")+requestURL, requestBody)
+	server.AuditMutation(requestMethod, clusterServer, requestURL, err)
+	return result, err
+}
+
+// KubernetesRequestWithCache is the same as KubernetesRequest, but GET requests can use the request cache via the
+// "cacheControl" argument. The supported values are "bypass", "prefer-cache" and "max-age=<seconds>".
+func KubernetesRequestWithCache(clusterServer, clusterCertificateAuthorityData string, clusterInsecureSkipTLSVerify bool, userClientCertificateData, userClientKeyData, userToken, userUsername, userPassword, proxy string, timeout int64, requestMethod, requestURL, requestBody, cacheControl string) (string, error) {
+	restConfig, clientset, err := kube.NewClient(mobile.Platform).GetClient("", clusterServer, clusterCertificateAuthorityData, clusterInsecureSkipTLSVerify, userClientCertificateData, userClientKeyData, userToken, userUsername, userPassword, proxy, timeout)
+	if err != nil {
+		return "", err
+	}
+
+	result, err := shared.KubernetesRequest(clientset, clusterServer, requestcache.CredentialsKey(restConfig), cacheControl, requestMethod, strings.TrimRight(clusterServer, "/")+requestURL, requestBody)
+	server.AuditMutation(requestMethod, clusterServer, requestURL, err)
+	return result, err
+}
+
+// KubernetesSetMaxResponseSize sets the maximum size in bytes of a response, which is read into memory by
+// KubernetesRequest and its variants. Larger responses return a "response too large" error, list requests are fetched
+// page by page instead. If the size is 0 the default of 64 MiB is used.
+func KubernetesSetMaxResponseSize(size int64) {
+	shared.SetMaxResponseSize(size)
+}
+
+// RequestProgress is implemented by the caller of KubernetesRequestToFile, to get the number of bytes which were
+// written to the file so far.
+type RequestProgress interface {
+	Progress(bytes int64)
+}
+
+// KubernetesRequestToFile is the same as KubernetesRequest, but the response body is streamed to the file specified
+// via the "path" argument (e.g. in the cache directory of the app), so that huge responses do not have to be kept in
+// memory. The "progress" argument is optional. The function returns the JSON encoded path, size and content type of
+// the response, errors of the Kubernetes API are returned like in KubernetesRequest.
+func KubernetesRequestToFile(clusterServer, clusterCertificateAuthorityData string, clusterInsecureSkipTLSVerify bool, userClientCertificateData, userClientKeyData, userToken, userUsername, userPassword, proxy string, timeout int64, requestMethod, requestURL, requestBody, path string, progress RequestProgress) (string, error) {
 	_, clientset, err := kube.NewClient(mobile.Platform).GetClient("", clusterServer, clusterCertificateAuthorityData, clusterInsecureSkipTLSVerify, userClientCertificateData, userClientKeyData, userToken, userUsername, userPassword, proxy, timeout)
 	if err != nil {
 		return "", err
 	}
 
-	requestURL = strings.TrimRight(clusterServer, "/") + requestURL
+	var progressFn func(int64)
+	if progress != nil {
+		progressFn = progress.Progress
+	}
+
+	response, err := shared.KubernetesRequestToFile(context.Background(), clientset, requestMethod, strings.TrimRight(clusterServer, "/")+requestURL, requestBody, path, progressFn)
+	server.AuditMutation(requestMethod, clusterServer, requestURL, err)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := json.Marshal(response)
+	if err != nil {
+		return "", err
+	}
+
+	return string(data), nil
+}
+
+// StoreClusterCredentials stores the credentials of a cluster in the memory of the Go layer, so that they must not be
+// passed to every call. Afterwards the cluster can be referenced via the "clusterID" in KubernetesRequestForCluster
+// and via the "X-CLUSTER-ID" header in requests to the server. The credentials are never written to disk and they are
+// removed, when the server is stopped.
+func StoreClusterCredentials(clusterID, clusterServer, clusterCertificateAuthorityData string, clusterInsecureSkipTLSVerify bool, userClientCertificateData, userClientKeyData, userToken, userUsername, userPassword, proxy string) error {
+	return credentials.Set(clusterID, credentials.Credentials{
+		ClusterServer:                   clusterServer,
+		ClusterCertificateAuthorityData: clusterCertificateAuthorityData,
+		ClusterInsecureSkipTLSVerify:    clusterInsecureSkipTLSVerify,
+		UserClientCertificateData:       userClientCertificateData,
+		UserClientKeyData:               userClientKeyData,
+		UserToken:                       userToken,
+		UserUsername:                    userUsername,
+		UserPassword:                    userPassword,
+		Proxy:                           proxy,
+	})
+}
+
+// RemoveClusterCredentials removes the stored credentials of the cluster with the given id.
+func RemoveClusterCredentials(clusterID string) {
+	credentials.Delete(clusterID)
+}
+
+// KubernetesRequestForCluster is the same as KubernetesRequestWithCache, but the credentials are looked up via the
+// cluster id, for which they were stored via StoreClusterCredentials.
+func KubernetesRequestForCluster(clusterID string, timeout int64, requestMethod, requestURL, requestBody, cacheControl string) (string, error) {
+	c, err := credentials.Get(clusterID)
+	if err != nil {
+		return "", err
+	}
+
+	return KubernetesRequestWithCache(c.ClusterServer, c.ClusterCertificateAuthorityData, c.ClusterInsecureSkipTLSVerify, c.UserClientCertificateData, c.UserClientKeyData, c.UserToken, c.UserUsername, c.UserPassword, c.Proxy, timeout, requestMethod, requestURL, requestBody, cacheControl)
+}
+
+// KubernetesFlushCache removes all cached responses of the KubernetesRequestWithCache function.
+func KubernetesFlushCache() {
+	shared.KubernetesFlushCache()
+}
+
+// KubernetesEnableOfflineCache enables the offline cache, which persists the responses of all successful GET requests
+// on disk. The "options" argument must be a JSON encoded "requestcache.OfflineOptions" object, which contains the
+// directory of the cache (e.g. in the cache directory of the app), its maximum size and if Secrets should be persisted.
+func KubernetesEnableOfflineCache(options string) error {
+	var offlineOptions requestcache.OfflineOptions
+	if err := json.Unmarshal([]byte(options), &offlineOptions); err != nil {
+		return err
+	}
+
+	return requestcache.Offline.Enable(offlineOptions)
+}
+
+// KubernetesDisableOfflineCache disables the offline cache. The persisted responses are kept on disk, until they are
+// removed via KubernetesPurgeOfflineCache.
+func KubernetesDisableOfflineCache() {
+	requestcache.Offline.Disable()
+}
+
+// KubernetesRequestWithOfflineFallback executes a GET request like KubernetesRequestWithCache. When the cluster isn't
+// reachable, the last persisted response from the offline cache is returned, if it isn't older than the
+// "staleToleranceSeconds" argument (0 accepts any age). The function returns the JSON encoded
+// "shared.OfflineResponse", which contains the response body and if it is stale together with its age.
+func KubernetesRequestWithOfflineFallback(clusterServer, clusterCertificateAuthorityData string, clusterInsecureSkipTLSVerify bool, userClientCertificateData, userClientKeyData, userToken, userUsername, userPassword, proxy string, timeout int64, requestURL, cacheControl string, staleToleranceSeconds int64) (string, error) {
+	restConfig, clientset, err := kube.NewClient(mobile.Platform).GetClient("", clusterServer, clusterCertificateAuthorityData, clusterInsecureSkipTLSVerify, userClientCertificateData, userClientKeyData, userToken, userUsername, userPassword, proxy, timeout)
+	if err != nil {
+		return "", err
+	}
+
+	response, err := shared.KubernetesRequestWithOfflineFallback(context.Background(), clientset, clusterServer, requestcache.CredentialsKey(restConfig), cacheControl, strings.TrimRight(clusterServer, "/")+requestURL, time.Duration(staleToleranceSeconds)*time.Second)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := json.Marshal(response)
+	if err != nil {
+		return "", err
+	}
+
+	return string(data), nil
+}
+
+// KubernetesPurgeOfflineCache removes all persisted responses of the cluster with the given server from the offline
+// cache. If the "clusterServer" argument is empty, the responses of all clusters are removed.
+func KubernetesPurgeOfflineCache(clusterServer string) error {
+	return requestcache.Offline.Purge(clusterServer)
+}
+
+// KubernetesOfflineCacheReport returns the JSON encoded "requestcache.OfflineReport", which contains the number of
+// persisted responses and their size in total and per cluster, so that it can be shown in the settings of the app.
+func KubernetesOfflineCacheReport() (string, error) {
+	report, err := requestcache.Offline.Report()
+	if err != nil {
+		return "", err
+	}
+
+	data, err := json.Marshal(report)
+	if err != nil {
+		return "", err
+	}
 
-	return shared.KubernetesRequest(clientset, requestMethod, requestURL, requestBody)
+	return string(data), nil
 }
 
 // KubernetesGetLogs returns the logs for a list of pods. The names of the Pods are provided via the "names" parameter,
@@ -38,9 +214,393 @@ func KubernetesGetLogs(clusterServer, clusterCertificateAuthorityData string, cl
 	return shared.KubernetesGetLogs(clientset, strings.TrimRight(clusterServer, "/"), names, namespace, container, since, filter, previous)
 }
 
+// KubernetesGetJobLogs returns the logs of all Pods of the Job with the given name and namespace, ordered by their
+// completion time. The "tailLines" argument limits the number of lines for each container, if it is 0 the default of
+// 500 lines is used. If "onlyFailed" is true, only the logs of the failed Pods are returned.
+func KubernetesGetJobLogs(clusterServer, clusterCertificateAuthorityData string, clusterInsecureSkipTLSVerify bool, userClientCertificateData, userClientKeyData, userToken, userUsername, userPassword, proxy string, timeout int64, namespace, name, container string, tailLines int64, onlyFailed bool) (string, error) {
+	_, clientset, err := kube.NewClient(mobile.Platform).GetClient("", clusterServer, clusterCertificateAuthorityData, clusterInsecureSkipTLSVerify, userClientCertificateData, userClientKeyData, userToken, userUsername, userPassword, proxy, timeout)
+	if err != nil {
+		return "", err
+	}
+
+	return shared.KubernetesGetJobLogs(clientset, strings.TrimRight(clusterServer, "/"), namespace, name, container, tailLines, onlyFailed)
+}
+
+// LogsDownloadProgress is implemented by the caller of KubernetesDownloadLogs, to get the number of bytes which were
+// downloaded so far.
+type LogsDownloadProgress interface {
+	Progress(bytes int64)
+}
+
+// KubernetesDownloadLogs downloads the complete logs of a container and writes them gzip compressed to the file
+// specified via the "path" argument, so that the file can be shared via the sharing sheet of the device. If the
+// "previous" argument is true, the logs of the previous container are also included. The "progress" argument is
+// optional and can be used to show the progress of the download. The function returns the number of downloaded bytes.
+func KubernetesDownloadLogs(clusterServer, clusterCertificateAuthorityData string, clusterInsecureSkipTLSVerify bool, userClientCertificateData, userClientKeyData, userToken, userUsername, userPassword, proxy string, name, namespace, container string, previous bool, path string, progress LogsDownloadProgress) (int64, error) {
+	_, clientset, err := kube.NewClient(mobile.Platform).GetClient("", clusterServer, clusterCertificateAuthorityData, clusterInsecureSkipTLSVerify, userClientCertificateData, userClientKeyData, userToken, userUsername, userPassword, proxy, 0)
+	if err != nil {
+		return 0, err
+	}
+
+	download, err := logs.NewDownload(context.Background(), clientset, logs.Options{
+		Namespace: namespace,
+		Name:      name,
+		Container: container,
+		Previous:  previous,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		download.Close()
+		return 0, err
+	}
+	defer file.Close()
+
+	var bytes int64
+	err = download.WriteTo(file, func(b int64) {
+		bytes = b
+		if progress != nil {
+			progress.Progress(b)
+		}
+	})
+	if err != nil {
+		return bytes, err
+	}
+
+	return bytes, file.Close()
+}
+
+// SnapshotExportProgress is implemented by the caller of KubernetesExportNamespace, to get the number of exported
+// resources and objects so far.
+type SnapshotExportProgress interface {
+	Progress(resource string, done, total, objects int64)
+}
+
+// KubernetesExportNamespace exports all objects of a namespace as gzip compressed tar archive to the file specified via
+// the "path" argument, so that the archive can be shared via the sharing sheet of the device. The "options" argument
+// is a JSON encoded snapshots.ExportOptions object. The "progress" argument is optional. The function returns the JSON
+// encoded index of the archive, which also contains the resources which could not be exported.
+func KubernetesExportNamespace(clusterServer, clusterCertificateAuthorityData string, clusterInsecureSkipTLSVerify bool, userClientCertificateData, userClientKeyData, userToken, userUsername, userPassword, proxy string, options, path string, progress SnapshotExportProgress) (string, error) {
+	_, clientset, err := kube.NewClient(mobile.Platform).GetClient("", clusterServer, clusterCertificateAuthorityData, clusterInsecureSkipTLSVerify, userClientCertificateData, userClientKeyData, userToken, userUsername, userPassword, proxy, 0)
+	if err != nil {
+		return "", err
+	}
+
+	var exportOptions snapshots.ExportOptions
+	if err := json.Unmarshal([]byte(options), &exportOptions); err != nil {
+		return "", err
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	index, err := snapshots.ExportNamespace(context.Background(), resources.NewDiscoveryCache(), clusterServer, clientset, exportOptions, file, func(p snapshots.ExportProgress) {
+		if progress != nil {
+			progress.Progress(p.Resource, int64(p.Done), int64(p.Total), int64(p.Objects))
+		}
+	})
+	if err != nil {
+		file.Close()
+		os.Remove(path)
+		return "", err
+	}
+
+	if err := file.Close(); err != nil {
+		return "", err
+	}
+
+	data, err := json.Marshal(index)
+	if err != nil {
+		return "", err
+	}
+
+	return string(data), nil
+}
+
+// DebugBundleProgress is implemented by the caller of KubernetesDebugBundle, to get the number of collected items so
+// far.
+type DebugBundleProgress interface {
+	Progress(item string, done, total int64)
+}
+
+// KubernetesDebugBundle writes a gzip compressed tar archive with all information to debug a workload to the file
+// specified via the "path" argument, so that it can be shared via the sharing sheet of the device. The "options"
+// argument is a JSON encoded debugbundle.Options object. The "progress" argument is optional. The function returns the
+// JSON encoded summary of the bundle, which also contains the items which could not be collected.
+func KubernetesDebugBundle(clusterServer, clusterCertificateAuthorityData string, clusterInsecureSkipTLSVerify bool, userClientCertificateData, userClientKeyData, userToken, userUsername, userPassword, proxy string, options, path string, progress DebugBundleProgress) (string, error) {
+	_, clientset, err := kube.NewClient(mobile.Platform).GetClient("", clusterServer, clusterCertificateAuthorityData, clusterInsecureSkipTLSVerify, userClientCertificateData, userClientKeyData, userToken, userUsername, userPassword, proxy, 0)
+	if err != nil {
+		return "", err
+	}
+
+	var bundleOptions debugbundle.Options
+	if err := json.Unmarshal([]byte(options), &bundleOptions); err != nil {
+		return "", err
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	summary, err := debugbundle.Create(context.Background(), clientset, bundleOptions, file, func(p debugbundle.Progress) {
+		if progress != nil {
+			progress.Progress(p.Item, int64(p.Done), int64(p.Total))
+		}
+	})
+	if err != nil {
+		file.Close()
+		os.Remove(path)
+		return "", err
+	}
+
+	if err := file.Close(); err != nil {
+		return "", err
+	}
+
+	data, err := json.Marshal(summary)
+	if err != nil {
+		return "", err
+	}
+
+	return string(data), nil
+}
+
+// KubernetesRestoreSnapshot restores the objects of the gzip compressed tar archive specified via the "path" argument,
+// e.g. an archive which was created via KubernetesExportNamespace. The "options" argument is a JSON encoded
+// snapshots.RestoreOptions object, the archive is always read from the file. The function returns the JSON encoded
+// result with the action for each object. Each object which was created or changed is recorded in the mutation audit
+// log of the server.
+func KubernetesRestoreSnapshot(clusterServer, clusterCertificateAuthorityData string, clusterInsecureSkipTLSVerify bool, userClientCertificateData, userClientKeyData, userToken, userUsername, userPassword, proxy string, options, path string) (string, error) {
+	_, clientset, err := kube.NewClient(mobile.Platform).GetClient("", clusterServer, clusterCertificateAuthorityData, clusterInsecureSkipTLSVerify, userClientCertificateData, userClientKeyData, userToken, userUsername, userPassword, proxy, 0)
+	if err != nil {
+		return "", err
+	}
+
+	var restoreOptions snapshots.RestoreOptions
+	if err := json.Unmarshal([]byte(options), &restoreOptions); err != nil {
+		return "", err
+	}
+
+	restoreOptions.Archive, err = os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	result, err := snapshots.RestoreArchive(context.Background(), resources.NewDiscoveryCache(), schemaCache, clusterServer, clientset, restoreOptions)
+	if err != nil {
+		return "", err
+	}
+
+	if !restoreOptions.DryRun {
+		for _, object := range result.Objects {
+			if object.Action == snapshots.ActionUnchanged || object.Action == snapshots.ActionSkipped || object.Action == snapshots.ActionInvalid {
+				continue
+			}
+
+			var objectErr error
+			if object.Action == snapshots.ActionFailed {
+				objectErr = errors.New(object.Error)
+			}
+			server.AuditMutation("restore", clusterServer, strings.ToLower(object.Kind)+"/"+object.Namespace+"/"+object.Name, objectErr)
+		}
+	}
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		return "", err
+	}
+
+	return string(data), nil
+}
+
+// KubernetesValidateManifest validates a manifest against the OpenAPI schema of the cluster, without sending it to the
+// Kubernetes API. The function returns the JSON encoded validation.Result with the errors of each document.
+func KubernetesValidateManifest(clusterServer, clusterCertificateAuthorityData string, clusterInsecureSkipTLSVerify bool, userClientCertificateData, userClientKeyData, userToken, userUsername, userPassword, proxy string, manifest string) (string, error) {
+	_, clientset, err := kube.NewClient(mobile.Platform).GetClient("", clusterServer, clusterCertificateAuthorityData, clusterInsecureSkipTLSVerify, userClientCertificateData, userClientKeyData, userToken, userUsername, userPassword, proxy, 0)
+	if err != nil {
+		return "", err
+	}
+
+	result, err := validation.ValidateManifest(context.Background(), schemaCache, clusterServer, clientset, manifest)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		return "", err
+	}
+
+	return string(data), nil
+}
+
+// FileDownloadProgress is implemented by the caller of DownloadFileFromContainer, to get the number of bytes which
+// were downloaded so far.
+type FileDownloadProgress interface {
+	Progress(bytes int64)
+}
+
+// DownloadFileFromContainer downloads the file with the given "remotePath" from a container and writes it to the file
+// specified via the "localPath" argument, so that large files do not have to be kept in the memory of the app. When
+// the download fails the partial file is removed, unless "resume" is true, then an existing file is continued. The
+// "progress" argument is optional. The function returns the JSON encoded size and SHA256 checksum of the file.
+func DownloadFileFromContainer(clusterServer, clusterCertificateAuthorityData string, clusterInsecureSkipTLSVerify bool, userClientCertificateData, userClientKeyData, userToken, userUsername, userPassword, proxy string, namespace, name, container, remotePath, localPath string, resume bool, progress FileDownloadProgress) (string, error) {
+	restConfig, _, err := kube.NewClient(mobile.Platform).GetClient("", clusterServer, clusterCertificateAuthorityData, clusterInsecureSkipTLSVerify, userClientCertificateData, userClientKeyData, userToken, userUsername, userPassword, proxy, 0)
+	if err != nil {
+		return "", err
+	}
+
+	cleanedPath, err := files.CleanPath(remotePath)
+	if err != nil {
+		return "", err
+	}
+
+	var progressFn func(int64)
+	if progress != nil {
+		progressFn = progress.Progress
+	}
+
+	download, err := files.DownloadFile(context.Background(), restConfig, files.Options{
+		Namespace: namespace,
+		Name:      name,
+		Container: container,
+		Path:      cleanedPath,
+	}, localPath, resume, progressFn)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := json.Marshal(download)
+	if err != nil {
+		return "", err
+	}
+
+	return string(data), nil
+}
+
+// FileUploadProgress is implemented by the caller of UploadFileToContainer, to get the number of bytes which were
+// uploaded so far.
+type FileUploadProgress interface {
+	Progress(bytes int64)
+}
+
+// UploadFileToContainer uploads the file from the "localPath" to the "remotePath" in a container. The file is streamed,
+// so that it hasn't to be loaded into the memory of the app. If "mode" is 0 the mode of the local file is used. With
+// "createDirs" missing directories are created and an existing file is only replaced when "overwrite" is true. The
+// function returns the JSON encoded number of sent bytes, the exit code and the error message of the command.
+func UploadFileToContainer(clusterServer, clusterCertificateAuthorityData string, clusterInsecureSkipTLSVerify bool, userClientCertificateData, userClientKeyData, userToken, userUsername, userPassword, proxy string, namespace, name, container, localPath, remotePath string, mode int64, createDirs, overwrite bool, progress FileUploadProgress) (string, error) {
+	restConfig, _, err := kube.NewClient(mobile.Platform).GetClient("", clusterServer, clusterCertificateAuthorityData, clusterInsecureSkipTLSVerify, userClientCertificateData, userClientKeyData, userToken, userUsername, userPassword, proxy, 0)
+	if err != nil {
+		return "", err
+	}
+
+	cleanedPath, err := files.CleanPath(remotePath)
+	if err != nil {
+		return "", err
+	}
+
+	var progressFn func(int64)
+	if progress != nil {
+		progressFn = progress.Progress
+	}
+
+	upload, err := files.UploadFile(context.Background(), restConfig, files.Options{
+		Namespace: namespace,
+		Name:      name,
+		Container: container,
+		Path:      cleanedPath,
+	}, localPath, files.UploadOptions{
+		Mode:       mode,
+		CreateDirs: createDirs,
+		Overwrite:  overwrite,
+	}, progressFn)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := json.Marshal(upload)
+	if err != nil {
+		return "", err
+	}
+
+	return string(data), nil
+}
+
 // KubernetesStartServer starts an Go server which listens on "14122". The server is responsible for providing the
-// port forwarding and Pod exec feature for kubenav.
+// port forwarding and Pod exec feature for kubenav. Because the auth token can not be returned to the app, the auth
+// token is disabled for this function, KubernetesStartServerWithOptions should be used instead.
 func KubernetesStartServer() {
 	kubeClient := kube.NewClient(mobile.Platform)
-	server.Start(kubeClient)
+	if _, err := server.Start(kubeClient, server.Options{DisableAuth: true}); err != nil {
+		log.Printf("Could not start server: %s", err.Error())
+	}
+}
+
+// KubernetesStartServerWithOptions starts the Go server with the given options, which must be a JSON encoded
+// "server.Options" object. The function returns the JSON encoded "server.Info" as soon as the server was started, so
+// that the app can also use a port chosen by the operating system (port 0), pin the certificate, when TLS is used, and
+// send the auth token with all requests. The info also contains the results of the self-check, so that the app can
+// disable features which will not work on the device. If the server is already running, the info of the running
+// server is returned.
+func KubernetesStartServerWithOptions(options string) (string, error) {
+	var serverOptions server.Options
+	if options != "" {
+		if err := json.Unmarshal([]byte(options), &serverOptions); err != nil {
+			return "", err
+		}
+	}
+
+	kubeClient := kube.NewClient(mobile.Platform)
+	info, err := server.Start(kubeClient, serverOptions)
+	if err != nil {
+		return "", err
+	}
+
+	jsonData, err := json.Marshal(info)
+	if err != nil {
+		return "", err
+	}
+
+	return string(jsonData), nil
+}
+
+// KubernetesStopServer stops the Go server gracefully. All sessions are closed and the function returns, when the
+// server was stopped.
+func KubernetesStopServer() error {
+	return server.Stop()
+}
+
+// KubernetesRestartServer restarts the Go server with the options from the last start. The server uses the same port
+// and auth token as before and the function returns the JSON encoded "server.Info" of the new server.
+func KubernetesRestartServer() (string, error) {
+	info, err := server.Restart()
+	if err != nil {
+		return "", err
+	}
+
+	jsonData, err := json.Marshal(info)
+	if err != nil {
+		return "", err
+	}
+
+	return string(jsonData), nil
+}
+
+// KubernetesServerStatus returns the JSON encoded "server.Status", which contains if the server is running, the port,
+// the uptime and the number of active sessions.
+func KubernetesServerStatus() (string, error) {
+	jsonData, err := json.Marshal(server.GetStatus())
+	if err != nil {
+		return "", err
+	}
+
+	return string(jsonData), nil
 }
@@ -0,0 +1,248 @@
+package kubenav
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kubenav/kubenav/pkg/kube"
+	"github.com/kubenav/kubenav/pkg/kube/credentials"
+	"github.com/kubenav/kubenav/pkg/kube/mobile"
+	"github.com/kubenav/kubenav/pkg/server/requestcache"
+	"github.com/kubenav/kubenav/pkg/shared"
+)
+
+// ClusterAnnotation is the annotation, which is added to each item of an aggregated multi cluster request, with the
+// name of the cluster the item was returned from.
+const ClusterAnnotation = "kubenav.io/cluster"
+
+// multiClusterConcurrency is the maximum number of clusters, which are requested concurrently by MultiClusterRequest.
+const multiClusterConcurrency = 5
+
+// defaultMultiClusterTimeout is the timeout for the request to a single cluster, when no timeout is passed to
+// MultiClusterRequest.
+const defaultMultiClusterTimeout = 30 * time.Second
+
+// The status of the request to a single cluster.
+const (
+	multiClusterStatusSuccess = "success"
+	multiClusterStatusError   = "error"
+)
+
+// multiClusterTarget is a single cluster of a MultiClusterRequest. The cluster is either referenced via the id of its
+// stored credentials (see StoreClusterCredentials) or via its credentials. The name is the key of the cluster in the
+// result, if it is empty the cluster id or server is used.
+type multiClusterTarget struct {
+	Name                            string `json:"name"`
+	ClusterID                       string `json:"clusterID"`
+	ClusterServer                   string `json:"clusterServer"`
+	ClusterCertificateAuthorityData string `json:"clusterCertificateAuthorityData"`
+	ClusterInsecureSkipTLSVerify    bool   `json:"clusterInsecureSkipTLSVerify"`
+	UserClientCertificateData       string `json:"userClientCertificateData"`
+	UserClientKeyData               string `json:"userClientKeyData"`
+	UserToken                       string `json:"userToken"`
+	UserUsername                    string `json:"userUsername"`
+	UserPassword                    string `json:"userPassword"`
+	Proxy                           string `json:"proxy"`
+}
+
+// multiClusterResult is the result of the request to a single cluster. The code is the status code of the Kubernetes
+// API or 0 for all other errors, like for the RequestCallback.
+type multiClusterResult struct {
+	Status string          `json:"status"`
+	Body   json.RawMessage `json:"body,omitempty"`
+	Error  string          `json:"error,omitempty"`
+	Code   int64           `json:"code,omitempty"`
+}
+
+// multiClusterResponse is the response of MultiClusterRequest. "Items" is only set in the aggregate mode.
+type multiClusterResponse struct {
+	Clusters  map[string]multiClusterResult `json:"clusters"`
+	Items     []json.RawMessage             `json:"items,omitempty"`
+	Succeeded int                           `json:"succeeded"`
+	Failed    int                           `json:"failed"`
+}
+
+// MultiClusterRequest executes a GET request for the "requestURL" (e.g. "/apis/apps/v1/namespaces/default/deployments")
+// against multiple clusters concurrently. The "clusters" argument must be a JSON array of clusters, where each cluster
+// contains a "name" and either the "clusterID" of stored credentials or the credentials of the cluster with the same
+// names as for KubernetesRequest. The "timeout" (in seconds) is applied to the request of each cluster.
+//
+// The function returns the JSON encoded result of each cluster by its name. The request to a cluster can fail without
+// failing the other requests, so that an error is only returned, when the "clusters" argument is invalid. In the
+// aggregate mode the items of all returned lists (or the returned objects, when the url is not a list) are merged into
+// a single "items" list, where each item has the ClusterAnnotation with the name of its cluster.
+func MultiClusterRequest(clusters string, timeout int64, requestURL string, aggregate bool) (string, error) {
+	var targets []multiClusterTarget
+	if err := json.Unmarshal([]byte(clusters), &targets); err != nil {
+		return "", fmt.Errorf("invalid clusters: %w", err)
+	}
+	if len(targets) == 0 {
+		return "", fmt.Errorf("at least one cluster is required")
+	}
+
+	names := make(map[string]bool, len(targets))
+	for i := range targets {
+		if targets[i].Name == "" {
+			targets[i].Name = targets[i].ClusterID
+		}
+		if targets[i].Name == "" {
+			targets[i].Name = targets[i].ClusterServer
+		}
+		if targets[i].Name == "" {
+			return "", fmt.Errorf("cluster %d requires a name, cluster id or cluster server", i)
+		}
+		if names[targets[i].Name] {
+			return "", fmt.Errorf("duplicate cluster %s", targets[i].Name)
+		}
+		names[targets[i].Name] = true
+	}
+
+	clusterTimeout := defaultMultiClusterTimeout
+	if timeout > 0 {
+		clusterTimeout = time.Duration(timeout) * time.Second
+	}
+
+	response := multiClusterResponse{Clusters: make(map[string]multiClusterResult, len(targets))}
+
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	semaphore := make(chan struct{}, multiClusterConcurrency)
+
+	for _, target := range targets {
+		wg.Add(1)
+		go func(target multiClusterTarget) {
+			defer wg.Done()
+
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+
+			ctx, cancel := context.WithTimeout(context.Background(), clusterTimeout)
+			defer cancel()
+
+			result := multiClusterResult{Status: multiClusterStatusSuccess}
+			body, err := multiClusterGet(ctx, target, requestURL)
+			if err != nil {
+				result = multiClusterResult{Status: multiClusterStatusError, Error: err.Error(), Code: errorCode(err)}
+			} else if !json.Valid([]byte(body)) {
+				result = multiClusterResult{Status: multiClusterStatusError, Error: "response is not valid JSON"}
+			} else {
+				result.Body = json.RawMessage(body)
+			}
+
+			lock.Lock()
+			response.Clusters[target.Name] = result
+			lock.Unlock()
+		}(target)
+	}
+
+	wg.Wait()
+
+	for _, target := range targets {
+		result := response.Clusters[target.Name]
+		if result.Status != multiClusterStatusSuccess {
+			response.Failed++
+			continue
+		}
+		response.Succeeded++
+
+		if aggregate {
+			items, err := annotateItems(result.Body, target.Name)
+			if err != nil {
+				response.Clusters[target.Name] = multiClusterResult{Status: multiClusterStatusError, Error: err.Error()}
+				response.Succeeded--
+				response.Failed++
+				continue
+			}
+			response.Items = append(response.Items, items...)
+		}
+	}
+
+	if aggregate && response.Items == nil {
+		response.Items = []json.RawMessage{}
+	}
+
+	data, err := json.Marshal(response)
+	if err != nil {
+		return "", err
+	}
+
+	return string(data), nil
+}
+
+// multiClusterGet executes the GET request against a single cluster. The credentials are looked up via the cluster id,
+// when it is set.
+func multiClusterGet(ctx context.Context, target multiClusterTarget, requestURL string) (string, error) {
+	c := credentials.Credentials{
+		ClusterServer:                   target.ClusterServer,
+		ClusterCertificateAuthorityData: target.ClusterCertificateAuthorityData,
+		ClusterInsecureSkipTLSVerify:    target.ClusterInsecureSkipTLSVerify,
+		UserClientCertificateData:       target.UserClientCertificateData,
+		UserClientKeyData:               target.UserClientKeyData,
+		UserToken:                       target.UserToken,
+		UserUsername:                    target.UserUsername,
+		UserPassword:                    target.UserPassword,
+		Proxy:                           target.Proxy,
+	}
+	if target.ClusterID != "" {
+		var err error
+		if c, err = credentials.Get(target.ClusterID); err != nil {
+			return "", err
+		}
+	}
+
+	restConfig, clientset, err := kube.NewClient(mobile.Platform).GetClient(c.ContextName, c.ClusterServer, c.ClusterCertificateAuthorityData, c.ClusterInsecureSkipTLSVerify, c.UserClientCertificateData, c.UserClientKeyData, c.UserToken, c.UserUsername, c.UserPassword, c.Proxy, 0)
+	if err != nil {
+		return "", err
+	}
+
+	return shared.KubernetesRequestWithContext(ctx, clientset, c.Cluster(), requestcache.CredentialsKey(restConfig), "", http.MethodGet, strings.TrimRight(c.ClusterServer, "/")+requestURL, "")
+}
+
+// annotateItems returns the items of a list or the object itself, when the body isn't a list. The ClusterAnnotation
+// is added to each item.
+func annotateItems(body json.RawMessage, cluster string) ([]json.RawMessage, error) {
+	var object map[string]interface{}
+	if err := json.Unmarshal(body, &object); err != nil {
+		return nil, fmt.Errorf("response is not an object: %w", err)
+	}
+
+	var items []interface{}
+	if list, ok := object["items"].([]interface{}); ok {
+		items = list
+	} else if _, ok := object["items"]; !ok {
+		items = []interface{}{object}
+	}
+
+	annotated := make([]json.RawMessage, 0, len(items))
+	for _, item := range items {
+		itemObject, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		metadata, ok := itemObject["metadata"].(map[string]interface{})
+		if !ok {
+			metadata = make(map[string]interface{})
+			itemObject["metadata"] = metadata
+		}
+		annotations, ok := metadata["annotations"].(map[string]interface{})
+		if !ok {
+			annotations = make(map[string]interface{})
+			metadata["annotations"] = annotations
+		}
+		annotations[ClusterAnnotation] = cluster
+
+		data, err := json.Marshal(itemObject)
+		if err != nil {
+			return nil, err
+		}
+		annotated = append(annotated, data)
+	}
+
+	return annotated, nil
+}
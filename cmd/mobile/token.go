@@ -0,0 +1,55 @@
+package kubenav
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/kubenav/kubenav/pkg/kube/token"
+)
+
+// Token is the structure of a token, which is returned by a TokenProvider. The "ExpiresAt" field is the expiration
+// time as unix timestamp in seconds, if it is 0 the token is used until it is rejected by the API server.
+type Token struct {
+	Token     string
+	ExpiresAt int64
+}
+
+// TokenProvider is implemented by the app, to provide refreshable tokens for a cluster. The "cluster" argument is the
+// server of the cluster, for which the provider was registered.
+type TokenProvider interface {
+	GetToken(cluster string) (*Token, error)
+}
+
+// RegisterTokenProvider registers the provider for the cluster with the given server. When a provider is registered,
+// the token of the provider is used instead of the static "userToken" for all requests against the cluster. The token
+// is cached until it expires.
+func RegisterTokenProvider(clusterServer string, provider TokenProvider) {
+	token.Register(clusterServer, tokenProvider{provider: provider})
+}
+
+// UnregisterTokenProvider removes the provider for the cluster with the given server.
+func UnregisterTokenProvider(clusterServer string) {
+	token.Unregister(clusterServer)
+}
+
+// tokenProvider adapts a TokenProvider of the app to the token.Provider interface.
+type tokenProvider struct {
+	provider TokenProvider
+}
+
+func (p tokenProvider) GetToken(cluster string) (string, time.Time, error) {
+	t, err := p.provider.GetToken(cluster)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	if t == nil {
+		return "", time.Time{}, fmt.Errorf("token provider returned no token")
+	}
+
+	var expiresAt time.Time
+	if t.ExpiresAt > 0 {
+		expiresAt = time.Unix(t.ExpiresAt, 0)
+	}
+
+	return t.Token, expiresAt, nil
+}
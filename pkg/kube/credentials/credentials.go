@@ -0,0 +1,96 @@
+// Package credentials implements an in-memory store for the credentials of clusters. The app stores the credentials of
+// a cluster once and afterwards only passes the id of the cluster, so that the credentials are not send with every
+// call. The credentials are only written to disk, when the store is persisted encrypted via Save.
+package credentials
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrNotFound is returned when no credentials are stored for a cluster id.
+var ErrNotFound = errors.New("credentials not found")
+
+// Credentials are the fields, which are required to create a Kubernetes client via "kube.Client.GetClient". The struct
+// doesn't implement the json.Marshaler interface with the real values, so that credentials can not be serialized by
+// accident.
+type Credentials struct {
+	ContextName                     string
+	ClusterServer                   string
+	ClusterCertificateAuthorityData string
+	ClusterInsecureSkipTLSVerify    bool
+	UserClientCertificateData       string
+	UserClientKeyData               string
+	UserToken                       string
+	UserUsername                    string
+	UserPassword                    string
+	Proxy                           string
+}
+
+// String returns a redacted representation of the credentials, so that they are not printed in logs.
+func (c Credentials) String() string {
+	return fmt.Sprintf("Credentials{ContextName: %q, ClusterServer: %q}", c.ContextName, c.ClusterServer)
+}
+
+// GoString is the same as String for the "%#v" verb.
+func (c Credentials) GoString() string {
+	return c.String()
+}
+
+// MarshalJSON always returns an error, because credentials must only be persisted encrypted via Save.
+func (c Credentials) MarshalJSON() ([]byte, error) {
+	return nil, fmt.Errorf("credentials can not be serialized")
+}
+
+// Cluster returns the identifier of the cluster, which is used for the caches of the server. This is the cluster
+// server or the context name on desktop, the same as for requests with explicit credentials.
+func (c Credentials) Cluster() string {
+	if c.ClusterServer != "" {
+		return c.ClusterServer
+	}
+	return c.ContextName
+}
+
+var (
+	store     = make(map[string]Credentials)
+	storeLock sync.RWMutex
+)
+
+// Set stores the credentials for the given cluster id. Already stored credentials for the id are replaced.
+func Set(clusterID string, credentials Credentials) error {
+	if clusterID == "" {
+		return fmt.Errorf("cluster id is required")
+	}
+
+	storeLock.Lock()
+	defer storeLock.Unlock()
+	store[clusterID] = credentials
+	return nil
+}
+
+// Get returns the stored credentials for the given cluster id. If no credentials are stored, ErrNotFound is returned.
+func Get(clusterID string) (Credentials, error) {
+	storeLock.RLock()
+	defer storeLock.RUnlock()
+
+	credentials, ok := store[clusterID]
+	if !ok {
+		return Credentials{}, fmt.Errorf("%w for cluster %s", ErrNotFound, clusterID)
+	}
+	return credentials, nil
+}
+
+// Delete removes the stored credentials for the given cluster id.
+func Delete(clusterID string) {
+	storeLock.Lock()
+	defer storeLock.Unlock()
+	delete(store, clusterID)
+}
+
+// Clear removes all stored credentials.
+func Clear() {
+	storeLock.Lock()
+	defer storeLock.Unlock()
+	store = make(map[string]Credentials)
+}
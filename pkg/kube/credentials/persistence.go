@@ -0,0 +1,180 @@
+package credentials
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+var (
+	// ErrInvalidKey is returned when the key for the encryption of the store isn't a 32 byte key.
+	ErrInvalidKey = errors.New("key must be 32 bytes")
+	// ErrAuthentication is returned when a persisted store can not be decrypted, because the key is wrong or the file
+	// was modified.
+	ErrAuthentication = errors.New("could not authenticate credential store")
+)
+
+// fileHeader is the header of a persisted store. It is followed by the nonce and the encrypted store and it is used as
+// additional data for AES-GCM, so that the header can not be changed without failing the authentication.
+var fileHeader = []byte("kubenav-credentials\x00\x01")
+
+// record is the serialized form of the credentials of a cluster. It is only used for the encrypted persistence of the
+// store, the Credentials type itself can not be serialized.
+type record struct {
+	ContextName                     string `json:"contextName"`
+	ClusterServer                   string `json:"clusterServer"`
+	ClusterCertificateAuthorityData string `json:"clusterCertificateAuthorityData"`
+	ClusterInsecureSkipTLSVerify    bool   `json:"clusterInsecureSkipTLSVerify"`
+	UserClientCertificateData       string `json:"userClientCertificateData"`
+	UserClientKeyData               string `json:"userClientKeyData"`
+	UserToken                       string `json:"userToken"`
+	UserUsername                    string `json:"userUsername"`
+	UserPassword                    string `json:"userPassword"`
+	Proxy                           string `json:"proxy"`
+}
+
+// Parse parses the JSON encoded credentials of a cluster, which are using the same field names as the custom headers
+// of the server, e.g. "clusterServer" or "userToken".
+func Parse(data []byte) (Credentials, error) {
+	var r record
+	if err := json.Unmarshal(data, &r); err != nil {
+		return Credentials{}, err
+	}
+
+	return Credentials(r), nil
+}
+
+// Save encrypts all stored credentials with AES-GCM using the given 32 byte key and writes them to the file at the
+// given path. The file is replaced atomically and the credentials are only written encrypted, also for the temporary
+// file.
+func Save(path string, key []byte) error {
+	storeLock.RLock()
+	records := make(map[string]record, len(store))
+	for clusterID, c := range store {
+		records[clusterID] = record(c)
+	}
+	storeLock.RUnlock()
+
+	data, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+
+	return writeEncrypted(path, key, data)
+}
+
+// Load decrypts the file at the given path with the given key and replaces all stored credentials with the
+// credentials from the file. If the key is wrong or the file was modified ErrAuthentication is returned and the
+// stored credentials are not changed.
+func Load(path string, key []byte) error {
+	data, err := readEncrypted(path, key)
+	if err != nil {
+		return err
+	}
+
+	var records map[string]record
+	if err := json.Unmarshal(data, &records); err != nil {
+		return fmt.Errorf("%w: %s", ErrAuthentication, err.Error())
+	}
+
+	loaded := make(map[string]Credentials, len(records))
+	for clusterID, r := range records {
+		loaded[clusterID] = Credentials(r)
+	}
+
+	storeLock.Lock()
+	defer storeLock.Unlock()
+	store = loaded
+	return nil
+}
+
+// RotateKey decrypts the file at the given path with the old key and encrypts it again with the new key. The stored
+// credentials in memory are not changed.
+func RotateKey(path string, oldKey, newKey []byte) error {
+	data, err := readEncrypted(path, oldKey)
+	if err != nil {
+		return err
+	}
+
+	return writeEncrypted(path, newKey, data)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	if len(key) != 32 {
+		return nil, ErrInvalidKey
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// writeEncrypted encrypts the data and writes it to a temporary file in the directory of the path, which is renamed to
+// the path afterwards, so that an interrupted save doesn't corrupt an existing file.
+func writeEncrypted(path string, key, data []byte) error {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return err
+	}
+
+	content := append(append([]byte{}, fileHeader...), nonce...)
+	content = gcm.Seal(content, nonce, data, fileHeader)
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+func readEncrypted(path string, key []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(content) < len(fileHeader)+gcm.NonceSize() || string(content[:len(fileHeader)]) != string(fileHeader) {
+		return nil, fmt.Errorf("%w: invalid file format", ErrAuthentication)
+	}
+
+	nonce := content[len(fileHeader) : len(fileHeader)+gcm.NonceSize()]
+	data, err := gcm.Open(nil, nonce, content[len(fileHeader)+gcm.NonceSize():], fileHeader)
+	if err != nil {
+		return nil, ErrAuthentication
+	}
+
+	return data, nil
+}
@@ -0,0 +1,212 @@
+package credentials
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var (
+	testKey      = bytes.Repeat([]byte{1}, 32)
+	testOtherKey = bytes.Repeat([]byte{2}, 32)
+
+	testCredentials = Credentials{
+		ClusterServer:                   "https://10.0.0.1:6443",
+		ClusterCertificateAuthorityData: "ca-data",
+		UserToken:                       "user-token",
+		UserPassword:                    "s3cret",
+	}
+)
+
+// saveTestStore stores the test credentials and saves them with the test key to a file in a temporary directory. The
+// store is cleared afterwards, so that the tests can check which credentials are loaded from the file.
+func saveTestStore(t *testing.T) string {
+	t.Helper()
+
+	Clear()
+	t.Cleanup(Clear)
+
+	if err := Set("cluster", testCredentials); err != nil {
+		t.Fatalf("could not set credentials: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "credentials")
+	if err := Save(path, testKey); err != nil {
+		t.Fatalf("could not save store: %v", err)
+	}
+
+	Clear()
+	return path
+}
+
+// checkNoTempFiles fails the test, when the directory of the path contains other files than the path itself.
+func checkNoTempFiles(t *testing.T, path string) {
+	t.Helper()
+
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatalf("could not read directory: %v", err)
+	}
+	for _, entry := range entries {
+		if entry.Name() != filepath.Base(path) {
+			t.Errorf("expected no temporary files, got %s", entry.Name())
+		}
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	path := saveTestStore(t)
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("could not read file: %v", err)
+	}
+	for _, value := range []string{testCredentials.ClusterServer, testCredentials.UserToken, testCredentials.UserPassword} {
+		if bytes.Contains(content, []byte(value)) {
+			t.Errorf("expected file to not contain %q in plain text", value)
+		}
+	}
+
+	if err := Load(path, testKey); err != nil {
+		t.Fatalf("could not load store: %v", err)
+	}
+
+	actual, err := Get("cluster")
+	if err != nil {
+		t.Fatalf("could not get credentials: %v", err)
+	}
+	if actual != testCredentials {
+		t.Errorf("expected loaded credentials to match the saved credentials")
+	}
+	checkNoTempFiles(t, path)
+}
+
+func TestLoadWrongKey(t *testing.T) {
+	path := saveTestStore(t)
+
+	if err := Set("other", testCredentials); err != nil {
+		t.Fatalf("could not set credentials: %v", err)
+	}
+
+	if err := Load(path, testOtherKey); !errors.Is(err, ErrAuthentication) {
+		t.Fatalf("expected authentication error, got %v", err)
+	}
+	if err := Load(path, testKey[:16]); !errors.Is(err, ErrInvalidKey) {
+		t.Fatalf("expected invalid key error, got %v", err)
+	}
+
+	// The stored credentials must not be changed, when the store can not be loaded.
+	if _, err := Get("other"); err != nil {
+		t.Errorf("expected stored credentials to be unchanged, got %v", err)
+	}
+}
+
+func TestLoadModifiedFile(t *testing.T) {
+	path := saveTestStore(t)
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("could not read file: %v", err)
+	}
+	nonceStart := len(fileHeader)
+	// The nonce of AES-GCM has 12 bytes.
+	ciphertextStart := nonceStart + 12
+
+	for _, tc := range []struct {
+		name   string
+		modify func(content []byte) []byte
+	}{
+		{name: "flipped header byte", modify: func(content []byte) []byte { content[0] ^= 1; return content }},
+		{name: "flipped header version", modify: func(content []byte) []byte { content[nonceStart-1] ^= 1; return content }},
+		{name: "flipped nonce byte", modify: func(content []byte) []byte { content[nonceStart] ^= 1; return content }},
+		{name: "flipped ciphertext byte", modify: func(content []byte) []byte { content[ciphertextStart] ^= 1; return content }},
+		{name: "flipped tag byte", modify: func(content []byte) []byte { content[len(content)-1] ^= 1; return content }},
+		{name: "truncated ciphertext", modify: func(content []byte) []byte { return content[:len(content)-1] }},
+		{name: "truncated nonce", modify: func(content []byte) []byte { return content[:ciphertextStart-1] }},
+		{name: "truncated header", modify: func(content []byte) []byte { return content[:nonceStart-1] }},
+		{name: "empty file", modify: func(content []byte) []byte { return nil }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			modifiedPath := filepath.Join(t.TempDir(), "credentials")
+			if err := os.WriteFile(modifiedPath, tc.modify(append([]byte{}, content...)), 0600); err != nil {
+				t.Fatalf("could not write file: %v", err)
+			}
+
+			if err := Load(modifiedPath, testKey); !errors.Is(err, ErrAuthentication) {
+				t.Errorf("expected authentication error, got %v", err)
+			}
+			if _, err := Get("cluster"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("expected no credentials to be loaded, got %v", err)
+			}
+		})
+	}
+}
+
+func TestRotateKey(t *testing.T) {
+	path := saveTestStore(t)
+
+	if err := RotateKey(path, testOtherKey, testKey); !errors.Is(err, ErrAuthentication) {
+		t.Fatalf("expected authentication error for the wrong old key, got %v", err)
+	}
+	if err := RotateKey(path, testKey, testOtherKey); err != nil {
+		t.Fatalf("could not rotate key: %v", err)
+	}
+
+	if err := Load(path, testKey); !errors.Is(err, ErrAuthentication) {
+		t.Errorf("expected authentication error for the old key, got %v", err)
+	}
+	if err := Load(path, testOtherKey); err != nil {
+		t.Fatalf("could not load store with the new key: %v", err)
+	}
+	if actual, err := Get("cluster"); err != nil || actual != testCredentials {
+		t.Errorf("expected loaded credentials to match the saved credentials, got %v", err)
+	}
+	checkNoTempFiles(t, path)
+}
+
+func TestSaveFailure(t *testing.T) {
+	path := saveTestStore(t)
+
+	original, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("could not read file: %v", err)
+	}
+
+	if err := Set("other", testCredentials); err != nil {
+		t.Fatalf("could not set credentials: %v", err)
+	}
+
+	// The save fails before the temporary file is created, when the key is invalid.
+	if err := Save(path, testKey[:16]); !errors.Is(err, ErrInvalidKey) {
+		t.Fatalf("expected invalid key error, got %v", err)
+	}
+	if err := RotateKey(path, testKey, testKey[:16]); !errors.Is(err, ErrInvalidKey) {
+		t.Fatalf("expected invalid key error, got %v", err)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("could not read file: %v", err)
+	}
+	if !bytes.Equal(content, original) {
+		t.Errorf("expected file to be unchanged after the failed save")
+	}
+	checkNoTempFiles(t, path)
+
+	// The save fails after the temporary file was written, when it can not be renamed, because the path is a non-empty
+	// directory. The content of the directory must be unchanged and the temporary file must be removed.
+	dirPath := filepath.Join(t.TempDir(), "credentials")
+	if err := os.MkdirAll(filepath.Join(dirPath, "original"), 0700); err != nil {
+		t.Fatalf("could not create directory: %v", err)
+	}
+
+	if err := Save(dirPath, testKey); err == nil {
+		t.Fatalf("expected save to fail")
+	}
+	if _, err := os.Stat(filepath.Join(dirPath, "original")); err != nil {
+		t.Errorf("expected directory to be unchanged after the failed save, got %v", err)
+	}
+	checkNoTempFiles(t, dirPath)
+}
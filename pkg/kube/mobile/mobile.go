@@ -6,6 +6,8 @@ import (
 	"net/url"
 	"time"
 
+	"github.com/kubenav/kubenav/pkg/kube/token"
+
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
@@ -74,6 +76,10 @@ users:
 		restClient.Transport = &http.Transport{Proxy: http.ProxyURL(proxyURL)}
 	}
 
+	// When the app registered a token provider for the cluster, the token of the provider is used instead of the
+	// static token.
+	token.WrapConfig(clusterServer, restClient)
+
 	clientset, err := kubernetes.NewForConfig(restClient)
 	if err != nil {
 		return nil, nil, err
@@ -0,0 +1,135 @@
+// Package token implements a registry for token providers, which can be used instead of a static token to authenticate
+// against a cluster. The token of a provider is cached until it expires, so that the provider is only called when a
+// new token is required.
+package token
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"k8s.io/client-go/rest"
+)
+
+// expirySkew is the duration before the expiration of a token, in which the token is already refreshed, so that a
+// token doesn't expire while a request is in flight.
+const expirySkew = 30 * time.Second
+
+// Provider returns a token for a cluster and the time when the token expires. If the returned time is zero, the token
+// is cached until the API server rejects it.
+type Provider interface {
+	GetToken(cluster string) (string, time.Time, error)
+}
+
+// entry is a registered provider and the cached token. The lock is held while the token is refreshed, so that
+// concurrent requests are waiting for the refreshed token instead of calling the provider multiple times.
+type entry struct {
+	provider  Provider
+	lock      sync.Mutex
+	token     string
+	expiresAt time.Time
+}
+
+var (
+	providers     = make(map[string]*entry)
+	providersLock sync.RWMutex
+)
+
+// Register registers the provider for the given cluster. An already registered provider for the cluster is replaced
+// and its cached token is discarded.
+func Register(cluster string, provider Provider) {
+	providersLock.Lock()
+	defer providersLock.Unlock()
+	providers[cluster] = &entry{provider: provider}
+}
+
+// Unregister removes the provider for the given cluster, so that the static credentials are used again.
+func Unregister(cluster string) {
+	providersLock.Lock()
+	defer providersLock.Unlock()
+	delete(providers, cluster)
+}
+
+// Invalidate discards the cached token for the given cluster, so that the provider is called for the next request.
+func Invalidate(cluster string) {
+	if e := get(cluster); e != nil {
+		e.lock.Lock()
+		e.token = ""
+		e.lock.Unlock()
+	}
+}
+
+// Token returns the token for the given cluster. If no provider is registered for the cluster, false is returned. The
+// cached token is returned until it expires, afterwards the provider is called to get a new token.
+func Token(cluster string) (string, bool, error) {
+	e := get(cluster)
+	if e == nil {
+		return "", false, nil
+	}
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	if e.token != "" && (e.expiresAt.IsZero() || time.Now().Add(expirySkew).Before(e.expiresAt)) {
+		return e.token, true, nil
+	}
+
+	token, expiresAt, err := e.provider.GetToken(cluster)
+	if err != nil {
+		return "", true, fmt.Errorf("could not get token from provider: %w", err)
+	}
+	if token == "" {
+		return "", true, fmt.Errorf("could not get token from provider: token is empty")
+	}
+
+	e.token = token
+	e.expiresAt = expiresAt
+	return token, true, nil
+}
+
+// WrapConfig wraps the transport of the given config, so that the token of the registered provider for the cluster is
+// used for all requests. The provider is looked up for each request, so that providers can also be registered for
+// already created clients. If no provider is registered, the static credentials of the config are used.
+func WrapConfig(cluster string, config *rest.Config) {
+	config.Wrap(func(rt http.RoundTripper) http.RoundTripper {
+		return &roundTripper{cluster: cluster, rt: rt}
+	})
+}
+
+func get(cluster string) *entry {
+	providersLock.RLock()
+	defer providersLock.RUnlock()
+	return providers[cluster]
+}
+
+// roundTripper sets the "Authorization" header of a request to the token of the registered provider. It is the inner
+// most round tripper of client-go, so that it overwrites the header for the static token. When the API server rejects
+// the token, the cached token is invalidated, so that the next request gets a new token from the provider.
+type roundTripper struct {
+	cluster string
+	rt      http.RoundTripper
+}
+
+func (t *roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, ok, err := Token(t.cluster)
+	if !ok {
+		return t.rt.RoundTrip(req)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := t.rt.RoundTrip(req)
+	if err == nil && resp.StatusCode == http.StatusUnauthorized {
+		Invalidate(t.cluster)
+	}
+	return resp, err
+}
+
+func (t *roundTripper) WrappedRoundTripper() http.RoundTripper {
+	return t.rt
+}
@@ -0,0 +1,303 @@
+// Package audit implements an asynchronous writer for audit logs. The audit entries are written as JSON lines to a
+// file or to stdout. When the audit log is written to a file, the file is rotated when it exceeds the configured size
+// or age.
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// Stdout can be used as path for the audit log, to write all entries to stdout instead of a file.
+	Stdout = "stdout"
+
+	// DefaultMaxSize is the default maximum size of an audit log file in bytes, before it is rotated.
+	DefaultMaxSize = 10 * 1024 * 1024
+	// DefaultMaxBackups is the default number of rotated audit log files, which are kept.
+	DefaultMaxBackups = 3
+
+	bufferSize = 1024
+)
+
+// Writer writes audit entries asynchronously to a file or stdout. The entries are send to a buffered channel, so that
+// the caller is never blocked by the write path. When the buffer is full, new entries are dropped and the number of
+// dropped entries is logged, when the writer is closed.
+type Writer struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+	maxAge     time.Duration
+
+	out    io.WriteCloser
+	size   int64
+	opened time.Time
+
+	entries chan any
+	dropped uint64
+	done    chan struct{}
+	once    sync.Once
+}
+
+// New returns a new audit writer for the given path. If the path is "stdout" all entries are written to stdout. The
+// "maxSize" is the maximum size of the audit log file in bytes and "maxBackups" the number of rotated files which are
+// kept. If these values are 0, the default values are used. The "maxAge" is the duration after which the audit log
+// file is rotated, regardless of its size. If it is 0, the file is only rotated by size.
+func New(path string, maxSize int64, maxBackups int, maxAge time.Duration) (*Writer, error) {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxSize
+	}
+	if maxBackups <= 0 {
+		maxBackups = DefaultMaxBackups
+	}
+
+	w := &Writer{
+		path:       path,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+		maxAge:     maxAge,
+		entries:    make(chan any, bufferSize),
+		done:       make(chan struct{}),
+	}
+
+	if path == Stdout {
+		w.out = nopCloser{os.Stdout}
+	} else {
+		if err := w.open(); err != nil {
+			return nil, err
+		}
+	}
+
+	go w.run()
+
+	return w, nil
+}
+
+// Write adds an entry to the audit log. The entry must be serializable to JSON. The function never blocks, if the
+// buffer is full, the entry is dropped. It is safe to call Write on a nil writer, so that callers don't have to check
+// if the audit log is enabled.
+func (w *Writer) Write(entry any) {
+	if w == nil {
+		return
+	}
+
+	select {
+	case <-w.done:
+		return
+	default:
+	}
+
+	select {
+	case w.entries <- entry:
+	default:
+		atomic.AddUint64(&w.dropped, 1)
+	}
+}
+
+// Close stops the writer after all buffered entries are written and closes the audit log file.
+func (w *Writer) Close() {
+	if w == nil {
+		return
+	}
+
+	w.once.Do(func() {
+		close(w.done)
+	})
+}
+
+// run writes all entries from the buffer to the audit log, until the writer is closed.
+func (w *Writer) run() {
+	defer w.out.Close()
+
+	for {
+		select {
+		case entry := <-w.entries:
+			w.write(entry)
+		case <-w.done:
+			for {
+				select {
+				case entry := <-w.entries:
+					w.write(entry)
+				default:
+					if dropped := atomic.LoadUint64(&w.dropped); dropped > 0 {
+						log.Printf("Audit log dropped %d entries", dropped)
+					}
+					return
+				}
+			}
+		}
+	}
+}
+
+// write serializes a single entry and writes it to the audit log. Before the entry is written we check if the file
+// must be rotated, because it would exceed the maximum size or because it is older than the maximum age.
+func (w *Writer) write(entry any) {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		log.Printf("Could not marshal audit log entry: %s", err.Error())
+		return
+	}
+	data = append(data, '\n')
+
+	if w.path != Stdout && (w.size+int64(len(data)) > w.maxSize || (w.maxAge > 0 && w.size > 0 && time.Since(w.opened) > w.maxAge)) {
+		if err := w.rotate(); err != nil {
+			log.Printf("Could not rotate audit log: %s", err.Error())
+		}
+	}
+
+	n, err := w.out.Write(data)
+	w.size += int64(n)
+	if err != nil {
+		log.Printf("Could not write audit log entry: %s", err.Error())
+	}
+}
+
+// open opens the audit log file in append mode. The file is only readable by the current user, because it may contain
+// sensitive information. The age of an existing file is measured from its last modification, so that a file which
+// wasn't written for a long time is rotated with the next entry.
+func (w *Writer) open() error {
+	file, err := os.OpenFile(w.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	w.out = file
+	w.size = info.Size()
+	w.opened = time.Now()
+	if w.size > 0 {
+		w.opened = info.ModTime()
+	}
+	return nil
+}
+
+// rotate closes the current audit log file, renames it to "<path>.1" and shifts all existing backups by one. Backups
+// exceeding the maximum number of backups are removed. Afterwards a new audit log file is opened.
+func (w *Writer) rotate() error {
+	w.out.Close()
+
+	os.Remove(fmt.Sprintf("%s.%d", w.path, w.maxBackups))
+	for i := w.maxBackups - 1; i > 0; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", w.path, i), fmt.Sprintf("%s.%d", w.path, i+1))
+	}
+
+	if err := os.Rename(w.path, w.path+".1"); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return w.open()
+}
+
+// ReadLast returns the last "n" entries of the audit log, the newest entry first. If the current audit log file
+// contains less than "n" entries, the rotated files are read as well. Entries which are still buffered by the writer
+// are not returned. The audit log can not be read, when it is written to stdout.
+func (w *Writer) ReadLast(n int) ([]json.RawMessage, error) {
+	if w == nil {
+		return nil, fmt.Errorf("audit log is not enabled")
+	}
+	if w.path == Stdout {
+		return nil, fmt.Errorf("audit log is written to stdout and can not be read")
+	}
+
+	var entries []json.RawMessage
+
+	for i := 0; i <= w.maxBackups && len(entries) < n; i++ {
+		path := w.path
+		if i > 0 {
+			path = fmt.Sprintf("%s.%d", w.path, i)
+		}
+
+		fileEntries, err := readEntries(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				break
+			}
+			return nil, err
+		}
+
+		for j := len(fileEntries) - 1; j >= 0 && len(entries) < n; j-- {
+			entries = append(entries, fileEntries[j])
+		}
+	}
+
+	return entries, nil
+}
+
+// readEntries reads all entries from the given audit log file. Lines which are not valid JSON (e.g. a partially
+// written last line) are skipped.
+func readEntries(path string) ([]json.RawMessage, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []json.RawMessage
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if json.Valid(scanner.Bytes()) {
+			entries = append(entries, json.RawMessage(append([]byte(nil), scanner.Bytes()...)))
+		}
+	}
+
+	return entries, scanner.Err()
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
+
+// TerminalEntry is the structure of an audit log entry for a terminal session. An entry is written when a session is
+// started ("start"), when it ends ("end") and optionally for each line of input ("input"). The entry never contains
+// the credentials which were used to create the session.
+type TerminalEntry struct {
+	Time      string   `json:"time"`
+	Event     string   `json:"event"`
+	SessionID string   `json:"sessionID"`
+	RequestID string   `json:"requestID,omitempty"`
+	Cluster   string   `json:"cluster"`
+	Namespace string   `json:"namespace"`
+	Pod       string   `json:"pod"`
+	Container string   `json:"container"`
+	Command   []string `json:"command"`
+	Duration  float64  `json:"durationSeconds,omitempty"`
+	ExitCode  *int     `json:"exitCode,omitempty"`
+	Input     string   `json:"input,omitempty"`
+}
+
+// MutationEntry is the structure of an audit log entry for a mutating operation against a cluster, e.g. a request with
+// the "DELETE", "PATCH", "POST" or "PUT" method or an action like a rollout restart. The "Action" is the name of the
+// action or the method of the request and the "URL" is the requested resource url. The "Outcome" is "success" or
+// "failure". The entry never contains the credentials or the body of the request.
+type MutationEntry struct {
+	Time       string `json:"time"`
+	RequestID  string `json:"requestID,omitempty"`
+	Action     string `json:"action"`
+	Cluster    string `json:"cluster"`
+	URL        string `json:"url,omitempty"`
+	Outcome    string `json:"outcome"`
+	StatusCode int    `json:"statusCode,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// The outcomes of a mutating operation.
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+)
@@ -0,0 +1,408 @@
+// Package certificates implements an overview of the TLS certificates of a cluster, sorted by their expiry. The
+// certificates are taken from the Certificate resources of cert-manager or, when cert-manager isn't installed, from the
+// Secrets of the type "kubernetes.io/tls". Each certificate is joined with the Ingresses, which reference its Secret,
+// to find hosts which are not covered by the certificate.
+package certificates
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/kubenav/kubenav/pkg/server/resources"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
+)
+
+// The sources of the certificates. With the "auto" source the Certificate resources of cert-manager are used, when
+// cert-manager is installed, otherwise the TLS Secrets are used.
+const (
+	SourceAuto         = ""
+	SourceCertificates = "certificates"
+	SourceSecrets      = "secrets"
+)
+
+// certificateNameAnnotation is the annotation, which is set by cert-manager on the Secret of a Certificate.
+const certificateNameAnnotation = "cert-manager.io/certificate-name"
+
+// mismatchTolerance is the allowed difference between the expiry in the status of a Certificate and the expiry of the
+// certificate in the Secret, because the status is stored with a precision of seconds.
+const mismatchTolerance = time.Second
+
+// DefaultWarningThreshold is the default duration before the expiry of a certificate, from which on the certificate is
+// flagged with a warning.
+const DefaultWarningThreshold = 30 * 24 * time.Hour
+
+// ErrInvalidOptions is returned for invalid options.
+var ErrInvalidOptions = fmt.Errorf("%w", resources.ErrInvalidOptions)
+
+var certificateKind = schema.GroupVersionKind{Group: "cert-manager.io", Kind: "Certificate"}
+
+// Options are the options for the overview. If "expiringWithin" is greater than 0, only certificates which expire
+// within this duration (or which are already expired) are returned. Certificates which expire within the warning
+// threshold are flagged with a warning, if the threshold is 0, DefaultWarningThreshold is used.
+type Options struct {
+	Namespace        string
+	Source           string
+	ExpiringWithin   time.Duration
+	WarningThreshold time.Duration
+}
+
+// OptionsFromQuery returns the options from the "namespace", "source", "days" and "warningDays" query parameters. The
+// "days" parameter is the number of days for the "expiringWithin" option and the "warningDays" parameter the number of
+// days for the "warningThreshold" option.
+func OptionsFromQuery(query url.Values) (Options, error) {
+	options := Options{
+		Namespace: query.Get("namespace"),
+		Source:    query.Get("source"),
+	}
+
+	if warningDays := query.Get("warningDays"); warningDays != "" {
+		parsedDays, err := strconv.Atoi(warningDays)
+		if err != nil || parsedDays <= 0 {
+			return options, fmt.Errorf("invalid warningDays %s", warningDays)
+		}
+		options.WarningThreshold = time.Duration(parsedDays) * 24 * time.Hour
+	}
+
+	if days := query.Get("days"); days != "" {
+		parsedDays, err := strconv.Atoi(days)
+		if err != nil || parsedDays < 0 {
+			return options, fmt.Errorf("invalid days %s", days)
+		}
+		options.ExpiringWithin = time.Duration(parsedDays) * 24 * time.Hour
+	}
+
+	return options, nil
+}
+
+// Issuer is the issuer of a certificate. For Certificates it is the referenced issuer of cert-manager, for Secrets
+// it is the common name of the issuer of the certificate.
+type Issuer struct {
+	Name  string `json:"name"`
+	Kind  string `json:"kind,omitempty"`
+	Group string `json:"group,omitempty"`
+}
+
+// Certificate is a single certificate of the overview. The "notAfter" and "renewalTime" are taken from the status of
+// the Certificate resource, the "secretNotAfter" is parsed from the certificate in the Secret. If both disagree,
+// "mismatch" is true. The "expiresIn" is the number of seconds until the certificate expires and "daysRemaining" the
+// number of full days, which is negative for expired certificates. "expiring" is true, when the certificate expires
+// within the warning threshold.
+//
+// When the certificate in the Secret is missing or could not be parsed, "invalid" is true and the warnings contain the
+// reason. "ingresses" contains the Ingresses which reference the Secret, if one of their hosts isn't covered by the
+// SANs of the certificate, "hostMismatch" is true.
+type Certificate struct {
+	Source         string             `json:"source"`
+	Namespace      string             `json:"namespace"`
+	Name           string             `json:"name"`
+	SecretName     string             `json:"secretName"`
+	Subject        string             `json:"subject,omitempty"`
+	Issuer         Issuer             `json:"issuer"`
+	DNSNames       []string           `json:"dnsNames,omitempty"`
+	IPAddresses    []string           `json:"ipAddresses,omitempty"`
+	Ready          string             `json:"ready,omitempty"`
+	Reason         string             `json:"reason,omitempty"`
+	Message        string             `json:"message,omitempty"`
+	NotAfter       string             `json:"notAfter,omitempty"`
+	RenewalTime    string             `json:"renewalTime,omitempty"`
+	SecretNotAfter string             `json:"secretNotAfter,omitempty"`
+	ExpiresIn      *int64             `json:"expiresIn,omitempty"`
+	DaysRemaining  *int64             `json:"daysRemaining,omitempty"`
+	Expired        bool               `json:"expired"`
+	Expiring       bool               `json:"expiring"`
+	Invalid        bool               `json:"invalid"`
+	Mismatch       bool               `json:"mismatch"`
+	HostMismatch   bool               `json:"hostMismatch"`
+	Ingresses      []IngressReference `json:"ingresses,omitempty"`
+	Warnings       []string           `json:"warnings,omitempty"`
+
+	expiry *time.Time
+}
+
+// Result is the result of the overview. The "source" is the source, which was used for the certificates and
+// "certManagerInstalled" is true, when the cert-manager CRDs are installed in the cluster. The "warningThreshold" is
+// the used threshold in days. When the Ingresses could not be listed, "ingressesError" contains the reason and the
+// hosts of the certificates are not checked.
+type Result struct {
+	Source               string        `json:"source"`
+	CertManagerInstalled bool          `json:"certManagerInstalled"`
+	WarningThreshold     int64         `json:"warningThreshold"`
+	IngressesError       string        `json:"ingressesError,omitempty"`
+	Certificates         []Certificate `json:"certificates"`
+}
+
+// certificate contains the fields of a cert-manager Certificate, which are used for the overview.
+type certificate struct {
+	Metadata metav1.ObjectMeta `json:"metadata"`
+	Spec     struct {
+		SecretName string   `json:"secretName"`
+		DNSNames   []string `json:"dnsNames"`
+		IssuerRef  Issuer   `json:"issuerRef"`
+	} `json:"spec"`
+	Status struct {
+		NotAfter    string             `json:"notAfter"`
+		RenewalTime string             `json:"renewalTime"`
+		Conditions  []metav1.Condition `json:"conditions"`
+	} `json:"status"`
+}
+
+// Get returns the certificates of the cluster sorted by their time to expiry, the certificates which expire first are
+// returned first. Certificates without an expiry are returned at the end.
+func Get(ctx context.Context, cache *resources.DiscoveryCache, clusterKey string, clientset kubernetes.Interface, options Options) (*Result, error) {
+	if options.Source != SourceAuto && options.Source != SourceCertificates && options.Source != SourceSecrets {
+		return nil, fmt.Errorf("%w: invalid source %s", ErrInvalidOptions, options.Source)
+	}
+
+	info, err := cache.ResolveKind(clusterKey, clientset, certificateKind)
+	if err != nil && !meta.IsNoMatchError(err) {
+		return nil, err
+	}
+
+	result := &Result{CertManagerInstalled: err == nil, Source: options.Source}
+	if result.Source == SourceAuto {
+		result.Source = SourceSecrets
+		if result.CertManagerInstalled {
+			result.Source = SourceCertificates
+		}
+	}
+	if result.Source == SourceCertificates && !result.CertManagerInstalled {
+		return nil, fmt.Errorf("%w: cert-manager is not installed", ErrInvalidOptions)
+	}
+
+	warningThreshold := options.WarningThreshold
+	if warningThreshold <= 0 {
+		warningThreshold = DefaultWarningThreshold
+	}
+	result.WarningThreshold = int64(warningThreshold / (24 * time.Hour))
+
+	now := time.Now()
+
+	var certificates []Certificate
+	if result.Source == SourceCertificates {
+		certificates, err = getCertificates(ctx, clientset, info, options.Namespace, now)
+	} else {
+		certificates, err = getSecrets(ctx, clientset, options.Namespace, now)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// The Ingresses are only used to check the hosts of the certificates, so that the overview is still returned when
+	// the user isn't allowed to list them.
+	ingresses, err := listIngressTLS(ctx, clientset, options.Namespace)
+	if err != nil {
+		result.IngressesError = err.Error()
+	}
+
+	result.Certificates = []Certificate{}
+	for _, c := range certificates {
+		if options.ExpiringWithin > 0 && (c.expiry == nil || c.expiry.After(now.Add(options.ExpiringWithin))) {
+			continue
+		}
+
+		c.Expiring = c.expiry != nil && !c.expiry.After(now.Add(warningThreshold))
+		c.setIngresses(ingresses[c.Namespace+"/"+c.SecretName])
+		result.Certificates = append(result.Certificates, c)
+	}
+
+	sort.SliceStable(result.Certificates, func(i, j int) bool {
+		a, b := result.Certificates[i], result.Certificates[j]
+		if a.expiry == nil || b.expiry == nil {
+			return a.expiry != nil
+		}
+		return a.expiry.Before(*b.expiry)
+	})
+
+	return result, nil
+}
+
+// getCertificates returns the Certificates of cert-manager. The certificates in the Secrets are parsed to cross-check
+// the status of the Certificates. If the user isn't allowed to list the Secrets, the cross-check is skipped.
+func getCertificates(ctx context.Context, clientset kubernetes.Interface, info resources.ResourceInfo, namespace string, now time.Time) ([]Certificate, error) {
+	data, err := clientset.CoreV1().RESTClient().Get().AbsPath(resources.Path(info, namespace)).DoRaw(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var list struct {
+		Items []certificate `json:"items"`
+	}
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, err
+	}
+
+	secrets, secretsErr := listSecrets(ctx, clientset, namespace)
+
+	certificates := make([]Certificate, 0, len(list.Items))
+	for _, item := range list.Items {
+		c := Certificate{
+			Source:      SourceCertificates,
+			Namespace:   item.Metadata.Namespace,
+			Name:        item.Metadata.Name,
+			SecretName:  item.Spec.SecretName,
+			Issuer:      item.Spec.IssuerRef,
+			DNSNames:    item.Spec.DNSNames,
+			Ready:       string(metav1.ConditionUnknown),
+			NotAfter:    item.Status.NotAfter,
+			RenewalTime: item.Status.RenewalTime,
+		}
+
+		if condition := meta.FindStatusCondition(item.Status.Conditions, "Ready"); condition != nil {
+			c.Ready = string(condition.Status)
+			c.Reason = condition.Reason
+			c.Message = condition.Message
+		}
+
+		var statusNotAfter *time.Time
+		if item.Status.NotAfter != "" {
+			parsed, err := time.Parse(time.RFC3339, item.Status.NotAfter)
+			if err != nil {
+				c.Warnings = append(c.Warnings, fmt.Sprintf("invalid notAfter in status: %s", err.Error()))
+			} else {
+				statusNotAfter = &parsed
+			}
+		}
+
+		var secretNotAfter *time.Time
+		if secretsErr != nil {
+			c.Warnings = append(c.Warnings, fmt.Sprintf("could not get secret: %s", secretsErr.Error()))
+		} else if secret, ok := secrets[item.Metadata.Namespace+"/"+item.Spec.SecretName]; !ok {
+			c.Invalid = true
+			c.Warnings = append(c.Warnings, fmt.Sprintf("secret %s not found", item.Spec.SecretName))
+		} else if cert, err := parseCertificate(secret); err != nil {
+			c.Invalid = true
+			c.Warnings = append(c.Warnings, fmt.Sprintf("could not parse certificate from secret: %s", err.Error()))
+		} else {
+			secretNotAfter = &cert.NotAfter
+			c.SecretNotAfter = cert.NotAfter.UTC().Format(time.RFC3339)
+			c.Subject = cert.Subject.String()
+			c.IPAddresses = ipAddresses(cert)
+		}
+
+		// The certificate in the Secret is the certificate which is actually used, so that it is preferred for the
+		// expiry, when the status of the Certificate disagrees with it.
+		if statusNotAfter != nil && secretNotAfter != nil {
+			if diff := statusNotAfter.Sub(*secretNotAfter); diff > mismatchTolerance || diff < -mismatchTolerance {
+				c.Mismatch = true
+				c.Warnings = append(c.Warnings, fmt.Sprintf("status expires at %s, but the certificate in the secret expires at %s", c.NotAfter, c.SecretNotAfter))
+			}
+		}
+
+		if secretNotAfter != nil {
+			c.setExpiry(*secretNotAfter, now)
+		} else if statusNotAfter != nil {
+			c.setExpiry(*statusNotAfter, now)
+		}
+
+		certificates = append(certificates, c)
+	}
+
+	return certificates, nil
+}
+
+// getSecrets returns the certificates of all TLS Secrets.
+func getSecrets(ctx context.Context, clientset kubernetes.Interface, namespace string, now time.Time) ([]Certificate, error) {
+	secrets, err := listSecrets(ctx, clientset, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	certificates := make([]Certificate, 0, len(secrets))
+	for _, secret := range secrets {
+		c := Certificate{
+			Source:     SourceSecrets,
+			Namespace:  secret.Namespace,
+			Name:       secret.Name,
+			SecretName: secret.Name,
+		}
+		if name := secret.Annotations[certificateNameAnnotation]; name != "" {
+			c.Name = name
+		}
+
+		cert, err := parseCertificate(secret)
+		if err != nil {
+			c.Invalid = true
+			c.Warnings = append(c.Warnings, fmt.Sprintf("could not parse certificate: %s", err.Error()))
+		} else {
+			c.Subject = cert.Subject.String()
+			c.Issuer = Issuer{Name: cert.Issuer.CommonName}
+			c.DNSNames = cert.DNSNames
+			c.IPAddresses = ipAddresses(cert)
+			c.NotAfter = cert.NotAfter.UTC().Format(time.RFC3339)
+			c.SecretNotAfter = c.NotAfter
+			c.setExpiry(cert.NotAfter, now)
+		}
+
+		certificates = append(certificates, c)
+	}
+
+	return certificates, nil
+}
+
+// listSecrets returns all Secrets of the type "kubernetes.io/tls" in the namespace. The key of the returned map is the
+// namespace and name of the Secret.
+func listSecrets(ctx context.Context, clientset kubernetes.Interface, namespace string) (map[string]*corev1.Secret, error) {
+	list, err := clientset.CoreV1().Secrets(namespace).List(ctx, metav1.ListOptions{FieldSelector: "type=" + string(corev1.SecretTypeTLS)})
+	if err != nil {
+		if apierrors.IsForbidden(err) {
+			return nil, fmt.Errorf("not allowed to list secrets: %w", err)
+		}
+		return nil, err
+	}
+
+	secrets := make(map[string]*corev1.Secret, len(list.Items))
+	for i := range list.Items {
+		secrets[list.Items[i].Namespace+"/"+list.Items[i].Name] = &list.Items[i]
+	}
+	return secrets, nil
+}
+
+// parseCertificate parses the first certificate from the "tls.crt" key of the Secret, which is the leaf certificate.
+func parseCertificate(secret *corev1.Secret) (*x509.Certificate, error) {
+	data := secret.Data[corev1.TLSCertKey]
+	if len(data) == 0 {
+		return nil, fmt.Errorf("%s is empty", corev1.TLSCertKey)
+	}
+
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("no certificate found in %s", corev1.TLSCertKey)
+		}
+		if block.Type == "CERTIFICATE" {
+			return x509.ParseCertificate(block.Bytes)
+		}
+	}
+}
+
+func ipAddresses(cert *x509.Certificate) []string {
+	var ips []string
+	for _, ip := range cert.IPAddresses {
+		ips = append(ips, ip.String())
+	}
+	return ips
+}
+
+func (c *Certificate) setExpiry(notAfter, now time.Time) {
+	expiresIn := int64(notAfter.Sub(now).Seconds())
+	daysRemaining := int64(math.Floor(notAfter.Sub(now).Hours() / 24))
+	c.expiry = &notAfter
+	c.ExpiresIn = &expiresIn
+	c.DaysRemaining = &daysRemaining
+	c.Expired = expiresIn <= 0
+}
@@ -0,0 +1,111 @@
+package certificates
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// IngressReference is an Ingress, which references the Secret of a certificate in its TLS configuration. The hosts
+// are the hosts of the TLS entry, "uncoveredHosts" are the hosts which are not covered by the SANs of the certificate,
+// so that clients will reject the certificate for them.
+type IngressReference struct {
+	Namespace      string   `json:"namespace"`
+	Name           string   `json:"name"`
+	Hosts          []string `json:"hosts"`
+	UncoveredHosts []string `json:"uncoveredHosts,omitempty"`
+}
+
+// listIngressTLS returns the hosts of the TLS entries of all Ingresses in the namespace, grouped by the namespace and
+// name of the referenced Secret. When a TLS entry doesn't contain any hosts, the hosts of all rules of the Ingress are
+// used, because the controller serves the certificate for all of them.
+func listIngressTLS(ctx context.Context, clientset kubernetes.Interface, namespace string) (map[string][]IngressReference, error) {
+	list, err := clientset.NetworkingV1().Ingresses(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	references := make(map[string][]IngressReference)
+	for _, ingress := range list.Items {
+		for _, tls := range ingress.Spec.TLS {
+			if tls.SecretName == "" {
+				continue
+			}
+
+			hosts := tls.Hosts
+			if len(hosts) == 0 {
+				hosts = ruleHosts(ingress)
+			}
+
+			key := ingress.Namespace + "/" + tls.SecretName
+			references[key] = append(references[key], IngressReference{Namespace: ingress.Namespace, Name: ingress.Name, Hosts: hosts})
+		}
+	}
+
+	return references, nil
+}
+
+func ruleHosts(ingress networkingv1.Ingress) []string {
+	var hosts []string
+	for _, rule := range ingress.Spec.Rules {
+		if rule.Host != "" {
+			hosts = append(hosts, rule.Host)
+		}
+	}
+	return hosts
+}
+
+// setIngresses adds the Ingresses, which reference the Secret of the certificate, and flags the hosts which are not
+// covered by the SANs of the certificate. Certificates without SANs (e.g. because they could not be parsed) are not
+// checked.
+func (c *Certificate) setIngresses(references []IngressReference) {
+	for _, reference := range references {
+		if len(c.DNSNames) > 0 || len(c.IPAddresses) > 0 {
+			for _, host := range reference.Hosts {
+				if !hostCovered(c.DNSNames, c.IPAddresses, host) {
+					reference.UncoveredHosts = append(reference.UncoveredHosts, host)
+				}
+			}
+		}
+
+		if len(reference.UncoveredHosts) > 0 {
+			c.HostMismatch = true
+			c.Warnings = append(c.Warnings, fmt.Sprintf("hosts %s of ingress %s are not covered by the certificate", strings.Join(reference.UncoveredHosts, ", "), reference.Name))
+		}
+
+		c.Ingresses = append(c.Ingresses, reference)
+	}
+}
+
+// hostCovered checks if the host is covered by the DNS names or IP addresses of a certificate. A wildcard DNS name only
+// covers a single label, like it is done by TLS clients, e.g. "*.example.com" covers "www.example.com", but not
+// "example.com" or "a.b.example.com".
+func hostCovered(dnsNames, ipAddresses []string, host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+
+	for _, ip := range ipAddresses {
+		if ip == host {
+			return true
+		}
+	}
+
+	for _, name := range dnsNames {
+		name = strings.ToLower(strings.TrimSuffix(name, "."))
+		if name == host {
+			return true
+		}
+
+		if strings.HasPrefix(name, "*.") {
+			label, rest, found := strings.Cut(host, ".")
+			if found && label != "" && rest == name[2:] {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/kubenav/kubenav/pkg/server/middleware"
+)
+
+// reloadableOptions are the JSON names of the options, which can be changed while the server is running via the
+// "/api/config" endpoint. All other options (e.g. the listen address, TLS or the audit logs) require a restart of the
+// server.
+var reloadableOptions = map[string]bool{
+	"maxTerminalSessions":           true,
+	"maxTerminalSessionsPerCluster": true,
+	"disableWebSocketCompression":   true,
+	"execProtocol":                  true,
+	"terminalAuditLogInput":         true,
+	"logMaxStreams":                 true,
+	"logMaxBytesPerSecond":          true,
+	"watchMaxSubscriptions":         true,
+	"allowedOrigins":                true,
+	"cors":                          true,
+	"requestLogLevel":               true,
+	"logOriginalErrors":             true,
+	"rateLimit":                     true,
+	"drainTimeout":                  true,
+	"maxRequestBodySize":            true,
+	"maxResponseSize":               true,
+	"plugins":                       true,
+}
+
+// getOptions returns the current options of the server. The options are stored as atomic pointer, which is replaced
+// by reloadOptions, so that handlers always see a consistent set of options without a lock. The returned options must
+// not be modified.
+func (s *server) getOptions() *Options {
+	return s.options.Load()
+}
+
+// copyOptions returns a deep copy of the current options, so that the copy can be modified (e.g. by decoding a request
+// body into it), without changing the options which are used by running requests.
+func (s *server) copyOptions() (Options, error) {
+	var options Options
+
+	data, err := json.Marshal(s.getOptions())
+	if err != nil {
+		return options, err
+	}
+
+	if err := json.Unmarshal(data, &options); err != nil {
+		return options, err
+	}
+
+	return options, nil
+}
+
+// reloadOptions replaces the options of the running server with the given options. If an option was changed, which
+// can not be changed while the server is running, an error with the names of these options is returned and nothing is
+// changed. The request logger, the rate limiter and the logging of the original errors are updated directly, all
+// other options are read by the handlers for each request.
+func (s *server) reloadOptions(options Options) error {
+	s.configLock.Lock()
+	defer s.configLock.Unlock()
+
+	if changed := changedOptions(*s.getOptions(), options, reloadableOptions); len(changed) > 0 {
+		return fmt.Errorf("the following options can not be changed while the server is running: %s", strings.Join(changed, ", "))
+	}
+
+	if err := s.requestLogger.SetLevel(options.RequestLogLevel); err != nil {
+		return err
+	}
+	s.rateLimiter.Update(options.RateLimit)
+	middleware.LogOriginalErrors(options.LogOriginalErrors)
+
+	s.options.Store(&options)
+	return nil
+}
+
+// changedOptions returns the sorted JSON names of all options, which are different in the old and new options and
+// which are not contained in the ignored options.
+func changedOptions(oldOptions, newOptions Options, ignored map[string]bool) []string {
+	var changed []string
+
+	oldValue := reflect.ValueOf(oldOptions)
+	newValue := reflect.ValueOf(newOptions)
+	for i := 0; i < oldValue.NumField(); i++ {
+		name := strings.Split(oldValue.Type().Field(i).Tag.Get("json"), ",")[0]
+		if ignored[name] {
+			continue
+		}
+
+		if !reflect.DeepEqual(oldValue.Field(i).Interface(), newValue.Field(i).Interface()) {
+			changed = append(changed, name)
+		}
+	}
+
+	sort.Strings(changed)
+	return changed
+}
+
+// redactOptions returns a copy of the given options without secrets, so that it can be returned by the "/api/config"
+// endpoint.
+func redactOptions(options Options) Options {
+	if options.AuthToken != "" {
+		options.AuthToken = "[REDACTED]"
+	}
+
+	return options
+}
@@ -0,0 +1,255 @@
+// Package configdata implements the update of the data of ConfigMaps and Secrets with an automatic retry on
+// conflicts. When the object was changed since the user loaded it, the changes of the user are applied to the live
+// object on the level of the data keys, so that concurrent edits of different keys do not fail.
+package configdata
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"sort"
+
+	"github.com/kubenav/kubenav/pkg/server/resources"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// The kinds, which can be updated.
+const (
+	KindConfigMap = "ConfigMap"
+	KindSecret    = "Secret"
+)
+
+// DefaultMaxRetries is the default number of retries, when the update fails with a conflict.
+const DefaultMaxRetries = 5
+
+// ErrInvalidOptions is returned when the options for an update are invalid.
+var ErrInvalidOptions = fmt.Errorf("%w", resources.ErrInvalidOptions)
+
+// UpdateOptions are the options to update the data of a ConfigMap or Secret. "Original" is the data as it was loaded
+// by the user together with the "ResourceVersion" of the object, "Data" is the data as it was edited by the user. Keys
+// of "Original" which are missing in "Data" are removed, keys which are in neither of them are not changed. For
+// ConfigMaps the keys of "data" and "binaryData" are merged separately, the values of "binaryData" must be base64
+// encoded. For Secrets all values must be base64 encoded, the same as in the "data" field of a Secret.
+type UpdateOptions struct {
+	Kind            string            `json:"kind"`
+	Namespace       string            `json:"namespace"`
+	Name            string            `json:"name"`
+	ResourceVersion string            `json:"resourceVersion"`
+	Original        map[string]string `json:"original"`
+	Data            map[string]string `json:"data"`
+	BinaryOriginal  map[string]string `json:"binaryOriginal"`
+	BinaryData      map[string]string `json:"binaryData"`
+	MaxRetries      int               `json:"maxRetries"`
+}
+
+// UpdateResult is the result of an update. When the same key was changed by the user and in the live object, the
+// object isn't updated and "Conflicts" contains the conflicting keys.
+type UpdateResult struct {
+	Updated         bool       `json:"updated"`
+	ResourceVersion string     `json:"resourceVersion"`
+	Retries         int        `json:"retries"`
+	Merged          bool       `json:"merged"`
+	Conflicts       []Conflict `json:"conflicts,omitempty"`
+}
+
+// Conflict is a key, which was changed by the user and in the live object. The values are only returned for
+// ConfigMaps, a missing value means that the key doesn't exist.
+type Conflict struct {
+	Key      string  `json:"key"`
+	Binary   bool    `json:"binary,omitempty"`
+	Original *string `json:"original,omitempty"`
+	Edited   *string `json:"edited,omitempty"`
+	Live     *string `json:"live,omitempty"`
+}
+
+// Update updates the data of the ConfigMap or Secret from the options. If the object wasn't changed since the
+// resource version from the options, this results in the edited data. Otherwise the keys which were changed by the
+// user are applied to the live object. When the update fails with a conflict, the live object is fetched again and the
+// changes are applied again, until the maximum number of retries is reached.
+func Update(ctx context.Context, clientset kubernetes.Interface, options UpdateOptions) (*UpdateResult, error) {
+	if options.Namespace == "" || options.Name == "" {
+		return nil, fmt.Errorf("%w: namespace and name are required", ErrInvalidOptions)
+	}
+	if options.Kind != KindConfigMap && options.Kind != KindSecret {
+		return nil, fmt.Errorf("%w: invalid kind %s", ErrInvalidOptions, options.Kind)
+	}
+	if options.Kind == KindSecret && (len(options.BinaryOriginal) > 0 || len(options.BinaryData) > 0) {
+		return nil, fmt.Errorf("%w: binary data is only supported for ConfigMaps", ErrInvalidOptions)
+	}
+
+	maxRetries := options.MaxRetries
+	if maxRetries <= 0 {
+		maxRetries = DefaultMaxRetries
+	}
+
+	result := &UpdateResult{}
+	for {
+		resourceVersion, conflicts, err := update(ctx, clientset, options, result)
+		if err == nil {
+			if len(conflicts) > 0 {
+				result.Conflicts = conflicts
+				return result, nil
+			}
+
+			result.Updated = true
+			result.ResourceVersion = resourceVersion
+			return result, nil
+		}
+
+		if !apierrors.IsConflict(err) || result.Retries >= maxRetries {
+			return nil, err
+		}
+		result.Retries++
+	}
+}
+
+// update fetches the live object, applies the changes of the user and updates the object. If the same key was
+// changed on both sides the conflicts are returned and the object isn't updated.
+func update(ctx context.Context, clientset kubernetes.Interface, options UpdateOptions, result *UpdateResult) (string, []Conflict, error) {
+	if options.Kind == KindSecret {
+		secret, err := clientset.CoreV1().Secrets(options.Namespace).Get(ctx, options.Name, metav1.GetOptions{})
+		if err != nil {
+			return "", nil, err
+		}
+
+		live := encodeSecretData(secret.Data)
+		data, conflicts := apply(options.Original, options.Data, live, false)
+		if len(conflicts) > 0 {
+			// The values of a Secret are never returned, the user can fetch the live Secret to resolve the conflict.
+			for i := range conflicts {
+				conflicts[i].Original, conflicts[i].Edited, conflicts[i].Live = nil, nil, nil
+			}
+			return "", conflicts, nil
+		}
+
+		decoded, err := decodeSecretData(data)
+		if err != nil {
+			return "", nil, err
+		}
+
+		result.Merged = options.ResourceVersion != secret.ResourceVersion
+		secret.Data = decoded
+		secret.StringData = nil
+		updated, err := clientset.CoreV1().Secrets(options.Namespace).Update(ctx, secret, metav1.UpdateOptions{FieldManager: resources.FieldManager})
+		if err != nil {
+			return "", nil, err
+		}
+		return updated.ResourceVersion, nil, nil
+	}
+
+	configMap, err := clientset.CoreV1().ConfigMaps(options.Namespace).Get(ctx, options.Name, metav1.GetOptions{})
+	if err != nil {
+		return "", nil, err
+	}
+
+	data, conflicts := apply(options.Original, options.Data, configMap.Data, false)
+	binaryData, binaryConflicts := apply(options.BinaryOriginal, options.BinaryData, encodeSecretData(configMap.BinaryData), true)
+	if conflicts = append(conflicts, binaryConflicts...); len(conflicts) > 0 {
+		return "", conflicts, nil
+	}
+
+	decodedBinaryData, err := decodeSecretData(binaryData)
+	if err != nil {
+		return "", nil, err
+	}
+
+	result.Merged = options.ResourceVersion != configMap.ResourceVersion
+	configMap.Data = data
+	configMap.BinaryData = decodedBinaryData
+	updated, err := clientset.CoreV1().ConfigMaps(options.Namespace).Update(ctx, configMap, metav1.UpdateOptions{FieldManager: resources.FieldManager})
+	if err != nil {
+		return "", nil, err
+	}
+	return updated.ResourceVersion, nil, nil
+}
+
+// apply applies the changes between the original and the edited data to the live data (a three-way merge on the level
+// of the keys). If the object wasn't changed since the user loaded it, the live data is the original data, so that the
+// edited data is returned. A key is conflicting, when it was changed by the user and in the live data to different
+// values.
+func apply(original, edited, live map[string]string, binary bool) (map[string]string, []Conflict) {
+	merged := make(map[string]string, len(live))
+	for key, value := range live {
+		merged[key] = value
+	}
+
+	keys := make(map[string]struct{})
+	for key := range original {
+		keys[key] = struct{}{}
+	}
+	for key := range edited {
+		keys[key] = struct{}{}
+	}
+
+	var conflicts []Conflict
+	for key := range keys {
+		originalValue, inOriginal := original[key]
+		editedValue, inEdited := edited[key]
+		liveValue, inLive := live[key]
+
+		if inOriginal == inEdited && originalValue == editedValue {
+			continue
+		}
+
+		// The key was changed by the user. When the live value is different from the original value, the key was also
+		// changed in the live object, which is only a conflict if the changes are different.
+		if (inOriginal != inLive || originalValue != liveValue) && (inEdited != inLive || editedValue != liveValue) {
+			conflicts = append(conflicts, Conflict{
+				Key:      key,
+				Binary:   binary,
+				Original: valuePtr(originalValue, inOriginal),
+				Edited:   valuePtr(editedValue, inEdited),
+				Live:     valuePtr(liveValue, inLive),
+			})
+			continue
+		}
+
+		if inEdited {
+			merged[key] = editedValue
+		} else {
+			delete(merged, key)
+		}
+	}
+
+	sort.Slice(conflicts, func(i, j int) bool {
+		return conflicts[i].Key < conflicts[j].Key
+	})
+
+	return merged, conflicts
+}
+
+func valuePtr(value string, ok bool) *string {
+	if !ok {
+		return nil
+	}
+	return &value
+}
+
+// encodeSecretData encodes the values of the data of a Secret or the binary data of a ConfigMap as base64 strings, the
+// same as they are encoded in the JSON representation of the object.
+func encodeSecretData(data map[string][]byte) map[string]string {
+	encoded := make(map[string]string, len(data))
+	for key, value := range data {
+		encoded[key] = base64.StdEncoding.EncodeToString(value)
+	}
+	return encoded
+}
+
+func decodeSecretData(data map[string]string) (map[string][]byte, error) {
+	if data == nil {
+		return nil, nil
+	}
+
+	decoded := make(map[string][]byte, len(data))
+	for key, value := range data {
+		d, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: value of key %s is not base64 encoded", ErrInvalidOptions, key)
+		}
+		decoded[key] = d
+	}
+	return decoded, nil
+}
@@ -0,0 +1,222 @@
+// Package cronjobs implements the suspension and resumption of CronJobs, so that a noisy CronJob can be paused during
+// an incident without editing its manifest. The CronJobs are accessed via the batch/v1 API, when it is served by the
+// cluster, otherwise via the batch/v1beta1 API.
+package cronjobs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/kubenav/kubenav/pkg/server/resources"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// ErrInvalidOptions is returned when the options to suspend or resume CronJobs are invalid.
+var ErrInvalidOptions = fmt.Errorf("%w", resources.ErrInvalidOptions)
+
+// The status of a single CronJob in the result. A CronJob is "unchanged", when it was already suspended or resumed.
+const (
+	StatusUpdated   = "updated"
+	StatusUnchanged = "unchanged"
+	StatusFailed    = "failed"
+)
+
+// Options are the options to suspend or resume a single CronJob via its name or all CronJobs matching the label
+// selector in a namespace.
+type Options struct {
+	Namespace     string `json:"namespace"`
+	Name          string `json:"name"`
+	LabelSelector string `json:"labelSelector"`
+}
+
+// Result is the result of a suspension or resumption, with the result for each CronJob, which was matched by the
+// options.
+type Result struct {
+	CronJobs []CronJobResult `json:"cronJobs"`
+}
+
+// CronJobResult is the result for a single CronJob with its new state. "NextScheduleTime" is computed from the schedule
+// and time zone of the CronJob, for a suspended CronJob it is the next time the CronJob would run after it is resumed.
+// "ActiveJobs" is the number of currently running Jobs, which are not stopped by a suspension, in this case the warning
+// is set.
+type CronJobResult struct {
+	Namespace        string     `json:"namespace"`
+	Name             string     `json:"name"`
+	APIVersion       string     `json:"apiVersion"`
+	Status           string     `json:"status"`
+	Message          string     `json:"message,omitempty"`
+	Suspended        bool       `json:"suspended"`
+	Schedule         string     `json:"schedule"`
+	TimeZone         string     `json:"timeZone,omitempty"`
+	NextScheduleTime *time.Time `json:"nextScheduleTime,omitempty"`
+	LastScheduleTime *time.Time `json:"lastScheduleTime,omitempty"`
+	ActiveJobs       int        `json:"activeJobs"`
+	Warning          string     `json:"warning,omitempty"`
+}
+
+// cronJob contains only the fields of a CronJob, which are required for the result. The fields are the same for the
+// batch/v1 and batch/v1beta1 API.
+type cronJob struct {
+	Metadata struct {
+		Namespace string `json:"namespace"`
+		Name      string `json:"name"`
+	} `json:"metadata"`
+	Spec struct {
+		Schedule string  `json:"schedule"`
+		TimeZone *string `json:"timeZone"`
+		Suspend  *bool   `json:"suspend"`
+	} `json:"spec"`
+	Status struct {
+		Active []struct {
+			Name string `json:"name"`
+		} `json:"active"`
+		LastScheduleTime *metav1.Time `json:"lastScheduleTime"`
+	} `json:"status"`
+}
+
+// SuspendCronJob suspends the CronJobs from the options, so that no new Jobs are created for them.
+func SuspendCronJob(ctx context.Context, clientset kubernetes.Interface, options Options) (*Result, error) {
+	return setSuspend(ctx, clientset, options, true)
+}
+
+// ResumeCronJob resumes the CronJobs from the options, so that new Jobs are created again at the next scheduled time.
+func ResumeCronJob(ctx context.Context, clientset kubernetes.Interface, options Options) (*Result, error) {
+	return setSuspend(ctx, clientset, options, false)
+}
+
+// setSuspend sets the "spec.suspend" field of the CronJobs via a merge patch. CronJobs which are already in the
+// requested state are not patched.
+func setSuspend(ctx context.Context, clientset kubernetes.Interface, options Options, suspend bool) (*Result, error) {
+	if options.Namespace == "" {
+		return nil, fmt.Errorf("%w: namespace is required", ErrInvalidOptions)
+	}
+	if (options.Name == "") == (options.LabelSelector == "") {
+		return nil, fmt.Errorf("%w: name or label selector is required", ErrInvalidOptions)
+	}
+
+	version, err := getVersion(clientset)
+	if err != nil {
+		return nil, err
+	}
+
+	restClient := clientset.BatchV1().RESTClient()
+	basePath := "/apis/batch/" + version + "/namespaces/" + options.Namespace + "/cronjobs"
+
+	var cronJobs []cronJob
+	if options.Name != "" {
+		data, err := restClient.Get().AbsPath(basePath, options.Name).DoRaw(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		var item cronJob
+		if err := json.Unmarshal(data, &item); err != nil {
+			return nil, err
+		}
+		cronJobs = append(cronJobs, item)
+	} else {
+		data, err := restClient.Get().AbsPath(basePath).Param("labelSelector", options.LabelSelector).DoRaw(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		var list struct {
+			Items []cronJob `json:"items"`
+		}
+		if err := json.Unmarshal(data, &list); err != nil {
+			return nil, err
+		}
+		cronJobs = list.Items
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{"spec": map[string]interface{}{"suspend": suspend}})
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	result := &Result{CronJobs: make([]CronJobResult, 0, len(cronJobs))}
+	for _, item := range cronJobs {
+		if (item.Spec.Suspend != nil && *item.Spec.Suspend) == suspend {
+			result.CronJobs = append(result.CronJobs, newResult(item, version, StatusUnchanged, now))
+			continue
+		}
+
+		data, err := restClient.Patch(types.MergePatchType).AbsPath(basePath, item.Metadata.Name).Body(patch).DoRaw(ctx)
+		if err != nil {
+			failed := newResult(item, version, StatusFailed, now)
+			failed.Message = err.Error()
+			if apierrors.IsNotFound(err) {
+				failed.Message = "cronjob was removed"
+			}
+			result.CronJobs = append(result.CronJobs, failed)
+			continue
+		}
+
+		var patched cronJob
+		if err := json.Unmarshal(data, &patched); err != nil {
+			return nil, err
+		}
+		result.CronJobs = append(result.CronJobs, newResult(patched, version, StatusUpdated, now))
+	}
+
+	return result, nil
+}
+
+// getVersion returns the version of the batch API, which should be used for CronJobs. CronJobs are served via
+// batch/v1 since Kubernetes 1.21, the batch/v1beta1 API was removed in Kubernetes 1.25.
+func getVersion(clientset kubernetes.Interface) (string, error) {
+	resources, err := clientset.Discovery().ServerResourcesForGroupVersion("batch/v1")
+	if err != nil && !apierrors.IsNotFound(err) {
+		return "", err
+	}
+
+	if resources != nil {
+		for _, resource := range resources.APIResources {
+			if resource.Name == "cronjobs" {
+				return "v1", nil
+			}
+		}
+	}
+
+	return "v1beta1", nil
+}
+
+// newResult returns the result for a CronJob. If the schedule or time zone of the CronJob is invalid, the next schedule
+// time is not set and the message contains the reason.
+func newResult(item cronJob, version, status string, now time.Time) CronJobResult {
+	result := CronJobResult{
+		Namespace:  item.Metadata.Namespace,
+		Name:       item.Metadata.Name,
+		APIVersion: "batch/" + version,
+		Status:     status,
+		Suspended:  item.Spec.Suspend != nil && *item.Spec.Suspend,
+		Schedule:   item.Spec.Schedule,
+		ActiveJobs: len(item.Status.Active),
+	}
+
+	if item.Spec.TimeZone != nil {
+		result.TimeZone = *item.Spec.TimeZone
+	}
+	if item.Status.LastScheduleTime != nil {
+		result.LastScheduleTime = &item.Status.LastScheduleTime.Time
+	}
+
+	if next, err := NextScheduleTime(item.Spec.Schedule, result.TimeZone, now); err != nil {
+		result.Message = err.Error()
+	} else if !next.IsZero() {
+		result.NextScheduleTime = &next
+	}
+
+	if result.Suspended && result.ActiveJobs > 0 {
+		result.Warning = fmt.Sprintf("Suspending the CronJob doesn't stop the %d active Jobs.", result.ActiveJobs)
+	}
+
+	return result
+}
@@ -0,0 +1,231 @@
+package cronjobs
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// scheduleYears is the number of years, which are searched for the next schedule time. A schedule which doesn't match
+// within this time (e.g. "0 0 30 2 *") never runs.
+const scheduleYears = 5
+
+// macros are the predefined schedules, which are supported by the CronJob controller.
+var macros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// bounds are the minimum and maximum value of a field of a schedule, with the names which can be used instead of the
+// values.
+type bounds struct {
+	min, max int
+	names    map[string]int
+}
+
+var (
+	minuteBounds = bounds{min: 0, max: 59}
+	hourBounds   = bounds{min: 0, max: 23}
+	domBounds    = bounds{min: 1, max: 31}
+	monthBounds  = bounds{min: 1, max: 12, names: map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6, "jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	dowBounds = bounds{min: 0, max: 7, names: map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+// schedule is a parsed cron schedule. Each field is a bit set of the matching values.
+type schedule struct {
+	minute, hour, dom, month, dow uint64
+	// domStar and dowStar are true, when the day of month or day of week field starts with "*" or "?". When both
+	// fields are restricted, a day matches if one of them matches, like it is done by cron.
+	domStar, dowStar bool
+	location         *time.Location
+	// every is set for "@every <duration>" schedules, which run in a fixed interval.
+	every time.Duration
+}
+
+// NextScheduleTime returns the next time after "now", when a CronJob with the given schedule and time zone runs. The
+// schedule supports the standard five cron fields, the predefined macros like "@daily" and the "CRON_TZ=" and "TZ="
+// prefixes. If the time zone is empty, UTC is used. A zero time is returned, when the schedule never runs.
+func NextScheduleTime(expression, timeZone string, now time.Time) (time.Time, error) {
+	s, err := parseSchedule(expression, timeZone)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return s.next(now), nil
+}
+
+func parseSchedule(expression, timeZone string) (*schedule, error) {
+	expression = strings.TrimSpace(expression)
+
+	if strings.HasPrefix(expression, "CRON_TZ=") || strings.HasPrefix(expression, "TZ=") {
+		zone, rest, _ := strings.Cut(expression, " ")
+		_, timeZone, _ = strings.Cut(zone, "=")
+		expression = strings.TrimSpace(rest)
+	}
+
+	location := time.UTC
+	if timeZone != "" {
+		var err error
+		location, err = time.LoadLocation(timeZone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid time zone %q: %w", timeZone, err)
+		}
+	}
+
+	if strings.HasPrefix(expression, "@every ") {
+		every, err := time.ParseDuration(strings.TrimSpace(strings.TrimPrefix(expression, "@every ")))
+		if err != nil || every < time.Second {
+			return nil, fmt.Errorf("invalid schedule %q", expression)
+		}
+		return &schedule{location: location, every: every}, nil
+	}
+
+	if macro, ok := macros[expression]; ok {
+		expression = macro
+	}
+
+	fields := strings.Fields(expression)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid schedule %q: expected 5 fields, got %d", expression, len(fields))
+	}
+
+	s := &schedule{location: location}
+	var err error
+	if s.minute, err = parseField(fields[0], minuteBounds); err != nil {
+		return nil, err
+	}
+	if s.hour, err = parseField(fields[1], hourBounds); err != nil {
+		return nil, err
+	}
+	if s.dom, err = parseField(fields[2], domBounds); err != nil {
+		return nil, err
+	}
+	if s.month, err = parseField(fields[3], monthBounds); err != nil {
+		return nil, err
+	}
+	if s.dow, err = parseField(fields[4], dowBounds); err != nil {
+		return nil, err
+	}
+
+	// Sunday can be 0 or 7.
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	s.domStar = strings.HasPrefix(fields[2], "*") || strings.HasPrefix(fields[2], "?")
+	s.dowStar = strings.HasPrefix(fields[4], "*") || strings.HasPrefix(fields[4], "?")
+
+	return s, nil
+}
+
+// parseField parses a single field of a schedule, which is a comma separated list of ranges, e.g. "1-5,10,*/15".
+func parseField(field string, b bounds) (uint64, error) {
+	var bits uint64
+
+	for _, part := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+
+		step := 1
+		if hasStep {
+			var err error
+			step, err = strconv.Atoi(stepPart)
+			if err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step %q in field %q", stepPart, field)
+			}
+		}
+
+		var start, end int
+		switch {
+		case rangePart == "*" || rangePart == "?":
+			start, end = b.min, b.max
+		case strings.Contains(rangePart, "-"):
+			startPart, endPart, _ := strings.Cut(rangePart, "-")
+			var err error
+			if start, err = parseValue(startPart, b); err != nil {
+				return 0, fmt.Errorf("%w in field %q", err, field)
+			}
+			if end, err = parseValue(endPart, b); err != nil {
+				return 0, fmt.Errorf("%w in field %q", err, field)
+			}
+		default:
+			var err error
+			if start, err = parseValue(rangePart, b); err != nil {
+				return 0, fmt.Errorf("%w in field %q", err, field)
+			}
+			end = start
+			// A single value with a step, e.g. "5/15", is the range from the value to the maximum.
+			if hasStep {
+				end = b.max
+			}
+		}
+
+		if start > end {
+			return 0, fmt.Errorf("invalid range %q in field %q", rangePart, field)
+		}
+
+		for value := start; value <= end; value += step {
+			bits |= 1 << uint(value)
+		}
+	}
+
+	return bits, nil
+}
+
+func parseValue(value string, b bounds) (int, error) {
+	if number, ok := b.names[strings.ToLower(value)]; ok {
+		return number, nil
+	}
+
+	number, err := strconv.Atoi(value)
+	if err != nil || number < b.min || number > b.max {
+		return 0, fmt.Errorf("invalid value %q", value)
+	}
+	return number, nil
+}
+
+// next returns the first time after t, which matches the schedule. The time is only moved forward, so that the search
+// terminates for times around daylight saving time changes.
+func (s *schedule) next(t time.Time) time.Time {
+	if s.every > 0 {
+		return t.Add(s.every).Truncate(time.Second)
+	}
+
+	t = t.In(s.location).Truncate(time.Minute).Add(time.Minute)
+	limit := t.Year() + scheduleYears
+
+	for t.Year() <= limit {
+		switch {
+		case s.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, s.location)
+		case !s.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, s.location)
+		case s.hour&(1<<uint(t.Hour())) == 0:
+			t = t.Add(time.Duration(60-t.Minute()) * time.Minute)
+		case s.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+
+	return time.Time{}
+}
+
+func (s *schedule) dayMatches(t time.Time) bool {
+	domMatch := s.dom&(1<<uint(t.Day())) != 0
+	dowMatch := s.dow&(1<<uint(t.Weekday())) != 0
+
+	if s.domStar || s.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
@@ -0,0 +1,442 @@
+// Package debugbundle implements the collection of all information, which is relevant to debug a workload, into a
+// single gzip compressed tar archive, which can be shared with another team. Each item which could not be collected is
+// replaced by an error note in the archive, so that a missing permission doesn't fail the whole bundle.
+package debugbundle
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kubenav/kubenav/pkg/server/resources"
+	"github.com/kubenav/kubenav/pkg/server/rollout"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/yaml"
+)
+
+// SummaryFile is the name of the summary in the archive. The summary is always the last file of the archive, because
+// it contains the errors of all items.
+const SummaryFile = "summary.yaml"
+
+// The default limits of a bundle. The logs of each container are limited to the last DefaultTailLines lines and
+// DefaultMaxLogBytes bytes, the uncompressed size of all files is limited to DefaultMaxSize bytes.
+const (
+	DefaultTailLines   = 1000
+	DefaultMaxLogBytes = 1024 * 1024
+	DefaultMaxSize     = 32 * 1024 * 1024
+)
+
+// lastAppliedAnnotation is the annotation, which is set by "kubectl apply". It contains a copy of the manifest, so that
+// it is removed from the objects in the bundle.
+const lastAppliedAnnotation = "kubectl.kubernetes.io/last-applied-configuration"
+
+// ErrInvalidOptions is returned when the options for a bundle are invalid.
+var ErrInvalidOptions = fmt.Errorf("%w", resources.ErrInvalidOptions)
+
+// errArchive is returned when the archive could not be written. In contrast to the errors of the Kubernetes API, this
+// error aborts the bundle.
+var errArchive = errors.New("could not write archive")
+
+// workloadKind is a kind of workload, for which a bundle can be created.
+type workloadKind struct {
+	apiVersion string
+	kind       string
+	resource   string
+	// rolloutKind is the kind for the rollout status, it is empty when the kind doesn't have a rollout status.
+	rolloutKind string
+}
+
+var workloadKinds = map[string]workloadKind{
+	"deployment":  {apiVersion: "apps/v1", kind: "Deployment", resource: "deployments", rolloutKind: rollout.KindDeployment},
+	"statefulset": {apiVersion: "apps/v1", kind: "StatefulSet", resource: "statefulsets", rolloutKind: rollout.KindStatefulSet},
+	"daemonset":   {apiVersion: "apps/v1", kind: "DaemonSet", resource: "daemonsets", rolloutKind: rollout.KindDaemonSet},
+	"replicaset":  {apiVersion: "apps/v1", kind: "ReplicaSet", resource: "replicasets"},
+	"job":         {apiVersion: "batch/v1", kind: "Job", resource: "jobs"},
+}
+
+// Options are the options for a bundle. The kind is one of "deployment", "statefulset", "daemonset", "replicaset" or
+// "job". If the limits are 0, the default limits are used.
+type Options struct {
+	Kind        string `json:"kind"`
+	Namespace   string `json:"namespace"`
+	Name        string `json:"name"`
+	TailLines   int64  `json:"tailLines"`
+	MaxLogBytes int64  `json:"maxLogBytes"`
+	MaxSize     int64  `json:"maxSize"`
+}
+
+// Summary is the summary of a bundle. "Files" are all files of the archive, "Errors" the items which could not be
+// collected, with the file containing the error note. "Size" is the uncompressed size of all files and "Truncated" is
+// true, when files were skipped because of the maximum size.
+type Summary struct {
+	Kind      string      `json:"kind"`
+	Namespace string      `json:"namespace"`
+	Name      string      `json:"name"`
+	CreatedAt time.Time   `json:"createdAt"`
+	Files     []string    `json:"files"`
+	Errors    []FileError `json:"errors,omitempty"`
+	Size      int64       `json:"size"`
+	Truncated bool        `json:"truncated"`
+}
+
+// FileError is an item of the bundle, which could not be collected.
+type FileError struct {
+	File  string `json:"file"`
+	Error string `json:"error"`
+}
+
+// Progress is the progress of a bundle, which is reported after each item. The total number of items is only known,
+// after the Pods of the workload were listed, because it depends on the number of containers.
+type Progress struct {
+	Item  string `json:"item"`
+	Done  int    `json:"done"`
+	Total int    `json:"total"`
+}
+
+// bundle writes the files of a bundle to the archive and keeps track of the size and the errors.
+type bundle struct {
+	archive  *tar.Writer
+	summary  *Summary
+	maxSize  int64
+	progress func(Progress)
+	done     int
+	total    int
+}
+
+// Create writes the bundle for the workload from the options as gzip compressed tar archive to the writer. The archive
+// contains the workload and its Pods as YAML without the managed fields, the events of the workload, its ReplicaSets
+// and Pods, the rollout status and the current and previous logs of all containers. The progress function can be nil.
+func Create(ctx context.Context, clientset kubernetes.Interface, options Options, w io.Writer, progress func(Progress)) (*Summary, error) {
+	kind, ok := workloadKinds[strings.ToLower(options.Kind)]
+	if !ok {
+		return nil, fmt.Errorf("%w: unsupported kind %s", ErrInvalidOptions, options.Kind)
+	}
+	if options.Namespace == "" || options.Name == "" {
+		return nil, fmt.Errorf("%w: namespace and name are required", ErrInvalidOptions)
+	}
+	if options.TailLines < 0 || options.MaxLogBytes < 0 || options.MaxSize < 0 {
+		return nil, fmt.Errorf("%w: limits must not be negative", ErrInvalidOptions)
+	}
+	if options.TailLines == 0 {
+		options.TailLines = DefaultTailLines
+	}
+	if options.MaxLogBytes == 0 {
+		options.MaxLogBytes = DefaultMaxLogBytes
+	}
+	if options.MaxSize == 0 {
+		options.MaxSize = DefaultMaxSize
+	}
+
+	gz := gzip.NewWriter(w)
+	b := &bundle{
+		archive:  tar.NewWriter(gz),
+		summary:  &Summary{Kind: kind.kind, Namespace: options.Namespace, Name: options.Name, CreatedAt: time.Now().UTC(), Files: []string{}},
+		maxSize:  options.MaxSize,
+		progress: progress,
+		total:    4,
+	}
+
+	// The workload is required for the selector of its Pods, so that we can only add the error note, when it could not
+	// be fetched.
+	workload, err := clientset.CoreV1().RESTClient().Get().AbsPath("/apis", kind.apiVersion, "namespaces", options.Namespace, kind.resource, options.Name).DoRaw(ctx)
+	if err != nil {
+		workload = nil
+		if err := b.addError("workload.yaml", err); err != nil {
+			return nil, err
+		}
+	} else {
+		if err := b.addObject("workload.yaml", workload); err != nil {
+			return nil, err
+		}
+	}
+	b.step("workload")
+
+	var pods []corev1.Pod
+	if workload != nil {
+		pods, err = listPods(ctx, clientset, options.Namespace, workload)
+		if err != nil {
+			if err := b.addError("pods.yaml", err); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, pod := range pods {
+		b.total++
+		for _, status := range containerStatuses(pod) {
+			b.total++
+			if status.RestartCount > 0 {
+				b.total++
+			}
+		}
+	}
+	b.step("pods")
+
+	for i := range pods {
+		pod := &pods[i]
+		pod.APIVersion = "v1"
+		pod.Kind = "Pod"
+
+		file := path.Join("pods", pod.Name+".yaml")
+		data, err := json.Marshal(pod)
+		if err != nil {
+			err = b.addError(file, err)
+		} else {
+			err = b.addObject(file, data)
+		}
+		if err != nil {
+			return nil, err
+		}
+		b.step("pods/" + pod.Name)
+	}
+
+	if err := b.addEvents(ctx, clientset, options, kind, pods); err != nil {
+		return nil, err
+	}
+	b.step("events")
+
+	if err := b.addRolloutStatus(kind, workload); err != nil {
+		return nil, err
+	}
+	b.step("rollout status")
+
+	for _, pod := range pods {
+		for _, status := range containerStatuses(pod) {
+			file := path.Join("logs", pod.Name, status.Name+".log")
+			if err := b.addLogs(ctx, clientset, pod, status.Name, false, options, file); err != nil {
+				return nil, err
+			}
+			b.step(file)
+
+			if status.RestartCount > 0 {
+				file := path.Join("logs", pod.Name, status.Name+".previous.log")
+				if err := b.addLogs(ctx, clientset, pod, status.Name, true, options, file); err != nil {
+					return nil, err
+				}
+				b.step(file)
+			}
+		}
+	}
+
+	data, err := yaml.Marshal(b.summary)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeFile(b.archive, SummaryFile, data, b.summary.CreatedAt); err != nil {
+		return nil, err
+	}
+
+	if err := b.archive.Close(); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+
+	return b.summary, nil
+}
+
+// listPods returns the Pods of the workload via the selector of the workload, sorted by their name.
+func listPods(ctx context.Context, clientset kubernetes.Interface, namespace string, workload []byte) ([]corev1.Pod, error) {
+	var object struct {
+		Spec struct {
+			Selector *metav1.LabelSelector `json:"selector"`
+		} `json:"spec"`
+	}
+	if err := json.Unmarshal(workload, &object); err != nil {
+		return nil, err
+	}
+	if object.Spec.Selector == nil {
+		return nil, fmt.Errorf("workload doesn't have a selector")
+	}
+
+	selector, err := metav1.LabelSelectorAsSelector(object.Spec.Selector)
+	if err != nil {
+		return nil, err
+	}
+
+	list, err := clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(list.Items, func(i, j int) bool {
+		return list.Items[i].Name < list.Items[j].Name
+	})
+	return list.Items, nil
+}
+
+// containerStatuses returns the statuses of the init containers and containers of the Pod.
+func containerStatuses(pod corev1.Pod) []corev1.ContainerStatus {
+	statuses := make([]corev1.ContainerStatus, 0, len(pod.Status.InitContainerStatuses)+len(pod.Status.ContainerStatuses))
+	statuses = append(statuses, pod.Status.InitContainerStatuses...)
+	return append(statuses, pod.Status.ContainerStatuses...)
+}
+
+// addEvents adds the events of the workload, its Pods and for Deployments also of its ReplicaSets, sorted by the time
+// when they were observed last.
+func (b *bundle) addEvents(ctx context.Context, clientset kubernetes.Interface, options Options, kind workloadKind, pods []corev1.Pod) error {
+	list, err := clientset.CoreV1().Events(options.Namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return b.addError("events.yaml", err)
+	}
+
+	podNames := make(map[string]bool, len(pods))
+	for _, pod := range pods {
+		podNames[pod.Name] = true
+	}
+
+	events := []corev1.Event{}
+	for _, event := range list.Items {
+		involved := event.InvolvedObject
+		switch {
+		case involved.Kind == kind.kind && involved.Name == options.Name:
+		case involved.Kind == "Pod" && podNames[involved.Name]:
+		case kind.kind == "Deployment" && involved.Kind == "ReplicaSet" && strings.HasPrefix(involved.Name, options.Name+"-"):
+		default:
+			continue
+		}
+
+		event.ManagedFields = nil
+		events = append(events, event)
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		return eventTime(events[i]).Before(eventTime(events[j]))
+	})
+
+	data, err := yaml.Marshal(events)
+	if err != nil {
+		return b.addError("events.yaml", err)
+	}
+	return b.add("events.yaml", data)
+}
+
+// addRolloutStatus adds the rollout status of the workload, if its kind has a rollout status.
+func (b *bundle) addRolloutStatus(kind workloadKind, workload []byte) error {
+	if kind.rolloutKind == "" || workload == nil {
+		return nil
+	}
+
+	status, err := rollout.GetStatus(kind.rolloutKind, workload)
+	if err != nil {
+		return b.addError("rollout-status.txt", err)
+	}
+	return b.add("rollout-status.txt", []byte(fmt.Sprintf("%s: %s\n", status.Op, status.Message)))
+}
+
+// addLogs adds the current or previous logs of a container. The logs are limited to the tail lines and the maximum log
+// bytes from the options, when the limit is reached a note is added to the end of the file.
+func (b *bundle) addLogs(ctx context.Context, clientset kubernetes.Interface, pod corev1.Pod, container string, previous bool, options Options, file string) error {
+	data, err := clientset.CoreV1().Pods(pod.Namespace).GetLogs(pod.Name, &corev1.PodLogOptions{
+		Container:  container,
+		Previous:   previous,
+		TailLines:  &options.TailLines,
+		LimitBytes: &options.MaxLogBytes,
+	}).DoRaw(ctx)
+	if err != nil {
+		return b.addError(file, err)
+	}
+
+	if int64(len(data)) >= options.MaxLogBytes {
+		data = append(data, []byte(fmt.Sprintf("\n========== logs truncated after %d bytes ==========\n", options.MaxLogBytes))...)
+	}
+	return b.add(file, data)
+}
+
+// addObject adds a JSON encoded object as YAML file, without the managed fields and the last applied configuration.
+func (b *bundle) addObject(file string, data []byte) error {
+	var object map[string]interface{}
+	if err := json.Unmarshal(data, &object); err != nil {
+		return b.addError(file, err)
+	}
+
+	if metadata, ok := object["metadata"].(map[string]interface{}); ok {
+		delete(metadata, "managedFields")
+		if annotations, ok := metadata["annotations"].(map[string]interface{}); ok {
+			delete(annotations, lastAppliedAnnotation)
+			if len(annotations) == 0 {
+				delete(metadata, "annotations")
+			}
+		}
+	}
+
+	yamlData, err := yaml.Marshal(object)
+	if err != nil {
+		return b.addError(file, err)
+	}
+	return b.add(file, yamlData)
+}
+
+// add adds a file to the archive. When the file would exceed the maximum size of the bundle, an error note is added
+// instead.
+func (b *bundle) add(file string, data []byte) error {
+	if b.summary.Size+int64(len(data)) > b.maxSize {
+		b.summary.Truncated = true
+		return b.addError(file, fmt.Errorf("skipped, because the bundle would exceed the maximum size of %d bytes", b.maxSize))
+	}
+
+	if err := writeFile(b.archive, file, data, b.summary.CreatedAt); err != nil {
+		return err
+	}
+	b.summary.Size += int64(len(data))
+	b.summary.Files = append(b.summary.Files, file)
+	return nil
+}
+
+// addError adds the error note "<file>.error.txt" for an item, which could not be collected. Only errors while writing
+// the archive are returned.
+func (b *bundle) addError(file string, err error) error {
+	note := file + ".error.txt"
+	data := []byte(fmt.Sprintf("Could not collect %s: %s\n", file, err.Error()))
+
+	if err := writeFile(b.archive, note, data, b.summary.CreatedAt); err != nil {
+		return err
+	}
+	b.summary.Size += int64(len(data))
+	b.summary.Files = append(b.summary.Files, note)
+	b.summary.Errors = append(b.summary.Errors, FileError{File: note, Error: err.Error()})
+	return nil
+}
+
+func (b *bundle) step(item string) {
+	b.done++
+	if b.progress != nil {
+		b.progress(Progress{Item: item, Done: b.done, Total: b.total})
+	}
+}
+
+func writeFile(archive *tar.Writer, name string, data []byte, modTime time.Time) error {
+	if err := archive.WriteHeader(&tar.Header{Name: name, Mode: 0600, Size: int64(len(data)), ModTime: modTime, Typeflag: tar.TypeReg}); err != nil {
+		return fmt.Errorf("%w: %s", errArchive, err.Error())
+	}
+	if _, err := archive.Write(data); err != nil {
+		return fmt.Errorf("%w: %s", errArchive, err.Error())
+	}
+	return nil
+}
+
+// eventTime returns the time when the event was observed last. Depending on the reporter of the event, only one of
+// the timestamps is set.
+func eventTime(event corev1.Event) time.Time {
+	switch {
+	case event.Series != nil && !event.Series.LastObservedTime.IsZero():
+		return event.Series.LastObservedTime.Time
+	case !event.LastTimestamp.IsZero():
+		return event.LastTimestamp.Time
+	case !event.EventTime.IsZero():
+		return event.EventTime.Time
+	default:
+		return event.CreationTimestamp.Time
+	}
+}
@@ -0,0 +1,99 @@
+package files
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/kubenav/kubenav/pkg/server/terminal"
+
+	"k8s.io/client-go/rest"
+)
+
+// FileDownload is the result of a file, which was downloaded to the local filesystem via DownloadFile. The SHA256
+// checksum is calculated for the complete file, also when the download was resumed.
+type FileDownload struct {
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// DownloadFile downloads the file with the path from the options in the container to the given local path. The file
+// is streamed directly to the local file, so that it hasn't to be kept in memory. The "progress" function is called
+// with the number of bytes of the local file after each write.
+//
+// When the download fails, the partial file is removed, unless "resume" is true. With "resume" an existing local file
+// is continued from its current size, the remaining content is read via "tail -c +<offset>" in the container.
+func DownloadFile(ctx context.Context, config *rest.Config, options Options, localPath string, resume bool, progress func(bytes int64)) (*FileDownload, error) {
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if resume {
+		flags = os.O_CREATE | os.O_RDWR
+	}
+
+	file, err := os.OpenFile(localPath, flags, 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	hash := sha256.New()
+
+	// When the download is resumed, the existing content of the file is added to the checksum, so that the checksum
+	// is the checksum of the complete file.
+	offset, err := io.Copy(hash, file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	command := []string{"cat", "--", options.Path}
+	if offset > 0 {
+		command = []string{"tail", "-c", "+" + strconv.FormatInt(offset+1, 10), "--", options.Path}
+	}
+
+	writer := &progressWriter{w: io.MultiWriter(file, hash), written: offset, progress: progress}
+
+	var stderr bytes.Buffer
+	err = terminal.Exec(ctx, config, options.Namespace, options.Name, options.Container, command, options.Protocol, writer, &stderr)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		if !resume {
+			os.Remove(localPath)
+		}
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return nil, errors.New(message)
+		}
+		return nil, err
+	}
+
+	return &FileDownload{
+		Size:   writer.written,
+		SHA256: hex.EncodeToString(hash.Sum(nil)),
+	}, nil
+}
+
+// progressWriter counts the written bytes and reports them via the progress function.
+type progressWriter struct {
+	w        io.Writer
+	written  int64
+	progress func(bytes int64)
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.written += int64(n)
+	if w.progress != nil && n > 0 {
+		w.progress(w.written)
+	}
+	return n, err
+}
@@ -0,0 +1,302 @@
+// Package files implements a file browser for containers. The files of a directory are listed by executing "ls" in the
+// container and parsing the output, so that no additional binary is required in the container.
+package files
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kubenav/kubenav/pkg/server/terminal"
+
+	"k8s.io/client-go/rest"
+)
+
+const (
+	// DefaultMaxEntries is the default maximum number of entries, which are returned for a directory.
+	DefaultMaxEntries = 1000
+)
+
+// listScript is the shell script, which is executed in the container to list the files of a directory. The path is
+// passed as positional parameter, so that it must not be escaped for the shell. We try the GNU ls first, then the
+// busybox ls with full timestamps and finally a plain "ls -la", which is supported by all implementations. The output
+// is limited via head, so that we do not transfer the complete listing of huge directories.
+const listScript = `p="$1"; [ -d "$p" ] && [ "${p%/}" = "$p" ] && p="$p/"; ` +
+	`{ ls -la --time-style=full-iso -- "$p" 2>/dev/null || ls -lae -- "$p" 2>/dev/null || ls -la -- "$p"; } | head -n "$2"`
+
+// Options are the options to list the files of a directory in a container.
+type Options struct {
+	Namespace  string
+	Name       string
+	Container  string
+	Path       string
+	MaxEntries int
+	Protocol   string
+}
+
+// OptionsFromQuery returns the options from the "namespace", "name", "container", "path" and "maxEntries" query
+// parameters. The path must be an absolute path and is cleaned, so that the returned paths of the entries are
+// normalized. If the path is empty the root directory is listed.
+func OptionsFromQuery(query url.Values) (Options, error) {
+	options := Options{
+		Namespace:  query.Get("namespace"),
+		Name:       query.Get("name"),
+		Container:  query.Get("container"),
+		Path:       query.Get("path"),
+		MaxEntries: DefaultMaxEntries,
+	}
+
+	if options.Namespace == "" || options.Name == "" {
+		return options, fmt.Errorf("namespace and name are required")
+	}
+
+	cleanedPath, err := CleanPath(options.Path)
+	if err != nil {
+		return options, err
+	}
+	options.Path = cleanedPath
+
+	if maxEntries := query.Get("maxEntries"); maxEntries != "" {
+		parsedMaxEntries, err := strconv.Atoi(maxEntries)
+		if err != nil || parsedMaxEntries <= 0 {
+			return options, fmt.Errorf("invalid maxEntries %s", maxEntries)
+		}
+		options.MaxEntries = parsedMaxEntries
+	}
+
+	return options, nil
+}
+
+// CleanPath validates and cleans the given path. The path must be absolute and must not contain a null byte, because
+// it is passed as argument to a process in the container. An empty path is the root directory.
+func CleanPath(value string) (string, error) {
+	if value == "" {
+		return "/", nil
+	}
+
+	if !strings.HasPrefix(value, "/") {
+		return "", fmt.Errorf("path must be absolute")
+	}
+	if strings.ContainsRune(value, 0) {
+		return "", fmt.Errorf("path contains invalid characters")
+	}
+
+	return path.Clean(value), nil
+}
+
+// Entry is a single file or directory of a listing. The ModTime is formatted as RFC3339 timestamp and is empty, when
+// it could not be parsed. When the entry is a symbolic link, the link target is set.
+type Entry struct {
+	Name       string `json:"name"`
+	Path       string `json:"path"`
+	Size       int64  `json:"size"`
+	Mode       string `json:"mode"`
+	ModTime    string `json:"modTime,omitempty"`
+	LinkTarget string `json:"linkTarget,omitempty"`
+	Dir        bool   `json:"dir"`
+}
+
+// Listing is the content of a directory. When the directory contains more entries than the maximum number of entries,
+// the listing is truncated.
+type Listing struct {
+	Path      string  `json:"path"`
+	Entries   []Entry `json:"entries"`
+	Truncated bool    `json:"truncated"`
+}
+
+// List lists the files of the directory from the options in the container. If the path is a file instead of a
+// directory, the listing only contains the file.
+func List(ctx context.Context, config *rest.Config, options Options) (*Listing, error) {
+	maxEntries := options.MaxEntries
+	if maxEntries <= 0 {
+		maxEntries = DefaultMaxEntries
+	}
+
+	// We request four additional lines, for the "total" line, the "." and ".." entries and one additional entry, so
+	// that we know if the listing was truncated.
+	command := []string{"sh", "-c", listScript, "sh", options.Path, strconv.Itoa(maxEntries + 4)}
+
+	var stdout, stderr bytes.Buffer
+	err := terminal.Exec(ctx, config, options.Namespace, options.Name, options.Container, command, options.Protocol, &stdout, &stderr)
+
+	entries := parseListing(options.Path, stdout.String())
+	if len(entries) == 0 && (err != nil || stderr.Len() > 0) {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return nil, errors.New(message)
+		}
+		return nil, err
+	}
+
+	listing := &Listing{Path: options.Path, Entries: entries}
+	if len(listing.Entries) > maxEntries {
+		listing.Entries = listing.Entries[:maxEntries]
+		listing.Truncated = true
+	}
+
+	return listing, nil
+}
+
+// Download writes the content of the file with the given path in the container to w. The file is read via "cat", the
+// path is passed as argument, so that it must not be escaped. When the file can not be read, the error contains the
+// error output of cat.
+func Download(ctx context.Context, config *rest.Config, options Options, w io.Writer) error {
+	var stderr bytes.Buffer
+
+	err := terminal.Exec(ctx, config, options.Namespace, options.Name, options.Container, []string{"cat", "--", options.Path}, options.Protocol, w, &stderr)
+	if err != nil {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return errors.New(message)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// parseListing parses the output of "ls -la". The "total" line and the "." and ".." entries are skipped, lines which
+// can not be parsed (e.g. error messages) are ignored.
+func parseListing(dir, output string) []Entry {
+	entries := []Entry{}
+
+	for _, line := range strings.Split(output, "\n") {
+		entry, ok := parseLine(line)
+		if !ok || entry.Name == "." || entry.Name == ".." {
+			continue
+		}
+
+		// When the path is a file, ls prints the path of the file instead of the name.
+		if strings.HasPrefix(entry.Name, "/") {
+			entry.Path = path.Clean(entry.Name)
+			entry.Name = path.Base(entry.Path)
+		} else {
+			entry.Path = path.Join(dir, entry.Name)
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries
+}
+
+// parseLine parses a single line of the output of "ls -la". The format of the timestamp depends on the ls
+// implementation and the used flags:
+//
+//	GNU (--time-style=full-iso)  2023-06-06 12:00:00.000000000 +0000
+//	busybox (-e)                 Tue Jun  6 12:00:00 2023
+//	plain                        Jun  6 12:00 or Jun  6  2022
+//
+// The name is the remaining part of the line after the timestamp, so that names with spaces are preserved.
+func parseLine(line string) (Entry, bool) {
+	fields, offsets := splitFields(line)
+	if len(fields) < 8 || len(fields[0]) < 10 || fields[0] == "total" {
+		return Entry{}, false
+	}
+
+	entry := Entry{
+		Mode: fields[0],
+		Dir:  fields[0][0] == 'd',
+	}
+
+	// The fields are mode, links, owner, group and size. For character and block devices the size is replaced by
+	// the major and minor number ("1, 3"), in this case the size is 0.
+	i := 4
+	if strings.HasSuffix(fields[i], ",") {
+		i++
+	} else if size, err := strconv.ParseInt(fields[i], 10, 64); err == nil {
+		entry.Size = size
+	}
+	i++
+
+	modTime, n := parseTime(fields[i:])
+	if n == 0 || i+n >= len(fields) {
+		return Entry{}, false
+	}
+	if !modTime.IsZero() {
+		entry.ModTime = modTime.UTC().Format(time.RFC3339)
+	}
+
+	entry.Name = line[offsets[i+n]:]
+	if entry.Mode[0] == 'l' {
+		if name, target, ok := strings.Cut(entry.Name, " -> "); ok {
+			entry.Name = name
+			entry.LinkTarget = target
+		}
+	}
+
+	return entry, true
+}
+
+// parseTime parses the timestamp at the beginning of the given fields and returns the time and the number of fields
+// used by the timestamp. If the fields do not start with a timestamp, 0 is returned.
+func parseTime(fields []string) (time.Time, int) {
+	if len(fields) >= 3 && len(fields[0]) == 10 && fields[0][4] == '-' {
+		if t, err := time.Parse("2006-01-02 15:04:05.999999999 -0700", strings.Join(fields[:3], " ")); err == nil {
+			return t, 3
+		}
+		if t, err := time.Parse("2006-01-02 15:04:05 -0700", strings.Join(fields[:3], " ")); err == nil {
+			return t, 3
+		}
+		return time.Time{}, 3
+	}
+
+	if len(fields) >= 5 {
+		if t, err := time.Parse("Mon Jan 2 15:04:05 2006", strings.Join(fields[:5], " ")); err == nil {
+			return t, 5
+		}
+	}
+
+	if len(fields) >= 3 {
+		if t, err := time.Parse("Jan 2 2006", strings.Join(fields[:3], " ")); err == nil {
+			return t, 3
+		}
+
+		// Without a year ls prints the time for files of the last six months, so that we assume the current year or
+		// the last year, if the timestamp would be in the future.
+		if t, err := time.Parse("Jan 2 15:04", strings.Join(fields[:3], " ")); err == nil {
+			now := time.Now()
+			t = t.AddDate(now.Year(), 0, 0)
+			if t.After(now) {
+				t = t.AddDate(-1, 0, 0)
+			}
+			return t, 3
+		}
+	}
+
+	return time.Time{}, 0
+}
+
+// splitFields splits the line at whitespace and returns the fields and the offset of each field in the line.
+func splitFields(line string) ([]string, []int) {
+	var fields []string
+	var offsets []int
+
+	start := -1
+	for i := 0; i < len(line); i++ {
+		if line[i] == ' ' || line[i] == '\t' {
+			if start >= 0 {
+				fields = append(fields, line[start:i])
+				offsets = append(offsets, start)
+				start = -1
+			}
+			continue
+		}
+
+		if start < 0 {
+			start = i
+		}
+	}
+
+	if start >= 0 {
+		fields = append(fields, line[start:])
+		offsets = append(offsets, start)
+	}
+
+	return fields, offsets
+}
@@ -0,0 +1,145 @@
+package files
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/kubenav/kubenav/pkg/server/terminal"
+
+	"k8s.io/client-go/rest"
+)
+
+// uploadExistsExitCode is the exit code of the upload script, when the file already exists and should not be
+// overwritten.
+const uploadExistsExitCode = 17
+
+// uploadScript is the shell script, which is executed in the container to extract the uploaded file. The directory,
+// the name of the file and the flags are passed as positional parameters, so that they must not be escaped for the
+// shell. The file is send as tar archive via stdin, so that we can preserve the mode of the file.
+const uploadScript = `d="$1"; f="$2"; ` +
+	`if [ "$3" = "true" ]; then mkdir -p -- "$d" || exit 1; fi; ` +
+	`if [ "$4" != "true" ] && [ -e "${d%/}/$f" ]; then echo "${d%/}/$f already exists" >&2; exit 17; fi; ` +
+	`tar -xmf - -C "$d"`
+
+// UploadOptions are the options to upload a local file into a container. If "Mode" is 0, the mode of the local file is
+// used. With "CreateDirs" the missing parent directories of the remote path are created and with "Overwrite" an
+// existing file is replaced.
+type UploadOptions struct {
+	Mode       int64
+	CreateDirs bool
+	Overwrite  bool
+}
+
+// FileUpload is the result of UploadFile. The "ExitCode" is the exit code of the command in the container, if the
+// command failed the "Error" field contains the error message and "BytesSent" the number of bytes of the local file,
+// which were sent before the command failed.
+type FileUpload struct {
+	BytesSent int64  `json:"bytesSent"`
+	ExitCode  int    `json:"exitCode"`
+	Error     string `json:"error,omitempty"`
+}
+
+// UploadFile uploads the file from the local path to the path from the options in the container. The file is streamed
+// as tar archive to the stdin of "tar" in the container, so that it hasn't to be kept in memory. The "progress"
+// function is called with the number of sent bytes of the file after each read.
+//
+// Errors for the local file are returned as error. When the command in the container fails, the result contains the
+// exit code and the error message. If the command fails after the file was partially transferred, the partial file is
+// removed from the container.
+func UploadFile(ctx context.Context, config *rest.Config, options Options, localPath string, uploadOptions UploadOptions, progress func(bytes int64)) (*FileUpload, error) {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("%s is not a regular file", localPath)
+	}
+
+	dir, name := path.Split(options.Path)
+	if name == "" {
+		return nil, fmt.Errorf("remote path must be a file")
+	}
+
+	mode := uploadOptions.Mode
+	if mode == 0 {
+		mode = int64(info.Mode().Perm())
+	}
+
+	reader := &progressReader{r: file, progress: progress}
+
+	// The tar archive is written to a pipe, which is used as stdin for the command in the container. When the command
+	// fails, the stdin stream is closed and the pipe is closed with an error, so that the goroutine is stopped.
+	pr, pw := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		tw := tar.NewWriter(pw)
+		err := tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     name,
+			Size:     info.Size(),
+			Mode:     mode,
+			ModTime:  info.ModTime(),
+		})
+		if err == nil {
+			_, err = io.CopyN(tw, reader, info.Size())
+		}
+		if err == nil {
+			err = tw.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+
+	var stderr bytes.Buffer
+	command := []string{"sh", "-c", uploadScript, "sh", dir, name, fmt.Sprintf("%t", uploadOptions.CreateDirs), fmt.Sprintf("%t", uploadOptions.Overwrite)}
+	err = terminal.ExecWithStdin(ctx, config, options.Namespace, options.Name, options.Container, command, options.Protocol, pr, io.Discard, &stderr)
+	pr.CloseWithError(io.ErrClosedPipe)
+	<-done
+
+	exitCode, message := terminal.GetExitStatus(err)
+	if err == nil {
+		return &FileUpload{BytesSent: reader.read, ExitCode: exitCode}, nil
+	}
+
+	if stderrMessage := strings.TrimSpace(stderr.String()); stderrMessage != "" {
+		message = stderrMessage
+	}
+
+	// When the file already existed, the transfer was never started, so that we must not remove the file.
+	if exitCode != uploadExistsExitCode && reader.read > 0 {
+		cleanupCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		terminal.Exec(cleanupCtx, config, options.Namespace, options.Name, options.Container, []string{"rm", "-f", "--", options.Path}, options.Protocol, io.Discard, io.Discard)
+	}
+
+	return &FileUpload{BytesSent: reader.read, ExitCode: exitCode, Error: message}, nil
+}
+
+// progressReader counts the read bytes and reports them via the progress function.
+type progressReader struct {
+	r        io.Reader
+	read     int64
+	progress func(bytes int64)
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.read += int64(n)
+	if r.progress != nil && n > 0 {
+		r.progress(r.read)
+	}
+	return n, err
+}
@@ -0,0 +1,308 @@
+package files
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/kubenav/kubenav/pkg/server/terminal"
+
+	"github.com/gorilla/websocket"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	remotecommandconsts "k8s.io/apimachinery/pkg/util/remotecommand"
+	"k8s.io/client-go/rest"
+)
+
+// The channels of the "v4.channel.k8s.io" protocol.
+const (
+	channelStdin  = 0
+	channelStdout = 1
+	channelStderr = 2
+	channelError  = 3
+)
+
+// fakeExec emulates the exec endpoint of the API server for the upload script via the "v4.channel.k8s.io" protocol.
+// The tar archive from stdin is extracted into a sink, which only counts the bytes of the file. When exists is true,
+// the upload fails with the exit code of the script for an existing file, unless the file should be overwritten. When
+// failAfter is set, the upload fails with exit code 2 after the given number of bytes was received. All other
+// commands (e.g. "rm") succeed without doing anything.
+type fakeExec struct {
+	exists    bool
+	failAfter int64
+
+	lock     sync.Mutex
+	commands [][]string
+	header   *tar.Header
+	received int64
+}
+
+func (f *fakeExec) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	upgrader := websocket.Upgrader{Subprotocols: []string{remotecommandconsts.StreamProtocolV4Name}}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	command := r.URL.Query()["command"]
+	f.lock.Lock()
+	f.commands = append(f.commands, command)
+	f.lock.Unlock()
+
+	exit := func(stderr string, exitCode int) {
+		if stderr != "" {
+			conn.WriteMessage(websocket.BinaryMessage, append([]byte{channelStderr}, stderr...))
+		}
+		conn.WriteMessage(websocket.BinaryMessage, append([]byte{channelError}, status(exitCode)...))
+		conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	}
+
+	if len(command) != 8 || command[2] != uploadScript {
+		exit("", 0)
+		return
+	}
+
+	dir, name, overwrite := command[4], command[5], command[7]
+	if f.exists && overwrite != "true" {
+		exit(fmt.Sprintf("%s already exists\n", dir+name), uploadExistsExitCode)
+		return
+	}
+
+	// The stdin frames are written to a pipe, which is read by the tar reader, so that the file is never buffered.
+	pr, pw := io.Pipe()
+	defer pr.Close()
+	go func() {
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			if len(data) > 1 && data[0] == channelStdin {
+				if _, err := pw.Write(data[1:]); err != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	tr := tar.NewReader(pr)
+	header, err := tr.Next()
+	if err != nil {
+		exit(err.Error(), 1)
+		return
+	}
+
+	f.lock.Lock()
+	f.header = header
+	f.lock.Unlock()
+
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := tr.Read(buf)
+
+		f.lock.Lock()
+		f.received += int64(n)
+		received := f.received
+		f.lock.Unlock()
+
+		if f.failAfter > 0 && received >= f.failAfter {
+			exit("no space left on device\n", 2)
+			return
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			exit(err.Error(), 1)
+			return
+		}
+	}
+
+	if _, err := tr.Next(); err != io.EOF {
+		exit("unexpected entry in archive", 1)
+		return
+	}
+
+	exit("", 0)
+}
+
+func (f *fakeExec) getCommands() [][]string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return append([][]string(nil), f.commands...)
+}
+
+func status(exitCode int) []byte {
+	status := metav1.Status{Status: metav1.StatusSuccess}
+	if exitCode != 0 {
+		status = metav1.Status{
+			Status: metav1.StatusFailure,
+			Reason: remotecommandconsts.NonZeroExitCodeReason,
+			Details: &metav1.StatusDetails{
+				Causes: []metav1.StatusCause{{Type: remotecommandconsts.ExitCodeCauseType, Message: fmt.Sprintf("%d", exitCode)}},
+			},
+			Message: fmt.Sprintf("command terminated with non-zero exit code: %d", exitCode),
+		}
+	}
+
+	data, _ := json.Marshal(status)
+	return data
+}
+
+func newUploadTest(t *testing.T, f *fakeExec, size int64) (*rest.Config, Options, string) {
+	t.Helper()
+
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+
+	// The local file is a sparse file, so that the test doesn't write the whole file to the disk.
+	localPath := filepath.Join(t.TempDir(), "upload")
+	file, err := os.Create(localPath)
+	if err != nil {
+		t.Fatalf("could not create file: %v", err)
+	}
+	if err := file.Truncate(size); err != nil {
+		t.Fatalf("could not resize file: %v", err)
+	}
+	file.Close()
+
+	options := Options{Namespace: "default", Name: "nginx", Container: "nginx", Path: "/tmp/upload.bin", Protocol: terminal.ProtocolWebSocket}
+	return &rest.Config{Host: srv.URL}, options, localPath
+}
+
+func TestUploadFileStreaming(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping upload of a large file in short mode")
+	}
+
+	const size = 100*1024*1024 + 123
+
+	f := &fakeExec{}
+	config, options, localPath := newUploadTest(t, f, size)
+
+	runtime.GC()
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	baseline := stats.HeapAlloc
+	peak := baseline
+
+	var lastProgress, lastSample int64
+	upload, err := UploadFile(context.Background(), config, options, localPath, UploadOptions{Mode: 0o600}, func(bytes int64) {
+		lastProgress = bytes
+		if bytes-lastSample >= 8*1024*1024 {
+			lastSample = bytes
+			runtime.ReadMemStats(&stats)
+			if stats.HeapAlloc > peak {
+				peak = stats.HeapAlloc
+			}
+		}
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if upload.ExitCode != 0 || upload.Error != "" || upload.BytesSent != size {
+		t.Errorf("expected successful upload of %d bytes, got %+v", size, upload)
+	}
+	if lastProgress != size {
+		t.Errorf("expected progress of %d bytes, got %d", size, lastProgress)
+	}
+
+	f.lock.Lock()
+	header, received := f.header, f.received
+	f.lock.Unlock()
+	if received != size || header.Name != "upload.bin" || header.Mode != 0o600 || header.Size != size {
+		t.Errorf("expected upload.bin with mode 600 and %d bytes, got %s with mode %o and %d bytes", size, header.Name, header.Mode, received)
+	}
+
+	// The file must be streamed, so that the heap only grows by the buffers of the streams and not by the size of the
+	// file.
+	if growth := int64(peak) - int64(baseline); growth > 32*1024*1024 {
+		t.Errorf("expected heap growth below 32 MiB, got %d MiB", growth/1024/1024)
+	}
+}
+
+func TestUploadFileExists(t *testing.T) {
+	f := &fakeExec{exists: true}
+	config, options, localPath := newUploadTest(t, f, 1024)
+
+	upload, err := UploadFile(context.Background(), config, options, localPath, UploadOptions{}, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if upload.ExitCode != uploadExistsExitCode || upload.Error != "/tmp/upload.bin already exists" {
+		t.Errorf("expected exit code %d with already exists error, got %+v", uploadExistsExitCode, upload)
+	}
+
+	// The existing file must not be removed.
+	if commands := f.getCommands(); len(commands) != 1 {
+		t.Errorf("expected only the upload command, got %q", commands)
+	}
+}
+
+func TestUploadFileOverwrite(t *testing.T) {
+	f := &fakeExec{exists: true}
+	config, options, localPath := newUploadTest(t, f, 1024)
+
+	upload, err := UploadFile(context.Background(), config, options, localPath, UploadOptions{Overwrite: true, CreateDirs: true}, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if upload.ExitCode != 0 || upload.BytesSent != 1024 {
+		t.Errorf("expected successful upload, got %+v", upload)
+	}
+	if commands := f.getCommands(); len(commands) != 1 || strings.Join(commands[0][4:], ",") != "/tmp/,upload.bin,true,true" {
+		t.Errorf("expected upload command with the directory, name and flags, got %q", commands)
+	}
+}
+
+func TestUploadFileCleanup(t *testing.T) {
+	f := &fakeExec{failAfter: 1024 * 1024}
+	config, options, localPath := newUploadTest(t, f, 4*1024*1024)
+
+	upload, err := UploadFile(context.Background(), config, options, localPath, UploadOptions{}, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if upload.ExitCode != 2 || upload.Error != "no space left on device" || upload.BytesSent == 0 {
+		t.Errorf("expected exit code 2 with stderr message after a partial transfer, got %+v", upload)
+	}
+
+	// The partial file must be removed from the container.
+	commands := f.getCommands()
+	if len(commands) != 2 || strings.Join(commands[1], " ") != "rm -f -- /tmp/upload.bin" {
+		t.Errorf("expected the upload and the rm command, got %q", commands)
+	}
+}
+
+func TestUploadFileInvalidPath(t *testing.T) {
+	f := &fakeExec{}
+	config, options, localPath := newUploadTest(t, f, 1024)
+
+	options.Path = "/tmp/"
+	if _, err := UploadFile(context.Background(), config, options, localPath, UploadOptions{}, nil); err == nil {
+		t.Errorf("expected error for a directory as remote path")
+	}
+
+	if _, err := UploadFile(context.Background(), config, Options{Path: "/tmp/upload.bin"}, filepath.Dir(localPath), UploadOptions{}, nil); err == nil {
+		t.Errorf("expected error for a directory as local path")
+	}
+
+	if commands := f.getCommands(); len(commands) != 0 {
+		t.Errorf("expected no commands, got %q", commands)
+	}
+}
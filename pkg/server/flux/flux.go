@@ -0,0 +1,244 @@
+// Package flux implements the status and the reconciliation of Flux resources. The Flux CRDs are detected via the
+// discovery data of a cluster, so that clusters without Flux can be reported as such.
+package flux
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/kubenav/kubenav/pkg/server/resources"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
+)
+
+// The kinds of the Flux resources, which are supported.
+const (
+	KindKustomization = "Kustomization"
+	KindHelmRelease   = "HelmRelease"
+	KindGitRepository = "GitRepository"
+)
+
+// The errors of the flux package. ErrNotInstalled is returned, when the CRD of a Flux kind isn't installed in a
+// cluster, ErrInvalidOptions for invalid options and ErrSuspended, when a suspended resource should be reconciled.
+var (
+	ErrNotInstalled   = errors.New("flux is not installed")
+	ErrInvalidOptions = fmt.Errorf("%w", resources.ErrInvalidOptions)
+	ErrSuspended      = errors.New("resource is suspended")
+)
+
+// kind is a supported kind of Flux. Only Kustomizations and HelmReleases can be suspended via kubenav.
+type kind struct {
+	group       string
+	kind        string
+	suspendable bool
+}
+
+var kinds = []kind{
+	{group: "kustomize.toolkit.fluxcd.io", kind: KindKustomization, suspendable: true},
+	{group: "helm.toolkit.fluxcd.io", kind: KindHelmRelease, suspendable: true},
+	{group: "source.toolkit.fluxcd.io", kind: KindGitRepository},
+}
+
+// Resource is the status of a Flux resource. The "revision" is the last applied revision for Kustomizations and
+// HelmReleases and the revision of the artifact for sources. The "ready" field is the status of the "Ready" condition
+// ("True", "False" or "Unknown").
+type Resource struct {
+	APIVersion             string `json:"apiVersion"`
+	Kind                   string `json:"kind"`
+	Namespace              string `json:"namespace"`
+	Name                   string `json:"name"`
+	Suspended              bool   `json:"suspended"`
+	Ready                  string `json:"ready"`
+	Reason                 string `json:"reason,omitempty"`
+	Message                string `json:"message,omitempty"`
+	Revision               string `json:"revision,omitempty"`
+	LastAttemptedRevision  string `json:"lastAttemptedRevision,omitempty"`
+	LastHandledReconcileAt string `json:"lastHandledReconcileAt,omitempty"`
+	Generation             int64  `json:"generation"`
+	ObservedGeneration     int64  `json:"observedGeneration"`
+}
+
+// ListResult contains the Flux resources of a cluster. The "kinds" are the Flux kinds, which are installed in the
+// cluster. If Flux isn't installed, "installed" is false.
+type ListResult struct {
+	Installed bool       `json:"installed"`
+	Kinds     []string   `json:"kinds"`
+	Resources []Resource `json:"resources"`
+}
+
+// object contains the fields of a Flux resource, which are used for the status.
+type object struct {
+	APIVersion string            `json:"apiVersion"`
+	Kind       string            `json:"kind"`
+	Metadata   metav1.ObjectMeta `json:"metadata"`
+	Spec       struct {
+		Suspend bool `json:"suspend"`
+	} `json:"spec"`
+	Status struct {
+		ObservedGeneration     int64              `json:"observedGeneration"`
+		Conditions             []metav1.Condition `json:"conditions"`
+		LastAppliedRevision    string             `json:"lastAppliedRevision"`
+		LastAttemptedRevision  string             `json:"lastAttemptedRevision"`
+		LastHandledReconcileAt string             `json:"lastHandledReconcileAt"`
+		Artifact               *struct {
+			Revision string `json:"revision"`
+		} `json:"artifact"`
+		History []struct {
+			ChartVersion string `json:"chartVersion"`
+		} `json:"history"`
+	} `json:"status"`
+}
+
+func (o *object) resource() Resource {
+	r := Resource{
+		APIVersion:             o.APIVersion,
+		Kind:                   o.Kind,
+		Namespace:              o.Metadata.Namespace,
+		Name:                   o.Metadata.Name,
+		Suspended:              o.Spec.Suspend,
+		Ready:                  string(metav1.ConditionUnknown),
+		Revision:               o.Status.LastAppliedRevision,
+		LastAttemptedRevision:  o.Status.LastAttemptedRevision,
+		LastHandledReconcileAt: o.Status.LastHandledReconcileAt,
+		Generation:             o.Metadata.Generation,
+		ObservedGeneration:     o.Status.ObservedGeneration,
+	}
+
+	// Sources report their revision via the artifact. HelmReleases of the "v2" API do not have the
+	// "lastAppliedRevision" field anymore, instead the chart version of the latest release in the history is used.
+	if r.Revision == "" && o.Status.Artifact != nil {
+		r.Revision = o.Status.Artifact.Revision
+	}
+	if r.Revision == "" && len(o.Status.History) > 0 {
+		r.Revision = o.Status.History[0].ChartVersion
+	}
+
+	if condition := meta.FindStatusCondition(o.Status.Conditions, "Ready"); condition != nil {
+		r.Ready = string(condition.Status)
+		r.Reason = condition.Reason
+		r.Message = condition.Message
+	}
+
+	return r
+}
+
+// resolveKind resolves the resource of the given Flux kind via the discovery data. If the CRD of the kind isn't
+// installed, false is returned.
+func resolveKind(cache *resources.DiscoveryCache, clusterKey string, clientset kubernetes.Interface, k kind) (resources.ResourceInfo, bool, error) {
+	info, err := cache.ResolveKind(clusterKey, clientset, schema.GroupVersionKind{Group: k.group, Kind: k.kind})
+	if err != nil {
+		if meta.IsNoMatchError(err) {
+			return info, false, nil
+		}
+		return info, false, err
+	}
+
+	return info, true, nil
+}
+
+// getKind returns the supported kind with the given name.
+func getKind(name string) (kind, error) {
+	for _, k := range kinds {
+		if k.kind == name {
+			return k, nil
+		}
+	}
+
+	return kind{}, fmt.Errorf("%w: unsupported kind %s", ErrInvalidOptions, name)
+}
+
+// List lists the Flux resources of the given kind in the namespace. If the kind is empty, the resources of all
+// supported kinds are returned and if the namespace is empty, the resources of all namespaces are returned. Kinds,
+// which are not installed in the cluster, are skipped.
+func List(ctx context.Context, cache *resources.DiscoveryCache, clusterKey string, clientset kubernetes.Interface, namespace, kindName string) (*ListResult, error) {
+	selected := kinds
+	if kindName != "" {
+		k, err := getKind(kindName)
+		if err != nil {
+			return nil, err
+		}
+		selected = []kind{k}
+	}
+
+	result := &ListResult{Kinds: []string{}, Resources: []Resource{}}
+
+	for _, k := range kinds {
+		if _, installed, err := resolveKind(cache, clusterKey, clientset, k); err != nil {
+			return nil, err
+		} else if installed {
+			result.Installed = true
+			result.Kinds = append(result.Kinds, k.kind)
+		}
+	}
+	if !result.Installed {
+		return result, nil
+	}
+
+	for _, k := range selected {
+		info, installed, err := resolveKind(cache, clusterKey, clientset, k)
+		if err != nil {
+			return nil, err
+		}
+		if !installed {
+			continue
+		}
+
+		data, err := clientset.CoreV1().RESTClient().Get().AbsPath(resources.Path(info, namespace)).DoRaw(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		var list struct {
+			Items []object `json:"items"`
+		}
+		if err := json.Unmarshal(data, &list); err != nil {
+			return nil, err
+		}
+
+		for i := range list.Items {
+			// The items of a list do not contain the api version and kind, so that we have to set them.
+			list.Items[i].APIVersion = schema.GroupVersion{Group: info.Group, Version: info.Version}.String()
+			list.Items[i].Kind = info.Kind
+			result.Resources = append(result.Resources, list.Items[i].resource())
+		}
+	}
+
+	sort.SliceStable(result.Resources, func(i, j int) bool {
+		a, b := result.Resources[i], result.Resources[j]
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return a.Name < b.Name
+	})
+
+	return result, nil
+}
+
+// get returns the Flux resource with the given kind, namespace and name and the resolved resource information.
+func get(ctx context.Context, cache *resources.DiscoveryCache, clusterKey string, clientset kubernetes.Interface, k kind, namespace, name string) (*object, resources.ResourceInfo, error) {
+	info, installed, err := resolveKind(cache, clusterKey, clientset, k)
+	if err != nil {
+		return nil, info, err
+	}
+	if !installed {
+		return nil, info, fmt.Errorf("%w: the CRD for %s is not installed", ErrNotInstalled, k.kind)
+	}
+
+	data, err := clientset.CoreV1().RESTClient().Get().AbsPath(resources.ObjectPath(info, namespace, name)).DoRaw(ctx)
+	if err != nil {
+		return nil, info, err
+	}
+
+	var obj object
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return nil, info, err
+	}
+
+	return &obj, info, nil
+}
@@ -0,0 +1,192 @@
+package flux
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/kubenav/kubenav/pkg/server/resources"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// requestedAtAnnotation is the annotation, which requests a reconciliation of a Flux resource. The controllers of
+// Flux set the "lastHandledReconcileAt" field in the status to the value of the annotation, when they handled the
+// request.
+const requestedAtAnnotation = "reconcile.fluxcd.io/requestedAt"
+
+// The timeouts for waiting until a reconciliation is finished.
+const (
+	defaultWaitTimeout = 2 * time.Minute
+	maxWaitTimeout     = 5 * time.Minute
+	pollInterval       = 2 * time.Second
+)
+
+// ReconcileOptions are the options to trigger the reconciliation of a Flux resource. If "wait" is true, we wait until
+// the reconciliation is finished or the "timeout" (e.g. "2m") is exceeded.
+type ReconcileOptions struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Wait      bool   `json:"wait"`
+	Timeout   string `json:"timeout"`
+}
+
+// SuspendOptions are the options to suspend or resume a Kustomization or a HelmRelease.
+type SuspendOptions struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Suspend   bool   `json:"suspend"`
+}
+
+// ReconcileResult is the result of a reconciliation. The "requestedAt" is the value of the annotation, which was set.
+// When we waited for the reconciliation, "finished" is true if the reconciliation was handled by Flux before the
+// timeout and the resource contains the status after the reconciliation.
+type ReconcileResult struct {
+	RequestedAt string   `json:"requestedAt"`
+	Waited      bool     `json:"waited"`
+	Finished    bool     `json:"finished"`
+	Resource    Resource `json:"resource"`
+}
+
+// Reconcile triggers the reconciliation of a Flux resource, by setting the "reconcile.fluxcd.io/requestedAt"
+// annotation to the current time, like it is done by the Flux CLI. Suspended resources can not be reconciled.
+func Reconcile(ctx context.Context, cache *resources.DiscoveryCache, clusterKey string, clientset kubernetes.Interface, options ReconcileOptions) (*ReconcileResult, error) {
+	k, err := getKind(options.Kind)
+	if err != nil {
+		return nil, err
+	}
+	if options.Namespace == "" || options.Name == "" {
+		return nil, fmt.Errorf("%w: namespace and name are required", ErrInvalidOptions)
+	}
+
+	timeout := defaultWaitTimeout
+	if options.Timeout != "" {
+		timeout, err = time.ParseDuration(options.Timeout)
+		if err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("%w: invalid timeout %s", ErrInvalidOptions, options.Timeout)
+		}
+		if timeout > maxWaitTimeout {
+			timeout = maxWaitTimeout
+		}
+	}
+
+	obj, info, err := get(ctx, cache, clusterKey, clientset, k, options.Namespace, options.Name)
+	if err != nil {
+		return nil, err
+	}
+	if obj.Spec.Suspend {
+		return nil, fmt.Errorf("%w: %s %s/%s", ErrSuspended, k.kind, options.Namespace, options.Name)
+	}
+
+	requestedAt := time.Now().Format(time.RFC3339Nano)
+	obj, err = patch(ctx, clientset, info, options.Namespace, options.Name, map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{requestedAtAnnotation: requestedAt},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ReconcileResult{RequestedAt: requestedAt, Waited: options.Wait, Resource: obj.resource()}
+	if !options.Wait {
+		return result, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return result, nil
+		case <-ticker.C:
+		}
+
+		obj, _, err := get(ctx, cache, clusterKey, clientset, k, options.Namespace, options.Name)
+		if err != nil {
+			if ctx.Err() != nil {
+				return result, nil
+			}
+			return nil, err
+		}
+
+		result.Resource = obj.resource()
+		if isReconciled(result.Resource, requestedAt) {
+			result.Finished = true
+			return result, nil
+		}
+	}
+}
+
+// isReconciled returns true, when Flux handled the reconciliation request and the "Ready" condition isn't "Unknown"
+// anymore, which is the case while the reconciliation is in progress.
+func isReconciled(resource Resource, requestedAt string) bool {
+	return resource.LastHandledReconcileAt == requestedAt && resource.ObservedGeneration >= resource.Generation && resource.Ready != string(metav1.ConditionUnknown)
+}
+
+// Suspend suspends or resumes a Kustomization or HelmRelease via the "spec.suspend" field. Like the Flux CLI, a
+// reconciliation is requested when a resource is resumed, so that the changes made while the resource was suspended
+// are applied.
+func Suspend(ctx context.Context, cache *resources.DiscoveryCache, clusterKey string, clientset kubernetes.Interface, options SuspendOptions) (*Resource, error) {
+	k, err := getKind(options.Kind)
+	if err != nil {
+		return nil, err
+	}
+	if !k.suspendable {
+		return nil, fmt.Errorf("%w: %s can not be suspended", ErrInvalidOptions, k.kind)
+	}
+	if options.Namespace == "" || options.Name == "" {
+		return nil, fmt.Errorf("%w: namespace and name are required", ErrInvalidOptions)
+	}
+
+	_, info, err := get(ctx, cache, clusterKey, clientset, k, options.Namespace, options.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	body := map[string]interface{}{
+		"spec": map[string]interface{}{"suspend": options.Suspend},
+	}
+	if !options.Suspend {
+		body["metadata"] = map[string]interface{}{
+			"annotations": map[string]string{requestedAtAnnotation: time.Now().Format(time.RFC3339Nano)},
+		}
+	}
+
+	obj, err := patch(ctx, clientset, info, options.Namespace, options.Name, body)
+	if err != nil {
+		return nil, err
+	}
+
+	resource := obj.resource()
+	return &resource, nil
+}
+
+// patch applies the given JSON merge patch to the Flux resource and returns the patched resource.
+func patch(ctx context.Context, clientset kubernetes.Interface, info resources.ResourceInfo, namespace, name string, body map[string]interface{}) (*object, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err = clientset.CoreV1().RESTClient().Patch(types.MergePatchType).AbsPath(resources.ObjectPath(info, namespace, name)).Param("fieldManager", resources.FieldManager).Body(data).DoRaw(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var obj object
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return nil, err
+	}
+
+	return &obj, nil
+}
@@ -0,0 +1,237 @@
+// Package gatekeeper implements a summary of the audit violations of Gatekeeper. The constraint kinds are created by
+// Gatekeeper for each constraint template, so that they are discovered via the "constraints.gatekeeper.sh" group.
+package gatekeeper
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+
+	"github.com/kubenav/kubenav/pkg/server/resources"
+
+	"k8s.io/client-go/kubernetes"
+)
+
+// constraintsGroup is the group of all constraint kinds of Gatekeeper.
+const constraintsGroup = "constraints.gatekeeper.sh"
+
+// defaultEnforcementAction is the enforcement action of a constraint, when it isn't set in the spec.
+const defaultEnforcementAction = "deny"
+
+// Summary contains all constraints and their violations. The violations are also counted per namespace and kind of
+// the violating objects, cluster-scoped objects are not counted per namespace. If Gatekeeper isn't installed, "installed" is false. The "truncated" field is true, when at
+// least one constraint has more violations than stored in its status (see the "--constraint-violations-limit" flag of
+// Gatekeeper), so that the counts are lower bounds.
+type Summary struct {
+	Installed       bool         `json:"installed"`
+	TotalViolations int64        `json:"totalViolations"`
+	Truncated       bool         `json:"truncated"`
+	Constraints     []Constraint `json:"constraints"`
+	Violations      []Violation  `json:"violations"`
+	ByNamespace     []Count      `json:"byNamespace"`
+	ByKind          []Count      `json:"byKind"`
+	Warnings        []string     `json:"warnings,omitempty"`
+}
+
+// Constraint is a single constraint. The "totalViolations" is the number of violations found by the last audit, the
+// "violations" is the number of violations stored in the status.
+type Constraint struct {
+	Kind              string `json:"kind"`
+	Name              string `json:"name"`
+	EnforcementAction string `json:"enforcementAction"`
+	TotalViolations   int64  `json:"totalViolations"`
+	Violations        int    `json:"violations"`
+	Truncated         bool   `json:"truncated"`
+	AuditTimestamp    string `json:"auditTimestamp,omitempty"`
+}
+
+// Violation is a single violation of a constraint by an object.
+type Violation struct {
+	ConstraintKind    string `json:"constraintKind"`
+	ConstraintName    string `json:"constraintName"`
+	EnforcementAction string `json:"enforcementAction"`
+	Group             string `json:"group,omitempty"`
+	Version           string `json:"version,omitempty"`
+	Kind              string `json:"kind"`
+	Namespace         string `json:"namespace,omitempty"`
+	Name              string `json:"name"`
+	Message           string `json:"message"`
+}
+
+// Count is the number of violations for a namespace or kind.
+type Count struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// constraint contains the fields of a constraint, which are used for the summary.
+type constraint struct {
+	Kind     string `json:"kind"`
+	Metadata struct {
+		Name string `json:"name"`
+	} `json:"metadata"`
+	Spec struct {
+		EnforcementAction string `json:"enforcementAction"`
+	} `json:"spec"`
+	Status struct {
+		AuditTimestamp  string `json:"auditTimestamp"`
+		TotalViolations int64  `json:"totalViolations"`
+		Violations      []struct {
+			EnforcementAction string `json:"enforcementAction"`
+			Group             string `json:"group"`
+			Version           string `json:"version"`
+			Kind              string `json:"kind"`
+			Namespace         string `json:"namespace"`
+			Name              string `json:"name"`
+			Message           string `json:"message"`
+		} `json:"violations"`
+	} `json:"status"`
+}
+
+// kindResult is the result of listing the constraints of a single kind.
+type kindResult struct {
+	info        resources.ResourceInfo
+	constraints []constraint
+	err         error
+}
+
+// GetSummary discovers all constraint kinds and lists their constraints concurrently. If the namespace is not empty,
+// only the violations of objects in this namespace are returned. Constraint kinds, which can not be listed, are
+// skipped and reported as warning.
+func GetSummary(ctx context.Context, cache *resources.DiscoveryCache, clusterKey string, clientset kubernetes.Interface, namespace string) (*Summary, error) {
+	infos, err := cache.GroupResources(clusterKey, clientset, constraintsGroup)
+	if err != nil {
+		return nil, err
+	}
+
+	summary := &Summary{
+		Installed:   len(infos) > 0,
+		Constraints: []Constraint{},
+		Violations:  []Violation{},
+		ByNamespace: []Count{},
+		ByKind:      []Count{},
+	}
+	if !summary.Installed {
+		return summary, nil
+	}
+
+	results := make([]kindResult, len(infos))
+
+	var wg sync.WaitGroup
+	for i, info := range infos {
+		wg.Add(1)
+		go func(i int, info resources.ResourceInfo) {
+			defer wg.Done()
+
+			results[i] = kindResult{info: info}
+			data, err := clientset.CoreV1().RESTClient().Get().AbsPath(resources.Path(info, "")).DoRaw(ctx)
+			if err != nil {
+				results[i].err = err
+				return
+			}
+
+			var list struct {
+				Items []constraint `json:"items"`
+			}
+			if err := json.Unmarshal(data, &list); err != nil {
+				results[i].err = err
+				return
+			}
+			results[i].constraints = list.Items
+		}(i, info)
+	}
+	wg.Wait()
+
+	byNamespace := make(map[string]int)
+	byKind := make(map[string]int)
+
+	for _, result := range results {
+		if result.err != nil {
+			log.Printf("Could not list constraints of kind %s: %s", result.info.Kind, result.err.Error())
+			summary.Warnings = append(summary.Warnings, fmt.Sprintf("could not list constraints of kind %s: %s", result.info.Kind, result.err.Error()))
+			continue
+		}
+
+		for _, item := range result.constraints {
+			c := Constraint{
+				Kind:              result.info.Kind,
+				Name:              item.Metadata.Name,
+				EnforcementAction: item.Spec.EnforcementAction,
+				TotalViolations:   item.Status.TotalViolations,
+				Violations:        len(item.Status.Violations),
+				Truncated:         item.Status.TotalViolations > int64(len(item.Status.Violations)),
+				AuditTimestamp:    item.Status.AuditTimestamp,
+			}
+			if c.EnforcementAction == "" {
+				c.EnforcementAction = defaultEnforcementAction
+			}
+
+			summary.TotalViolations += c.TotalViolations
+			summary.Truncated = summary.Truncated || c.Truncated
+			summary.Constraints = append(summary.Constraints, c)
+
+			for _, v := range item.Status.Violations {
+				if namespace != "" && v.Namespace != namespace {
+					continue
+				}
+
+				violation := Violation{
+					ConstraintKind:    c.Kind,
+					ConstraintName:    c.Name,
+					EnforcementAction: v.EnforcementAction,
+					Group:             v.Group,
+					Version:           v.Version,
+					Kind:              v.Kind,
+					Namespace:         v.Namespace,
+					Name:              v.Name,
+					Message:           v.Message,
+				}
+				if violation.EnforcementAction == "" {
+					violation.EnforcementAction = c.EnforcementAction
+				}
+
+				summary.Violations = append(summary.Violations, violation)
+				if v.Namespace != "" {
+					byNamespace[v.Namespace]++
+				}
+				byKind[v.Kind]++
+			}
+		}
+	}
+
+	sort.SliceStable(summary.Constraints, func(i, j int) bool {
+		a, b := summary.Constraints[i], summary.Constraints[j]
+		if a.TotalViolations != b.TotalViolations {
+			return a.TotalViolations > b.TotalViolations
+		}
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		return a.Name < b.Name
+	})
+
+	summary.ByNamespace = sortCounts(byNamespace)
+	summary.ByKind = sortCounts(byKind)
+
+	return summary, nil
+}
+
+// sortCounts returns the counts sorted by the number of violations, the highest number first.
+func sortCounts(counts map[string]int) []Count {
+	sorted := make([]Count, 0, len(counts))
+	for name, count := range counts {
+		sorted = append(sorted, Count{Name: name, Count: count})
+	}
+
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Count != sorted[j].Count {
+			return sorted[i].Count > sorted[j].Count
+		}
+		return sorted[i].Name < sorted[j].Name
+	})
+
+	return sorted
+}
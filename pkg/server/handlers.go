@@ -1,26 +1,145 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log"
 	"net/http"
 	"net/url"
+	"path"
+	"runtime"
+	"sort"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/kubenav/kubenav/pkg/server/certificates"
+	"github.com/kubenav/kubenav/pkg/server/configdata"
+	"github.com/kubenav/kubenav/pkg/server/cronjobs"
+	"github.com/kubenav/kubenav/pkg/server/debugbundle"
+	"github.com/kubenav/kubenav/pkg/server/files"
+	"github.com/kubenav/kubenav/pkg/server/flux"
+	"github.com/kubenav/kubenav/pkg/server/gatekeeper"
+	"github.com/kubenav/kubenav/pkg/server/helm"
+	"github.com/kubenav/kubenav/pkg/server/kustomize"
+	"github.com/kubenav/kubenav/pkg/server/logs"
+	"github.com/kubenav/kubenav/pkg/server/metrics"
 	"github.com/kubenav/kubenav/pkg/server/middleware"
+	"github.com/kubenav/kubenav/pkg/server/namespaces"
+	"github.com/kubenav/kubenav/pkg/server/overview"
+	"github.com/kubenav/kubenav/pkg/server/plugins/elasticsearch"
+	"github.com/kubenav/kubenav/pkg/server/plugins/loki"
+	"github.com/kubenav/kubenav/pkg/server/plugins/prometheus"
+	"github.com/kubenav/kubenav/pkg/server/pods"
 	"github.com/kubenav/kubenav/pkg/server/portforwarding"
+	"github.com/kubenav/kubenav/pkg/server/processes"
+	"github.com/kubenav/kubenav/pkg/server/proxy"
+	"github.com/kubenav/kubenav/pkg/server/rbac"
+	"github.com/kubenav/kubenav/pkg/server/requestcache"
+	"github.com/kubenav/kubenav/pkg/server/resources"
+	"github.com/kubenav/kubenav/pkg/server/rollout"
+	"github.com/kubenav/kubenav/pkg/server/selfcheck"
+	"github.com/kubenav/kubenav/pkg/server/serviceaccounts"
+	"github.com/kubenav/kubenav/pkg/server/snapshots"
 	"github.com/kubenav/kubenav/pkg/server/terminal"
+	"github.com/kubenav/kubenav/pkg/server/validation"
+	"github.com/kubenav/kubenav/pkg/server/velero"
+	"github.com/kubenav/kubenav/pkg/server/wait"
+	"github.com/kubenav/kubenav/pkg/server/watch"
+	"github.com/kubenav/kubenav/pkg/version"
 
 	"github.com/gorilla/websocket"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/tools/remotecommand"
 )
 
-// healthHandler always returns a status ok response and can be used to check if the server is running or not.
+// healthHandler always returns a status ok response and can be used to check if the server is running or not. When
+// the request accepts a JSON response via the "Accept" header, the complete status of the server is returned, which
+// contains the version, the start time, the port, the active sessions, the enabled features and the listen addresses.
+// Otherwise the response is empty, so that existing probes are not affected.
+//
+// The status contains the start time instead of the uptime, so that the ETag of the response only changes, when the
+// status changes and polling clients get a "304 Not Modified" response otherwise.
 func (s *server) healthHandler(w http.ResponseWriter, r *http.Request) {
-	w.WriteHeader(http.StatusOK)
+	if !strings.Contains(r.Header.Get("Accept"), "application/json") {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	// The status is read from the published start-up info. It is only missing, when a request is served before the
+	// start-up is complete, in this case the start time and addresses are not returned.
+	var startTime *time.Time
+	var addresses []string
+	tlsEnabled := false
+	if startup := s.startup.Load(); startup != nil && startup.info != nil {
+		startTime = &startup.startTime
+		addresses = startup.addresses
+		tlsEnabled = startup.info.CertificateFingerprint != ""
+	}
+
+	middleware.Write(w, r, struct {
+		Version                string          `json:"version"`
+		Commit                 string          `json:"commit"`
+		StartTime              *time.Time      `json:"startTime"`
+		Port                   int             `json:"port"`
+		Addresses              []string        `json:"addresses"`
+		TerminalSessions       int             `json:"terminalSessions"`
+		PortForwardingSessions int             `json:"portForwardingSessions"`
+		Features               map[string]bool `json:"features"`
+	}{
+		version.Version,
+		version.Commit,
+		startTime,
+		s.port,
+		addresses,
+		terminal.Sessions.Count(),
+		portforwarding.Sessions.Count(),
+		map[string]bool{
+			"tls":         tlsEnabled,
+			"auth":        s.authToken != "",
+			"metrics":     s.getOptions().PrometheusMetrics,
+			"profiling":   s.getOptions().Profiling,
+			"compression": !s.getOptions().DisableCompression,
+			"rateLimit":   s.getOptions().RateLimit.CheapRequestsPerSecond > 0 || s.getOptions().RateLimit.ExpensiveRequestsPerSecond > 0,
+		},
+	})
+}
+
+// debugStatsHandler returns some runtime statistics of the server, like the number of goroutines, the used heap and
+// the number of open sessions, which can be displayed in the app. In contrast to the pprof endpoints, the statistics
+// are always available.
+func (s *server) debugStatsHandler(w http.ResponseWriter, r *http.Request) {
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+
+	middleware.Write(w, r, struct {
+		Goroutines             int                     `json:"goroutines"`
+		HeapInUse              uint64                  `json:"heapInUse"`
+		HeapAlloc              uint64                  `json:"heapAlloc"`
+		Sys                    uint64                  `json:"sys"`
+		NumGC                  uint32                  `json:"numGC"`
+		TerminalSessions       int                     `json:"terminalSessions"`
+		PortForwardingSessions int                     `json:"portForwardingSessions"`
+		Watches                int64                   `json:"watches"`
+		RequestCache           requestcache.Stats      `json:"requestCache"`
+		RequestDeduplication   requestcache.DedupStats `json:"requestDeduplication"`
+	}{
+		runtime.NumGoroutine(),
+		memStats.HeapInuse,
+		memStats.HeapAlloc,
+		memStats.Sys,
+		memStats.NumGC,
+		terminal.Sessions.Count(),
+		portforwarding.Sessions.Count(),
+		watch.ActiveWatches(),
+		requestcache.Default.Stats(),
+		requestcache.GetDedupStats(),
+	})
 }
 
 // portForwardingHandler can be used to establish a new port forwarding connection ("POST"), to get a list of all
@@ -31,10 +150,7 @@ func (s *server) portForwardingHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodGet {
 		var sessions []portforwarding.GetResponse
 
-		portforwarding.Sessions.Lock.RLock()
-		defer portforwarding.Sessions.Lock.RUnlock()
-
-		for _, session := range portforwarding.Sessions.Sessions {
+		for _, session := range portforwarding.Sessions.Snapshot() {
 			if strings.HasPrefix(session.ID, "user_") {
 				sessions = append(sessions, portforwarding.GetResponse{
 					ID:         session.ID,
@@ -43,10 +159,15 @@ func (s *server) portForwardingHandler(w http.ResponseWriter, r *http.Request) {
 					Container:  session.Container,
 					RemotePort: session.RemotePort,
 					LocalPort:  session.LocalPort,
+					RequestID:  session.RequestID,
 				})
 			}
 		}
 
+		// The sessions are sorted by their id, so that the response (and its ETag) only changes, when the sessions
+		// change.
+		sort.Slice(sessions, func(i, j int) bool { return sessions[i].ID < sessions[j].ID })
+
 		middleware.Write(w, r, struct {
 			Sessions []portforwarding.GetResponse `json:"sessions"`
 		}{
@@ -59,21 +180,14 @@ func (s *server) portForwardingHandler(w http.ResponseWriter, r *http.Request) {
 	// and started, we return the session id which can be used to delete a session and the used local port from the
 	// session which can then used by the user to interact with the selected remote port.
 	if r.Method == http.MethodPost {
-		if r.Body == nil {
-			middleware.Errorf(w, r, nil, http.StatusBadRequest, "Request body is empty")
-			return
-		}
-
 		var request portforwarding.CreateRequest
-		err := json.NewDecoder(r.Body).Decode(&request)
-		if err != nil {
-			middleware.Errorf(w, r, err, http.StatusBadRequest, fmt.Sprintf("Could not decode request body: %s", err.Error()))
+		if !s.decodeRequestBody(w, r, &request) {
 			return
 		}
 
 		restConfig, clientset, err := s.kubeClient.GetClient(request.ContextName, request.ClusterServer, request.ClusterCertificateAuthorityData, request.ClusterInsecureSkipTLSVerify, request.UserClientCertificateData, request.UserClientKeyData, request.UserToken, request.UserUsername, request.UserPassword, request.Proxy, 0)
 		if err != nil {
-			middleware.Errorf(w, r, err, http.StatusBadRequest, fmt.Sprintf("Could not create Kubernetes API client: %s", err.Error()))
+			middleware.Errorf(w, r, middleware.ClientConfiguration(err), http.StatusBadRequest, fmt.Sprintf("Could not create Kubernetes API client: %s", err.Error()))
 			return
 		}
 
@@ -110,11 +224,15 @@ func (s *server) portForwardingHandler(w http.ResponseWriter, r *http.Request) {
 			middleware.Errorf(w, r, err, http.StatusBadRequest, fmt.Sprintf("Could not initialize port forwarding: %s", err.Error()))
 			return
 		}
+		pf.RequestID = middleware.GetRequestID(r.Context())
 
 		errCh := make(chan error, 1)
 
+		// When the port forwarding connection is closed (e.g. because the Pod was deleted), the session is removed, so
+		// that the client can check via a GET request if the session still exists.
 		go func() {
 			err := pf.Start(restConfig, fmt.Sprintf("/api/v1/namespaces/%s/pods/%s/portforward", request.PodNamespace, request.PodName), request.PodPort)
+			portforwarding.Sessions.Stop(pf.ID)
 			if err != nil {
 				errCh <- err
 			}
@@ -135,6 +253,7 @@ func (s *server) portForwardingHandler(w http.ResponseWriter, r *http.Request) {
 			Container:  pf.Container,
 			RemotePort: pf.RemotePort,
 			LocalPort:  pf.LocalPort,
+			RequestID:  pf.RequestID,
 		})
 		return
 	}
@@ -143,20 +262,11 @@ func (s *server) portForwardingHandler(w http.ResponseWriter, r *http.Request) {
 	// connection.
 	if r.Method == http.MethodDelete {
 		var request portforwarding.DeleteRequest
-		if r.Body == nil {
-			middleware.Errorf(w, r, nil, http.StatusBadRequest, "Request body is empty")
-			return
-		}
-		err := json.NewDecoder(r.Body).Decode(&request)
-		if err != nil {
-			middleware.Errorf(w, r, err, http.StatusBadRequest, fmt.Sprintf("Could not decode request body: %s", err.Error()))
+		if !s.decodeRequestBody(w, r, &request) {
 			return
 		}
 
-		if session, ok := portforwarding.Sessions.Get(request.SessionID); ok {
-			close(session.StopCh)
-			portforwarding.Sessions.Delete(session.ID)
-		}
+		portforwarding.Sessions.Stop(request.SessionID)
 
 		middleware.Write(w, r, nil)
 		return
@@ -170,93 +280,2537 @@ func (s *server) portForwardingHandler(w http.ResponseWriter, r *http.Request) {
 func (s *server) terminalHandler(w http.ResponseWriter, r *http.Request) {
 	// The Pod data (name and namespace) as well as the container and shell are send via query parameters. While the
 	// credentials required to authenticate against the Kubernetes API must be send via our custom headers.
+	//
+	// Instead of a shell the user can also provide a command via the "command" query parameter (which can be specified
+	// multiple times for the arguments of the command). In this case the command is executed without a tty, which can
+	// be used to run one-off commands in a container. The "encoding" query parameter can be set to "base64" to receive
+	// the output of the process base64 encoded, so that binary output isn't modified. The "force" query parameter can be
+	// set to "true" to skip the check of the container state.
 	name := r.URL.Query().Get("name")
 	namespace := r.URL.Query().Get("namespace")
 	container := r.URL.Query().Get("container")
 	shell := r.URL.Query().Get("shell")
+	command := r.URL.Query()["command"]
+	encoding := r.URL.Query().Get("encoding")
+	force := r.URL.Query().Get("force") == "true"
+
+	restConfig, clientset, err := s.getClientFromHeaders(r)
+	if err != nil {
+		middleware.Errorf(w, r, middleware.ClientConfiguration(err), http.StatusBadRequest, fmt.Sprintf("Could not create Kubernetes API client: %s", err.Error()))
+		return
+	}
+
+	// Before we upgrade the connection, we check that the requested container exists and that it is running, so that
+	// the user gets a meaningful error instead of the low-level error from the exec endpoint. If the Pod only contains
+	// one container the container query parameter can be omitted. The check can be skipped via the "force" query
+	// parameter, e.g. to exec into a terminating container.
+	if !force || container == "" {
+		pod, err := clientset.CoreV1().Pods(namespace).Get(r.Context(), name, metav1.GetOptions{})
+		if err != nil {
+			middleware.Errorf(w, r, err, http.StatusBadRequest, fmt.Sprintf("Could not get pod: %s", err.Error()))
+			return
+		}
+
+		if container == "" {
+			container, err = getDefaultContainer(pod)
+			if err != nil {
+				middleware.Errorf(w, r, err, http.StatusBadRequest, fmt.Sprintf("Could not determine container: %s", err.Error()))
+				return
+			}
+		}
+
+		if !force {
+			if statusCode, err := validateContainer(pod, container); err != nil {
+				middleware.Errorf(w, r, err, statusCode, fmt.Sprintf("Could not use container: %s", err.Error()))
+				return
+			}
+		}
+	}
 
-	contextName := r.Header.Get("X-CONTEXT-NAME")
-	clusterServer := r.Header.Get("X-CLUSTER-SERVER")
-	clusterCertificateAuthorityData := r.Header.Get("X-CLUSTER-CERTIFICATE-AUTHORITY-DATA")
-	clusterInsecureSkipTLSVerify := r.Header.Get("X-CLUSTER-INSECURE-SKIP-TLS-VERIFY")
-	userClientCertificateData := r.Header.Get("X-USER-CLIENT-CERTIFICATE-DATA")
-	userClientKeyData := r.Header.Get("X-USER-CLIENT-KEY-DATA")
-	userToken := r.Header.Get("X-USER-TOKEN")
-	userUsername := r.Header.Get("X-USER-USERNAME")
-	userPassword := r.Header.Get("X-USER-PASSWORD")
-	proxy := r.Header.Get("X-PROXY")
+	// We validate the user defined shell and fallback to "sh" when it was invalid. The shell is only used when the user
+	// didn't provide a command.
+	tty := len(command) == 0
+	if tty {
+		if !terminal.IsValidShell(shell) {
+			shell = "sh"
+		}
+		command = []string{shell}
+	}
 
-	parsedClusterInsecureSkipTLSVerify, err := strconv.ParseBool(clusterInsecureSkipTLSVerify)
+	// Before we upgrade the connection we have to add the session to our session registry. If the maximum number of
+	// terminal sessions is reached we return an error, so that the client receives a proper http status code.
+	sessionID, err := terminal.GenSessionID()
 	if err != nil {
-		parsedClusterInsecureSkipTLSVerify = false
+		middleware.Errorf(w, r, err, http.StatusInternalServerError, fmt.Sprintf("Could not create session id: %s", err.Error()))
+		return
+	}
+
+	session := &terminal.Session{
+		ID:        sessionID,
+		Cluster:   getClusterFromHeaders(r),
+		Name:      name,
+		Namespace: namespace,
+		Container: container,
+		Command:   command,
+		RequestID: middleware.GetRequestID(r.Context()),
+		StartTime: time.Now(),
+		DoneChan:  make(chan struct{}),
+	}
+	if tty {
+		session.SizeChan = make(chan remotecommand.TerminalSize)
+	}
+	if encoding == terminal.EncodingBase64 {
+		session.Encoding = terminal.EncodingBase64
+	}
+
+	if err := terminal.Sessions.Add(session, s.getOptions().MaxTerminalSessions, s.getOptions().MaxTerminalSessionsPerCluster); err != nil {
+		middleware.Errorf(w, r, middleware.SessionLimit(err), http.StatusTooManyRequests, fmt.Sprintf("Could not create terminal session: %s", err.Error()))
+		return
 	}
+	defer terminal.Sessions.Delete(session.ID)
+	defer close(session.DoneChan)
 
-	restConfig, _, err := s.kubeClient.GetClient(contextName, clusterServer, clusterCertificateAuthorityData, parsedClusterInsecureSkipTLSVerify, userClientCertificateData, userClientKeyData, userToken, userUsername, userPassword, proxy, 0)
+	// When the audit log is enabled, we record the start and the end of the session. If the user also enabled the
+	// recording of the input, each line of input is also written to the audit log.
+	s.auditTerminalSession(session, "start", nil, "")
+	if s.getOptions().TerminalAuditLogInput {
+		session.InputRecorder = func(line string) {
+			s.auditTerminalSession(session, "input", nil, line)
+		}
+	}
 
 	// After we create a client to interact with the Kubernetes API, we can upgrade the underlying http connection, to
 	// get a shell into the requested container.
 	//
-	// We also setup the ping and pong handlers, so that the WebSocket connection isn't closed, when the user doesn't
-	// send any data for a while.
-	var upgrader = websocket.Upgrader{}
-	upgrader.CheckOrigin = func(r *http.Request) bool { return true }
+	// We also start sending ping messages, so that the WebSocket connection isn't closed, when the user doesn't send any
+	// data for a while.
+	upgrader := s.newUpgrader()
 
-	c, err := upgrader.Upgrade(w, r, nil)
+	c, err := s.upgrade(upgrader, w, r)
 	if err != nil {
-		middleware.Errorf(w, r, err, http.StatusBadRequest, fmt.Sprintf("Could not upgrade connection: %s", err.Error()))
+		middleware.Errorf(w, r, middleware.UpgradeFailed(err), http.StatusBadRequest, fmt.Sprintf("Could not upgrade connection: %s", err.Error()))
 		return
 	}
-	defer c.Close()
-
-	c.SetPongHandler(func(string) error { return nil })
+	defer s.closeConnection(c)
 
-	go func() {
-		ticker := time.NewTicker(30 * time.Second)
-		defer ticker.Stop()
-
-		for {
-			select {
-			case <-ticker.C:
-				if err := c.WriteMessage(websocket.PingMessage, nil); err != nil {
-					return
-				}
-			}
-		}
-	}()
+	go keepAlive(c, session.DoneChan)
 
 	// After our WebSocket connection is established, we create the request url for the Kubernetes API to get a terminal
 	// into the requested container.
-	//
-	// We also validating the user defined shell and fallback to "sh" when it was invalid.
-	reqURL, err := url.Parse(fmt.Sprintf("%s/api/v1/namespaces/%s/pods/%s/exec?container=%s&command=%s&stdin=true&stdout=true&stderr=true&tty=true", restConfig.Host, namespace, name, container, shell))
+	params := url.Values{}
+	params.Set("container", container)
+	for _, c := range command {
+		params.Add("command", c)
+	}
+	params.Set("stdin", "true")
+	params.Set("stdout", "true")
+	params.Set("stderr", "true")
+	params.Set("tty", strconv.FormatBool(tty))
+
+	// Finally we connect our terminal session with the WebSocket connection we established before and we are starting
+	// the terminal process, where the communication between the user and the container happens via the WebSocket
+	// connection.
+	session.WebSocket = c
+
+	reqURL, err := url.Parse(fmt.Sprintf("%s/api/v1/namespaces/%s/pods/%s/exec?%s", restConfig.Host, namespace, name, params.Encode()))
 	if err != nil {
-		msg, _ := json.Marshal(terminal.Message{
+		session.WriteMessage(terminal.Message{
 			Op:   "stdout",
 			Data: fmt.Sprintf("Could not create request url: %s", err.Error()),
 		})
-		c.WriteMessage(websocket.TextMessage, msg)
+		session.Exit(err)
 		return
 	}
 
-	if !terminal.IsValidShell(shell) {
-		shell = "sh"
+	// When the process ends we always send a final "exit" message, so that the frontend can differentiate between a
+	// successful and a failed process. If the terminal couldn't be created at all, we also write the error to the
+	// terminal, so that it is visible for the user.
+	//
+	// The process is tied to the session via the context, which is canceled when the session ends or the server is
+	// stopped.
+	processCtx, cancelProcess := context.WithCancel(r.Context())
+	defer cancelProcess()
+
+	err = terminal.StartProcess(processCtx, restConfig, reqURL, tty, s.getOptions().ExecProtocol, session)
+	exitCode, _ := terminal.GetExitStatus(err)
+	s.auditTerminalSession(session, "end", &exitCode, "")
+	if exitCode == -1 {
+		session.WriteMessage(terminal.Message{
+			Op:   "stdout",
+			Data: fmt.Sprintf("Could not create terminal: %s", err.Error()),
+		})
 	}
-	cmd := []string{shell}
 
-	// Finally we create a new terminal session and we are starting the terminal process, where the communication
-	// between the user and the container happens via the WebSocket connection we established before.
-	session := &terminal.Session{
-		WebSocket: c,
-		SizeChan:  make(chan remotecommand.TerminalSize),
+	session.Exit(err)
+}
+
+// terminalContainersHandler returns all containers of a Pod, including the init and ephemeral containers, which can be
+// used to select the container for a terminal session. Each container is labeled with its type and current state. The
+// Pod is specified via the "name" and "namespace" query parameters, the credentials must be send via our custom headers.
+func (s *server) terminalContainersHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+	namespace := r.URL.Query().Get("namespace")
+
+	_, clientset, err := s.getClientFromHeaders(r)
+	if err != nil {
+		middleware.Errorf(w, r, middleware.ClientConfiguration(err), http.StatusBadRequest, fmt.Sprintf("Could not create Kubernetes API client: %s", err.Error()))
+		return
 	}
 
-	err = terminal.StartProcess(restConfig, reqURL, cmd, session)
+	pod, err := clientset.CoreV1().Pods(namespace).Get(r.Context(), name, metav1.GetOptions{})
 	if err != nil {
-		msg, _ := json.Marshal(terminal.Message{
-			Op:   "stdout",
-			Data: fmt.Sprintf("Could not create terminal: %s", err.Error()),
+		middleware.Errorf(w, r, err, http.StatusBadRequest, fmt.Sprintf("Could not get pod: %s", err.Error()))
+		return
+	}
+
+	middleware.Write(w, r, struct {
+		Containers []podContainer `json:"containers"`
+	}{
+		getPodContainers(pod),
+	})
+}
+
+// logsHandler streams the logs of a container via WebSockets. The container is specified via the "name", "namespace"
+// and "container" query parameters, the options for the logs via the "follow", "tailLines", "sinceSeconds",
+// "timestamps" and "previous" query parameters. The credentials must be send via our custom headers.
+//
+// Each log line is send as a JSON message to the client (see "logs.Message"). When the stream is closed by the
+// Kubernetes API (e.g. because the container was restarted), a final "closed" message with the reason is send, so
+// that the frontend can offer the user to follow the logs again.
+//
+// When the client requests an event stream (see isEventStream), the messages are send as Server-Sent Events instead,
+// where the event type is the operation of the message.
+func (s *server) logsHandler(w http.ResponseWriter, r *http.Request) {
+	options, err := logs.OptionsFromQuery(r.URL.Query())
+	if err != nil {
+		middleware.Errorf(w, r, middleware.InvalidParameters(err), http.StatusBadRequest, fmt.Sprintf("Invalid parameters: %s", err.Error()))
+		return
+	}
+	options.MaxStreams = s.getOptions().LogMaxStreams
+	options.MaxBytesPerSecond = s.getOptions().LogMaxBytesPerSecond
+
+	_, clientset, err := s.getClientFromHeaders(r)
+	if err != nil {
+		middleware.Errorf(w, r, middleware.ClientConfiguration(err), http.StatusBadRequest, fmt.Sprintf("Could not create Kubernetes API client: %s", err.Error()))
+		return
+	}
+
+	if isEventStream(r) {
+		writer, err := newEventStreamWriter(w)
+		if err != nil {
+			middleware.Errorf(w, r, err, http.StatusInternalServerError, fmt.Sprintf("Could not create event stream: %s", err.Error()))
+			return
+		}
+
+		ctx := r.Context()
+		go writer.heartbeat(ctx.Done())
+
+		err = logs.Stream(ctx, clientset, options, func(msg logs.Message) error {
+			return writer.WriteEvent(msg.Op, "", msg)
+		})
+		if err != nil && ctx.Err() == nil {
+			writer.WriteEvent(logs.OpError, "", logs.Message{Op: logs.OpError, Pod: options.Name, Container: options.Container, Data: err.Error()})
+		}
+		return
+	}
+
+	upgrader := s.newUpgrader()
+
+	c, err := s.upgrade(upgrader, w, r)
+	if err != nil {
+		middleware.Errorf(w, r, middleware.UpgradeFailed(err), http.StatusBadRequest, fmt.Sprintf("Could not upgrade connection: %s", err.Error()))
+		return
+	}
+	defer s.closeConnection(c)
+
+	// The context for the log stream is canceled when the client closes the WebSocket connection, so that we do not
+	// leak the log stream.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go keepAlive(c, ctx.Done())
+	go cancelOnClose(c, cancel)
+
+	writer := newWebSocketWriter(c)
+
+	err = logs.Stream(ctx, clientset, options, func(msg logs.Message) error {
+		return writer.WriteJSON(msg)
+	})
+	if err != nil && ctx.Err() == nil {
+		writer.WriteJSON(logs.Message{Op: logs.OpError, Pod: options.Name, Container: options.Container, Data: err.Error()})
+	}
+
+	writer.Close(websocket.CloseNormalClosure, "")
+}
+
+// logsDownloadHandler returns the complete logs of a container as gzip compressed file. The container is specified via
+// the "name", "namespace" and "container" query parameters. If the "previous" parameter is true, the logs of the
+// previous container are also included.
+func (s *server) logsDownloadHandler(w http.ResponseWriter, r *http.Request) {
+	options, err := logs.OptionsFromQuery(r.URL.Query())
+	if err != nil {
+		middleware.Errorf(w, r, middleware.InvalidParameters(err), http.StatusBadRequest, fmt.Sprintf("Invalid parameters: %s", err.Error()))
+		return
+	}
+
+	if options.Name == "" {
+		middleware.Errorf(w, r, middleware.InvalidParameters(nil), http.StatusBadRequest, "Invalid parameters: name is required")
+		return
+	}
+
+	_, clientset, err := s.getClientFromHeaders(r)
+	if err != nil {
+		middleware.Errorf(w, r, middleware.ClientConfiguration(err), http.StatusBadRequest, fmt.Sprintf("Could not create Kubernetes API client: %s", err.Error()))
+		return
+	}
+
+	download, err := logs.NewDownload(r.Context(), clientset, options)
+	if err != nil {
+		middleware.Errorf(w, r, err, http.StatusBadRequest, fmt.Sprintf("Could not get logs: %s", err.Error()))
+		return
+	}
+
+	filename := options.Name
+	if options.Container != "" {
+		filename = filename + "-" + options.Container
+	}
+
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename+".log.gz"))
+	w.WriteHeader(http.StatusOK)
+
+	// When the download fails after we started to write the response, we can not return an error to the client
+	// anymore, so that we only log the error. The client will receive an incomplete gzip file in this case.
+	if err := download.WriteTo(w, nil); err != nil {
+		log.Printf("Could not write logs: %s", middleware.ScrubRequest(r, err.Error()))
+	}
+}
+
+// filesHandler lists the files of a directory in a container. The container is specified via the "name", "namespace"
+// and "container" query parameters and the directory via the "path" parameter. The returned paths of the entries can
+// be passed to the filesDownloadHandler to download a file.
+func (s *server) filesHandler(w http.ResponseWriter, r *http.Request) {
+	options, err := files.OptionsFromQuery(r.URL.Query())
+	if err != nil {
+		middleware.Errorf(w, r, middleware.InvalidParameters(err), http.StatusBadRequest, fmt.Sprintf("Invalid parameters: %s", err.Error()))
+		return
+	}
+	options.Protocol = s.getOptions().ExecProtocol
+
+	restConfig, clientset, err := s.getClientFromHeaders(r)
+	if err != nil {
+		middleware.Errorf(w, r, middleware.ClientConfiguration(err), http.StatusBadRequest, fmt.Sprintf("Could not create Kubernetes API client: %s", err.Error()))
+		return
+	}
+
+	container, statusCode, err := getExecContainer(r.Context(), clientset, options.Namespace, options.Name, options.Container)
+	if err != nil {
+		middleware.Errorf(w, r, err, statusCode, fmt.Sprintf("Could not use container: %s", err.Error()))
+		return
+	}
+	options.Container = container
+
+	listing, err := files.List(r.Context(), restConfig, options)
+	if err != nil {
+		middleware.Errorf(w, r, err, http.StatusInternalServerError, fmt.Sprintf("Could not list files: %s", err.Error()))
+		return
+	}
+
+	middleware.Write(w, r, listing)
+}
+
+// filesDownloadHandler returns the content of a file in a container. The parameters are the same as for the
+// filesHandler, but the "path" parameter must be the path of a file.
+func (s *server) filesDownloadHandler(w http.ResponseWriter, r *http.Request) {
+	options, err := files.OptionsFromQuery(r.URL.Query())
+	if err != nil {
+		middleware.Errorf(w, r, middleware.InvalidParameters(err), http.StatusBadRequest, fmt.Sprintf("Invalid parameters: %s", err.Error()))
+		return
+	}
+	options.Protocol = s.getOptions().ExecProtocol
+
+	restConfig, clientset, err := s.getClientFromHeaders(r)
+	if err != nil {
+		middleware.Errorf(w, r, middleware.ClientConfiguration(err), http.StatusBadRequest, fmt.Sprintf("Could not create Kubernetes API client: %s", err.Error()))
+		return
+	}
+
+	container, statusCode, err := getExecContainer(r.Context(), clientset, options.Namespace, options.Name, options.Container)
+	if err != nil {
+		middleware.Errorf(w, r, err, statusCode, fmt.Sprintf("Could not use container: %s", err.Error()))
+		return
+	}
+	options.Container = container
+
+	// When the download fails after we started to write the response, we can not return an error to the client
+	// anymore, so that we only log the error. The client will receive an incomplete file in this case.
+	writer := &attachmentWriter{w: w, filename: path.Base(options.Path)}
+	if err := files.Download(r.Context(), restConfig, options, writer); err != nil {
+		if !writer.written {
+			middleware.Errorf(w, r, err, http.StatusInternalServerError, fmt.Sprintf("Could not download file: %s", err.Error()))
+			return
+		}
+		log.Printf("Could not write file: %s", middleware.ScrubRequest(r, err.Error()))
+		return
+	}
+
+	// An empty file doesn't write any data, so that we have to write the headers here.
+	if !writer.written {
+		writer.Write(nil)
+	}
+}
+
+// processesHandler returns the processes of a container. The container is specified via the "name", "namespace" and
+// "container" query parameters. The processes are listed via ps or the "/proc" filesystem, when ps isn't available in
+// the container.
+func (s *server) processesHandler(w http.ResponseWriter, r *http.Request) {
+	options, err := processes.OptionsFromQuery(r.URL.Query())
+	if err != nil {
+		middleware.Errorf(w, r, middleware.InvalidParameters(err), http.StatusBadRequest, fmt.Sprintf("Invalid parameters: %s", err.Error()))
+		return
+	}
+	options.Protocol = s.getOptions().ExecProtocol
+
+	restConfig, clientset, err := s.getClientFromHeaders(r)
+	if err != nil {
+		middleware.Errorf(w, r, middleware.ClientConfiguration(err), http.StatusBadRequest, fmt.Sprintf("Could not create Kubernetes API client: %s", err.Error()))
+		return
+	}
+
+	container, statusCode, err := getExecContainer(r.Context(), clientset, options.Namespace, options.Name, options.Container)
+	if err != nil {
+		middleware.Errorf(w, r, err, statusCode, fmt.Sprintf("Could not use container: %s", err.Error()))
+		return
+	}
+	options.Container = container
+
+	containerProcesses, err := processes.List(r.Context(), restConfig, options)
+	if err != nil {
+		middleware.Errorf(w, r, err, http.StatusInternalServerError, fmt.Sprintf("Could not list processes: %s", err.Error()))
+		return
+	}
+
+	middleware.Write(w, r, containerProcesses)
+}
+
+// rolloutHandler streams the rollout status of a Deployment, StatefulSet or DaemonSet, similar to "kubectl rollout
+// status". The resource is specified via the "kind", "namespace" and "name" query parameters, the maximum duration to
+// wait for the rollout via the "timeout" parameter in seconds.
+//
+// Each change of the rollout status is send as "progress" message (see "rollout.Message"). The stream ends with a
+// final "complete", "failed", "timeout" or "error" message. When the client requests an event stream, the messages are
+// send as Server-Sent Events, where the event type is the operation of the message.
+func (s *server) rolloutHandler(w http.ResponseWriter, r *http.Request) {
+	options, err := rollout.OptionsFromQuery(r.URL.Query())
+	if err != nil {
+		middleware.Errorf(w, r, middleware.InvalidParameters(err), http.StatusBadRequest, fmt.Sprintf("Invalid parameters: %s", err.Error()))
+		return
+	}
+
+	_, clientset, err := s.getClientFromHeaders(r)
+	if err != nil {
+		middleware.Errorf(w, r, middleware.ClientConfiguration(err), http.StatusBadRequest, fmt.Sprintf("Could not create Kubernetes API client: %s", err.Error()))
+		return
+	}
+
+	errorMessage := func(err error) rollout.Message {
+		return rollout.Message{Op: rollout.OpError, Kind: options.Kind, Name: options.Name, Message: err.Error()}
+	}
+
+	if isEventStream(r) {
+		writer, err := newEventStreamWriter(w)
+		if err != nil {
+			middleware.Errorf(w, r, err, http.StatusInternalServerError, fmt.Sprintf("Could not create event stream: %s", err.Error()))
+			return
+		}
+
+		ctx := r.Context()
+		go writer.heartbeat(ctx.Done())
+
+		err = rollout.Stream(ctx, clientset.RESTClient(), options, func(msg rollout.Message) error {
+			return writer.WriteEvent(msg.Op, "", msg)
+		})
+		if err != nil && ctx.Err() == nil {
+			writer.WriteEvent(rollout.OpError, "", errorMessage(err))
+		}
+		return
+	}
+
+	upgrader := s.newUpgrader()
+
+	c, err := s.upgrade(upgrader, w, r)
+	if err != nil {
+		middleware.Errorf(w, r, middleware.UpgradeFailed(err), http.StatusBadRequest, fmt.Sprintf("Could not upgrade connection: %s", err.Error()))
+		return
+	}
+	defer s.closeConnection(c)
+
+	// The watch is stopped as soon as the client closes the WebSocket connection.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go keepAlive(c, ctx.Done())
+	go cancelOnClose(c, cancel)
+
+	writer := newWebSocketWriter(c)
+
+	err = rollout.Stream(ctx, clientset.RESTClient(), options, func(msg rollout.Message) error {
+		return writer.WriteJSON(msg)
+	})
+	if err != nil && ctx.Err() == nil {
+		writer.WriteJSON(errorMessage(err))
+	}
+
+	writer.Close(websocket.CloseNormalClosure, "")
+}
+
+// waitHandler waits until a condition of a Kubernetes object holds, similar to "kubectl wait". The object is specified
+// via the "url" query parameter, the conditions via the "for" parameter (e.g. "condition=Ready" or "delete") and the
+// maximum duration to wait via the "timeout" parameter in seconds.
+//
+// Each change of the state of the conditions is send as "progress" message (see "wait.Message"). The stream ends with
+// a final "met", "timeout" or "error" message, where the "met" message contains the object. When the client requests
+// an event stream, the messages are send as Server-Sent Events, where the event type is the operation of the message.
+// The watch is stopped as soon as the client disconnects.
+func (s *server) waitHandler(w http.ResponseWriter, r *http.Request) {
+	options, err := wait.OptionsFromQuery(r.URL.Query())
+	if err != nil {
+		middleware.Errorf(w, r, middleware.InvalidParameters(err), http.StatusBadRequest, fmt.Sprintf("Invalid parameters: %s", err.Error()))
+		return
+	}
+
+	_, clientset, err := s.getClientFromHeaders(r)
+	if err != nil {
+		middleware.Errorf(w, r, middleware.ClientConfiguration(err), http.StatusBadRequest, fmt.Sprintf("Could not create Kubernetes API client: %s", err.Error()))
+		return
+	}
+
+	errorMessage := func(err error) wait.Message {
+		return wait.Message{Op: wait.OpError, Message: err.Error()}
+	}
+
+	if isEventStream(r) {
+		writer, err := newEventStreamWriter(w)
+		if err != nil {
+			middleware.Errorf(w, r, err, http.StatusInternalServerError, fmt.Sprintf("Could not create event stream: %s", err.Error()))
+			return
+		}
+
+		ctx := r.Context()
+		go writer.heartbeat(ctx.Done())
+
+		err = wait.Stream(ctx, clientset.RESTClient(), options, func(msg wait.Message) error {
+			return writer.WriteEvent(msg.Op, "", msg)
 		})
-		c.WriteMessage(websocket.TextMessage, msg)
+		if err != nil && ctx.Err() == nil {
+			writer.WriteEvent(wait.OpError, "", errorMessage(err))
+		}
 		return
 	}
+
+	upgrader := s.newUpgrader()
+
+	c, err := s.upgrade(upgrader, w, r)
+	if err != nil {
+		middleware.Errorf(w, r, middleware.UpgradeFailed(err), http.StatusBadRequest, fmt.Sprintf("Could not upgrade connection: %s", err.Error()))
+		return
+	}
+	defer s.closeConnection(c)
+
+	// The watch is stopped as soon as the client closes the WebSocket connection.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go keepAlive(c, ctx.Done())
+	go cancelOnClose(c, cancel)
+
+	writer := newWebSocketWriter(c)
+
+	err = wait.Stream(ctx, clientset.RESTClient(), options, func(msg wait.Message) error {
+		return writer.WriteJSON(msg)
+	})
+	if err != nil && ctx.Err() == nil {
+		writer.WriteJSON(errorMessage(err))
+	}
+
+	writer.Close(websocket.CloseNormalClosure, "")
+}
+
+// watchHandler watches a Kubernetes resource and sends all changes via a WebSocket connection to the client. The
+// resource is specified via the "url" query parameter, the credentials are passed via the headers.
+//
+// If the "url" parameter is not set, multiple resources can be watched over the same connection. In this case the
+// client must send "subscribe" and "unsubscribe" control messages and each event contains the ID of the subscription.
+//
+// When the client requests an event stream (see isEventStream), the events are send as Server-Sent Events instead.
+// The multiplexing isn't supported for event streams, because the client can not send control messages.
+func (s *server) watchHandler(w http.ResponseWriter, r *http.Request) {
+	multiplexed := r.URL.Query().Get("url") == "" && !isEventStream(r)
+
+	var options watch.Options
+	if !multiplexed {
+		var err error
+		options, err = watch.OptionsFromQuery(r.URL.Query())
+		if err != nil {
+			middleware.Errorf(w, r, middleware.InvalidParameters(err), http.StatusBadRequest, fmt.Sprintf("Invalid parameters: %s", err.Error()))
+			return
+		}
+	}
+
+	_, clientset, err := s.getClientFromHeaders(r)
+	if err != nil {
+		middleware.Errorf(w, r, middleware.ClientConfiguration(err), http.StatusBadRequest, fmt.Sprintf("Could not create Kubernetes API client: %s", err.Error()))
+		return
+	}
+
+	if !multiplexed {
+		// For an event stream the "Last-Event-ID" header, which is send by the client when it reconnects, contains the
+		// last seen resource version, so that the watch can be resumed.
+		if isEventStream(r) && options.ResourceVersion == "" {
+			options.ResourceVersion = r.Header.Get("Last-Event-ID")
+		}
+
+		s.streamWatchEvents(w, r, func(ctx context.Context, send func(watch.Event) error) error {
+			return watch.Watch(ctx, clientset.RESTClient(), options, send)
+		})
+		return
+	}
+
+	upgrader := s.newUpgrader()
+
+	c, err := s.upgrade(upgrader, w, r)
+	if err != nil {
+		middleware.Errorf(w, r, middleware.UpgradeFailed(err), http.StatusBadRequest, fmt.Sprintf("Could not upgrade connection: %s", err.Error()))
+		return
+	}
+	defer s.closeConnection(c)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go keepAlive(c, ctx.Done())
+
+	writer := newWebSocketWriter(c)
+	mux := watch.NewMultiplexer(ctx, clientset.RESTClient(), s.getOptions().WatchMaxSubscriptions, func(event watch.Event) error {
+		return writer.WriteJSON(event)
+	})
+
+	// The control messages are read until the client closes the connection. Then the context is canceled, which stops
+	// all watches of the connection.
+	go func() {
+		defer cancel()
+
+		for {
+			var control watch.Control
+			if err := c.ReadJSON(&control); err != nil {
+				if _, ok := err.(*json.SyntaxError); ok {
+					continue
+				}
+				return
+			}
+			mux.Handle(control)
+		}
+	}()
+
+	if err := mux.Run(); err != nil && ctx.Err() == nil {
+		writer.WriteJSON(watch.Event{Type: watch.EventError, Data: err.Error()})
+	}
+
+	writer.Close(websocket.CloseNormalClosure, "")
+}
+
+// eventsHandler watches the events of a single object. The object is specified via the "namespace", "name" and "uid"
+// query parameters. The events are send via a WebSocket connection or as Server-Sent Events (see streamWatchEvents).
+func (s *server) eventsHandler(w http.ResponseWriter, r *http.Request) {
+	options, err := watch.EventsOptionsFromQuery(r.URL.Query())
+	if err != nil {
+		middleware.Errorf(w, r, middleware.InvalidParameters(err), http.StatusBadRequest, fmt.Sprintf("Invalid parameters: %s", err.Error()))
+		return
+	}
+
+	_, clientset, err := s.getClientFromHeaders(r)
+	if err != nil {
+		middleware.Errorf(w, r, middleware.ClientConfiguration(err), http.StatusBadRequest, fmt.Sprintf("Could not create Kubernetes API client: %s", err.Error()))
+		return
+	}
+
+	if isEventStream(r) && options.ResourceVersion == "" {
+		options.ResourceVersion = r.Header.Get("Last-Event-ID")
+	}
+
+	s.streamWatchEvents(w, r, func(ctx context.Context, send func(watch.Event) error) error {
+		return watch.WatchEvents(ctx, clientset.RESTClient(), options, send)
+	})
+}
+
+// eventsSummaryHandler returns the events of a namespace or the whole cluster, grouped by the involved object and the
+// reason. The namespace is specified via the "namespace" query parameter, the "since" parameter (in seconds) can be
+// used to only include recent events and the "maxGroups" parameter to limit the number of returned groups.
+func (s *server) eventsSummaryHandler(w http.ResponseWriter, r *http.Request) {
+	options, err := watch.SummaryOptionsFromQuery(r.URL.Query())
+	if err != nil {
+		middleware.Errorf(w, r, middleware.InvalidParameters(err), http.StatusBadRequest, fmt.Sprintf("Invalid parameters: %s", err.Error()))
+		return
+	}
+
+	_, clientset, err := s.getClientFromHeaders(r)
+	if err != nil {
+		middleware.Errorf(w, r, middleware.ClientConfiguration(err), http.StatusBadRequest, fmt.Sprintf("Could not create Kubernetes API client: %s", err.Error()))
+		return
+	}
+
+	summary, err := watch.GetEventsSummary(r.Context(), clientset.RESTClient(), options)
+	if err != nil {
+		middleware.Errorf(w, r, err, http.StatusInternalServerError, fmt.Sprintf("Could not get events summary: %s", err.Error()))
+		return
+	}
+
+	middleware.Write(w, r, summary)
+}
+
+// metricsSamplesHandler returns the samples of the metrics sampler for the targets from the "targets" query parameter,
+// which must be a comma separated list of targets in the format "pod/<namespace>/<name>" or "node/<name>". The sampler
+// must be enabled via the "MetricsSampler" option.
+func (s *server) metricsSamplesHandler(w http.ResponseWriter, r *http.Request) {
+	if s.metricsSampler == nil {
+		middleware.Errorf(w, r, middleware.NotEnabled(nil), http.StatusNotFound, "Metrics sampler is not enabled")
+		return
+	}
+
+	var targets []metrics.Target
+	for _, value := range strings.Split(r.URL.Query().Get("targets"), ",") {
+		if value == "" {
+			continue
+		}
+
+		target, err := metrics.ParseTarget(value)
+		if err != nil {
+			middleware.Errorf(w, r, middleware.InvalidParameters(err), http.StatusBadRequest, fmt.Sprintf("Invalid parameters: %s", err.Error()))
+			return
+		}
+		targets = append(targets, target)
+	}
+
+	_, clientset, err := s.getClientFromHeaders(r)
+	if err != nil {
+		middleware.Errorf(w, r, middleware.ClientConfiguration(err), http.StatusBadRequest, fmt.Sprintf("Could not create Kubernetes API client: %s", err.Error()))
+		return
+	}
+
+	middleware.Write(w, r, struct {
+		Interval int64                       `json:"interval"`
+		Series   map[string][]metrics.Sample `json:"series"`
+	}{
+		Interval: int64(s.metricsSampler.Interval().Seconds()),
+		Series:   s.metricsSampler.Query(getClusterFromHeaders(r), clientset, targets),
+	})
+}
+
+// topHandler returns the usage of all Pods in a namespace or of a workload, joined with the requests and limits of the
+// Pods and the status of the HPAs. The namespace is specified via the "namespace" query parameter, the workload via the
+// "kind" and "name" query parameters.
+func (s *server) topHandler(w http.ResponseWriter, r *http.Request) {
+	options := metrics.TopOptions{
+		Namespace: r.URL.Query().Get("namespace"),
+		Kind:      r.URL.Query().Get("kind"),
+		Name:      r.URL.Query().Get("name"),
+	}
+
+	_, clientset, err := s.getClientFromHeaders(r)
+	if err != nil {
+		middleware.Errorf(w, r, middleware.ClientConfiguration(err), http.StatusBadRequest, fmt.Sprintf("Could not create Kubernetes API client: %s", err.Error()))
+		return
+	}
+
+	top, err := metrics.GetTop(r.Context(), clientset, options)
+	if err != nil {
+		middleware.Errorf(w, r, err, http.StatusInternalServerError, fmt.Sprintf("Could not get usage: %s", err.Error()))
+		return
+	}
+
+	middleware.Write(w, r, top)
+}
+
+// storageSummaryHandler returns the filesystem usage of the Nodes and the ephemeral storage and volume usage of the
+// Pods, from the summary API of the kubelet. The Node can be selected via the "node" query parameter, if it is empty
+// the summary for all Nodes is returned. The "namespace" query parameter can be used to only return the Pods and PVCs
+// of a single namespace.
+func (s *server) storageSummaryHandler(w http.ResponseWriter, r *http.Request) {
+	_, clientset, err := s.getClientFromHeaders(r)
+	if err != nil {
+		middleware.Errorf(w, r, middleware.ClientConfiguration(err), http.StatusBadRequest, fmt.Sprintf("Could not create Kubernetes API client: %s", err.Error()))
+		return
+	}
+
+	summary, err := metrics.GetSummary(r.Context(), clientset, r.URL.Query().Get("node"), r.URL.Query().Get("namespace"))
+	if err != nil {
+		// The summary is only available via the proxy subresource of the Nodes, which isn't granted to most users, so
+		// that we return a meaningful error for this case.
+		if apierrors.IsForbidden(err) {
+			middleware.Errorf(w, r, err, http.StatusForbidden, fmt.Sprintf("Could not get summary, the \"get\" permission for the \"nodes/proxy\" resource is required: %s", err.Error()))
+			return
+		}
+
+		middleware.Errorf(w, r, err, http.StatusInternalServerError, fmt.Sprintf("Could not get summary: %s", err.Error()))
+		return
+	}
+
+	middleware.Write(w, r, summary)
+}
+
+// storagePVCsHandler returns all PersistentVolumeClaims joined with their PersistentVolumes, StorageClasses, the usage
+// reported by the kubelets and the events of pending claims (see metrics.GetPVCOverview). The claims can be filtered
+// via the "namespace" query parameter and sorted via the "sort" parameter ("usage" or "name"). When the usage can not
+// be fetched from the kubelets, all other information is still returned.
+func (s *server) storagePVCsHandler(w http.ResponseWriter, r *http.Request) {
+	sortBy := r.URL.Query().Get("sort")
+	if sortBy != "" && sortBy != metrics.PVCSortUsage && sortBy != metrics.PVCSortName {
+		err := fmt.Errorf("invalid sort order %s", sortBy)
+		middleware.Errorf(w, r, middleware.InvalidParameters(err), http.StatusBadRequest, fmt.Sprintf("Invalid parameters: %s", err.Error()))
+		return
+	}
+
+	_, clientset, err := s.getClientFromHeaders(r)
+	if err != nil {
+		middleware.Errorf(w, r, middleware.ClientConfiguration(err), http.StatusBadRequest, fmt.Sprintf("Could not create Kubernetes API client: %s", err.Error()))
+		return
+	}
+
+	overview, err := metrics.GetPVCOverview(r.Context(), clientset, r.URL.Query().Get("namespace"), sortBy)
+	if err != nil {
+		middleware.Errorf(w, r, err, http.StatusInternalServerError, fmt.Sprintf("Could not get persistent volume claims: %s", err.Error()))
+		return
+	}
+
+	middleware.Write(w, r, overview)
+}
+
+// nodesSummaryHandler returns the conditions, taints, versions and the allocatable resources compared to the requests
+// of all Pods for a page of Nodes (see metrics.GetNodeSummaries). The page size can be set via the "limit" query
+// parameter, the next page can be requested by passing the returned token via the "continue" parameter.
+func (s *server) nodesSummaryHandler(w http.ResponseWriter, r *http.Request) {
+	var limit int64
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsedLimit, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || parsedLimit <= 0 {
+			err = fmt.Errorf("limit must be a positive number")
+			middleware.Errorf(w, r, middleware.InvalidParameters(err), http.StatusBadRequest, fmt.Sprintf("Invalid parameters: %s", err.Error()))
+			return
+		}
+		limit = parsedLimit
+	}
+
+	_, clientset, err := s.getClientFromHeaders(r)
+	if err != nil {
+		middleware.Errorf(w, r, middleware.ClientConfiguration(err), http.StatusBadRequest, fmt.Sprintf("Could not create Kubernetes API client: %s", err.Error()))
+		return
+	}
+
+	summaries, err := metrics.GetNodeSummaries(r.Context(), clientset, limit, r.URL.Query().Get("continue"))
+	if err != nil {
+		code, err := resourcesError(err)
+		middleware.Errorf(w, r, err, code, fmt.Sprintf("Could not get nodes: %s", err.Error()))
+		return
+	}
+
+	middleware.Write(w, r, summaries)
+}
+
+// overviewHandler returns the aggregated overview for a cluster or for the namespace from the "namespace" query
+// parameter (see overview.Get).
+func (s *server) overviewHandler(w http.ResponseWriter, r *http.Request) {
+	restConfig, clientset, err := s.getClientFromHeaders(r)
+	if err != nil {
+		middleware.Errorf(w, r, middleware.ClientConfiguration(err), http.StatusBadRequest, fmt.Sprintf("Could not create Kubernetes API client: %s", err.Error()))
+		return
+	}
+
+	clusterOverview, err := overview.Get(r.Context(), restConfig, clientset, r.URL.Query().Get("namespace"))
+	if err != nil {
+		middleware.Errorf(w, r, err, http.StatusInternalServerError, fmt.Sprintf("Could not get overview: %s", err.Error()))
+		return
+	}
+
+	middleware.Write(w, r, clusterOverview)
+}
+
+// resourcesHandler lists the objects of any resource of the cluster. The resource is resolved via the cached discovery
+// data, so that the client only has to provide the group, version and resource or kind. When the "light" query
+// parameter is "table" or "metadata", the Table or PartialObjectMetadata representation is returned, which is much
+// smaller than the full objects. When the "resourceVersion" parameter is set to the resource version of a previously
+// fetched list, the objects are only returned if something was changed, so that polling clients do not have to
+// download an unchanged list again.
+func (s *server) resourcesHandler(w http.ResponseWriter, r *http.Request) {
+	options, err := resources.ListOptionsFromQuery(r.URL.Query())
+	if err != nil {
+		middleware.Errorf(w, r, middleware.InvalidParameters(err), http.StatusBadRequest, fmt.Sprintf("Invalid parameters: %s", err.Error()))
+		return
+	}
+
+	_, clientset, err := s.getClientFromHeaders(r)
+	if err != nil {
+		middleware.Errorf(w, r, middleware.ClientConfiguration(err), http.StatusBadRequest, fmt.Sprintf("Could not create Kubernetes API client: %s", err.Error()))
+		return
+	}
+
+	list, err := resources.Get(r.Context(), s.discoveryCache, getClusterFromHeaders(r), clientset, options)
+	if err != nil {
+		middleware.Errorf(w, r, err, http.StatusInternalServerError, fmt.Sprintf("Could not list resources: %s", err.Error()))
+		return
+	}
+
+	middleware.Write(w, r, list)
+}
+
+// resourcesDeleteHandler deletes a single object of any resource, see resources.DeleteOptions for the format of the
+// request body. The response contains if the object was removed or if it is still being deleted, e.g. because the
+// foreground deletion waits for the dependents of the object. Errors of the Kubernetes API are returned with the status
+// code of the API server.
+func (s *server) resourcesDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		err := fmt.Errorf("method %s is not allowed", r.Method)
+		middleware.Errorf(w, r, middleware.WithCode(middleware.CodeMethodNotAllowed, err), http.StatusMethodNotAllowed, fmt.Sprintf("Invalid request: %s", err.Error()))
+		return
+	}
+
+	var options resources.DeleteOptions
+	if !s.decodeRequestBody(w, r, &options) {
+		return
+	}
+
+	_, clientset, err := s.getClientFromHeaders(r)
+	if err != nil {
+		middleware.Errorf(w, r, middleware.ClientConfiguration(err), http.StatusBadRequest, fmt.Sprintf("Could not create Kubernetes API client: %s", err.Error()))
+		return
+	}
+
+	target := options.Resource
+	if target == "" {
+		target = options.Kind
+	}
+
+	result, err := resources.Delete(r.Context(), s.discoveryCache, getClusterFromHeaders(r), clientset, options)
+	s.auditMutation(middleware.GetRequestID(r.Context()), http.MethodDelete, getClusterFromHeaders(r), target+"/"+options.Namespace+"/"+options.Name, 0, err)
+	if err != nil {
+		statusCode, err := resourcesError(err)
+		middleware.Errorf(w, r, err, statusCode, fmt.Sprintf("Could not delete resource: %s", err.Error()))
+		return
+	}
+
+	middleware.Write(w, r, result)
+}
+
+// podsForceDeleteHandler force deletes Pods, which are stuck in the Terminating state, see pods.ForceDeleteOptions for
+// the format of the request body. The response contains the result for each matched Pod and a warning about the risks
+// of a force deletion. Each deleted Pod is recorded in the audit log.
+func (s *server) podsForceDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		err := fmt.Errorf("method %s is not allowed", r.Method)
+		middleware.Errorf(w, r, middleware.WithCode(middleware.CodeMethodNotAllowed, err), http.StatusMethodNotAllowed, fmt.Sprintf("Invalid request: %s", err.Error()))
+		return
+	}
+
+	var options pods.ForceDeleteOptions
+	if !s.decodeRequestBody(w, r, &options) {
+		return
+	}
+
+	_, clientset, err := s.getClientFromHeaders(r)
+	if err != nil {
+		middleware.Errorf(w, r, middleware.ClientConfiguration(err), http.StatusBadRequest, fmt.Sprintf("Could not create Kubernetes API client: %s", err.Error()))
+		return
+	}
+
+	result, err := pods.ForceDelete(r.Context(), clientset, options)
+	if err != nil {
+		statusCode, err := resourcesError(err)
+		middleware.Errorf(w, r, err, statusCode, fmt.Sprintf("Could not force delete pods: %s", err.Error()))
+		return
+	}
+
+	for _, pod := range result.Pods {
+		if pod.Status == pods.StatusSkipped {
+			continue
+		}
+
+		var podErr error
+		if pod.Status == pods.StatusFailed {
+			podErr = errors.New(pod.Message)
+		}
+		s.auditMutation(middleware.GetRequestID(r.Context()), "force-delete", getClusterFromHeaders(r), "pods/"+pod.Namespace+"/"+pod.Name, 0, podErr)
+	}
+
+	middleware.Write(w, r, result)
+}
+
+// podsImagesHandler returns all unique images, which are used by the Pods in the cluster, with the namespaces and
+// workloads using them (see pods.GetImages). The images can be filtered via the "namespace", "registry" and
+// "repository" query parameters.
+func (s *server) podsImagesHandler(w http.ResponseWriter, r *http.Request) {
+	_, clientset, err := s.getClientFromHeaders(r)
+	if err != nil {
+		middleware.Errorf(w, r, middleware.ClientConfiguration(err), http.StatusBadRequest, fmt.Sprintf("Could not create Kubernetes API client: %s", err.Error()))
+		return
+	}
+
+	inventory, err := pods.GetImages(r.Context(), clientset, pods.ImagesOptions{
+		Namespace:  r.URL.Query().Get("namespace"),
+		Registry:   r.URL.Query().Get("registry"),
+		Repository: r.URL.Query().Get("repository"),
+	})
+	if err != nil {
+		statusCode, err := resourcesError(err)
+		middleware.Errorf(w, r, err, statusCode, fmt.Sprintf("Could not get images: %s", err.Error()))
+		return
+	}
+
+	middleware.Write(w, r, inventory)
+}
+
+// podsUnhealthyHandler returns all Pods with problems, like crash loops, image pull errors, restarts, OOM kills and Pods
+// which are pending for too long (see pods.GetUnhealthy). The Pods can be filtered via the "namespace" query parameter,
+// the thresholds can be set via the "restartThreshold" and "pendingMinutes" query parameters.
+func (s *server) podsUnhealthyHandler(w http.ResponseWriter, r *http.Request) {
+	options := pods.UnhealthyOptions{Namespace: r.URL.Query().Get("namespace")}
+
+	if value := r.URL.Query().Get("restartThreshold"); value != "" {
+		restartThreshold, err := strconv.ParseInt(value, 10, 32)
+		if err != nil || restartThreshold < 0 {
+			err = fmt.Errorf("restartThreshold must be a non-negative number")
+			middleware.Errorf(w, r, middleware.InvalidParameters(err), http.StatusBadRequest, fmt.Sprintf("Invalid parameters: %s", err.Error()))
+			return
+		}
+		options.RestartThreshold = int32(restartThreshold)
+	}
+
+	if value := r.URL.Query().Get("pendingMinutes"); value != "" {
+		pendingMinutes, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || pendingMinutes < 0 {
+			err = fmt.Errorf("pendingMinutes must be a non-negative number")
+			middleware.Errorf(w, r, middleware.InvalidParameters(err), http.StatusBadRequest, fmt.Sprintf("Invalid parameters: %s", err.Error()))
+			return
+		}
+		options.PendingMinutes = pendingMinutes
+	}
+
+	_, clientset, err := s.getClientFromHeaders(r)
+	if err != nil {
+		middleware.Errorf(w, r, middleware.ClientConfiguration(err), http.StatusBadRequest, fmt.Sprintf("Could not create Kubernetes API client: %s", err.Error()))
+		return
+	}
+
+	result, err := pods.GetUnhealthy(r.Context(), clientset, options)
+	if err != nil {
+		statusCode, err := resourcesError(err)
+		middleware.Errorf(w, r, err, statusCode, fmt.Sprintf("Could not get unhealthy pods: %s", err.Error()))
+		return
+	}
+
+	middleware.Write(w, r, result)
+}
+
+// cronJobsSuspendHandler suspends a single CronJob or all CronJobs matching a label selector, see cronjobs.Options for
+// the format of the request body. The response contains the new state of each matched CronJob.
+func (s *server) cronJobsSuspendHandler(w http.ResponseWriter, r *http.Request) {
+	s.cronJobsSetSuspend(w, r, true)
+}
+
+// cronJobsResumeHandler resumes a single CronJob or all CronJobs matching a label selector, see cronjobs.Options for the
+// format of the request body. The response contains the new state of each matched CronJob.
+func (s *server) cronJobsResumeHandler(w http.ResponseWriter, r *http.Request) {
+	s.cronJobsSetSuspend(w, r, false)
+}
+
+// cronJobsSetSuspend implements the cronJobsSuspendHandler and cronJobsResumeHandler. Each CronJob which was changed is
+// recorded in the audit log.
+func (s *server) cronJobsSetSuspend(w http.ResponseWriter, r *http.Request, suspend bool) {
+	if r.Method != http.MethodPost {
+		err := fmt.Errorf("method %s is not allowed", r.Method)
+		middleware.Errorf(w, r, middleware.WithCode(middleware.CodeMethodNotAllowed, err), http.StatusMethodNotAllowed, fmt.Sprintf("Invalid request: %s", err.Error()))
+		return
+	}
+
+	var options cronjobs.Options
+	if !s.decodeRequestBody(w, r, &options) {
+		return
+	}
+
+	_, clientset, err := s.getClientFromHeaders(r)
+	if err != nil {
+		middleware.Errorf(w, r, middleware.ClientConfiguration(err), http.StatusBadRequest, fmt.Sprintf("Could not create Kubernetes API client: %s", err.Error()))
+		return
+	}
+
+	action, update := "resume", cronjobs.ResumeCronJob
+	if suspend {
+		action, update = "suspend", cronjobs.SuspendCronJob
+	}
+
+	result, err := update(r.Context(), clientset, options)
+	if err != nil {
+		statusCode, err := resourcesError(err)
+		middleware.Errorf(w, r, err, statusCode, fmt.Sprintf("Could not %s cronjobs: %s", action, err.Error()))
+		return
+	}
+
+	for _, cronJob := range result.CronJobs {
+		if cronJob.Status == cronjobs.StatusUnchanged {
+			continue
+		}
+
+		var cronJobErr error
+		if cronJob.Status == cronjobs.StatusFailed {
+			cronJobErr = errors.New(cronJob.Message)
+		}
+		s.auditMutation(middleware.GetRequestID(r.Context()), action, getClusterFromHeaders(r), "cronjobs/"+cronJob.Namespace+"/"+cronJob.Name, 0, cronJobErr)
+	}
+
+	middleware.Write(w, r, result)
+}
+
+// rbacCanIHandler checks if the current user is allowed to perform actions in the cluster (see rbac.CanI). A GET
+// request checks a single action from the "verb", "group", "resource", "subresource", "name", "namespace" and "path"
+// query parameters. A POST request checks all actions from the request body in parallel, see rbac.BatchOptions for the
+// format of the body. Reviews, which time out, are returned as "unknown".
+func (s *server) rbacCanIHandler(w http.ResponseWriter, r *http.Request) {
+	var options rbac.BatchOptions
+
+	switch r.Method {
+	case http.MethodGet:
+		query := r.URL.Query()
+		options.Reviews = []rbac.Review{{
+			Verb:        query.Get("verb"),
+			Group:       query.Get("group"),
+			Resource:    query.Get("resource"),
+			Subresource: query.Get("subresource"),
+			Name:        query.Get("name"),
+			Namespace:   query.Get("namespace"),
+			Path:        query.Get("path"),
+		}}
+	case http.MethodPost:
+		if !s.decodeRequestBody(w, r, &options) {
+			return
+		}
+	default:
+		err := fmt.Errorf("method %s is not allowed", r.Method)
+		middleware.Errorf(w, r, middleware.WithCode(middleware.CodeMethodNotAllowed, err), http.StatusMethodNotAllowed, fmt.Sprintf("Invalid request: %s", err.Error()))
+		return
+	}
+
+	_, clientset, err := s.getClientFromHeaders(r)
+	if err != nil {
+		middleware.Errorf(w, r, middleware.ClientConfiguration(err), http.StatusBadRequest, fmt.Sprintf("Could not create Kubernetes API client: %s", err.Error()))
+		return
+	}
+
+	results, err := rbac.CanIBatch(r.Context(), clientset, options)
+	if err != nil {
+		statusCode, err := resourcesError(err)
+		middleware.Errorf(w, r, err, statusCode, fmt.Sprintf("Could not review access: %s", err.Error()))
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		middleware.Write(w, r, results[0])
+		return
+	}
+
+	middleware.Write(w, r, results)
+}
+
+// rbacWhoCanHandler returns all RoleBindings and ClusterRoleBindings, which allow their subjects to perform the verb on
+// the resource from the "verb", "group", "resource", "subresource", "name" and "namespace" query parameters (see
+// rbac.WhoCan).
+func (s *server) rbacWhoCanHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	options := rbac.WhoCanOptions{
+		Verb:        query.Get("verb"),
+		Group:       query.Get("group"),
+		Resource:    query.Get("resource"),
+		Subresource: query.Get("subresource"),
+		Name:        query.Get("name"),
+		Namespace:   query.Get("namespace"),
+	}
+
+	_, clientset, err := s.getClientFromHeaders(r)
+	if err != nil {
+		middleware.Errorf(w, r, middleware.ClientConfiguration(err), http.StatusBadRequest, fmt.Sprintf("Could not create Kubernetes API client: %s", err.Error()))
+		return
+	}
+
+	result, err := rbac.WhoCan(r.Context(), clientset, options)
+	if err != nil {
+		statusCode, err := resourcesError(err)
+		middleware.Errorf(w, r, err, statusCode, fmt.Sprintf("Could not evaluate rbac rules: %s", err.Error()))
+		return
+	}
+
+	middleware.Write(w, r, result)
+}
+
+// serviceAccountsTokenHandler generates a short-lived token for a ServiceAccount via the TokenRequest API, see
+// serviceaccounts.TokenOptions for the format of the request body. The generation of a token is recorded in the audit
+// log, the token itself is never written to the log.
+func (s *server) serviceAccountsTokenHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		err := fmt.Errorf("method %s is not allowed", r.Method)
+		middleware.Errorf(w, r, middleware.WithCode(middleware.CodeMethodNotAllowed, err), http.StatusMethodNotAllowed, fmt.Sprintf("Invalid request: %s", err.Error()))
+		return
+	}
+
+	var options serviceaccounts.TokenOptions
+	if !s.decodeRequestBody(w, r, &options) {
+		return
+	}
+
+	config, clientset, err := s.getClientFromHeaders(r)
+	if err != nil {
+		middleware.Errorf(w, r, middleware.ClientConfiguration(err), http.StatusBadRequest, fmt.Sprintf("Could not create Kubernetes API client: %s", err.Error()))
+		return
+	}
+
+	token, err := serviceaccounts.CreateToken(r.Context(), config, clientset, options)
+	s.auditMutation(middleware.GetRequestID(r.Context()), "create-token", getClusterFromHeaders(r), "serviceaccounts/"+options.Namespace+"/"+options.Name, 0, err)
+	if err != nil {
+		statusCode, err := serviceAccountsError(err)
+		middleware.Errorf(w, r, err, statusCode, fmt.Sprintf("Could not create token: %s", err.Error()))
+		return
+	}
+
+	middleware.Write(w, r, token)
+}
+
+// snapshotsExportHandler exports all objects of a namespace as gzip compressed tar archive with one YAML file per
+// object. The namespace is specified via the "namespace" query parameter, the exported resources can be filtered via
+// the comma separated "kinds" parameter and the "labelSelector" parameter. Secrets are only exported, when the
+// "includeSecrets" parameter is "true". The archive contains the file "index.yaml" with all exported objects and the
+// resources which could not be exported.
+func (s *server) snapshotsExportHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	options := snapshots.ExportOptions{
+		Namespace:      query.Get("namespace"),
+		LabelSelector:  query.Get("labelSelector"),
+		IncludeSecrets: query.Get("includeSecrets") == "true",
+	}
+	if kinds := query.Get("kinds"); kinds != "" {
+		options.Kinds = strings.Split(kinds, ",")
+	}
+
+	if options.Namespace == "" {
+		middleware.Errorf(w, r, middleware.InvalidParameters(nil), http.StatusBadRequest, "Invalid parameters: namespace is required")
+		return
+	}
+
+	_, clientset, err := s.getClientFromHeaders(r)
+	if err != nil {
+		middleware.Errorf(w, r, middleware.ClientConfiguration(err), http.StatusBadRequest, fmt.Sprintf("Could not create Kubernetes API client: %s", err.Error()))
+		return
+	}
+
+	// The headers are only written with the first byte of the archive, so that we can still return an error when the
+	// discovery of the resources fails. Errors of single resources are recorded in the index of the archive.
+	writer := &attachmentWriter{w: w, filename: options.Namespace + ".tar.gz", contentType: "application/gzip"}
+	_, err = snapshots.ExportNamespace(r.Context(), s.discoveryCache, getClusterFromHeaders(r), clientset, options, writer, nil)
+	if err != nil {
+		if writer.written {
+			log.Printf("Could not write snapshot: %s", middleware.ScrubRequest(r, err.Error()))
+			return
+		}
+
+		statusCode, err := resourcesError(err)
+		middleware.Errorf(w, r, err, statusCode, fmt.Sprintf("Could not export namespace: %s", err.Error()))
+	}
+}
+
+// debugBundleHandler returns a gzip compressed tar archive with all information to debug a workload, see
+// debugbundle.Create. The workload is specified via the "kind", "namespace" and "name" query parameters, the limits of
+// the bundle can be set via the "tailLines", "maxLogBytes" and "maxSize" parameters. The archive contains the file
+// "summary.yaml" with all files and the items which could not be collected.
+func (s *server) debugBundleHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	options := debugbundle.Options{
+		Kind:      query.Get("kind"),
+		Namespace: query.Get("namespace"),
+		Name:      query.Get("name"),
+	}
+
+	limits := []struct {
+		parameter string
+		value     *int64
+	}{{"tailLines", &options.TailLines}, {"maxLogBytes", &options.MaxLogBytes}, {"maxSize", &options.MaxSize}}
+
+	for _, limit := range limits {
+		if value := query.Get(limit.parameter); value != "" {
+			parsedValue, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || parsedValue < 0 {
+				err = fmt.Errorf("%s must be a non-negative number", limit.parameter)
+				middleware.Errorf(w, r, middleware.InvalidParameters(err), http.StatusBadRequest, fmt.Sprintf("Invalid parameters: %s", err.Error()))
+				return
+			}
+			*limit.value = parsedValue
+		}
+	}
+
+	_, clientset, err := s.getClientFromHeaders(r)
+	if err != nil {
+		middleware.Errorf(w, r, middleware.ClientConfiguration(err), http.StatusBadRequest, fmt.Sprintf("Could not create Kubernetes API client: %s", err.Error()))
+		return
+	}
+
+	// The headers are only written with the first byte of the archive, so that we can still return an error for
+	// invalid options. Errors of single items are recorded in the summary of the archive.
+	writer := &attachmentWriter{w: w, filename: options.Namespace + "-" + options.Name + "-debug.tar.gz", contentType: "application/gzip"}
+	_, err = debugbundle.Create(r.Context(), clientset, options, writer, nil)
+	if err != nil {
+		if writer.written {
+			log.Printf("Could not write debug bundle: %s", middleware.ScrubRequest(r, err.Error()))
+			return
+		}
+
+		statusCode, err := resourcesError(err)
+		middleware.Errorf(w, r, err, statusCode, fmt.Sprintf("Could not create debug bundle: %s", err.Error()))
+	}
+}
+
+// snapshotsRestoreHandler restores the objects of a gzip compressed tar archive, see snapshots.RestoreOptions for the
+// format of the request body. The response contains the result for each object of the archive. Each object which was
+// created or changed is written to the audit log, a dry run is not recorded.
+func (s *server) snapshotsRestoreHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		err := fmt.Errorf("method %s is not allowed", r.Method)
+		middleware.Errorf(w, r, middleware.WithCode(middleware.CodeMethodNotAllowed, err), http.StatusMethodNotAllowed, fmt.Sprintf("Invalid request: %s", err.Error()))
+		return
+	}
+
+	var options snapshots.RestoreOptions
+	if !s.decodeRequestBody(w, r, &options) {
+		return
+	}
+
+	_, clientset, err := s.getClientFromHeaders(r)
+	if err != nil {
+		middleware.Errorf(w, r, middleware.ClientConfiguration(err), http.StatusBadRequest, fmt.Sprintf("Could not create Kubernetes API client: %s", err.Error()))
+		return
+	}
+
+	result, err := snapshots.RestoreArchive(r.Context(), s.discoveryCache, s.schemaCache, getClusterFromHeaders(r), clientset, options)
+	if err != nil {
+		statusCode, err := resourcesError(err)
+		middleware.Errorf(w, r, err, statusCode, fmt.Sprintf("Could not restore snapshot: %s", err.Error()))
+		return
+	}
+
+	if !options.DryRun {
+		for _, object := range result.Objects {
+			if object.Action == snapshots.ActionUnchanged || object.Action == snapshots.ActionSkipped || object.Action == snapshots.ActionInvalid {
+				continue
+			}
+
+			var objectErr error
+			if object.Action == snapshots.ActionFailed {
+				objectErr = errors.New(object.Error)
+			}
+			s.auditMutation(middleware.GetRequestID(r.Context()), "restore", getClusterFromHeaders(r), strings.ToLower(object.Kind)+"/"+object.Namespace+"/"+object.Name, 0, objectErr)
+		}
+	}
+
+	middleware.Write(w, r, result)
+}
+
+// manifestsValidateHandler validates a manifest against the OpenAPI schema of the cluster, without sending it to the
+// Kubernetes API. The request body must contain the manifest in the "manifest" field, the response contains the
+// validation errors of each document with their line and path.
+func (s *server) manifestsValidateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		err := fmt.Errorf("method %s is not allowed", r.Method)
+		middleware.Errorf(w, r, middleware.WithCode(middleware.CodeMethodNotAllowed, err), http.StatusMethodNotAllowed, fmt.Sprintf("Invalid request: %s", err.Error()))
+		return
+	}
+
+	var request struct {
+		Manifest string `json:"manifest"`
+	}
+	if !s.decodeRequestBody(w, r, &request) {
+		return
+	}
+
+	_, clientset, err := s.getClientFromHeaders(r)
+	if err != nil {
+		middleware.Errorf(w, r, middleware.ClientConfiguration(err), http.StatusBadRequest, fmt.Sprintf("Could not create Kubernetes API client: %s", err.Error()))
+		return
+	}
+
+	result, err := validation.ValidateManifest(r.Context(), s.schemaCache, getClusterFromHeaders(r), clientset, request.Manifest)
+	if err != nil {
+		statusCode, err := validationError(err)
+		middleware.Errorf(w, r, err, statusCode, fmt.Sprintf("Could not validate manifest: %s", err.Error()))
+		return
+	}
+
+	middleware.Write(w, r, result)
+}
+
+// namespacesDeleteHandler deletes a namespace, see namespaces.Options for the format of the request body. The response
+// contains the status of the deletion, with all objects which are remaining in the namespace and their finalizers.
+// The progress of the deletion can then be watched via the namespacesDeletionHandler.
+func (s *server) namespacesDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		err := fmt.Errorf("method %s is not allowed", r.Method)
+		middleware.Errorf(w, r, middleware.WithCode(middleware.CodeMethodNotAllowed, err), http.StatusMethodNotAllowed, fmt.Sprintf("Invalid request: %s", err.Error()))
+		return
+	}
+
+	var options namespaces.Options
+	if !s.decodeRequestBody(w, r, &options) {
+		return
+	}
+
+	_, clientset, err := s.getClientFromHeaders(r)
+	if err != nil {
+		middleware.Errorf(w, r, middleware.ClientConfiguration(err), http.StatusBadRequest, fmt.Sprintf("Could not create Kubernetes API client: %s", err.Error()))
+		return
+	}
+
+	action := "namespace-delete"
+	if options.Finalize {
+		action = "namespace-finalize"
+	}
+
+	status, err := namespaces.Delete(r.Context(), s.discoveryCache, getClusterFromHeaders(r), clientset, options)
+	s.auditMutation(middleware.GetRequestID(r.Context()), action, getClusterFromHeaders(r), "namespaces/"+options.Name, 0, err)
+	if err != nil {
+		statusCode, err := resourcesError(err)
+		middleware.Errorf(w, r, err, statusCode, fmt.Sprintf("Could not delete namespace: %s", err.Error()))
+		return
+	}
+
+	middleware.Write(w, r, status)
+}
+
+// namespacesDeletionHandler streams the progress of a namespace deletion. The namespace is specified via the "name"
+// query parameter, the interval between two status updates and the maximum duration of the stream via the "interval"
+// and "timeout" parameters in seconds.
+//
+// Each status is send as "progress" message (see "namespaces.Message"), which contains the remaining objects and their
+// finalizers. The stream ends with a final "deleted", "timeout" or "error" message. When the client requests an event
+// stream, the messages are send as Server-Sent Events, where the event type is the operation of the message.
+func (s *server) namespacesDeletionHandler(w http.ResponseWriter, r *http.Request) {
+	options, err := namespaces.StreamOptionsFromQuery(r.URL.Query())
+	if err != nil {
+		middleware.Errorf(w, r, middleware.InvalidParameters(err), http.StatusBadRequest, fmt.Sprintf("Invalid parameters: %s", err.Error()))
+		return
+	}
+
+	_, clientset, err := s.getClientFromHeaders(r)
+	if err != nil {
+		middleware.Errorf(w, r, middleware.ClientConfiguration(err), http.StatusBadRequest, fmt.Sprintf("Could not create Kubernetes API client: %s", err.Error()))
+		return
+	}
+
+	cluster := getClusterFromHeaders(r)
+	errorMessage := func(err error) namespaces.Message {
+		return namespaces.Message{Op: namespaces.OpError, Name: options.Name, Message: err.Error()}
+	}
+
+	if isEventStream(r) {
+		writer, err := newEventStreamWriter(w)
+		if err != nil {
+			middleware.Errorf(w, r, err, http.StatusInternalServerError, fmt.Sprintf("Could not create event stream: %s", err.Error()))
+			return
+		}
+
+		ctx := r.Context()
+		go writer.heartbeat(ctx.Done())
+
+		err = namespaces.Stream(ctx, s.discoveryCache, cluster, clientset, options, func(msg namespaces.Message) error {
+			return writer.WriteEvent(msg.Op, "", msg)
+		})
+		if err != nil && ctx.Err() == nil {
+			writer.WriteEvent(namespaces.OpError, "", errorMessage(err))
+		}
+		return
+	}
+
+	upgrader := s.newUpgrader()
+
+	c, err := s.upgrade(upgrader, w, r)
+	if err != nil {
+		middleware.Errorf(w, r, middleware.UpgradeFailed(err), http.StatusBadRequest, fmt.Sprintf("Could not upgrade connection: %s", err.Error()))
+		return
+	}
+	defer s.closeConnection(c)
+
+	// The stream is stopped as soon as the client closes the WebSocket connection.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go keepAlive(c, ctx.Done())
+	go cancelOnClose(c, cancel)
+
+	writer := newWebSocketWriter(c)
+
+	err = namespaces.Stream(ctx, s.discoveryCache, cluster, clientset, options, func(msg namespaces.Message) error {
+		return writer.WriteJSON(msg)
+	})
+	if err != nil && ctx.Err() == nil {
+		writer.WriteJSON(errorMessage(err))
+	}
+
+	writer.Close(websocket.CloseNormalClosure, "")
+}
+
+// configDataUpdateHandler updates the data of a ConfigMap or Secret, see configdata.UpdateOptions for the format of the
+// request body. Conflicts with concurrent changes are resolved on the level of the data keys. When the same key was
+// changed on both sides, the object isn't updated and the response contains the conflicting keys.
+func (s *server) configDataUpdateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		err := fmt.Errorf("method %s is not allowed", r.Method)
+		middleware.Errorf(w, r, middleware.WithCode(middleware.CodeMethodNotAllowed, err), http.StatusMethodNotAllowed, fmt.Sprintf("Invalid request: %s", err.Error()))
+		return
+	}
+
+	var options configdata.UpdateOptions
+	if !s.decodeRequestBody(w, r, &options) {
+		return
+	}
+
+	_, clientset, err := s.getClientFromHeaders(r)
+	if err != nil {
+		middleware.Errorf(w, r, middleware.ClientConfiguration(err), http.StatusBadRequest, fmt.Sprintf("Could not create Kubernetes API client: %s", err.Error()))
+		return
+	}
+
+	result, err := configdata.Update(r.Context(), clientset, options)
+	if err != nil || result.Updated {
+		s.auditMutation(middleware.GetRequestID(r.Context()), http.MethodPut, getClusterFromHeaders(r), options.Kind+"/"+options.Namespace+"/"+options.Name, 0, err)
+	}
+	if err != nil {
+		statusCode, err := resourcesError(err)
+		middleware.Errorf(w, r, err, statusCode, fmt.Sprintf("Could not update %s: %s", options.Kind, err.Error()))
+		return
+	}
+
+	middleware.Write(w, r, result)
+}
+
+// proxyHandler forwards a request to the Kubernetes API, similar to "kubectl proxy". The target path is the path of the
+// request without the "/api/proxy" prefix, the method, body, query parameters and the "Accept" and "Content-Type"
+// headers are preserved. The credentials are passed via the headers.
+//
+// The response is returned with the original status code and content type. Streaming responses (watch requests and
+// followed logs) are streamed to the client, all other responses are buffered up to the maximum response size. Larger
+// responses are truncated and the "X-RESPONSE-TRUNCATED" and "X-RESPONSE-LIMIT" headers are set. Concurrent identical
+// GET requests share a single request to the Kubernetes API (see proxyDeduplicated). Mutating requests are written to
+// the audit log.
+func (s *server) proxyHandler(w http.ResponseWriter, r *http.Request) {
+	requestPath := strings.TrimPrefix(r.URL.Path, "/api/proxy")
+	if err := proxy.ValidatePath(requestPath); err != nil {
+		middleware.Errorf(w, r, middleware.InvalidParameters(err), http.StatusBadRequest, fmt.Sprintf("Invalid path: %s", err.Error()))
+		return
+	}
+
+	restConfig, clientset, err := s.getClientFromHeaders(r)
+	if err != nil {
+		middleware.Errorf(w, r, middleware.ClientConfiguration(err), http.StatusBadRequest, fmt.Sprintf("Could not create Kubernetes API client: %s", err.Error()))
+		return
+	}
+
+	// The auth token of the server can be passed as query parameter, so that it must be removed before the request is
+	// forwarded.
+	query := r.URL.Query()
+	query.Del(middleware.AuthTokenParameter)
+
+	var body io.Reader
+	if r.Body != nil && r.Body != http.NoBody {
+		body = http.MaxBytesReader(w, r.Body, s.maxRequestBodySize())
+	}
+
+	req, err := proxy.NewRequest(r.Context(), restConfig, r.Method, requestPath, query, r.Header, body)
+	if err != nil {
+		middleware.Errorf(w, r, middleware.InvalidParameters(err), http.StatusBadRequest, fmt.Sprintf("Could not create request: %s", err.Error()))
+		return
+	}
+
+	if r.Method == http.MethodGet && !proxy.IsStreaming(query) {
+		s.proxyDeduplicated(w, r, restConfig, clientset, req)
+		return
+	}
+
+	resp, err := proxy.Do(clientset, restConfig, req)
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		statusCode := 0
+		if resp != nil {
+			statusCode = resp.StatusCode
+		}
+		s.auditMutation(middleware.GetRequestID(r.Context()), r.Method, getClusterFromHeaders(r), requestPath, statusCode, err)
+	}
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			middleware.Errorf(w, r, middleware.RequestTooLarge(err), http.StatusRequestEntityTooLarge, fmt.Sprintf("Request body exceeds the limit of %d bytes", s.maxRequestBodySize()))
+			return
+		}
+
+		middleware.Errorf(w, r, err, http.StatusBadGateway, fmt.Sprintf("Could not send request: %s", err.Error()))
+		return
+	}
+	defer resp.Body.Close()
+
+	proxy.CopyHeaders(w.Header(), resp.Header)
+
+	if proxy.IsStreaming(query) {
+		w.WriteHeader(resp.StatusCode)
+		copyAndFlush(w, resp.Body)
+		return
+	}
+
+	data, truncated, err := readLimited(resp.Body, s.maxResponseSize())
+	if err != nil {
+		middleware.Errorf(w, r, err, http.StatusBadGateway, fmt.Sprintf("Could not read response: %s", err.Error()))
+		return
+	}
+	if truncated {
+		w.Header().Set(proxy.TruncatedHeader, "true")
+		w.Header().Set(proxy.LimitHeader, strconv.FormatInt(s.maxResponseSize(), 10))
+	}
+
+	w.WriteHeader(resp.StatusCode)
+	w.Write(data)
+}
+
+// configHandler returns the current options of the server for a GET request. The auth token is redacted, because it
+// is already known by the client. A PUT request changes the options of the running server, the body can contain all or
+// only some of the options, options which are not contained in the body are not changed. If the body changes an option
+// which requires a restart of the server (e.g. the listen address or TLS), the request is rejected.
+func (s *server) configHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		middleware.Write(w, r, redactOptions(*s.getOptions()))
+		return
+	}
+
+	if r.Method == http.MethodPut {
+		options, err := s.copyOptions()
+		if err != nil {
+			middleware.Errorf(w, r, err, http.StatusInternalServerError, fmt.Sprintf("Could not copy options: %s", err.Error()))
+			return
+		}
+
+		// The auth token is redacted in the response of a GET request, so that a client could send the redacted value
+		// back. In this case we keep the current auth token.
+		currentAuthToken := options.AuthToken
+		if !s.decodeRequestBody(w, r, &options) {
+			return
+		}
+		if options.AuthToken == "[REDACTED]" {
+			options.AuthToken = currentAuthToken
+		}
+
+		if err := s.reloadOptions(options); err != nil {
+			middleware.Errorf(w, r, middleware.InvalidRequestBody(err), http.StatusBadRequest, fmt.Sprintf("Could not change options: %s", err.Error()))
+			return
+		}
+
+		middleware.Write(w, r, redactOptions(*s.getOptions()))
+		return
+	}
+
+	err := fmt.Errorf("method %s is not allowed", r.Method)
+	middleware.Errorf(w, r, middleware.WithCode(middleware.CodeMethodNotAllowed, err), http.StatusMethodNotAllowed, fmt.Sprintf("Invalid request: %s", err.Error()))
+}
+
+// selfCheckHandler runs the self-check of the required capabilities and returns the results.
+func (s *server) selfCheckHandler(w http.ResponseWriter, r *http.Request) {
+	middleware.Write(w, r, struct {
+		Results []selfcheck.Result `json:"results"`
+	}{
+		Results: selfcheck.Run(r.Context()),
+	})
+}
+
+// The default and maximum number of entries, which are returned by the auditHandler.
+const (
+	defaultAuditEntries = 100
+	maxAuditEntries     = 1000
+)
+
+// auditHandler returns the last entries of the audit log for mutating operations, the newest entry first, so that
+// they can be shown in the app. The number of entries can be set via the "limit" query parameter.
+func (s *server) auditHandler(w http.ResponseWriter, r *http.Request) {
+	if s.mutationAuditLog == nil {
+		middleware.Errorf(w, r, middleware.NotEnabled(nil), http.StatusNotFound, "Mutation audit log is not enabled")
+		return
+	}
+
+	limit := defaultAuditEntries
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsedLimit, err := strconv.Atoi(value)
+		if err != nil || parsedLimit <= 0 {
+			err = fmt.Errorf("limit must be a positive number")
+			middleware.Errorf(w, r, middleware.InvalidParameters(err), http.StatusBadRequest, fmt.Sprintf("Invalid parameters: %s", err.Error()))
+			return
+		}
+		limit = parsedLimit
+		if limit > maxAuditEntries {
+			limit = maxAuditEntries
+		}
+	}
+
+	entries, err := s.mutationAuditLog.ReadLast(limit)
+	if err != nil {
+		middleware.Errorf(w, r, err, http.StatusInternalServerError, fmt.Sprintf("Could not read audit log: %s", err.Error()))
+		return
+	}
+
+	middleware.Write(w, r, struct {
+		Entries []json.RawMessage `json:"entries"`
+	}{
+		Entries: entries,
+	})
+}
+
+// streamWatchEvents runs the given watch function and sends all events to the client. The events are send via a
+// WebSocket connection or as Server-Sent Events, when the client requested an event stream. For event streams the
+// resource version of an event is used as event id.
+func (s *server) streamWatchEvents(w http.ResponseWriter, r *http.Request, run func(ctx context.Context, send func(watch.Event) error) error) {
+	if isEventStream(r) {
+		writer, err := newEventStreamWriter(w)
+		if err != nil {
+			middleware.Errorf(w, r, err, http.StatusInternalServerError, fmt.Sprintf("Could not create event stream: %s", err.Error()))
+			return
+		}
+
+		ctx := r.Context()
+		go writer.heartbeat(ctx.Done())
+
+		err = run(ctx, func(event watch.Event) error {
+			return writer.WriteEvent(strings.ToLower(event.Type), event.ResourceVersion, event)
+		})
+		if err != nil && ctx.Err() == nil {
+			writer.WriteEvent(strings.ToLower(watch.EventError), "", watch.Event{Type: watch.EventError, Data: err.Error()})
+		}
+		return
+	}
+
+	upgrader := s.newUpgrader()
+
+	c, err := s.upgrade(upgrader, w, r)
+	if err != nil {
+		middleware.Errorf(w, r, middleware.UpgradeFailed(err), http.StatusBadRequest, fmt.Sprintf("Could not upgrade connection: %s", err.Error()))
+		return
+	}
+	defer s.closeConnection(c)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go keepAlive(c, ctx.Done())
+	go cancelOnClose(c, cancel)
+
+	writer := newWebSocketWriter(c)
+
+	err = run(ctx, func(event watch.Event) error {
+		return writer.WriteJSON(event)
+	})
+	if err != nil && ctx.Err() == nil {
+		writer.WriteJSON(watch.Event{Type: watch.EventError, Data: err.Error()})
+	}
+
+	writer.Close(websocket.CloseNormalClosure, "")
+}
+
+// certificatesHandler returns the TLS certificates of a cluster sorted by their time to expiry, see
+// certificates.OptionsFromQuery for the query parameters. The certificates are taken from the Certificate resources
+// of cert-manager and cross-checked with the certificates in their Secrets. Clusters without cert-manager fall back to
+// the TLS Secrets. Each certificate contains the Ingresses which reference its Secret and the hosts of them, which are
+// not covered by the certificate.
+func (s *server) certificatesHandler(w http.ResponseWriter, r *http.Request) {
+	options, err := certificates.OptionsFromQuery(r.URL.Query())
+	if err != nil {
+		middleware.Errorf(w, r, middleware.InvalidParameters(err), http.StatusBadRequest, fmt.Sprintf("Invalid parameters: %s", err.Error()))
+		return
+	}
+
+	_, clientset, err := s.getClientFromHeaders(r)
+	if err != nil {
+		middleware.Errorf(w, r, middleware.ClientConfiguration(err), http.StatusBadRequest, fmt.Sprintf("Could not create Kubernetes API client: %s", err.Error()))
+		return
+	}
+
+	result, err := certificates.Get(r.Context(), s.discoveryCache, getClusterFromHeaders(r), clientset, options)
+	if err != nil {
+		statusCode, err := resourcesError(err)
+		middleware.Errorf(w, r, err, statusCode, fmt.Sprintf("Could not get certificates: %s", err.Error()))
+		return
+	}
+
+	middleware.Write(w, r, result)
+}
+
+// gatekeeperViolationsHandler returns a summary of the audit violations of all Gatekeeper constraints. The violations
+// can be filtered by the namespace of the violating objects via the "namespace" query parameter. If Gatekeeper isn't
+// installed in the cluster, the "installed" field of the result is false.
+func (s *server) gatekeeperViolationsHandler(w http.ResponseWriter, r *http.Request) {
+	_, clientset, err := s.getClientFromHeaders(r)
+	if err != nil {
+		middleware.Errorf(w, r, middleware.ClientConfiguration(err), http.StatusBadRequest, fmt.Sprintf("Could not create Kubernetes API client: %s", err.Error()))
+		return
+	}
+
+	summary, err := gatekeeper.GetSummary(r.Context(), s.discoveryCache, getClusterFromHeaders(r), clientset, r.URL.Query().Get("namespace"))
+	if err != nil {
+		middleware.Errorf(w, r, err, http.StatusInternalServerError, fmt.Sprintf("Could not get Gatekeeper violations: %s", err.Error()))
+		return
+	}
+
+	middleware.Write(w, r, summary)
+}
+
+// fluxResourcesHandler lists the Kustomizations, HelmReleases and GitRepositories of Flux with their "Ready" condition
+// and revision. The resources can be filtered via the "namespace" and "kind" query parameters. If Flux isn't installed
+// in the cluster, the "installed" field of the result is false.
+func (s *server) fluxResourcesHandler(w http.ResponseWriter, r *http.Request) {
+	_, clientset, err := s.getClientFromHeaders(r)
+	if err != nil {
+		middleware.Errorf(w, r, middleware.ClientConfiguration(err), http.StatusBadRequest, fmt.Sprintf("Could not create Kubernetes API client: %s", err.Error()))
+		return
+	}
+
+	result, err := flux.List(r.Context(), s.discoveryCache, getClusterFromHeaders(r), clientset, r.URL.Query().Get("namespace"), r.URL.Query().Get("kind"))
+	if err != nil {
+		statusCode, err := fluxError(err)
+		middleware.Errorf(w, r, err, statusCode, fmt.Sprintf("Could not list Flux resources: %s", err.Error()))
+		return
+	}
+
+	middleware.Write(w, r, result)
+}
+
+// fluxReconcileHandler triggers the reconciliation of a Flux resource, see flux.ReconcileOptions for the format of the
+// request body. If the "wait" option is set, the response is returned when the reconciliation is finished or the
+// timeout is exceeded.
+func (s *server) fluxReconcileHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		err := fmt.Errorf("method %s is not allowed", r.Method)
+		middleware.Errorf(w, r, middleware.WithCode(middleware.CodeMethodNotAllowed, err), http.StatusMethodNotAllowed, fmt.Sprintf("Invalid request: %s", err.Error()))
+		return
+	}
+
+	var options flux.ReconcileOptions
+	if !s.decodeRequestBody(w, r, &options) {
+		return
+	}
+
+	_, clientset, err := s.getClientFromHeaders(r)
+	if err != nil {
+		middleware.Errorf(w, r, middleware.ClientConfiguration(err), http.StatusBadRequest, fmt.Sprintf("Could not create Kubernetes API client: %s", err.Error()))
+		return
+	}
+
+	result, err := flux.Reconcile(r.Context(), s.discoveryCache, getClusterFromHeaders(r), clientset, options)
+	s.auditMutation(middleware.GetRequestID(r.Context()), "flux-reconcile", getClusterFromHeaders(r), options.Kind+"/"+options.Namespace+"/"+options.Name, 0, err)
+	if err != nil {
+		statusCode, err := fluxError(err)
+		middleware.Errorf(w, r, err, statusCode, fmt.Sprintf("Could not reconcile Flux resource: %s", err.Error()))
+		return
+	}
+
+	middleware.Write(w, r, result)
+}
+
+// fluxSuspendHandler suspends or resumes a Kustomization or HelmRelease of Flux, see flux.SuspendOptions for the format
+// of the request body.
+func (s *server) fluxSuspendHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		err := fmt.Errorf("method %s is not allowed", r.Method)
+		middleware.Errorf(w, r, middleware.WithCode(middleware.CodeMethodNotAllowed, err), http.StatusMethodNotAllowed, fmt.Sprintf("Invalid request: %s", err.Error()))
+		return
+	}
+
+	var options flux.SuspendOptions
+	if !s.decodeRequestBody(w, r, &options) {
+		return
+	}
+
+	_, clientset, err := s.getClientFromHeaders(r)
+	if err != nil {
+		middleware.Errorf(w, r, middleware.ClientConfiguration(err), http.StatusBadRequest, fmt.Sprintf("Could not create Kubernetes API client: %s", err.Error()))
+		return
+	}
+
+	action := "flux-resume"
+	if options.Suspend {
+		action = "flux-suspend"
+	}
+
+	resource, err := flux.Suspend(r.Context(), s.discoveryCache, getClusterFromHeaders(r), clientset, options)
+	s.auditMutation(middleware.GetRequestID(r.Context()), action, getClusterFromHeaders(r), options.Kind+"/"+options.Namespace+"/"+options.Name, 0, err)
+	if err != nil {
+		statusCode, err := fluxError(err)
+		middleware.Errorf(w, r, err, statusCode, fmt.Sprintf("Could not update Flux resource: %s", err.Error()))
+		return
+	}
+
+	middleware.Write(w, r, resource)
+}
+
+// veleroResourcesHandler lists the Backups, Restores and Schedules of Velero in the namespace from the "namespace"
+// query parameter, which defaults to "velero".
+func (s *server) veleroResourcesHandler(w http.ResponseWriter, r *http.Request) {
+	_, clientset, err := s.getClientFromHeaders(r)
+	if err != nil {
+		middleware.Errorf(w, r, middleware.ClientConfiguration(err), http.StatusBadRequest, fmt.Sprintf("Could not create Kubernetes API client: %s", err.Error()))
+		return
+	}
+
+	result, err := velero.GetList(r.Context(), s.discoveryCache, getClusterFromHeaders(r), clientset, r.URL.Query().Get("namespace"))
+	if err != nil {
+		statusCode, err := veleroError(err)
+		middleware.Errorf(w, r, err, statusCode, fmt.Sprintf("Could not list Velero resources: %s", err.Error()))
+		return
+	}
+
+	middleware.Write(w, r, result)
+}
+
+// veleroBackupHandler creates a new Velero Backup, see velero.BackupOptions for the format of the request body. The
+// progress of the Backup can be watched via the veleroWatchHandler.
+func (s *server) veleroBackupHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		err := fmt.Errorf("method %s is not allowed", r.Method)
+		middleware.Errorf(w, r, middleware.WithCode(middleware.CodeMethodNotAllowed, err), http.StatusMethodNotAllowed, fmt.Sprintf("Invalid request: %s", err.Error()))
+		return
+	}
+
+	var options velero.BackupOptions
+	if !s.decodeRequestBody(w, r, &options) {
+		return
+	}
+
+	_, clientset, err := s.getClientFromHeaders(r)
+	if err != nil {
+		middleware.Errorf(w, r, middleware.ClientConfiguration(err), http.StatusBadRequest, fmt.Sprintf("Could not create Kubernetes API client: %s", err.Error()))
+		return
+	}
+
+	backup, err := velero.CreateBackup(r.Context(), s.discoveryCache, getClusterFromHeaders(r), clientset, options)
+	target := options.Namespace + "/" + options.Name
+	if backup != nil {
+		target = backup.Namespace + "/" + backup.Name
+	}
+	s.auditMutation(middleware.GetRequestID(r.Context()), "velero-backup", getClusterFromHeaders(r), target, 0, err)
+	if err != nil {
+		statusCode, err := veleroError(err)
+		middleware.Errorf(w, r, err, statusCode, fmt.Sprintf("Could not create Velero backup: %s", err.Error()))
+		return
+	}
+
+	middleware.Write(w, r, backup)
+}
+
+// veleroRestoreHandler creates a new Velero Restore from an existing Backup, see velero.RestoreOptions for the format
+// of the request body.
+func (s *server) veleroRestoreHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		err := fmt.Errorf("method %s is not allowed", r.Method)
+		middleware.Errorf(w, r, middleware.WithCode(middleware.CodeMethodNotAllowed, err), http.StatusMethodNotAllowed, fmt.Sprintf("Invalid request: %s", err.Error()))
+		return
+	}
+
+	var options velero.RestoreOptions
+	if !s.decodeRequestBody(w, r, &options) {
+		return
+	}
+
+	_, clientset, err := s.getClientFromHeaders(r)
+	if err != nil {
+		middleware.Errorf(w, r, middleware.ClientConfiguration(err), http.StatusBadRequest, fmt.Sprintf("Could not create Kubernetes API client: %s", err.Error()))
+		return
+	}
+
+	restore, err := velero.CreateRestore(r.Context(), s.discoveryCache, getClusterFromHeaders(r), clientset, options)
+	target := options.Namespace + "/" + options.Name
+	if restore != nil {
+		target = restore.Namespace + "/" + restore.Name
+	}
+	s.auditMutation(middleware.GetRequestID(r.Context()), "velero-restore", getClusterFromHeaders(r), target, 0, err)
+	if err != nil {
+		statusCode, err := veleroError(err)
+		middleware.Errorf(w, r, err, statusCode, fmt.Sprintf("Could not create Velero restore: %s", err.Error()))
+		return
+	}
+
+	middleware.Write(w, r, restore)
+}
+
+// veleroWatchHandler watches a single Backup or Restore of Velero, which is specified via the "kind", "namespace" and
+// "name" query parameters. The events are send via a WebSocket connection or as Server-Sent Events (see
+// streamWatchEvents) until the Backup or Restore is finished.
+func (s *server) veleroWatchHandler(w http.ResponseWriter, r *http.Request) {
+	_, clientset, err := s.getClientFromHeaders(r)
+	if err != nil {
+		middleware.Errorf(w, r, middleware.ClientConfiguration(err), http.StatusBadRequest, fmt.Sprintf("Could not create Kubernetes API client: %s", err.Error()))
+		return
+	}
+
+	query := r.URL.Query()
+	watchURL, err := velero.WatchURL(s.discoveryCache, getClusterFromHeaders(r), clientset, velero.NormalizeKind(query.Get("kind")), query.Get("namespace"), query.Get("name"))
+	if err != nil {
+		statusCode, err := veleroError(err)
+		middleware.Errorf(w, r, err, statusCode, fmt.Sprintf("Could not watch Velero resource: %s", err.Error()))
+		return
+	}
+
+	s.streamWatchEvents(w, r, func(ctx context.Context, send func(watch.Event) error) error {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		// When the Backup or Restore is finished, the watch is stopped after the event was sent. The error of the
+		// stopped watch is ignored, so that the connection is closed normally.
+		var finished atomic.Bool
+		err := watch.Watch(ctx, clientset.RESTClient(), watch.Options{URL: watchURL}, func(event watch.Event) error {
+			if err := send(event); err != nil {
+				return err
+			}
+			if velero.IsFinished(event) {
+				finished.Store(true)
+				cancel()
+			}
+			return nil
+		})
+		if finished.Load() {
+			return nil
+		}
+		return err
+	})
+}
+
+// veleroLogsHandler returns the logs of a Velero Backup or Restore as text file. The Backup or Restore is specified via
+// the "kind", "namespace" and "name" query parameters. The logs are downloaded from the backup storage location, if
+// its certificate can not be verified the "insecureSkipTLSVerify" parameter can be set to true.
+func (s *server) veleroLogsHandler(w http.ResponseWriter, r *http.Request) {
+	_, clientset, err := s.getClientFromHeaders(r)
+	if err != nil {
+		middleware.Errorf(w, r, middleware.ClientConfiguration(err), http.StatusBadRequest, fmt.Sprintf("Could not create Kubernetes API client: %s", err.Error()))
+		return
+	}
+
+	query := r.URL.Query()
+	options := velero.LogsOptions{
+		Namespace:             query.Get("namespace"),
+		Kind:                  query.Get("kind"),
+		Name:                  query.Get("name"),
+		InsecureSkipTLSVerify: query.Get("insecureSkipTLSVerify") == "true",
+	}
+
+	reader, err := velero.Logs(r.Context(), s.discoveryCache, getClusterFromHeaders(r), clientset, options)
+	if err != nil {
+		statusCode, err := veleroError(err)
+		middleware.Errorf(w, r, err, statusCode, fmt.Sprintf("Could not get Velero logs: %s", err.Error()))
+		return
+	}
+	defer reader.Close()
+
+	w.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", options.Name+".log"))
+	w.WriteHeader(http.StatusOK)
+
+	// When the download fails after we started to write the response, we can not return an error to the client
+	// anymore, so that we only log the error.
+	if _, err := io.Copy(w, reader); err != nil {
+		log.Printf("Could not write Velero logs: %s", middleware.ScrubRequest(r, err.Error()))
+	}
+}
+
+// kustomizeRenderHandler renders a kustomization from uploaded files, see kustomize.RenderRequest for the format of the
+// request body. The rendered multi-document manifest is returned in the "manifest" field of the response.
+func (s *server) kustomizeRenderHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		err := fmt.Errorf("method %s is not allowed", r.Method)
+		middleware.Errorf(w, r, middleware.WithCode(middleware.CodeMethodNotAllowed, err), http.StatusMethodNotAllowed, fmt.Sprintf("Invalid request: %s", err.Error()))
+		return
+	}
+
+	var request kustomize.RenderRequest
+	if !s.decodeRequestBody(w, r, &request) {
+		return
+	}
+
+	manifest, err := kustomize.Render(request)
+	if err != nil {
+		var renderErr *kustomize.RenderError
+		switch {
+		case errors.Is(err, kustomize.ErrInvalidOptions):
+			middleware.Errorf(w, r, middleware.InvalidParameters(err), http.StatusBadRequest, fmt.Sprintf("Invalid parameters: %s", err.Error()))
+		case errors.As(err, &renderErr):
+			middleware.Errorf(w, r, middleware.WithCode(middleware.CodeBadRequest, err), http.StatusBadRequest, fmt.Sprintf("Could not render kustomization: %s", err.Error()))
+		default:
+			middleware.Errorf(w, r, err, http.StatusInternalServerError, fmt.Sprintf("Could not render kustomization: %s", err.Error()))
+		}
+		return
+	}
+
+	middleware.Write(w, r, struct {
+		Manifest string `json:"manifest"`
+	}{
+		Manifest: manifest,
+	})
+}
+
+// helmReleasesHandler lists the Helm releases of the namespace from the "namespace" query parameter, if the parameter
+// is empty the releases of all namespaces are returned. By default only the latest revision of each release is
+// returned, all revisions are returned when the "allRevisions" parameter is true.
+func (s *server) helmReleasesHandler(w http.ResponseWriter, r *http.Request) {
+	_, clientset, err := s.getClientFromHeaders(r)
+	if err != nil {
+		middleware.Errorf(w, r, middleware.ClientConfiguration(err), http.StatusBadRequest, fmt.Sprintf("Could not create Kubernetes API client: %s", err.Error()))
+		return
+	}
+
+	result, err := helm.List(r.Context(), clientset, r.URL.Query().Get("namespace"), r.URL.Query().Get("allRevisions") == "true")
+	if err != nil {
+		middleware.Errorf(w, r, err, http.StatusInternalServerError, fmt.Sprintf("Could not list Helm releases: %s", err.Error()))
+		return
+	}
+
+	middleware.Write(w, r, result)
+}
+
+// helmHistoryHandler returns all revisions of the Helm release from the "namespace" and "name" query parameters,
+// together with their status and description. The newest revision is the first item.
+func (s *server) helmHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	namespace, name, _, err := helmReleaseFromQuery(r.URL.Query())
+	if err != nil {
+		middleware.Errorf(w, r, middleware.InvalidParameters(err), http.StatusBadRequest, fmt.Sprintf("Invalid parameters: %s", err.Error()))
+		return
+	}
+
+	_, clientset, err := s.getClientFromHeaders(r)
+	if err != nil {
+		middleware.Errorf(w, r, middleware.ClientConfiguration(err), http.StatusBadRequest, fmt.Sprintf("Could not create Kubernetes API client: %s", err.Error()))
+		return
+	}
+
+	history, err := helm.History(r.Context(), clientset, namespace, name)
+	if err != nil {
+		statusCode, err := helmError(err)
+		middleware.Errorf(w, r, err, statusCode, fmt.Sprintf("Could not get Helm release history: %s", err.Error()))
+		return
+	}
+
+	middleware.Write(w, r, history)
+}
+
+// helmValuesHandler returns the user supplied and the computed values of a revision of a Helm release. The release is
+// specified via the "namespace", "name" and "revision" query parameters, without a revision the latest revision is
+// used. When the "redact" parameter is true, all values which could contain a credential are replaced.
+func (s *server) helmValuesHandler(w http.ResponseWriter, r *http.Request) {
+	namespace, name, revision, err := helmReleaseFromQuery(r.URL.Query())
+	if err != nil {
+		middleware.Errorf(w, r, middleware.InvalidParameters(err), http.StatusBadRequest, fmt.Sprintf("Invalid parameters: %s", err.Error()))
+		return
+	}
+
+	_, clientset, err := s.getClientFromHeaders(r)
+	if err != nil {
+		middleware.Errorf(w, r, middleware.ClientConfiguration(err), http.StatusBadRequest, fmt.Sprintf("Could not create Kubernetes API client: %s", err.Error()))
+		return
+	}
+
+	values, err := helm.GetValues(r.Context(), clientset, namespace, name, revision, r.URL.Query().Get("redact") == "true")
+	if err != nil {
+		statusCode, err := helmError(err)
+		middleware.Errorf(w, r, err, statusCode, fmt.Sprintf("Could not get Helm release values: %s", err.Error()))
+		return
+	}
+
+	middleware.Write(w, r, values)
+}
+
+// helmManifestHandler returns the rendered manifest of a revision of a Helm release. The release is specified via the
+// "namespace", "name" and "revision" query parameters, without a revision the latest revision is used. Manifests which
+// are larger than the maximum response size are truncated, the response is compressed by the gzip middleware.
+func (s *server) helmManifestHandler(w http.ResponseWriter, r *http.Request) {
+	namespace, name, revision, err := helmReleaseFromQuery(r.URL.Query())
+	if err != nil {
+		middleware.Errorf(w, r, middleware.InvalidParameters(err), http.StatusBadRequest, fmt.Sprintf("Invalid parameters: %s", err.Error()))
+		return
+	}
+
+	_, clientset, err := s.getClientFromHeaders(r)
+	if err != nil {
+		middleware.Errorf(w, r, middleware.ClientConfiguration(err), http.StatusBadRequest, fmt.Sprintf("Could not create Kubernetes API client: %s", err.Error()))
+		return
+	}
+
+	manifest, err := helm.GetManifest(r.Context(), clientset, namespace, name, revision, int(s.maxResponseSize()))
+	if err != nil {
+		statusCode, err := helmError(err)
+		middleware.Errorf(w, r, err, statusCode, fmt.Sprintf("Could not get Helm release manifest: %s", err.Error()))
+		return
+	}
+
+	middleware.Write(w, r, manifest)
+}
+
+// helmRollbackHandler rolls back a Helm release to a previous revision. The release and the revision are passed via
+// the request body, when the "dryRun" field is true only the diff between the current and the target revision is
+// returned. Rollbacks are written to the audit log.
+func (s *server) helmRollbackHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		err := fmt.Errorf("method %s is not allowed", r.Method)
+		middleware.Errorf(w, r, middleware.WithCode(middleware.CodeMethodNotAllowed, err), http.StatusMethodNotAllowed, fmt.Sprintf("Invalid request: %s", err.Error()))
+		return
+	}
+
+	var options helm.RollbackOptions
+	if !s.decodeRequestBody(w, r, &options) {
+		return
+	}
+
+	_, clientset, err := s.getClientFromHeaders(r)
+	if err != nil {
+		middleware.Errorf(w, r, middleware.ClientConfiguration(err), http.StatusBadRequest, fmt.Sprintf("Could not create Kubernetes API client: %s", err.Error()))
+		return
+	}
+
+	result, err := helm.Rollback(r.Context(), s.discoveryCache, getClusterFromHeaders(r), clientset, options)
+	if !options.DryRun {
+		auditErr := err
+		if err == nil && result.Failed > 0 {
+			auditErr = errors.New(result.Message)
+		}
+		s.auditMutation(middleware.GetRequestID(r.Context()), "helm-rollback", getClusterFromHeaders(r), options.Namespace+"/"+options.Name, 0, auditErr)
+	}
+	if err != nil {
+		statusCode, err := helmError(err)
+		middleware.Errorf(w, r, err, statusCode, fmt.Sprintf("Could not roll back Helm release: %s", err.Error()))
+		return
+	}
+
+	middleware.Write(w, r, result)
+}
+
+// helmUninstallHandler uninstalls a Helm release. The release is passed via the request body, together with the
+// "keepHistory" and "dryRun" fields. Resources which could not be deleted are reported in the result. Uninstalls are
+// written to the audit log.
+func (s *server) helmUninstallHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		err := fmt.Errorf("method %s is not allowed", r.Method)
+		middleware.Errorf(w, r, middleware.WithCode(middleware.CodeMethodNotAllowed, err), http.StatusMethodNotAllowed, fmt.Sprintf("Invalid request: %s", err.Error()))
+		return
+	}
+
+	var options helm.UninstallOptions
+	if !s.decodeRequestBody(w, r, &options) {
+		return
+	}
+
+	_, clientset, err := s.getClientFromHeaders(r)
+	if err != nil {
+		middleware.Errorf(w, r, middleware.ClientConfiguration(err), http.StatusBadRequest, fmt.Sprintf("Could not create Kubernetes API client: %s", err.Error()))
+		return
+	}
+
+	result, err := helm.Uninstall(r.Context(), s.discoveryCache, getClusterFromHeaders(r), clientset, options)
+	if !options.DryRun {
+		auditErr := err
+		if err == nil && result.Failed > 0 {
+			auditErr = errors.New(result.Message)
+		}
+		s.auditMutation(middleware.GetRequestID(r.Context()), "helm-uninstall", getClusterFromHeaders(r), options.Namespace+"/"+options.Name, 0, auditErr)
+	}
+	if err != nil {
+		statusCode, err := helmError(err)
+		middleware.Errorf(w, r, err, statusCode, fmt.Sprintf("Could not uninstall Helm release: %s", err.Error()))
+		return
+	}
+
+	middleware.Write(w, r, result)
+}
+
+// helmDiffHandler returns the diff between the rendered manifests of two revisions of a Helm release. The release is
+// specified via the "namespace" and "name" query parameters and the revisions via the "from" and "to" parameters.
+func (s *server) helmDiffHandler(w http.ResponseWriter, r *http.Request) {
+	namespace, name, _, err := helmReleaseFromQuery(r.URL.Query())
+	if err != nil {
+		middleware.Errorf(w, r, middleware.InvalidParameters(err), http.StatusBadRequest, fmt.Sprintf("Invalid parameters: %s", err.Error()))
+		return
+	}
+
+	fromRevision, fromErr := strconv.Atoi(r.URL.Query().Get("from"))
+	toRevision, toErr := strconv.Atoi(r.URL.Query().Get("to"))
+	if fromErr != nil || toErr != nil {
+		err := fmt.Errorf("from and to must be valid revisions")
+		middleware.Errorf(w, r, middleware.InvalidParameters(err), http.StatusBadRequest, fmt.Sprintf("Invalid parameters: %s", err.Error()))
+		return
+	}
+
+	_, clientset, err := s.getClientFromHeaders(r)
+	if err != nil {
+		middleware.Errorf(w, r, middleware.ClientConfiguration(err), http.StatusBadRequest, fmt.Sprintf("Could not create Kubernetes API client: %s", err.Error()))
+		return
+	}
+
+	diff, err := helm.DiffRevisions(r.Context(), clientset, namespace, name, fromRevision, toRevision)
+	if err != nil {
+		statusCode, err := helmError(err)
+		middleware.Errorf(w, r, err, statusCode, fmt.Sprintf("Could not get Helm release diff: %s", err.Error()))
+		return
+	}
+
+	middleware.Write(w, r, diff)
+}
+
+// prometheusQueryHandler executes an instant PromQL query from the "query" parameter against the Prometheus instance
+// of a cluster. The optional "time" and "timeout" parameters are passed to Prometheus. Prometheus is accessed via the
+// service proxy or a port forwarding session, see getPluginClient. The response of Prometheus is returned unmodified,
+// so that query errors are passed to the client.
+func (s *server) prometheusQueryHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query().Get("query")
+	if query == "" {
+		middleware.Errorf(w, r, middleware.InvalidParameters(nil), http.StatusBadRequest, "Invalid parameters: query is required")
+		return
+	}
+
+	client, statusCode, err := s.getPluginClient(r, prometheus.Name, prometheus.SessionPrefix, prometheus.Candidates)
+	if err != nil {
+		middleware.Errorf(w, r, err, statusCode, fmt.Sprintf("Could not access Prometheus: %s", err.Error()))
+		return
+	}
+	defer client.Close()
+
+	resp, err := prometheus.Query(r.Context(), client, query, r.URL.Query().Get("time"), r.URL.Query().Get("timeout"))
+	if err != nil {
+		middleware.Errorf(w, r, err, http.StatusBadGateway, fmt.Sprintf("Could not query Prometheus: %s", err.Error()))
+		return
+	}
+
+	s.writePluginResponse(w, r, resp)
+}
+
+// prometheusQueryRangeHandler executes multiple PromQL range queries from the request body against the Prometheus
+// instance of a cluster, see prometheus.RangeRequest. The step is increased when a series would contain more than the
+// requested number of points. The results are returned in a columnar format, errors of a single query are returned in
+// the result of the query. All queries are canceled when the client disconnects.
+func (s *server) prometheusQueryRangeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		err := fmt.Errorf("method %s is not allowed", r.Method)
+		middleware.Errorf(w, r, middleware.WithCode(middleware.CodeMethodNotAllowed, err), http.StatusMethodNotAllowed, fmt.Sprintf("Invalid request: %s", err.Error()))
+		return
+	}
+
+	var request prometheus.RangeRequest
+	if !s.decodeRequestBody(w, r, &request) {
+		return
+	}
+
+	if err := request.Validate(); err != nil {
+		middleware.Errorf(w, r, middleware.InvalidParameters(err), http.StatusBadRequest, fmt.Sprintf("Invalid parameters: %s", err.Error()))
+		return
+	}
+
+	client, statusCode, err := s.getPluginClient(r, prometheus.Name, prometheus.SessionPrefix, prometheus.Candidates)
+	if err != nil {
+		middleware.Errorf(w, r, err, statusCode, fmt.Sprintf("Could not access Prometheus: %s", err.Error()))
+		return
+	}
+	defer client.Close()
+
+	response, err := prometheus.QueryRange(r.Context(), client, request)
+	if err != nil {
+		middleware.Errorf(w, r, err, http.StatusBadGateway, fmt.Sprintf("Could not query Prometheus: %s", err.Error()))
+		return
+	}
+
+	middleware.Write(w, r, response)
+}
+
+// elasticsearchSearchHandler searches the logs in an Elasticsearch or OpenSearch cluster, see
+// elasticsearch.SearchRequest for the format of the request body. If the request doesn't contain an address, the
+// cluster is accessed inside of the Kubernetes cluster, see getPluginClientWithCredentials. The common failures of a
+// search are returned with a machine-readable code, see pluginError.
+func (s *server) elasticsearchSearchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		err := fmt.Errorf("method %s is not allowed", r.Method)
+		middleware.Errorf(w, r, middleware.WithCode(middleware.CodeMethodNotAllowed, err), http.StatusMethodNotAllowed, fmt.Sprintf("Invalid request: %s", err.Error()))
+		return
+	}
+
+	var request elasticsearch.SearchRequest
+	if !s.decodeRequestBody(w, r, &request) {
+		return
+	}
+
+	if err := request.Validate(); err != nil {
+		middleware.Errorf(w, r, middleware.InvalidParameters(err), http.StatusBadRequest, fmt.Sprintf("Invalid parameters: %s", err.Error()))
+		return
+	}
+
+	client, statusCode, err := s.getPluginClientWithCredentials(r, request.Address, request.Credentials, elasticsearch.Name, elasticsearch.SessionPrefix, elasticsearch.Candidates)
+	if err != nil {
+		middleware.Errorf(w, r, err, statusCode, fmt.Sprintf("Could not access Elasticsearch: %s", err.Error()))
+		return
+	}
+	defer client.Close()
+
+	result, err := elasticsearch.Search(r.Context(), client, request)
+	if err != nil {
+		statusCode, err := pluginError(err)
+		middleware.Errorf(w, r, err, statusCode, fmt.Sprintf("Could not search Elasticsearch: %s", err.Error()))
+		return
+	}
+
+	middleware.Write(w, r, result)
+}
+
+// lokiQueryRangeHandler executes a LogQL log query against Loki, see loki.QueryRequest for the format of the request
+// body. The log lines of all streams are merged and sorted by their timestamp. If the request doesn't contain an
+// address, Loki is accessed inside of the Kubernetes cluster, see getPluginClientWithCredentials.
+func (s *server) lokiQueryRangeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		err := fmt.Errorf("method %s is not allowed", r.Method)
+		middleware.Errorf(w, r, middleware.WithCode(middleware.CodeMethodNotAllowed, err), http.StatusMethodNotAllowed, fmt.Sprintf("Invalid request: %s", err.Error()))
+		return
+	}
+
+	var request loki.QueryRequest
+	if !s.decodeRequestBody(w, r, &request) {
+		return
+	}
+
+	client, statusCode, err := s.getLokiClient(r, request.Connection)
+	if err != nil {
+		middleware.Errorf(w, r, err, statusCode, fmt.Sprintf("Could not access Loki: %s", err.Error()))
+		return
+	}
+	defer client.Close()
+
+	result, err := loki.QueryRange(r.Context(), client, request)
+	if err != nil {
+		statusCode, err := pluginError(err)
+		middleware.Errorf(w, r, err, statusCode, fmt.Sprintf("Could not query Loki: %s", err.Error()))
+		return
+	}
+
+	middleware.Write(w, r, result)
+}
+
+// lokiLabelsHandler returns the names of all labels or the values of a single label from Loki, see loki.LabelsRequest
+// for the format of the request body. The labels are used to build the query in the app.
+func (s *server) lokiLabelsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		err := fmt.Errorf("method %s is not allowed", r.Method)
+		middleware.Errorf(w, r, middleware.WithCode(middleware.CodeMethodNotAllowed, err), http.StatusMethodNotAllowed, fmt.Sprintf("Invalid request: %s", err.Error()))
+		return
+	}
+
+	var request loki.LabelsRequest
+	if !s.decodeRequestBody(w, r, &request) {
+		return
+	}
+
+	client, statusCode, err := s.getLokiClient(r, request.Connection)
+	if err != nil {
+		middleware.Errorf(w, r, err, statusCode, fmt.Sprintf("Could not access Loki: %s", err.Error()))
+		return
+	}
+	defer client.Close()
+
+	values, err := loki.Labels(r.Context(), client, request)
+	if err != nil {
+		statusCode, err := pluginError(err)
+		middleware.Errorf(w, r, err, statusCode, fmt.Sprintf("Could not get labels from Loki: %s", err.Error()))
+		return
+	}
+
+	middleware.Write(w, r, struct {
+		Values []string `json:"values"`
+	}{
+		Values: values,
+	})
+}
+
+// lokiSeriesHandler returns the label sets of all streams from Loki, which match the stream selectors of the request,
+// see loki.SeriesRequest for the format of the request body.
+func (s *server) lokiSeriesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		err := fmt.Errorf("method %s is not allowed", r.Method)
+		middleware.Errorf(w, r, middleware.WithCode(middleware.CodeMethodNotAllowed, err), http.StatusMethodNotAllowed, fmt.Sprintf("Invalid request: %s", err.Error()))
+		return
+	}
+
+	var request loki.SeriesRequest
+	if !s.decodeRequestBody(w, r, &request) {
+		return
+	}
+
+	client, statusCode, err := s.getLokiClient(r, request.Connection)
+	if err != nil {
+		middleware.Errorf(w, r, err, statusCode, fmt.Sprintf("Could not access Loki: %s", err.Error()))
+		return
+	}
+	defer client.Close()
+
+	series, err := loki.Series(r.Context(), client, request)
+	if err != nil {
+		statusCode, err := pluginError(err)
+		middleware.Errorf(w, r, err, statusCode, fmt.Sprintf("Could not get series from Loki: %s", err.Error()))
+		return
+	}
+
+	middleware.Write(w, r, struct {
+		Series []map[string]string `json:"series"`
+	}{
+		Series: series,
+	})
+}
+
+// lokiTailHandler follows the log lines of a LogQL query via a WebSocket connection. The request may contain the
+// credentials for Loki, so that it isn't send via query parameters, instead the client must send the request as first
+// message after the connection was established, see loki.TailRequest. The new log lines are then send as
+// loki.TailMessage until the client closes the connection. Errors are send as message with the "error" field before the
+// connection is closed.
+func (s *server) lokiTailHandler(w http.ResponseWriter, r *http.Request) {
+	upgrader := s.newUpgrader()
+
+	c, err := s.upgrade(upgrader, w, r)
+	if err != nil {
+		middleware.Errorf(w, r, middleware.UpgradeFailed(err), http.StatusBadRequest, fmt.Sprintf("Could not upgrade connection: %s", err.Error()))
+		return
+	}
+	defer s.closeConnection(c)
+
+	writer := newWebSocketWriter(c)
+
+	var request loki.TailRequest
+	if err := c.ReadJSON(&request); err != nil {
+		writer.WriteJSON(loki.TailMessage{Error: fmt.Sprintf("Invalid request: %s", err.Error())})
+		writer.Close(websocket.CloseUnsupportedData, "invalid request")
+		return
+	}
+
+	client, _, err := s.getLokiClient(r, request.Connection)
+	if err != nil {
+		writer.WriteJSON(loki.TailMessage{Error: fmt.Sprintf("Could not access Loki: %s", err.Error())})
+		writer.Close(websocket.CloseNormalClosure, "")
+		return
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go keepAlive(c, ctx.Done())
+	go cancelOnClose(c, cancel)
+
+	err = loki.Tail(ctx, client, request, func(message *loki.TailMessage) error {
+		return writer.WriteJSON(message)
+	})
+	if err != nil && ctx.Err() == nil {
+		writer.WriteJSON(loki.TailMessage{Error: fmt.Sprintf("Could not tail logs from Loki: %s", err.Error())})
+	}
+
+	writer.Close(websocket.CloseNormalClosure, "")
 }
@@ -0,0 +1,218 @@
+package helm
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/kubenav/kubenav/pkg/server/resources"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/jsonmergepatch"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+)
+
+// The actions, which were taken for a resource of a release.
+const (
+	ActionCreated   = "created"
+	ActionPatched   = "patched"
+	ActionUnchanged = "unchanged"
+	ActionDeleted   = "deleted"
+	ActionKept      = "kept"
+	ActionNotFound  = "not-found"
+	ActionFailed    = "failed"
+)
+
+// ResourceResult is the result for a single resource, when a manifest is applied or deleted. If the action failed, the
+// error contains the reason, e.g. a conflict with the live state of the resource.
+type ResourceResult struct {
+	Key       string `json:"key"`
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+	Action    string `json:"action"`
+	Error     string `json:"error,omitempty"`
+}
+
+// client applies and deletes the resources of a release. The resources are resolved via the discovery cache, so that
+// custom resources can be handled in the same way as the built-in resources.
+type client struct {
+	cache      *resources.DiscoveryCache
+	clusterKey string
+	clientset  kubernetes.Interface
+	namespace  string
+}
+
+func newResourceResult(resource Resource, namespace string) ResourceResult {
+	return ResourceResult{Key: resource.Key(), Kind: resource.Kind, Namespace: namespace, Name: resource.Name}
+}
+
+func failed(result ResourceResult, err error) ResourceResult {
+	result.Action = ActionFailed
+	result.Error = err.Error()
+	return result
+}
+
+// resolve returns the resource information and the namespace of a resource. Namespaced resources without a namespace
+// in the manifest are created in the namespace of the release, like it is done by Helm.
+func (c *client) resolve(resource Resource) (resources.ResourceInfo, string, error) {
+	info, err := c.cache.ResolveKind(c.clusterKey, c.clientset, resource.GroupVersionKind())
+	if err != nil {
+		return resources.ResourceInfo{}, "", err
+	}
+
+	if !info.Namespaced {
+		return info, "", nil
+	}
+	if resource.Namespace != "" {
+		return info, resource.Namespace, nil
+	}
+	return info, c.namespace, nil
+}
+
+// apply creates or updates a resource. Existing resources are updated via a three-way merge patch between the resource
+// from the manifest of the current revision (original), the resource from the new manifest (modified) and the live
+// state of the resource, in the same way as Helm. For the built-in resources a strategic merge patch is used, for all
+// other resources a JSON merge patch.
+func (c *client) apply(ctx context.Context, original *Resource, modified Resource) ResourceResult {
+	info, namespace, err := c.resolve(modified)
+	result := newResourceResult(modified, namespace)
+	if err != nil {
+		return failed(result, err)
+	}
+
+	restClient := c.clientset.CoreV1().RESTClient()
+
+	current, err := restClient.Get().AbsPath(resources.ObjectPath(info, namespace, modified.Name)).DoRaw(ctx)
+	if err != nil {
+		if !apierrors.IsNotFound(err) {
+			return failed(result, err)
+		}
+
+		_, err := restClient.Post().AbsPath(resources.Path(info, namespace)).Param("fieldManager", resources.FieldManager).Body(modified.JSON).DoRaw(ctx)
+		if err != nil {
+			return failed(result, err)
+		}
+
+		result.Action = ActionCreated
+		return result
+	}
+
+	originalJSON := []byte("{}")
+	if original != nil {
+		originalJSON = original.JSON
+	}
+
+	var patch []byte
+	var patchType types.PatchType
+
+	if object, err := scheme.Scheme.New(modified.GroupVersionKind()); err == nil {
+		patchMeta, err := strategicpatch.NewPatchMetaFromStruct(object)
+		if err != nil {
+			return failed(result, err)
+		}
+
+		patch, err = strategicpatch.CreateThreeWayMergePatch(originalJSON, modified.JSON, current, patchMeta, true)
+		if err != nil {
+			return failed(result, fmt.Errorf("could not create patch: %w", err))
+		}
+		patchType = types.StrategicMergePatchType
+	} else {
+		patch, err = jsonmergepatch.CreateThreeWayJSONMergePatch(originalJSON, modified.JSON, current)
+		if err != nil {
+			return failed(result, fmt.Errorf("could not create patch: %w", err))
+		}
+		patchType = types.MergePatchType
+	}
+
+	if string(patch) == "{}" {
+		result.Action = ActionUnchanged
+		return result
+	}
+
+	_, err = restClient.Patch(patchType).AbsPath(resources.ObjectPath(info, namespace, modified.Name)).Param("fieldManager", resources.FieldManager).Body(patch).DoRaw(ctx)
+	if err != nil {
+		if apierrors.IsConflict(err) {
+			return failed(result, fmt.Errorf("conflict with the live state: %w", err))
+		}
+		return failed(result, err)
+	}
+
+	result.Action = ActionPatched
+	return result
+}
+
+// delete deletes a resource, unless the "helm.sh/resource-policy" annotation of the resource is set to "keep". If the
+// resource doesn't exist anymore, the "not-found" action is returned.
+func (c *client) delete(ctx context.Context, resource Resource, dryRun bool) ResourceResult {
+	info, namespace, err := c.resolve(resource)
+	result := newResourceResult(resource, namespace)
+	if err != nil {
+		return failed(result, err)
+	}
+
+	if resource.Keep() {
+		result.Action = ActionKept
+		return result
+	}
+
+	request := c.clientset.CoreV1().RESTClient().Delete().AbsPath(resources.ObjectPath(info, namespace, resource.Name)).Param("propagationPolicy", "Background")
+	if dryRun {
+		request = request.Param("dryRun", "All")
+	}
+
+	if _, err := request.DoRaw(ctx); err != nil {
+		if apierrors.IsNotFound(err) {
+			result.Action = ActionNotFound
+			return result
+		}
+		return failed(result, err)
+	}
+
+	result.Action = ActionDeleted
+	return result
+}
+
+// update applies the resources of the new manifest and deletes all resources of the current manifest, which are not
+// part of the new manifest anymore. The returned number is the number of resources which failed, the update continues
+// with the other resources when a resource fails.
+func (c *client) update(ctx context.Context, current, modified []Resource) ([]ResourceResult, int) {
+	currentByKey := make(map[string]*Resource, len(current))
+	for i := range current {
+		currentByKey[current[i].Key()] = &current[i]
+	}
+
+	var results []ResourceResult
+	var failures int
+
+	modifiedKeys := make(map[string]bool, len(modified))
+	for _, resource := range modified {
+		modifiedKeys[resource.Key()] = true
+
+		result := c.apply(ctx, currentByKey[resource.Key()], resource)
+		if result.Action == ActionFailed {
+			failures++
+		}
+		results = append(results, result)
+	}
+
+	removed := make([]Resource, 0, len(current))
+	for _, resource := range current {
+		if !modifiedKeys[resource.Key()] {
+			removed = append(removed, resource)
+		}
+	}
+	sortResources(removed, true)
+
+	for _, resource := range removed {
+		result := c.delete(ctx, resource, false)
+		if result.Action == ActionFailed {
+			failures++
+		}
+		results = append(results, result)
+	}
+
+	return results, failures
+}
@@ -0,0 +1,325 @@
+package helm
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"k8s.io/client-go/kubernetes"
+)
+
+// The actions of a resource in a diff.
+const (
+	DiffAdded     = "added"
+	DiffRemoved   = "removed"
+	DiffChanged   = "changed"
+	DiffUnchanged = "unchanged"
+)
+
+// diffContext is the number of unchanged lines, which are shown around a change in a unified diff.
+const diffContext = 3
+
+// maxDiffCells is the maximum size of the table (lines of the old document times lines of the new document), which is
+// used to compute the diff of a single resource. Larger documents are shown as completely replaced, so that a huge
+// resource can not use all the memory of the device.
+const maxDiffCells = 4 * 1024 * 1024
+
+// ResourceDiff is the diff of a single resource. For changed resources the diff contains the unified diff of the YAML
+// documents, for added and removed resources it contains the complete document.
+type ResourceDiff struct {
+	Key        string `json:"key"`
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Namespace  string `json:"namespace,omitempty"`
+	Name       string `json:"name"`
+	Action     string `json:"action"`
+	Diff       string `json:"diff,omitempty"`
+}
+
+// DiffSummary contains the number of added, removed, changed and unchanged resources of a diff.
+type DiffSummary struct {
+	Added     int `json:"added"`
+	Removed   int `json:"removed"`
+	Changed   int `json:"changed"`
+	Unchanged int `json:"unchanged"`
+}
+
+// Diff is the diff between two manifests. The resources are returned in the install order of the new manifest,
+// followed by the removed resources.
+type Diff struct {
+	From      string         `json:"from"`
+	To        string         `json:"to"`
+	Resources []ResourceDiff `json:"resources"`
+	Summary   DiffSummary    `json:"summary"`
+}
+
+// DiffManifests returns the diff between the two given manifests. The manifests are split into the single resources,
+// which are matched by their group, version, kind, namespace and name. The "from" and "to" names are used in the
+// headers of the unified diffs.
+func DiffManifests(fromName, fromManifest, toName, toManifest string) (*Diff, error) {
+	fromResources, err := SplitManifest(fromManifest)
+	if err != nil {
+		return nil, err
+	}
+
+	toResources, err := SplitManifest(toManifest)
+	if err != nil {
+		return nil, err
+	}
+
+	diff := &Diff{From: fromName, To: toName, Resources: []ResourceDiff{}}
+
+	fromByKey := make(map[string]Resource, len(fromResources))
+	for _, resource := range fromResources {
+		fromByKey[resource.Key()] = resource
+	}
+
+	toKeys := make(map[string]bool, len(toResources))
+	for _, resource := range toResources {
+		toKeys[resource.Key()] = true
+
+		resourceDiff := newResourceDiff(resource)
+		from, ok := fromByKey[resource.Key()]
+		switch {
+		case !ok:
+			resourceDiff.Action = DiffAdded
+			resourceDiff.Diff = UnifiedDiff(fromName, "", toName, resource.Document)
+			diff.Summary.Added++
+		case from.Document == resource.Document:
+			resourceDiff.Action = DiffUnchanged
+			diff.Summary.Unchanged++
+		default:
+			resourceDiff.Action = DiffChanged
+			resourceDiff.Diff = UnifiedDiff(fromName, from.Document, toName, resource.Document)
+			diff.Summary.Changed++
+		}
+
+		diff.Resources = append(diff.Resources, resourceDiff)
+	}
+
+	for _, resource := range fromResources {
+		if toKeys[resource.Key()] {
+			continue
+		}
+
+		resourceDiff := newResourceDiff(resource)
+		resourceDiff.Action = DiffRemoved
+		resourceDiff.Diff = UnifiedDiff(fromName, resource.Document, toName, "")
+		diff.Summary.Removed++
+		diff.Resources = append(diff.Resources, resourceDiff)
+	}
+
+	return diff, nil
+}
+
+// DiffRevisions returns the diff between the rendered manifests of two revisions of a release. The diff has the same
+// format as the diff, which is returned by a dry run of a rollback, so that both can be rendered in the same way.
+func DiffRevisions(ctx context.Context, clientset kubernetes.Interface, namespace, name string, fromRevision, toRevision int) (*Diff, error) {
+	if fromRevision < 1 || toRevision < 1 {
+		return nil, fmt.Errorf("from and to revision are required")
+	}
+
+	records, err := loadRelease(ctx, clientset, namespace, name)
+	if err != nil {
+		return nil, err
+	}
+
+	var from, to *Record
+	for _, record := range records {
+		if record.Version == fromRevision {
+			from = record
+		}
+		if record.Version == toRevision {
+			to = record
+		}
+	}
+
+	if from == nil {
+		return nil, fmt.Errorf("revision %d of release %s/%s %w", fromRevision, namespace, name, ErrNotFound)
+	}
+	if to == nil {
+		return nil, fmt.Errorf("revision %d of release %s/%s %w", toRevision, namespace, name, ErrNotFound)
+	}
+
+	return DiffManifests(revisionName(from.Version), from.Manifest, revisionName(to.Version), to.Manifest)
+}
+
+func newResourceDiff(resource Resource) ResourceDiff {
+	return ResourceDiff{
+		Key:        resource.Key(),
+		APIVersion: resource.APIVersion,
+		Kind:       resource.Kind,
+		Namespace:  resource.Namespace,
+		Name:       resource.Name,
+	}
+}
+
+// UnifiedDiff returns the unified diff between the two given texts, in the same format as "diff -u". If both texts
+// are equal an empty string is returned.
+func UnifiedDiff(fromName, from, toName, to string) string {
+	if from == to {
+		return ""
+	}
+
+	fromLines := splitLines(from)
+	toLines := splitLines(to)
+	operations := diffLines(fromLines, toLines)
+
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "--- %s\n+++ %s\n", fromName, toName)
+
+	// We group the operations into hunks. A hunk starts "diffContext" lines before the first change and ends
+	// "diffContext" lines after the last change, changes which are closer together are merged into one hunk.
+	for start := 0; start < len(operations); {
+		if operations[start].kind == ' ' {
+			start++
+			continue
+		}
+
+		hunkStart := start - diffContext
+		if hunkStart < 0 {
+			hunkStart = 0
+		}
+
+		hunkEnd := start
+		for i := start; i < len(operations); i++ {
+			if operations[i].kind != ' ' {
+				hunkEnd = i
+			} else if i-hunkEnd > 2*diffContext {
+				break
+			}
+		}
+		hunkEnd = hunkEnd + diffContext + 1
+		if hunkEnd > len(operations) {
+			hunkEnd = len(operations)
+		}
+
+		writeHunk(&builder, operations[hunkStart:hunkEnd])
+		start = hunkEnd
+	}
+
+	return builder.String()
+}
+
+type diffOperation struct {
+	kind     byte
+	line     string
+	fromLine int
+	toLine   int
+}
+
+func writeHunk(builder *strings.Builder, operations []diffOperation) {
+	fromStart, toStart := operations[0].fromLine, operations[0].toLine
+	var fromCount, toCount int
+	for _, operation := range operations {
+		if operation.kind != '+' {
+			fromCount++
+		}
+		if operation.kind != '-' {
+			toCount++
+		}
+	}
+
+	// Like "diff -u" we are using the line before the hunk as start, when the hunk doesn't contain any lines of a
+	// text.
+	if fromCount == 0 {
+		fromStart--
+	}
+	if toCount == 0 {
+		toStart--
+	}
+
+	fmt.Fprintf(builder, "@@ -%s +%s @@\n", hunkRange(fromStart, fromCount), hunkRange(toStart, toCount))
+	for _, operation := range operations {
+		builder.WriteByte(operation.kind)
+		builder.WriteString(operation.line)
+		builder.WriteByte('\n')
+	}
+}
+
+func hunkRange(start, count int) string {
+	if count == 1 {
+		return fmt.Sprintf("%d", start)
+	}
+	return fmt.Sprintf("%d,%d", start, count)
+}
+
+// diffLines returns the operations to transform the "from" lines into the "to" lines, based on the longest common
+// subsequence of both texts. The line numbers of the operations start at 1.
+func diffLines(from, to []string) []diffOperation {
+	// The common prefix and suffix are removed before the table is computed, because most changes of a resource only
+	// modify a few lines.
+	prefix := 0
+	for prefix < len(from) && prefix < len(to) && from[prefix] == to[prefix] {
+		prefix++
+	}
+
+	suffix := 0
+	for suffix < len(from)-prefix && suffix < len(to)-prefix && from[len(from)-1-suffix] == to[len(to)-1-suffix] {
+		suffix++
+	}
+
+	operations := make([]diffOperation, 0, len(from)+len(to))
+	for i := 0; i < prefix; i++ {
+		operations = append(operations, diffOperation{kind: ' ', line: from[i], fromLine: i + 1, toLine: i + 1})
+	}
+
+	fromMiddle := from[prefix : len(from)-suffix]
+	toMiddle := to[prefix : len(to)-suffix]
+
+	if len(fromMiddle)*len(toMiddle) > maxDiffCells {
+		for i, line := range fromMiddle {
+			operations = append(operations, diffOperation{kind: '-', line: line, fromLine: prefix + i + 1, toLine: prefix + 1})
+		}
+		for i, line := range toMiddle {
+			operations = append(operations, diffOperation{kind: '+', line: line, fromLine: prefix + len(fromMiddle) + 1, toLine: prefix + i + 1})
+		}
+	} else {
+		// lengths[i][j] is the length of the longest common subsequence of fromMiddle[i:] and toMiddle[j:].
+		lengths := make([][]int, len(fromMiddle)+1)
+		for i := range lengths {
+			lengths[i] = make([]int, len(toMiddle)+1)
+		}
+		for i := len(fromMiddle) - 1; i >= 0; i-- {
+			for j := len(toMiddle) - 1; j >= 0; j-- {
+				if fromMiddle[i] == toMiddle[j] {
+					lengths[i][j] = lengths[i+1][j+1] + 1
+				} else if lengths[i+1][j] >= lengths[i][j+1] {
+					lengths[i][j] = lengths[i+1][j]
+				} else {
+					lengths[i][j] = lengths[i][j+1]
+				}
+			}
+		}
+
+		i, j := 0, 0
+		for i < len(fromMiddle) || j < len(toMiddle) {
+			fromLine, toLine := prefix+i+1, prefix+j+1
+			switch {
+			case i < len(fromMiddle) && j < len(toMiddle) && fromMiddle[i] == toMiddle[j]:
+				operations = append(operations, diffOperation{kind: ' ', line: fromMiddle[i], fromLine: fromLine, toLine: toLine})
+				i++
+				j++
+			case j == len(toMiddle) || (i < len(fromMiddle) && lengths[i+1][j] >= lengths[i][j+1]):
+				operations = append(operations, diffOperation{kind: '-', line: fromMiddle[i], fromLine: fromLine, toLine: toLine})
+				i++
+			default:
+				operations = append(operations, diffOperation{kind: '+', line: toMiddle[j], fromLine: fromLine, toLine: toLine})
+				j++
+			}
+		}
+	}
+
+	for i := len(from) - suffix; i < len(from); i++ {
+		operations = append(operations, diffOperation{kind: ' ', line: from[i], fromLine: i + 1, toLine: i - len(from) + len(to) + 1})
+	}
+
+	return operations
+}
+
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
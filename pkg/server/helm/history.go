@@ -0,0 +1,249 @@
+package helm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+
+	"k8s.io/client-go/kubernetes"
+)
+
+// ErrNotFound is returned when a release or a revision of a release doesn't exist.
+var ErrNotFound = errors.New("not found")
+
+// redactedValue is the value, which is returned instead of a value, which could contain a credential.
+const redactedValue = "[REDACTED]"
+
+// sensitiveKey matches the keys of values, which could contain a credential, e.g. "password", "apiToken" or
+// "tls.key".
+var sensitiveKey = regexp.MustCompile(`(?i)(password|passwd|token|secret|key|credential|auth)`)
+
+// Values are the values of a release revision. The "user" values are the values which were passed to Helm during the
+// installation or upgrade, the "computed" values are the default values of the chart merged with the user values.
+type Values struct {
+	Name      string                 `json:"name"`
+	Namespace string                 `json:"namespace"`
+	Revision  int                    `json:"revision"`
+	User      map[string]interface{} `json:"user"`
+	Computed  map[string]interface{} `json:"computed"`
+	Redacted  bool                   `json:"redacted"`
+}
+
+// Manifest is the rendered manifest of a release revision. If the manifest is larger than the requested limit it is
+// truncated and "truncated" is true, "size" is always the size of the complete manifest.
+type Manifest struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	Revision  int    `json:"revision"`
+	Manifest  string `json:"manifest"`
+	Size      int    `json:"size"`
+	Truncated bool   `json:"truncated"`
+}
+
+// History returns all revisions of the release with the given name. The revisions are sorted by the revision number,
+// the newest revision is the first item.
+func History(ctx context.Context, clientset kubernetes.Interface, namespace, name string) ([]Summary, error) {
+	records, err := loadRelease(ctx, clientset, namespace, name)
+	if err != nil {
+		return nil, err
+	}
+
+	history := make([]Summary, 0, len(records))
+	for _, record := range records {
+		history = append(history, record.Summary())
+	}
+
+	return history, nil
+}
+
+// GetRecord returns the record of a single revision of the release. If the revision is 0 the latest revision is
+// returned.
+func GetRecord(ctx context.Context, clientset kubernetes.Interface, namespace, name string, revision int) (*Record, error) {
+	records, err := loadRelease(ctx, clientset, namespace, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if revision == 0 {
+		return records[0], nil
+	}
+
+	for _, record := range records {
+		if record.Version == revision {
+			return record, nil
+		}
+	}
+
+	return nil, fmt.Errorf("revision %d of release %s/%s %w", revision, namespace, name, ErrNotFound)
+}
+
+// GetValues returns the user supplied and the computed values of a revision of the release. If the revision is 0 the
+// values of the latest revision are returned. When "redact" is true, all values with a key which could contain a
+// credential are replaced.
+func GetValues(ctx context.Context, clientset kubernetes.Interface, namespace, name string, revision int, redact bool) (*Values, error) {
+	record, err := GetRecord(ctx, clientset, namespace, name, revision)
+	if err != nil {
+		return nil, err
+	}
+
+	user := record.Config
+	if user == nil {
+		user = make(map[string]interface{})
+	}
+
+	var defaults map[string]interface{}
+	if record.Chart != nil {
+		defaults = record.Chart.Values
+	}
+
+	// We have to copy the user values before merging them with the defaults, because "coalesceValues" modifies the
+	// given maps.
+	computed := coalesceValues(copyValues(user), copyValues(defaults))
+
+	if redact {
+		user = redactValues(copyValues(user))
+		computed = redactValues(computed)
+	}
+
+	return &Values{
+		Name:      record.Name,
+		Namespace: record.Namespace,
+		Revision:  record.Version,
+		User:      user,
+		Computed:  computed,
+		Redacted:  redact,
+	}, nil
+}
+
+// GetManifest returns the rendered manifest of a revision of the release. If the revision is 0 the manifest of the
+// latest revision is returned. If the limit is larger than 0 and the manifest is larger than the limit, the manifest is
+// truncated.
+func GetManifest(ctx context.Context, clientset kubernetes.Interface, namespace, name string, revision int, limit int) (*Manifest, error) {
+	record, err := GetRecord(ctx, clientset, namespace, name, revision)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := &Manifest{
+		Name:      record.Name,
+		Namespace: record.Namespace,
+		Revision:  record.Version,
+		Manifest:  record.Manifest,
+		Size:      len(record.Manifest),
+	}
+
+	if limit > 0 && len(manifest.Manifest) > limit {
+		manifest.Manifest = manifest.Manifest[:limit]
+		manifest.Truncated = true
+	}
+
+	return manifest, nil
+}
+
+// loadRelease loads all records of the release with the given name. If the release doesn't exist an error is
+// returned.
+func loadRelease(ctx context.Context, clientset kubernetes.Interface, namespace, name string) ([]*Record, error) {
+	if namespace == "" || name == "" {
+		return nil, fmt.Errorf("namespace and name are required")
+	}
+
+	records, _, err := LoadRecords(ctx, clientset, namespace, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(records) == 0 {
+		return nil, fmt.Errorf("release %s/%s %w", namespace, name, ErrNotFound)
+	}
+
+	return records, nil
+}
+
+// coalesceValues merges the user values with the default values of the chart in the same way as Helm. The user values
+// take precedence, nested maps are merged recursively and a user value of "null" removes the default value.
+func coalesceValues(user, defaults map[string]interface{}) map[string]interface{} {
+	for key, value := range defaults {
+		userValue, ok := user[key]
+		if !ok {
+			user[key] = value
+			continue
+		}
+
+		if userValue == nil {
+			delete(user, key)
+			continue
+		}
+
+		userMap, userIsMap := userValue.(map[string]interface{})
+		defaultMap, defaultIsMap := value.(map[string]interface{})
+		if userIsMap && defaultIsMap {
+			user[key] = coalesceValues(userMap, defaultMap)
+		}
+	}
+
+	for key, value := range user {
+		if value == nil {
+			delete(user, key)
+		}
+	}
+
+	return user
+}
+
+// copyValues returns a deep copy of the given values.
+func copyValues(values map[string]interface{}) map[string]interface{} {
+	copied := make(map[string]interface{}, len(values))
+	for key, value := range values {
+		copied[key] = copyValue(value)
+	}
+	return copied
+}
+
+func copyValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return copyValues(v)
+	case []interface{}:
+		copied := make([]interface{}, len(v))
+		for i, nested := range v {
+			copied[i] = copyValue(nested)
+		}
+		return copied
+	default:
+		return v
+	}
+}
+
+// redactValues replaces all values with a key which could contain a credential. When the value of a sensitive key is
+// a map or a list, all nested values are replaced, because e.g. "auth" or "secrets" often contain nested credentials.
+func redactValues(values map[string]interface{}) map[string]interface{} {
+	for key, value := range values {
+		values[key] = redactValue(value, sensitiveKey.MatchString(key))
+	}
+	return values
+}
+
+func redactValue(value interface{}, sensitive bool) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if !sensitive {
+			return redactValues(v)
+		}
+		for key, nested := range v {
+			v[key] = redactValue(nested, true)
+		}
+		return v
+	case []interface{}:
+		for i, nested := range v {
+			v[i] = redactValue(nested, sensitive)
+		}
+		return v
+	default:
+		// Empty values are not replaced, so that the user can still see that a credential wasn't set.
+		if sensitive && v != nil && v != "" {
+			return redactedValue
+		}
+		return v
+	}
+}
@@ -0,0 +1,164 @@
+package helm
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/yaml"
+)
+
+// resourcePolicyAnnotation is the annotation, which can be set to "keep" to prevent Helm from deleting a resource
+// during an uninstall, upgrade or rollback.
+const resourcePolicyAnnotation = "helm.sh/resource-policy"
+
+// installOrder is the order in which Helm installs the resources of a release. Resources are uninstalled in the
+// reverse order. Kinds which are not in the list are installed after all known kinds.
+var installOrder = []string{
+	"Namespace",
+	"NetworkPolicy",
+	"ResourceQuota",
+	"LimitRange",
+	"PodSecurityPolicy",
+	"PodDisruptionBudget",
+	"ServiceAccount",
+	"Secret",
+	"SecretList",
+	"ConfigMap",
+	"StorageClass",
+	"PersistentVolume",
+	"PersistentVolumeClaim",
+	"CustomResourceDefinition",
+	"ClusterRole",
+	"ClusterRoleList",
+	"ClusterRoleBinding",
+	"ClusterRoleBindingList",
+	"Role",
+	"RoleList",
+	"RoleBinding",
+	"RoleBindingList",
+	"Service",
+	"DaemonSet",
+	"Pod",
+	"ReplicationController",
+	"ReplicaSet",
+	"Deployment",
+	"HorizontalPodAutoscaler",
+	"StatefulSet",
+	"Job",
+	"CronJob",
+	"IngressClass",
+	"Ingress",
+	"APIService",
+}
+
+// Resource is a single resource from the rendered manifest of a release.
+type Resource struct {
+	APIVersion string
+	Kind       string
+	Namespace  string
+	Name       string
+	// Document is the YAML document of the resource from the manifest.
+	Document string
+	// JSON is the resource converted to JSON.
+	JSON        []byte
+	Annotations map[string]string
+}
+
+// GroupVersionKind returns the group, version and kind of the resource.
+func (r Resource) GroupVersionKind() schema.GroupVersionKind {
+	return schema.FromAPIVersionAndKind(r.APIVersion, r.Kind)
+}
+
+// Key returns a key, which identifies the resource in the manifests of all revisions of a release. The key contains
+// the group, version, kind, namespace and name of the resource.
+func (r Resource) Key() string {
+	return fmt.Sprintf("%s, Kind=%s, %s/%s", r.APIVersion, r.Kind, r.Namespace, r.Name)
+}
+
+// Keep returns true, when the resource should not be deleted, because the "helm.sh/resource-policy" annotation is set
+// to "keep".
+func (r Resource) Keep() bool {
+	return r.Annotations[resourcePolicyAnnotation] == "keep"
+}
+
+// SplitManifest splits the rendered manifest of a release into the single resources. Empty documents (e.g. templates
+// which only contain comments) are skipped. The resources are sorted in the install order of Helm.
+func SplitManifest(manifest string) ([]Resource, error) {
+	reader := utilyaml.NewYAMLReader(bufio.NewReader(strings.NewReader(manifest)))
+
+	var resources []Resource
+	for {
+		document, err := reader.Read()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+
+		data, err := yaml.YAMLToJSON(document)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse manifest: %w", err)
+		}
+		if len(data) == 0 || string(data) == "null" {
+			continue
+		}
+
+		var object struct {
+			APIVersion string `json:"apiVersion"`
+			Kind       string `json:"kind"`
+			Metadata   struct {
+				Namespace   string            `json:"namespace"`
+				Name        string            `json:"name"`
+				Annotations map[string]string `json:"annotations"`
+			} `json:"metadata"`
+		}
+		if err := json.Unmarshal(data, &object); err != nil {
+			return nil, fmt.Errorf("could not parse manifest: %w", err)
+		}
+		if object.Kind == "" || object.Metadata.Name == "" {
+			return nil, fmt.Errorf("could not parse manifest: resource without kind or name")
+		}
+
+		resources = append(resources, Resource{
+			APIVersion:  object.APIVersion,
+			Kind:        object.Kind,
+			Namespace:   object.Metadata.Namespace,
+			Name:        object.Metadata.Name,
+			Document:    strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(string(document)), "---")),
+			JSON:        data,
+			Annotations: object.Metadata.Annotations,
+		})
+	}
+
+	sortResources(resources, false)
+	return resources, nil
+}
+
+// sortResources sorts the resources in the install order of Helm or in the uninstall order, when "reverse" is true.
+func sortResources(resources []Resource, reverse bool) {
+	order := make(map[string]int, len(installOrder))
+	for i, kind := range installOrder {
+		order[kind] = i
+	}
+
+	rank := func(kind string) int {
+		if i, ok := order[kind]; ok {
+			return i
+		}
+		return len(installOrder)
+	}
+
+	sort.SliceStable(resources, func(i, j int) bool {
+		if reverse {
+			return rank(resources[i].Kind) > rank(resources[j].Kind)
+		}
+		return rank(resources[i].Kind) < rank(resources[j].Kind)
+	})
+}
@@ -0,0 +1,317 @@
+// Package helm implements the management of Helm releases without the helm binary. The releases are read from the
+// Secrets (and ConfigMaps) which are used by the storage drivers of Helm 3. The records are decoded into a minimal
+// release structure, which only contains the fields required by kubenav, the original JSON is kept, so that records
+// can be written back without losing any fields.
+package helm
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strconv"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// The storage drivers of a release record.
+const (
+	StorageSecret    = "secret"
+	StorageConfigMap = "configmap"
+)
+
+// SecretType is the type of the Secrets, which are used by Helm 3 to store the release records.
+const SecretType = "helm.sh/release.v1"
+
+// ownerSelector selects all release records of Helm 3. Helm 2 used the "OWNER=TILLER" label, so that the protobuf
+// encoded records of Helm 2 are never returned.
+const ownerSelector = "owner=helm"
+
+var gzipMagic = []byte{0x1f, 0x8b, 0x08}
+
+// Release is a single revision of a Helm release. It only contains the fields, which are used by kubenav.
+type Release struct {
+	Name      string                 `json:"name"`
+	Namespace string                 `json:"namespace"`
+	Version   int                    `json:"version"`
+	Info      *Info                  `json:"info,omitempty"`
+	Chart     *Chart                 `json:"chart,omitempty"`
+	Config    map[string]interface{} `json:"config,omitempty"`
+	Manifest  string                 `json:"manifest,omitempty"`
+}
+
+// Info contains the status of a release revision.
+type Info struct {
+	FirstDeployed string `json:"first_deployed,omitempty"`
+	LastDeployed  string `json:"last_deployed,omitempty"`
+	Deleted       string `json:"deleted,omitempty"`
+	Description   string `json:"description,omitempty"`
+	Status        string `json:"status,omitempty"`
+	Notes         string `json:"notes,omitempty"`
+}
+
+// Chart contains the metadata and the default values of the chart of a release.
+type Chart struct {
+	Metadata *Metadata              `json:"metadata,omitempty"`
+	Values   map[string]interface{} `json:"values,omitempty"`
+}
+
+// Metadata contains the metadata of a chart from the Chart.yaml file.
+type Metadata struct {
+	Name       string `json:"name,omitempty"`
+	Version    string `json:"version,omitempty"`
+	AppVersion string `json:"appVersion,omitempty"`
+}
+
+// Record is a decoded release record. Besides the release, it contains the original JSON of the release and the
+// storage object (Secret or ConfigMap) the record was loaded from.
+type Record struct {
+	Release
+	Raw         json.RawMessage
+	Storage     string
+	StorageName string
+}
+
+// Summary is the summary of a release revision, which is returned by List.
+type Summary struct {
+	Name         string `json:"name"`
+	Namespace    string `json:"namespace"`
+	Revision     int    `json:"revision"`
+	Chart        string `json:"chart"`
+	ChartVersion string `json:"chartVersion"`
+	AppVersion   string `json:"appVersion"`
+	Status       string `json:"status"`
+	Description  string `json:"description,omitempty"`
+	LastDeployed string `json:"lastDeployed,omitempty"`
+	Storage      string `json:"storage"`
+}
+
+// ListResult is the result of List. The warnings contain the records, which could not be decoded and were skipped.
+type ListResult struct {
+	Releases []Summary `json:"releases"`
+	Warnings []string  `json:"warnings,omitempty"`
+}
+
+// Decode decodes the "release" field of a release record. The field contains the base64 encoded and gzip compressed
+// JSON of the release. To be resilient against different versions of Helm 3 and other tools which write release
+// records, uncompressed and not base64 encoded records are accepted as well.
+func Decode(data []byte) ([]byte, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return nil, fmt.Errorf("release data is empty")
+	}
+
+	if data[0] != '{' && !bytes.HasPrefix(data, gzipMagic) {
+		decoded, err := base64.StdEncoding.DecodeString(string(data))
+		if err != nil {
+			return nil, fmt.Errorf("could not decode release data: %w", err)
+		}
+		data = decoded
+	}
+
+	if bytes.HasPrefix(data, gzipMagic) {
+		reader, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("could not decompress release data: %w", err)
+		}
+		defer reader.Close()
+
+		decompressed, err := io.ReadAll(reader)
+		if err != nil {
+			return nil, fmt.Errorf("could not decompress release data: %w", err)
+		}
+		data = decompressed
+	}
+
+	if len(data) == 0 || data[0] != '{' {
+		return nil, fmt.Errorf("unsupported release format")
+	}
+
+	return data, nil
+}
+
+// Encode encodes the JSON of a release in the same format as Helm: gzip compressed and base64 encoded. The returned
+// data can be used as "release" field of a Secret.
+func Encode(data []byte) ([]byte, error) {
+	var buffer bytes.Buffer
+
+	writer, err := gzip.NewWriterLevel(&buffer, gzip.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := writer.Write(data); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	return []byte(base64.StdEncoding.EncodeToString(buffer.Bytes())), nil
+}
+
+func newRecord(data []byte, storage, storageName string) (*Record, error) {
+	raw, err := Decode(data)
+	if err != nil {
+		return nil, err
+	}
+
+	record := &Record{Raw: raw, Storage: storage, StorageName: storageName}
+	if err := json.Unmarshal(raw, &record.Release); err != nil {
+		return nil, fmt.Errorf("could not unmarshal release: %w", err)
+	}
+
+	return record, nil
+}
+
+// LoadRecords loads all release records from the given namespace, if the namespace is empty the records of all
+// namespaces are loaded. The records can be filtered by the name of the release. The records are loaded from the
+// Secrets and the ConfigMaps, because Helm can be configured to use the ConfigMap storage driver. If the user isn't
+// allowed to list ConfigMaps, only the records from the Secrets are returned. Records which can not be decoded are
+// skipped and returned as warnings.
+//
+// The records are sorted by namespace, name and revision (newest revision first).
+func LoadRecords(ctx context.Context, clientset kubernetes.Interface, namespace, name string) ([]*Record, []string, error) {
+	selector := ownerSelector
+	if name != "" {
+		selector = selector + ",name=" + name
+	}
+
+	var records []*Record
+	var warnings []string
+
+	skip := func(kind, namespace, name string, err error) {
+		warning := fmt.Sprintf("Skipped %s %s/%s: %s", kind, namespace, name, err.Error())
+		log.Print(warning)
+		warnings = append(warnings, warning)
+	}
+
+	secrets, err := clientset.CoreV1().Secrets(namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: selector,
+		FieldSelector: "type=" + SecretType,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// When a release was migrated between the storage drivers, the same revision could exist as Secret and ConfigMap.
+	// In this case we are only using the Secret, because it is the default driver of Helm.
+	revisions := make(map[string]bool)
+
+	for _, secret := range secrets.Items {
+		record, err := newRecord(secret.Data["release"], StorageSecret, secret.Name)
+		if err != nil {
+			skip("Secret", secret.Namespace, secret.Name, err)
+			continue
+		}
+		fillFromLabels(record, secret.Namespace, secret.Labels)
+		revisions[record.key()] = true
+		records = append(records, record)
+	}
+
+	configMaps, err := clientset.CoreV1().ConfigMaps(namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: selector,
+	})
+	if err != nil && !apierrors.IsForbidden(err) {
+		return nil, nil, err
+	}
+	if configMaps != nil {
+		for _, configMap := range configMaps.Items {
+			record, err := newRecord([]byte(configMap.Data["release"]), StorageConfigMap, configMap.Name)
+			if err != nil {
+				skip("ConfigMap", configMap.Namespace, configMap.Name, err)
+				continue
+			}
+			fillFromLabels(record, configMap.Namespace, configMap.Labels)
+			if revisions[record.key()] {
+				continue
+			}
+			records = append(records, record)
+		}
+	}
+
+	sort.SliceStable(records, func(i, j int) bool {
+		if records[i].Namespace != records[j].Namespace {
+			return records[i].Namespace < records[j].Namespace
+		}
+		if records[i].Name != records[j].Name {
+			return records[i].Name < records[j].Name
+		}
+		return records[i].Version > records[j].Version
+	})
+
+	return records, warnings, nil
+}
+
+// fillFromLabels sets the name, namespace and revision of a record from the labels of the storage object, when they
+// are missing in the release.
+func fillFromLabels(record *Record, namespace string, labels map[string]string) {
+	if record.Namespace == "" {
+		record.Namespace = namespace
+	}
+	if record.Name == "" {
+		record.Name = labels["name"]
+	}
+	if record.Version == 0 {
+		record.Version, _ = strconv.Atoi(labels["version"])
+	}
+}
+
+func (r *Record) key() string {
+	return fmt.Sprintf("%s/%s/%d", r.Namespace, r.Name, r.Version)
+}
+
+// List returns the summaries of all releases in the given namespace, if the namespace is empty the releases of all
+// namespaces are returned. By default only the latest revision of each release is returned, all revisions are
+// returned when "allRevisions" is true.
+func List(ctx context.Context, clientset kubernetes.Interface, namespace string, allRevisions bool) (*ListResult, error) {
+	records, warnings, err := LoadRecords(ctx, clientset, namespace, "")
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ListResult{Releases: []Summary{}, Warnings: warnings}
+
+	seen := make(map[string]bool)
+	for _, record := range records {
+		key := record.Namespace + "/" + record.Name
+		if !allRevisions && seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		result.Releases = append(result.Releases, record.Summary())
+	}
+
+	return result, nil
+}
+
+// Summary returns the summary of the release record.
+func (r *Record) Summary() Summary {
+	summary := Summary{
+		Name:      r.Name,
+		Namespace: r.Namespace,
+		Revision:  r.Version,
+		Storage:   r.Storage,
+	}
+
+	if r.Chart != nil && r.Chart.Metadata != nil {
+		summary.Chart = r.Chart.Metadata.Name
+		summary.ChartVersion = r.Chart.Metadata.Version
+		summary.AppVersion = r.Chart.Metadata.AppVersion
+	}
+
+	if r.Info != nil {
+		summary.Status = r.Info.Status
+		summary.Description = r.Info.Description
+		summary.LastDeployed = r.Info.LastDeployed
+	}
+
+	return summary
+}
@@ -0,0 +1,180 @@
+package helm
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/kubenav/kubenav/pkg/server/resources"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/kubernetes"
+)
+
+// hooksSkippedMessage is added to the result of all operations, because kubenav doesn't execute the hooks of a chart.
+const hooksSkippedMessage = "The hooks of the release were not executed."
+
+// RollbackOptions are the options for a rollback. The release is identified by the namespace and name. If the revision
+// is 0, the release is rolled back to the previous revision. When "dryRun" is true, only the diff between the current
+// and the target revision is returned.
+type RollbackOptions struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Revision  int    `json:"revision"`
+	DryRun    bool   `json:"dryRun"`
+}
+
+// RollbackResult is the result of a rollback. It contains the diff between the current and the target revision and
+// for a rollback, which isn't a dry run, the result for each resource and the new revision of the release.
+type RollbackResult struct {
+	Name            string           `json:"name"`
+	Namespace       string           `json:"namespace"`
+	CurrentRevision int              `json:"currentRevision"`
+	TargetRevision  int              `json:"targetRevision"`
+	NewRevision     int              `json:"newRevision,omitempty"`
+	Status          string           `json:"status,omitempty"`
+	DryRun          bool             `json:"dryRun"`
+	HooksSkipped    bool             `json:"hooksSkipped"`
+	Message         string           `json:"message"`
+	Diff            *Diff            `json:"diff"`
+	Resources       []ResourceResult `json:"resources,omitempty"`
+	Failed          int              `json:"failed"`
+}
+
+// Rollback rolls back a release to a previous revision, without the helm binary. The manifest of the target revision
+// is applied via a three-way merge against the live state and the resources which are not part of the target revision
+// are deleted. Then a new revision with the "deployed" status is created and the previous revisions are marked as
+// "superseded". If some resources could not be applied, the new revision is marked as "failed".
+//
+// The hooks of the chart are not executed, this is stated in the returned result.
+func Rollback(ctx context.Context, cache *resources.DiscoveryCache, clusterKey string, clientset kubernetes.Interface, options RollbackOptions) (*RollbackResult, error) {
+	records, err := loadRelease(ctx, clientset, options.Namespace, options.Name)
+	if err != nil {
+		return nil, err
+	}
+	current := records[0]
+
+	targetRevision := options.Revision
+	if targetRevision == 0 {
+		targetRevision = current.Version - 1
+	}
+	if targetRevision < 1 {
+		return nil, fmt.Errorf("release %s/%s has no previous revision", options.Namespace, options.Name)
+	}
+
+	var target *Record
+	for _, record := range records {
+		if record.Version == targetRevision {
+			target = record
+			break
+		}
+	}
+	if target == nil {
+		return nil, fmt.Errorf("revision %d of release %s/%s %w", targetRevision, options.Namespace, options.Name, ErrNotFound)
+	}
+
+	diff, err := DiffManifests(revisionName(current.Version), current.Manifest, revisionName(target.Version), target.Manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &RollbackResult{
+		Name:            current.Name,
+		Namespace:       current.Namespace,
+		CurrentRevision: current.Version,
+		TargetRevision:  target.Version,
+		DryRun:          options.DryRun,
+		HooksSkipped:    true,
+		Diff:            diff,
+	}
+
+	if options.DryRun {
+		result.Message = fmt.Sprintf("Dry run of the rollback to revision %d. %s", target.Version, hooksSkippedMessage)
+		return result, nil
+	}
+
+	currentResources, err := SplitManifest(current.Manifest)
+	if err != nil {
+		return nil, err
+	}
+	targetResources, err := SplitManifest(target.Manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	// Before we apply the manifest, we create the new revision with the "pending-rollback" status. The new revision
+	// works as lock: if another rollback or Helm itself created the revision in the meantime, the rollback fails.
+	object, err := target.object()
+	if err != nil {
+		return nil, err
+	}
+
+	info := map[string]interface{}{
+		"last_deployed": now(),
+		"status":        StatusPendingRollback,
+		"description":   fmt.Sprintf("Rollback to %d", target.Version),
+	}
+	if currentObject, err := current.object(); err == nil {
+		if currentInfo, ok := currentObject["info"].(map[string]interface{}); ok && currentInfo["first_deployed"] != nil {
+			info["first_deployed"] = currentInfo["first_deployed"]
+		}
+	}
+	if targetInfo, ok := object["info"].(map[string]interface{}); ok && targetInfo["notes"] != nil {
+		info["notes"] = targetInfo["notes"]
+	}
+
+	result.NewRevision = current.Version + 1
+	object["version"] = result.NewRevision
+	object["info"] = info
+
+	if err := createRecord(ctx, clientset, current.Storage, current.Namespace, current.Name, result.NewRevision, object); err != nil {
+		if apierrors.IsAlreadyExists(err) {
+			return nil, fmt.Errorf("revision %d of release %s/%s already exists, another operation is in progress", result.NewRevision, current.Namespace, current.Name)
+		}
+		return nil, err
+	}
+
+	c := &client{cache: cache, clusterKey: clusterKey, clientset: clientset, namespace: current.Namespace}
+	result.Resources, result.Failed = c.update(ctx, currentResources, targetResources)
+
+	newRecord := &Record{
+		Release:     Release{Name: current.Name, Namespace: current.Namespace, Version: result.NewRevision},
+		Storage:     current.Storage,
+		StorageName: storageName(current.Name, result.NewRevision),
+	}
+
+	if result.Failed > 0 {
+		result.Status = StatusFailed
+		result.Message = fmt.Sprintf("Rollback to revision %d failed: %d of %d resources could not be updated. %s", target.Version, result.Failed, len(result.Resources), hooksSkippedMessage)
+
+		setInfo(object, StatusFailed, result.Message)
+		if err := updateRecord(ctx, clientset, newRecord, object); err != nil {
+			return nil, err
+		}
+		if err := setRecordStatus(ctx, clientset, current, StatusSuperseded, ""); err != nil {
+			return nil, err
+		}
+
+		return result, nil
+	}
+
+	for _, record := range records {
+		if record.Info != nil && record.Info.Status == StatusDeployed {
+			if err := setRecordStatus(ctx, clientset, record, StatusSuperseded, ""); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	setInfo(object, StatusDeployed, "")
+	if err := updateRecord(ctx, clientset, newRecord, object); err != nil {
+		return nil, err
+	}
+
+	result.Status = StatusDeployed
+	result.Message = fmt.Sprintf("Rollback to revision %d was successful. %s", target.Version, hooksSkippedMessage)
+	return result, nil
+}
+
+func revisionName(revision int) string {
+	return fmt.Sprintf("revision %d", revision)
+}
@@ -0,0 +1,181 @@
+package helm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// The status of a release revision, which are set by kubenav.
+const (
+	StatusDeployed        = "deployed"
+	StatusSuperseded      = "superseded"
+	StatusFailed          = "failed"
+	StatusUninstalled     = "uninstalled"
+	StatusUninstalling    = "uninstalling"
+	StatusPendingRollback = "pending-rollback"
+)
+
+// object returns the release of the record as generic object. The object contains all fields of the original release,
+// so that it can be modified and written back without losing the fields, which are not known by kubenav (e.g. the
+// hooks and templates).
+func (r *Record) object() (map[string]interface{}, error) {
+	var object map[string]interface{}
+	if err := json.Unmarshal(r.Raw, &object); err != nil {
+		return nil, err
+	}
+	return object, nil
+}
+
+// setInfo sets the status and description in the info of the given release object.
+func setInfo(object map[string]interface{}, status, description string) {
+	info, ok := object["info"].(map[string]interface{})
+	if !ok {
+		info = make(map[string]interface{})
+		object["info"] = info
+	}
+
+	info["status"] = status
+	if description != "" {
+		info["description"] = description
+	}
+}
+
+// now returns the current time in the format, which is used by Helm for the timestamps of a release.
+func now() string {
+	return time.Now().Format(time.RFC3339Nano)
+}
+
+// storageName returns the name of the Secret or ConfigMap for the given revision of a release. This is the same name
+// as it is used by Helm, so that Helm can still manage the release.
+func storageName(name string, version int) string {
+	return fmt.Sprintf("sh.helm.release.v1.%s.v%d", name, version)
+}
+
+// createRecord creates a new Secret or ConfigMap for the given release object. The storage driver is the same as the
+// one of the existing revisions of the release. If the record already exists an error is returned.
+func createRecord(ctx context.Context, clientset kubernetes.Interface, storage, namespace, name string, version int, object map[string]interface{}) error {
+	data, labels, err := encodeRecord(name, version, object)
+	if err != nil {
+		return err
+	}
+	labels["createdAt"] = strconv.FormatInt(time.Now().Unix(), 10)
+
+	meta := metav1.ObjectMeta{Name: storageName(name, version), Namespace: namespace, Labels: labels}
+
+	if storage == StorageConfigMap {
+		_, err = clientset.CoreV1().ConfigMaps(namespace).Create(ctx, &corev1.ConfigMap{
+			ObjectMeta: meta,
+			Data:       map[string]string{"release": string(data)},
+		}, metav1.CreateOptions{})
+		return err
+	}
+
+	_, err = clientset.CoreV1().Secrets(namespace).Create(ctx, &corev1.Secret{
+		ObjectMeta: meta,
+		Type:       SecretType,
+		Data:       map[string][]byte{"release": data},
+	}, metav1.CreateOptions{})
+	return err
+}
+
+// updateRecord writes the given release object to the existing Secret or ConfigMap of the record. The labels of the
+// storage object are updated, so that they match the new status of the release.
+func updateRecord(ctx context.Context, clientset kubernetes.Interface, record *Record, object map[string]interface{}) error {
+	data, labels, err := encodeRecord(record.Name, record.Version, object)
+	if err != nil {
+		return err
+	}
+	labels["modifiedAt"] = strconv.FormatInt(time.Now().Unix(), 10)
+
+	if record.Storage == StorageConfigMap {
+		configMap, err := clientset.CoreV1().ConfigMaps(record.Namespace).Get(ctx, record.StorageName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		configMap.Labels = mergeLabels(configMap.Labels, labels)
+		if configMap.Data == nil {
+			configMap.Data = make(map[string]string)
+		}
+		configMap.Data["release"] = string(data)
+
+		_, err = clientset.CoreV1().ConfigMaps(record.Namespace).Update(ctx, configMap, metav1.UpdateOptions{})
+		return err
+	}
+
+	secret, err := clientset.CoreV1().Secrets(record.Namespace).Get(ctx, record.StorageName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+
+	secret.Labels = mergeLabels(secret.Labels, labels)
+	if secret.Data == nil {
+		secret.Data = make(map[string][]byte)
+	}
+	secret.Data["release"] = data
+
+	_, err = clientset.CoreV1().Secrets(record.Namespace).Update(ctx, secret, metav1.UpdateOptions{})
+	return err
+}
+
+// deleteRecord deletes the Secret or ConfigMap of the record.
+func deleteRecord(ctx context.Context, clientset kubernetes.Interface, record *Record) error {
+	if record.Storage == StorageConfigMap {
+		return clientset.CoreV1().ConfigMaps(record.Namespace).Delete(ctx, record.StorageName, metav1.DeleteOptions{})
+	}
+	return clientset.CoreV1().Secrets(record.Namespace).Delete(ctx, record.StorageName, metav1.DeleteOptions{})
+}
+
+// setRecordStatus sets the status and description of an existing record and writes it back to the cluster.
+func setRecordStatus(ctx context.Context, clientset kubernetes.Interface, record *Record, status, description string) error {
+	object, err := record.object()
+	if err != nil {
+		return err
+	}
+
+	setInfo(object, status, description)
+	return updateRecord(ctx, clientset, record, object)
+}
+
+// encodeRecord encodes the release object and returns the labels of the storage object, which are used by Helm to
+// select the records of a release.
+func encodeRecord(name string, version int, object map[string]interface{}) ([]byte, map[string]string, error) {
+	raw, err := json.Marshal(object)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	data, err := Encode(raw)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var status string
+	if info, ok := object["info"].(map[string]interface{}); ok {
+		status, _ = info["status"].(string)
+	}
+
+	return data, map[string]string{
+		"name":    name,
+		"owner":   "helm",
+		"status":  status,
+		"version": strconv.Itoa(version),
+	}, nil
+}
+
+func mergeLabels(labels, updates map[string]string) map[string]string {
+	if labels == nil {
+		labels = make(map[string]string, len(updates))
+	}
+	for key, value := range updates {
+		labels[key] = value
+	}
+	return labels
+}
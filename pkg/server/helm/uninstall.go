@@ -0,0 +1,114 @@
+package helm
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/kubenav/kubenav/pkg/server/resources"
+
+	"k8s.io/client-go/kubernetes"
+)
+
+// UninstallOptions are the options for an uninstall. The release is identified by the namespace and name. When
+// "keepHistory" is true, the records of the release are kept and the latest revision is marked as "uninstalled". When
+// "dryRun" is true, the resources are deleted via a server-side dry run, so that nothing is changed.
+type UninstallOptions struct {
+	Namespace   string `json:"namespace"`
+	Name        string `json:"name"`
+	KeepHistory bool   `json:"keepHistory"`
+	DryRun      bool   `json:"dryRun"`
+}
+
+// UninstallResult is the result of an uninstall. It contains the result for each resource of the release, resources
+// which could not be deleted are marked as "failed" and are counted in "failed".
+type UninstallResult struct {
+	Name         string           `json:"name"`
+	Namespace    string           `json:"namespace"`
+	Revision     int              `json:"revision"`
+	DryRun       bool             `json:"dryRun"`
+	KeepHistory  bool             `json:"keepHistory"`
+	HooksSkipped bool             `json:"hooksSkipped"`
+	Message      string           `json:"message"`
+	Resources    []ResourceResult `json:"resources"`
+	Failed       int              `json:"failed"`
+}
+
+// Uninstall uninstalls a release, without the helm binary. The resources from the manifest of the latest revision are
+// deleted in the reverse install order of Helm, resources with the "helm.sh/resource-policy: keep" annotation are not
+// deleted. When a resource can not be deleted, the uninstall continues with the other resources and the failure is
+// reported in the result. Afterwards the records of the release are deleted or, when the history should be kept, the
+// latest revision is marked as "uninstalled".
+//
+// The hooks of the chart are not executed, this is stated in the returned result.
+func Uninstall(ctx context.Context, cache *resources.DiscoveryCache, clusterKey string, clientset kubernetes.Interface, options UninstallOptions) (*UninstallResult, error) {
+	records, err := loadRelease(ctx, clientset, options.Namespace, options.Name)
+	if err != nil {
+		return nil, err
+	}
+	current := records[0]
+
+	uninstalled := current.Info != nil && current.Info.Status == StatusUninstalled
+	if uninstalled && options.KeepHistory {
+		return nil, fmt.Errorf("release %s/%s is already uninstalled", options.Namespace, options.Name)
+	}
+
+	manifestResources, err := SplitManifest(current.Manifest)
+	if err != nil {
+		return nil, err
+	}
+	sortResources(manifestResources, true)
+
+	result := &UninstallResult{
+		Name:         current.Name,
+		Namespace:    current.Namespace,
+		Revision:     current.Version,
+		DryRun:       options.DryRun,
+		KeepHistory:  options.KeepHistory,
+		HooksSkipped: true,
+		Resources:    make([]ResourceResult, 0, len(manifestResources)),
+	}
+
+	if !options.DryRun && !uninstalled {
+		if err := setRecordStatus(ctx, clientset, current, StatusUninstalling, ""); err != nil {
+			return nil, err
+		}
+	}
+
+	// The resources of an uninstalled release were already deleted, when the history was kept. In this case we only
+	// have to delete the records of the release.
+	if !uninstalled {
+		c := &client{cache: cache, clusterKey: clusterKey, clientset: clientset, namespace: current.Namespace}
+		for _, resource := range manifestResources {
+			resourceResult := c.delete(ctx, resource, options.DryRun)
+			if resourceResult.Action == ActionFailed {
+				result.Failed++
+			}
+			result.Resources = append(result.Resources, resourceResult)
+		}
+	}
+
+	if options.DryRun {
+		result.Message = fmt.Sprintf("Dry run of the uninstall of release %s. %s", current.Name, hooksSkippedMessage)
+		return result, nil
+	}
+
+	if options.KeepHistory {
+		if err := setRecordStatus(ctx, clientset, current, StatusUninstalled, "Uninstallation complete"); err != nil {
+			return nil, err
+		}
+	} else {
+		for _, record := range records {
+			if err := deleteRecord(ctx, clientset, record); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if result.Failed > 0 {
+		result.Message = fmt.Sprintf("Uninstall of release %s completed with errors: %d of %d resources could not be deleted. %s", current.Name, result.Failed, len(result.Resources), hooksSkippedMessage)
+	} else {
+		result.Message = fmt.Sprintf("Uninstall of release %s was successful. %s", current.Name, hooksSkippedMessage)
+	}
+
+	return result, nil
+}
@@ -1,13 +1,525 @@
 package server
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/kubenav/kubenav/pkg/kube/credentials"
+	"github.com/kubenav/kubenav/pkg/server/audit"
+	"github.com/kubenav/kubenav/pkg/server/flux"
+	"github.com/kubenav/kubenav/pkg/server/helm"
+	"github.com/kubenav/kubenav/pkg/server/middleware"
+	"github.com/kubenav/kubenav/pkg/server/plugins"
+	"github.com/kubenav/kubenav/pkg/server/plugins/loki"
+	"github.com/kubenav/kubenav/pkg/server/proxy"
+	"github.com/kubenav/kubenav/pkg/server/requestcache"
+	"github.com/kubenav/kubenav/pkg/server/resources"
+	"github.com/kubenav/kubenav/pkg/server/serviceaccounts"
+	"github.com/kubenav/kubenav/pkg/server/terminal"
+	"github.com/kubenav/kubenav/pkg/server/validation"
+	"github.com/kubenav/kubenav/pkg/server/velero"
+
+	"github.com/gorilla/websocket"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// Container types which are used to label the containers of a Pod, so that the frontend can group them.
+const (
+	containerTypeContainer          = "container"
+	containerTypeInitContainer      = "initContainer"
+	containerTypeEphemeralContainer = "ephemeralContainer"
+)
+
+// podContainer is the structure of a single container (including init and ephemeral containers) of a Pod.
+type podContainer struct {
+	Name   string                  `json:"name"`
+	Type   string                  `json:"type"`
+	State  string                  `json:"state"`
+	status *corev1.ContainerStatus `json:"-"`
+}
+
+// getClientFromHeaders returns a rest config and clientset for the Kubernetes API, which are created from the
+// credentials send via our custom headers. If the "X-CLUSTER-ID" header is set, the credentials which were stored for
+// the cluster id are used instead.
+func (s *server) getClientFromHeaders(r *http.Request) (*rest.Config, *kubernetes.Clientset, error) {
+	if clusterID := r.Header.Get("X-CLUSTER-ID"); clusterID != "" {
+		c, err := credentials.Get(clusterID)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return s.kubeClient.GetClient(c.ContextName, c.ClusterServer, c.ClusterCertificateAuthorityData, c.ClusterInsecureSkipTLSVerify, c.UserClientCertificateData, c.UserClientKeyData, c.UserToken, c.UserUsername, c.UserPassword, c.Proxy, 0)
+	}
+
+	contextName := r.Header.Get("X-CONTEXT-NAME")
+	clusterServer := r.Header.Get("X-CLUSTER-SERVER")
+	clusterCertificateAuthorityData := r.Header.Get("X-CLUSTER-CERTIFICATE-AUTHORITY-DATA")
+	clusterInsecureSkipTLSVerify := r.Header.Get("X-CLUSTER-INSECURE-SKIP-TLS-VERIFY")
+	userClientCertificateData := r.Header.Get("X-USER-CLIENT-CERTIFICATE-DATA")
+	userClientKeyData := r.Header.Get("X-USER-CLIENT-KEY-DATA")
+	userToken := r.Header.Get("X-USER-TOKEN")
+	userUsername := r.Header.Get("X-USER-USERNAME")
+	userPassword := r.Header.Get("X-USER-PASSWORD")
+	proxy := r.Header.Get("X-PROXY")
+
+	parsedClusterInsecureSkipTLSVerify, err := strconv.ParseBool(clusterInsecureSkipTLSVerify)
+	if err != nil {
+		parsedClusterInsecureSkipTLSVerify = false
+	}
+
+	return s.kubeClient.GetClient(contextName, clusterServer, clusterCertificateAuthorityData, parsedClusterInsecureSkipTLSVerify, userClientCertificateData, userClientKeyData, userToken, userUsername, userPassword, proxy, 0)
+}
+
+// getClusterFromHeaders returns an identifier for the cluster of a request. This is the cluster server or the context
+// name on desktop, where the cluster server isn't send. For requests with the "X-CLUSTER-ID" header, the identifier of
+// the stored credentials is used, so that both kinds of requests share the same caches.
+func getClusterFromHeaders(r *http.Request) string {
+	if clusterID := r.Header.Get("X-CLUSTER-ID"); clusterID != "" {
+		if c, err := credentials.Get(clusterID); err == nil {
+			return c.Cluster()
+		}
+		return clusterID
+	}
+
+	if clusterServer := r.Header.Get("X-CLUSTER-SERVER"); clusterServer != "" {
+		return clusterServer
+	}
+
+	return r.Header.Get("X-CONTEXT-NAME")
+}
+
+// newUpgrader returns the upgrader, which must be used by all handlers to upgrade a http connection to a WebSocket
+// connection. By default the upgrader negotiates the per message compression (permessage-deflate) with the client, to
+// reduce the amount of transferred data for the terminal and log streams. If the client doesn't support the extension
+// the messages are send uncompressed. The compression can be disabled via the "DisableWebSocketCompression" option.
+//
+// The origin of the request is checked via checkOrigin, so that websites which are opened in the browser of the user
+// can not open a WebSocket connection to our server.
+func (s *server) newUpgrader() websocket.Upgrader {
+	return websocket.Upgrader{
+		EnableCompression: !s.getOptions().DisableWebSocketCompression,
+		CheckOrigin:       s.checkOrigin,
+	}
+}
+
+// The default limits for the size of request bodies, WebSocket messages and buffered responses.
+const (
+	DefaultMaxRequestBodySize = 3 * 1024 * 1024
+	DefaultMaxResponseSize    = 64 * 1024 * 1024
 )
 
+// maxRequestBodySize returns the maximum size of a request body and a WebSocket message from the options.
+func (s *server) maxRequestBodySize() int64 {
+	if s.getOptions().MaxRequestBodySize > 0 {
+		return s.getOptions().MaxRequestBodySize
+	}
+	return DefaultMaxRequestBodySize
+}
+
+// maxResponseSize returns the maximum size of a buffered response from the Kubernetes API from the options.
+func (s *server) maxResponseSize() int64 {
+	if s.getOptions().MaxResponseSize > 0 {
+		return s.getOptions().MaxResponseSize
+	}
+	return DefaultMaxResponseSize
+}
+
+// decodeRequestBody decodes the JSON body of the request into the given value. The body is limited to the maximum
+// request body size, so that a misbehaving client can not force us to buffer a huge body. If the body is empty, too
+// large or invalid, the error is written to the response and false is returned.
+func (s *server) decodeRequestBody(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if r.Body == nil || r.Body == http.NoBody {
+		middleware.Errorf(w, r, middleware.InvalidRequestBody(nil), http.StatusBadRequest, "Request body is empty")
+		return false
+	}
+
+	limit := s.maxRequestBodySize()
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, limit)).Decode(v); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			middleware.Errorf(w, r, middleware.RequestTooLarge(err), http.StatusRequestEntityTooLarge, fmt.Sprintf("Request body exceeds the limit of %d bytes", limit))
+			return false
+		}
+
+		middleware.Errorf(w, r, middleware.InvalidRequestBody(err), http.StatusBadRequest, fmt.Sprintf("Could not decode request body: %s", err.Error()))
+		return false
+	}
+
+	return true
+}
+
+// proxyResponse is a buffered response of the Kubernetes API, which can be shared by deduplicated proxy requests. The
+// response must not be modified, because it is shared by all waiting requests.
+type proxyResponse struct {
+	statusCode int
+	header     http.Header
+	body       []byte
+	truncated  bool
+}
+
+// proxyDeduplicated forwards a non-streaming GET request of the proxyHandler. Concurrent requests with the same cluster,
+// credentials, url and "Accept" header share a single request to the Kubernetes API and all receive the same response.
+// The shared request is only canceled, when all waiting requests are canceled.
+func (s *server) proxyDeduplicated(w http.ResponseWriter, r *http.Request, restConfig *rest.Config, clientset kubernetes.Interface, req *http.Request) {
+	key := requestcache.DedupKey(getClusterFromHeaders(r)+"\x00"+requestcache.CredentialsKey(restConfig), req.Method, req.URL.String()+"\x00"+req.Header.Get("Accept"))
+
+	resp, _, err := s.proxyRequests.Do(r.Context(), key, func(ctx context.Context) (*proxyResponse, error) {
+		resp, err := proxy.Do(clientset, restConfig, req.WithContext(ctx))
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+
+		data, truncated, err := readLimited(resp.Body, s.maxResponseSize())
+		if err != nil {
+			return nil, fmt.Errorf("could not read response: %w", err)
+		}
+
+		return &proxyResponse{statusCode: resp.StatusCode, header: resp.Header, body: data, truncated: truncated}, nil
+	})
+	if err != nil {
+		middleware.Errorf(w, r, err, http.StatusBadGateway, fmt.Sprintf("Could not send request: %s", err.Error()))
+		return
+	}
+
+	proxy.CopyHeaders(w.Header(), resp.header)
+	if resp.truncated {
+		w.Header().Set(proxy.TruncatedHeader, "true")
+		w.Header().Set(proxy.LimitHeader, strconv.FormatInt(s.maxResponseSize(), 10))
+	}
+
+	w.WriteHeader(resp.statusCode)
+	w.Write(resp.body)
+}
+
+// readLimited reads the given reader up to the given limit. If the reader contains more data, the returned data is
+// truncated to the limit and true is returned, so that the caller can inform the client about the truncation.
+func readLimited(reader io.Reader, limit int64) ([]byte, bool, error) {
+	data, err := io.ReadAll(io.LimitReader(reader, limit+1))
+	if err != nil {
+		return nil, false, err
+	}
+
+	if int64(len(data)) > limit {
+		return data[:limit], true, nil
+	}
+	return data, false, nil
+}
+
+// cors applies the Cors middleware with the current cors options, so that the options can be changed while the server
+// is running.
+func (s *server) cors(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		middleware.Cors(s.getOptions().Cors, next).ServeHTTP(w, r)
+	}
+}
+
+// copyAndFlush copies the reader to the response writer and flushes the response after each read, so that streaming
+// responses (e.g. watch events) are send to the client immediately. The copy is stopped, when the reader or the client
+// returns an error.
+func copyAndFlush(w http.ResponseWriter, reader io.Reader) {
+	flusher, _ := w.(http.Flusher)
+	buffer := make([]byte, 32*1024)
+
+	for {
+		n, err := reader.Read(buffer)
+		if n > 0 {
+			if _, writeErr := w.Write(buffer[:n]); writeErr != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// upgradeConnection upgrades the http connection to a WebSocket connection via the given upgrader and registers a
+// close handler, which records the close code and reason of the client for the request log.
+func upgradeConnection(upgrader websocket.Upgrader, w http.ResponseWriter, r *http.Request) (*websocket.Conn, error) {
+	c, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	closeHandler := c.CloseHandler()
+	c.SetCloseHandler(func(code int, text string) error {
+		middleware.SetCloseReason(r, fmt.Sprintf("%d %s", code, text))
+		return closeHandler(code, text)
+	})
+
+	return c, nil
+}
+
+// checkOrigin returns true, when the request doesn't contain an "Origin" header, which is the case for our native
+// clients, or when the origin is contained in the "AllowedOrigins" option. All other requests are rejected by the
+// upgrader with a "403 Forbidden" error before the connection is upgraded.
+func (s *server) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	for _, allowedOrigin := range s.getOptions().AllowedOrigins {
+		if strings.EqualFold(origin, allowedOrigin) {
+			return true
+		}
+	}
+
+	log.Printf("Rejected WebSocket connection from origin %s", origin)
+	return false
+}
+
+// keepAlive sends a ping message every 30 seconds over the given WebSocket connection, so that the connection isn't
+// closed, when no data is send for a while. The ping messages are send via "WriteControl", because it can be called
+// concurrently with the other write methods of the connection. The function returns when the done channel is closed or
+// when the ping message could not be send.
+func keepAlive(c *websocket.Conn, done <-chan struct{}) {
+	c.SetPongHandler(func(string) error { return nil })
+
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := c.WriteControl(websocket.PingMessage, nil, time.Now().Add(10*time.Second)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// auditTerminalSession writes an entry for the given terminal session to the audit log. The exit code and the input
+// are only set for the corresponding events. If the audit log isn't enabled the entry is ignored.
+func (s *server) auditTerminalSession(session *terminal.Session, event string, exitCode *int, input string) {
+	if s.terminalAuditLog == nil {
+		return
+	}
+
+	entry := audit.TerminalEntry{
+		Time:      time.Now().Format(time.RFC3339Nano),
+		Event:     event,
+		SessionID: session.ID,
+		RequestID: session.RequestID,
+		Cluster:   redactURLUserinfo(session.Cluster),
+		Namespace: session.Namespace,
+		Pod:       session.Name,
+		Container: session.Container,
+		Command:   session.Command,
+		ExitCode:  exitCode,
+		Input:     input,
+	}
+	if event == "end" {
+		entry.Duration = time.Since(session.StartTime).Seconds()
+	}
+
+	s.terminalAuditLog.Write(entry)
+}
+
+// maxAuditErrorLength is the maximum length of an error message in the mutation audit log.
+const maxAuditErrorLength = 1024
+
+// auditMutation writes an entry for a mutating operation to the audit log. The "action" is the name of the action or
+// the method of the request. The cluster, url and error are scrubbed, so that they never contain any credentials. If
+// the audit log isn't enabled the entry is ignored.
+func (s *server) auditMutation(requestID, action, cluster, resourceURL string, statusCode int, err error) {
+	if s.mutationAuditLog == nil {
+		return
+	}
+
+	entry := audit.MutationEntry{
+		Time:       time.Now().Format(time.RFC3339Nano),
+		RequestID:  requestID,
+		Action:     action,
+		Cluster:    redactURLUserinfo(cluster),
+		URL:        middleware.Scrub(redactURLUserinfo(resourceURL)),
+		Outcome:    audit.OutcomeSuccess,
+		StatusCode: statusCode,
+	}
+	if err != nil || statusCode >= http.StatusBadRequest {
+		entry.Outcome = audit.OutcomeFailure
+	}
+	if err != nil {
+		entry.Error = middleware.Scrub(err.Error())
+		if len(entry.Error) > maxAuditErrorLength {
+			entry.Error = entry.Error[:maxAuditErrorLength]
+		}
+	}
+
+	s.mutationAuditLog.Write(entry)
+}
+
+// AuditMutation writes an entry for a mutating operation to the audit log of the running server. It is used by the
+// mobile and desktop bindings, which send requests to the Kubernetes API without the server. If the server isn't
+// running or the audit log isn't enabled the entry is ignored. Requests with the "GET" method are never recorded.
+func AuditMutation(action, cluster, resourceURL string, err error) {
+	if action == http.MethodGet {
+		return
+	}
+
+	if s := getInstance(); s != nil {
+		s.auditMutation("", action, cluster, resourceURL, 0, err)
+	}
+}
+
+// redactURLUserinfo removes the user information (e.g. basic auth credentials) from the given url, so that it can be
+// logged. If the value isn't a valid url it is returned unchanged.
+func redactURLUserinfo(value string) string {
+	parsedURL, err := url.Parse(value)
+	if err != nil || parsedURL.User == nil {
+		return value
+	}
+
+	parsedURL.User = nil
+	return parsedURL.String()
+}
+
+// webSocketWriter serializes the writes of JSON messages to a WebSocket connection, because a WebSocket connection only
+// supports one concurrent writer.
+type webSocketWriter struct {
+	conn *websocket.Conn
+	lock sync.Mutex
+}
+
+func newWebSocketWriter(conn *websocket.Conn) *webSocketWriter {
+	return &webSocketWriter{conn: conn}
+}
+
+// WriteJSON writes the given value as JSON message to the WebSocket connection.
+func (w *webSocketWriter) WriteJSON(v any) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	return w.conn.WriteJSON(v)
+}
+
+// Close sends a close frame with the given close code and text to the client.
+func (w *webSocketWriter) Close(code int, text string) error {
+	return w.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, text), time.Now().Add(5*time.Second))
+}
+
+// eventStreamHeartbeatInterval is the interval in which a heartbeat comment is written to an event stream, so that
+// proxies do not close the connection.
+const eventStreamHeartbeatInterval = 15 * time.Second
+
+// isEventStream returns true if the client requested a Server-Sent Events stream instead of a WebSocket connection.
+// This is the case when the path ends with "/sse" or when the client accepts the "text/event-stream" content type.
+func isEventStream(r *http.Request) bool {
+	return strings.HasSuffix(r.URL.Path, "/sse") || strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+}
+
+// eventStreamWriter writes Server-Sent Events to a http response. It is used as an alternative to a WebSocket
+// connection, for clients which are behind a proxy that doesn't support WebSockets.
+type eventStreamWriter struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+	lock    sync.Mutex
+}
+
+// newEventStreamWriter writes the headers for an event stream and returns the writer. If the response writer doesn't
+// support flushing an error is returned, before anything is written.
+func newEventStreamWriter(w http.ResponseWriter) (*eventStreamWriter, error) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return nil, fmt.Errorf("streaming is not supported")
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	return &eventStreamWriter{w: w, flusher: flusher}, nil
+}
+
+// WriteEvent writes the given value as JSON encoded data frame with the given event type. If the id is not empty, it
+// is also written, so that the client can send it via the "Last-Event-ID" header when it reconnects.
+func (w *eventStreamWriter) WriteEvent(event, id string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if id != "" {
+		if _, err := fmt.Fprintf(w.w, "id: %s\n", id); err != nil {
+			return err
+		}
+	}
+	if _, err := fmt.Fprintf(w.w, "event: %s\ndata: %s\n\n", event, data); err != nil {
+		return err
+	}
+
+	w.flusher.Flush()
+	return nil
+}
+
+// heartbeat writes a comment to the event stream in the heartbeat interval, until the done channel is closed.
+func (w *eventStreamWriter) heartbeat(done <-chan struct{}) {
+	ticker := time.NewTicker(eventStreamHeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			w.lock.Lock()
+			_, err := fmt.Fprint(w.w, ": heartbeat\n\n")
+			if err == nil {
+				w.flusher.Flush()
+			}
+			w.lock.Unlock()
+
+			if err != nil {
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// cancelOnClose reads from the WebSocket connection until it is closed by the client and then calls the cancel
+// function. All messages send by the client are ignored. This is used for streams, where the client only receives
+// messages, so that the stream is stopped when the client disconnects.
+func cancelOnClose(conn *websocket.Conn, cancel context.CancelFunc) {
+	defer cancel()
+
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return
+		}
+	}
+}
+
 func getPodContainerAndPort(pod corev1.Pod, serviceTargetPort string) (string, int64) {
-	for _, container := range pod.Spec.Containers {
+	containers := append([]corev1.Container{}, pod.Spec.Containers...)
+	containers = append(containers, pod.Spec.InitContainers...)
+
+	for _, container := range containers {
 		for _, port := range container.Ports {
 			parsedServiceTargetPort, err := strconv.ParseInt(serviceTargetPort, 10, 64)
 			if err == nil {
@@ -24,3 +536,367 @@ func getPodContainerAndPort(pod corev1.Pod, serviceTargetPort string) (string, i
 
 	return "", 0
 }
+
+// getPodContainers returns all containers of a Pod, including the init and ephemeral containers. Each container is
+// labeled with its type and the current state of the container.
+func getPodContainers(pod *corev1.Pod) []podContainer {
+	var containers []podContainer
+
+	addContainer := func(name, containerType string, statuses []corev1.ContainerStatus) {
+		container := podContainer{
+			Name:  name,
+			Type:  containerType,
+			State: "unknown",
+		}
+
+		for i := range statuses {
+			if statuses[i].Name == name {
+				container.status = &statuses[i]
+				container.State = formatContainerState(statuses[i].State)
+				break
+			}
+		}
+
+		containers = append(containers, container)
+	}
+
+	for _, c := range pod.Spec.InitContainers {
+		addContainer(c.Name, containerTypeInitContainer, pod.Status.InitContainerStatuses)
+	}
+	for _, c := range pod.Spec.Containers {
+		addContainer(c.Name, containerTypeContainer, pod.Status.ContainerStatuses)
+	}
+	for _, c := range pod.Spec.EphemeralContainers {
+		addContainer(c.Name, containerTypeEphemeralContainer, pod.Status.EphemeralContainerStatuses)
+	}
+
+	return containers
+}
+
+// getDefaultContainer returns the name of the container of the given Pod, which is used when the user doesn't provide a
+// container name. This is only possible when the Pod has exactly one container.
+func getDefaultContainer(pod *corev1.Pod) (string, error) {
+	if len(pod.Spec.Containers) != 1 {
+		return "", fmt.Errorf("container is required, because pod %s has %d containers", pod.Name, len(pod.Spec.Containers))
+	}
+
+	return pod.Spec.Containers[0].Name, nil
+}
+
+// getExecContainer returns the container, which should be used to execute a command in the given Pod. If the
+// container is empty, the default container of the Pod is used. When the container doesn't exist or isn't running we
+// return the http status code and an error, which can be returned to the user.
+func getExecContainer(ctx context.Context, clientset kubernetes.Interface, namespace, name, container string) (string, int, error) {
+	pod, err := clientset.CoreV1().Pods(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return "", http.StatusBadRequest, fmt.Errorf("could not get pod: %w", err)
+	}
+
+	if container == "" {
+		container, err = getDefaultContainer(pod)
+		if err != nil {
+			return "", http.StatusBadRequest, err
+		}
+	}
+
+	if statusCode, err := validateContainer(pod, container); err != nil {
+		return "", statusCode, err
+	}
+
+	return container, http.StatusOK, nil
+}
+
+// validateContainer checks if the given container (or init or ephemeral container) exists in the Pod and if the
+// container is running, so that we can exec into the container. If the container can not be used, we return the http
+// status code and an error which describes the state of the container.
+func validateContainer(pod *corev1.Pod, container string) (int, error) {
+	for _, c := range getPodContainers(pod) {
+		if c.Name != container {
+			continue
+		}
+
+		if c.status == nil {
+			return http.StatusConflict, fmt.Errorf("%s %s is not running: no status available (pod phase %s)", c.Type, container, pod.Status.Phase)
+		}
+
+		if c.status.State.Running != nil {
+			return http.StatusOK, nil
+		}
+
+		if c.status.State.Terminated != nil {
+			return http.StatusConflict, fmt.Errorf("%s %s already terminated with exit code %d (%s)", c.Type, container, c.status.State.Terminated.ExitCode, c.status.State.Terminated.Reason)
+		}
+
+		return http.StatusConflict, fmt.Errorf("%s %s is not running: %s%s", c.Type, container, c.State, formatLastTermination(c.status.LastTerminationState))
+	}
+
+	return http.StatusNotFound, fmt.Errorf("container %s not found in pod %s", container, pod.Name)
+}
+
+// formatContainerState returns a human readable description of the given container state, e.g.
+// "waiting (CrashLoopBackOff: back-off 5m0s restarting failed container)".
+func formatContainerState(state corev1.ContainerState) string {
+	if state.Waiting != nil {
+		if state.Waiting.Message != "" {
+			return fmt.Sprintf("waiting (%s: %s)", state.Waiting.Reason, state.Waiting.Message)
+		}
+		return fmt.Sprintf("waiting (%s)", state.Waiting.Reason)
+	}
+
+	if state.Terminated != nil {
+		return fmt.Sprintf("terminated (%s, exit code %d)", state.Terminated.Reason, state.Terminated.ExitCode)
+	}
+
+	if state.Running != nil {
+		return "running"
+	}
+
+	return "unknown"
+}
+
+// formatLastTermination returns the reason and exit code of the last termination for a container, so that the user
+// knows why a container in the CrashLoopBackOff state failed. If the container was never terminated an empty string
+// is returned.
+func formatLastTermination(state corev1.ContainerState) string {
+	if state.Terminated == nil {
+		return ""
+	}
+
+	return fmt.Sprintf(", last termination reason: %s (exit code %d)", state.Terminated.Reason, state.Terminated.ExitCode)
+}
+
+// attachmentWriter writes the response for a file download. The headers are only written with the first write, so
+// that we can still return an error to the client, when the download fails before any data was written. If the content
+// type is empty, "application/octet-stream" is used.
+type attachmentWriter struct {
+	w           http.ResponseWriter
+	filename    string
+	contentType string
+	written     bool
+}
+
+func (w *attachmentWriter) Write(p []byte) (int, error) {
+	if !w.written {
+		contentType := w.contentType
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		w.w.Header().Set("Content-Type", contentType)
+		w.w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", w.filename))
+		w.w.WriteHeader(http.StatusOK)
+		w.written = true
+	}
+
+	return w.w.Write(p)
+}
+
+// helmReleaseFromQuery returns the namespace, name and revision of a Helm release from the query parameters of a
+// request. The namespace and name are required, if the revision is missing 0 is returned for the latest revision.
+func helmReleaseFromQuery(query url.Values) (string, string, int, error) {
+	namespace := query.Get("namespace")
+	name := query.Get("name")
+	if namespace == "" || name == "" {
+		return "", "", 0, fmt.Errorf("namespace and name are required")
+	}
+
+	var revision int
+	if value := query.Get("revision"); value != "" {
+		parsedRevision, err := strconv.Atoi(value)
+		if err != nil || parsedRevision < 0 {
+			return "", "", 0, fmt.Errorf("invalid revision %s", value)
+		}
+		revision = parsedRevision
+	}
+
+	return namespace, name, revision, nil
+}
+
+// helmError returns the status code and error for an error of the helm package. If the release doesn't exist a "not
+// found" error is returned, for all other errors an internal server error is returned.
+func helmError(err error) (int, error) {
+	if errors.Is(err, helm.ErrNotFound) {
+		return http.StatusNotFound, middleware.WithCode(middleware.CodeNotFound, err)
+	}
+	return http.StatusInternalServerError, err
+}
+
+// fluxError returns the status code and error for an error of the flux package. For errors of the Kubernetes API the
+// status code of the API server is returned, see resourcesError.
+func fluxError(err error) (int, error) {
+	switch {
+	case errors.Is(err, flux.ErrNotInstalled):
+		return http.StatusNotFound, middleware.WithCode(middleware.CodeNotFound, err)
+	case errors.Is(err, flux.ErrSuspended):
+		return http.StatusConflict, middleware.WithCode(middleware.CodeConflict, err)
+	default:
+		return resourcesError(err)
+	}
+}
+
+// resourcesError returns the status code and error for an error of the resources package or of any other package,
+// which wraps resources.ErrInvalidOptions for invalid options. For errors of the Kubernetes API the status code of the
+// API server is returned, so that e.g. a failed precondition is returned as conflict.
+func resourcesError(err error) (int, error) {
+	if errors.Is(err, resources.ErrInvalidOptions) {
+		return http.StatusBadRequest, middleware.InvalidParameters(err)
+	}
+
+	var apiStatus apierrors.APIStatus
+	if errors.As(err, &apiStatus) && apiStatus.Status().Code >= http.StatusBadRequest {
+		return int(apiStatus.Status().Code), err
+	}
+
+	return http.StatusInternalServerError, err
+}
+
+// serviceAccountsError returns the status code and error for an error of the serviceaccounts package. When the cluster
+// doesn't support the TokenRequest API a "501 Not Implemented" error is returned.
+func serviceAccountsError(err error) (int, error) {
+	if errors.Is(err, serviceaccounts.ErrNotSupported) {
+		return http.StatusNotImplemented, middleware.WithCode(middleware.CodeNotSupported, err)
+	}
+
+	return resourcesError(err)
+}
+
+// validationError returns the status code and error for an error of the validation package. When the cluster doesn't
+// publish the OpenAPI v3 schema, the validation is not supported.
+func validationError(err error) (int, error) {
+	switch {
+	case errors.Is(err, validation.ErrInvalidManifest):
+		return http.StatusBadRequest, middleware.InvalidParameters(err)
+	case errors.Is(err, validation.ErrNotSupported):
+		return http.StatusNotImplemented, middleware.WithCode(middleware.CodeNotSupported, err)
+	}
+
+	return resourcesError(err)
+}
+
+// veleroError returns the status code and error for an error of the velero package. For errors of the Kubernetes API
+// the status code of the API server is returned, see resourcesError.
+func veleroError(err error) (int, error) {
+	switch {
+	case errors.Is(err, velero.ErrNotInstalled), errors.Is(err, velero.ErrNotFound):
+		return http.StatusNotFound, middleware.WithCode(middleware.CodeNotFound, err)
+	default:
+		return resourcesError(err)
+	}
+}
+
+// pluginError returns the status code and error for an error of a plugin. The common failures of a request to the
+// service of a plugin (see plugins.ErrNotFound and the other errors of the plugins package) are mapped to a
+// machine-readable code, so that they can be handled by the client. All other errors are returned as "502 Bad Gateway"
+// error.
+func pluginError(err error) (int, error) {
+	switch {
+	case errors.Is(err, plugins.ErrInvalidQuery):
+		return http.StatusBadRequest, middleware.InvalidParameters(err)
+	case errors.Is(err, plugins.ErrNotFound):
+		return http.StatusNotFound, middleware.WithCode(middleware.CodeNotFound, err)
+	case errors.Is(err, plugins.ErrUnauthorized):
+		return http.StatusUnauthorized, middleware.WithCode(middleware.CodeUnauthorized, err)
+	case errors.Is(err, plugins.ErrForbidden):
+		return http.StatusForbidden, middleware.WithCode(middleware.CodeForbidden, err)
+	case errors.Is(err, plugins.ErrUnavailable):
+		return http.StatusServiceUnavailable, middleware.WithCode(middleware.CodeUnavailable, err)
+	case errors.Is(err, plugins.ErrTimeout):
+		return http.StatusGatewayTimeout, middleware.WithCode(middleware.CodeTimeout, err)
+	default:
+		return http.StatusBadGateway, err
+	}
+}
+
+// getPluginClient returns a client for the service of a plugin. The service is taken from the query parameters of the
+// request, from the "Plugins" option for the cluster of the request or it is autodetected via the given candidates.
+// The access mode is taken from the "access" query parameter. The returned client must be closed by the caller. If the
+// client can not be created, the error and the status code for the response are returned.
+func (s *server) getPluginClient(r *http.Request, plugin, sessionPrefix string, candidates []plugins.Candidate) (*plugins.Client, int, error) {
+	service, err := plugins.ServiceFromQuery(r.URL.Query())
+	if err != nil {
+		return nil, http.StatusBadRequest, middleware.InvalidParameters(err)
+	}
+
+	restConfig, clientset, err := s.getClientFromHeaders(r)
+	if err != nil {
+		return nil, http.StatusBadRequest, middleware.ClientConfiguration(err)
+	}
+
+	cluster := getClusterFromHeaders(r)
+	if service == nil {
+		if configured, ok := s.getOptions().Plugins[cluster][plugin]; ok {
+			service = &configured
+		}
+	}
+	if service == nil {
+		service, err = plugins.Detect(r.Context(), clientset, cluster+"/"+plugin, candidates)
+		if err != nil {
+			return nil, http.StatusNotFound, middleware.WithCode(middleware.CodeNotFound, err)
+		}
+	}
+
+	client, err := plugins.NewClient(restConfig, clientset, *service, r.URL.Query().Get("access"), sessionPrefix)
+	if err != nil {
+		return nil, http.StatusBadRequest, middleware.InvalidParameters(err)
+	}
+
+	return client, 0, nil
+}
+
+// getPluginClientWithCredentials returns a client for the service of a plugin, which can require authentication. If
+// the address is set, the service is accessed directly via this address, otherwise it is accessed inside the cluster,
+// see getPluginClient. The returned client must be closed by the caller.
+func (s *server) getPluginClientWithCredentials(r *http.Request, address string, credentials *plugins.Credentials, plugin, sessionPrefix string, candidates []plugins.Candidate) (*plugins.Client, int, error) {
+	if address != "" {
+		client, err := plugins.NewDirectClient(address, credentials)
+		if err != nil {
+			return nil, http.StatusBadRequest, middleware.InvalidParameters(err)
+		}
+		return client, 0, nil
+	}
+
+	client, statusCode, err := s.getPluginClient(r, plugin, sessionPrefix, candidates)
+	if err != nil {
+		return nil, statusCode, err
+	}
+	if err := client.SetCredentials(credentials); err != nil {
+		client.Close()
+		return nil, http.StatusBadRequest, middleware.InvalidParameters(err)
+	}
+
+	return client, 0, nil
+}
+
+// getLokiClient returns a client for Loki from the given connection, see getPluginClientWithCredentials. The returned
+// client must be closed by the caller.
+func (s *server) getLokiClient(r *http.Request, connection loki.Connection) (*plugins.Client, int, error) {
+	client, statusCode, err := s.getPluginClientWithCredentials(r, connection.Address, connection.Credentials, loki.Name, loki.SessionPrefix, loki.Candidates)
+	if err != nil {
+		return nil, statusCode, err
+	}
+
+	connection.Configure(client)
+	return client, 0, nil
+}
+
+// writePluginResponse writes the response of the service of a plugin to the client. The status code, the content type
+// and the body are returned unmodified, so that errors of the service are passed through. Responses larger than the
+// maximum response size are truncated.
+func (s *server) writePluginResponse(w http.ResponseWriter, r *http.Request, resp *http.Response) {
+	defer resp.Body.Close()
+
+	data, truncated, err := readLimited(resp.Body, s.maxResponseSize())
+	if err != nil {
+		middleware.Errorf(w, r, err, http.StatusBadGateway, fmt.Sprintf("Could not read response: %s", err.Error()))
+		return
+	}
+	if truncated {
+		w.Header().Set(proxy.TruncatedHeader, "true")
+		w.Header().Set(proxy.LimitHeader, strconv.FormatInt(s.maxResponseSize(), 10))
+	}
+	if contentType := resp.Header.Get("Content-Type"); contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+
+	w.WriteHeader(resp.StatusCode)
+	w.Write(data)
+}
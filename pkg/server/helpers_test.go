@@ -0,0 +1,181 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/kubenav/kubenav/pkg/server/certificates"
+	"github.com/kubenav/kubenav/pkg/server/configdata"
+	"github.com/kubenav/kubenav/pkg/server/cronjobs"
+	"github.com/kubenav/kubenav/pkg/server/debugbundle"
+	"github.com/kubenav/kubenav/pkg/server/flux"
+	"github.com/kubenav/kubenav/pkg/server/kustomize"
+	"github.com/kubenav/kubenav/pkg/server/logs"
+	"github.com/kubenav/kubenav/pkg/server/middleware"
+	"github.com/kubenav/kubenav/pkg/server/namespaces"
+	"github.com/kubenav/kubenav/pkg/server/pods"
+	"github.com/kubenav/kubenav/pkg/server/rbac"
+	"github.com/kubenav/kubenav/pkg/server/resources"
+	"github.com/kubenav/kubenav/pkg/server/serviceaccounts"
+	"github.com/kubenav/kubenav/pkg/server/snapshots"
+	"github.com/kubenav/kubenav/pkg/server/velero"
+)
+
+// countingConn counts the bytes, which are read from the underlying connection.
+type countingConn struct {
+	net.Conn
+	read *int64
+}
+
+func (c countingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	atomic.AddInt64(c.read, int64(n))
+	return n, err
+}
+
+// streamLogs streams a repetitive log stream over a WebSocket connection, which is upgraded via newUpgrader, and
+// returns the received messages, the number of bytes read from the connection and if the compression was negotiated.
+func streamLogs(t *testing.T, options Options, lines []string) ([]string, int64, bool) {
+	t.Helper()
+
+	s := &server{}
+	s.options.Store(&options)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upgrader := s.newUpgrader()
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+
+		writer := newWebSocketWriter(c)
+		for _, line := range lines {
+			if err := writer.WriteJSON(logs.Message{Op: logs.OpLog, Pod: "nginx", Container: "nginx", Data: line}); err != nil {
+				return
+			}
+		}
+		writer.Close(websocket.CloseNormalClosure, "")
+	}))
+	defer srv.Close()
+
+	var read int64
+	dialer := websocket.Dialer{
+		EnableCompression: true,
+		NetDial: func(network, addr string) (net.Conn, error) {
+			conn, err := net.Dial(network, addr)
+			if err != nil {
+				return nil, err
+			}
+			return countingConn{Conn: conn, read: &read}, nil
+		},
+	}
+
+	c, resp, err := dialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("could not dial: %v", err)
+	}
+	defer c.Close()
+
+	var messages []string
+	for {
+		_, data, err := c.ReadMessage()
+		if err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+				t.Fatalf("could not read message: %v", err)
+			}
+			break
+		}
+
+		var msg logs.Message
+		if err := json.Unmarshal(data, &msg); err != nil {
+			t.Fatalf("could not decode message: %v", err)
+		}
+		messages = append(messages, msg.Data)
+	}
+
+	return messages, atomic.LoadInt64(&read), strings.Contains(resp.Header.Get("Sec-WebSocket-Extensions"), "permessage-deflate")
+}
+
+func TestNewUpgraderCompression(t *testing.T) {
+	var lines []string
+	for i := 0; i < 3000; i++ {
+		lines = append(lines, fmt.Sprintf("2023-01-01T12:00:%02d.000Z INFO [http] GET /api/v1/namespaces/default/pods 200 OK duration=12ms", i%60))
+	}
+
+	// A log stream is send as one message per line or as one message per batch of lines (e.g. when a stack trace or
+	// the output of a terminal is send).
+	var batches []string
+	for i := 0; i < len(lines); i += 30 {
+		batches = append(batches, strings.Join(lines[i:i+30], "\n"))
+	}
+
+	for _, tc := range []struct {
+		name          string
+		lines         []string
+		maxCompressed func(uncompressed int64) int64
+	}{
+		{name: "single lines", lines: lines, maxCompressed: func(uncompressed int64) int64 { return uncompressed * 9 / 10 }},
+		{name: "batches of lines", lines: batches, maxCompressed: func(uncompressed int64) int64 { return uncompressed / 4 }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			compressedMessages, compressed, negotiated := streamLogs(t, Options{}, tc.lines)
+			if !negotiated {
+				t.Fatalf("expected compression to be negotiated")
+			}
+
+			uncompressedMessages, uncompressed, negotiated := streamLogs(t, Options{DisableWebSocketCompression: true}, tc.lines)
+			if negotiated {
+				t.Fatalf("expected compression not to be negotiated, when it is disabled")
+			}
+
+			for _, messages := range [][]string{compressedMessages, uncompressedMessages} {
+				if strings.Join(messages, "\n") != strings.Join(tc.lines, "\n") {
+					t.Fatalf("expected %d messages to match the log stream, got %d messages", len(tc.lines), len(messages))
+				}
+			}
+
+			t.Logf("compressed %d bytes, uncompressed %d bytes", compressed, uncompressed)
+			if max := tc.maxCompressed(uncompressed); compressed > max {
+				t.Errorf("expected compressed stream to have at most %d bytes, got %d bytes", max, compressed)
+			}
+		})
+	}
+}
+
+func TestResourcesErrorInvalidOptions(t *testing.T) {
+	// The invalid options errors of all packages wrap resources.ErrInvalidOptions, so that they are mapped to a bad
+	// request by resourcesError and by the mappers, which are delegating to it.
+	for _, err := range []error{
+		resources.ErrInvalidOptions,
+		certificates.ErrInvalidOptions,
+		configdata.ErrInvalidOptions,
+		cronjobs.ErrInvalidOptions,
+		debugbundle.ErrInvalidOptions,
+		flux.ErrInvalidOptions,
+		kustomize.ErrInvalidOptions,
+		namespaces.ErrInvalidOptions,
+		pods.ErrInvalidOptions,
+		rbac.ErrInvalidOptions,
+		serviceaccounts.ErrInvalidOptions,
+		snapshots.ErrInvalidOptions,
+		velero.ErrInvalidOptions,
+	} {
+		for _, mapper := range []func(error) (int, error){resourcesError, fluxError, serviceAccountsError, veleroError} {
+			statusCode, mappedErr := mapper(fmt.Errorf("%w: name is required", err))
+
+			var codedErr *middleware.CodedError
+			if statusCode != http.StatusBadRequest || !errors.As(mappedErr, &codedErr) || codedErr.Code != middleware.CodeInvalidParameters {
+				t.Errorf("expected status code %d with code %s, got %d (%v)", http.StatusBadRequest, middleware.CodeInvalidParameters, statusCode, mappedErr)
+			}
+		}
+	}
+}
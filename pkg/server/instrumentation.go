@@ -0,0 +1,85 @@
+package server
+
+import (
+	"github.com/kubenav/kubenav/pkg/server/middleware"
+	"github.com/kubenav/kubenav/pkg/server/portforwarding"
+	"github.com/kubenav/kubenav/pkg/server/terminal"
+	"github.com/kubenav/kubenav/pkg/server/watch"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// newServerMetrics returns the metrics for the "/metrics" endpoint. Besides the request metrics, which are recorded by
+// the middleware, the metrics contain the number of active and created sessions from the session registries, the
+// number of active watches and the number of bytes, which were forwarded by the port forwarding sessions.
+//
+// The number of created sessions is counted via hooks of the session registries. The returned function removes these
+// hooks again and must be called when the server is stopped.
+func newServerMetrics() (*middleware.Metrics, func()) {
+	m := middleware.NewMetrics()
+
+	terminalSessionsCreated := prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "kubenav_server",
+		Name:      "terminal_sessions_created_total",
+		Help:      "Number of created terminal sessions.",
+	})
+	portForwardingSessionsCreated := prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "kubenav_server",
+		Name:      "portforwarding_sessions_created_total",
+		Help:      "Number of created port forwarding sessions.",
+	})
+
+	removeTerminalHook := terminal.Sessions.OnAdd(func(string, *terminal.Session) {
+		terminalSessionsCreated.Inc()
+	})
+	removePortForwardingHook := portforwarding.Sessions.OnAdd(func(string, *portforwarding.Session) {
+		portForwardingSessionsCreated.Inc()
+	})
+
+	m.Registry.MustRegister(
+		terminalSessionsCreated,
+		portForwardingSessionsCreated,
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: "kubenav_server",
+			Name:      "terminal_sessions",
+			Help:      "Number of active terminal sessions.",
+		}, func() float64 {
+			return float64(terminal.Sessions.Count())
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: "kubenav_server",
+			Name:      "portforwarding_sessions",
+			Help:      "Number of active port forwarding sessions.",
+		}, func() float64 {
+			return float64(portforwarding.Sessions.Count())
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: "kubenav_server",
+			Name:      "watches",
+			Help:      "Number of active watches, including the subscriptions of multiplexed connections.",
+		}, func() float64 {
+			return float64(watch.ActiveWatches())
+		}),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: "kubenav_server",
+			Name:      "portforwarding_received_bytes_total",
+			Help:      "Number of bytes received from the forwarded ports.",
+		}, func() float64 {
+			received, _ := portforwarding.BytesForwarded()
+			return float64(received)
+		}),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: "kubenav_server",
+			Name:      "portforwarding_sent_bytes_total",
+			Help:      "Number of bytes sent to the forwarded ports.",
+		}, func() float64 {
+			_, sent := portforwarding.BytesForwarded()
+			return float64(sent)
+		}),
+	)
+
+	return m, func() {
+		removeTerminalHook()
+		removePortForwardingHook()
+	}
+}
@@ -0,0 +1,68 @@
+package kustomize
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// The limits for an uploaded archive, so that a large or malicious archive can not use all the memory of the device.
+const (
+	maxArchiveFiles = 10000
+	maxArchiveSize  = 32 * 1024 * 1024
+)
+
+// RenderArchive renders the kustomization in the given root directory of a gzip compressed tar archive. Only regular
+// files and directories are extracted, all other entries (e.g. symlinks) are ignored.
+func RenderArchive(data []byte, root string, options Options) (string, error) {
+	files, err := extract(data)
+	if err != nil {
+		return "", err
+	}
+
+	return RenderFiles(files, root, options)
+}
+
+func extract(data []byte) (map[string][]byte, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid archive: %s", ErrInvalidOptions, err.Error())
+	}
+	defer gz.Close()
+
+	files := make(map[string][]byte)
+	var size int64
+
+	reader := tar.NewReader(gz)
+	for {
+		header, err := reader.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return files, nil
+			}
+			return nil, fmt.Errorf("%w: invalid archive: %s", ErrInvalidOptions, err.Error())
+		}
+
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		if len(files) >= maxArchiveFiles {
+			return nil, fmt.Errorf("%w: archive contains more than %d files", ErrInvalidOptions, maxArchiveFiles)
+		}
+
+		size += header.Size
+		if size > maxArchiveSize {
+			return nil, fmt.Errorf("%w: archive is larger than %d bytes", ErrInvalidOptions, maxArchiveSize)
+		}
+
+		content, err := io.ReadAll(io.LimitReader(reader, header.Size))
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid archive: %s", ErrInvalidOptions, err.Error())
+		}
+		files[header.Name] = content
+	}
+}
@@ -0,0 +1,277 @@
+// Package kustomize renders kustomizations in-process via the kustomize API. A kustomization can be rendered from a
+// directory on the local disk (desktop only) or from a set of uploaded files or a tar archive, which are written to an
+// in-memory filesystem. The rendered multi-document manifest can then be used like a plain manifest.
+package kustomize
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/kubenav/kubenav/pkg/server/resources"
+
+	"sigs.k8s.io/kustomize/api/konfig"
+	"sigs.k8s.io/kustomize/api/krusty"
+	"sigs.k8s.io/kustomize/api/types"
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+)
+
+// ErrInvalidOptions is returned for invalid options, e.g. an uploaded file with a path outside of the kustomization.
+var ErrInvalidOptions = fmt.Errorf("%w", resources.ErrInvalidOptions)
+
+// Options are the options to render a kustomization. Remote bases (e.g. "github.com/org/repo//deploy?ref=v1.0.0")
+// are disabled by default, because they would fetch and render arbitrary content from the network. When they are
+// allowed, kustomize clones them via the git executable, so that they are only supported for directories on disk.
+type Options struct {
+	AllowRemoteBases bool `json:"allowRemoteBases"`
+}
+
+// RenderRequest is the request to render uploaded files. The files can be send as map of paths and contents or as a
+// gzip compressed tar archive (see RenderArchive). The "root" is the directory of the kustomization, which should be
+// rendered, so that an upload can contain the bases and all overlays of an application.
+type RenderRequest struct {
+	Files   map[string]string `json:"files"`
+	Archive []byte            `json:"archive"`
+	Root    string            `json:"root"`
+	Options
+}
+
+// RenderError is returned when a kustomization can not be rendered. The error points at the kustomization file and,
+// if known, the field of the file which caused the error, e.g. "resources[2]".
+type RenderError struct {
+	File    string `json:"file"`
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+func (e *RenderError) Error() string {
+	if e.Field == "" {
+		return fmt.Sprintf("%s: %s", e.File, e.Message)
+	}
+	return fmt.Sprintf("%s: %s: %s", e.File, e.Field, e.Message)
+}
+
+// unknownFieldRegexp matches the error of the JSON decoder for an unknown field of a kustomization.
+var unknownFieldRegexp = regexp.MustCompile(`unknown field "([^"]+)"`)
+
+// Render renders the uploaded files or the archive of the given request.
+func Render(request RenderRequest) (string, error) {
+	if len(request.Archive) > 0 {
+		return RenderArchive(request.Archive, request.Root, request.Options)
+	}
+
+	files := make(map[string][]byte, len(request.Files))
+	for name, content := range request.Files {
+		files[name] = []byte(content)
+	}
+	return RenderFiles(files, request.Root, request.Options)
+}
+
+// RenderDirectory renders the kustomization in the given directory of the local disk. This is only used by the
+// desktop version, the server only renders uploaded files (see RenderFiles), so that a client can not read arbitrary
+// files of the host.
+func RenderDirectory(dir string, options Options) (string, error) {
+	if dir == "" {
+		return "", fmt.Errorf("%w: directory is required", ErrInvalidOptions)
+	}
+
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+
+	return render(filesys.MakeFsOnDisk(), dir, dir, options, true)
+}
+
+// RenderFiles renders the kustomization in the given root directory of the uploaded files. The keys of the files map
+// are the slash separated paths of the files relative to the root of the upload.
+func RenderFiles(files map[string][]byte, root string, options Options) (string, error) {
+	if len(files) == 0 {
+		return "", fmt.Errorf("%w: no files", ErrInvalidOptions)
+	}
+
+	fSys := filesys.MakeFsInMemory()
+	for name, content := range files {
+		name, err := cleanPath(name)
+		if err != nil {
+			return "", err
+		}
+		if err := fSys.WriteFile(name, content); err != nil {
+			return "", err
+		}
+	}
+
+	root, err := cleanPath(root)
+	if err != nil {
+		return "", err
+	}
+
+	return render(fSys, "/", root, options, false)
+}
+
+// cleanPath returns the absolute path in the in-memory filesystem for the given relative path. Absolute paths and
+// paths outside of the upload are rejected.
+func cleanPath(name string) (string, error) {
+	cleaned := path.Clean("/" + strings.TrimPrefix(name, "./"))
+	if strings.HasPrefix(name, "/") || strings.Contains(name, "\\") || strings.HasPrefix(path.Clean(name), "..") {
+		return "", fmt.Errorf("%w: invalid path %s", ErrInvalidOptions, name)
+	}
+	return cleaned, nil
+}
+
+// render builds the kustomization in the given directory. The paths in the errors are relative to the base directory,
+// which is the directory on disk or the root of the uploaded files. Before the kustomization is passed to kustomize,
+// all kustomization files are checked, so that errors in the files and references to remote bases can be reported
+// with the file and field which caused them. The errors of kustomize itself do not contain this information in a
+// structured way.
+func render(fSys filesys.FileSystem, base, dir string, options Options, onDisk bool) (string, error) {
+	allowRemote := options.AllowRemoteBases && onDisk
+	if err := check(fSys, base, dir, allowRemote, map[string]bool{}); err != nil {
+		return "", err
+	}
+
+	resMap, err := krusty.MakeKustomizer(krusty.MakeDefaultOptions()).Run(fSys, dir)
+	if err != nil {
+		file, _ := kustomizationFile(fSys, dir)
+		return "", &RenderError{File: relativePath(base, file), Message: err.Error()}
+	}
+
+	manifest, err := resMap.AsYaml()
+	if err != nil {
+		return "", err
+	}
+
+	return string(manifest), nil
+}
+
+// check parses the kustomization file in the given directory and checks all references to other files and
+// directories. Referenced directories are checked recursively, the visited map prevents loops.
+func check(fSys filesys.FileSystem, root, dir string, allowRemote bool, visited map[string]bool) error {
+	if visited[dir] {
+		return nil
+	}
+	visited[dir] = true
+
+	file, err := kustomizationFile(fSys, dir)
+	if err != nil {
+		return &RenderError{File: relativePath(root, dir), Message: err.Error()}
+	}
+	name := relativePath(root, file)
+
+	data, err := fSys.ReadFile(file)
+	if err != nil {
+		return &RenderError{File: name, Message: err.Error()}
+	}
+
+	var kustomization types.Kustomization
+	if err := kustomization.Unmarshal(data); err != nil {
+		return decodeError(name, err)
+	}
+
+	references := map[string][]string{
+		"bases":      kustomization.Bases,
+		"resources":  kustomization.Resources,
+		"components": kustomization.Components,
+		"crds":       kustomization.Crds,
+	}
+	for i, patch := range kustomization.Patches {
+		if patch.Path != "" {
+			references["patches["+strconv.Itoa(i)+"].path"] = []string{patch.Path}
+		}
+	}
+	for i, patch := range kustomization.PatchesJson6902 {
+		if patch.Path != "" {
+			references["patchesJson6902["+strconv.Itoa(i)+"].path"] = []string{patch.Path}
+		}
+	}
+
+	for _, field := range sortedKeys(references) {
+		for i, reference := range references[field] {
+			fieldName := field
+			if !strings.HasSuffix(field, ".path") {
+				fieldName = field + "[" + strconv.Itoa(i) + "]"
+			}
+
+			target := filepath.Join(dir, filepath.FromSlash(reference))
+			if !fSys.Exists(target) {
+				if isRemote(reference) {
+					if allowRemote {
+						continue
+					}
+					return &RenderError{File: name, Field: fieldName, Message: fmt.Sprintf("remote base %s is not allowed", reference)}
+				}
+				return &RenderError{File: name, Field: fieldName, Message: fmt.Sprintf("%s does not exist", reference)}
+			}
+
+			if fSys.IsDir(target) {
+				if err := check(fSys, root, target, allowRemote, visited); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// kustomizationFile returns the path of the kustomization file in the given directory.
+func kustomizationFile(fSys filesys.FileSystem, dir string) (string, error) {
+	for _, name := range konfig.RecognizedKustomizationFileNames() {
+		file := filepath.Join(dir, name)
+		if fSys.Exists(file) {
+			return file, nil
+		}
+	}
+	return "", fmt.Errorf("no kustomization file found, expected one of %s", strings.Join(konfig.RecognizedKustomizationFileNames(), ", "))
+}
+
+// decodeError returns the error for a kustomization file, which could not be decoded. For unknown fields and fields
+// with a wrong type the name of the field is added to the error.
+func decodeError(file string, err error) error {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		return &RenderError{File: file, Field: typeErr.Field, Message: fmt.Sprintf("expected %s, got %s", typeErr.Type.String(), typeErr.Value)}
+	}
+
+	if match := unknownFieldRegexp.FindStringSubmatch(err.Error()); match != nil {
+		return &RenderError{File: file, Field: match[1], Message: "unknown field"}
+	}
+
+	return &RenderError{File: file, Message: err.Error()}
+}
+
+// isRemote returns true, when the given reference of a kustomization looks like a remote base, e.g. an url, a git
+// repository via ssh or a repository without scheme like "github.com/org/repo".
+func isRemote(reference string) bool {
+	if strings.Contains(reference, "://") || strings.HasPrefix(reference, "git@") || strings.Contains(reference, "?ref=") {
+		return true
+	}
+
+	host, _, found := strings.Cut(reference, "/")
+	return found && strings.Contains(host, ".") && !strings.HasPrefix(host, ".")
+}
+
+// relativePath returns the slash separated path of the file relative to the root directory of the kustomization, which
+// is used in the errors.
+func relativePath(root, file string) string {
+	rel, err := filepath.Rel(root, file)
+	if err != nil {
+		return filepath.ToSlash(file)
+	}
+	return filepath.ToSlash(rel)
+}
+
+func sortedKeys(m map[string][]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,176 @@
+package server
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/kubenav/kubenav/pkg/kube"
+	"github.com/kubenav/kubenav/pkg/kube/credentials"
+	"github.com/kubenav/kubenav/pkg/server/portforwarding"
+	"github.com/kubenav/kubenav/pkg/server/terminal"
+	"github.com/kubenav/kubenav/pkg/server/watch"
+)
+
+// Status is the status of the server, which is returned by GetStatus. When the server isn't running, only the Running
+// field is set. The uptime is returned in seconds.
+type Status struct {
+	Running                bool    `json:"running"`
+	Port                   int     `json:"port,omitempty"`
+	Uptime                 float64 `json:"uptime,omitempty"`
+	TerminalSessions       int     `json:"terminalSessions"`
+	PortForwardingSessions int     `json:"portForwardingSessions"`
+	Watches                int64   `json:"watches"`
+}
+
+// The lifecycle lock serializes all calls of Start, Stop and Restart, so that they can be called from multiple threads
+// of the mobile and desktop platforms. The instance lock protects the running server and the last used options, it is
+// only held for a short time, so that GetStatus never has to wait for a running Stop.
+var (
+	lifecycleLock sync.Mutex
+
+	instanceLock    sync.Mutex
+	instance        *server
+	lastKubeClient  kube.Client
+	lastOptions     Options
+	lastOptionsUsed bool
+)
+
+// Start starts our internal http server with the given options, see start for details. If the server is already
+// running, the info of the running server is returned and the options are ignored.
+func Start(kubeClient kube.Client, options Options) (*Info, error) {
+	lifecycleLock.Lock()
+	defer lifecycleLock.Unlock()
+
+	if s := getInstance(); s != nil {
+		info := *s.startup.Load().info
+		return &info, nil
+	}
+
+	s, err := start(kubeClient, options)
+	if err != nil {
+		return nil, err
+	}
+
+	instanceLock.Lock()
+	instance = s
+	lastKubeClient = kubeClient
+	lastOptions = options
+	lastOptionsUsed = true
+	instanceLock.Unlock()
+
+	info := *s.startup.Load().info
+	return &info, nil
+}
+
+// Stop stops the server, which was started via Start, gracefully. New requests are not accepted anymore, all WebSocket
+// connections receive a close message, all port forwarding sessions are closed and all running streams are canceled.
+// Afterwards we wait until all sessions are closed or the drain timeout from the options expires. Connections which
+// are still open after the timeout are closed forcefully. If the server isn't running, Stop does nothing.
+//
+// The stored credentials of all clusters are removed, also when the server isn't running, so that they do not stay in
+// memory after the app stopped the server.
+func Stop() error {
+	lifecycleLock.Lock()
+	defer lifecycleLock.Unlock()
+
+	credentials.Clear()
+	return stop()
+}
+
+func stop() error {
+	s := getInstance()
+	if s == nil {
+		return nil
+	}
+
+	timeout := DefaultDrainTimeout
+	if s.getOptions().DrainTimeout > 0 {
+		timeout = time.Duration(s.getOptions().DrainTimeout) * time.Second
+	}
+
+	err := s.shutdown(timeout)
+	clearInstance(s)
+	return err
+}
+
+// Restart stops the running server and starts it again with the options of the last call of Start, including all
+// changes which were applied via the "/api/config" endpoint. The new server uses the same port and auth token as the
+// old server, so that the app doesn't have to reconnect with new settings. If the server was never started an error is
+// returned.
+func Restart() (*Info, error) {
+	lifecycleLock.Lock()
+	defer lifecycleLock.Unlock()
+
+	instanceLock.Lock()
+	kubeClient, options, ok := lastKubeClient, lastOptions, lastOptionsUsed
+	previous := instance
+	instanceLock.Unlock()
+
+	if !ok {
+		return nil, fmt.Errorf("server was never started")
+	}
+
+	if previous != nil {
+		options = *previous.getOptions()
+		if previousInfo := previous.startup.Load().info; previousInfo.Port != 0 {
+			port := previousInfo.Port
+			options.Port = &port
+		}
+		if previous.authToken != "" {
+			options.AuthToken = previous.authToken
+		}
+	}
+
+	if err := stop(); err != nil {
+		return nil, err
+	}
+
+	s, err := start(kubeClient, options)
+	if err != nil {
+		return nil, err
+	}
+
+	instanceLock.Lock()
+	instance = s
+	instanceLock.Unlock()
+
+	info := *s.startup.Load().info
+	return &info, nil
+}
+
+// GetStatus returns the status of the server, including the number of active sessions.
+func GetStatus() Status {
+	status := Status{
+		TerminalSessions:       terminal.Sessions.Count(),
+		PortForwardingSessions: portforwarding.Sessions.Count(),
+		Watches:                watch.ActiveWatches(),
+	}
+
+	if s := getInstance(); s != nil {
+		status.Running = true
+		startup := s.startup.Load()
+		status.Port = startup.info.Port
+		status.Uptime = time.Since(startup.startTime).Seconds()
+	}
+
+	return status
+}
+
+func getInstance() *server {
+	instanceLock.Lock()
+	defer instanceLock.Unlock()
+
+	return instance
+}
+
+// clearInstance removes the given server as running instance, e.g. when the server was stopped because a listener
+// failed.
+func clearInstance(s *server) {
+	instanceLock.Lock()
+	defer instanceLock.Unlock()
+
+	if instance == s {
+		instance = nil
+	}
+}
@@ -0,0 +1,112 @@
+package logs
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// previousSeparator is written between the logs of the previous and the current container, when the logs of the
+// previous container are included in the download.
+const previousSeparator = "\n========== end of previous container logs ==========\n\n"
+
+// progressInterval is the number of bytes after which the progress of a download is reported.
+const progressInterval = 256 * 1024
+
+// Download is a download of the complete logs of a container. The log streams are opened via NewDownload, so that
+// errors can be returned to the user before the download is started. The logs are then written via WriteTo.
+type Download struct {
+	previous io.ReadCloser
+	current  io.ReadCloser
+}
+
+// NewDownload opens the log streams for the container from the options. The logs are fetched without any limits, the
+// "tailLines" and "sinceSeconds" options are ignored. If the "previous" option is set, the logs of the previous
+// container are also included, when they are available.
+func NewDownload(ctx context.Context, clientset kubernetes.Interface, options Options) (*Download, error) {
+	d := &Download{}
+
+	if options.Previous {
+		previous, err := clientset.CoreV1().Pods(options.Namespace).GetLogs(options.Name, &corev1.PodLogOptions{
+			Container:  options.Container,
+			Timestamps: options.Timestamps,
+			Previous:   true,
+		}).Stream(ctx)
+		if err == nil {
+			d.previous = previous
+		}
+	}
+
+	current, err := clientset.CoreV1().Pods(options.Namespace).GetLogs(options.Name, &corev1.PodLogOptions{
+		Container:  options.Container,
+		Timestamps: options.Timestamps,
+	}).Stream(ctx)
+	if err != nil {
+		d.Close()
+		return nil, err
+	}
+	d.current = current
+
+	return d, nil
+}
+
+// WriteTo writes the gzip compressed logs to the given writer. The logs are streamed, so that the memory usage stays
+// flat, also for very large logs. The progress function is called with the number of (uncompressed) bytes, which were
+// downloaded so far, after every 256KB and when the download is finished. The progress function can be nil.
+func (d *Download) WriteTo(w io.Writer, progress func(bytes int64)) error {
+	defer d.Close()
+
+	gz := gzip.NewWriter(w)
+	pw := &progressWriter{w: gz, progress: progress}
+
+	if d.previous != nil {
+		if _, err := io.Copy(pw, d.previous); err != nil {
+			return err
+		}
+		if _, err := fmt.Fprint(pw, previousSeparator); err != nil {
+			return err
+		}
+	}
+
+	if _, err := io.Copy(pw, d.current); err != nil {
+		return err
+	}
+
+	if progress != nil {
+		progress(pw.bytes)
+	}
+
+	return gz.Close()
+}
+
+// Close closes all log streams of the download.
+func (d *Download) Close() {
+	if d.previous != nil {
+		d.previous.Close()
+	}
+	if d.current != nil {
+		d.current.Close()
+	}
+}
+
+// progressWriter counts the bytes written to the underlying writer and reports them to the progress function.
+type progressWriter struct {
+	w        io.Writer
+	progress func(bytes int64)
+	bytes    int64
+	reported int64
+}
+
+func (p *progressWriter) Write(data []byte) (int, error) {
+	n, err := p.w.Write(data)
+	p.bytes += int64(n)
+	if p.progress != nil && p.bytes-p.reported >= progressInterval {
+		p.reported = p.bytes
+		p.progress(p.bytes)
+	}
+	return n, err
+}
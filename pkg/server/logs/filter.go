@@ -0,0 +1,143 @@
+package logs
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// statsInterval is the interval in which the number of matched lines is reported, when the logs are filtered.
+const statsInterval = 10 * time.Second
+
+// Stats contains the number of log lines which matched the filter and the total number of processed lines.
+type Stats struct {
+	Matched int64 `json:"matched"`
+	Total   int64 `json:"total"`
+}
+
+// lineFilter filters the log lines before they are passed to the send function. The state for the context lines is
+// tracked per Pod and container, so that the filter can also be used when multiple containers are streamed.
+type lineFilter struct {
+	match        func(line string) bool
+	invert       bool
+	contextLines int
+	sendFn       func(Message) error
+
+	lock    sync.Mutex
+	states  map[string]*filterState
+	matched int64
+	total   int64
+}
+
+// filterState contains the last lines before a match (which are send as context, when the next line matches) and the
+// number of lines after a match, which must still be send as context.
+type filterState struct {
+	before []Message
+	after  int
+}
+
+// newLineFilter returns a new filter for the "Filter", "FilterRegex", "FilterInvert" and "ContextLines" options. If no
+// filter is set nil is returned. If the filter is an invalid regular expression an error is returned.
+func newLineFilter(options Options, send func(Message) error) (*lineFilter, error) {
+	if options.Filter == "" {
+		return nil, nil
+	}
+
+	match := func(line string) bool {
+		return strings.Contains(line, options.Filter)
+	}
+
+	if options.FilterRegex {
+		reg, err := regexp.Compile(options.Filter)
+		if err != nil {
+			return nil, fmt.Errorf("invalid filter: %s", err.Error())
+		}
+		match = reg.MatchString
+	}
+
+	return &lineFilter{
+		match:        match,
+		invert:       options.FilterInvert,
+		contextLines: options.ContextLines,
+		sendFn:       send,
+		states:       make(map[string]*filterState),
+	}, nil
+}
+
+// send passes all matching log lines and the configured context lines to the send function. All other messages (e.g.
+// errors) are always passed to the send function.
+func (f *lineFilter) send(msg Message) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if msg.Op != OpLog {
+		return f.sendFn(msg)
+	}
+
+	f.total++
+
+	key := msg.Pod + "/" + msg.Container
+	state, ok := f.states[key]
+	if !ok {
+		state = &filterState{}
+		f.states[key] = state
+	}
+
+	if f.match(msg.Data) != f.invert {
+		f.matched++
+
+		for _, before := range state.before {
+			if err := f.sendFn(before); err != nil {
+				return err
+			}
+		}
+		state.before = state.before[:0]
+		state.after = f.contextLines
+
+		return f.sendFn(msg)
+	}
+
+	if state.after > 0 {
+		state.after--
+		msg.Context = true
+		return f.sendFn(msg)
+	}
+
+	if f.contextLines > 0 {
+		msg.Context = true
+		state.before = append(state.before, msg)
+		if len(state.before) > f.contextLines {
+			state.before = state.before[1:]
+		}
+	}
+
+	return nil
+}
+
+// report sends the number of matched lines in the stats interval, until the context is canceled.
+func (f *lineFilter) report(ctx context.Context) {
+	ticker := time.NewTicker(statsInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := f.sendStats(); err != nil {
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// sendStats sends a "stats" message with the current number of matched and total lines.
+func (f *lineFilter) sendStats() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return f.sendFn(Message{Op: OpStats, Stats: &Stats{Matched: f.matched, Total: f.total}})
+}
@@ -0,0 +1,139 @@
+package logs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// jsonFieldAliases are the names of the well known fields "level", "msg" and "ts", which are used by the common
+// logging libraries. The first field found in a log line is returned under the well known name.
+var jsonFieldAliases = map[string][]string{
+	"level": {"level", "lvl", "severity", "log.level"},
+	"msg":   {"msg", "message"},
+	"ts":    {"ts", "time", "timestamp", "@timestamp"},
+}
+
+// jsonParser parses log lines as JSON and adds the selected fields to the messages. Lines which are not valid JSON are
+// send as raw text. When field filters are set, only the lines where all fields have the expected values are send.
+type jsonParser struct {
+	fields       []string
+	fieldFilters map[string]string
+	sendFn       func(Message) error
+
+	lock sync.Mutex
+	// lastMatched contains, per Pod and container, if the last JSON line matched the field filters. It is used for
+	// lines which can not be parsed (e.g. stack traces), which belong to the last JSON line.
+	lastMatched map[string]bool
+}
+
+// newJSONParser returns a new parser for the "ParseJSON", "Fields" and "FieldFilters" options. If the JSON parsing is
+// not enabled nil is returned.
+func newJSONParser(options Options, send func(Message) error) *jsonParser {
+	if !options.ParseJSON {
+		return nil
+	}
+
+	return &jsonParser{
+		fields:       options.Fields,
+		fieldFilters: options.FieldFilters,
+		sendFn:       send,
+		lastMatched:  make(map[string]bool),
+	}
+}
+
+// send parses the log line of the message and passes the message to the send function. The order of the messages is
+// never changed, lines which can not be parsed are send when the last JSON line of the same container was send.
+func (p *jsonParser) send(msg Message) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if msg.Op != OpLog {
+		return p.sendFn(msg)
+	}
+
+	key := msg.Pod + "/" + msg.Container
+
+	parsed, ok := parseJSONLine(msg.Data)
+	if !ok {
+		if len(p.fieldFilters) > 0 {
+			if matched, ok := p.lastMatched[key]; ok && !matched {
+				return nil
+			}
+		}
+		return p.sendFn(msg)
+	}
+
+	if !p.matches(parsed) {
+		p.lastMatched[key] = false
+		return nil
+	}
+	p.lastMatched[key] = true
+
+	msg.Fields = make(map[string]interface{})
+	for name := range jsonFieldAliases {
+		if value, ok := getJSONField(parsed, name); ok {
+			msg.Fields[name] = value
+		}
+	}
+	for _, name := range p.fields {
+		if value, ok := getJSONField(parsed, name); ok {
+			msg.Fields[name] = value
+		}
+	}
+
+	return p.sendFn(msg)
+}
+
+// matches returns true, when all field filters are matching the parsed log line. The values are compared case
+// insensitive, so that "level=error" also matches lines with the level "ERROR".
+func (p *jsonParser) matches(parsed map[string]interface{}) bool {
+	for name, expected := range p.fieldFilters {
+		value, ok := getJSONField(parsed, name)
+		if !ok || !strings.EqualFold(fmt.Sprintf("%v", value), expected) {
+			return false
+		}
+	}
+	return true
+}
+
+// parseJSONLine parses a log line as JSON object. If the line is not a JSON object false is returned. A timestamp
+// prefix, which is added by the Kubernetes API when the timestamps are requested, is ignored.
+func parseJSONLine(line string) (map[string]interface{}, bool) {
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(line, "{") {
+		if _, rest, found := strings.Cut(line, " "); found && strings.HasPrefix(strings.TrimSpace(rest), "{") {
+			line = strings.TrimSpace(rest)
+		} else {
+			return nil, false
+		}
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader([]byte(line)))
+	decoder.UseNumber()
+
+	var parsed map[string]interface{}
+	if err := decoder.Decode(&parsed); err != nil {
+		return nil, false
+	}
+
+	return parsed, true
+}
+
+// getJSONField returns the value of a field from a parsed log line. For the well known fields "level", "msg" and "ts"
+// all aliases are checked.
+func getJSONField(parsed map[string]interface{}, name string) (interface{}, bool) {
+	names := []string{name}
+	if aliases, ok := jsonFieldAliases[name]; ok {
+		names = aliases
+	}
+
+	for _, n := range names {
+		if value, ok := parsed[n]; ok {
+			return value, true
+		}
+	}
+	return nil, false
+}
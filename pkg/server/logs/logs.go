@@ -0,0 +1,624 @@
+// Package logs implements the streaming of container logs. The logs are read line by line from the Kubernetes API and
+// each line is passed as Message to a send function, so that the logs can be streamed to the frontend, e.g. via a
+// WebSocket connection.
+package logs
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kubenav/kubenav/pkg/server/watch"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Message is the messaging protocol for the log streams.
+//
+// OP      FIELD(S) USED             DESCRIPTION
+// ---------------------------------------------------------------------
+// log     Pod, Container, Data      A single log line
+// closed  Pod, Container, Data      The log stream was closed, Data contains the reason ("stream closed: <reason>")
+// status  Pod, Container, Data      The connection was lost ("reconnecting") or is restored ("resynced")
+// error   Pod, Container, Data      An error occurred, Data contains the error message
+// stats   Stats                     The number of matched and total lines, when the logs are filtered
+//
+// When the logs of multiple containers are streamed, each "log" message also contains the Timestamp of the log line
+// (RFC3339 with nanoseconds), so that the frontend can interleave the lines of the different containers correctly.
+// When the logs are filtered, lines which are only send as context of a matching line are marked via Context. When the
+// JSON parsing is enabled, the parsed fields of a log line are added as Fields (see newJSONParser).
+type Message struct {
+	Op        string                 `json:"op"`
+	Pod       string                 `json:"pod,omitempty"`
+	Container string                 `json:"container,omitempty"`
+	Timestamp string                 `json:"timestamp,omitempty"`
+	Context   bool                   `json:"context,omitempty"`
+	Stats     *Stats                 `json:"stats,omitempty"`
+	Fields    map[string]interface{} `json:"fields,omitempty"`
+	Data      string                 `json:"data"`
+}
+
+// AllContainers can be used as container name to stream the logs of all containers of a Pod.
+const AllContainers = "all"
+
+// The operations of a Message.
+const (
+	OpLog    = "log"
+	OpClosed = "closed"
+	OpStatus = "status"
+	OpError  = "error"
+	OpStats  = "stats"
+)
+
+// Options are the options for a log stream. The options can be created from the query parameters of a request via
+// OptionsFromQuery.
+//
+// When a LabelSelector is set, the logs of all Pods matching the selector are streamed (see StreamSelector). The
+// MaxStreams and MaxBytesPerSecond options are used to limit the number of concurrent streams and the bandwidth in
+// this case. They are not set via the query parameters, because they are part of the server configuration.
+//
+// The Filter, FilterRegex, FilterInvert and ContextLines options are used to filter the log lines before they are send
+// (see newLineFilter). The ParseJSON, Fields and FieldFilters options are used to parse JSON log lines and to filter
+// them by the values of the parsed fields (see newJSONParser).
+type Options struct {
+	Namespace         string
+	Name              string
+	Container         string
+	LabelSelector     string
+	Follow            bool
+	TailLines         *int64
+	SinceSeconds      *int64
+	Timestamps        bool
+	Previous          bool
+	MaxStreams        int
+	MaxBytesPerSecond int64
+	Filter            string
+	FilterRegex       bool
+	FilterInvert      bool
+	ContextLines      int
+	ParseJSON         bool
+	Fields            []string
+	FieldFilters      map[string]string
+}
+
+// OptionsFromQuery returns the options for a log stream from the given query parameters. The Pod is specified via the
+// "name", "namespace" and "container" parameters. The "container" parameter can also be "all" or a comma separated
+// list of containers, to stream the logs of multiple containers. The "follow" parameter is true by default, the
+// "tailLines", "sinceSeconds", "timestamps" and "previous" parameters are passed to the Kubernetes API.
+//
+// Instead of the "name" parameter the "labelSelector" parameter can be used, to stream the logs of all Pods in the
+// namespace matching the selector.
+//
+// The lines can be filtered via the "filter" parameter, which is a substring or a RE2 regular expression when the
+// "regex" parameter is true. The "invert" parameter inverts the filter and the "contextLines" parameter defines the
+// number of lines before and after a match which are also send. An invalid regular expression returns an error.
+//
+// When the "json" parameter is true, each line is parsed as JSON. The "fields" parameter is a comma separated list of
+// additional fields, which should be returned. The "fieldFilter" parameter can be used multiple times to filter the
+// lines by the value of a parsed field, e.g. "fieldFilter=level=error".
+func OptionsFromQuery(query url.Values) (Options, error) {
+	options := Options{
+		Namespace:     query.Get("namespace"),
+		Name:          query.Get("name"),
+		Container:     query.Get("container"),
+		LabelSelector: query.Get("labelSelector"),
+		Follow:        query.Get("follow") != "false",
+		Timestamps:    query.Get("timestamps") == "true",
+		Previous:      query.Get("previous") == "true",
+		Filter:        query.Get("filter"),
+		FilterRegex:   query.Get("regex") == "true",
+		FilterInvert:  query.Get("invert") == "true",
+		ParseJSON:     query.Get("json") == "true",
+	}
+
+	if fields := query.Get("fields"); fields != "" {
+		for _, field := range strings.Split(fields, ",") {
+			if field = strings.TrimSpace(field); field != "" {
+				options.Fields = append(options.Fields, field)
+			}
+		}
+	}
+
+	for _, fieldFilter := range query["fieldFilter"] {
+		field, value, found := strings.Cut(fieldFilter, "=")
+		if !found || field == "" {
+			return options, fmt.Errorf("invalid fieldFilter parameter: %s", fieldFilter)
+		}
+
+		if options.FieldFilters == nil {
+			options.FieldFilters = make(map[string]string)
+		}
+		options.FieldFilters[field] = value
+	}
+
+	if options.Namespace == "" || (options.Name == "" && options.LabelSelector == "") {
+		return options, fmt.Errorf("namespace and name or labelSelector are required")
+	}
+
+	if tailLines := query.Get("tailLines"); tailLines != "" {
+		parsedTailLines, err := strconv.ParseInt(tailLines, 10, 64)
+		if err != nil {
+			return options, fmt.Errorf("invalid tailLines parameter: %s", err.Error())
+		}
+		options.TailLines = &parsedTailLines
+	}
+
+	if sinceSeconds := query.Get("sinceSeconds"); sinceSeconds != "" {
+		parsedSinceSeconds, err := strconv.ParseInt(sinceSeconds, 10, 64)
+		if err != nil {
+			return options, fmt.Errorf("invalid sinceSeconds parameter: %s", err.Error())
+		}
+		options.SinceSeconds = &parsedSinceSeconds
+	}
+
+	if contextLines := query.Get("contextLines"); contextLines != "" {
+		parsedContextLines, err := strconv.Atoi(contextLines)
+		if err != nil || parsedContextLines < 0 {
+			return options, fmt.Errorf("invalid contextLines parameter: %s", contextLines)
+		}
+		options.ContextLines = parsedContextLines
+	}
+
+	if _, err := newLineFilter(options, nil); err != nil {
+		return options, err
+	}
+
+	return options, nil
+}
+
+// Stream opens the log stream for the container specified in the options and passes each log line to the send
+// function. The function returns, when the stream ends, the context is canceled or the send function returns an
+// error. When the stream ends, because the container was terminated or restarted, a final "closed" message with the
+// reason is send, so that the frontend can offer the user to follow the logs again.
+//
+// If the container is "all" or a comma separated list of containers, the logs of all these containers are streamed
+// concurrently (see StreamContainers). If a label selector is set, the logs of all matching Pods are streamed (see
+// StreamSelector).
+//
+// If a filter is set, only the matching lines are send. The number of matched lines is reported periodically via a
+// "stats" message, so that the user knows that the stream is still alive, even when no line matches.
+//
+// If the JSON parsing is enabled, the lines are parsed and filtered by their fields, before the filter is applied.
+func Stream(ctx context.Context, clientset kubernetes.Interface, options Options, send func(Message) error) error {
+	filter, err := newLineFilter(options, send)
+	if err != nil {
+		return err
+	}
+	if filter != nil {
+		send = filter.send
+	}
+
+	if parser := newJSONParser(options, send); parser != nil {
+		send = parser.send
+	}
+
+	if filter == nil {
+		return stream(ctx, clientset, options, send)
+	}
+
+	reportCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go filter.report(reportCtx)
+
+	if err := stream(ctx, clientset, options, send); err != nil {
+		return err
+	}
+
+	return filter.sendStats()
+}
+
+// stream selects the streaming mode for the options.
+func stream(ctx context.Context, clientset kubernetes.Interface, options Options, send func(Message) error) error {
+	if options.LabelSelector != "" {
+		return StreamSelector(ctx, clientset, options, send)
+	}
+
+	if options.Container == AllContainers || strings.Contains(options.Container, ",") {
+		containers, err := getContainers(ctx, clientset, options)
+		if err != nil {
+			return err
+		}
+
+		return StreamContainers(ctx, clientset, options, containers, send)
+	}
+
+	return streamContainer(ctx, clientset, options, nil, false, send)
+}
+
+// StreamContainers streams the logs of multiple containers of a Pod concurrently. The lines of all containers are
+// multiplexed into the send function, each line is tagged with the container name and the timestamp of the line. The
+// send function is never called concurrently.
+//
+// To start the merged logs from a consistent point in time, the "sinceSeconds" option is converted to a shared
+// "sinceTime" for all containers. If an error occurs while opening the stream for one container (e.g. because the
+// container isn't started yet), the error is send as "error" message, without stopping the other streams.
+func StreamContainers(ctx context.Context, clientset kubernetes.Interface, options Options, containers []string, send func(Message) error) error {
+	var sinceTime *metav1.Time
+	if options.SinceSeconds != nil {
+		t := metav1.NewTime(time.Now().Add(-time.Duration(*options.SinceSeconds) * time.Second))
+		sinceTime = &t
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	sender := newSyncSender(ctx, send, cancel, options.MaxBytesPerSecond)
+
+	var wg sync.WaitGroup
+	for _, container := range containers {
+		wg.Add(1)
+		go func(container string) {
+			defer wg.Done()
+
+			containerOptions := options
+			containerOptions.Container = container
+
+			if err := streamContainer(ctx, clientset, containerOptions, sinceTime, true, sender.send); err != nil && ctx.Err() == nil {
+				sender.send(Message{Op: OpError, Pod: options.Name, Container: container, Data: err.Error()})
+			}
+		}(container)
+	}
+
+	wg.Wait()
+
+	return sender.err()
+}
+
+// syncSender wraps a send function, so that it can be used by multiple goroutines. The first error returned by the
+// send function is stored and returned for all following calls, and the context of the streams is canceled. If a
+// bandwidth limit is set, the sender blocks, when more bytes are send within one second.
+type syncSender struct {
+	ctx     context.Context
+	lock    sync.Mutex
+	sendFn  func(Message) error
+	cancel  context.CancelFunc
+	sendErr error
+
+	bytesPerSecond int64
+	window         time.Time
+	windowBytes    int64
+}
+
+func newSyncSender(ctx context.Context, send func(Message) error, cancel context.CancelFunc, bytesPerSecond int64) *syncSender {
+	return &syncSender{
+		ctx:            ctx,
+		sendFn:         send,
+		cancel:         cancel,
+		bytesPerSecond: bytesPerSecond,
+	}
+}
+
+func (s *syncSender) send(msg Message) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.sendErr != nil {
+		return s.sendErr
+	}
+
+	if err := s.throttle(int64(len(msg.Data))); err != nil {
+		return err
+	}
+
+	if err := s.sendFn(msg); err != nil {
+		s.sendErr = err
+		s.cancel()
+		return err
+	}
+	return nil
+}
+
+// throttle blocks until the given number of bytes can be send without exceeding the bandwidth limit. The limit is
+// applied per one second window, a single message which is larger than the limit is always send in a new window.
+func (s *syncSender) throttle(n int64) error {
+	if s.bytesPerSecond <= 0 {
+		return nil
+	}
+
+	now := time.Now()
+	if now.Sub(s.window) >= time.Second {
+		s.window = now
+		s.windowBytes = 0
+	}
+
+	if s.windowBytes > 0 && s.windowBytes+n > s.bytesPerSecond {
+		timer := time.NewTimer(time.Second - now.Sub(s.window))
+		defer timer.Stop()
+
+		select {
+		case <-timer.C:
+		case <-s.ctx.Done():
+			return s.ctx.Err()
+		}
+
+		s.window = time.Now()
+		s.windowBytes = 0
+	}
+
+	s.windowBytes += n
+	return nil
+}
+
+func (s *syncSender) err() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.sendErr
+}
+
+// streamContainer streams the logs of a single container. If the sinceTime is set it is used instead of the
+// "sinceSeconds" option. If tagTimestamps is true, the timestamps of the log lines are requested from the Kubernetes
+// API and added to each message. If the user didn't request the timestamps, they are removed from the log line.
+//
+// When the logs are followed and the connection to the Kubernetes API is lost, the stream is reconnected via a
+// watch.Reconnector and resumed after the timestamp of the last line, so that no line is send twice. In this case the
+// timestamps are always requested from the Kubernetes API. While the stream reconnects a "status" message with
+// "reconnecting" is send and after the stream was resumed a "status" message with "resynced".
+func streamContainer(ctx context.Context, clientset kubernetes.Interface, options Options, sinceTime *metav1.Time, tagTimestamps bool, send func(Message) error) error {
+	restartCount := getRestartCount(ctx, clientset, options)
+	reconnector := watch.NewReconnector(0)
+
+	requestTimestamps := options.Timestamps || tagTimestamps || options.Follow
+	connected := false
+	var last logPosition
+
+	// The errors of the send function are recorded, so that they can be distinguished from the errors of the stream.
+	var sendErr error
+	trackedSend := func(msg Message) error {
+		if err := send(msg); err != nil {
+			sendErr = err
+			return err
+		}
+		return nil
+	}
+
+	for {
+		logOptions := &corev1.PodLogOptions{
+			Container:    options.Container,
+			Follow:       options.Follow,
+			TailLines:    options.TailLines,
+			SinceSeconds: options.SinceSeconds,
+			Timestamps:   requestTimestamps,
+			Previous:     options.Previous,
+		}
+		if sinceTime != nil {
+			logOptions.SinceSeconds = nil
+			logOptions.SinceTime = sinceTime
+		}
+		if !last.timestamp.IsZero() {
+			logOptions.TailLines = nil
+			logOptions.SinceSeconds = nil
+			logOptions.SinceTime = &metav1.Time{Time: last.timestamp}
+		}
+
+		stream, err := clientset.CoreV1().Pods(options.Namespace).GetLogs(options.Name, logOptions).Stream(ctx)
+		if err != nil {
+			// Errors while opening the stream are only retried, when the stream was already connected before. Otherwise
+			// the container is not started yet or the user isn't allowed to get the logs.
+			if !connected || ctx.Err() != nil || !watch.IsTemporary(err) {
+				if connected && ctx.Err() == nil {
+					return send(Message{Op: OpClosed, Pod: options.Name, Container: options.Container, Data: fmt.Sprintf("stream closed: %s", err.Error())})
+				}
+				return err
+			}
+		} else {
+			connected = true
+			if reconnector.Connected() {
+				if err := send(Message{Op: OpStatus, Pod: options.Name, Container: options.Container, Data: watch.StatusResynced}); err != nil {
+					stream.Close()
+					return err
+				}
+			}
+
+			previous := last
+			last, err = readContainer(stream, options, requestTimestamps, tagTimestamps, last, trackedSend)
+			stream.Close()
+			if !last.timestamp.Equal(previous.timestamp) || last.count != previous.count {
+				reconnector.ResetBackoff()
+			}
+			if sendErr != nil {
+				return sendErr
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			if err == io.EOF {
+				reason := "end of logs"
+				if options.Follow {
+					reason = getClosedReason(ctx, clientset, options, restartCount)
+				}
+				return send(Message{Op: OpClosed, Pod: options.Name, Container: options.Container, Data: fmt.Sprintf("stream closed: %s", reason)})
+			}
+
+			if !options.Follow {
+				return send(Message{Op: OpClosed, Pod: options.Name, Container: options.Container, Data: fmt.Sprintf("stream closed: %s", err.Error())})
+			}
+		}
+
+		if !reconnector.Reconnecting() {
+			if err := send(Message{Op: OpStatus, Pod: options.Name, Container: options.Container, Data: watch.StatusReconnecting}); err != nil {
+				return err
+			}
+		}
+
+		if err := reconnector.Wait(ctx, err); err != nil {
+			return err
+		}
+	}
+}
+
+// logPosition is the position of the last line of a container, which was send to the client. Because all lines of a
+// single write (e.g. a stack trace) have the same timestamp, the number of lines which were send with the timestamp is
+// also stored, so that the lines can be skipped exactly when the stream is resumed.
+type logPosition struct {
+	timestamp time.Time
+	count     int
+}
+
+// readContainer reads the log lines from the stream and passes them to the send function. When the stream was resumed
+// after the given position, the Kubernetes API returns the lines again, which were already send: The lines before the
+// timestamp of the position and the first "count" lines with the timestamp are skipped. All following lines are send,
+// also when they have the same timestamp. The function returns the position of the last line and the error, which
+// ended the stream.
+func readContainer(stream io.Reader, options Options, requestTimestamps, tagTimestamps bool, position logPosition, send func(Message) error) (logPosition, error) {
+	replaying := !position.timestamp.IsZero()
+	skip := position.count
+
+	reader := bufio.NewReader(stream)
+	for {
+		line, err := reader.ReadString('\n')
+		if line != "" {
+			msg := Message{Op: OpLog, Pod: options.Name, Container: options.Container, Data: strings.TrimSuffix(line, "\n")}
+
+			if requestTimestamps {
+				var timestamp string
+				timestamp, msg.Data = splitTimestamp(msg.Data, options.Timestamps)
+				if tagTimestamps {
+					msg.Timestamp = timestamp
+				}
+
+				if parsed, err := time.Parse(time.RFC3339Nano, timestamp); err == nil {
+					if replaying {
+						if parsed.Before(position.timestamp) {
+							continue
+						}
+						if parsed.Equal(position.timestamp) && skip > 0 {
+							skip--
+							continue
+						}
+						replaying = false
+					}
+
+					if parsed.Equal(position.timestamp) {
+						position.count++
+					} else {
+						position = logPosition{timestamp: parsed, count: 1}
+					}
+				}
+			}
+
+			if err := send(msg); err != nil {
+				return position, err
+			}
+		}
+
+		if err != nil {
+			return position, err
+		}
+	}
+}
+
+// splitTimestamp splits a log line with a timestamp prefix (as returned by the Kubernetes API when timestamps are
+// requested) into the timestamp and the log line. If keepTimestamp is true, the returned log line still contains the
+// timestamp.
+func splitTimestamp(line string, keepTimestamp bool) (string, string) {
+	timestamp, rest, found := strings.Cut(line, " ")
+	if !found {
+		if _, err := time.Parse(time.RFC3339Nano, line); err == nil {
+			timestamp, rest = line, ""
+		} else {
+			return "", line
+		}
+	}
+
+	if keepTimestamp {
+		return timestamp, line
+	}
+	return timestamp, rest
+}
+
+// getContainers returns the containers for the container option. If the option is "all" all containers (including the
+// init and ephemeral containers) of the Pod are returned, otherwise the option is split into a list of containers.
+func getContainers(ctx context.Context, clientset kubernetes.Interface, options Options) ([]string, error) {
+	if options.Container != AllContainers {
+		var containers []string
+		for _, container := range strings.Split(options.Container, ",") {
+			if container = strings.TrimSpace(container); container != "" {
+				containers = append(containers, container)
+			}
+		}
+		return containers, nil
+	}
+
+	pod, err := clientset.CoreV1().Pods(options.Namespace).Get(ctx, options.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	var containers []string
+	for _, c := range pod.Spec.InitContainers {
+		containers = append(containers, c.Name)
+	}
+	for _, c := range pod.Spec.Containers {
+		containers = append(containers, c.Name)
+	}
+	for _, c := range pod.Spec.EphemeralContainers {
+		containers = append(containers, c.Name)
+	}
+
+	return containers, nil
+}
+
+// getRestartCount returns the restart count of the container, which is used to detect a restart of the container,
+// when the log stream ends. If the restart count can not be determined -1 is returned.
+func getRestartCount(ctx context.Context, clientset kubernetes.Interface, options Options) int32 {
+	status := getContainerStatus(ctx, clientset, options)
+	if status == nil {
+		return -1
+	}
+
+	return status.RestartCount
+}
+
+// getClosedReason returns the reason why the log stream of a container was closed by the Kubernetes API. The reason is
+// determined by the current state and restart count of the container.
+func getClosedReason(ctx context.Context, clientset kubernetes.Interface, options Options, restartCount int32) string {
+	status := getContainerStatus(ctx, clientset, options)
+	if status == nil {
+		return "container not found"
+	}
+
+	if status.State.Terminated != nil {
+		return fmt.Sprintf("container terminated (%s, exit code %d)", status.State.Terminated.Reason, status.State.Terminated.ExitCode)
+	}
+
+	if restartCount >= 0 && status.RestartCount > restartCount {
+		return fmt.Sprintf("container restarted (restart count %d)", status.RestartCount)
+	}
+
+	if status.State.Waiting != nil {
+		return fmt.Sprintf("container waiting (%s)", status.State.Waiting.Reason)
+	}
+
+	return "end of stream"
+}
+
+// getContainerStatus returns the status of the container from the options. If the container name is empty, the status
+// of the first container is returned, which is also used by the Kubernetes API in this case.
+func getContainerStatus(ctx context.Context, clientset kubernetes.Interface, options Options) *corev1.ContainerStatus {
+	pod, err := clientset.CoreV1().Pods(options.Namespace).Get(ctx, options.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil
+	}
+
+	statuses := append([]corev1.ContainerStatus{}, pod.Status.ContainerStatuses...)
+	statuses = append(statuses, pod.Status.InitContainerStatuses...)
+	statuses = append(statuses, pod.Status.EphemeralContainerStatuses...)
+
+	for i := range statuses {
+		if statuses[i].Name == options.Container || (options.Container == "" && i == 0) {
+			return &statuses[i]
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,80 @@
+package logs
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadContainer(t *testing.T) {
+	t1 := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	t2 := t1.Add(time.Second)
+	ts1, ts2 := t1.Format(time.RFC3339Nano), t2.Format(time.RFC3339Nano)
+
+	for _, tc := range []struct {
+		name          string
+		stream        string
+		position      logPosition
+		expected      []string
+		expectedCount int
+		expectedTime  time.Time
+	}{
+		{
+			name:          "multi-line write on first connection",
+			stream:        ts1 + " panic: boom\n" + ts1 + " goroutine 1\n" + ts1 + " main.go:10\n" + ts2 + " next\n",
+			expected:      []string{"panic: boom", "goroutine 1", "main.go:10", "next"},
+			expectedCount: 1,
+			expectedTime:  t2,
+		},
+		{
+			name:          "multi-line write at the end",
+			stream:        ts1 + " panic: boom\n" + ts1 + " goroutine 1\n",
+			expected:      []string{"panic: boom", "goroutine 1"},
+			expectedCount: 2,
+			expectedTime:  t1,
+		},
+		{
+			name:          "resume skips only the lines which were already send",
+			stream:        ts1 + " panic: boom\n" + ts1 + " goroutine 1\n" + ts1 + " main.go:10\n" + ts2 + " next\n",
+			position:      logPosition{timestamp: t1, count: 2},
+			expected:      []string{"main.go:10", "next"},
+			expectedCount: 1,
+			expectedTime:  t2,
+		},
+		{
+			name:          "resume skips older lines",
+			stream:        ts1 + " old\n" + ts2 + " sent\n" + ts2 + " new\n",
+			position:      logPosition{timestamp: t2, count: 1},
+			expected:      []string{"new"},
+			expectedCount: 2,
+			expectedTime:  t2,
+		},
+		{
+			name:          "lines with the same timestamp after the replay are send",
+			stream:        ts1 + " sent\n" + ts2 + " new\n" + ts2 + " new again\n",
+			position:      logPosition{timestamp: t1, count: 1},
+			expected:      []string{"new", "new again"},
+			expectedCount: 2,
+			expectedTime:  t2,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var lines []string
+			position, err := readContainer(strings.NewReader(tc.stream), Options{Name: "pod", Container: "container"}, true, false, tc.position, func(msg Message) error {
+				lines = append(lines, msg.Data)
+				return nil
+			})
+			if err != io.EOF {
+				t.Fatalf("expected EOF, got %v", err)
+			}
+
+			if strings.Join(lines, "|") != strings.Join(tc.expected, "|") {
+				t.Errorf("expected lines %q, got %q", tc.expected, lines)
+			}
+			if !position.timestamp.Equal(tc.expectedTime) || position.count != tc.expectedCount {
+				t.Errorf("expected position %s/%d, got %s/%d", tc.expectedTime, tc.expectedCount, position.timestamp, position.count)
+			}
+		})
+	}
+}
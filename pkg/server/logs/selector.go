@@ -0,0 +1,228 @@
+package logs
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// DefaultMaxStreams is the default maximum number of concurrent log streams for a label selector.
+	DefaultMaxStreams = 25
+	// DefaultMaxBytesPerSecond is the default maximum bandwidth for the log streams of a label selector.
+	DefaultMaxBytesPerSecond = 512 * 1024
+)
+
+// StreamSelector streams the logs of all Pods in a namespace, which are matching the label selector from the options.
+// Each message is tagged with the name of the Pod and container. The streamed containers can be restricted via the
+// "container" option. The Pods are watched, so that new Pods (e.g. during the rollout of a Deployment) are attached
+// automatically. When a Pod is deleted, a final "closed" message is send for all containers of the Pod.
+//
+// The number of concurrent streams is limited by the "MaxStreams" option and the bandwidth by the "MaxBytesPerSecond"
+// option. If a container can not be attached, because the limit is reached, an "error" message is send for it.
+func StreamSelector(ctx context.Context, clientset kubernetes.Interface, options Options, send func(Message) error) error {
+	if options.MaxStreams <= 0 {
+		options.MaxStreams = DefaultMaxStreams
+	}
+	if options.MaxBytesPerSecond <= 0 {
+		options.MaxBytesPerSecond = DefaultMaxBytesPerSecond
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+
+	t := &tailer{
+		ctx:       ctx,
+		clientset: clientset,
+		options:   options,
+		sender:    newSyncSender(ctx, send, cancel, options.MaxBytesPerSecond),
+		streams:   make(map[string]*tailStream),
+		rejected:  make(map[string]bool),
+	}
+
+	defer func() {
+		cancel()
+		t.wg.Wait()
+	}()
+
+	pods, err := clientset.CoreV1().Pods(options.Namespace).List(ctx, metav1.ListOptions{LabelSelector: options.LabelSelector})
+	if err != nil {
+		return err
+	}
+
+	for i := range pods.Items {
+		t.attach(&pods.Items[i], true)
+	}
+
+	if !options.Follow {
+		t.wg.Wait()
+		return t.sender.err()
+	}
+
+	// The watch is restarted from the last known resource version, when it is closed by the Kubernetes API, e.g.
+	// because of a timeout.
+	resourceVersion := pods.ResourceVersion
+	for {
+		watcher, err := clientset.CoreV1().Pods(options.Namespace).Watch(ctx, metav1.ListOptions{
+			LabelSelector:   options.LabelSelector,
+			ResourceVersion: resourceVersion,
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				return t.sender.err()
+			}
+			return err
+		}
+
+		for event := range watcher.ResultChan() {
+			switch event.Type {
+			case watch.Added, watch.Modified:
+				if pod, ok := event.Object.(*corev1.Pod); ok {
+					resourceVersion = pod.ResourceVersion
+					t.attach(pod, false)
+				}
+			case watch.Deleted:
+				if pod, ok := event.Object.(*corev1.Pod); ok {
+					resourceVersion = pod.ResourceVersion
+					t.detach(pod)
+				}
+			case watch.Error:
+				watcher.Stop()
+				return apierrors.FromObject(event.Object)
+			}
+		}
+
+		if ctx.Err() != nil {
+			return t.sender.err()
+		}
+	}
+}
+
+// tailer manages the log streams for all containers of the Pods matching a label selector. The streams are identified
+// by the namespace, Pod and container name.
+type tailer struct {
+	ctx       context.Context
+	clientset kubernetes.Interface
+	options   Options
+	sender    *syncSender
+
+	wg       sync.WaitGroup
+	lock     sync.Mutex
+	streams  map[string]*tailStream
+	rejected map[string]bool
+}
+
+type tailStream struct {
+	cancel context.CancelFunc
+}
+
+// attach opens a log stream for each running container of the Pod, which isn't already attached. Only for the Pods of
+// the initial list the "tailLines" and "sinceSeconds" options are used, for all Pods which are attached later (or for
+// restarted containers) we stream the complete logs of the container.
+func (t *tailer) attach(pod *corev1.Pod, initial bool) {
+	if pod.DeletionTimestamp != nil {
+		return
+	}
+
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.State.Running == nil || !t.matchesContainer(status.Name) {
+			continue
+		}
+
+		key := fmt.Sprintf("%s/%s/%s", pod.Namespace, pod.Name, status.Name)
+
+		t.lock.Lock()
+		if _, ok := t.streams[key]; ok {
+			t.lock.Unlock()
+			continue
+		}
+
+		if len(t.streams) >= t.options.MaxStreams {
+			rejected := t.rejected[key]
+			t.rejected[key] = true
+			t.lock.Unlock()
+
+			if !rejected {
+				t.sender.send(Message{Op: OpError, Pod: pod.Name, Container: status.Name, Data: fmt.Sprintf("stream limit of %d reached, logs are not streamed", t.options.MaxStreams)})
+			}
+			continue
+		}
+
+		ctx, cancel := context.WithCancel(t.ctx)
+		stream := &tailStream{cancel: cancel}
+		t.streams[key] = stream
+		delete(t.rejected, key)
+		t.lock.Unlock()
+
+		containerOptions := t.options
+		containerOptions.Name = pod.Name
+		containerOptions.Container = status.Name
+		containerOptions.Previous = false
+		if !initial {
+			containerOptions.TailLines = nil
+			containerOptions.SinceSeconds = nil
+		}
+
+		t.wg.Add(1)
+		go func(key string) {
+			defer t.wg.Done()
+			defer cancel()
+
+			if err := streamContainer(ctx, t.clientset, containerOptions, nil, true, t.sender.send); err != nil && ctx.Err() == nil {
+				t.sender.send(Message{Op: OpError, Pod: containerOptions.Name, Container: containerOptions.Container, Data: err.Error()})
+			}
+
+			t.lock.Lock()
+			if t.streams[key] == stream {
+				delete(t.streams, key)
+			}
+			t.lock.Unlock()
+		}(key)
+	}
+}
+
+// matchesContainer returns true if the logs of the container should be streamed. If the container option is empty or
+// "all" the logs of all containers are streamed, otherwise only of the containers from the comma separated list.
+func (t *tailer) matchesContainer(container string) bool {
+	if t.options.Container == "" || t.options.Container == AllContainers {
+		return true
+	}
+
+	for _, c := range strings.Split(t.options.Container, ",") {
+		if strings.TrimSpace(c) == container {
+			return true
+		}
+	}
+	return false
+}
+
+// detach closes all log streams of the given Pod and sends a final "closed" message for each of them.
+func (t *tailer) detach(pod *corev1.Pod) {
+	prefix := fmt.Sprintf("%s/%s/", pod.Namespace, pod.Name)
+
+	t.lock.Lock()
+	var containers []string
+	for key, stream := range t.streams {
+		if strings.HasPrefix(key, prefix) {
+			stream.cancel()
+			delete(t.streams, key)
+			containers = append(containers, strings.TrimPrefix(key, prefix))
+		}
+	}
+	for key := range t.rejected {
+		if strings.HasPrefix(key, prefix) {
+			delete(t.rejected, key)
+		}
+	}
+	t.lock.Unlock()
+
+	for _, container := range containers {
+		t.sender.send(Message{Op: OpClosed, Pod: pod.Name, Container: container, Data: "stream closed: pod deleted"})
+	}
+}
@@ -0,0 +1,134 @@
+// Package metrics implements the access to the metrics API ("metrics.k8s.io") of a Kubernetes cluster. Since the
+// metrics API only returns the current usage of a Pod or Node, the package also contains a sampler, which keeps the
+// last samples in memory, so that the usage can be displayed as chart.
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Usage is the CPU (in millicores) and memory (in bytes) usage of a container, Pod or Node.
+type Usage struct {
+	CPU    int64 `json:"cpu"`
+	Memory int64 `json:"memory"`
+}
+
+// PodMetrics are the metrics of a single Pod, as returned by the metrics API. The usage of the Pod is the sum of the
+// usage of all containers.
+type PodMetrics struct {
+	Namespace  string           `json:"namespace"`
+	Name       string           `json:"name"`
+	Timestamp  metav1.Time      `json:"timestamp"`
+	Containers map[string]Usage `json:"containers"`
+	Usage      Usage            `json:"usage"`
+}
+
+// NodeMetrics are the metrics of a single Node, as returned by the metrics API.
+type NodeMetrics struct {
+	Name      string      `json:"name"`
+	Timestamp metav1.Time `json:"timestamp"`
+	Usage     Usage       `json:"usage"`
+}
+
+type resourceUsage map[string]resource.Quantity
+
+func (r resourceUsage) toUsage() Usage {
+	cpu := r["cpu"]
+	memory := r["memory"]
+
+	return Usage{
+		CPU:    cpu.MilliValue(),
+		Memory: memory.Value(),
+	}
+}
+
+// ListPodMetrics returns the metrics of all Pods in the given namespace. If the namespace is empty the metrics of all
+// Pods in the cluster are returned. The label selector is optional.
+func ListPodMetrics(ctx context.Context, clientset kubernetes.Interface, namespace, labelSelector string) ([]PodMetrics, error) {
+	path := "/apis/metrics.k8s.io/v1beta1/pods"
+	if namespace != "" {
+		path = fmt.Sprintf("/apis/metrics.k8s.io/v1beta1/namespaces/%s/pods", url.PathEscape(namespace))
+	}
+
+	request := clientset.CoreV1().RESTClient().Get().AbsPath(path)
+	if labelSelector != "" {
+		request = request.Param("labelSelector", labelSelector)
+	}
+
+	data, err := request.DoRaw(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var list struct {
+		Items []struct {
+			Metadata   metav1.ObjectMeta `json:"metadata"`
+			Timestamp  metav1.Time       `json:"timestamp"`
+			Containers []struct {
+				Name  string        `json:"name"`
+				Usage resourceUsage `json:"usage"`
+			} `json:"containers"`
+		} `json:"items"`
+	}
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, err
+	}
+
+	podMetrics := make([]PodMetrics, 0, len(list.Items))
+	for _, item := range list.Items {
+		pod := PodMetrics{
+			Namespace:  item.Metadata.Namespace,
+			Name:       item.Metadata.Name,
+			Timestamp:  item.Timestamp,
+			Containers: make(map[string]Usage),
+		}
+
+		for _, container := range item.Containers {
+			usage := container.Usage.toUsage()
+			pod.Containers[container.Name] = usage
+			pod.Usage.CPU += usage.CPU
+			pod.Usage.Memory += usage.Memory
+		}
+
+		podMetrics = append(podMetrics, pod)
+	}
+
+	return podMetrics, nil
+}
+
+// ListNodeMetrics returns the metrics of all Nodes in the cluster.
+func ListNodeMetrics(ctx context.Context, clientset kubernetes.Interface) ([]NodeMetrics, error) {
+	data, err := clientset.CoreV1().RESTClient().Get().AbsPath("/apis/metrics.k8s.io/v1beta1/nodes").DoRaw(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var list struct {
+		Items []struct {
+			Metadata  metav1.ObjectMeta `json:"metadata"`
+			Timestamp metav1.Time       `json:"timestamp"`
+			Usage     resourceUsage     `json:"usage"`
+		} `json:"items"`
+	}
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, err
+	}
+
+	nodeMetrics := make([]NodeMetrics, 0, len(list.Items))
+	for _, item := range list.Items {
+		nodeMetrics = append(nodeMetrics, NodeMetrics{
+			Name:      item.Metadata.Name,
+			Timestamp: item.Timestamp,
+			Usage:     item.Usage.toUsage(),
+		})
+	}
+
+	return nodeMetrics, nil
+}
@@ -0,0 +1,236 @@
+package metrics
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/kubernetes"
+)
+
+// The page size for the Node summaries. When no limit is set, "DefaultNodeLimit" Nodes are returned per page, larger
+// limits are capped to "MaxNodeLimit", so that a single request doesn't list the Pods of thousands of Nodes.
+const (
+	DefaultNodeLimit = 50
+	MaxNodeLimit     = 500
+)
+
+// nodePodsConcurrency is the maximum number of Nodes, for which the Pods are listed concurrently.
+const nodePodsConcurrency = 10
+
+// NodeSummaries is a single page of Node summaries. If there are more Nodes, "Continue" contains the token, which must
+// be passed to the next request. When the Pods of a Node could not be listed, the error is added to "Errors", using
+// the name of the Node as key, and the requests and Pod count of the Node are not set.
+type NodeSummaries struct {
+	Nodes    []NodeInfo        `json:"nodes"`
+	Continue string            `json:"continue,omitempty"`
+	Errors   map[string]string `json:"errors,omitempty"`
+}
+
+// NodeInfo contains everything which is needed to find out why Pods can not be scheduled on a Node: the readiness and
+// the conditions, the taints, the versions of the Node components and the allocatable resources compared to the sum of
+// the requests of all Pods running on the Node.
+type NodeInfo struct {
+	Name          string          `json:"name"`
+	Ready         bool            `json:"ready"`
+	Unschedulable bool            `json:"unschedulable,omitempty"`
+	Conditions    []NodeCondition `json:"conditions,omitempty"`
+	Taints        []corev1.Taint  `json:"taints,omitempty"`
+	Versions      NodeVersions    `json:"versions"`
+	Allocatable   Usage           `json:"allocatable"`
+	Requests      *Usage          `json:"requests,omitempty"`
+	Utilization   NodeUtilization `json:"utilization"`
+	Pods          *int64          `json:"pods,omitempty"`
+	PodCapacity   int64           `json:"podCapacity"`
+}
+
+// NodeCondition is a single condition of a Node, e.g. "Ready" or "MemoryPressure".
+type NodeCondition struct {
+	Type               corev1.NodeConditionType `json:"type"`
+	Status             corev1.ConditionStatus   `json:"status"`
+	Reason             string                   `json:"reason,omitempty"`
+	Message            string                   `json:"message,omitempty"`
+	LastHeartbeatTime  metav1.Time              `json:"lastHeartbeatTime,omitempty"`
+	LastTransitionTime metav1.Time              `json:"lastTransitionTime,omitempty"`
+}
+
+// NodeVersions are the versions of the kubelet, the kernel and the container runtime of a Node.
+type NodeVersions struct {
+	Kubelet          string `json:"kubelet,omitempty"`
+	Kernel           string `json:"kernel,omitempty"`
+	ContainerRuntime string `json:"containerRuntime,omitempty"`
+	OSImage          string `json:"osImage,omitempty"`
+}
+
+// NodeUtilization is the sum of the requests in percent of the allocatable resources and the number of Pods in percent
+// of the Pod capacity of a Node.
+type NodeUtilization struct {
+	CPURequests    *float64 `json:"cpuRequests,omitempty"`
+	MemoryRequests *float64 `json:"memoryRequests,omitempty"`
+	Pods           *float64 `json:"pods,omitempty"`
+}
+
+// GetNodeSummaries returns a page of Node summaries. The requests and the number of Pods are computed by listing the
+// non terminated Pods of each Node via a field selector, which is done concurrently for at most "nodePodsConcurrency"
+// Nodes. The limit is capped to "MaxNodeLimit", the continue token is the one returned by a previous call.
+func GetNodeSummaries(ctx context.Context, clientset kubernetes.Interface, limit int64, continueToken string) (*NodeSummaries, error) {
+	if limit <= 0 {
+		limit = DefaultNodeLimit
+	}
+	if limit > MaxNodeLimit {
+		limit = MaxNodeLimit
+	}
+
+	nodes, err := clientset.CoreV1().Nodes().List(ctx, metav1.ListOptions{Limit: limit, Continue: continueToken})
+	if err != nil {
+		return nil, err
+	}
+
+	summaries := &NodeSummaries{
+		Nodes:    make([]NodeInfo, len(nodes.Items)),
+		Continue: nodes.Continue,
+		Errors:   make(map[string]string),
+	}
+
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	semaphore := make(chan struct{}, nodePodsConcurrency)
+
+	for i, node := range nodes.Items {
+		summaries.Nodes[i] = convertNode(node)
+
+		wg.Add(1)
+		go func(nodeInfo *NodeInfo) {
+			defer wg.Done()
+
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+
+			requests, pods, err := getNodeRequests(ctx, clientset, nodeInfo.Name)
+			if err != nil {
+				lock.Lock()
+				summaries.Errors[nodeInfo.Name] = err.Error()
+				lock.Unlock()
+				return
+			}
+
+			nodeInfo.Requests = &requests
+			nodeInfo.Pods = &pods
+			nodeInfo.Utilization = NodeUtilization{
+				CPURequests:    percent(requests.CPU, nodeInfo.Allocatable.CPU),
+				MemoryRequests: percent(requests.Memory, nodeInfo.Allocatable.Memory),
+				Pods:           percent(pods, nodeInfo.PodCapacity),
+			}
+		}(&summaries.Nodes[i])
+	}
+
+	wg.Wait()
+
+	if len(summaries.Errors) == 0 {
+		summaries.Errors = nil
+	}
+
+	return summaries, nil
+}
+
+// convertNode converts a Node to the format of the Node summary. The requests and the number of Pods are set by the
+// caller, because they require the Pods of the Node.
+func convertNode(node corev1.Node) NodeInfo {
+	nodeInfo := NodeInfo{
+		Name:          node.Name,
+		Unschedulable: node.Spec.Unschedulable,
+		Taints:        node.Spec.Taints,
+		Versions: NodeVersions{
+			Kubelet:          node.Status.NodeInfo.KubeletVersion,
+			Kernel:           node.Status.NodeInfo.KernelVersion,
+			ContainerRuntime: node.Status.NodeInfo.ContainerRuntimeVersion,
+			OSImage:          node.Status.NodeInfo.OSImage,
+		},
+		Allocatable: resourceListToUsage(node.Status.Allocatable),
+		PodCapacity: node.Status.Allocatable.Pods().Value(),
+	}
+
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == corev1.NodeReady && condition.Status == corev1.ConditionTrue {
+			nodeInfo.Ready = true
+		}
+
+		nodeInfo.Conditions = append(nodeInfo.Conditions, NodeCondition{
+			Type:               condition.Type,
+			Status:             condition.Status,
+			Reason:             condition.Reason,
+			Message:            condition.Message,
+			LastHeartbeatTime:  condition.LastHeartbeatTime,
+			LastTransitionTime: condition.LastTransitionTime,
+		})
+	}
+
+	sort.Slice(nodeInfo.Conditions, func(i, j int) bool {
+		return nodeInfo.Conditions[i].Type < nodeInfo.Conditions[j].Type
+	})
+
+	return nodeInfo
+}
+
+// getNodeRequests returns the sum of the requests and the number of the Pods, which are scheduled on the Node and
+// which are not terminated. Succeeded and failed Pods are ignored, because they do not consume any resources anymore.
+func getNodeRequests(ctx context.Context, clientset kubernetes.Interface, node string) (Usage, int64, error) {
+	fieldSelector := fields.AndSelectors(
+		fields.OneTermEqualSelector("spec.nodeName", node),
+		fields.OneTermNotEqualSelector("status.phase", string(corev1.PodSucceeded)),
+		fields.OneTermNotEqualSelector("status.phase", string(corev1.PodFailed)),
+	).String()
+
+	var requests Usage
+	var pods int64
+
+	listOptions := metav1.ListOptions{FieldSelector: fieldSelector, Limit: podListLimit}
+	for {
+		list, err := clientset.CoreV1().Pods("").List(ctx, listOptions)
+		if err != nil {
+			return Usage{}, 0, err
+		}
+
+		for _, pod := range list.Items {
+			podRequests := getPodRequests(pod)
+			requests.CPU += podRequests.CPU
+			requests.Memory += podRequests.Memory
+			pods++
+		}
+
+		if list.Continue == "" {
+			return requests, pods, nil
+		}
+		listOptions.Continue = list.Continue
+	}
+}
+
+// getPodRequests returns the effective requests of a Pod, like they are used by the scheduler: the sum of the requests
+// of all containers or the largest request of an init container, when it is larger, plus the overhead of the Pod.
+func getPodRequests(pod corev1.Pod) Usage {
+	var requests Usage
+	for _, container := range pod.Spec.Containers {
+		containerRequests := resourceListToUsage(container.Resources.Requests)
+		requests.CPU += containerRequests.CPU
+		requests.Memory += containerRequests.Memory
+	}
+
+	for _, container := range pod.Spec.InitContainers {
+		containerRequests := resourceListToUsage(container.Resources.Requests)
+		if containerRequests.CPU > requests.CPU {
+			requests.CPU = containerRequests.CPU
+		}
+		if containerRequests.Memory > requests.Memory {
+			requests.Memory = containerRequests.Memory
+		}
+	}
+
+	overhead := resourceListToUsage(pod.Spec.Overhead)
+	requests.CPU += overhead.CPU
+	requests.Memory += overhead.Memory
+
+	return requests
+}
@@ -0,0 +1,287 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// The sort orders for the PVC overview. By default the claims are sorted by the utilization of the volume in
+// descending order, claims without usage are sorted by their namespace and name after all other claims.
+const (
+	PVCSortUsage = "usage"
+	PVCSortName  = "name"
+)
+
+// maxPVCEvents is the maximum number of events, which are returned for a pending claim.
+const maxPVCEvents = 5
+
+// defaultStorageClassAnnotation is the annotation, which marks a StorageClass as the default class of the cluster.
+const defaultStorageClassAnnotation = "storageclass.kubernetes.io/is-default-class"
+
+// PVCOverview is the list of all PersistentVolumeClaims with their volume, storage class and usage. "UsageAvailable"
+// is false, when the usage could not be fetched from the kubelets (e.g. because of a missing permission for the
+// "nodes/proxy" resource), the reason is returned in "UsageError". Errors for the PersistentVolumes and StorageClasses
+// are added to "Errors", using the resource as key, all other fields are still returned.
+type PVCOverview struct {
+	PersistentVolumeClaims []PVCInfo         `json:"persistentVolumeClaims"`
+	UsageAvailable         bool              `json:"usageAvailable"`
+	UsageError             string            `json:"usageError,omitempty"`
+	Errors                 map[string]string `json:"errors,omitempty"`
+}
+
+// PVCInfo is a single PersistentVolumeClaim joined with its PersistentVolume, StorageClass and the usage of the
+// volume, which is reported by the kubelet of the Node where the claim is mounted. The usage is only set, when the
+// claim is mounted by a running Pod. For pending claims the latest events are returned, which usually contain the
+// reason why the claim can not be bound (e.g. a provisioning failure).
+type PVCInfo struct {
+	Namespace    string                              `json:"namespace"`
+	Name         string                              `json:"name"`
+	Phase        corev1.PersistentVolumeClaimPhase   `json:"phase"`
+	AccessModes  []corev1.PersistentVolumeAccessMode `json:"accessModes,omitempty"`
+	Requested    string                              `json:"requested,omitempty"`
+	Capacity     string                              `json:"capacity,omitempty"`
+	Volume       *PVInfo                             `json:"volume,omitempty"`
+	StorageClass *StorageClassInfo                   `json:"storageClass,omitempty"`
+	Pod          string                              `json:"pod,omitempty"`
+	Usage        *FilesystemUsage                    `json:"usage,omitempty"`
+	Events       []PVCEvent                          `json:"events,omitempty"`
+}
+
+// PVInfo is the PersistentVolume, which is bound to a claim. The driver is the CSI driver of the volume, if the volume
+// isn't a CSI volume it is empty.
+type PVInfo struct {
+	Name          string                               `json:"name"`
+	Phase         corev1.PersistentVolumePhase         `json:"phase,omitempty"`
+	Capacity      string                               `json:"capacity,omitempty"`
+	ReclaimPolicy corev1.PersistentVolumeReclaimPolicy `json:"reclaimPolicy,omitempty"`
+	Driver        string                               `json:"driver,omitempty"`
+}
+
+// StorageClassInfo is the StorageClass of a claim. If the StorageClass doesn't exist (anymore), only the name is set.
+type StorageClassInfo struct {
+	Name                 string                                `json:"name"`
+	Provisioner          string                                `json:"provisioner,omitempty"`
+	ReclaimPolicy        *corev1.PersistentVolumeReclaimPolicy `json:"reclaimPolicy,omitempty"`
+	VolumeBindingMode    *storagev1.VolumeBindingMode          `json:"volumeBindingMode,omitempty"`
+	AllowVolumeExpansion bool                                  `json:"allowVolumeExpansion"`
+	Default              bool                                  `json:"default"`
+}
+
+// PVCEvent is an event of a pending claim.
+type PVCEvent struct {
+	Type          string      `json:"type"`
+	Reason        string      `json:"reason"`
+	Message       string      `json:"message"`
+	Count         int32       `json:"count"`
+	LastTimestamp metav1.Time `json:"lastTimestamp"`
+}
+
+// GetPVCOverview returns the overview for all PersistentVolumeClaims of the given namespace. If the namespace is
+// empty, the claims of all namespaces are returned. The usage of the claims is taken from the storage summary of all
+// Nodes (see GetSummary), so that it requires the same permissions.
+func GetPVCOverview(ctx context.Context, clientset kubernetes.Interface, namespace, sortBy string) (*PVCOverview, error) {
+	if sortBy == "" {
+		sortBy = PVCSortUsage
+	}
+	if sortBy != PVCSortUsage && sortBy != PVCSortName {
+		return nil, fmt.Errorf("invalid sort order %s", sortBy)
+	}
+
+	pvcs, err := clientset.CoreV1().PersistentVolumeClaims(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	overview := &PVCOverview{PersistentVolumeClaims: []PVCInfo{}, Errors: make(map[string]string)}
+
+	volumes := make(map[string]*corev1.PersistentVolume)
+	if pvs, err := clientset.CoreV1().PersistentVolumes().List(ctx, metav1.ListOptions{}); err != nil {
+		overview.Errors["persistentvolumes"] = err.Error()
+	} else {
+		for i := range pvs.Items {
+			volumes[pvs.Items[i].Name] = &pvs.Items[i]
+		}
+	}
+
+	storageClasses := make(map[string]*storagev1.StorageClass)
+	defaultStorageClass := ""
+	if scs, err := clientset.StorageV1().StorageClasses().List(ctx, metav1.ListOptions{}); err != nil {
+		overview.Errors["storageclasses"] = err.Error()
+	} else {
+		for i := range scs.Items {
+			storageClasses[scs.Items[i].Name] = &scs.Items[i]
+			if scs.Items[i].Annotations[defaultStorageClassAnnotation] == "true" {
+				defaultStorageClass = scs.Items[i].Name
+			}
+		}
+	}
+
+	usages := make(map[string]PVCUsage)
+	if summary, err := GetSummary(ctx, clientset, "", namespace); err != nil {
+		overview.UsageError = err.Error()
+	} else {
+		overview.UsageAvailable = true
+		for _, node := range summary.Nodes {
+			for _, usage := range node.PersistentVolumeClaims {
+				usages[usage.Namespace+"/"+usage.Name] = usage
+			}
+		}
+	}
+
+	events := getPendingEvents(ctx, clientset, namespace, pvcs.Items, overview.Errors)
+
+	for _, pvc := range pvcs.Items {
+		info := PVCInfo{
+			Namespace:   pvc.Namespace,
+			Name:        pvc.Name,
+			Phase:       pvc.Status.Phase,
+			AccessModes: pvc.Spec.AccessModes,
+			Events:      events[pvc.UID],
+		}
+
+		if requested, ok := pvc.Spec.Resources.Requests[corev1.ResourceStorage]; ok {
+			info.Requested = requested.String()
+		}
+		if capacity, ok := pvc.Status.Capacity[corev1.ResourceStorage]; ok {
+			info.Capacity = capacity.String()
+		}
+
+		if pvc.Spec.VolumeName != "" {
+			info.Volume = &PVInfo{Name: pvc.Spec.VolumeName}
+			if pv, ok := volumes[pvc.Spec.VolumeName]; ok {
+				info.Volume.Phase = pv.Status.Phase
+				info.Volume.ReclaimPolicy = pv.Spec.PersistentVolumeReclaimPolicy
+				if capacity, ok := pv.Spec.Capacity[corev1.ResourceStorage]; ok {
+					info.Volume.Capacity = capacity.String()
+				}
+				if pv.Spec.CSI != nil {
+					info.Volume.Driver = pv.Spec.CSI.Driver
+				}
+			}
+		}
+
+		// A claim without a storage class uses the default storage class of the cluster, which is set by the admission
+		// controller. The field is only empty, when there was no default class at the time the claim was created.
+		storageClassName := ""
+		if pvc.Spec.StorageClassName != nil {
+			storageClassName = *pvc.Spec.StorageClassName
+		} else if pvc.Status.Phase == corev1.ClaimPending {
+			storageClassName = defaultStorageClass
+		}
+		if storageClassName != "" {
+			info.StorageClass = &StorageClassInfo{Name: storageClassName}
+			if sc, ok := storageClasses[storageClassName]; ok {
+				info.StorageClass.Provisioner = sc.Provisioner
+				info.StorageClass.ReclaimPolicy = sc.ReclaimPolicy
+				info.StorageClass.VolumeBindingMode = sc.VolumeBindingMode
+				info.StorageClass.AllowVolumeExpansion = sc.AllowVolumeExpansion != nil && *sc.AllowVolumeExpansion
+				info.StorageClass.Default = sc.Name == defaultStorageClass
+			}
+		}
+
+		if usage, ok := usages[pvc.Namespace+"/"+pvc.Name]; ok {
+			info.Pod = usage.Pod
+			filesystemUsage := usage.FilesystemUsage
+			info.Usage = &filesystemUsage
+		}
+
+		overview.PersistentVolumeClaims = append(overview.PersistentVolumeClaims, info)
+	}
+
+	sortPVCs(overview.PersistentVolumeClaims, sortBy)
+
+	if len(overview.Errors) == 0 {
+		overview.Errors = nil
+	}
+
+	return overview, nil
+}
+
+// getPendingEvents returns the latest events of all pending claims, using the UID of a claim as key. The events are
+// listed once for all claims, if they can not be listed the error is added to the given errors.
+func getPendingEvents(ctx context.Context, clientset kubernetes.Interface, namespace string, pvcs []corev1.PersistentVolumeClaim, errs map[string]string) map[types.UID][]PVCEvent {
+	pending := make(map[types.UID]bool)
+	for _, pvc := range pvcs {
+		if pvc.Status.Phase == corev1.ClaimPending {
+			pending[pvc.UID] = true
+		}
+	}
+
+	if len(pending) == 0 {
+		return nil
+	}
+
+	list, err := clientset.CoreV1().Events(namespace).List(ctx, metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("involvedObject.kind", "PersistentVolumeClaim").String(),
+	})
+	if err != nil {
+		errs["events"] = err.Error()
+		return nil
+	}
+
+	events := make(map[types.UID][]PVCEvent)
+	for _, event := range list.Items {
+		if !pending[event.InvolvedObject.UID] {
+			continue
+		}
+
+		lastTimestamp := event.LastTimestamp
+		if lastTimestamp.IsZero() {
+			lastTimestamp = metav1.NewTime(event.EventTime.Time)
+		}
+
+		events[event.InvolvedObject.UID] = append(events[event.InvolvedObject.UID], PVCEvent{
+			Type:          event.Type,
+			Reason:        event.Reason,
+			Message:       event.Message,
+			Count:         event.Count,
+			LastTimestamp: lastTimestamp,
+		})
+	}
+
+	for uid := range events {
+		sort.Slice(events[uid], func(i, j int) bool {
+			return events[uid][i].LastTimestamp.After(events[uid][j].LastTimestamp.Time)
+		})
+		if len(events[uid]) > maxPVCEvents {
+			events[uid] = events[uid][:maxPVCEvents]
+		}
+	}
+
+	return events
+}
+
+// sortPVCs sorts the claims by the given sort order. Claims with the same utilization are sorted by their namespace
+// and name.
+func sortPVCs(pvcs []PVCInfo, sortBy string) {
+	sort.SliceStable(pvcs, func(i, j int) bool {
+		if sortBy == PVCSortUsage {
+			ui, uj := utilization(pvcs[i]), utilization(pvcs[j])
+			if ui != uj {
+				return ui > uj
+			}
+		}
+
+		if pvcs[i].Namespace != pvcs[j].Namespace {
+			return pvcs[i].Namespace < pvcs[j].Namespace
+		}
+		return pvcs[i].Name < pvcs[j].Name
+	})
+}
+
+// utilization returns the utilization of the claim or -1, when the usage of the claim is unknown, so that these claims
+// are sorted after all other claims.
+func utilization(pvc PVCInfo) float64 {
+	if pvc.Usage == nil || pvc.Usage.Utilization == nil {
+		return -1
+	}
+	return *pvc.Usage.Utilization
+}
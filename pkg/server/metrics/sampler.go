@@ -0,0 +1,314 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// DefaultInterval is the default interval in which the metrics API is polled.
+	DefaultInterval = 10 * time.Second
+	// DefaultSize is the default number of samples, which are kept per target. With the default interval the
+	// samples are covering the last 15 minutes.
+	DefaultSize = 90
+	// DefaultTTL is the default duration after which a target is removed, when it wasn't queried.
+	DefaultTTL = 5 * time.Minute
+)
+
+// The kinds of a Target.
+const (
+	TargetPod  = "pod"
+	TargetNode = "node"
+)
+
+// Target is a Pod or Node for which the metrics are sampled.
+type Target struct {
+	Kind      string
+	Namespace string
+	Name      string
+}
+
+// ParseTarget parses a target in the format "pod/<namespace>/<name>" or "node/<name>".
+func ParseTarget(value string) (Target, error) {
+	parts := strings.Split(value, "/")
+
+	if len(parts) == 3 && parts[0] == TargetPod && parts[1] != "" && parts[2] != "" {
+		return Target{Kind: TargetPod, Namespace: parts[1], Name: parts[2]}, nil
+	}
+	if len(parts) == 2 && parts[0] == TargetNode && parts[1] != "" {
+		return Target{Kind: TargetNode, Name: parts[1]}, nil
+	}
+
+	return Target{}, fmt.Errorf("invalid target %s", value)
+}
+
+// String returns the target in the format accepted by ParseTarget.
+func (t Target) String() string {
+	if t.Kind == TargetPod {
+		return fmt.Sprintf("%s/%s/%s", t.Kind, t.Namespace, t.Name)
+	}
+	return fmt.Sprintf("%s/%s", t.Kind, t.Name)
+}
+
+// Sample is the usage of a target at the given time (unix timestamp in seconds).
+type Sample struct {
+	Timestamp int64 `json:"timestamp"`
+	Usage
+}
+
+// Sampler polls the metrics API for all registered targets and keeps the last samples of each target in a ring buffer
+// in memory. A target is registered, when it is queried the first time and removed, when it wasn't queried within
+// the TTL. This way the sampler only polls the metrics, which are displayed in the app.
+//
+// A single sample needs 24 bytes, so that with the default size of 90 samples a tracked Pod or Node needs around 2KB
+// of memory, plus around 200 bytes for the bookkeeping of the target.
+type Sampler struct {
+	interval time.Duration
+	size     int
+	ttl      time.Duration
+
+	lock     sync.Mutex
+	clusters map[string]*cluster
+
+	done chan struct{}
+	once sync.Once
+}
+
+// cluster contains the client and the targets of a single cluster. The client is reused for all polls, so that the
+// rate limiter of the client (QPS and burst settings of the cluster) is respected.
+type cluster struct {
+	clientset kubernetes.Interface
+	targets   map[Target]*series
+}
+
+// series is the ring buffer for the samples of a single target.
+type series struct {
+	samples     []Sample
+	next        int
+	lastQueried time.Time
+}
+
+// NewSampler returns a new sampler. If the interval, size or ttl is 0 the default value is used. The sampler must be
+// started via Start.
+func NewSampler(interval time.Duration, size int, ttl time.Duration) *Sampler {
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	if size <= 0 {
+		size = DefaultSize
+	}
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+
+	return &Sampler{
+		interval: interval,
+		size:     size,
+		ttl:      ttl,
+		clusters: make(map[string]*cluster),
+		done:     make(chan struct{}),
+	}
+}
+
+// Interval returns the interval in which the metrics are polled.
+func (s *Sampler) Interval() time.Duration {
+	return s.interval
+}
+
+// Start starts polling the metrics API in the background, until the sampler is stopped.
+func (s *Sampler) Start() {
+	go s.run()
+}
+
+// Stop stops polling the metrics API.
+func (s *Sampler) Stop() {
+	s.once.Do(func() {
+		close(s.done)
+	})
+}
+
+// Query returns the samples for the given targets. Targets which are not known yet, are registered, so that they are
+// polled from now on. The cluster is identified by the given key, the clientset is used for all following polls of
+// the cluster.
+func (s *Sampler) Query(clusterKey string, clientset kubernetes.Interface, targets []Target) map[string][]Sample {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	c, ok := s.clusters[clusterKey]
+	if !ok {
+		c = &cluster{targets: make(map[Target]*series)}
+		s.clusters[clusterKey] = c
+	}
+	c.clientset = clientset
+
+	now := time.Now()
+	result := make(map[string][]Sample)
+
+	for _, target := range targets {
+		ts, ok := c.targets[target]
+		if !ok {
+			ts = &series{samples: make([]Sample, 0, s.size)}
+			c.targets[target] = ts
+		}
+		ts.lastQueried = now
+
+		result[target.String()] = ts.ordered()
+	}
+
+	return result
+}
+
+func (s *Sampler) run() {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.expire()
+			s.poll()
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// expire removes all targets which were not queried within the TTL and all clusters without targets.
+func (s *Sampler) expire() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	now := time.Now()
+	for key, c := range s.clusters {
+		for target, ts := range c.targets {
+			if now.Sub(ts.lastQueried) > s.ttl {
+				delete(c.targets, target)
+			}
+		}
+
+		if len(c.targets) == 0 {
+			delete(s.clusters, key)
+		}
+	}
+}
+
+// poll polls the metrics of all clusters concurrently. For each cluster we make one request per namespace with a
+// tracked Pod and one request for all Nodes, instead of one request per target.
+func (s *Sampler) poll() {
+	type clusterPoll struct {
+		key        string
+		clientset  kubernetes.Interface
+		namespaces map[string]bool
+		nodes      bool
+	}
+
+	var polls []clusterPoll
+
+	s.lock.Lock()
+	for key, c := range s.clusters {
+		p := clusterPoll{key: key, clientset: c.clientset, namespaces: make(map[string]bool)}
+		for target := range c.targets {
+			if target.Kind == TargetPod {
+				p.namespaces[target.Namespace] = true
+			} else {
+				p.nodes = true
+			}
+		}
+		polls = append(polls, p)
+	}
+	s.lock.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.interval)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for _, p := range polls {
+		wg.Add(1)
+		go func(p clusterPoll) {
+			defer wg.Done()
+
+			usages := make(map[Target]Sample)
+
+			for namespace := range p.namespaces {
+				podMetrics, err := ListPodMetrics(ctx, p.clientset, namespace, "")
+				if err != nil {
+					log.Printf("Could not get Pod metrics: %s", err.Error())
+					continue
+				}
+
+				for _, m := range podMetrics {
+					usages[Target{Kind: TargetPod, Namespace: m.Namespace, Name: m.Name}] = Sample{Timestamp: m.Timestamp.Unix(), Usage: m.Usage}
+				}
+			}
+
+			if p.nodes {
+				nodeMetrics, err := ListNodeMetrics(ctx, p.clientset)
+				if err != nil {
+					log.Printf("Could not get Node metrics: %s", err.Error())
+				}
+
+				for _, m := range nodeMetrics {
+					usages[Target{Kind: TargetNode, Name: m.Name}] = Sample{Timestamp: m.Timestamp.Unix(), Usage: m.Usage}
+				}
+			}
+
+			s.record(p.key, usages)
+		}(p)
+	}
+
+	wg.Wait()
+}
+
+// record adds the polled samples to the series of the targets of a cluster.
+func (s *Sampler) record(clusterKey string, usages map[Target]Sample) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	c, ok := s.clusters[clusterKey]
+	if !ok {
+		return
+	}
+
+	for target, ts := range c.targets {
+		if sample, ok := usages[target]; ok {
+			ts.add(sample, s.size)
+		}
+	}
+}
+
+// add adds a sample to the ring buffer. The sample is skipped, when the metrics API returned the same sample as in the
+// last poll, because the metrics server only scrapes the metrics in its own resolution.
+func (ts *series) add(sample Sample, size int) {
+	if len(ts.samples) > 0 {
+		last := ts.samples[(ts.next-1+len(ts.samples))%len(ts.samples)]
+		if last.Timestamp == sample.Timestamp {
+			return
+		}
+	}
+
+	if len(ts.samples) < size {
+		ts.samples = append(ts.samples, sample)
+		ts.next = len(ts.samples) % size
+		return
+	}
+
+	ts.samples[ts.next] = sample
+	ts.next = (ts.next + 1) % size
+}
+
+// ordered returns a copy of the samples, ordered from the oldest to the newest sample.
+func (ts *series) ordered() []Sample {
+	samples := make([]Sample, 0, len(ts.samples))
+	if len(ts.samples) < cap(ts.samples) {
+		return append(samples, ts.samples...)
+	}
+
+	samples = append(samples, ts.samples[ts.next:]...)
+	return append(samples, ts.samples[:ts.next]...)
+}
@@ -0,0 +1,248 @@
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"sync"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// summaryConcurrency is the maximum number of Nodes, for which the summary is fetched concurrently, when the summary
+// for all Nodes is requested.
+const summaryConcurrency = 5
+
+// FilesystemUsage is the usage of a filesystem or volume, as reported by the kubelet. The utilization is the used
+// bytes in percent of the capacity. All values are optional, because the kubelet doesn't report them for all
+// filesystems.
+type FilesystemUsage struct {
+	AvailableBytes *uint64  `json:"availableBytes,omitempty"`
+	CapacityBytes  *uint64  `json:"capacityBytes,omitempty"`
+	UsedBytes      *uint64  `json:"usedBytes,omitempty"`
+	InodesFree     *uint64  `json:"inodesFree,omitempty"`
+	Inodes         *uint64  `json:"inodes,omitempty"`
+	InodesUsed     *uint64  `json:"inodesUsed,omitempty"`
+	Utilization    *float64 `json:"utilization,omitempty"`
+}
+
+// VolumeUsage is the usage of a single volume of a Pod. The PersistentVolumeClaim is only set, when the volume is
+// backed by a PVC.
+type VolumeUsage struct {
+	Name                  string `json:"name"`
+	PersistentVolumeClaim string `json:"persistentVolumeClaim,omitempty"`
+	FilesystemUsage
+}
+
+// PodStorage is the ephemeral storage and the volume usage of a single Pod.
+type PodStorage struct {
+	Namespace        string           `json:"namespace"`
+	Name             string           `json:"name"`
+	EphemeralStorage *FilesystemUsage `json:"ephemeralStorage,omitempty"`
+	Volumes          []VolumeUsage    `json:"volumes"`
+}
+
+// PVCUsage is the usage of a PersistentVolumeClaim and the Pod, which mounts the claim. A claim which is mounted by
+// multiple Pods on the same Node is only returned once.
+type PVCUsage struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Pod       string `json:"pod"`
+	FilesystemUsage
+}
+
+// NodeSummary is the storage summary of a single Node, which is created from the "/stats/summary" endpoint of the
+// kubelet. It contains the usage of the root and image filesystem of the Node, the ephemeral storage and volume usage
+// of all Pods on the Node and the usage of all PVCs mounted on the Node.
+type NodeSummary struct {
+	Node                   string           `json:"node"`
+	Filesystem             *FilesystemUsage `json:"filesystem,omitempty"`
+	ImageFilesystem        *FilesystemUsage `json:"imageFilesystem,omitempty"`
+	Pods                   []PodStorage     `json:"pods"`
+	PersistentVolumeClaims []PVCUsage       `json:"persistentVolumeClaims"`
+}
+
+// Summary is the storage summary of one or multiple Nodes. If the summary for a single Node could not be fetched, the
+// error is added to the Errors field, using the name of the Node as key.
+type Summary struct {
+	Nodes  []NodeSummary     `json:"nodes"`
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+// kubeletSummary is the subset of the kubelet summary API ("stats.k8s.io/v1alpha1"), which is required for the
+// storage summary.
+type kubeletSummary struct {
+	Node struct {
+		NodeName string           `json:"nodeName"`
+		Fs       *FilesystemUsage `json:"fs"`
+		Runtime  *struct {
+			ImageFs *FilesystemUsage `json:"imageFs"`
+		} `json:"runtime"`
+	} `json:"node"`
+	Pods []struct {
+		PodRef struct {
+			Name      string `json:"name"`
+			Namespace string `json:"namespace"`
+		} `json:"podRef"`
+		Volume []struct {
+			Name   string `json:"name"`
+			PVCRef *struct {
+				Name      string `json:"name"`
+				Namespace string `json:"namespace"`
+			} `json:"pvcRef"`
+			FilesystemUsage
+		} `json:"volume"`
+		EphemeralStorage *FilesystemUsage `json:"ephemeral-storage"`
+	} `json:"pods"`
+}
+
+// GetSummary returns the storage summary for the given Node. If the node is empty, the summary for all Nodes is
+// returned. When a namespace is provided, only the Pods and PVCs of this namespace are returned.
+//
+// The summary is fetched via the proxy subresource of the Node, which requires the "get" permission for the
+// "nodes/proxy" resource. If a user doesn't have this permission, the returned error is a "Forbidden" API error, which
+// can be checked via "apierrors.IsForbidden".
+func GetSummary(ctx context.Context, clientset kubernetes.Interface, node, namespace string) (*Summary, error) {
+	if node != "" {
+		nodeSummary, err := getNodeSummary(ctx, clientset, node, namespace)
+		if err != nil {
+			return nil, err
+		}
+
+		return &Summary{Nodes: []NodeSummary{*nodeSummary}}, nil
+	}
+
+	nodes, err := clientset.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	summary := &Summary{Nodes: []NodeSummary{}, Errors: make(map[string]string)}
+
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	var firstErr error
+	semaphore := make(chan struct{}, summaryConcurrency)
+
+	for _, n := range nodes.Items {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+
+			nodeSummary, err := getNodeSummary(ctx, clientset, name, namespace)
+
+			lock.Lock()
+			defer lock.Unlock()
+
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				summary.Errors[name] = err.Error()
+				return
+			}
+			summary.Nodes = append(summary.Nodes, *nodeSummary)
+		}(n.Name)
+	}
+
+	wg.Wait()
+
+	// When the summary could not be fetched for any Node, we return the error, so that a missing permission for the
+	// node proxy is returned as error and not as an empty summary.
+	if len(summary.Nodes) == 0 && firstErr != nil {
+		return nil, firstErr
+	}
+
+	sort.Slice(summary.Nodes, func(i, j int) bool {
+		return summary.Nodes[i].Node < summary.Nodes[j].Node
+	})
+
+	if len(summary.Errors) == 0 {
+		summary.Errors = nil
+	}
+
+	return summary, nil
+}
+
+// getNodeSummary fetches the summary from the kubelet of the given Node and converts it to the storage summary.
+func getNodeSummary(ctx context.Context, clientset kubernetes.Interface, node, namespace string) (*NodeSummary, error) {
+	data, err := clientset.CoreV1().RESTClient().Get().AbsPath(fmt.Sprintf("/api/v1/nodes/%s/proxy/stats/summary", url.PathEscape(node))).DoRaw(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var raw kubeletSummary
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+
+	nodeSummary := &NodeSummary{
+		Node:                   node,
+		Filesystem:             withUtilization(raw.Node.Fs),
+		Pods:                   []PodStorage{},
+		PersistentVolumeClaims: []PVCUsage{},
+	}
+	if raw.Node.Runtime != nil {
+		nodeSummary.ImageFilesystem = withUtilization(raw.Node.Runtime.ImageFs)
+	}
+
+	pvcs := make(map[string]bool)
+
+	for _, pod := range raw.Pods {
+		if namespace != "" && pod.PodRef.Namespace != namespace {
+			continue
+		}
+
+		podStorage := PodStorage{
+			Namespace:        pod.PodRef.Namespace,
+			Name:             pod.PodRef.Name,
+			EphemeralStorage: withUtilization(pod.EphemeralStorage),
+			Volumes:          []VolumeUsage{},
+		}
+
+		for _, volume := range pod.Volume {
+			usage := withUtilization(&volume.FilesystemUsage)
+			volumeUsage := VolumeUsage{Name: volume.Name, FilesystemUsage: *usage}
+
+			if volume.PVCRef != nil {
+				volumeUsage.PersistentVolumeClaim = volume.PVCRef.Name
+
+				key := volume.PVCRef.Namespace + "/" + volume.PVCRef.Name
+				if !pvcs[key] {
+					pvcs[key] = true
+					nodeSummary.PersistentVolumeClaims = append(nodeSummary.PersistentVolumeClaims, PVCUsage{
+						Namespace:       volume.PVCRef.Namespace,
+						Name:            volume.PVCRef.Name,
+						Pod:             pod.PodRef.Name,
+						FilesystemUsage: *usage,
+					})
+				}
+			}
+
+			podStorage.Volumes = append(podStorage.Volumes, volumeUsage)
+		}
+
+		nodeSummary.Pods = append(nodeSummary.Pods, podStorage)
+	}
+
+	return nodeSummary, nil
+}
+
+// withUtilization sets the utilization of the given filesystem usage, when the used bytes and the capacity are known.
+func withUtilization(usage *FilesystemUsage) *FilesystemUsage {
+	if usage == nil {
+		return nil
+	}
+
+	if usage.UsedBytes != nil && usage.CapacityBytes != nil {
+		usage.Utilization = percent(int64(*usage.UsedBytes), int64(*usage.CapacityBytes))
+	}
+
+	return usage
+}
@@ -0,0 +1,412 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// podListLimit is the number of Pods, which are fetched per request, when the Pods for the top view are listed.
+const podListLimit = 500
+
+// TopOptions are the options for the top view. The Pods are selected via the namespace and optional via a workload,
+// which is specified via its kind ("deployment", "statefulset", "daemonset" or "replicaset") and name.
+type TopOptions struct {
+	Namespace string
+	Kind      string
+	Name      string
+}
+
+// Top is the joined view of the metrics, the requests and limits of all Pods and the HPAs of a namespace or workload.
+// If the metrics API isn't available, the Pods only contain the requests and limits and MetricsAvailable is false.
+type Top struct {
+	MetricsAvailable bool     `json:"metricsAvailable"`
+	MetricsError     string   `json:"metricsError,omitempty"`
+	Pods             []TopPod `json:"pods"`
+	HPAs             []TopHPA `json:"hpas"`
+	HPAsError        string   `json:"hpasError,omitempty"`
+}
+
+// TopPod is the usage, requests and limits of a single Pod or container. The utilization is the usage in percent of
+// the requests and limits, it is nil when no requests or limits are set or the usage is not available.
+type TopPod struct {
+	Namespace   string         `json:"namespace,omitempty"`
+	Name        string         `json:"name"`
+	Usage       *Usage         `json:"usage,omitempty"`
+	Requests    Usage          `json:"requests"`
+	Limits      Usage          `json:"limits"`
+	Utilization TopUtilization `json:"utilization"`
+	Containers  []TopPod       `json:"containers,omitempty"`
+}
+
+// TopUtilization is the usage in percent of the requests and limits.
+type TopUtilization struct {
+	CPURequests    *float64 `json:"cpuRequests,omitempty"`
+	CPULimits      *float64 `json:"cpuLimits,omitempty"`
+	MemoryRequests *float64 `json:"memoryRequests,omitempty"`
+	MemoryLimits   *float64 `json:"memoryLimits,omitempty"`
+}
+
+// TopHPA is the status of a HorizontalPodAutoscaler with its current and target metrics.
+type TopHPA struct {
+	Name            string         `json:"name"`
+	TargetKind      string         `json:"targetKind"`
+	TargetName      string         `json:"targetName"`
+	MinReplicas     int32          `json:"minReplicas"`
+	MaxReplicas     int32          `json:"maxReplicas"`
+	CurrentReplicas int32          `json:"currentReplicas"`
+	DesiredReplicas int32          `json:"desiredReplicas"`
+	Metrics         []TopHPAMetric `json:"metrics"`
+}
+
+// TopHPAMetric is a single metric of a HPA with the current and the target value.
+type TopHPAMetric struct {
+	Type    string `json:"type"`
+	Name    string `json:"name"`
+	Current string `json:"current"`
+	Target  string `json:"target"`
+}
+
+// GetTop returns the top view for the given options. The Pods, metrics and HPAs are fetched concurrently. Errors for
+// the metrics and HPAs are returned as part of the result, so that the view can also be used when the metrics server
+// isn't installed or the user isn't allowed to list the HPAs.
+func GetTop(ctx context.Context, clientset kubernetes.Interface, options TopOptions) (*Top, error) {
+	labelSelector, err := getWorkloadSelector(ctx, clientset, options)
+	if err != nil {
+		return nil, err
+	}
+
+	var wg sync.WaitGroup
+	var pods []corev1.Pod
+	var podsErr error
+	var podMetrics []PodMetrics
+	var metricsErr error
+	var hpas []autoscalingv2.HorizontalPodAutoscaler
+	var hpasErr error
+
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		pods, podsErr = listPods(ctx, clientset, options.Namespace, labelSelector)
+	}()
+	go func() {
+		defer wg.Done()
+		podMetrics, metricsErr = ListPodMetrics(ctx, clientset, options.Namespace, labelSelector)
+	}()
+	go func() {
+		defer wg.Done()
+		hpas, hpasErr = listHPAs(ctx, clientset, options)
+	}()
+	wg.Wait()
+
+	if podsErr != nil {
+		return nil, podsErr
+	}
+
+	top := &Top{
+		MetricsAvailable: metricsErr == nil,
+		Pods:             make([]TopPod, 0, len(pods)),
+		HPAs:             make([]TopHPA, 0, len(hpas)),
+	}
+	if metricsErr != nil {
+		top.MetricsError = metricsErr.Error()
+	}
+	if hpasErr != nil {
+		top.HPAsError = hpasErr.Error()
+	}
+
+	metricsByPod := make(map[string]PodMetrics, len(podMetrics))
+	for _, m := range podMetrics {
+		metricsByPod[m.Namespace+"/"+m.Name] = m
+	}
+
+	for _, pod := range pods {
+		m, hasMetrics := metricsByPod[pod.Namespace+"/"+pod.Name]
+		top.Pods = append(top.Pods, joinPod(pod, m, hasMetrics))
+	}
+
+	for _, hpa := range hpas {
+		top.HPAs = append(top.HPAs, convertHPA(hpa))
+	}
+
+	return top, nil
+}
+
+// getWorkloadSelector returns the label selector of the workload from the options. If no workload is set an empty
+// selector is returned, so that all Pods of the namespace are selected.
+func getWorkloadSelector(ctx context.Context, clientset kubernetes.Interface, options TopOptions) (string, error) {
+	if options.Kind == "" || options.Name == "" {
+		return "", nil
+	}
+
+	var selector *metav1.LabelSelector
+
+	switch strings.ToLower(options.Kind) {
+	case "deployment":
+		deployment, err := clientset.AppsV1().Deployments(options.Namespace).Get(ctx, options.Name, metav1.GetOptions{})
+		if err != nil {
+			return "", err
+		}
+		selector = deployment.Spec.Selector
+	case "statefulset":
+		statefulSet, err := clientset.AppsV1().StatefulSets(options.Namespace).Get(ctx, options.Name, metav1.GetOptions{})
+		if err != nil {
+			return "", err
+		}
+		selector = statefulSet.Spec.Selector
+	case "daemonset":
+		daemonSet, err := clientset.AppsV1().DaemonSets(options.Namespace).Get(ctx, options.Name, metav1.GetOptions{})
+		if err != nil {
+			return "", err
+		}
+		selector = daemonSet.Spec.Selector
+	case "replicaset":
+		replicaSet, err := clientset.AppsV1().ReplicaSets(options.Namespace).Get(ctx, options.Name, metav1.GetOptions{})
+		if err != nil {
+			return "", err
+		}
+		selector = replicaSet.Spec.Selector
+	default:
+		return "", fmt.Errorf("unsupported workload kind %s", options.Kind)
+	}
+
+	labelSelector, err := metav1.LabelSelectorAsSelector(selector)
+	if err != nil {
+		return "", err
+	}
+
+	return labelSelector.String(), nil
+}
+
+// listPods lists all Pods page by page, so that we do not have to load thousands of Pods within a single request.
+func listPods(ctx context.Context, clientset kubernetes.Interface, namespace, labelSelector string) ([]corev1.Pod, error) {
+	var pods []corev1.Pod
+
+	listOptions := metav1.ListOptions{LabelSelector: labelSelector, Limit: podListLimit}
+	for {
+		list, err := clientset.CoreV1().Pods(namespace).List(ctx, listOptions)
+		if err != nil {
+			return nil, err
+		}
+
+		pods = append(pods, list.Items...)
+
+		if list.Continue == "" {
+			return pods, nil
+		}
+		listOptions.Continue = list.Continue
+	}
+}
+
+// listHPAs lists the HPAs of the namespace. If a workload is set, only the HPAs which are targeting the workload are
+// returned.
+func listHPAs(ctx context.Context, clientset kubernetes.Interface, options TopOptions) ([]autoscalingv2.HorizontalPodAutoscaler, error) {
+	list, err := clientset.AutoscalingV2().HorizontalPodAutoscalers(options.Namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	if options.Kind == "" || options.Name == "" {
+		return list.Items, nil
+	}
+
+	var hpas []autoscalingv2.HorizontalPodAutoscaler
+	for _, hpa := range list.Items {
+		if strings.EqualFold(hpa.Spec.ScaleTargetRef.Kind, options.Kind) && hpa.Spec.ScaleTargetRef.Name == options.Name {
+			hpas = append(hpas, hpa)
+		}
+	}
+
+	return hpas, nil
+}
+
+// joinPod joins the requests and limits of the Pod spec with the usage from the metrics API.
+func joinPod(pod corev1.Pod, m PodMetrics, hasMetrics bool) TopPod {
+	topPod := TopPod{
+		Namespace: pod.Namespace,
+		Name:      pod.Name,
+	}
+
+	for _, container := range pod.Spec.Containers {
+		topContainer := TopPod{
+			Name:     container.Name,
+			Requests: resourceListToUsage(container.Resources.Requests),
+			Limits:   resourceListToUsage(container.Resources.Limits),
+		}
+
+		if hasMetrics {
+			if usage, ok := m.Containers[container.Name]; ok {
+				topContainer.Usage = &usage
+			}
+		}
+		topContainer.Utilization = getUtilization(topContainer.Usage, topContainer.Requests, topContainer.Limits)
+
+		topPod.Requests.CPU += topContainer.Requests.CPU
+		topPod.Requests.Memory += topContainer.Requests.Memory
+		topPod.Limits.CPU += topContainer.Limits.CPU
+		topPod.Limits.Memory += topContainer.Limits.Memory
+		topPod.Containers = append(topPod.Containers, topContainer)
+	}
+
+	if hasMetrics {
+		usage := m.Usage
+		topPod.Usage = &usage
+	}
+	topPod.Utilization = getUtilization(topPod.Usage, topPod.Requests, topPod.Limits)
+
+	return topPod
+}
+
+func resourceListToUsage(resources corev1.ResourceList) Usage {
+	return Usage{
+		CPU:    resources.Cpu().MilliValue(),
+		Memory: resources.Memory().Value(),
+	}
+}
+
+func getUtilization(usage *Usage, requests, limits Usage) TopUtilization {
+	if usage == nil {
+		return TopUtilization{}
+	}
+
+	return TopUtilization{
+		CPURequests:    percent(usage.CPU, requests.CPU),
+		CPULimits:      percent(usage.CPU, limits.CPU),
+		MemoryRequests: percent(usage.Memory, requests.Memory),
+		MemoryLimits:   percent(usage.Memory, limits.Memory),
+	}
+}
+
+func percent(value, total int64) *float64 {
+	if total == 0 {
+		return nil
+	}
+
+	p := float64(value) / float64(total) * 100
+	return &p
+}
+
+// convertHPA converts a HPA to the format of the top view. The current value of each metric is taken from the status
+// of the HPA, by matching the type and name of the metric.
+func convertHPA(hpa autoscalingv2.HorizontalPodAutoscaler) TopHPA {
+	topHPA := TopHPA{
+		Name:            hpa.Name,
+		TargetKind:      hpa.Spec.ScaleTargetRef.Kind,
+		TargetName:      hpa.Spec.ScaleTargetRef.Name,
+		MaxReplicas:     hpa.Spec.MaxReplicas,
+		CurrentReplicas: hpa.Status.CurrentReplicas,
+		DesiredReplicas: hpa.Status.DesiredReplicas,
+		Metrics:         []TopHPAMetric{},
+	}
+	if hpa.Spec.MinReplicas != nil {
+		topHPA.MinReplicas = *hpa.Spec.MinReplicas
+	} else {
+		topHPA.MinReplicas = 1
+	}
+
+	for _, spec := range hpa.Spec.Metrics {
+		name, target := describeMetricTarget(spec)
+		metric := TopHPAMetric{
+			Type:   string(spec.Type),
+			Name:   name,
+			Target: target,
+		}
+
+		for _, status := range hpa.Status.CurrentMetrics {
+			if statusName, current := describeMetricStatus(status); status.Type == spec.Type && statusName == name {
+				metric.Current = current
+				break
+			}
+		}
+
+		topHPA.Metrics = append(topHPA.Metrics, metric)
+	}
+
+	return topHPA
+}
+
+func describeMetricTarget(spec autoscalingv2.MetricSpec) (string, string) {
+	switch spec.Type {
+	case autoscalingv2.ResourceMetricSourceType:
+		if spec.Resource != nil {
+			return string(spec.Resource.Name), describeTarget(spec.Resource.Target)
+		}
+	case autoscalingv2.ContainerResourceMetricSourceType:
+		if spec.ContainerResource != nil {
+			return fmt.Sprintf("%s/%s", spec.ContainerResource.Container, spec.ContainerResource.Name), describeTarget(spec.ContainerResource.Target)
+		}
+	case autoscalingv2.PodsMetricSourceType:
+		if spec.Pods != nil {
+			return spec.Pods.Metric.Name, describeTarget(spec.Pods.Target)
+		}
+	case autoscalingv2.ObjectMetricSourceType:
+		if spec.Object != nil {
+			return spec.Object.Metric.Name, describeTarget(spec.Object.Target)
+		}
+	case autoscalingv2.ExternalMetricSourceType:
+		if spec.External != nil {
+			return spec.External.Metric.Name, describeTarget(spec.External.Target)
+		}
+	}
+
+	return "", ""
+}
+
+func describeMetricStatus(status autoscalingv2.MetricStatus) (string, string) {
+	switch status.Type {
+	case autoscalingv2.ResourceMetricSourceType:
+		if status.Resource != nil {
+			return string(status.Resource.Name), describeCurrent(status.Resource.Current)
+		}
+	case autoscalingv2.ContainerResourceMetricSourceType:
+		if status.ContainerResource != nil {
+			return fmt.Sprintf("%s/%s", status.ContainerResource.Container, status.ContainerResource.Name), describeCurrent(status.ContainerResource.Current)
+		}
+	case autoscalingv2.PodsMetricSourceType:
+		if status.Pods != nil {
+			return status.Pods.Metric.Name, describeCurrent(status.Pods.Current)
+		}
+	case autoscalingv2.ObjectMetricSourceType:
+		if status.Object != nil {
+			return status.Object.Metric.Name, describeCurrent(status.Object.Current)
+		}
+	case autoscalingv2.ExternalMetricSourceType:
+		if status.External != nil {
+			return status.External.Metric.Name, describeCurrent(status.External.Current)
+		}
+	}
+
+	return "", ""
+}
+
+func describeTarget(target autoscalingv2.MetricTarget) string {
+	if target.AverageUtilization != nil {
+		return fmt.Sprintf("%d%%", *target.AverageUtilization)
+	}
+	if target.AverageValue != nil {
+		return target.AverageValue.String()
+	}
+	if target.Value != nil {
+		return target.Value.String()
+	}
+	return ""
+}
+
+func describeCurrent(current autoscalingv2.MetricValueStatus) string {
+	if current.AverageUtilization != nil {
+		return fmt.Sprintf("%d%%", *current.AverageUtilization)
+	}
+	if current.AverageValue != nil {
+		return current.AverageValue.String()
+	}
+	if current.Value != nil {
+		return current.Value.String()
+	}
+	return ""
+}
@@ -0,0 +1,39 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+)
+
+// AuthTokenHeader is the header, which must contain the auth token for all requests to the server.
+const AuthTokenHeader = "X-AUTH-TOKEN"
+
+// AuthTokenParameter is the query parameter, which can be used instead of the header for WebSocket connections and
+// Server-Sent Events, because the browser APIs do not allow to set custom headers for them.
+const AuthTokenParameter = "token"
+
+// Auth rejects all requests which do not contain the given token in the "X-AUTH-TOKEN" header or the "token" query
+// parameter with a 401 Unauthorized error. If the token is empty, all requests are allowed. The tokens are compared in
+// constant time, so that the token can not be guessed via the response time.
+func Auth(token string, next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		requestToken := r.Header.Get(AuthTokenHeader)
+		if requestToken == "" {
+			requestToken = r.URL.Query().Get(AuthTokenParameter)
+		}
+
+		if subtle.ConstantTimeCompare([]byte(requestToken), []byte(token)) != 1 {
+			err := fmt.Errorf("invalid auth token")
+			Errorf(w, r, err, http.StatusUnauthorized, fmt.Sprintf("Unauthorized: %s", err.Error()))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,95 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func okHandler(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+}
+
+func TestAuth(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		token      string
+		header     string
+		query      string
+		statusCode int
+	}{
+		{name: "header token", token: "secret", header: "secret", statusCode: http.StatusOK},
+		{name: "query token", token: "secret", query: "?token=secret", statusCode: http.StatusOK},
+		{name: "header token is preferred", token: "secret", header: "secret", query: "?token=wrong", statusCode: http.StatusOK},
+		{name: "wrong header token", token: "secret", header: "wrong", statusCode: http.StatusUnauthorized},
+		{name: "wrong query token", token: "secret", query: "?token=wrong", statusCode: http.StatusUnauthorized},
+		{name: "prefix of token", token: "secret", header: "secre", statusCode: http.StatusUnauthorized},
+		{name: "empty token", token: "secret", query: "?token=", statusCode: http.StatusUnauthorized},
+		{name: "missing token", token: "secret", statusCode: http.StatusUnauthorized},
+		{name: "auth disabled", token: "", statusCode: http.StatusOK},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/resources"+tc.query, nil)
+			if tc.header != "" {
+				r.Header.Set(AuthTokenHeader, tc.header)
+			}
+			w := httptest.NewRecorder()
+
+			Auth(tc.token, okHandler)(w, r)
+
+			if w.Code != tc.statusCode {
+				t.Fatalf("expected status code %d, got %d", tc.statusCode, w.Code)
+			}
+
+			if tc.statusCode == http.StatusUnauthorized {
+				var response Error
+				if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+					t.Fatalf("could not decode error response: %v", err)
+				}
+				if response.Code != CodeUnauthorized {
+					t.Errorf("expected code %s, got %s", CodeUnauthorized, response.Code)
+				}
+			}
+		})
+	}
+}
+
+func TestAuthWebSocket(t *testing.T) {
+	srv := httptest.NewServer(Auth("secret", func(w http.ResponseWriter, r *http.Request) {
+		upgrader := websocket.Upgrader{}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		conn.WriteMessage(websocket.TextMessage, []byte("hello"))
+	}))
+	defer srv.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL+"?token=secret", nil)
+	if err != nil {
+		t.Fatalf("expected upgrade with query token, got %v", err)
+	}
+	defer conn.Close()
+
+	if _, data, err := conn.ReadMessage(); err != nil || string(data) != "hello" {
+		t.Errorf("expected message hello, got %q (%v)", data, err)
+	}
+
+	for _, query := range []string{"", "?token=wrong"} {
+		_, resp, err := websocket.DefaultDialer.Dial(wsURL+query, nil)
+		if err == nil {
+			t.Fatalf("expected upgrade with %q to fail", query)
+		}
+		if resp == nil || resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("expected status code %d for %q, got %v", http.StatusUnauthorized, query, resp)
+		}
+	}
+}
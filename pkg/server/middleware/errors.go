@@ -0,0 +1,163 @@
+package middleware
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"unicode"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// The machine-readable codes of an error response. Errors of the Kubernetes API use the reason of the returned status
+// as code (e.g. "not-found" or "forbidden"), all other errors are mapped to the codes below.
+const (
+	CodeBadRequest          = "bad-request"
+	CodeInvalidParameters   = "invalid-parameters"
+	CodeInvalidRequestBody  = "invalid-request-body"
+	CodeUnauthorized        = "unauthorized"
+	CodeForbidden           = "forbidden"
+	CodeNotFound            = "not-found"
+	CodeNotEnabled          = "not-enabled"
+	CodeNotSupported        = "not-supported"
+	CodeMethodNotAllowed    = "method-not-allowed"
+	CodeConflict            = "conflict"
+	CodeRequestTooLarge     = "request-too-large"
+	CodeTooManyRequests     = "too-many-requests"
+	CodeSessionLimit        = "session-limit"
+	CodeClientConfiguration = "client-configuration"
+	CodeClusterUnreachable  = "cluster-unreachable"
+	CodeUpgradeFailed       = "upgrade-failed"
+	CodeInternal            = "internal"
+	CodeUnavailable         = "unavailable"
+	CodeTimeout             = "timeout"
+)
+
+// CodedError is an error with a machine-readable code, which is returned in the error response by Errorf. The
+// underlying error can be nil, when the error is only described by the message of the response.
+type CodedError struct {
+	Code string
+	Err  error
+}
+
+func (e *CodedError) Error() string {
+	if e.Err == nil {
+		return e.Code
+	}
+	return e.Err.Error()
+}
+
+func (e *CodedError) Unwrap() error {
+	return e.Err
+}
+
+// WithCode returns the given error with the given machine-readable code.
+func WithCode(code string, err error) error {
+	return &CodedError{Code: code, Err: err}
+}
+
+// InvalidParameters returns an error for invalid query parameters of a request.
+func InvalidParameters(err error) error {
+	return WithCode(CodeInvalidParameters, err)
+}
+
+// InvalidRequestBody returns an error for an empty or invalid request body.
+func InvalidRequestBody(err error) error {
+	return WithCode(CodeInvalidRequestBody, err)
+}
+
+// ClientConfiguration returns an error for a Kubernetes API client, which could not be created from the provided
+// credentials.
+func ClientConfiguration(err error) error {
+	return WithCode(CodeClientConfiguration, err)
+}
+
+// RequestTooLarge returns an error for a request body or WebSocket message, which exceeds the configured size limit.
+func RequestTooLarge(err error) error {
+	return WithCode(CodeRequestTooLarge, err)
+}
+
+// SessionLimit returns an error for a session, which could not be created, because the maximum number of sessions is
+// reached.
+func SessionLimit(err error) error {
+	return WithCode(CodeSessionLimit, err)
+}
+
+// NotEnabled returns an error for a feature, which isn't enabled in the server options.
+func NotEnabled(err error) error {
+	return WithCode(CodeNotEnabled, err)
+}
+
+// UpgradeFailed returns an error for a connection, which could not be upgraded to a WebSocket connection.
+func UpgradeFailed(err error) error {
+	return WithCode(CodeUpgradeFailed, err)
+}
+
+// getErrorCode returns the machine-readable code and the Kubernetes status for an error response. The code is taken
+// from a CodedError, the reason of a Kubernetes API error or the type of a network error. If none of them matches the
+// code is derived from the http status code.
+func getErrorCode(err error, statusCode int) (string, *metav1.Status) {
+	var status *metav1.Status
+	var apiStatus apierrors.APIStatus
+	if err != nil && errors.As(err, &apiStatus) {
+		s := apiStatus.Status()
+		status = &s
+	}
+
+	var codedErr *CodedError
+	if errors.As(err, &codedErr) {
+		return codedErr.Code, status
+	}
+
+	if status != nil && status.Reason != "" && status.Reason != metav1.StatusReasonUnknown {
+		return reasonToCode(string(status.Reason)), status
+	}
+
+	var netErr net.Error
+	if err != nil && errors.As(err, &netErr) {
+		return CodeClusterUnreachable, status
+	}
+
+	switch statusCode {
+	case http.StatusUnauthorized:
+		return CodeUnauthorized, status
+	case http.StatusForbidden:
+		return CodeForbidden, status
+	case http.StatusNotFound:
+		return CodeNotFound, status
+	case http.StatusMethodNotAllowed:
+		return CodeMethodNotAllowed, status
+	case http.StatusConflict:
+		return CodeConflict, status
+	case http.StatusRequestEntityTooLarge:
+		return CodeRequestTooLarge, status
+	case http.StatusTooManyRequests:
+		return CodeTooManyRequests, status
+	case http.StatusServiceUnavailable:
+		return CodeUnavailable, status
+	case http.StatusGatewayTimeout:
+		return CodeTimeout, status
+	}
+
+	if statusCode >= http.StatusInternalServerError {
+		return CodeInternal, status
+	}
+	return CodeBadRequest, status
+}
+
+// reasonToCode converts the reason of a Kubernetes status to our code format, e.g. "NotFound" to "not-found".
+func reasonToCode(reason string) string {
+	var code strings.Builder
+	for i, r := range reason {
+		if unicode.IsUpper(r) {
+			if i > 0 {
+				code.WriteByte('-')
+			}
+			r = unicode.ToLower(r)
+		}
+		code.WriteRune(r)
+	}
+	return code.String()
+}
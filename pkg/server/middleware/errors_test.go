@@ -0,0 +1,105 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestGetErrorCode(t *testing.T) {
+	pods := schema.GroupResource{Resource: "pods"}
+
+	for _, tc := range []struct {
+		name            string
+		err             error
+		statusCode      int
+		expectedCode    string
+		expectedReason  metav1.StatusReason
+		expectedDetails bool
+	}{
+		{name: "not found", err: apierrors.NewNotFound(pods, "nginx"), statusCode: http.StatusInternalServerError, expectedCode: "not-found", expectedReason: metav1.StatusReasonNotFound, expectedDetails: true},
+		{name: "forbidden", err: apierrors.NewForbidden(pods, "nginx", errors.New("rbac")), statusCode: http.StatusBadRequest, expectedCode: "forbidden", expectedReason: metav1.StatusReasonForbidden, expectedDetails: true},
+		{name: "already exists", err: apierrors.NewAlreadyExists(pods, "nginx"), statusCode: http.StatusBadRequest, expectedCode: "already-exists", expectedReason: metav1.StatusReasonAlreadyExists, expectedDetails: true},
+		{name: "too many requests", err: apierrors.NewTooManyRequests("slow down", 1), statusCode: http.StatusBadRequest, expectedCode: "too-many-requests", expectedReason: metav1.StatusReasonTooManyRequests, expectedDetails: true},
+		{name: "wrapped api error", err: fmt.Errorf("could not get pod: %w", apierrors.NewConflict(pods, "nginx", errors.New("modified"))), statusCode: http.StatusBadRequest, expectedCode: "conflict", expectedReason: metav1.StatusReasonConflict, expectedDetails: true},
+		{name: "generic server response", err: apierrors.NewGenericServerResponse(http.StatusBadGateway, "get", pods, "nginx", "", 0, false), statusCode: http.StatusBadGateway, expectedCode: "internal-error", expectedReason: metav1.StatusReasonInternalError, expectedDetails: true},
+		{name: "api error with unknown reason", err: &apierrors.StatusError{ErrStatus: metav1.Status{Status: metav1.StatusFailure, Reason: metav1.StatusReasonUnknown, Code: http.StatusInternalServerError}}, statusCode: http.StatusInternalServerError, expectedCode: CodeInternal, expectedReason: metav1.StatusReasonUnknown, expectedDetails: true},
+		{name: "api error without reason", err: &apierrors.StatusError{ErrStatus: metav1.Status{Status: metav1.StatusFailure, Code: http.StatusTeapot}}, statusCode: http.StatusNotFound, expectedCode: "not-found", expectedDetails: true},
+		{name: "coded error", err: SessionLimit(errors.New("too many sessions")), statusCode: http.StatusTooManyRequests, expectedCode: CodeSessionLimit},
+		{name: "coded error without error", err: WithCode(CodeNotEnabled, nil), statusCode: http.StatusBadRequest, expectedCode: CodeNotEnabled},
+		{name: "coded error wins over api error", err: ClientConfiguration(apierrors.NewUnauthorized("expired")), statusCode: http.StatusBadRequest, expectedCode: CodeClientConfiguration, expectedReason: metav1.StatusReasonUnauthorized, expectedDetails: true},
+		{name: "max bytes error", err: RequestTooLarge(&http.MaxBytesError{Limit: 1024}), statusCode: http.StatusRequestEntityTooLarge, expectedCode: CodeRequestTooLarge},
+		{name: "max bytes error without code", err: &http.MaxBytesError{Limit: 1024}, statusCode: http.StatusRequestEntityTooLarge, expectedCode: CodeRequestTooLarge},
+		{name: "network error", err: &url.Error{Op: "Get", URL: "https://10.0.0.1:6443", Err: &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}}, statusCode: http.StatusInternalServerError, expectedCode: CodeClusterUnreachable},
+		{name: "deadline exceeded", err: context.DeadlineExceeded, statusCode: http.StatusInternalServerError, expectedCode: CodeClusterUnreachable},
+		{name: "plain error with unauthorized", err: errors.New("invalid auth token"), statusCode: http.StatusUnauthorized, expectedCode: CodeUnauthorized},
+		{name: "plain error with method not allowed", err: errors.New("method not allowed"), statusCode: http.StatusMethodNotAllowed, expectedCode: CodeMethodNotAllowed},
+		{name: "plain error with service unavailable", err: errors.New("metrics server unavailable"), statusCode: http.StatusServiceUnavailable, expectedCode: CodeUnavailable},
+		{name: "plain error with gateway timeout", err: errors.New("timeout"), statusCode: http.StatusGatewayTimeout, expectedCode: CodeTimeout},
+		{name: "plain error with internal server error", err: errors.New("boom"), statusCode: http.StatusInternalServerError, expectedCode: CodeInternal},
+		{name: "plain error with bad gateway", err: errors.New("boom"), statusCode: http.StatusBadGateway, expectedCode: CodeInternal},
+		{name: "plain error with bad request", err: errors.New("invalid"), statusCode: http.StatusBadRequest, expectedCode: CodeBadRequest},
+		{name: "nil error", err: nil, statusCode: http.StatusNotFound, expectedCode: CodeNotFound},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			code, status := getErrorCode(tc.err, tc.statusCode)
+			if code != tc.expectedCode {
+				t.Errorf("expected code %s, got %s", tc.expectedCode, code)
+			}
+
+			if (status != nil) != tc.expectedDetails {
+				t.Fatalf("expected details %t, got %+v", tc.expectedDetails, status)
+			}
+			if status != nil && status.Reason != tc.expectedReason {
+				t.Errorf("expected reason %s, got %s", tc.expectedReason, status.Reason)
+			}
+		})
+	}
+}
+
+func TestReasonToCode(t *testing.T) {
+	for reason, expected := range map[string]string{
+		"NotFound":              "not-found",
+		"Forbidden":             "forbidden",
+		"AlreadyExists":         "already-exists",
+		"ServiceUnavailable":    "service-unavailable",
+		"RequestEntityTooLarge": "request-entity-too-large",
+	} {
+		if actual := reasonToCode(reason); actual != expected {
+			t.Errorf("expected code %s for reason %s, got %s", expected, reason, actual)
+		}
+	}
+}
+
+func TestErrorfResponse(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/api/resources", nil)
+	w := httptest.NewRecorder()
+
+	Errorf(w, r, apierrors.NewNotFound(schema.GroupResource{Group: "apps", Resource: "deployments"}, "nginx"), http.StatusNotFound, "Could not get deployment")
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status code %d, got %d", http.StatusNotFound, w.Code)
+	}
+
+	var response Error
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("could not decode response: %v", err)
+	}
+
+	if response.Code != CodeNotFound || response.Message != "Could not get deployment" || !response.Error || response.StatusCode != http.StatusNotFound {
+		t.Errorf("unexpected response %+v", response)
+	}
+	if response.Details == nil || response.Details.Details == nil || response.Details.Details.Name != "nginx" || response.Details.Details.Group != "apps" || response.Details.Details.Kind != "deployments" {
+		t.Errorf("expected details of the Kubernetes status, got %+v", response.Details)
+	}
+}
@@ -0,0 +1,200 @@
+package middleware
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// DefaultGzipMinSize is the default minimum size of a response in bytes, before it is compressed.
+const DefaultGzipMinSize = 1024
+
+var gzipWriterPool = sync.Pool{
+	New: func() interface{} {
+		return gzip.NewWriter(nil)
+	},
+}
+
+// Gzip compresses the responses of the next handler via gzip, when the client accepts it via the "Accept-Encoding"
+// header. The response is buffered until it reaches the given minimum size, so that small responses are not
+// compressed. WebSocket upgrades and responses which are already compressed (e.g. the logs download) are never
+// compressed. Server-Sent Events are compressed immediately and each event is flushed to the client.
+func Gzip(minSize int, next http.Handler) http.Handler {
+	if minSize <= 0 {
+		minSize = DefaultGzipMinSize
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead || isWebSocketUpgrade(r) || !acceptsGzip(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		gw := &gzipResponseWriter{ResponseWriter: w, minSize: minSize, status: http.StatusOK}
+		defer gw.Close()
+
+		next.ServeHTTP(gw, r)
+	})
+}
+
+func acceptsGzip(r *http.Request) bool {
+	for _, encoding := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		encoding, params, _ := strings.Cut(strings.TrimSpace(encoding), ";")
+		if strings.EqualFold(strings.TrimSpace(encoding), "gzip") && strings.ReplaceAll(params, " ", "") != "q=0" {
+			return true
+		}
+	}
+	return false
+}
+
+// gzipResponseWriter buffers the response until it is decided whether the response is compressed or not. This happens
+// when the buffer reaches the minimum size, when the response is flushed or when the handler returns.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	minSize  int
+	status   int
+	buf      []byte
+	gz       *gzip.Writer
+	decided  bool
+	hijacked bool
+}
+
+func (w *gzipResponseWriter) WriteHeader(status int) {
+	if w.decided {
+		return
+	}
+
+	w.status = status
+	if status < http.StatusOK || status == http.StatusNoContent || status == http.StatusNotModified {
+		w.decide(false)
+	}
+}
+
+func (w *gzipResponseWriter) Write(data []byte) (int, error) {
+	if !w.decided {
+		if !w.isCompressible() {
+			w.decide(false)
+		} else if w.isEventStream() {
+			w.decide(true)
+		} else {
+			w.buf = append(w.buf, data...)
+			if len(w.buf) >= w.minSize {
+				if err := w.decide(true); err != nil {
+					return 0, err
+				}
+			}
+			return len(data), nil
+		}
+	}
+
+	if w.gz != nil {
+		return w.gz.Write(data)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+// decide writes the header and the buffered data. If compress is true, the data is written via a gzip writer.
+func (w *gzipResponseWriter) decide(compress bool) error {
+	w.decided = true
+
+	if compress {
+		w.Header().Del("Content-Length")
+		w.Header().Set("Content-Encoding", "gzip")
+
+		w.gz = gzipWriterPool.Get().(*gzip.Writer)
+		w.gz.Reset(w.ResponseWriter)
+	}
+
+	w.ResponseWriter.WriteHeader(w.status)
+
+	if len(w.buf) == 0 {
+		return nil
+	}
+
+	buf := w.buf
+	w.buf = nil
+	if w.gz != nil {
+		_, err := w.gz.Write(buf)
+		return err
+	}
+	_, err := w.ResponseWriter.Write(buf)
+	return err
+}
+
+// isCompressible returns false, when the response already has a content encoding or when the content type indicates
+// that the content is already compressed.
+func (w *gzipResponseWriter) isCompressible() bool {
+	if w.Header().Get("Content-Encoding") != "" {
+		return false
+	}
+
+	contentType := strings.ToLower(w.Header().Get("Content-Type"))
+	for _, prefix := range []string{"application/gzip", "application/x-gzip", "application/zip", "application/octet-stream", "image/", "video/", "audio/"} {
+		if strings.HasPrefix(contentType, prefix) && contentType != "image/svg+xml" {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (w *gzipResponseWriter) isEventStream() bool {
+	return strings.HasPrefix(w.Header().Get("Content-Type"), "text/event-stream")
+}
+
+// Flush writes the buffered data to the client. If no decision was made yet, event streams are compressed and all
+// other responses are send uncompressed, because the client expects the data now.
+func (w *gzipResponseWriter) Flush() {
+	if !w.decided {
+		w.decide(w.isCompressible() && w.isEventStream())
+	}
+
+	if w.gz != nil {
+		w.gz.Flush()
+	}
+
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Close writes the remaining data, when the handler returns.
+func (w *gzipResponseWriter) Close() {
+	if w.hijacked {
+		return
+	}
+
+	if !w.decided {
+		w.decide(false)
+	}
+
+	if w.gz != nil {
+		w.gz.Close()
+		w.gz.Reset(nil)
+		gzipWriterPool.Put(w.gz)
+		w.gz = nil
+	}
+}
+
+func (w *gzipResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response writer does not implement http.Hijacker")
+	}
+
+	conn, rw, err := hijacker.Hijack()
+	if err == nil {
+		w.hijacked = true
+	}
+	return conn, rw, err
+}
+
+func (w *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
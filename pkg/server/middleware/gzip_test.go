@@ -0,0 +1,324 @@
+package middleware
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func gunzip(t *testing.T, data []byte) []byte {
+	t.Helper()
+
+	gr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("could not create gzip reader: %v", err)
+	}
+	defer gr.Close()
+
+	decompressed, err := io.ReadAll(gr)
+	if err != nil {
+		t.Fatalf("could not decompress response: %v", err)
+	}
+	return decompressed
+}
+
+func serveGzip(minSize int, acceptEncoding string, method string, handler http.HandlerFunc) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, "/api/resources", nil)
+	if acceptEncoding != "" {
+		r.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+	w := httptest.NewRecorder()
+
+	Gzip(minSize, handler).ServeHTTP(w, r)
+	return w
+}
+
+func TestGzipRoundTrip(t *testing.T) {
+	// The response is written in many small chunks, like it is done by a json encoder for a large list.
+	var expected bytes.Buffer
+	for i := 0; expected.Len() < 5*1024*1024; i++ {
+		fmt.Fprintf(&expected, `{"kind":"Pod","metadata":{"name":"nginx-%d","namespace":"default"}},`, i)
+	}
+
+	w := serveGzip(0, "gzip, deflate, br", http.MethodGet, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		data := expected.Bytes()
+		for len(data) > 0 {
+			n := 1000
+			if n > len(data) {
+				n = len(data)
+			}
+			w.Write(data[:n])
+			data = data[n:]
+		}
+	})
+
+	if w.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("expected gzip content encoding, got %q", w.Header().Get("Content-Encoding"))
+	}
+	if w.Header().Get("Vary") != "Accept-Encoding" {
+		t.Errorf("expected Vary Accept-Encoding, got %q", w.Header().Get("Vary"))
+	}
+	if w.Body.Len() >= expected.Len()/4 {
+		t.Errorf("expected compressed size below %d bytes, got %d", expected.Len()/4, w.Body.Len())
+	}
+	if !bytes.Equal(gunzip(t, w.Body.Bytes()), expected.Bytes()) {
+		t.Errorf("expected decompressed response to match the original response")
+	}
+}
+
+func TestGzipMinSize(t *testing.T) {
+	for _, tc := range []struct {
+		name           string
+		minSize        int
+		size           int
+		expectedGzip   bool
+		acceptEncoding string
+		method         string
+	}{
+		{name: "below default min size", minSize: 0, size: DefaultGzipMinSize - 1, expectedGzip: false, acceptEncoding: "gzip", method: http.MethodGet},
+		{name: "default min size", minSize: 0, size: DefaultGzipMinSize, expectedGzip: true, acceptEncoding: "gzip", method: http.MethodGet},
+		{name: "below custom min size", minSize: 4096, size: 4095, expectedGzip: false, acceptEncoding: "gzip", method: http.MethodGet},
+		{name: "custom min size", minSize: 4096, size: 4096, expectedGzip: true, acceptEncoding: "gzip", method: http.MethodGet},
+		{name: "gzip not accepted", minSize: 0, size: 4096, expectedGzip: false, acceptEncoding: "deflate, br", method: http.MethodGet},
+		{name: "gzip disabled via quality", minSize: 0, size: 4096, expectedGzip: false, acceptEncoding: "gzip;q=0, br", method: http.MethodGet},
+		{name: "gzip with quality", minSize: 0, size: 4096, expectedGzip: true, acceptEncoding: "br;q=1.0, GZIP;q=0.5", method: http.MethodGet},
+		{name: "post request", minSize: 0, size: 4096, expectedGzip: true, acceptEncoding: "gzip", method: http.MethodPost},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			expected := strings.Repeat("a", tc.size)
+			w := serveGzip(tc.minSize, tc.acceptEncoding, tc.method, func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(expected))
+			})
+
+			if actual := w.Header().Get("Content-Encoding") == "gzip"; actual != tc.expectedGzip {
+				t.Fatalf("expected gzip %t, got %t", tc.expectedGzip, actual)
+			}
+
+			body := w.Body.Bytes()
+			if tc.expectedGzip {
+				body = gunzip(t, body)
+			}
+			if string(body) != expected {
+				t.Errorf("expected body with %d bytes, got %d bytes", len(expected), len(body))
+			}
+		})
+	}
+}
+
+func TestGzipNotCompressed(t *testing.T) {
+	large := strings.Repeat("a", 4096)
+
+	for _, tc := range []struct {
+		name         string
+		method       string
+		handler      http.HandlerFunc
+		expectedCode int
+		expectedBody string
+	}{
+		{
+			name:   "already encoded",
+			method: http.MethodGet,
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Encoding", "br")
+				w.Write([]byte(large))
+			},
+			expectedCode: http.StatusOK,
+			expectedBody: large,
+		},
+		{
+			name:   "compressed content type",
+			method: http.MethodGet,
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/gzip")
+				w.Write([]byte(large))
+			},
+			expectedCode: http.StatusOK,
+			expectedBody: large,
+		},
+		{
+			name:   "image",
+			method: http.MethodGet,
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "image/png")
+				w.Write([]byte(large))
+			},
+			expectedCode: http.StatusOK,
+			expectedBody: large,
+		},
+		{
+			name:   "not modified",
+			method: http.MethodGet,
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNotModified)
+			},
+			expectedCode: http.StatusNotModified,
+		},
+		{
+			name:   "no content",
+			method: http.MethodDelete,
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNoContent)
+			},
+			expectedCode: http.StatusNoContent,
+		},
+		{
+			name:   "head request",
+			method: http.MethodHead,
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(large))
+			},
+			expectedCode: http.StatusOK,
+			expectedBody: large,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			w := serveGzip(0, "gzip", tc.method, tc.handler)
+
+			if w.Code != tc.expectedCode {
+				t.Errorf("expected status code %d, got %d", tc.expectedCode, w.Code)
+			}
+			if encoding := w.Header().Get("Content-Encoding"); encoding == "gzip" {
+				t.Errorf("expected response not to be compressed")
+			}
+			if w.Body.String() != tc.expectedBody {
+				t.Errorf("expected body with %d bytes, got %d bytes", len(tc.expectedBody), w.Body.Len())
+			}
+		})
+	}
+}
+
+func TestGzipEventStream(t *testing.T) {
+	next := make(chan struct{})
+	defer close(next)
+
+	srv := httptest.NewServer(Gzip(0, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for i := 0; i < 2; i++ {
+			fmt.Fprintf(w, "event: message\ndata: %d\n\n", i)
+			w.(http.Flusher).Flush()
+			<-next
+		}
+	})))
+	defer srv.Close()
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+	if err != nil {
+		t.Fatalf("could not create request: %v", err)
+	}
+	// When the header is set explicitly, the transport doesn't decompress the response, so that we can check the
+	// content encoding.
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("could not send request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.Header.Get("Content-Encoding") != "gzip" {
+		t.Fatalf("expected gzip content encoding, got %q", resp.Header.Get("Content-Encoding"))
+	}
+
+	gr, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatalf("could not create gzip reader: %v", err)
+	}
+	reader := bufio.NewReader(gr)
+
+	// Each event must be readable, before the handler writes the next event, even though it is smaller than the minimum
+	// size.
+	for i := 0; i < 2; i++ {
+		lines := make(chan string, 1)
+		go func() {
+			var event strings.Builder
+			for {
+				line, err := reader.ReadString('\n')
+				event.WriteString(line)
+				if err != nil || line == "\n" {
+					lines <- event.String()
+					return
+				}
+			}
+		}()
+
+		select {
+		case event := <-lines:
+			if expected := fmt.Sprintf("event: message\ndata: %d\n\n", i); event != expected {
+				t.Fatalf("expected event %q, got %q", expected, event)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("event %d was not flushed", i)
+		}
+
+		next <- struct{}{}
+	}
+}
+
+func TestGzipHijack(t *testing.T) {
+	srv := httptest.NewServer(Gzip(0, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Errorf("could not hijack connection: %v", err)
+			return
+		}
+		defer conn.Close()
+
+		rw.WriteString("HTTP/1.1 200 OK\r\nContent-Length: 5\r\nConnection: close\r\n\r\nhello")
+		rw.Flush()
+	})))
+	defer srv.Close()
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+	if err != nil {
+		t.Fatalf("could not create request: %v", err)
+	}
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("could not send request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("could not read response: %v", err)
+	}
+	if resp.Header.Get("Content-Encoding") != "" || string(body) != "hello" {
+		t.Errorf("expected uncompressed response from the hijacked connection, got %q", body)
+	}
+}
+
+func TestGzipWebSocket(t *testing.T) {
+	srv := httptest.NewServer(Gzip(0, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upgrader := websocket.Upgrader{}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		conn.WriteMessage(websocket.TextMessage, []byte(strings.Repeat("a", 4096)))
+	})))
+	defer srv.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), http.Header{"Accept-Encoding": []string{"gzip"}})
+	if err != nil {
+		t.Fatalf("could not upgrade connection: %v", err)
+	}
+	defer conn.Close()
+
+	if _, data, err := conn.ReadMessage(); err != nil || len(data) != 4096 {
+		t.Errorf("expected message with 4096 bytes, got %d bytes (%v)", len(data), err)
+	}
+}
@@ -0,0 +1,350 @@
+package middleware
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The levels of the request log. With the "error" level only failed requests (status code >= 400) are logged, with the
+// "info" level all requests are logged and with the "debug" level the scrubbed headers, query parameters and JSON
+// bodies of the requests are added to the log lines.
+const (
+	LogLevelOff   = "off"
+	LogLevelError = "error"
+	LogLevelInfo  = "info"
+	LogLevelDebug = "debug"
+)
+
+// maxLogBodySize is the maximum size of a request body, which is added to the request log with the "debug" level.
+const maxLogBodySize = 64 * 1024
+
+// redacted is the value, which is logged instead of a credential.
+const redacted = "[REDACTED]"
+
+var logLevels = map[string]int{
+	"":            0,
+	LogLevelOff:   0,
+	LogLevelError: 1,
+	LogLevelInfo:  2,
+	LogLevelDebug: 3,
+}
+
+// Logger writes a JSON line for each request to the given writer. The credentials of the user, which are send via our
+// custom headers, the "Authorization" header, query parameters or the request body are never logged.
+type Logger struct {
+	level atomic.Int32
+	out   io.Writer
+	lock  sync.Mutex
+}
+
+// NewLogger returns a new request logger for the given level. If the level is empty or "off", the requests are not
+// logged.
+func NewLogger(level string, out io.Writer) (*Logger, error) {
+	l := &Logger{out: out}
+	if err := l.SetLevel(level); err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// SetLevel changes the level of the request logger. The level can be changed while the server is running, it is
+// applied to all new requests. If the level is invalid an error is returned and the level isn't changed.
+func (l *Logger) SetLevel(level string) error {
+	parsedLevel, ok := logLevels[strings.ToLower(level)]
+	if !ok {
+		return fmt.Errorf("invalid log level %s", level)
+	}
+
+	l.level.Store(int32(parsedLevel))
+	return nil
+}
+
+// logEntry is a single line of the request log. For WebSocket connections the close reason is set via SetCloseReason
+// by the handler, so that it is protected by a lock.
+type logEntry struct {
+	Time        string            `json:"time"`
+	Level       string            `json:"level"`
+	Message     string            `json:"msg"`
+	RequestID   string            `json:"requestID"`
+	Method      string            `json:"method"`
+	Path        string            `json:"path"`
+	Query       map[string]string `json:"query,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	Body        json.RawMessage   `json:"body,omitempty"`
+	Status      int               `json:"status"`
+	Size        int64             `json:"size"`
+	Duration    float64           `json:"durationMs"`
+	WebSocket   bool              `json:"websocket,omitempty"`
+	CloseReason string            `json:"closeReason,omitempty"`
+	Error       string            `json:"error,omitempty"`
+
+	lock sync.Mutex
+}
+
+type logEntryKey struct{}
+
+// SetCloseReason sets the close reason of a WebSocket connection, which is added to the log line, when the connection
+// is closed.
+func SetCloseReason(r *http.Request, reason string) {
+	if entry, ok := r.Context().Value(logEntryKey{}).(*logEntry); ok {
+		entry.lock.Lock()
+		entry.CloseReason = reason
+		entry.lock.Unlock()
+	}
+}
+
+// setLogError sets the scrubbed error message of a request, which is added to the log line.
+func setLogError(r *http.Request, message string) {
+	if entry, ok := r.Context().Value(logEntryKey{}).(*logEntry); ok {
+		entry.lock.Lock()
+		entry.Error = message
+		entry.lock.Unlock()
+	}
+}
+
+// Handler returns a http handler, which logs all requests of the next handler. WebSocket connections are logged twice:
+// when the connection is upgraded and when it is closed, together with the close reason.
+func (l *Logger) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		level := int(l.level.Load())
+		if level == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+
+		requestID := GetRequestID(r.Context())
+		if requestID == "" {
+			requestID = newRequestID()
+		}
+
+		entry := &logEntry{
+			RequestID: requestID,
+			Method:    r.Method,
+			Path:      r.URL.Path,
+		}
+
+		if level >= logLevels[LogLevelDebug] {
+			entry.Query = scrubQuery(r.URL.Query())
+			entry.Headers = scrubHeaders(r.Header)
+			entry.Body = l.readBody(r)
+		}
+
+		lw := &logResponseWriter{ResponseWriter: w, status: http.StatusOK}
+		lw.onHijack = func() {
+			entry.lock.Lock()
+			entry.WebSocket = true
+			entry.Status = http.StatusSwitchingProtocols
+			entry.Duration = float64(time.Since(start).Microseconds()) / 1000
+			l.write(entry, "websocket upgraded")
+			entry.lock.Unlock()
+		}
+
+		next.ServeHTTP(lw, r.WithContext(context.WithValue(r.Context(), logEntryKey{}, entry)))
+
+		entry.lock.Lock()
+		defer entry.lock.Unlock()
+
+		entry.Size = lw.size
+		entry.Duration = float64(time.Since(start).Microseconds()) / 1000
+		if entry.WebSocket {
+			l.write(entry, "websocket closed")
+			return
+		}
+
+		entry.Status = lw.status
+		if entry.Status < http.StatusBadRequest && level < logLevels[LogLevelInfo] {
+			return
+		}
+		l.write(entry, "request")
+	})
+}
+
+// write writes the entry with the given message as JSON line. The entry must be locked by the caller.
+func (l *Logger) write(entry *logEntry, message string) {
+	entry.Time = time.Now().UTC().Format(time.RFC3339Nano)
+	entry.Message = message
+	entry.Level = LogLevelInfo
+	if entry.Status >= http.StatusBadRequest {
+		entry.Level = LogLevelError
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.out.Write(append(data, '\n'))
+}
+
+// readBody returns the scrubbed JSON body of the request. The body is restored, so that it can be read by the handler.
+// If the body isn't valid JSON or it is larger than the maximum size, nothing is returned, because we can not
+// guarantee that it doesn't contain any credentials.
+func (l *Logger) readBody(r *http.Request) json.RawMessage {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxLogBodySize+1))
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(data), r.Body), r.Body}
+	if err != nil || len(data) > maxLogBodySize {
+		return nil
+	}
+
+	var body interface{}
+	if err := json.Unmarshal(data, &body); err != nil {
+		return nil
+	}
+
+	scrubbed, err := json.Marshal(scrubValue(body))
+	if err != nil {
+		return nil
+	}
+
+	return scrubbed
+}
+
+// isSensitiveKey returns true, when the given header, query parameter or JSON field name could contain a credential,
+// e.g. "X-USER-TOKEN", "token" or "userPassword".
+func isSensitiveKey(key string) bool {
+	key = strings.ToLower(key)
+	if strings.HasPrefix(key, "x-user-") || strings.HasPrefix(key, "x-cluster-") || key == "x-proxy" {
+		return true
+	}
+
+	key = strings.NewReplacer("-", "", "_", "").Replace(key)
+	for _, sensitive := range []string{"token", "password", "secret", "key", "certificate", "authorization", "cookie", "credential", "signature", "proxy"} {
+		if strings.Contains(key, sensitive) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func scrubHeaders(header http.Header) map[string]string {
+	scrubbed := make(map[string]string, len(header))
+	for key, values := range header {
+		if isSensitiveKey(key) {
+			scrubbed[key] = redacted
+		} else {
+			scrubbed[key] = strings.Join(values, ", ")
+		}
+	}
+	return scrubbed
+}
+
+// scrubQuery scrubs the query parameters of a request. The "url" parameter of the watch endpoint can contain another
+// query string, so that it is scrubbed recursively.
+func scrubQuery(query url.Values) map[string]string {
+	if len(query) == 0 {
+		return nil
+	}
+
+	scrubbed := make(map[string]string, len(query))
+	for key, values := range query {
+		value := strings.Join(values, ", ")
+		if isSensitiveKey(key) {
+			value = redacted
+		} else if u, err := url.Parse(value); err == nil && (u.RawQuery != "" || u.User != nil) {
+			if u.User != nil {
+				u.User = url.User(redacted)
+			}
+			nested := url.Values{}
+			for nestedKey, nestedValue := range scrubQuery(u.Query()) {
+				nested.Set(nestedKey, nestedValue)
+			}
+			u.RawQuery = nested.Encode()
+			value = u.String()
+		}
+		scrubbed[key] = value
+	}
+	return scrubbed
+}
+
+func scrubValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, nested := range v {
+			if isSensitiveKey(key) {
+				v[key] = redacted
+			} else {
+				v[key] = scrubValue(nested)
+			}
+		}
+		return v
+	case []interface{}:
+		for i, nested := range v {
+			v[i] = scrubValue(nested)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+// logResponseWriter records the status code and the size of a response. It implements the http.Flusher and
+// http.Hijacker interfaces, so that it can be used for Server-Sent Events and WebSocket connections.
+type logResponseWriter struct {
+	http.ResponseWriter
+	status      int
+	size        int64
+	wroteHeader bool
+	onHijack    func()
+}
+
+func (w *logResponseWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.status = status
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *logResponseWriter) Write(data []byte) (int, error) {
+	w.wroteHeader = true
+	n, err := w.ResponseWriter.Write(data)
+	w.size += int64(n)
+	return n, err
+}
+
+func (w *logResponseWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *logResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response writer does not implement http.Hijacker")
+	}
+
+	conn, rw, err := hijacker.Hijack()
+	if err == nil && w.onHijack != nil {
+		w.onHijack()
+	}
+	return conn, rw, err
+}
+
+func (w *logResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
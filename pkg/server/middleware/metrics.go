@@ -0,0 +1,87 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metricsNamespace is the namespace for all metrics of the server.
+const metricsNamespace = "kubenav_server"
+
+// Metrics contains the Prometheus registry for the metrics of the server and the metrics for the requests, which are
+// recorded by the Instrument middleware. Besides the request metrics, the registry contains the Go runtime and process
+// metrics. Additional metrics (e.g. for the sessions) can be registered via the Registry.
+type Metrics struct {
+	Registry *prometheus.Registry
+
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+	size     *prometheus.CounterVec
+}
+
+// NewMetrics returns a new Metrics object with a new registry.
+func NewMetrics() *Metrics {
+	m := &Metrics{
+		Registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "requests_total",
+			Help:      "Number of requests by route, method and status code.",
+		}, []string{"route", "method", "status"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "request_duration_seconds",
+			Help:      "Duration of the requests by route and method. For WebSocket connections this is the duration of the session.",
+			Buckets:   []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 300, 1800},
+		}, []string{"route", "method"}),
+		size: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "response_bytes_total",
+			Help:      "Number of bytes written in the responses by route.",
+		}, []string{"route"}),
+	}
+
+	m.Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.requests,
+		m.duration,
+		m.size,
+	)
+
+	return m
+}
+
+// Instrument records the number, the duration and the size of the requests for the next handler. The route is used as
+// label instead of the path of the request, so that the number of time series is limited. If the metrics are nil, the
+// requests are not recorded.
+func (m *Metrics) Instrument(route string, next http.HandlerFunc) http.HandlerFunc {
+	if m == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		lw := &logResponseWriter{ResponseWriter: w, status: http.StatusOK}
+		lw.onHijack = func() {
+			lw.status = http.StatusSwitchingProtocols
+		}
+
+		next.ServeHTTP(lw, r)
+
+		m.requests.WithLabelValues(route, r.Method, strconv.Itoa(lw.status)).Inc()
+		m.duration.WithLabelValues(route, r.Method).Observe(time.Since(start).Seconds())
+		m.size.WithLabelValues(route).Add(float64(lw.size))
+	})
+}
+
+// Handler returns the http handler for the "/metrics" endpoint.
+func (m *Metrics) Handler() http.HandlerFunc {
+	return promhttp.HandlerFor(m.Registry, promhttp.HandlerOpts{}).ServeHTTP
+}
@@ -2,16 +2,106 @@ package middleware
 
 import (
 	"net/http"
+	"strconv"
+	"strings"
 )
 
-// Cors sets cors headers to handles preflight requests.
-func Cors(next http.HandlerFunc) http.HandlerFunc {
+// CorsOptions are the options for the Cors middleware. The "AllowedOrigins" are the origins, which are allowed to
+// access the server from a browser. The special origin "*" allows all origins, but it is ignored when
+// "AllowCredentials" is set, because browsers do not accept a wildcard for requests with credentials. If no origins are
+// configured, no cors headers are set, which is the default for the mobile and desktop builds.
+type CorsOptions struct {
+	AllowedOrigins   []string `json:"allowedOrigins"`
+	AllowCredentials bool     `json:"allowCredentials"`
+	MaxAge           int      `json:"maxAge"`
+}
+
+// corsAllowedHeaders are the headers, which can be used by the frontend. Besides the standard headers these are our
+// custom headers for the cluster credentials and the auth token.
+var corsAllowedHeaders = strings.Join([]string{
+	"Accept",
+	"Content-Type",
+	"If-None-Match",
+	"Last-Event-ID",
+	AuthTokenHeader,
+	"X-CONTEXT-NAME",
+	"X-CLUSTER-ID",
+	"X-CLUSTER-SERVER",
+	"X-CLUSTER-CERTIFICATE-AUTHORITY-DATA",
+	"X-CLUSTER-INSECURE-SKIP-TLS-VERIFY",
+	"X-USER-CLIENT-CERTIFICATE-DATA",
+	"X-USER-CLIENT-KEY-DATA",
+	"X-USER-TOKEN",
+	"X-USER-USERNAME",
+	"X-USER-PASSWORD",
+	"X-PROXY",
+}, ", ")
+
+const corsAllowedMethods = "POST, GET, OPTIONS, PUT, DELETE, PATCH"
+
+// Cors sets cors headers to handles preflight requests. The "Access-Control-Allow-Origin" header is only set for the
+// allowed origins from the options. Preflight requests of allowed origins are answered directly, so that they do not
+// require the auth token, all other requests are passed to the next handler.
+func Cors(options CorsOptions, next http.HandlerFunc) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type")
 		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE, PATCH")
+
+		if len(options.AllowedOrigins) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// The response depends on the "Origin" header, so that caches must not reuse it for other origins.
+		w.Header().Add("Vary", "Origin")
+
+		origin := r.Header.Get("Origin")
+		allowOrigin := getAllowOrigin(options, origin)
+		if allowOrigin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("Access-Control-Allow-Origin", allowOrigin)
+		w.Header().Set("Access-Control-Expose-Headers", "ETag, Retry-After, "+RequestIDHeader)
+		if options.AllowCredentials {
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Add("Vary", "Access-Control-Request-Method")
+			w.Header().Add("Vary", "Access-Control-Request-Headers")
+			w.Header().Set("Access-Control-Allow-Methods", corsAllowedMethods)
+			w.Header().Set("Access-Control-Allow-Headers", corsAllowedHeaders)
+			if options.MaxAge > 0 {
+				w.Header().Set("Access-Control-Max-Age", strconv.Itoa(options.MaxAge))
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
 
 		next.ServeHTTP(w, r)
 	})
 }
+
+// getAllowOrigin returns the value for the "Access-Control-Allow-Origin" header for the given origin. If the origin
+// isn't allowed an empty string is returned.
+func getAllowOrigin(options CorsOptions, origin string) string {
+	if origin == "" {
+		return ""
+	}
+
+	for _, allowedOrigin := range options.AllowedOrigins {
+		if allowedOrigin == "*" {
+			if !options.AllowCredentials {
+				return "*"
+			}
+			continue
+		}
+
+		if strings.EqualFold(origin, allowedOrigin) {
+			return origin
+		}
+	}
+
+	return ""
+}
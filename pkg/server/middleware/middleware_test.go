@@ -0,0 +1,198 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCors(t *testing.T) {
+	allowed := CorsOptions{AllowedOrigins: []string{"https://app.kubenav.io", "http://localhost:3000"}, MaxAge: 600}
+
+	for _, tc := range []struct {
+		name                 string
+		options              CorsOptions
+		method               string
+		origin               string
+		requestMethod        string
+		expectedStatusCode   int
+		expectedAllowOrigin  string
+		expectedCredentials  bool
+		expectedPreflight    bool
+		expectedVary         []string
+		expectedNextHandler  bool
+		expectedMaxAge       string
+		expectedExposeHeader bool
+	}{
+		{
+			name:                 "allowed origin",
+			options:              allowed,
+			method:               http.MethodGet,
+			origin:               "https://app.kubenav.io",
+			expectedStatusCode:   http.StatusOK,
+			expectedAllowOrigin:  "https://app.kubenav.io",
+			expectedVary:         []string{"Origin"},
+			expectedNextHandler:  true,
+			expectedExposeHeader: true,
+		},
+		{
+			name:                 "allowed origin is case insensitive",
+			options:              allowed,
+			method:               http.MethodGet,
+			origin:               "HTTP://LOCALHOST:3000",
+			expectedStatusCode:   http.StatusOK,
+			expectedAllowOrigin:  "HTTP://LOCALHOST:3000",
+			expectedVary:         []string{"Origin"},
+			expectedNextHandler:  true,
+			expectedExposeHeader: true,
+		},
+		{
+			name:                "denied origin",
+			options:             allowed,
+			method:              http.MethodGet,
+			origin:              "https://evil.example.com",
+			expectedStatusCode:  http.StatusOK,
+			expectedVary:        []string{"Origin"},
+			expectedNextHandler: true,
+		},
+		{
+			name:                "without origin",
+			options:             allowed,
+			method:              http.MethodGet,
+			expectedStatusCode:  http.StatusOK,
+			expectedVary:        []string{"Origin"},
+			expectedNextHandler: true,
+		},
+		{
+			name:                "no allowed origins",
+			options:             CorsOptions{},
+			method:              http.MethodGet,
+			origin:              "https://app.kubenav.io",
+			expectedStatusCode:  http.StatusOK,
+			expectedNextHandler: true,
+		},
+		{
+			name:                 "preflight of allowed origin",
+			options:              allowed,
+			method:               http.MethodOptions,
+			origin:               "https://app.kubenav.io",
+			requestMethod:        http.MethodDelete,
+			expectedStatusCode:   http.StatusNoContent,
+			expectedAllowOrigin:  "https://app.kubenav.io",
+			expectedPreflight:    true,
+			expectedVary:         []string{"Origin", "Access-Control-Request-Method", "Access-Control-Request-Headers"},
+			expectedMaxAge:       "600",
+			expectedExposeHeader: true,
+		},
+		{
+			// Preflight requests of denied origins are passed to the next handler, which rejects them, because they do
+			// not contain the auth token.
+			name:                "preflight of denied origin",
+			options:             allowed,
+			method:              http.MethodOptions,
+			origin:              "https://evil.example.com",
+			requestMethod:       http.MethodDelete,
+			expectedStatusCode:  http.StatusOK,
+			expectedVary:        []string{"Origin"},
+			expectedNextHandler: true,
+		},
+		{
+			name:                 "options request without request method is not a preflight",
+			options:              allowed,
+			method:               http.MethodOptions,
+			origin:               "https://app.kubenav.io",
+			expectedStatusCode:   http.StatusOK,
+			expectedAllowOrigin:  "https://app.kubenav.io",
+			expectedVary:         []string{"Origin"},
+			expectedNextHandler:  true,
+			expectedExposeHeader: true,
+		},
+		{
+			name:                 "wildcard origin",
+			options:              CorsOptions{AllowedOrigins: []string{"*"}},
+			method:               http.MethodGet,
+			origin:               "https://app.kubenav.io",
+			expectedStatusCode:   http.StatusOK,
+			expectedAllowOrigin:  "*",
+			expectedVary:         []string{"Origin"},
+			expectedNextHandler:  true,
+			expectedExposeHeader: true,
+		},
+		{
+			name:                "wildcard origin is ignored with credentials",
+			options:             CorsOptions{AllowedOrigins: []string{"*"}, AllowCredentials: true},
+			method:              http.MethodGet,
+			origin:              "https://app.kubenav.io",
+			expectedStatusCode:  http.StatusOK,
+			expectedVary:        []string{"Origin"},
+			expectedNextHandler: true,
+		},
+		{
+			name:                 "allowed origin with credentials",
+			options:              CorsOptions{AllowedOrigins: []string{"*", "https://app.kubenav.io"}, AllowCredentials: true},
+			method:               http.MethodGet,
+			origin:               "https://app.kubenav.io",
+			expectedStatusCode:   http.StatusOK,
+			expectedAllowOrigin:  "https://app.kubenav.io",
+			expectedCredentials:  true,
+			expectedVary:         []string{"Origin"},
+			expectedNextHandler:  true,
+			expectedExposeHeader: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(tc.method, "/api/resources", nil)
+			if tc.origin != "" {
+				r.Header.Set("Origin", tc.origin)
+			}
+			if tc.requestMethod != "" {
+				r.Header.Set("Access-Control-Request-Method", tc.requestMethod)
+			}
+			w := httptest.NewRecorder()
+
+			nextHandler := false
+			Cors(tc.options, func(w http.ResponseWriter, r *http.Request) {
+				nextHandler = true
+				w.WriteHeader(http.StatusOK)
+			})(w, r)
+
+			if w.Code != tc.expectedStatusCode {
+				t.Errorf("expected status code %d, got %d", tc.expectedStatusCode, w.Code)
+			}
+			if nextHandler != tc.expectedNextHandler {
+				t.Errorf("expected next handler to be called %t, got %t", tc.expectedNextHandler, nextHandler)
+			}
+
+			header := w.Header()
+			if actual := header.Get("Access-Control-Allow-Origin"); actual != tc.expectedAllowOrigin {
+				t.Errorf("expected Access-Control-Allow-Origin %q, got %q", tc.expectedAllowOrigin, actual)
+			}
+			if actual := header.Get("Access-Control-Allow-Credentials") == "true"; actual != tc.expectedCredentials {
+				t.Errorf("expected Access-Control-Allow-Credentials %t, got %t", tc.expectedCredentials, actual)
+			}
+			if actual := strings.Join(header.Values("Vary"), ","); actual != strings.Join(tc.expectedVary, ",") {
+				t.Errorf("expected Vary %q, got %q", tc.expectedVary, actual)
+			}
+			if actual := strings.Contains(header.Get("Access-Control-Expose-Headers"), RequestIDHeader); actual != tc.expectedExposeHeader {
+				t.Errorf("expected request id in Access-Control-Expose-Headers %t, got %t", tc.expectedExposeHeader, actual)
+			}
+			if actual := header.Get("Access-Control-Max-Age"); actual != tc.expectedMaxAge {
+				t.Errorf("expected Access-Control-Max-Age %q, got %q", tc.expectedMaxAge, actual)
+			}
+
+			if tc.expectedPreflight {
+				if actual := header.Get("Access-Control-Allow-Methods"); !strings.Contains(actual, tc.requestMethod) {
+					t.Errorf("expected Access-Control-Allow-Methods to contain %s, got %q", tc.requestMethod, actual)
+				}
+				for _, allowedHeader := range []string{AuthTokenHeader, "X-CLUSTER-ID", "X-USER-TOKEN", "Last-Event-ID"} {
+					if actual := header.Get("Access-Control-Allow-Headers"); !strings.Contains(actual, allowedHeader) {
+						t.Errorf("expected Access-Control-Allow-Headers to contain %s, got %q", allowedHeader, actual)
+					}
+				}
+			} else if actual := header.Get("Access-Control-Allow-Methods"); actual != "" {
+				t.Errorf("expected no Access-Control-Allow-Methods, got %q", actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package middleware
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// RateLimitOptions are the options for the RateLimiter. Cheap requests are requests, which are answered by the server
+// itself (e.g. the health check or the list of port forwarding sessions), expensive requests are all requests which
+// are proxied to a cluster or which start a new session. The requests per second define the rate in which the bucket
+// is refilled and the burst the size of the bucket. If the requests per second are 0, requests are not limited.
+type RateLimitOptions struct {
+	CheapRequestsPerSecond     float64 `json:"cheapRequestsPerSecond"`
+	CheapBurst                 int     `json:"cheapBurst"`
+	ExpensiveRequestsPerSecond float64 `json:"expensiveRequestsPerSecond"`
+	ExpensiveBurst             int     `json:"expensiveBurst"`
+}
+
+// RateLimiter limits the requests to the server via two token buckets, one for cheap and one for expensive requests.
+// The buckets are stored as atomic pointers, so that they can be replaced via Update while the server is running.
+type RateLimiter struct {
+	cheap     atomic.Pointer[rate.Limiter]
+	expensive atomic.Pointer[rate.Limiter]
+}
+
+// NewRateLimiter returns a new rate limiter for the given options. When the burst isn't set, the burst is the number
+// of requests per second, but at least one request.
+func NewRateLimiter(options RateLimitOptions) *RateLimiter {
+	l := &RateLimiter{}
+	l.Update(options)
+	return l
+}
+
+// Update replaces the buckets of the rate limiter with new buckets for the given options. The new buckets are full,
+// so that the requests which were already counted are not taken into account.
+func (l *RateLimiter) Update(options RateLimitOptions) {
+	l.cheap.Store(newLimiter(options.CheapRequestsPerSecond, options.CheapBurst))
+	l.expensive.Store(newLimiter(options.ExpensiveRequestsPerSecond, options.ExpensiveBurst))
+}
+
+func newLimiter(requestsPerSecond float64, burst int) *rate.Limiter {
+	if requestsPerSecond <= 0 {
+		return nil
+	}
+
+	if burst <= 0 {
+		burst = int(math.Max(1, math.Ceil(requestsPerSecond)))
+	}
+
+	return rate.NewLimiter(rate.Limit(requestsPerSecond), burst)
+}
+
+// Cheap limits the requests of the next handler via the bucket for cheap requests. WebSocket upgrades always count
+// against the bucket for expensive requests, because they start a long running session.
+func (l *RateLimiter) Cheap(next http.HandlerFunc) http.HandlerFunc {
+	return l.limit(next, func(r *http.Request) bool { return false })
+}
+
+// CheapMethods limits the requests of the next handler with one of the given methods via the bucket for cheap
+// requests and all other requests via the bucket for expensive requests.
+func (l *RateLimiter) CheapMethods(next http.HandlerFunc, methods ...string) http.HandlerFunc {
+	return l.limit(next, func(r *http.Request) bool {
+		for _, method := range methods {
+			if r.Method == method {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// Expensive limits the requests of the next handler via the bucket for expensive requests.
+func (l *RateLimiter) Expensive(next http.HandlerFunc) http.HandlerFunc {
+	return l.limit(next, func(r *http.Request) bool { return true })
+}
+
+// limit returns a handler, which takes a token from the bucket for cheap or expensive requests, before the request is
+// passed to the next handler. If the bucket is empty, the request is rejected with a "429 Too Many Requests" error and
+// the "Retry-After" header contains the number of seconds until the next token is available.
+func (l *RateLimiter) limit(next http.HandlerFunc, isExpensive func(r *http.Request) bool) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limiter := l.cheap.Load()
+		if isExpensive(r) || isWebSocketUpgrade(r) {
+			limiter = l.expensive.Load()
+		}
+
+		if limiter == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		now := time.Now()
+		reservation := limiter.ReserveN(now, 1)
+		if delay := reservation.DelayFrom(now); !reservation.OK() || delay > 0 {
+			reservation.CancelAt(now)
+
+			retryAfter := int(math.Ceil(delay.Seconds()))
+			if retryAfter < 1 {
+				retryAfter = 1
+			}
+
+			err := fmt.Errorf("rate limit exceeded")
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+			Errorf(w, r, err, http.StatusTooManyRequests, fmt.Sprintf("Too many requests: %s, retry after %d seconds", err.Error(), retryAfter))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func isWebSocketUpgrade(r *http.Request) bool {
+	return strings.EqualFold(r.Header.Get("Upgrade"), "websocket")
+}
@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+)
+
+// RequestIDHeader is the header, which contains the id of a request. If a client sends the header, the id from the
+// client is used, otherwise a new id is generated. The id is always returned in the header of the response.
+const RequestIDHeader = "X-REQUEST-ID"
+
+// maxRequestIDLength is the maximum length of a request id, which is accepted from a client.
+const maxRequestIDLength = 128
+
+type requestIDKey struct{}
+
+// RequestID assigns an id to each request, which is stored in the context of the request, so that it can be added to
+// the error responses, the request log and the sessions, which are created by the request.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(RequestIDHeader)
+		if !isValidRequestID(requestID) {
+			requestID = newRequestID()
+		}
+
+		w.Header().Set(RequestIDHeader, requestID)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, requestID)))
+	})
+}
+
+// GetRequestID returns the id of the request from the given context. If the context doesn't contain a request id, an
+// empty string is returned.
+func GetRequestID(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey{}).(string)
+	return requestID
+}
+
+// isValidRequestID checks that a request id from a client only contains safe characters, so that it can be added to
+// the response headers and log lines without escaping.
+func isValidRequestID(requestID string) bool {
+	if requestID == "" || len(requestID) > maxRequestIDLength {
+		return false
+	}
+
+	for _, c := range requestID {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+
+	return true
+}
+
+func newRequestID() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
@@ -1,23 +1,59 @@
 package middleware
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
+	"log"
 	"net/http"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
-// Error represents the structure of an error message.
+// Error represents the structure of an error message. The Code is a machine-readable code (e.g. "unauthorized" or
+// "session-limit"), so that the frontend doesn't have to match the message. The Details contain the status of the
+// Kubernetes API, when the error was returned by the Kubernetes API.
+//
+// The Error and StatusCode fields are only returned for compatibility with older versions of the frontend and will be
+// removed in the next release. The status code is also available via the http status of the response.
 type Error struct {
-	Error   bool   `json:"error"`
-	Code    int    `json:"statusCode"`
-	Message string `json:"message"`
+	Code       string         `json:"code"`
+	Message    string         `json:"message"`
+	Details    *metav1.Status `json:"details,omitempty"`
+	RequestID  string         `json:"requestID,omitempty"`
+	Error      bool           `json:"error"`
+	StatusCode int            `json:"statusCode"`
 }
 
-// Errorf return an new error response.
+// Errorf return an new error response. The credentials of the user are redacted from the message via ScrubRequest,
+// because the errors of client-go can contain the request url or the server with the userinfo. The scrubbed message is
+// also added to the request log. The original error is only logged, when this is enabled via LogOriginalErrors.
 func Errorf(w http.ResponseWriter, r *http.Request, err error, code int, message string) {
+	message = ScrubRequest(r, message)
+
+	if err != nil && r != nil && logOriginalErrors.Load() {
+		log.Printf("Original error for %s %s: %s", r.Method, r.URL.Path, err.Error())
+	}
+	if r != nil {
+		setLogError(r, message)
+	}
+
+	errorCode, status := getErrorCode(err, code)
+	if status != nil {
+		scrubStatus(r, status)
+	}
+
 	errorMessage := Error{
-		Error:   true,
-		Code:    code,
-		Message: message,
+		Code:       errorCode,
+		Message:    message,
+		Details:    status,
+		Error:      true,
+		StatusCode: code,
+	}
+	if r != nil {
+		errorMessage.RequestID = GetRequestID(r.Context())
 	}
 
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
@@ -26,10 +62,74 @@ func Errorf(w http.ResponseWriter, r *http.Request, err error, code int, message
 	return
 }
 
-// Write return a new json response.
+// scrubStatus redacts the credentials from the message, the name and the causes of a Kubernetes status. The details
+// are copied before they are scrubbed, because they are shared with the original error.
+func scrubStatus(r *http.Request, status *metav1.Status) {
+	status.Message = ScrubRequest(r, status.Message)
+
+	if status.Details != nil {
+		details := *status.Details
+		details.Name = ScrubRequest(r, details.Name)
+		details.Causes = append([]metav1.StatusCause(nil), details.Causes...)
+		for i := range details.Causes {
+			details.Causes[i].Message = ScrubRequest(r, details.Causes[i].Message)
+		}
+		status.Details = &details
+	}
+}
+
+// Write return a new json response. For GET requests an ETag is generated from the hash of the serialized body, so
+// that it changes whenever the content of the response changes. If the ETag matches the "If-None-Match" header of the
+// request, a "304 Not Modified" response without a body is returned, so that polling clients do not have to parse the
+// same response again.
 func Write(w http.ResponseWriter, r *http.Request, data interface{}) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(&data)
+		return
+	}
+
+	body, err := json.Marshal(&data)
+	if err != nil {
+		Errorf(w, r, err, http.StatusInternalServerError, fmt.Sprintf("Could not encode response: %s", err.Error()))
+		return
+	}
+	body = append(body, '\n')
+
+	etag := generateETag(body)
+	w.Header().Set("ETag", etag)
+	if matchesETag(r.Header.Get("If-None-Match"), etag) {
+		w.Header().Del("Content-Type")
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(&data)
-	return
+	w.Write(body)
+}
+
+// generateETag returns a weak ETag for the given body. The ETag is weak, because the response can be compressed by the
+// Gzip middleware.
+func generateETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return fmt.Sprintf("W/\"%s\"", hex.EncodeToString(sum[:16]))
+}
+
+// matchesETag returns true, when the value of the "If-None-Match" header contains the given ETag or "*". The
+// comparison is weak, so that a strong ETag of the client matches our weak ETag.
+func matchesETag(ifNoneMatch, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+
+	for _, value := range strings.Split(ifNoneMatch, ",") {
+		value = strings.TrimSpace(value)
+		if value == "*" || strings.TrimPrefix(value, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+
+	return false
 }
@@ -0,0 +1,339 @@
+// Package namespaces implements the deletion of namespaces, together with a diagnosis why a namespace is stuck in the
+// Terminating state. The diagnosis lists all remaining objects in the namespace via the discovery data of the cluster
+// and reports the finalizers, which are blocking the deletion.
+package namespaces
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/kubenav/kubenav/pkg/server/resources"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// The defaults for the interval in which the progress of a deletion is reported and the duration after which the
+// stream is stopped.
+const (
+	DefaultInterval = 5 * time.Second
+	DefaultTimeout  = 10 * time.Minute
+)
+
+// maxObjects is the maximum number of objects, which are reported for each resource. The total number of remaining
+// objects is always reported.
+const maxObjects = 20
+
+// listConcurrency is the number of resources, which are listed in parallel for a diagnosis.
+const listConcurrency = 8
+
+// kubernetesFinalizer is the finalizer of the namespace controller, which is removed via the finalize subresource.
+const kubernetesFinalizer = corev1.FinalizerKubernetes
+
+// ErrInvalidOptions is returned when the options are invalid or the removal of the finalizer wasn't confirmed.
+var ErrInvalidOptions = fmt.Errorf("%w", resources.ErrInvalidOptions)
+
+// The operations of a Message.
+//
+// OP        FIELD(S) USED     DESCRIPTION
+// ---------------------------------------------------------------------
+// progress  Status            The namespace still exists, the status contains the remaining objects
+// deleted   Status            The namespace was removed
+// timeout   Status, Message   The namespace wasn't removed within the timeout
+// error     Message           The status of the namespace could not be fetched
+//
+// All operations except "progress" are final, after them the stream is closed.
+const (
+	OpProgress = "progress"
+	OpDeleted  = "deleted"
+	OpTimeout  = "timeout"
+	OpError    = "error"
+)
+
+// Message is a single message of the stream of a namespace deletion.
+type Message struct {
+	Op      string  `json:"op"`
+	Name    string  `json:"name"`
+	Status  *Status `json:"status,omitempty"`
+	Message string  `json:"message,omitempty"`
+}
+
+// Options are the options for the deletion of a namespace. "Finalize" removes the "kubernetes" finalizer of the
+// namespace via the finalize subresource, which orphans all remaining objects in etcd, so that "IUnderstand" must be
+// true as well.
+type Options struct {
+	Name        string `json:"name"`
+	Finalize    bool   `json:"finalize"`
+	IUnderstand bool   `json:"iUnderstand"`
+}
+
+// StreamOptions are the options to stream the progress of a namespace deletion.
+type StreamOptions struct {
+	Name     string
+	Interval time.Duration
+	Timeout  time.Duration
+}
+
+// StreamOptionsFromQuery returns the options from the "name", "interval" and "timeout" query parameters. The interval
+// and timeout must be provided in seconds.
+func StreamOptionsFromQuery(query url.Values) (StreamOptions, error) {
+	options := StreamOptions{
+		Name:     query.Get("name"),
+		Interval: DefaultInterval,
+		Timeout:  DefaultTimeout,
+	}
+
+	if options.Name == "" {
+		return options, fmt.Errorf("name is required")
+	}
+
+	for param, value := range map[string]*time.Duration{"interval": &options.Interval, "timeout": &options.Timeout} {
+		if raw := query.Get(param); raw != "" {
+			parsed, err := strconv.ParseInt(raw, 10, 64)
+			if err != nil || parsed <= 0 {
+				return options, fmt.Errorf("invalid %s %s", param, raw)
+			}
+			*value = time.Duration(parsed) * time.Second
+		}
+	}
+
+	return options, nil
+}
+
+// Status is the status of a namespace deletion. When "Deleted" is true, the namespace doesn't exist anymore and all
+// other fields are empty. "Errors" contains the resources, which could not be listed, e.g. because the user isn't
+// allowed to list them, so that they could also contain remaining objects.
+type Status struct {
+	Name       string                      `json:"name"`
+	Deleted    bool                        `json:"deleted"`
+	Phase      corev1.NamespacePhase       `json:"phase,omitempty"`
+	Finalizers []corev1.FinalizerName      `json:"finalizers,omitempty"`
+	Conditions []corev1.NamespaceCondition `json:"conditions,omitempty"`
+	Resources  []RemainingResource         `json:"resources,omitempty"`
+	Errors     []string                    `json:"errors,omitempty"`
+}
+
+// RemainingResource is a resource, which still has objects in the namespace. "Count" is the total number of objects,
+// "Objects" contains at most 20 of them.
+type RemainingResource struct {
+	Resource resources.ResourceInfo `json:"resource"`
+	Count    int                    `json:"count"`
+	Objects  []RemainingObject      `json:"objects"`
+}
+
+// RemainingObject is an object, which still exists in the namespace. The finalizers of the object are blocking its
+// deletion, when the deletion timestamp is set.
+type RemainingObject struct {
+	Name              string       `json:"name"`
+	DeletionTimestamp *metav1.Time `json:"deletionTimestamp,omitempty"`
+	Finalizers        []string     `json:"finalizers,omitempty"`
+}
+
+// Delete deletes the namespace from the options and returns the status of the deletion. When "Finalize" is set, the
+// "kubernetes" finalizer is removed afterwards, if the namespace is still terminating.
+func Delete(ctx context.Context, cache *resources.DiscoveryCache, clusterKey string, clientset kubernetes.Interface, options Options) (*Status, error) {
+	if options.Name == "" {
+		return nil, fmt.Errorf("%w: name is required", ErrInvalidOptions)
+	}
+	if options.Finalize && !options.IUnderstand {
+		return nil, fmt.Errorf("%w: removing the %s finalizer orphans all remaining objects of the namespace and must be confirmed", ErrInvalidOptions, kubernetesFinalizer)
+	}
+
+	err := clientset.CoreV1().Namespaces().Delete(ctx, options.Name, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return nil, err
+	}
+
+	if options.Finalize {
+		if err := finalize(ctx, clientset, options.Name); err != nil {
+			return nil, err
+		}
+	}
+
+	return Diagnose(ctx, cache, clusterKey, clientset, options.Name)
+}
+
+// finalize removes the "kubernetes" finalizer from the namespace via the finalize subresource. This is the last resort
+// for a namespace, which is stuck because the namespace controller can not delete the remaining objects, e.g. because
+// an aggregated API isn't available anymore.
+func finalize(ctx context.Context, clientset kubernetes.Interface, name string) error {
+	namespace, err := clientset.CoreV1().Namespaces().Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	if namespace.DeletionTimestamp == nil {
+		return fmt.Errorf("%w: namespace %s is not terminating", ErrInvalidOptions, name)
+	}
+
+	var finalizers []corev1.FinalizerName
+	for _, finalizer := range namespace.Spec.Finalizers {
+		if finalizer != kubernetesFinalizer {
+			finalizers = append(finalizers, finalizer)
+		}
+	}
+	namespace.Spec.Finalizers = finalizers
+
+	_, err = clientset.CoreV1().Namespaces().Finalize(ctx, namespace, metav1.UpdateOptions{FieldManager: resources.FieldManager})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// Diagnose returns the status of the namespace with the given name. For a namespace, which still exists, all namespaced
+// resources of the cluster are listed, to find the objects which are remaining in the namespace.
+func Diagnose(ctx context.Context, cache *resources.DiscoveryCache, clusterKey string, clientset kubernetes.Interface, name string) (*Status, error) {
+	namespace, err := clientset.CoreV1().Namespaces().Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return &Status{Name: name, Deleted: true}, nil
+		}
+		return nil, err
+	}
+
+	status := &Status{
+		Name:       name,
+		Phase:      namespace.Status.Phase,
+		Finalizers: namespace.Spec.Finalizers,
+		Conditions: namespace.Status.Conditions,
+	}
+
+	infos, err := cache.NamespacedResources(clusterKey, clientset)
+	if err != nil {
+		return nil, err
+	}
+
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	semaphore := make(chan struct{}, listConcurrency)
+
+	for _, info := range infos {
+		wg.Add(1)
+		semaphore <- struct{}{}
+
+		go func(info resources.ResourceInfo) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+
+			remaining, err := listRemaining(ctx, cache, clusterKey, clientset, info, name)
+
+			lock.Lock()
+			defer lock.Unlock()
+			if err != nil {
+				status.Errors = append(status.Errors, fmt.Sprintf("%s: %s", info.Resource, err.Error()))
+			} else if remaining != nil {
+				status.Resources = append(status.Resources, *remaining)
+			}
+		}(info)
+	}
+
+	wg.Wait()
+
+	sort.Slice(status.Resources, func(i, j int) bool {
+		return resourceKey(status.Resources[i].Resource) < resourceKey(status.Resources[j].Resource)
+	})
+	sort.Strings(status.Errors)
+
+	return status, nil
+}
+
+// listRemaining lists the metadata of all objects of the resource in the namespace. If there are no objects, nil is
+// returned.
+func listRemaining(ctx context.Context, cache *resources.DiscoveryCache, clusterKey string, clientset kubernetes.Interface, info resources.ResourceInfo, namespace string) (*RemainingResource, error) {
+	list, err := resources.Get(ctx, cache, clusterKey, clientset, resources.ListOptions{
+		Group:     info.Group,
+		Version:   info.Version,
+		Resource:  info.Resource,
+		Namespace: namespace,
+		Light:     resources.LightMetadata,
+	})
+	if err != nil {
+		if apierrors.IsNotFound(err) || apierrors.IsMethodNotSupported(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	if len(list.Items) == 0 {
+		return nil, nil
+	}
+
+	remaining := &RemainingResource{Resource: info, Count: len(list.Items)}
+	for _, item := range list.Items {
+		if len(remaining.Objects) == maxObjects {
+			break
+		}
+
+		var object struct {
+			Metadata metav1.ObjectMeta `json:"metadata"`
+		}
+		if err := json.Unmarshal(item, &object); err != nil {
+			continue
+		}
+
+		remaining.Objects = append(remaining.Objects, RemainingObject{
+			Name:              object.Metadata.Name,
+			DeletionTimestamp: object.Metadata.DeletionTimestamp,
+			Finalizers:        object.Metadata.Finalizers,
+		})
+	}
+
+	return remaining, nil
+}
+
+func resourceKey(info resources.ResourceInfo) string {
+	return info.Group + "/" + info.Resource
+}
+
+// Stream sends the status of the namespace as "progress" message in the interval from the options, until the
+// namespace was removed or the timeout elapses. The stream is stopped, when the context is canceled.
+func Stream(ctx context.Context, cache *resources.DiscoveryCache, clusterKey string, clientset kubernetes.Interface, options StreamOptions, send func(Message) error) error {
+	interval := options.Interval
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	timeout := options.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		status, err := Diagnose(ctx, cache, clusterKey, clientset, options.Name)
+		if err != nil {
+			return err
+		}
+
+		if status.Deleted {
+			return send(Message{Op: OpDeleted, Name: options.Name, Status: status})
+		}
+
+		if err := send(Message{Op: OpProgress, Name: options.Name, Status: status}); err != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+			return send(Message{Op: OpTimeout, Name: options.Name, Status: status, Message: fmt.Sprintf("timed out waiting for the deletion of namespace %s after %s", options.Name, timeout)})
+		case <-ticker.C:
+		}
+	}
+}
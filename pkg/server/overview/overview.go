@@ -0,0 +1,291 @@
+// Package overview implements the aggregation of the overview for a cluster. Instead of sending a dozen list requests
+// from the app to the Kubernetes API, all lists are executed concurrently in the server and only the aggregated counts
+// are returned.
+package overview
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/metadata"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	// listLimit is the number of objects, which are fetched per request.
+	listLimit = 500
+	// eventsWindow is the time window for the warning events.
+	eventsWindow = time.Hour
+	// topEventReasons is the number of warning event reasons, which are returned.
+	topEventReasons = 5
+)
+
+// workloads are the resources, which are counted for the overview. Only the metadata of these resources is fetched.
+var workloads = map[string]schema.GroupVersionResource{
+	"deployments":  {Group: "apps", Version: "v1", Resource: "deployments"},
+	"statefulsets": {Group: "apps", Version: "v1", Resource: "statefulsets"},
+	"daemonsets":   {Group: "apps", Version: "v1", Resource: "daemonsets"},
+	"jobs":         {Group: "batch", Version: "v1", Resource: "jobs"},
+	"cronjobs":     {Group: "batch", Version: "v1", Resource: "cronjobs"},
+	"services":     {Group: "", Version: "v1", Resource: "services"},
+}
+
+// Overview is the aggregated overview of a cluster or namespace. If a single list fails (e.g. because the user isn't
+// allowed to list the resource), the corresponding value is null and the error is added to the Errors field, using
+// the name of the value as key.
+type Overview struct {
+	Workloads     map[string]*int   `json:"workloads"`
+	Pods          map[string]int    `json:"pods"`
+	Nodes         *NodeReadiness    `json:"nodes"`
+	WarningEvents []EventReason     `json:"warningEvents"`
+	Errors        map[string]string `json:"errors,omitempty"`
+}
+
+// NodeReadiness is the number of ready and not ready Nodes.
+type NodeReadiness struct {
+	Ready    int `json:"ready"`
+	NotReady int `json:"notReady"`
+}
+
+// EventReason is the number of warning events with the same reason.
+type EventReason struct {
+	Reason string `json:"reason"`
+	Count  int32  `json:"count"`
+}
+
+// Get returns the overview for the given namespace. If the namespace is empty, the overview for the whole cluster is
+// returned and also contains the readiness of the Nodes.
+func Get(ctx context.Context, config *rest.Config, clientset kubernetes.Interface, namespace string) (*Overview, error) {
+	metadataClient, err := metadata.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	overview := &Overview{
+		Workloads: make(map[string]*int),
+		Errors:    make(map[string]string),
+	}
+
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+
+	setError := func(name string, err error) {
+		lock.Lock()
+		defer lock.Unlock()
+		overview.Errors[name] = err.Error()
+	}
+
+	for name, gvr := range workloads {
+		overview.Workloads[name] = nil
+
+		wg.Add(1)
+		go func(name string, gvr schema.GroupVersionResource) {
+			defer wg.Done()
+
+			count, err := countMetadata(ctx, metadataClient, gvr, namespace)
+			if err != nil {
+				setError(name, err)
+				return
+			}
+
+			lock.Lock()
+			overview.Workloads[name] = &count
+			lock.Unlock()
+		}(name, gvr)
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		phases, err := countPodPhases(ctx, clientset, namespace)
+		if err != nil {
+			setError("pods", err)
+			return
+		}
+
+		lock.Lock()
+		overview.Pods = phases
+		lock.Unlock()
+	}()
+
+	if namespace == "" {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			readiness, err := countNodeReadiness(ctx, clientset)
+			if err != nil {
+				setError("nodes", err)
+				return
+			}
+
+			lock.Lock()
+			overview.Nodes = readiness
+			lock.Unlock()
+		}()
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		reasons, err := topWarningEventReasons(ctx, clientset, namespace)
+		if err != nil {
+			setError("warningEvents", err)
+			return
+		}
+
+		lock.Lock()
+		overview.WarningEvents = reasons
+		lock.Unlock()
+	}()
+
+	wg.Wait()
+
+	if len(overview.Errors) == 0 {
+		overview.Errors = nil
+	}
+
+	return overview, nil
+}
+
+// countMetadata counts the objects of the given resource. Only the metadata of the objects is fetched. If the
+// Kubernetes API returns the number of remaining items, we do not have to fetch all pages.
+func countMetadata(ctx context.Context, client metadata.Interface, gvr schema.GroupVersionResource, namespace string) (int, error) {
+	count := 0
+
+	listOptions := metav1.ListOptions{Limit: listLimit}
+	for {
+		list, err := client.Resource(gvr).Namespace(namespace).List(ctx, listOptions)
+		if err != nil {
+			return 0, err
+		}
+
+		count += len(list.Items)
+
+		if list.RemainingItemCount != nil {
+			return count + int(*list.RemainingItemCount), nil
+		}
+		if list.Continue == "" {
+			return count, nil
+		}
+		listOptions.Continue = list.Continue
+	}
+}
+
+// countPodPhases returns the number of Pods per phase.
+func countPodPhases(ctx context.Context, clientset kubernetes.Interface, namespace string) (map[string]int, error) {
+	phases := make(map[string]int)
+
+	listOptions := metav1.ListOptions{Limit: listLimit}
+	for {
+		list, err := clientset.CoreV1().Pods(namespace).List(ctx, listOptions)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, pod := range list.Items {
+			phases[string(pod.Status.Phase)]++
+		}
+
+		if list.Continue == "" {
+			return phases, nil
+		}
+		listOptions.Continue = list.Continue
+	}
+}
+
+// countNodeReadiness returns the number of ready and not ready Nodes, based on the "Ready" condition of each Node.
+func countNodeReadiness(ctx context.Context, clientset kubernetes.Interface) (*NodeReadiness, error) {
+	readiness := &NodeReadiness{}
+
+	listOptions := metav1.ListOptions{Limit: listLimit}
+	for {
+		list, err := clientset.CoreV1().Nodes().List(ctx, listOptions)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, node := range list.Items {
+			ready := false
+			for _, condition := range node.Status.Conditions {
+				if condition.Type == corev1.NodeReady && condition.Status == corev1.ConditionTrue {
+					ready = true
+					break
+				}
+			}
+
+			if ready {
+				readiness.Ready++
+			} else {
+				readiness.NotReady++
+			}
+		}
+
+		if list.Continue == "" {
+			return readiness, nil
+		}
+		listOptions.Continue = list.Continue
+	}
+}
+
+// topWarningEventReasons returns the reasons of the warning events from the last hour, with the highest number of
+// events.
+func topWarningEventReasons(ctx context.Context, clientset kubernetes.Interface, namespace string) ([]EventReason, error) {
+	counts := make(map[string]int32)
+	since := time.Now().Add(-eventsWindow)
+
+	listOptions := metav1.ListOptions{FieldSelector: "type=Warning", Limit: listLimit}
+	for {
+		list, err := clientset.CoreV1().Events(namespace).List(ctx, listOptions)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, event := range list.Items {
+			lastTimestamp := event.LastTimestamp.Time
+			if lastTimestamp.IsZero() {
+				lastTimestamp = event.EventTime.Time
+			}
+			if lastTimestamp.Before(since) {
+				continue
+			}
+
+			count := event.Count
+			if count == 0 {
+				count = 1
+			}
+			counts[event.Reason] += count
+		}
+
+		if list.Continue == "" {
+			break
+		}
+		listOptions.Continue = list.Continue
+	}
+
+	reasons := make([]EventReason, 0, len(counts))
+	for reason, count := range counts {
+		reasons = append(reasons, EventReason{Reason: reason, Count: count})
+	}
+
+	sort.Slice(reasons, func(i, j int) bool {
+		if reasons[i].Count == reasons[j].Count {
+			return reasons[i].Reason < reasons[j].Reason
+		}
+		return reasons[i].Count > reasons[j].Count
+	})
+
+	if len(reasons) > topEventReasons {
+		reasons = reasons[:topEventReasons]
+	}
+
+	return reasons, nil
+}
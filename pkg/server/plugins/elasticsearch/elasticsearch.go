@@ -0,0 +1,361 @@
+// Package elasticsearch implements the Elasticsearch plugin, which searches the logs stored in an Elasticsearch or
+// OpenSearch cluster. The cluster can run inside of the Kubernetes cluster or it can be accessed via its address.
+package elasticsearch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/kubenav/kubenav/pkg/server/plugins"
+)
+
+// The name of the plugin, which is used in the "Plugins" option of the server and the prefix of the port forwarding
+// sessions.
+const (
+	Name          = "elasticsearch"
+	SessionPrefix = "plugin_elasticsearch_"
+)
+
+// The limits for the number of returned hits.
+const (
+	DefaultSize = 100
+	MaxSize     = 1000
+)
+
+// defaultTimestampField is the field, which is used for the time range and the sorting of the hits.
+const defaultTimestampField = "@timestamp"
+
+// timeout is the maximum duration of a search, including the retrieval of the cluster health.
+const timeout = 30 * time.Second
+
+// maxResponseSize is the maximum size of a response of Elasticsearch.
+const maxResponseSize = 32 * 1024 * 1024
+
+// maxReasonLength is the maximum length of a response body, which is used as reason for an error. This is used for
+// errors, which are not returned by Elasticsearch (e.g. an error page of a reverse proxy).
+const maxReasonLength = 512
+
+// The errors for the common failures of a search. The errors wrap the errors of the plugins package and they are
+// wrapped again, so that they contain the reason returned by Elasticsearch.
+var (
+	ErrIndexNotFound      = fmt.Errorf("index %w", plugins.ErrNotFound)
+	ErrUnauthorized       = plugins.ErrUnauthorized
+	ErrForbidden          = plugins.ErrForbidden
+	ErrClusterUnavailable = fmt.Errorf("cluster %w", plugins.ErrUnavailable)
+	ErrTimeout            = fmt.Errorf("search %w", plugins.ErrTimeout)
+	ErrInvalidQuery       = plugins.ErrInvalidQuery
+)
+
+// Candidates are the common installations of Elasticsearch and OpenSearch, which are used for the autodetection. The
+// candidates are checked in the given order.
+var Candidates = []plugins.Candidate{
+	// Elastic Cloud on Kubernetes (ECK)
+	{LabelSelector: "common.k8s.elastic.co/type=elasticsearch", PortNames: []string{"https", "http"}, Scheme: "https"},
+	// elasticsearch Helm chart of Elastic
+	{LabelSelector: "app=elasticsearch-master", PortNames: []string{"http"}, Scheme: "https"},
+	// opensearch Helm chart
+	{LabelSelector: "app.kubernetes.io/name=opensearch", PortNames: []string{"http"}, Scheme: "https"},
+}
+
+// SearchRequest is a request to search the documents of the given index pattern (e.g. "logs-*") in a time range. The
+// "start" and "end" can be dates or date math expressions of Elasticsearch (e.g. "now-15m"). The documents can be
+// filtered via a Lucene "query" string or a "rawQuery" in the query DSL of Elasticsearch, but not both. The next page
+// is requested by setting "searchAfter" to the value returned with the previous page.
+//
+// If the "address" is set, Elasticsearch is accessed directly via this address, otherwise it is accessed inside the
+// cluster. The "credentials" are used for both ways.
+type SearchRequest struct {
+	Address        string               `json:"address"`
+	Credentials    *plugins.Credentials `json:"credentials"`
+	Index          string               `json:"index"`
+	Query          string               `json:"query"`
+	RawQuery       json.RawMessage      `json:"rawQuery"`
+	Start          string               `json:"start"`
+	End            string               `json:"end"`
+	TimestampField string               `json:"timestampField"`
+	Size           int                  `json:"size"`
+	Ascending      bool                 `json:"ascending"`
+	SearchAfter    json.RawMessage      `json:"searchAfter"`
+}
+
+// SearchResult is the result of a search. The "total" is the number of matching documents, when the "totalRelation"
+// is "gte" it is a lower bound. The "searchAfter" value is only set, when there could be more hits.
+type SearchResult struct {
+	Took          int64           `json:"took"`
+	TimedOut      bool            `json:"timedOut"`
+	Total         int64           `json:"total"`
+	TotalRelation string          `json:"totalRelation"`
+	Hits          []Hit           `json:"hits"`
+	SearchAfter   json.RawMessage `json:"searchAfter,omitempty"`
+	ShardFailures int64           `json:"shardFailures,omitempty"`
+}
+
+// Hit is a single document of a search result.
+type Hit struct {
+	Index  string          `json:"index"`
+	ID     string          `json:"id"`
+	Source json.RawMessage `json:"source"`
+	Sort   json.RawMessage `json:"sort,omitempty"`
+}
+
+// searchResponse is the format of a response of the "_search" API.
+type searchResponse struct {
+	Took     int64 `json:"took"`
+	TimedOut bool  `json:"timed_out"`
+	Shards   struct {
+		Failed int64 `json:"failed"`
+	} `json:"_shards"`
+	Hits struct {
+		Total struct {
+			Value    int64  `json:"value"`
+			Relation string `json:"relation"`
+		} `json:"total"`
+		Hits []struct {
+			Index  string          `json:"_index"`
+			ID     string          `json:"_id"`
+			Source json.RawMessage `json:"_source"`
+			Sort   json.RawMessage `json:"sort"`
+		} `json:"hits"`
+	} `json:"hits"`
+}
+
+// errorResponse is the format of an error of Elasticsearch.
+type errorResponse struct {
+	Error struct {
+		Type      string `json:"type"`
+		Reason    string `json:"reason"`
+		RootCause []struct {
+			Type   string `json:"type"`
+			Reason string `json:"reason"`
+		} `json:"root_cause"`
+	} `json:"error"`
+	Status int `json:"status"`
+}
+
+// Validate validates the request and sets the default values.
+func (r *SearchRequest) Validate() error {
+	if r.Index == "" {
+		return fmt.Errorf("index is required")
+	}
+	if strings.ContainsAny(r.Index, "/ \"\\?#") || strings.HasPrefix(r.Index, "_") {
+		return fmt.Errorf("invalid index %s", r.Index)
+	}
+
+	if r.Query != "" && len(r.RawQuery) > 0 {
+		return fmt.Errorf("query and rawQuery can not be used together")
+	}
+	if len(r.RawQuery) > 0 && !json.Valid(r.RawQuery) {
+		return fmt.Errorf("rawQuery must be valid JSON")
+	}
+	if len(r.SearchAfter) > 0 && !json.Valid(r.SearchAfter) {
+		return fmt.Errorf("searchAfter must be valid JSON")
+	}
+
+	if r.TimestampField == "" {
+		r.TimestampField = defaultTimestampField
+	}
+	if r.Size <= 0 {
+		r.Size = DefaultSize
+	}
+	if r.Size > MaxSize {
+		r.Size = MaxSize
+	}
+
+	return nil
+}
+
+// buildQuery returns the body for the "_search" API. The hits are sorted by the timestamp field and the "_doc" field,
+// which is used as tiebreaker for documents with the same timestamp, so that the pagination via "search_after" doesn't
+// skip documents.
+func (r *SearchRequest) buildQuery() ([]byte, error) {
+	timeRange := map[string]interface{}{}
+	if r.Start != "" {
+		timeRange["gte"] = r.Start
+	}
+	if r.End != "" {
+		timeRange["lte"] = r.End
+	}
+
+	filter := []interface{}{}
+	if len(timeRange) > 0 {
+		filter = append(filter, map[string]interface{}{"range": map[string]interface{}{r.TimestampField: timeRange}})
+	}
+
+	must := []interface{}{}
+	if r.Query != "" {
+		must = append(must, map[string]interface{}{"query_string": map[string]interface{}{"query": r.Query}})
+	}
+	if len(r.RawQuery) > 0 {
+		must = append(must, r.RawQuery)
+	}
+
+	order := "desc"
+	if r.Ascending {
+		order = "asc"
+	}
+
+	body := map[string]interface{}{
+		"size":             r.Size,
+		"track_total_hits": true,
+		"query":            map[string]interface{}{"bool": map[string]interface{}{"filter": filter, "must": must}},
+		"sort": []interface{}{
+			map[string]interface{}{r.TimestampField: map[string]interface{}{"order": order, "unmapped_type": "date"}},
+			map[string]interface{}{"_doc": map[string]interface{}{"order": order}},
+		},
+	}
+	if len(r.SearchAfter) > 0 {
+		body["search_after"] = r.SearchAfter
+	}
+
+	return json.Marshal(body)
+}
+
+// Search executes the search request against the "_search" API of Elasticsearch. The search is canceled, when the
+// given context is canceled or the timeout is exceeded. Errors of Elasticsearch are mapped to
+// the errors of this package, when the cluster isn't available the health of the cluster is added to the error.
+func Search(ctx context.Context, client *plugins.Client, request SearchRequest) (*SearchResult, error) {
+	if err := request.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidQuery, err.Error())
+	}
+
+	body, err := request.buildQuery()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	header := http.Header{}
+	header.Set("Accept", "application/json")
+	header.Set("Content-Type", "application/json")
+
+	query := url.Values{}
+	query.Set("timeout", timeout.String())
+
+	data, statusCode, err := do(ctx, client, http.MethodPost, "/"+request.Index+"/_search", query, header, body)
+	if err != nil {
+		return nil, err
+	}
+	if statusCode >= http.StatusBadRequest {
+		err := searchError(statusCode, data)
+		if errors.Is(err, ErrClusterUnavailable) {
+			if status := clusterHealth(ctx, client); status != "" {
+				err = fmt.Errorf("%w (cluster health is %s)", err, status)
+			}
+		}
+		return nil, err
+	}
+
+	var response searchResponse
+	if err := json.Unmarshal(data, &response); err != nil {
+		return nil, fmt.Errorf("could not decode response: %w", err)
+	}
+
+	result := &SearchResult{
+		Took:          response.Took,
+		TimedOut:      response.TimedOut,
+		Total:         response.Hits.Total.Value,
+		TotalRelation: response.Hits.Total.Relation,
+		Hits:          make([]Hit, 0, len(response.Hits.Hits)),
+		ShardFailures: response.Shards.Failed,
+	}
+
+	for _, hit := range response.Hits.Hits {
+		result.Hits = append(result.Hits, Hit{Index: hit.Index, ID: hit.ID, Source: hit.Source, Sort: hit.Sort})
+	}
+	if len(response.Hits.Hits) == request.Size {
+		result.SearchAfter = response.Hits.Hits[len(response.Hits.Hits)-1].Sort
+	}
+
+	return result, nil
+}
+
+// do sends the request to Elasticsearch and returns the body and the status code of the response.
+func do(ctx context.Context, client *plugins.Client, method, requestPath string, query url.Values, header http.Header, body []byte) ([]byte, int, error) {
+	resp, err := client.Do(ctx, method, requestPath, query, header, body)
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, 0, fmt.Errorf("%w: %s", ErrTimeout, err.Error())
+		}
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize+1))
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(data) > maxResponseSize {
+		return nil, 0, fmt.Errorf("response exceeds the maximum size of %d bytes", maxResponseSize)
+	}
+
+	return data, resp.StatusCode, nil
+}
+
+// searchError maps an error response of Elasticsearch to one of the errors of this package. The reason of the error is
+// taken from the root cause, because it is more specific than the reason of the error (e.g. "all shards failed").
+func searchError(statusCode int, data []byte) error {
+	var response errorResponse
+	json.Unmarshal(data, &response)
+
+	errorType := response.Error.Type
+	reason := response.Error.Reason
+	if len(response.Error.RootCause) > 0 && response.Error.RootCause[0].Reason != "" {
+		errorType = response.Error.RootCause[0].Type
+		reason = response.Error.RootCause[0].Reason
+	}
+	if reason == "" {
+		reason = strings.TrimSpace(string(data))
+		if len(reason) > maxReasonLength {
+			reason = reason[:maxReasonLength] + "..."
+		}
+	}
+	if reason == "" {
+		reason = http.StatusText(statusCode)
+	}
+
+	switch {
+	case errorType == "index_not_found_exception":
+		return fmt.Errorf("%w: %s", ErrIndexNotFound, reason)
+	case statusCode == http.StatusUnauthorized:
+		return fmt.Errorf("%w: %s", ErrUnauthorized, reason)
+	case statusCode == http.StatusForbidden:
+		return fmt.Errorf("%w: %s", ErrForbidden, reason)
+	case statusCode == http.StatusServiceUnavailable || errorType == "no_shard_available_action_exception" || errorType == "cluster_block_exception":
+		return fmt.Errorf("%w: %s", ErrClusterUnavailable, reason)
+	case statusCode == http.StatusGatewayTimeout || errorType == "timeout_exception":
+		return fmt.Errorf("%w: %s", ErrTimeout, reason)
+	case statusCode == http.StatusBadRequest:
+		return fmt.Errorf("%w: %s", ErrInvalidQuery, reason)
+	default:
+		return fmt.Errorf("search failed with status code %d: %s", statusCode, reason)
+	}
+}
+
+// clusterHealth returns the health status of the cluster ("green", "yellow" or "red"). If the health can not be
+// retrieved, an empty string is returned.
+func clusterHealth(ctx context.Context, client *plugins.Client) string {
+	header := http.Header{}
+	header.Set("Accept", "application/json")
+
+	data, statusCode, err := do(ctx, client, http.MethodGet, "/_cluster/health", nil, header, nil)
+	if err != nil || statusCode != http.StatusOK {
+		return ""
+	}
+
+	var health struct {
+		Status string `json:"status"`
+	}
+	if err := json.Unmarshal(data, &health); err != nil {
+		return ""
+	}
+	return health.Status
+}
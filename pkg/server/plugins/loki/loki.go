@@ -0,0 +1,360 @@
+// Package loki implements the Loki plugin, which queries the logs stored in Loki via LogQL. Loki can run inside of the
+// Kubernetes cluster or it can be accessed via its address.
+package loki
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kubenav/kubenav/pkg/server/plugins"
+)
+
+// The name of the plugin, which is used in the "Plugins" option of the server and the prefix of the port forwarding
+// sessions.
+const (
+	Name          = "loki"
+	SessionPrefix = "plugin_loki_"
+)
+
+// orgIDHeader is the header, which selects the tenant in a multi-tenant Loki installation.
+const orgIDHeader = "X-Scope-OrgID"
+
+// The limits for the number of returned log lines.
+const (
+	DefaultLimit = 1000
+	MaxLimit     = 5000
+)
+
+// timeout is the maximum duration of a request to Loki.
+const timeout = 30 * time.Second
+
+// maxResponseSize is the maximum size of a response of Loki.
+const maxResponseSize = 32 * 1024 * 1024
+
+// maxReasonLength is the maximum length of a response body, which is used as reason for an error.
+const maxReasonLength = 512
+
+// Candidates are the common installations of Loki, which are used for the autodetection. The candidates are checked in
+// the given order.
+var Candidates = []plugins.Candidate{
+	// Gateway of the loki Helm chart of Grafana
+	{LabelSelector: "app.kubernetes.io/name=loki,app.kubernetes.io/component=gateway", PortNames: []string{"http-metrics", "http"}},
+	// Single binary deployment of the loki Helm chart of Grafana
+	{LabelSelector: "app.kubernetes.io/name=loki,app.kubernetes.io/component=single-binary", PortNames: []string{"http-metrics"}},
+	// loki-stack Helm chart of Grafana
+	{LabelSelector: "app=loki,release", PortNames: []string{"http-metrics"}},
+}
+
+// Connection describes how Loki is accessed. If the "address" is set, Loki is accessed directly via this address,
+// otherwise it is accessed inside the cluster. The "orgID" is send as "X-Scope-OrgID" header, to select the tenant in
+// a multi-tenant installation.
+type Connection struct {
+	Address     string               `json:"address"`
+	Credentials *plugins.Credentials `json:"credentials"`
+	OrgID       string               `json:"orgID"`
+}
+
+// Configure sets the tenant of the connection for the given client.
+func (c Connection) Configure(client *plugins.Client) {
+	if c.OrgID != "" {
+		client.SetHeader(orgIDHeader, c.OrgID)
+	}
+}
+
+// QueryRequest is a request to execute a LogQL log query in a time range. The "start" and "end" are passed to Loki as
+// they are, so that they can be unix timestamps in nanoseconds or RFC3339 dates. The "direction" is "backward" (newest
+// lines first) or "forward".
+type QueryRequest struct {
+	Connection
+	Query     string `json:"query"`
+	Start     string `json:"start"`
+	End       string `json:"end"`
+	Limit     int    `json:"limit"`
+	Direction string `json:"direction"`
+}
+
+// LabelsRequest is a request to get the names of all labels or, when the "name" is set, the values of a label. The
+// optional "query" is a stream selector to get only the labels of the matching streams.
+type LabelsRequest struct {
+	Connection
+	Name  string `json:"name"`
+	Query string `json:"query"`
+	Start string `json:"start"`
+	End   string `json:"end"`
+}
+
+// SeriesRequest is a request to get the label sets of all streams, which match one of the given stream selectors.
+type SeriesRequest struct {
+	Connection
+	Match []string `json:"match"`
+	Start string   `json:"start"`
+	End   string   `json:"end"`
+}
+
+// Result are the log lines of all streams of a query. The lines of all streams are merged and sorted by their
+// timestamp, each line references the labels of its stream via the index in "streams".
+type Result struct {
+	Streams []map[string]string `json:"streams"`
+	Entries []Entry             `json:"entries"`
+}
+
+// Entry is a single log line. The timestamp is a unix timestamp in nanoseconds as returned by Loki.
+type Entry struct {
+	Timestamp string `json:"timestamp"`
+	Stream    int    `json:"stream"`
+	Line      string `json:"line"`
+}
+
+// stream is a stream in the responses of Loki.
+type stream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+// Validate validates the request and sets the default values.
+func (r *QueryRequest) Validate() error {
+	if r.Query == "" {
+		return fmt.Errorf("query is required")
+	}
+
+	if r.Limit <= 0 {
+		r.Limit = DefaultLimit
+	}
+	if r.Limit > MaxLimit {
+		r.Limit = MaxLimit
+	}
+
+	if r.Direction == "" {
+		r.Direction = "backward"
+	}
+	if r.Direction != "backward" && r.Direction != "forward" {
+		return fmt.Errorf("invalid direction %s", r.Direction)
+	}
+
+	return nil
+}
+
+// QueryRange executes the log query against the "/loki/api/v1/query_range" endpoint of Loki. Metric queries are
+// rejected, because they do not return log lines.
+func QueryRange(ctx context.Context, client *plugins.Client, request QueryRequest) (*Result, error) {
+	if err := request.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %s", plugins.ErrInvalidQuery, err.Error())
+	}
+
+	query := url.Values{}
+	query.Set("query", request.Query)
+	query.Set("limit", strconv.Itoa(request.Limit))
+	query.Set("direction", request.Direction)
+	setTimeRange(query, request.Start, request.End)
+
+	var response struct {
+		Data struct {
+			ResultType string          `json:"resultType"`
+			Result     json.RawMessage `json:"result"`
+		} `json:"data"`
+	}
+	if err := get(ctx, client, "/loki/api/v1/query_range", query, &response); err != nil {
+		return nil, err
+	}
+
+	if response.Data.ResultType != "streams" {
+		return nil, fmt.Errorf("%w: only log queries are supported, the query returned a %s", plugins.ErrInvalidQuery, response.Data.ResultType)
+	}
+
+	var streams []stream
+	if err := json.Unmarshal(response.Data.Result, &streams); err != nil {
+		return nil, fmt.Errorf("could not decode response: %w", err)
+	}
+
+	return mergeStreams(streams, request.Direction == "forward"), nil
+}
+
+// Labels returns the names of all labels or the values of the label from the request.
+func Labels(ctx context.Context, client *plugins.Client, request LabelsRequest) ([]string, error) {
+	requestPath := "/loki/api/v1/labels"
+	if request.Name != "" {
+		requestPath = fmt.Sprintf("/loki/api/v1/label/%s/values", url.PathEscape(request.Name))
+	}
+
+	query := url.Values{}
+	if request.Query != "" {
+		query.Set("query", request.Query)
+	}
+	setTimeRange(query, request.Start, request.End)
+
+	var response struct {
+		Data []string `json:"data"`
+	}
+	if err := get(ctx, client, requestPath, query, &response); err != nil {
+		return nil, err
+	}
+
+	if response.Data == nil {
+		return []string{}, nil
+	}
+	return response.Data, nil
+}
+
+// Series returns the label sets of all streams, which match the stream selectors from the request.
+func Series(ctx context.Context, client *plugins.Client, request SeriesRequest) ([]map[string]string, error) {
+	if len(request.Match) == 0 {
+		return nil, fmt.Errorf("%w: at least one stream selector is required", plugins.ErrInvalidQuery)
+	}
+
+	query := url.Values{}
+	for _, match := range request.Match {
+		query.Add("match[]", match)
+	}
+	setTimeRange(query, request.Start, request.End)
+
+	var response struct {
+		Data []map[string]string `json:"data"`
+	}
+	if err := get(ctx, client, "/loki/api/v1/series", query, &response); err != nil {
+		return nil, err
+	}
+
+	if response.Data == nil {
+		return []map[string]string{}, nil
+	}
+	return response.Data, nil
+}
+
+func setTimeRange(query url.Values, start, end string) {
+	if start != "" {
+		query.Set("start", start)
+	}
+	if end != "" {
+		query.Set("end", end)
+	}
+}
+
+// mergeStreams merges the log lines of all streams and sorts them by their timestamp. Lines with the same timestamp
+// keep the order of their streams.
+func mergeStreams(streams []stream, ascending bool) *Result {
+	result := &Result{
+		Streams: make([]map[string]string, 0, len(streams)),
+		Entries: []Entry{},
+	}
+	timestamps := []int64{}
+
+	for i, s := range streams {
+		result.Streams = append(result.Streams, s.Stream)
+		for _, value := range s.Values {
+			timestamp, _ := strconv.ParseInt(value[0], 10, 64)
+			timestamps = append(timestamps, timestamp)
+			result.Entries = append(result.Entries, Entry{Timestamp: value[0], Stream: i, Line: value[1]})
+		}
+	}
+
+	sort.Stable(entriesByTimestamp{entries: result.Entries, timestamps: timestamps, ascending: ascending})
+	return result
+}
+
+// entriesByTimestamp sorts the entries by their parsed timestamps.
+type entriesByTimestamp struct {
+	entries    []Entry
+	timestamps []int64
+	ascending  bool
+}
+
+func (e entriesByTimestamp) Len() int {
+	return len(e.entries)
+}
+
+func (e entriesByTimestamp) Less(i, j int) bool {
+	if e.ascending {
+		return e.timestamps[i] < e.timestamps[j]
+	}
+	return e.timestamps[i] > e.timestamps[j]
+}
+
+func (e entriesByTimestamp) Swap(i, j int) {
+	e.entries[i], e.entries[j] = e.entries[j], e.entries[i]
+	e.timestamps[i], e.timestamps[j] = e.timestamps[j], e.timestamps[i]
+}
+
+// get sends a GET request to Loki and decodes the response into the given value. Errors of Loki are mapped to the
+// errors of the plugins package.
+func get(ctx context.Context, client *plugins.Client, requestPath string, query url.Values, v any) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	header := http.Header{}
+	header.Set("Accept", "application/json")
+
+	resp, err := client.Do(ctx, http.MethodGet, requestPath, query, header, nil)
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return fmt.Errorf("%w: %s", plugins.ErrTimeout, err.Error())
+		}
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize+1))
+	if err != nil {
+		return err
+	}
+	if len(data) > maxResponseSize {
+		return fmt.Errorf("response exceeds the maximum size of %d bytes", maxResponseSize)
+	}
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return responseError(resp.StatusCode, data)
+	}
+
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("could not decode response: %w", err)
+	}
+	return nil
+}
+
+// responseError maps an error response of Loki to one of the errors of the plugins package. Depending on the version,
+// Loki returns errors as plain text or as JSON object with a "message" field.
+func responseError(statusCode int, data []byte) error {
+	var response struct {
+		Message string `json:"message"`
+		Error   string `json:"error"`
+	}
+	json.Unmarshal(data, &response)
+
+	reason := response.Message
+	if reason == "" {
+		reason = response.Error
+	}
+	if reason == "" {
+		reason = strings.TrimSpace(string(data))
+		if len(reason) > maxReasonLength {
+			reason = reason[:maxReasonLength] + "..."
+		}
+	}
+	if reason == "" {
+		reason = http.StatusText(statusCode)
+	}
+
+	switch {
+	case statusCode == http.StatusBadRequest:
+		return fmt.Errorf("%w: %s", plugins.ErrInvalidQuery, reason)
+	case statusCode == http.StatusUnauthorized:
+		return fmt.Errorf("%w: %s", plugins.ErrUnauthorized, reason)
+	case statusCode == http.StatusForbidden:
+		return fmt.Errorf("%w: %s", plugins.ErrForbidden, reason)
+	case statusCode == http.StatusNotFound:
+		return fmt.Errorf("%w: %s", plugins.ErrNotFound, reason)
+	case statusCode == http.StatusGatewayTimeout:
+		return fmt.Errorf("%w: %s", plugins.ErrTimeout, reason)
+	case statusCode == http.StatusServiceUnavailable || statusCode == http.StatusTooManyRequests:
+		return fmt.Errorf("%w: %s", plugins.ErrUnavailable, reason)
+	default:
+		return fmt.Errorf("request failed with status code %d: %s", statusCode, reason)
+	}
+}
@@ -0,0 +1,115 @@
+package loki
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+
+	"github.com/kubenav/kubenav/pkg/server/plugins"
+)
+
+// The limits for the tail endpoint of Loki. The delay is the number of seconds Loki waits before it sends new lines,
+// so that lines which are received late are not missed.
+const (
+	defaultTailLimit = 100
+	maxTailDelay     = 5
+)
+
+// TailRequest is a request to follow the log lines of a LogQL log query. The "start" is passed to Loki as it is, if it
+// is empty Loki starts with the lines of the last hour.
+type TailRequest struct {
+	Connection
+	Query    string `json:"query"`
+	Start    string `json:"start"`
+	DelayFor int    `json:"delayFor"`
+	Limit    int    `json:"limit"`
+}
+
+// TailMessage contains the new log lines of a tail request in the same format as the result of a query. The
+// "droppedEntries" is the number of lines, which were dropped by Loki, because the client was too slow.
+type TailMessage struct {
+	Result
+	DroppedEntries int    `json:"droppedEntries,omitempty"`
+	Error          string `json:"error,omitempty"`
+}
+
+// Validate validates the request and sets the default values.
+func (r *TailRequest) Validate() error {
+	if r.Query == "" {
+		return fmt.Errorf("query is required")
+	}
+
+	if r.Limit <= 0 {
+		r.Limit = defaultTailLimit
+	}
+	if r.Limit > MaxLimit {
+		r.Limit = MaxLimit
+	}
+
+	if r.DelayFor < 0 || r.DelayFor > maxTailDelay {
+		return fmt.Errorf("delayFor must be between 0 and %d", maxTailDelay)
+	}
+
+	return nil
+}
+
+// Tail follows the log lines of the query via the WebSocket endpoint "/loki/api/v1/tail" of Loki and calls the send
+// function for each message of Loki. The function returns, when the given context is canceled, when Loki closes the
+// connection or when the send function returns an error.
+func Tail(ctx context.Context, client *plugins.Client, request TailRequest, send func(*TailMessage) error) error {
+	if err := request.Validate(); err != nil {
+		return fmt.Errorf("%w: %s", plugins.ErrInvalidQuery, err.Error())
+	}
+
+	query := url.Values{}
+	query.Set("query", request.Query)
+	query.Set("limit", strconv.Itoa(request.Limit))
+	query.Set("delay_for", strconv.Itoa(request.DelayFor))
+	if request.Start != "" {
+		query.Set("start", request.Start)
+	}
+
+	conn, err := client.DialWebSocket(ctx, "/loki/api/v1/tail", query)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// The connection is closed when the context is canceled, so that the blocking read returns.
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	for {
+		var response struct {
+			Streams        []stream `json:"streams"`
+			DroppedEntries []struct {
+				Labels    map[string]string `json:"labels"`
+				Timestamp string            `json:"timestamp"`
+			} `json:"dropped_entries"`
+		}
+		if err := conn.ReadJSON(&response); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		// The lines of a tail message are always send in ascending order, because new lines are appended by the
+		// client.
+		message := &TailMessage{
+			Result:         *mergeStreams(response.Streams, true),
+			DroppedEntries: len(response.DroppedEntries),
+		}
+		if err := send(message); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,576 @@
+// Package plugins implements the access to services inside a cluster, which are used by the plugins of kubenav (e.g.
+// Prometheus). Most of these services are not exposed outside of the cluster, so that they are accessed via the
+// service proxy of the Kubernetes API or, when the proxy can not be used, via a port forwarding session.
+package plugins
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kubenav/kubenav/pkg/server/portforwarding"
+	"github.com/kubenav/kubenav/pkg/server/proxy"
+
+	"github.com/gorilla/websocket"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// The access modes for a service. With the "auto" mode the service proxy is used and a port forwarding session is
+// only created, when the request can not be send via the service proxy (e.g. because the user isn't allowed to use the
+// proxy subresource).
+const (
+	AccessAuto        = ""
+	AccessProxy       = "proxy"
+	AccessPortForward = "portforward"
+)
+
+// readyTimeout is the maximum time we wait for a port forwarding session to be ready.
+const readyTimeout = 30 * time.Second
+
+// detectTTL is the duration for which an autodetected service is cached.
+const detectTTL = 5 * time.Minute
+
+// The errors for the common failures of a request to the service of a plugin. The plugins wrap these errors, so that
+// they contain the reason returned by the service and the failures can be mapped to a status code by the server.
+var (
+	ErrInvalidQuery = errors.New("invalid query")
+	ErrNotFound     = errors.New("not found")
+	ErrUnauthorized = errors.New("authentication failed")
+	ErrForbidden    = errors.New("access denied")
+	ErrUnavailable  = errors.New("unavailable")
+	ErrTimeout      = errors.New("timed out")
+)
+
+// Options are the services of the plugins for each cluster. The key of the outer map is the identifier of the cluster
+// (cluster server or context name), the key of the inner map is the name of the plugin.
+type Options map[string]map[string]Service
+
+// Service is the location of a service inside a cluster. The "scheme" is "http" or "https" and the "path" is the
+// prefix for all requests (e.g. when Prometheus is served under "/prometheus").
+type Service struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Port      int32  `json:"port"`
+	Scheme    string `json:"scheme,omitempty"`
+	Path      string `json:"path,omitempty"`
+}
+
+// ServiceFromQuery returns the service from the "namespace", "service", "port", "scheme" and "path" query parameters.
+// If the "service" parameter is empty, nil is returned, so that the service can be autodetected.
+func ServiceFromQuery(query url.Values) (*Service, error) {
+	if query.Get("service") == "" {
+		return nil, nil
+	}
+
+	service := &Service{
+		Namespace: query.Get("namespace"),
+		Name:      query.Get("service"),
+		Scheme:    query.Get("scheme"),
+		Path:      query.Get("path"),
+	}
+
+	if service.Namespace == "" {
+		return nil, fmt.Errorf("namespace is required")
+	}
+
+	if port := query.Get("port"); port != "" {
+		parsedPort, err := strconv.ParseInt(port, 10, 32)
+		if err != nil || parsedPort <= 0 {
+			return nil, fmt.Errorf("invalid port %s", port)
+		}
+		service.Port = int32(parsedPort)
+	}
+
+	if service.Scheme != "" && service.Scheme != "http" && service.Scheme != "https" {
+		return nil, fmt.Errorf("invalid scheme %s", service.Scheme)
+	}
+
+	return service, nil
+}
+
+// Credentials are the credentials and the certificate authority for a service, which requires authentication (e.g.
+// Elasticsearch). The "username" and "password" are used for basic auth and the "apiKey" is send as "ApiKey" in the
+// "Authorization" header. The "certificateAuthorityData" is the base64 encoded PEM certificate authority of the
+// service.
+type Credentials struct {
+	Username                 string `json:"username,omitempty"`
+	Password                 string `json:"password,omitempty"`
+	APIKey                   string `json:"apiKey,omitempty"`
+	CertificateAuthorityData string `json:"certificateAuthorityData,omitempty"`
+	InsecureSkipTLSVerify    bool   `json:"insecureSkipTLSVerify,omitempty"`
+}
+
+func (c *Credentials) isEmpty() bool {
+	return c.Username == "" && c.Password == "" && c.APIKey == "" && c.CertificateAuthorityData == "" && !c.InsecureSkipTLSVerify
+}
+
+// Candidate describes a common installation of a service, which is used for the autodetection. The services are
+// selected via the label selector and the port is selected via its name, if no port matches the first port is used.
+type Candidate struct {
+	LabelSelector string
+	PortNames     []string
+	Scheme        string
+}
+
+type detected struct {
+	service *Service
+	expires time.Time
+}
+
+var (
+	detectedLock     sync.Mutex
+	detectedServices = make(map[string]detected)
+)
+
+// Detect returns the first service, which matches one of the given candidates. The result is cached for each cluster
+// and plugin, so that we do not have to list the services of the cluster for each request.
+func Detect(ctx context.Context, clientset kubernetes.Interface, cacheKey string, candidates []Candidate) (*Service, error) {
+	detectedLock.Lock()
+	if cached, ok := detectedServices[cacheKey]; ok && time.Now().Before(cached.expires) {
+		detectedLock.Unlock()
+		return cached.service, nil
+	}
+	detectedLock.Unlock()
+
+	for _, candidate := range candidates {
+		services, err := clientset.CoreV1().Services("").List(ctx, metav1.ListOptions{LabelSelector: candidate.LabelSelector})
+		if err != nil {
+			return nil, err
+		}
+		if len(services.Items) == 0 {
+			continue
+		}
+
+		service := &Service{
+			Namespace: services.Items[0].Namespace,
+			Name:      services.Items[0].Name,
+			Port:      selectPort(services.Items[0].Spec.Ports, candidate.PortNames),
+			Scheme:    candidate.Scheme,
+		}
+
+		detectedLock.Lock()
+		detectedServices[cacheKey] = detected{service: service, expires: time.Now().Add(detectTTL)}
+		detectedLock.Unlock()
+
+		return service, nil
+	}
+
+	return nil, fmt.Errorf("service could not be detected, please configure its namespace, name and port")
+}
+
+func selectPort(ports []corev1.ServicePort, names []string) int32 {
+	for _, name := range names {
+		for _, port := range ports {
+			if port.Name == name {
+				return port.Port
+			}
+		}
+	}
+
+	if len(ports) > 0 {
+		return ports[0].Port
+	}
+	return 0
+}
+
+// Client sends requests to a service inside a cluster. The client must be closed via Close, when it isn't used
+// anymore, so that the port forwarding session is stopped.
+type Client struct {
+	restConfig    *rest.Config
+	clientset     kubernetes.Interface
+	service       Service
+	access        string
+	sessionPrefix string
+	address       *url.URL
+	credentials   *Credentials
+	header        http.Header
+	tlsConfig     *tls.Config
+	httpClient    *http.Client
+
+	lock    sync.Mutex
+	session *portforwarding.Session
+}
+
+// NewClient returns a new client for the given service. The session prefix is used for the port forwarding sessions,
+// it must start with "plugin_", so that the sessions can be differentiated from the sessions of the user.
+func NewClient(restConfig *rest.Config, clientset kubernetes.Interface, service Service, access, sessionPrefix string) (*Client, error) {
+	if access != AccessAuto && access != AccessProxy && access != AccessPortForward {
+		return nil, fmt.Errorf("invalid access mode %s", access)
+	}
+	if service.Scheme == "" {
+		service.Scheme = "http"
+	}
+
+	return &Client{
+		restConfig:    restConfig,
+		clientset:     clientset,
+		service:       service,
+		access:        access,
+		sessionPrefix: sessionPrefix,
+		header:        http.Header{},
+		httpClient:    http.DefaultClient,
+	}, nil
+}
+
+// NewDirectClient returns a new client for a service, which is reachable via the given address (e.g. an Elasticsearch
+// cluster outside of the Kubernetes cluster). All requests are send directly to the address.
+func NewDirectClient(address string, credentials *Credentials) (*Client, error) {
+	parsedAddress, err := url.Parse(address)
+	if err != nil || (parsedAddress.Scheme != "http" && parsedAddress.Scheme != "https") || parsedAddress.Host == "" {
+		return nil, fmt.Errorf("invalid address %s", address)
+	}
+
+	c := &Client{
+		service:    Service{Scheme: parsedAddress.Scheme, Path: parsedAddress.Path},
+		address:    parsedAddress,
+		header:     http.Header{},
+		httpClient: http.DefaultClient,
+	}
+	if err := c.SetCredentials(credentials); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// SetCredentials sets the credentials, which are used for all requests of the client. The Kubernetes API removes the
+// "Authorization" header from requests to the service proxy, so that requests with credentials are always send via a
+// port forwarding session. When the service is accessed via a port forwarding session, the certificate of the service
+// is verified for the DNS name of the service.
+func (c *Client) SetCredentials(credentials *Credentials) error {
+	if credentials == nil || credentials.isEmpty() {
+		return nil
+	}
+	if c.address == nil && c.access == AccessProxy {
+		return fmt.Errorf("credentials can not be used with the %s access mode", AccessProxy)
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: credentials.InsecureSkipTLSVerify}
+	if c.address == nil {
+		tlsConfig.ServerName = fmt.Sprintf("%s.%s.svc", c.service.Name, c.service.Namespace)
+	}
+
+	if credentials.CertificateAuthorityData != "" {
+		data, err := base64.StdEncoding.DecodeString(credentials.CertificateAuthorityData)
+		if err != nil {
+			return fmt.Errorf("invalid certificate authority data: %w", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return errors.New("invalid certificate authority data: no certificate found")
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	c.credentials = credentials
+	c.tlsConfig = tlsConfig
+	c.httpClient = &http.Client{Transport: transport}
+	return nil
+}
+
+// SetHeader sets a header, which is send with all requests of the client (e.g. the tenant header of a multi-tenant
+// Loki installation). In contrast to the credentials, the header is also send via the service proxy.
+func (c *Client) SetHeader(key, value string) {
+	c.header.Set(key, value)
+}
+
+// Do sends a request with the given method, path, query parameters and body to the service. Only the "Accept" and
+// "Content-Type" headers are forwarded, because all other headers are consumed by the service proxy. Clients with
+// credentials or an address do not use the service proxy, see SetCredentials and NewDirectClient. The response of
+// the service is returned unmodified, so that errors of the service can be passed to the client. Errors of the service
+// proxy (e.g. a "403 Forbidden" error, when the user isn't allowed to use the proxy) are returned as error, when the
+// access mode is "proxy". With the "auto" access mode the request is retried via a port forwarding session.
+func (c *Client) Do(ctx context.Context, method, requestPath string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	if c.address != nil {
+		return c.send(ctx, *c.address, method, requestPath, query, header, body)
+	}
+
+	if c.access != AccessPortForward && c.credentials == nil {
+		resp, err := c.doProxy(ctx, method, requestPath, query, header, body)
+		if err == nil || c.access == AccessProxy || ctx.Err() != nil {
+			return resp, err
+		}
+	}
+
+	return c.doPortForward(ctx, method, requestPath, query, header, body)
+}
+
+// doProxy sends the request via the service proxy of the Kubernetes API. If the response is an error of the
+// Kubernetes API and not of the service, the error is returned.
+func (c *Client) doProxy(ctx context.Context, method, requestPath string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	proxyPath := fmt.Sprintf("/api/v1/namespaces/%s/services/%s:%s:%d/proxy%s%s", url.PathEscape(c.service.Namespace), c.service.Scheme, url.PathEscape(c.service.Name), c.service.Port, strings.TrimSuffix(c.service.Path, "/"), requestPath)
+
+	req, err := proxy.NewRequest(ctx, c.restConfig, method, proxyPath, query, header, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	for key := range c.header {
+		req.Header.Set(key, c.header.Get(key))
+	}
+
+	resp, err := proxy.Do(c.clientset, c.restConfig, req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode < http.StatusBadRequest {
+		return resp, nil
+	}
+
+	// The service proxy returns a Kubernetes status object, when the request couldn't be send to the service (e.g. the
+	// service has no endpoints or the user isn't allowed to use the proxy). We have to read the body to check this, so
+	// that we replace the body of the response with the read data.
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	var status metav1.Status
+	if json.Unmarshal(data, &status) == nil && status.Kind == "Status" {
+		return nil, fmt.Errorf("could not access service via proxy: %s", status.Message)
+	}
+
+	resp.Body = io.NopCloser(bytes.NewReader(data))
+	return resp, nil
+}
+
+// doPortForward sends the request via a port forwarding session to a Pod of the service. The session is created with
+// the first request and reused for all following requests of the client.
+func (c *Client) doPortForward(ctx context.Context, method, requestPath string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	session, err := c.getSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.send(ctx, url.URL{Scheme: c.service.Scheme, Host: fmt.Sprintf("localhost:%d", session.LocalPort), Path: c.service.Path}, method, requestPath, query, header, body)
+}
+
+// send sends the request to the given base url. Besides the "Accept" and "Content-Type" headers, the "Authorization"
+// header is set from the credentials of the client.
+func (c *Client) send(ctx context.Context, baseURL url.URL, method, requestPath string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	baseURL.Path = strings.TrimSuffix(baseURL.Path, "/") + requestPath
+	baseURL.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, method, baseURL.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range []string{"Accept", "Content-Type"} {
+		if value := header.Get(key); value != "" {
+			req.Header.Set(key, value)
+		}
+	}
+
+	c.setHeaders(req.Header)
+
+	return c.httpClient.Do(req)
+}
+
+// setHeaders sets the headers of the client and the "Authorization" header from the credentials.
+func (c *Client) setHeaders(header http.Header) {
+	for key := range c.header {
+		header.Set(key, c.header.Get(key))
+	}
+
+	if c.credentials != nil {
+		if c.credentials.APIKey != "" {
+			header.Set("Authorization", "ApiKey "+c.credentials.APIKey)
+		} else if c.credentials.Username != "" {
+			header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(c.credentials.Username+":"+c.credentials.Password)))
+		}
+	}
+}
+
+// DialWebSocket opens a WebSocket connection to the given path of the service (e.g. the tail endpoint of Loki). The
+// service proxy can not be used for WebSocket connections, so that the connection is always established directly or
+// via a port forwarding session. If the service rejects the connection, the returned error contains the response of the
+// service.
+func (c *Client) DialWebSocket(ctx context.Context, requestPath string, query url.Values) (*websocket.Conn, error) {
+	var baseURL url.URL
+	if c.address != nil {
+		baseURL = *c.address
+	} else {
+		if c.access == AccessProxy {
+			return nil, fmt.Errorf("WebSocket connections can not be used with the %s access mode", AccessProxy)
+		}
+
+		session, err := c.getSession(ctx)
+		if err != nil {
+			return nil, err
+		}
+		baseURL = url.URL{Scheme: c.service.Scheme, Host: fmt.Sprintf("localhost:%d", session.LocalPort), Path: c.service.Path}
+	}
+
+	if baseURL.Scheme == "https" {
+		baseURL.Scheme = "wss"
+	} else {
+		baseURL.Scheme = "ws"
+	}
+	baseURL.Path = strings.TrimSuffix(baseURL.Path, "/") + requestPath
+	baseURL.RawQuery = query.Encode()
+
+	header := http.Header{}
+	c.setHeaders(header)
+
+	dialer := websocket.Dialer{
+		Proxy:            http.ProxyFromEnvironment,
+		HandshakeTimeout: readyTimeout,
+		TLSClientConfig:  c.tlsConfig,
+	}
+
+	conn, resp, err := dialer.DialContext(ctx, baseURL.String(), header)
+	if err != nil {
+		if resp != nil && resp.Body != nil {
+			defer resp.Body.Close()
+			data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+			return nil, fmt.Errorf("%w: %s", err, strings.TrimSpace(string(data)))
+		}
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+// getSession returns the port forwarding session of the client. If the client doesn't have a session yet, a Pod of
+// the service is selected and a new session is created.
+func (c *Client) getSession(ctx context.Context) (*portforwarding.Session, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.session != nil {
+		return c.session, nil
+	}
+
+	pod, port, err := c.selectPod(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := portforwarding.CreateSession(c.sessionPrefix, pod.Name, pod.Namespace, "", int64(port))
+	if err != nil {
+		return nil, err
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		err := session.Start(c.restConfig, fmt.Sprintf("/api/v1/namespaces/%s/pods/%s/portforward", pod.Namespace, pod.Name), int64(port))
+		if err != nil {
+			errCh <- err
+		}
+		portforwarding.Sessions.Stop(session.ID)
+	}()
+
+	timer := time.NewTimer(readyTimeout)
+	defer timer.Stop()
+
+	select {
+	case err := <-errCh:
+		return nil, err
+	case <-ctx.Done():
+		portforwarding.Sessions.Stop(session.ID)
+		return nil, ctx.Err()
+	case <-timer.C:
+		portforwarding.Sessions.Stop(session.ID)
+		return nil, fmt.Errorf("port forwarding session was not ready after %s", readyTimeout)
+	case <-session.ReadyCh:
+	}
+
+	c.session = session
+	return session, nil
+}
+
+// selectPod returns a running Pod of the service and the container port, which is the target of the service port.
+func (c *Client) selectPod(ctx context.Context) (*corev1.Pod, int32, error) {
+	service, err := c.clientset.CoreV1().Services(c.service.Namespace).Get(ctx, c.service.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(service.Spec.Selector) == 0 {
+		return nil, 0, fmt.Errorf("service %s/%s has no selector", c.service.Namespace, c.service.Name)
+	}
+
+	var servicePort *corev1.ServicePort
+	for i := range service.Spec.Ports {
+		if service.Spec.Ports[i].Port == c.service.Port || c.service.Port == 0 {
+			servicePort = &service.Spec.Ports[i]
+			break
+		}
+	}
+	if servicePort == nil {
+		return nil, 0, fmt.Errorf("service %s/%s has no port %d", c.service.Namespace, c.service.Name, c.service.Port)
+	}
+
+	pods, err := c.clientset.CoreV1().Pods(c.service.Namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(service.Spec.Selector).String(),
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if pod.Status.Phase != corev1.PodRunning || pod.DeletionTimestamp != nil {
+			continue
+		}
+
+		if port, ok := targetPort(pod, servicePort); ok {
+			return pod, port, nil
+		}
+	}
+
+	return nil, 0, fmt.Errorf("no running pod found for service %s/%s", c.service.Namespace, c.service.Name)
+}
+
+// targetPort resolves the target port of the service port for the given Pod. Named target ports are resolved via the
+// ports of the containers.
+func targetPort(pod *corev1.Pod, servicePort *corev1.ServicePort) (int32, bool) {
+	if servicePort.TargetPort.StrVal == "" {
+		if servicePort.TargetPort.IntVal != 0 {
+			return servicePort.TargetPort.IntVal, true
+		}
+		return servicePort.Port, true
+	}
+
+	for _, container := range pod.Spec.Containers {
+		for _, port := range container.Ports {
+			if port.Name == servicePort.TargetPort.StrVal {
+				return port.ContainerPort, true
+			}
+		}
+	}
+
+	return 0, false
+}
+
+// Close stops the port forwarding session of the client, if one was created.
+func (c *Client) Close() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.session != nil {
+		portforwarding.Sessions.Stop(c.session.ID)
+		c.session = nil
+	}
+}
@@ -0,0 +1,58 @@
+// Package prometheus implements the Prometheus plugin, which executes PromQL queries against a Prometheus instance
+// running inside of a cluster.
+package prometheus
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/kubenav/kubenav/pkg/server/plugins"
+)
+
+// The name of the plugin, which is used in the "Plugins" option of the server and the prefix of the port forwarding
+// sessions.
+const (
+	Name          = "prometheus"
+	SessionPrefix = "plugin_prometheus_"
+)
+
+// Candidates are the common installations of Prometheus, which are used for the autodetection. The candidates are
+// checked in the given order.
+var Candidates = []plugins.Candidate{
+	// kube-prometheus-stack Helm chart
+	{LabelSelector: "app=kube-prometheus-stack-prometheus", PortNames: []string{"http-web", "web"}},
+	// kube-prometheus (prometheus-operator)
+	{LabelSelector: "app.kubernetes.io/name=prometheus,app.kubernetes.io/part-of=kube-prometheus", PortNames: []string{"web"}},
+	// Governing service, which is created by the prometheus-operator for each Prometheus resource
+	{LabelSelector: "operated-prometheus=true", PortNames: []string{"web"}},
+	// prometheus Helm chart of the prometheus-community
+	{LabelSelector: "app.kubernetes.io/name=prometheus,app.kubernetes.io/component=server", PortNames: []string{"http"}},
+	{LabelSelector: "app=prometheus,component=server", PortNames: []string{"http"}},
+}
+
+// Query executes an instant query against the "/api/v1/query" endpoint of Prometheus. The "time" and "timeout" are
+// optional and passed to Prometheus as they are. The query is send as form, so that long queries do not exceed the
+// maximum length of an url. The response of Prometheus is returned unmodified, so that the caller can pass errors of
+// Prometheus to the client.
+func Query(ctx context.Context, client *plugins.Client, query, evaluationTime, timeout string) (*http.Response, error) {
+	if query == "" {
+		return nil, fmt.Errorf("query is required")
+	}
+
+	form := url.Values{}
+	form.Set("query", query)
+	if evaluationTime != "" {
+		form.Set("time", evaluationTime)
+	}
+	if timeout != "" {
+		form.Set("timeout", timeout)
+	}
+
+	header := http.Header{}
+	header.Set("Accept", "application/json")
+	header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	return client.Do(ctx, http.MethodPost, "/api/v1/query", nil, header, []byte(form.Encode()))
+}
@@ -0,0 +1,277 @@
+package prometheus
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/kubenav/kubenav/pkg/server/plugins"
+)
+
+// The limits for range queries. By default each series contains at most 500 points, which is enough for a chart on a
+// mobile device. The maximum is the limit of Prometheus (11000 points per series).
+const (
+	DefaultMaxPoints = 500
+	MaxPoints        = 11000
+	MaxQueries       = 20
+	DefaultTimeout   = 30 * time.Second
+)
+
+// maxResponseSize is the maximum size of the response of Prometheus for a single range query.
+const maxResponseSize = 32 * 1024 * 1024
+
+// RangeRequest is a request to execute multiple range queries with the same time range. The "start" and "end" are
+// unix timestamps in seconds and the "step" is the resolution in seconds. When the number of points per series would
+// exceed "maxPoints", the step is increased. The "timeout" is the timeout for each query (e.g. "30s").
+type RangeRequest struct {
+	Queries   []string `json:"queries"`
+	Start     float64  `json:"start"`
+	End       float64  `json:"end"`
+	Step      float64  `json:"step"`
+	MaxPoints int      `json:"maxPoints"`
+	Timeout   string   `json:"timeout"`
+}
+
+// RangeResponse is the result of a RangeRequest in a columnar format: the timestamps are only returned once and the
+// values of all series are aligned to this timestamps. Missing values are returned as null.
+type RangeResponse struct {
+	Start      float64       `json:"start"`
+	End        float64       `json:"end"`
+	Step       float64       `json:"step"`
+	Timestamps []float64     `json:"timestamps"`
+	Results    []RangeResult `json:"results"`
+}
+
+// RangeResult is the result of a single query. If Prometheus returned an error, the "errorType" and "error" fields
+// contain the error of Prometheus as it was returned.
+type RangeResult struct {
+	Query      string   `json:"query"`
+	Status     string   `json:"status"`
+	StatusCode int      `json:"statusCode,omitempty"`
+	ErrorType  string   `json:"errorType,omitempty"`
+	Error      string   `json:"error,omitempty"`
+	Warnings   []string `json:"warnings,omitempty"`
+	Series     []Series `json:"series"`
+}
+
+// Series is a single series of a query result. The values are aligned to the timestamps of the response.
+type Series struct {
+	Metric map[string]string `json:"metric"`
+	Values []*float64        `json:"values"`
+}
+
+// apiResponse is the format of a response of the Prometheus API.
+type apiResponse struct {
+	Status    string   `json:"status"`
+	ErrorType string   `json:"errorType"`
+	Error     string   `json:"error"`
+	Warnings  []string `json:"warnings"`
+	Data      struct {
+		ResultType string `json:"resultType"`
+		Result     []struct {
+			Metric map[string]string `json:"metric"`
+			Values [][2]interface{}  `json:"values"`
+		} `json:"result"`
+	} `json:"data"`
+}
+
+// Validate validates the request and sets the default values. The step is increased, so that a series never contains
+// more than the maximum number of points.
+func (r *RangeRequest) Validate() error {
+	if len(r.Queries) == 0 {
+		return fmt.Errorf("at least one query is required")
+	}
+	if len(r.Queries) > MaxQueries {
+		return fmt.Errorf("at most %d queries are allowed", MaxQueries)
+	}
+	for _, query := range r.Queries {
+		if query == "" {
+			return fmt.Errorf("query must not be empty")
+		}
+	}
+
+	if r.Start <= 0 || r.End <= 0 || r.End < r.Start {
+		return fmt.Errorf("invalid time range")
+	}
+
+	if r.MaxPoints <= 0 {
+		r.MaxPoints = DefaultMaxPoints
+	}
+	if r.MaxPoints > MaxPoints {
+		r.MaxPoints = MaxPoints
+	}
+
+	if r.Timeout != "" {
+		if _, err := time.ParseDuration(r.Timeout); err != nil {
+			return fmt.Errorf("invalid timeout %s", r.Timeout)
+		}
+	}
+
+	r.Step = AdjustStep(r.Start, r.End, r.Step, r.MaxPoints)
+	return nil
+}
+
+// AdjustStep returns the step for a range query, so that the number of points doesn't exceed the given maximum. If the
+// step is 0, the step is computed from the maximum number of points. The step is always at least 1 second.
+func AdjustStep(start, end, step float64, maxPoints int) float64 {
+	if maxPoints > 1 {
+		if minStep := math.Ceil((end - start) / float64(maxPoints-1)); step < minStep {
+			step = minStep
+		}
+	}
+
+	if step < 1 {
+		step = 1
+	}
+
+	return step
+}
+
+// QueryRange executes all queries of the request concurrently against the "/api/v1/query_range" endpoint of Prometheus
+// and returns the results in the columnar format. Each query has its own timeout, all queries are canceled when the
+// given context is canceled (e.g. because the client disconnected). An error is only returned, when the context was
+// canceled, errors of a single query are returned in its result.
+func QueryRange(ctx context.Context, client *plugins.Client, request RangeRequest) (*RangeResponse, error) {
+	if err := request.Validate(); err != nil {
+		return nil, err
+	}
+
+	timeout := DefaultTimeout
+	if request.Timeout != "" {
+		timeout, _ = time.ParseDuration(request.Timeout)
+	}
+
+	response := &RangeResponse{
+		Start:      request.Start,
+		End:        request.End,
+		Step:       request.Step,
+		Timestamps: timestamps(request.Start, request.End, request.Step),
+		Results:    make([]RangeResult, len(request.Queries)),
+	}
+
+	var wg sync.WaitGroup
+	for i, query := range request.Queries {
+		wg.Add(1)
+		go func(i int, query string) {
+			defer wg.Done()
+
+			queryCtx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+
+			response.Results[i] = queryRange(queryCtx, client, query, request, timeout, len(response.Timestamps))
+		}(i, query)
+	}
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+func queryRange(ctx context.Context, client *plugins.Client, query string, request RangeRequest, timeout time.Duration, points int) RangeResult {
+	result := RangeResult{Query: query, Series: []Series{}}
+
+	form := url.Values{}
+	form.Set("query", query)
+	form.Set("start", formatFloat(request.Start))
+	form.Set("end", formatFloat(request.End))
+	form.Set("step", formatFloat(request.Step))
+	form.Set("timeout", timeout.String())
+
+	header := http.Header{}
+	header.Set("Accept", "application/json")
+	header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := client.Do(ctx, http.MethodPost, "/api/v1/query_range", nil, header, []byte(form.Encode()))
+	if err != nil {
+		result.Status = "error"
+		result.Error = err.Error()
+		if ctx.Err() == context.DeadlineExceeded {
+			result.ErrorType = "timeout"
+		}
+		return result
+	}
+	defer resp.Body.Close()
+
+	result.StatusCode = resp.StatusCode
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize+1))
+	if err != nil {
+		result.Status = "error"
+		result.Error = err.Error()
+		return result
+	}
+	if len(data) > maxResponseSize {
+		result.Status = "error"
+		result.Error = fmt.Sprintf("response exceeds the maximum size of %d bytes", maxResponseSize)
+		return result
+	}
+
+	var apiResp apiResponse
+	if err := json.Unmarshal(data, &apiResp); err != nil {
+		// The response isn't a response of the Prometheus API (e.g. an error page of a reverse proxy), so that we
+		// return the body as error.
+		result.Status = "error"
+		result.Error = string(data)
+		return result
+	}
+
+	result.Status = apiResp.Status
+	result.ErrorType = apiResp.ErrorType
+	result.Error = apiResp.Error
+	result.Warnings = apiResp.Warnings
+
+	for _, stream := range apiResp.Data.Result {
+		series := Series{Metric: stream.Metric, Values: make([]*float64, points)}
+
+		for _, value := range stream.Values {
+			timestamp, ok := value[0].(float64)
+			if !ok {
+				continue
+			}
+			index := int(math.Round((timestamp - request.Start) / request.Step))
+			if index < 0 || index >= points {
+				continue
+			}
+
+			stringValue, ok := value[1].(string)
+			if !ok {
+				continue
+			}
+			parsedValue, err := strconv.ParseFloat(stringValue, 64)
+			if err != nil || math.IsNaN(parsedValue) || math.IsInf(parsedValue, 0) {
+				continue
+			}
+			series.Values[index] = &parsedValue
+		}
+
+		result.Series = append(result.Series, series)
+	}
+
+	return result
+}
+
+// timestamps returns the timestamps of a range query. Prometheus evaluates a range query at the start time and then
+// at each step until the end time.
+func timestamps(start, end, step float64) []float64 {
+	count := int(math.Floor((end-start)/step)) + 1
+
+	timestamps := make([]float64, count)
+	for i := range timestamps {
+		timestamps[i] = start + float64(i)*step
+	}
+	return timestamps
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
@@ -0,0 +1,144 @@
+// Package pods implements operations for Pods, which are not covered by the generic resources of the server, e.g. the
+// force deletion of Pods which are stuck in the Terminating state.
+package pods
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/kubenav/kubenav/pkg/server/resources"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// DefaultThreshold is the default duration a Pod must be terminating, before it is considered as stuck.
+const DefaultThreshold = 5 * time.Minute
+
+// ForceDeleteWarning is returned with every force deletion, because the API server doesn't wait for the kubelet to
+// confirm that the containers were stopped.
+const ForceDeleteWarning = "Force deleting a Pod removes it from the API server without waiting for the kubelet to " +
+	"confirm that its containers were stopped. If the node is still running, the containers can continue to run, " +
+	"which can violate the at most one semantics of StatefulSets."
+
+// ErrInvalidOptions is returned when the options for a force deletion are invalid or the deletion wasn't confirmed.
+var ErrInvalidOptions = fmt.Errorf("%w", resources.ErrInvalidOptions)
+
+// The status of a single Pod in the result of a force deletion.
+const (
+	StatusDeleted = "deleted"
+	StatusSkipped = "skipped"
+	StatusFailed  = "failed"
+)
+
+// ForceDeleteOptions are the options to force delete a single Pod via its name or all Pods matching the label
+// selector in a namespace. Only Pods, which are terminating for longer than the threshold (in seconds, defaults to
+// DefaultThreshold) are deleted. Because of the risks of a force deletion (see ForceDeleteWarning), "Confirm" must be
+// true.
+type ForceDeleteOptions struct {
+	Namespace        string `json:"namespace"`
+	Name             string `json:"name"`
+	LabelSelector    string `json:"labelSelector"`
+	ThresholdSeconds int64  `json:"thresholdSeconds"`
+	Confirm          bool   `json:"confirm"`
+}
+
+// ForceDeleteResult is the result of a force deletion, with the result for each Pod, which was matched by the options.
+type ForceDeleteResult struct {
+	Warning string      `json:"warning"`
+	Pods    []PodResult `json:"pods"`
+}
+
+// PodResult is the result of the force deletion of a single Pod. The message contains the reason, when the Pod was
+// skipped or the deletion failed.
+type PodResult struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Status    string `json:"status"`
+	Message   string `json:"message,omitempty"`
+}
+
+// ForceDelete deletes the Pods from the options with a grace period of 0. Pods which are not terminating or which are
+// terminating for less than the threshold are skipped, so that only stuck Pods are deleted. The UID of the Pod is used
+// as precondition, so that a new Pod with the same name (e.g. of a StatefulSet) is never deleted.
+func ForceDelete(ctx context.Context, clientset kubernetes.Interface, options ForceDeleteOptions) (*ForceDeleteResult, error) {
+	if options.Namespace == "" {
+		return nil, fmt.Errorf("%w: namespace is required", ErrInvalidOptions)
+	}
+	if (options.Name == "") == (options.LabelSelector == "") {
+		return nil, fmt.Errorf("%w: name or label selector is required", ErrInvalidOptions)
+	}
+	if options.ThresholdSeconds < 0 {
+		return nil, fmt.Errorf("%w: threshold must not be negative", ErrInvalidOptions)
+	}
+	if !options.Confirm {
+		return nil, fmt.Errorf("%w: force deletion must be confirmed: %s", ErrInvalidOptions, ForceDeleteWarning)
+	}
+
+	threshold := DefaultThreshold
+	if options.ThresholdSeconds > 0 {
+		threshold = time.Duration(options.ThresholdSeconds) * time.Second
+	}
+
+	var pods []corev1.Pod
+	if options.Name != "" {
+		pod, err := clientset.CoreV1().Pods(options.Namespace).Get(ctx, options.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		pods = append(pods, *pod)
+	} else {
+		list, err := clientset.CoreV1().Pods(options.Namespace).List(ctx, metav1.ListOptions{LabelSelector: options.LabelSelector})
+		if err != nil {
+			return nil, err
+		}
+		pods = list.Items
+	}
+
+	result := &ForceDeleteResult{Warning: ForceDeleteWarning, Pods: make([]PodResult, 0, len(pods))}
+	for i := range pods {
+		result.Pods = append(result.Pods, forceDeletePod(ctx, clientset, &pods[i], threshold))
+	}
+
+	return result, nil
+}
+
+func forceDeletePod(ctx context.Context, clientset kubernetes.Interface, pod *corev1.Pod, threshold time.Duration) PodResult {
+	result := PodResult{Namespace: pod.Namespace, Name: pod.Name}
+
+	if pod.DeletionTimestamp == nil {
+		result.Status = StatusSkipped
+		result.Message = "pod is not terminating"
+		return result
+	}
+
+	if terminating := time.Since(pod.DeletionTimestamp.Time); terminating < threshold {
+		result.Status = StatusSkipped
+		result.Message = fmt.Sprintf("pod is terminating since %s, which is less than the threshold of %s", terminating.Round(time.Second), threshold)
+		return result
+	}
+
+	gracePeriodSeconds := int64(0)
+	err := clientset.CoreV1().Pods(pod.Namespace).Delete(ctx, pod.Name, metav1.DeleteOptions{
+		GracePeriodSeconds: &gracePeriodSeconds,
+		Preconditions:      &metav1.Preconditions{UID: &pod.UID},
+	})
+	switch {
+	case err == nil:
+		result.Status = StatusDeleted
+	case apierrors.IsNotFound(err):
+		result.Status = StatusSkipped
+		result.Message = "pod was already removed"
+	case apierrors.IsConflict(err):
+		result.Status = StatusSkipped
+		result.Message = "pod was replaced by a new pod with the same name"
+	default:
+		result.Status = StatusFailed
+		result.Message = err.Error()
+	}
+
+	return result
+}
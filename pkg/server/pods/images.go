@@ -0,0 +1,261 @@
+package pods
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// imagesPageSize is the number of Pods, which are fetched per request for the image inventory.
+const imagesPageSize = 500
+
+// defaultRegistry is the registry, which is used by the container runtimes for images without a registry.
+const defaultRegistry = "docker.io"
+
+// ImagesOptions are the options for the image inventory. When the namespace is empty the images of all namespaces are
+// returned. The registry and repository are case insensitive substrings, which must be contained in the registry and
+// repository of an image.
+type ImagesOptions struct {
+	Namespace  string `json:"namespace"`
+	Registry   string `json:"registry"`
+	Repository string `json:"repository"`
+}
+
+// ImageInventory contains all unique images, which are used by the containers, init containers and ephemeral
+// containers of the Pods, sorted by the number of containers using the image in descending order.
+type ImageInventory struct {
+	Images []Image `json:"images"`
+	Pods   int64   `json:"pods"`
+}
+
+// Image is a single unique image reference, split into its registry, repository, tag and digest. "Containers" is the
+// number of containers using the image, "Namespaces" and "Workloads" are the namespaces and workloads of the Pods.
+type Image struct {
+	Image      string     `json:"image"`
+	Registry   string     `json:"registry"`
+	Repository string     `json:"repository"`
+	Tag        string     `json:"tag,omitempty"`
+	Digest     string     `json:"digest,omitempty"`
+	Containers int64      `json:"containers"`
+	Namespaces []string   `json:"namespaces"`
+	Workloads  []Workload `json:"workloads"`
+}
+
+// Workload is the owner of a Pod. For Pods of a Deployment the Deployment is returned instead of the ReplicaSet. Pods
+// without an owner are returned with the kind "Pod".
+type Workload struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+}
+
+// podProjection contains only the fields of a Pod, which are required for the image inventory. The Kubernetes API
+// doesn't support to select fields of a resource, so that we decode each page of Pods into the projection, which
+// avoids to keep the complete Pod objects in memory.
+type podProjection struct {
+	Metadata struct {
+		Namespace       string                  `json:"namespace"`
+		Name            string                  `json:"name"`
+		Labels          map[string]string       `json:"labels"`
+		OwnerReferences []metav1.OwnerReference `json:"ownerReferences"`
+	} `json:"metadata"`
+	Spec struct {
+		Containers          []containerProjection `json:"containers"`
+		InitContainers      []containerProjection `json:"initContainers"`
+		EphemeralContainers []containerProjection `json:"ephemeralContainers"`
+	} `json:"spec"`
+}
+
+type containerProjection struct {
+	Image string `json:"image"`
+}
+
+// imageAggregate is the aggregated usage of a single image, while the Pods are listed.
+type imageAggregate struct {
+	image      Image
+	namespaces map[string]struct{}
+	workloads  map[Workload]struct{}
+}
+
+// GetImages returns the inventory of all images, which are used in the namespace from the options. The Pods are listed
+// page by page and each page is only decoded into the fields, which are required for the inventory, so that the
+// memory usage depends on the number of unique images and not on the number of Pods in the cluster.
+func GetImages(ctx context.Context, clientset kubernetes.Interface, options ImagesOptions) (*ImageInventory, error) {
+	images := make(map[string]*imageAggregate)
+	inventory := &ImageInventory{Images: []Image{}}
+
+	continueToken := ""
+	for {
+		request := clientset.CoreV1().RESTClient().Get().Namespace(options.Namespace).Resource("pods").Param("limit", strconv.Itoa(imagesPageSize))
+		if continueToken != "" {
+			request = request.Param("continue", continueToken)
+		}
+
+		data, err := request.DoRaw(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		var list struct {
+			Metadata struct {
+				Continue string `json:"continue"`
+			} `json:"metadata"`
+			Items []podProjection `json:"items"`
+		}
+		if err := json.Unmarshal(data, &list); err != nil {
+			return nil, err
+		}
+
+		for _, pod := range list.Items {
+			inventory.Pods++
+			addPodImages(images, pod, options)
+		}
+
+		if list.Metadata.Continue == "" {
+			break
+		}
+		continueToken = list.Metadata.Continue
+	}
+
+	for _, aggregate := range images {
+		image := aggregate.image
+		image.Namespaces = make([]string, 0, len(aggregate.namespaces))
+		for namespace := range aggregate.namespaces {
+			image.Namespaces = append(image.Namespaces, namespace)
+		}
+		sort.Strings(image.Namespaces)
+
+		image.Workloads = make([]Workload, 0, len(aggregate.workloads))
+		for workload := range aggregate.workloads {
+			image.Workloads = append(image.Workloads, workload)
+		}
+		sort.Slice(image.Workloads, func(i, j int) bool {
+			a, b := image.Workloads[i], image.Workloads[j]
+			if a.Namespace != b.Namespace {
+				return a.Namespace < b.Namespace
+			}
+			if a.Kind != b.Kind {
+				return a.Kind < b.Kind
+			}
+			return a.Name < b.Name
+		})
+
+		inventory.Images = append(inventory.Images, image)
+	}
+
+	sort.Slice(inventory.Images, func(i, j int) bool {
+		if inventory.Images[i].Containers != inventory.Images[j].Containers {
+			return inventory.Images[i].Containers > inventory.Images[j].Containers
+		}
+		return inventory.Images[i].Image < inventory.Images[j].Image
+	})
+
+	return inventory, nil
+}
+
+// addPodImages adds the images of all containers of the Pod, which are matching the registry and repository filter
+// from the options, to the aggregated images.
+func addPodImages(images map[string]*imageAggregate, pod podProjection, options ImagesOptions) {
+	workload := getWorkload(pod)
+
+	var containers []containerProjection
+	containers = append(containers, pod.Spec.InitContainers...)
+	containers = append(containers, pod.Spec.Containers...)
+	containers = append(containers, pod.Spec.EphemeralContainers...)
+
+	for _, container := range containers {
+		if container.Image == "" {
+			continue
+		}
+
+		aggregate, ok := images[container.Image]
+		if !ok {
+			image := ParseImage(container.Image)
+			if !containsFold(image.Registry, options.Registry) || !containsFold(image.Repository, options.Repository) {
+				continue
+			}
+
+			aggregate = &imageAggregate{
+				image:      image,
+				namespaces: make(map[string]struct{}),
+				workloads:  make(map[Workload]struct{}),
+			}
+			images[container.Image] = aggregate
+		}
+
+		aggregate.image.Containers++
+		aggregate.namespaces[pod.Metadata.Namespace] = struct{}{}
+		aggregate.workloads[workload] = struct{}{}
+	}
+}
+
+// getWorkload returns the workload of the Pod via its controller reference. The Deployment of a ReplicaSet is
+// determined via the "pod-template-hash" label, which is appended to the name of the ReplicaSet by the Deployment
+// controller, so that we do not have to get the ReplicaSet.
+func getWorkload(pod podProjection) Workload {
+	return workloadOf(pod.Metadata.Namespace, pod.Metadata.Name, pod.Metadata.Labels, pod.Metadata.OwnerReferences)
+}
+
+func workloadOf(namespace, name string, labels map[string]string, owners []metav1.OwnerReference) Workload {
+	for _, owner := range owners {
+		if owner.Controller == nil || !*owner.Controller {
+			continue
+		}
+
+		if owner.Kind == "ReplicaSet" {
+			if hash, ok := labels["pod-template-hash"]; ok && strings.HasSuffix(owner.Name, "-"+hash) {
+				return Workload{Kind: "Deployment", Namespace: namespace, Name: strings.TrimSuffix(owner.Name, "-"+hash)}
+			}
+		}
+
+		return Workload{Kind: owner.Kind, Namespace: namespace, Name: owner.Name}
+	}
+
+	return Workload{Kind: "Pod", Namespace: namespace, Name: name}
+}
+
+// ParseImage splits an image reference into its registry, repository, tag and digest. Images without a registry are
+// normalized like the container runtimes do it, so "nginx" is "docker.io/library/nginx". When the image has neither a
+// tag nor a digest, the tag is "latest".
+func ParseImage(reference string) Image {
+	image := Image{Image: reference}
+
+	name := reference
+	if index := strings.Index(name, "@"); index != -1 {
+		image.Digest = name[index+1:]
+		name = name[:index]
+	}
+
+	if index := strings.LastIndex(name, ":"); index != -1 && !strings.Contains(name[index+1:], "/") {
+		image.Tag = name[index+1:]
+		name = name[:index]
+	}
+	if image.Tag == "" && image.Digest == "" {
+		image.Tag = "latest"
+	}
+
+	// The first component of the name is the registry, when it contains a "." or ":" or when it is "localhost",
+	// otherwise the image is an image from Docker Hub.
+	if registry, repository, ok := strings.Cut(name, "/"); ok && (strings.ContainsAny(registry, ".:") || registry == "localhost") {
+		image.Registry = registry
+		image.Repository = repository
+	} else {
+		image.Registry = defaultRegistry
+		image.Repository = name
+	}
+
+	if image.Registry == defaultRegistry && !strings.Contains(image.Repository, "/") {
+		image.Repository = "library/" + image.Repository
+	}
+
+	return image
+}
+
+func containsFold(value, substr string) bool {
+	return strings.Contains(strings.ToLower(value), strings.ToLower(substr))
+}
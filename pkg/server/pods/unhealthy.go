@@ -0,0 +1,331 @@
+package pods
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/kubernetes"
+)
+
+// The default thresholds for the unhealthy Pods: A container must be restarted at least DefaultRestartThreshold times
+// and a Pod must be pending for at least DefaultPendingThreshold, before it is returned.
+const (
+	DefaultRestartThreshold = 5
+	DefaultPendingThreshold = 5 * time.Minute
+)
+
+// unhealthyPageSize is the number of Pods, which are fetched per request for the unhealthy Pods.
+const unhealthyPageSize = 500
+
+// eventsConcurrency is the maximum number of concurrent requests to get the events of the unhealthy Pods.
+const eventsConcurrency = 10
+
+// The types of the problems of an unhealthy Pod.
+const (
+	ProblemCrashLoopBackOff = "CrashLoopBackOff"
+	ProblemImagePullBackOff = "ImagePullBackOff"
+	ProblemRestarts         = "Restarts"
+	ProblemOOMKilled        = "OOMKilled"
+	ProblemPending          = "Pending"
+)
+
+// The severities of the problems, a Pod has the highest severity of its problems. Pods which are not running at all
+// (crash loops, image pull errors and pending Pods) are "critical", restarts and OOM kills are a "warning".
+const (
+	SeverityCritical = "critical"
+	SeverityWarning  = "warning"
+)
+
+// UnhealthyOptions are the options to get the unhealthy Pods. When the namespace is empty, the Pods of all namespaces
+// are checked. If the thresholds are 0, DefaultRestartThreshold and DefaultPendingThreshold are used.
+type UnhealthyOptions struct {
+	Namespace        string `json:"namespace"`
+	RestartThreshold int32  `json:"restartThreshold"`
+	PendingMinutes   int64  `json:"pendingMinutes"`
+}
+
+// UnhealthyResult contains all Pods with at least one problem, sorted by their severity and the time of their last
+// problem, see sortUnhealthyPods. "CheckedPods" is the number of checked Pods. When the events of a Pod could not be listed,
+// the error is returned in "Errors" by the namespace and name of the Pod, but the Pod is still returned.
+type UnhealthyResult struct {
+	Pods        []UnhealthyPod    `json:"pods"`
+	CheckedPods int64             `json:"checkedPods"`
+	Errors      map[string]string `json:"errors,omitempty"`
+}
+
+// UnhealthyPod is a Pod with at least one problem. "LastSeen" is the time of the most recent problem or warning event
+// of the Pod, "LastEvent" is the message of its most recent warning event.
+type UnhealthyPod struct {
+	Namespace string     `json:"namespace"`
+	Name      string     `json:"name"`
+	Phase     string     `json:"phase"`
+	Node      string     `json:"node,omitempty"`
+	Workload  Workload   `json:"workload"`
+	Severity  string     `json:"severity"`
+	Problems  []Problem  `json:"problems"`
+	LastSeen  *time.Time `json:"lastSeen,omitempty"`
+	LastEvent string     `json:"lastEvent,omitempty"`
+}
+
+// Problem is a single problem of a Pod or one of its containers. For restarts and OOM kills, the reason and exit code
+// are the ones of the last termination of the container. The time is the time when the problem was observed last, e.g.
+// the end of the last termination.
+type Problem struct {
+	Type      string     `json:"type"`
+	Severity  string     `json:"severity"`
+	Container string     `json:"container,omitempty"`
+	Reason    string     `json:"reason,omitempty"`
+	Message   string     `json:"message,omitempty"`
+	Restarts  int32      `json:"restarts,omitempty"`
+	ExitCode  *int32     `json:"exitCode,omitempty"`
+	Time      *time.Time `json:"time,omitempty"`
+}
+
+// GetUnhealthy returns all Pods in the namespace from the options, which have at least one problem: containers waiting
+// in the CrashLoopBackOff or ImagePullBackOff state, containers with at least as many restarts as the threshold or which
+// were OOM killed and Pods which are pending for longer than the threshold. The Pods are listed page by page, only the unhealthy
+// Pods are kept. Afterwards the events of the unhealthy Pods are listed concurrently, to add the most recent warning
+// event and the scheduling message of pending Pods.
+func GetUnhealthy(ctx context.Context, clientset kubernetes.Interface, options UnhealthyOptions) (*UnhealthyResult, error) {
+	if options.RestartThreshold < 0 || options.PendingMinutes < 0 {
+		return nil, fmt.Errorf("%w: thresholds must not be negative", ErrInvalidOptions)
+	}
+	if options.RestartThreshold == 0 {
+		options.RestartThreshold = DefaultRestartThreshold
+	}
+	pendingThreshold := DefaultPendingThreshold
+	if options.PendingMinutes > 0 {
+		pendingThreshold = time.Duration(options.PendingMinutes) * time.Minute
+	}
+
+	result := &UnhealthyResult{Pods: []UnhealthyPod{}, Errors: make(map[string]string)}
+	now := time.Now()
+
+	continueToken := ""
+	for {
+		list, err := clientset.CoreV1().Pods(options.Namespace).List(ctx, metav1.ListOptions{Limit: unhealthyPageSize, Continue: continueToken})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, pod := range list.Items {
+			result.CheckedPods++
+			if unhealthy, ok := checkPod(pod, options.RestartThreshold, pendingThreshold, now); ok {
+				result.Pods = append(result.Pods, unhealthy)
+			}
+		}
+
+		if list.Continue == "" {
+			break
+		}
+		continueToken = list.Continue
+	}
+
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	semaphore := make(chan struct{}, eventsConcurrency)
+
+	for i := range result.Pods {
+		wg.Add(1)
+		go func(pod *UnhealthyPod) {
+			defer wg.Done()
+
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+
+			if err := addEvents(ctx, clientset, pod); err != nil {
+				lock.Lock()
+				result.Errors[pod.Namespace+"/"+pod.Name] = err.Error()
+				lock.Unlock()
+			}
+		}(&result.Pods[i])
+	}
+
+	wg.Wait()
+
+	if len(result.Errors) == 0 {
+		result.Errors = nil
+	}
+
+	sortUnhealthyPods(result.Pods)
+	return result, nil
+}
+
+// checkPod returns the problems of the Pod and false, when the Pod doesn't have any problems.
+func checkPod(pod corev1.Pod, restartThreshold int32, pendingThreshold time.Duration, now time.Time) (UnhealthyPod, bool) {
+	var problems []Problem
+
+	var statuses []corev1.ContainerStatus
+	statuses = append(statuses, pod.Status.InitContainerStatuses...)
+	statuses = append(statuses, pod.Status.ContainerStatuses...)
+
+	for _, status := range statuses {
+		lastTermination := status.LastTerminationState.Terminated
+
+		if waiting := status.State.Waiting; waiting != nil {
+			switch waiting.Reason {
+			case "CrashLoopBackOff":
+				problem := Problem{Type: ProblemCrashLoopBackOff, Severity: SeverityCritical, Container: status.Name, Reason: waiting.Reason, Message: waiting.Message, Restarts: status.RestartCount}
+				if lastTermination != nil {
+					problem.Reason = lastTermination.Reason
+					problem.ExitCode = &lastTermination.ExitCode
+					problem.Time = timePtr(lastTermination.FinishedAt)
+				}
+				problems = append(problems, problem)
+			case "ImagePullBackOff", "ErrImagePull":
+				problems = append(problems, Problem{Type: ProblemImagePullBackOff, Severity: SeverityCritical, Container: status.Name, Reason: waiting.Reason, Message: waiting.Message})
+			}
+		}
+
+		if status.RestartCount >= restartThreshold {
+			problem := Problem{Type: ProblemRestarts, Severity: SeverityWarning, Container: status.Name, Restarts: status.RestartCount}
+			if lastTermination != nil {
+				problem.Reason = lastTermination.Reason
+				problem.Message = lastTermination.Message
+				problem.ExitCode = &lastTermination.ExitCode
+				problem.Time = timePtr(lastTermination.FinishedAt)
+			}
+			problems = append(problems, problem)
+		}
+
+		for _, terminated := range []*corev1.ContainerStateTerminated{status.State.Terminated, lastTermination} {
+			if terminated != nil && terminated.Reason == "OOMKilled" {
+				problems = append(problems, Problem{Type: ProblemOOMKilled, Severity: SeverityWarning, Container: status.Name, Reason: terminated.Reason, ExitCode: &terminated.ExitCode, Time: timePtr(terminated.FinishedAt)})
+				break
+			}
+		}
+	}
+
+	if pod.Status.Phase == corev1.PodPending && now.Sub(pod.CreationTimestamp.Time) >= pendingThreshold {
+		problem := Problem{Type: ProblemPending, Severity: SeverityCritical, Time: timePtr(pod.CreationTimestamp)}
+		for _, condition := range pod.Status.Conditions {
+			if condition.Type == corev1.PodScheduled && condition.Status == corev1.ConditionFalse {
+				problem.Reason = condition.Reason
+				problem.Message = condition.Message
+			}
+		}
+		problems = append(problems, problem)
+	}
+
+	if len(problems) == 0 {
+		return UnhealthyPod{}, false
+	}
+
+	unhealthy := UnhealthyPod{
+		Namespace: pod.Namespace,
+		Name:      pod.Name,
+		Phase:     string(pod.Status.Phase),
+		Node:      pod.Spec.NodeName,
+		Workload:  workloadOf(pod.Namespace, pod.Name, pod.Labels, pod.OwnerReferences),
+		Severity:  SeverityWarning,
+		Problems:  problems,
+	}
+	for _, problem := range problems {
+		if problem.Severity == SeverityCritical {
+			unhealthy.Severity = SeverityCritical
+		}
+		unhealthy.LastSeen = latest(unhealthy.LastSeen, problem.Time)
+	}
+
+	return unhealthy, true
+}
+
+// addEvents adds the message of the most recent warning event to the Pod. For pending Pods the message of the most
+// recent "FailedScheduling" event is used as message of the pending problem, because it contains the reason why the
+// Pod can not be scheduled, e.g. "0/3 nodes are available: 3 Insufficient cpu.".
+func addEvents(ctx context.Context, clientset kubernetes.Interface, pod *UnhealthyPod) error {
+	selector := fields.Set{"involvedObject.kind": "Pod", "involvedObject.name": pod.Name, "type": corev1.EventTypeWarning}.AsSelector().String()
+
+	events, err := clientset.CoreV1().Events(pod.Namespace).List(ctx, metav1.ListOptions{FieldSelector: selector})
+	if err != nil {
+		return err
+	}
+
+	var lastEvent, lastScheduling *corev1.Event
+	for i := range events.Items {
+		event := &events.Items[i]
+		if lastEvent == nil || eventTime(*event).After(eventTime(*lastEvent)) {
+			lastEvent = event
+		}
+		if event.Reason == "FailedScheduling" && (lastScheduling == nil || eventTime(*event).After(eventTime(*lastScheduling))) {
+			lastScheduling = event
+		}
+	}
+
+	if lastEvent != nil {
+		t := eventTime(*lastEvent)
+		pod.LastSeen = latest(pod.LastSeen, &t)
+		pod.LastEvent = fmt.Sprintf("%s: %s", lastEvent.Reason, lastEvent.Message)
+	}
+
+	if lastScheduling != nil {
+		for i := range pod.Problems {
+			if pod.Problems[i].Type == ProblemPending {
+				pod.Problems[i].Reason = lastScheduling.Reason
+				pod.Problems[i].Message = lastScheduling.Message
+			}
+		}
+	}
+
+	return nil
+}
+
+// sortUnhealthyPods sorts the Pods by their severity, the time of their last problem (most recent first) and their
+// namespace and name, so that the order is deterministic for Pods without a time.
+func sortUnhealthyPods(pods []UnhealthyPod) {
+	sort.Slice(pods, func(i, j int) bool {
+		a, b := pods[i], pods[j]
+		if a.Severity != b.Severity {
+			return a.Severity == SeverityCritical
+		}
+		if ta, tb := unixOrZero(a.LastSeen), unixOrZero(b.LastSeen); ta != tb {
+			return ta > tb
+		}
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return a.Name < b.Name
+	})
+}
+
+// eventTime returns the time when the event was observed last. Depending on the reporter of the event, only one of
+// the timestamps is set.
+func eventTime(event corev1.Event) time.Time {
+	switch {
+	case event.Series != nil && !event.Series.LastObservedTime.IsZero():
+		return event.Series.LastObservedTime.Time
+	case !event.LastTimestamp.IsZero():
+		return event.LastTimestamp.Time
+	case !event.EventTime.IsZero():
+		return event.EventTime.Time
+	default:
+		return event.CreationTimestamp.Time
+	}
+}
+
+func timePtr(t metav1.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t.Time
+}
+
+func latest(a, b *time.Time) *time.Time {
+	if a == nil || (b != nil && b.After(*a)) {
+		return b
+	}
+	return a
+}
+
+func unixOrZero(t *time.Time) int64 {
+	if t == nil {
+		return 0
+	}
+	return t.UnixNano()
+}
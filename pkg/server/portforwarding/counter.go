@@ -0,0 +1,59 @@
+package portforwarding
+
+import (
+	"net/http"
+	"sync/atomic"
+
+	"k8s.io/apimachinery/pkg/util/httpstream"
+	"k8s.io/client-go/transport/spdy"
+)
+
+var bytesReceived, bytesSent atomic.Uint64
+
+// BytesForwarded returns the number of bytes, which were received from and sent to the forwarded ports of all port
+// forwarding sessions.
+func BytesForwarded() (received uint64, sent uint64) {
+	return bytesReceived.Load(), bytesSent.Load()
+}
+
+// countingUpgrader wraps the SPDY upgrader of a port forwarding session, so that we can count the bytes of all streams,
+// which are created for the connection.
+type countingUpgrader struct {
+	spdy.Upgrader
+}
+
+func (u countingUpgrader) NewConnection(resp *http.Response) (httpstream.Connection, error) {
+	conn, err := u.Upgrader.NewConnection(resp)
+	if err != nil {
+		return nil, err
+	}
+	return countingConnection{conn}, nil
+}
+
+type countingConnection struct {
+	httpstream.Connection
+}
+
+func (c countingConnection) CreateStream(headers http.Header) (httpstream.Stream, error) {
+	stream, err := c.Connection.CreateStream(headers)
+	if err != nil {
+		return nil, err
+	}
+	return countingStream{stream}, nil
+}
+
+type countingStream struct {
+	httpstream.Stream
+}
+
+func (s countingStream) Read(p []byte) (int, error) {
+	n, err := s.Stream.Read(p)
+	bytesReceived.Add(uint64(n))
+	return n, err
+}
+
+func (s countingStream) Write(p []byte) (int, error) {
+	n, err := s.Stream.Write(p)
+	bytesSent.Add(uint64(n))
+	return n, err
+}
@@ -52,11 +52,12 @@ type GetResponse struct {
 	Container  string `json:"container"`
 	RemotePort int64  `json:"remotePort"`
 	LocalPort  int64  `json:"localPort"`
+	RequestID  string `json:"requestID,omitempty"`
 }
 
 // Session is the structure for an establish port forwading session. It contains the session id, the local port which
 // should be used for the port forwarding, a channel to close the connection, a channel which can be used to check if
-// the connection is ready and the IO streams.
+// the connection is ready and the IO streams. The RequestID is the id of the request, which created the session.
 type Session struct {
 	ID         string
 	Name       string
@@ -64,6 +65,7 @@ type Session struct {
 	Container  string
 	RemotePort int64
 	LocalPort  int64
+	RequestID  string
 	StopCh     chan struct{}
 	ReadyCh    chan struct{}
 	Streams    genericclioptions.IOStreams
@@ -133,11 +135,13 @@ func (s *Session) Start(restConfig *rest.Config, path string, remotePort int64)
 
 	// Finally we can create our transporter and upgrader which can be used with SPDY. The transporter and upgrader are
 	// then used to create a new dialer that connects to the provided URL and upgrades the connection to SPDY. The
-	// dialer is then used to forward the requested port.
+	// dialer is then used to forward the requested port. The upgrader is wrapped, so that we can count the forwarded
+	// bytes for the metrics of the server.
 	transport, upgrader, err := spdy.RoundTripperFor(restConfig)
 	if err != nil {
 		return err
 	}
+	upgrader = countingUpgrader{upgrader}
 
 	dialer := spdy.NewDialer(upgrader, &http.Client{Transport: transport}, http.MethodPost, parsedRequestURL)
 	pf, err := portforward.New(dialer, []string{fmt.Sprintf("%d:%d", s.LocalPort, remotePort)}, s.StopCh, s.ReadyCh, s.Streams.Out, s.Streams.ErrOut)
@@ -1,40 +1,30 @@
 package portforwarding
 
 import (
-	"sync"
+	"github.com/kubenav/kubenav/pkg/server/sessions"
 )
 
 // Sessions holds all active port forwarding sessions.
-var Sessions = SessionMap{Sessions: make(map[string]*Session)}
+var Sessions = SessionMap{Store: sessions.New[*Session]()}
 
-// SessionMap stores a map of all PortForwardSession objects and a lock to avoid concurrent conflict.
+// SessionMap stores all port forwarding sessions. The sessions are stored in a sharded store, so that it can be
+// accessed concurrently from the handlers, the Prometheus requests and the metrics.
 type SessionMap struct {
-	Sessions map[string]*Session
-	Lock     sync.RWMutex
+	*sessions.Store[*Session]
 }
 
-// Get return a given portForwardSession by sessionID.
-func (sm *SessionMap) Get(sessionID string) (*Session, bool) {
-	sm.Lock.RLock()
-	defer sm.Lock.RUnlock()
-
-	session, ok := sm.Sessions[sessionID]
-	return session, ok
-}
-
-// Set store a PortForwardSession to SessionMap.
-func (sm *SessionMap) Set(sessionID string, session *Session) {
-	sm.Lock.Lock()
-	defer sm.Lock.Unlock()
-	sm.Sessions[sessionID] = session
+// StopAll closes all port forwarding sessions via their stop channel and removes them from the active sessions. The
+// stop channel is only closed by the caller, which removed the session, so that a session is never closed twice.
+func (sm *SessionMap) StopAll() {
+	for _, session := range sm.Snapshot() {
+		sm.Stop(session.ID)
+	}
 }
 
-// Delete removes a session from the active sessions.
-func (sm *SessionMap) Delete(sessionID string) {
-	sm.Lock.Lock()
-	defer sm.Lock.Unlock()
-
-	if _, ok := sm.Sessions[sessionID]; ok {
-		delete(sm.Sessions, sessionID)
+// Stop removes the session with the given id from the active sessions and closes it via its stop channel. If the
+// session doesn't exist anymore, nothing is done.
+func (sm *SessionMap) Stop(sessionID string) {
+	if session, ok := sm.Delete(sessionID); ok {
+		close(session.StopCh)
 	}
 }
@@ -0,0 +1,343 @@
+// Package processes implements the process list for containers. The processes are listed via "ps" in the container.
+// When the container doesn't contain ps, the processes are read from the "/proc" filesystem via a small shell script,
+// which only uses shell builtins, so that it also works in minimal images.
+package processes
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/kubenav/kubenav/pkg/server/terminal"
+
+	"k8s.io/client-go/rest"
+)
+
+// The methods which can be used to get the processes of a container.
+const (
+	MethodPS   = "ps"
+	MethodProc = "proc"
+)
+
+const (
+	// clockTicks is the number of clock ticks per second, which is used for the times in "/proc/<pid>/stat". This is
+	// 100 on nearly all Linux systems, so that we do not have to run "getconf CLK_TCK" in the container.
+	clockTicks = 100
+	// pageSize is the size of a memory page in bytes, which is used for the RSS in "/proc/<pid>/stat".
+	pageSize = 4096
+)
+
+// script is the shell script, which is executed in the container. Each line of the output starts with a keyword, so
+// that we can parse the output of both methods:
+//
+//	KEYWORD  DESCRIPTION
+//	---------------------------------------------------------------------
+//	method   The used method ("ps" or "proc")
+//	ps       A single line of the ps output, the first line is the header
+//	uptime   The content of "/proc/uptime"
+//	memory   The total memory in kB from "/proc/meminfo"
+//	user     A user from "/etc/passwd" in the format "<name>:<uid>"
+//	process  The pid, uid, the content of "/proc/<pid>/stat" and "/proc/<pid>/cmdline" separated by tabs
+//
+// The "-e" flag is required for procps to list the processes of all users, but it isn't supported by busybox. When ps
+// doesn't support the "pcpu" and "pmem" columns (e.g. some busybox builds), we try it again without these columns,
+// before we fall back to "/proc".
+const script = `for o in "-e -o pid,ppid,user,etime,pcpu,pmem,args" "-o pid,ppid,user,etime,pcpu,pmem,args" "-o pid,ppid,user,etime,args"; do
+  if out=$(ps $o 2>/dev/null); then
+    echo "method ps"; printf '%s\n' "$out" | while IFS= read -r l; do printf 'ps %s\n' "$l"; done; exit 0
+  fi
+done
+echo "method proc"
+read -r u _ < /proc/uptime && echo "uptime $u"
+while read -r k v _; do [ "$k" = "MemTotal:" ] && echo "memory $v"; done < /proc/meminfo
+[ -r /etc/passwd ] && while IFS=: read -r n _ id _; do echo "user $n:$id"; done < /etc/passwd
+for d in /proc/[0-9]*; do
+  [ -r "$d/stat" ] || continue
+  uid=; while read -r k v _; do [ "$k" = "Uid:" ] && uid=$v; done < "$d/status"
+  read -r stat < "$d/stat" || continue
+  c=; while IFS= read -r -d '' a 2>/dev/null; do c="$c $a"; done < "$d/cmdline"
+  printf 'process %s\t%s\t%s\t%s\n' "${d#/proc/}" "$uid" "$stat" "${c# }"
+done`
+
+// Options are the options to list the processes of a container.
+type Options struct {
+	Namespace string
+	Name      string
+	Container string
+	Protocol  string
+}
+
+// OptionsFromQuery returns the options from the "namespace", "name" and "container" query parameters.
+func OptionsFromQuery(query url.Values) (Options, error) {
+	options := Options{
+		Namespace: query.Get("namespace"),
+		Name:      query.Get("name"),
+		Container: query.Get("container"),
+	}
+
+	if options.Namespace == "" || options.Name == "" {
+		return options, fmt.Errorf("namespace and name are required")
+	}
+
+	return options, nil
+}
+
+// Process is a single process of a container. The elapsed time is in seconds. The CPU and memory usage are in percent
+// and are only set, when they are available via the used method.
+type Process struct {
+	PID     int64    `json:"pid"`
+	PPID    int64    `json:"ppid"`
+	User    string   `json:"user"`
+	Elapsed int64    `json:"elapsed"`
+	CPU     *float64 `json:"cpu,omitempty"`
+	Memory  *float64 `json:"memory,omitempty"`
+	Command string   `json:"command"`
+}
+
+// Processes is the list of processes of a container and the method, which was used to get the processes.
+type Processes struct {
+	Method    string    `json:"method"`
+	Processes []Process `json:"processes"`
+}
+
+// List returns the processes of the container from the options. When the command could not be executed, because the
+// container doesn't contain a shell, the returned error recommends to use an ephemeral debug container instead.
+func List(ctx context.Context, config *rest.Config, options Options) (*Processes, error) {
+	var stdout, stderr bytes.Buffer
+
+	err := terminal.Exec(ctx, config, options.Namespace, options.Name, options.Container, []string{"sh", "-c", script}, options.Protocol, &stdout, &stderr)
+	if err != nil && stdout.Len() == 0 {
+		message := err.Error()
+		if stderrMessage := strings.TrimSpace(stderr.String()); stderrMessage != "" {
+			message = stderrMessage
+		}
+
+		if isMissingShell(err, message) {
+			return nil, fmt.Errorf("the container doesn't contain a shell, use an ephemeral debug container to inspect the processes: %s", message)
+		}
+		return nil, errors.New(message)
+	}
+
+	processes, err := parse(stdout.String())
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(processes.Processes, func(i, j int) bool {
+		return processes.Processes[i].PID < processes.Processes[j].PID
+	})
+
+	return processes, nil
+}
+
+// isMissingShell returns true, when the exec failed, because the shell is not available in the container. This is
+// the case when the container runtime can not find the executable or when the exit code is 126 or 127.
+func isMissingShell(err error, message string) bool {
+	if exitCode, _ := terminal.GetExitStatus(err); exitCode == 126 || exitCode == 127 {
+		return true
+	}
+
+	return strings.Contains(message, "executable file not found") || strings.Contains(message, "no such file or directory")
+}
+
+// parse parses the output of the script.
+func parse(output string) (*Processes, error) {
+	processes := &Processes{Processes: []Process{}}
+
+	columns := 0
+	var uptime float64
+	var memory float64
+	users := make(map[string]string)
+	var procLines []string
+
+	for _, line := range strings.Split(output, "\n") {
+		keyword, value, _ := strings.Cut(line, " ")
+
+		switch keyword {
+		case "method":
+			processes.Method = value
+		case "ps":
+			// The first line of the ps output is the header, which is used to get the number of columns.
+			if columns == 0 {
+				columns = len(strings.Fields(value))
+				continue
+			}
+			if process, ok := parsePSLine(value, columns); ok {
+				processes.Processes = append(processes.Processes, process)
+			}
+		case "uptime":
+			uptime, _ = strconv.ParseFloat(value, 64)
+		case "memory":
+			memory, _ = strconv.ParseFloat(value, 64)
+		case "user":
+			if name, uid, ok := strings.Cut(value, ":"); ok {
+				users[uid] = name
+			}
+		case "process":
+			procLines = append(procLines, value)
+		}
+	}
+
+	if processes.Method == "" {
+		return nil, fmt.Errorf("unexpected output")
+	}
+
+	for _, line := range procLines {
+		if process, ok := parseProcLine(line, uptime, memory, users); ok {
+			processes.Processes = append(processes.Processes, process)
+		}
+	}
+
+	return processes, nil
+}
+
+// parsePSLine parses a single line of the ps output. The number of columns is 7, when the "pcpu" and "pmem" columns
+// are included and 5 otherwise. The command is the remaining part of the line, so that the arguments are preserved.
+func parsePSLine(line string, columns int) (Process, bool) {
+	fields, command := splitN(line, columns-1)
+	if len(fields) != columns-1 {
+		return Process{}, false
+	}
+
+	pid, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return Process{}, false
+	}
+	ppid, _ := strconv.ParseInt(fields[1], 10, 64)
+
+	process := Process{
+		PID:     pid,
+		PPID:    ppid,
+		User:    fields[2],
+		Elapsed: parseElapsed(fields[3]),
+		Command: command,
+	}
+
+	if columns == 7 {
+		if cpu, err := strconv.ParseFloat(fields[4], 64); err == nil {
+			process.CPU = &cpu
+		}
+		if memory, err := strconv.ParseFloat(fields[5], 64); err == nil {
+			process.Memory = &memory
+		}
+	}
+
+	return process, true
+}
+
+// parseProcLine parses a process line of the "/proc" fallback. The stat content contains the command name in
+// parentheses, which can contain spaces, so that we split the remaining fields after the last closing parenthesis.
+// The CPU usage is calculated in the same way as ps does it: The used CPU time divided by the elapsed time.
+func parseProcLine(line string, uptime, memory float64, users map[string]string) (Process, bool) {
+	parts := strings.SplitN(line, "\t", 4)
+	if len(parts) != 4 {
+		return Process{}, false
+	}
+
+	pid, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return Process{}, false
+	}
+
+	stat := parts[2]
+	start := strings.Index(stat, "(")
+	end := strings.LastIndex(stat, ")")
+	if start < 0 || end < start {
+		return Process{}, false
+	}
+
+	// After the command name the fields start with the state (field 3 in proc(5)), so that the ppid is at index 1,
+	// utime at 11, stime at 12, starttime at 19 and rss at 21.
+	fields := strings.Fields(stat[end+1:])
+	if len(fields) < 22 {
+		return Process{}, false
+	}
+
+	ppid, _ := strconv.ParseInt(fields[1], 10, 64)
+	utime, _ := strconv.ParseFloat(fields[11], 64)
+	stime, _ := strconv.ParseFloat(fields[12], 64)
+	starttime, _ := strconv.ParseFloat(fields[19], 64)
+	rss, _ := strconv.ParseFloat(fields[21], 64)
+
+	process := Process{
+		PID:     pid,
+		PPID:    ppid,
+		User:    parts[1],
+		Command: parts[3],
+	}
+
+	if name, ok := users[parts[1]]; ok {
+		process.User = name
+	}
+
+	if process.Command == "" {
+		process.Command = "[" + stat[start+1:end] + "]"
+	}
+
+	if uptime > 0 {
+		elapsed := uptime - starttime/clockTicks
+		if elapsed > 0 {
+			process.Elapsed = int64(elapsed)
+
+			cpu := roundPercent((utime + stime) / clockTicks / elapsed * 100)
+			process.CPU = &cpu
+		}
+	}
+
+	if memory > 0 {
+		usage := roundPercent(rss * pageSize / (memory * 1024) * 100)
+		process.Memory = &usage
+	}
+
+	return process, true
+}
+
+// parseElapsed parses the elapsed time of ps in the format "[[dd-]hh:]mm:ss" and returns the elapsed time in seconds.
+func parseElapsed(value string) int64 {
+	var days int64
+	if d, rest, ok := strings.Cut(value, "-"); ok {
+		days, _ = strconv.ParseInt(d, 10, 64)
+		value = rest
+	}
+
+	var seconds int64
+	for _, part := range strings.Split(value, ":") {
+		n, err := strconv.ParseInt(part, 10, 64)
+		if err != nil {
+			return 0
+		}
+		seconds = seconds*60 + n
+	}
+
+	return days*24*60*60 + seconds
+}
+
+// splitN splits the first n whitespace separated fields from the line and returns the fields and the remaining part
+// of the line.
+func splitN(line string, n int) ([]string, string) {
+	var fields []string
+
+	rest := strings.TrimLeft(line, " \t")
+	for len(fields) < n && rest != "" {
+		end := strings.IndexAny(rest, " \t")
+		if end < 0 {
+			fields = append(fields, rest)
+			rest = ""
+			break
+		}
+
+		fields = append(fields, rest[:end])
+		rest = strings.TrimLeft(rest[end:], " \t")
+	}
+
+	return fields, rest
+}
+
+func roundPercent(value float64) float64 {
+	return float64(int64(value*10+0.5)) / 10
+}
@@ -0,0 +1,144 @@
+// Package proxy implements a generic proxy for requests against the Kubernetes API, similar to "kubectl proxy". The
+// target path of a request is validated, so that the proxy can only be used to send requests to the API server of the
+// configured cluster.
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// TruncatedHeader is the header, which is set to "true", when the response of the Kubernetes API exceeded the maximum
+// response size and was truncated by the server. The maximum response size is returned in the LimitHeader, so that the
+// client can decide to use a paginated request instead.
+const (
+	TruncatedHeader = "X-RESPONSE-TRUNCATED"
+	LimitHeader     = "X-RESPONSE-LIMIT"
+)
+
+// forwardedHeaders are the headers of the client, which are forwarded to the Kubernetes API. All other headers are
+// dropped, so that our custom headers with the credentials of the user and the auth token are never forwarded.
+var forwardedHeaders = []string{"Accept", "Content-Type"}
+
+// returnedHeaders are the headers of the Kubernetes API response, which are returned to the client.
+var returnedHeaders = []string{"Content-Type", "Content-Disposition", "Audit-Id", "Warning"}
+
+// ValidatePath checks that the given path can be used as target path of a proxy request. The path must be an absolute
+// path without a scheme or host, it must be clean (no ".." or "." elements and no duplicated slashes) and it must not
+// contain backslashes or control characters. This ensures that the request is always send to the API server of the
+// cluster and never to another host, e.g. via a path like "//example.com" or a path traversal.
+func ValidatePath(requestPath string) error {
+	if requestPath == "" || !strings.HasPrefix(requestPath, "/") {
+		return fmt.Errorf("path must be an absolute path")
+	}
+
+	if strings.HasPrefix(requestPath, "//") {
+		return fmt.Errorf("path must not contain a host")
+	}
+
+	if strings.ContainsAny(requestPath, "\\?#") {
+		return fmt.Errorf("path contains invalid characters")
+	}
+
+	for _, r := range requestPath {
+		if r < 0x20 || r == 0x7f {
+			return fmt.Errorf("path contains control characters")
+		}
+	}
+
+	// A trailing slash is allowed (e.g. for "/apis/"), but it is removed by path.Clean, so that we have to add it again
+	// before we compare the clean path with the requested path.
+	cleanPath := path.Clean(requestPath)
+	if strings.HasSuffix(requestPath, "/") && cleanPath != "/" {
+		cleanPath = cleanPath + "/"
+	}
+	if cleanPath != requestPath {
+		return fmt.Errorf("path must be clean")
+	}
+
+	return nil
+}
+
+// IsStreaming returns true, when the request returns a streaming response, which must not be buffered, e.g. a watch
+// request or the logs of a container with the "follow" parameter.
+func IsStreaming(query url.Values) bool {
+	for _, key := range []string{"watch", "follow"} {
+		if value := strings.ToLower(query.Get(key)); value == "true" || value == "1" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// NewRequest creates a new request against the Kubernetes API from the given rest config. The path must be validated
+// via ValidatePath before. The host of the rest config can contain a path (e.g. when the cluster is accessed via
+// Rancher), so that the requested path is joined with it.
+func NewRequest(ctx context.Context, restConfig *rest.Config, method, requestPath string, query url.Values, header http.Header, body io.Reader) (*http.Request, error) {
+	if err := ValidatePath(requestPath); err != nil {
+		return nil, err
+	}
+
+	host := restConfig.Host
+	if !strings.Contains(host, "://") {
+		host = "https://" + host
+	}
+
+	targetURL, err := url.Parse(host)
+	if err != nil {
+		return nil, err
+	}
+	targetURL.Path = strings.TrimSuffix(targetURL.Path, "/") + requestPath
+	targetURL.RawPath = ""
+	targetURL.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, method, targetURL.String(), body)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, key := range forwardedHeaders {
+		if value := header.Get(key); value != "" {
+			req.Header.Set(key, value)
+		}
+	}
+
+	return req, nil
+}
+
+// CopyHeaders copies the headers, which should be returned to the client, from the response of the Kubernetes API.
+func CopyHeaders(dst, src http.Header) {
+	for _, key := range returnedHeaders {
+		if values := src.Values(key); len(values) > 0 {
+			dst.Del(key)
+			for _, value := range values {
+				dst.Add(key, value)
+			}
+		}
+	}
+}
+
+// Do sends the request to the Kubernetes API with the transport of the given clientset, so that the credentials of
+// the user are added to the request. The http client of the clientset is reused, because creating a new client for
+// each request would also create a new transport (and connection pool) for each request. Only when the clientset
+// doesn't expose its http client (e.g. a fake clientset), a client is created from the rest config.
+func Do(clientset kubernetes.Interface, restConfig *rest.Config, req *http.Request) (*http.Response, error) {
+	if restClient, ok := clientset.CoreV1().RESTClient().(*rest.RESTClient); ok && restClient != nil && restClient.Client != nil {
+		return restClient.Client.Do(req)
+	}
+
+	client, err := rest.HTTPClientFor(restConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return client.Do(req)
+}
@@ -0,0 +1,218 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+)
+
+func TestValidatePath(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		path        string
+		expectError bool
+	}{
+		{name: "core api path", path: "/api/v1/namespaces/default/pods", expectError: false},
+		{name: "group api path", path: "/apis/apps/v1/deployments", expectError: false},
+		{name: "root path", path: "/", expectError: false},
+		{name: "trailing slash", path: "/apis/", expectError: false},
+		{name: "percent encoded dot segment is a literal name", path: "/api/v1/namespaces/%2e%2e/pods", expectError: false},
+		{name: "empty path", path: "", expectError: true},
+		{name: "relative path", path: "api/v1/pods", expectError: true},
+		{name: "host", path: "//example.com/api/v1/pods", expectError: true},
+		{name: "host with scheme", path: "https://example.com/api/v1/pods", expectError: true},
+		{name: "dot dot segment", path: "/api/v1/../../version", expectError: true},
+		{name: "trailing dot dot segment", path: "/api/v1/..", expectError: true},
+		{name: "dot segment", path: "/api/./v1/pods", expectError: true},
+		{name: "duplicated slashes", path: "/api//v1/pods", expectError: true},
+		{name: "duplicated trailing slashes", path: "/apis//", expectError: true},
+		{name: "backslash", path: "/api/v1\\..\\version", expectError: true},
+		{name: "backslash host", path: "/\\example.com", expectError: true},
+		{name: "query", path: "/api/v1/pods?watch=true", expectError: true},
+		{name: "fragment", path: "/api/v1/pods#fragment", expectError: true},
+		{name: "newline", path: "/api/v1/pods\nHost: example.com", expectError: true},
+		{name: "carriage return", path: "/api/v1/pods\r", expectError: true},
+		{name: "null byte", path: "/api/v1/pods\x00", expectError: true},
+		{name: "tab", path: "/api/v1/\tpods", expectError: true},
+		{name: "delete character", path: "/api/v1/pods\x7f", expectError: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := ValidatePath(tc.path)
+			if tc.expectError && err == nil {
+				t.Errorf("expected error for path %q", tc.path)
+			}
+			if !tc.expectError && err != nil {
+				t.Errorf("expected no error for path %q, got %v", tc.path, err)
+			}
+		})
+	}
+}
+
+func TestIsStreaming(t *testing.T) {
+	for _, tc := range []struct {
+		query    string
+		expected bool
+	}{
+		{query: "", expected: false},
+		{query: "watch=true", expected: true},
+		{query: "watch=1", expected: true},
+		{query: "watch=TRUE", expected: true},
+		{query: "watch=false", expected: false},
+		{query: "follow=true", expected: true},
+		{query: "follow=0", expected: false},
+		{query: "limit=10", expected: false},
+	} {
+		query, err := url.ParseQuery(tc.query)
+		if err != nil {
+			t.Fatalf("could not parse query %q: %v", tc.query, err)
+		}
+		if actual := IsStreaming(query); actual != tc.expected {
+			t.Errorf("expected streaming %t for %q, got %t", tc.expected, tc.query, actual)
+		}
+	}
+}
+
+func TestNewRequest(t *testing.T) {
+	header := http.Header{}
+	header.Set("Accept", "application/json")
+	header.Set("Content-Type", "application/merge-patch+json")
+	header.Set("X-Auth-Token", "token")
+	header.Set("X-User-Token", "user-token")
+	header.Set("Authorization", "Bearer user-token")
+
+	for _, tc := range []struct {
+		name        string
+		host        string
+		path        string
+		expectedURL string
+		expectError bool
+	}{
+		{name: "host", host: "https://10.0.0.1:6443", path: "/api/v1/pods", expectedURL: "https://10.0.0.1:6443/api/v1/pods?limit=10"},
+		{name: "host without scheme", host: "10.0.0.1:6443", path: "/api/v1/pods", expectedURL: "https://10.0.0.1:6443/api/v1/pods?limit=10"},
+		{name: "host with path", host: "https://rancher.example.com/k8s/clusters/c-1/", path: "/api/v1/pods", expectedURL: "https://rancher.example.com/k8s/clusters/c-1/api/v1/pods?limit=10"},
+		{name: "percent encoded dot segment is escaped", host: "https://10.0.0.1:6443", path: "/api/v1/namespaces/%2e%2e/pods", expectedURL: "https://10.0.0.1:6443/api/v1/namespaces/%252e%252e/pods?limit=10"},
+		{name: "host in path", host: "https://10.0.0.1:6443", path: "//example.com/api/v1/pods", expectError: true},
+		{name: "path traversal", host: "https://10.0.0.1:6443", path: "/api/../../version", expectError: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := NewRequest(context.Background(), &rest.Config{Host: tc.host}, http.MethodGet, tc.path, url.Values{"limit": []string{"10"}}, header, nil)
+			if tc.expectError {
+				if err == nil {
+					t.Fatalf("expected error, got request for %s", req.URL.String())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("could not create request: %v", err)
+			}
+
+			if req.URL.String() != tc.expectedURL {
+				t.Errorf("expected url %s, got %s", tc.expectedURL, req.URL.String())
+			}
+			if req.Header.Get("Accept") != "application/json" || req.Header.Get("Content-Type") != "application/merge-patch+json" {
+				t.Errorf("expected Accept and Content-Type headers to be forwarded, got %v", req.Header)
+			}
+			for _, key := range []string{"X-Auth-Token", "X-User-Token", "Authorization"} {
+				if req.Header.Get(key) != "" {
+					t.Errorf("expected header %s not to be forwarded", key)
+				}
+			}
+		})
+	}
+}
+
+func TestCopyHeaders(t *testing.T) {
+	src := http.Header{}
+	src.Set("Content-Type", "application/json")
+	src.Add("Warning", `299 - "first"`)
+	src.Add("Warning", `299 - "second"`)
+	src.Set("Set-Cookie", "session=secret")
+	src.Set("Www-Authenticate", "Basic")
+
+	dst := http.Header{}
+	dst.Set("Content-Type", "text/plain")
+	CopyHeaders(dst, src)
+
+	if dst.Get("Content-Type") != "application/json" {
+		t.Errorf("expected Content-Type to be replaced, got %q", dst.Get("Content-Type"))
+	}
+	if len(dst.Values("Warning")) != 2 {
+		t.Errorf("expected 2 Warning headers, got %v", dst.Values("Warning"))
+	}
+	if dst.Get("Set-Cookie") != "" || dst.Get("Www-Authenticate") != "" {
+		t.Errorf("expected only the returned headers to be copied, got %v", dst)
+	}
+}
+
+func TestDo(t *testing.T) {
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer user-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer api.Close()
+
+	// The transport is wrapped once for each created http client, so that we can check that the client of the clientset
+	// is reused for all requests.
+	var transports int32
+	restConfig := &rest.Config{
+		Host:        api.URL,
+		BearerToken: "user-token",
+		WrapTransport: func(rt http.RoundTripper) http.RoundTripper {
+			atomic.AddInt32(&transports, 1)
+			return rt
+		},
+	}
+
+	clientset, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		t.Fatalf("could not create clientset: %v", err)
+	}
+	created := atomic.LoadInt32(&transports)
+
+	for i := 0; i < 3; i++ {
+		req, err := NewRequest(context.Background(), restConfig, http.MethodGet, "/api/v1/pods", nil, nil, nil)
+		if err != nil {
+			t.Fatalf("could not create request: %v", err)
+		}
+
+		resp, err := Do(clientset, restConfig, req)
+		if err != nil {
+			t.Fatalf("could not send request: %v", err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("expected status code %d, got %d", http.StatusOK, resp.StatusCode)
+		}
+	}
+
+	if actual := atomic.LoadInt32(&transports); actual != created {
+		t.Errorf("expected the transport of the clientset to be reused, got %d new transports", actual-created)
+	}
+
+	// A fake clientset doesn't have a http client, so that a client must be created from the rest config.
+	req, err := NewRequest(context.Background(), restConfig, http.MethodGet, "/api/v1/pods", nil, nil, nil)
+	if err != nil {
+		t.Fatalf("could not create request: %v", err)
+	}
+
+	resp, err := Do(fake.NewSimpleClientset(), restConfig, req)
+	if err != nil {
+		t.Fatalf("could not send request with fake clientset: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected status code %d with fake clientset, got %d", http.StatusOK, resp.StatusCode)
+	}
+}
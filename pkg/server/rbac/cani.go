@@ -0,0 +1,177 @@
+// Package rbac implements helpers for the RBAC authorization of a cluster, e.g. to check which actions the current
+// user is allowed to perform, so that the app can disable forbidden actions instead of failing with a 403 error.
+package rbac
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/kubenav/kubenav/pkg/server/resources"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// DefaultReviewTimeout is the default timeout for a single access review. Authorization webhooks can be slow or not
+// respond at all, so that a review, which takes longer, is reported as "unknown" instead of blocking the caller.
+const DefaultReviewTimeout = 5 * time.Second
+
+// MaxReviews is the maximum number of reviews in a single batch.
+const MaxReviews = 100
+
+// reviewConcurrency is the number of access reviews, which are created in parallel for a batch.
+const reviewConcurrency = 10
+
+// ErrInvalidOptions is returned when an access review is invalid, e.g. because the verb is missing.
+var ErrInvalidOptions = fmt.Errorf("%w", resources.ErrInvalidOptions)
+
+// The result of an access review. "unknown" is returned, when the review could not be created or timed out.
+const (
+	ResultAllowed = "allowed"
+	ResultDenied  = "denied"
+	ResultUnknown = "unknown"
+)
+
+// Review is a single action, which should be checked for the current user. For a resource the verb and the resource
+// are required, the group is empty for the core API group. For a non-resource URL (e.g. "/healthz") the path and the
+// verb must be set and all other fields must be empty.
+type Review struct {
+	Verb        string `json:"verb"`
+	Group       string `json:"group,omitempty"`
+	Resource    string `json:"resource,omitempty"`
+	Subresource string `json:"subresource,omitempty"`
+	Name        string `json:"name,omitempty"`
+	Namespace   string `json:"namespace,omitempty"`
+	Path        string `json:"path,omitempty"`
+}
+
+// ReviewResult is the result of a single access review. The reason is the reason of the authorizer, which allowed or
+// denied the request. The message contains the evaluation error of the API server or the error, why the result is
+// unknown.
+type ReviewResult struct {
+	Review  Review `json:"review"`
+	Result  string `json:"result"`
+	Reason  string `json:"reason,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// BatchOptions are the options for a batch of access reviews. The timeout is the timeout of a single review in
+// seconds, if it is 0 DefaultReviewTimeout is used.
+type BatchOptions struct {
+	Reviews        []Review `json:"reviews"`
+	TimeoutSeconds int64    `json:"timeoutSeconds"`
+}
+
+// CanI checks if the current user is allowed to perform the action from the review, by creating a
+// SelfSubjectAccessReview. When the review times out or fails, the result is "unknown" and the error is returned in the
+// message of the result, only an invalid review returns an error.
+func CanI(ctx context.Context, clientset kubernetes.Interface, review Review, timeout time.Duration) (ReviewResult, error) {
+	if err := validate(review); err != nil {
+		return ReviewResult{}, err
+	}
+
+	if timeout <= 0 {
+		timeout = DefaultReviewTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	accessReview := &authorizationv1.SelfSubjectAccessReview{}
+	if review.Path != "" {
+		accessReview.Spec.NonResourceAttributes = &authorizationv1.NonResourceAttributes{
+			Path: review.Path,
+			Verb: review.Verb,
+		}
+	} else {
+		accessReview.Spec.ResourceAttributes = &authorizationv1.ResourceAttributes{
+			Namespace:   review.Namespace,
+			Verb:        review.Verb,
+			Group:       review.Group,
+			Resource:    review.Resource,
+			Subresource: review.Subresource,
+			Name:        review.Name,
+		}
+	}
+
+	result, err := clientset.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, accessReview, metav1.CreateOptions{})
+	if err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return ReviewResult{Review: review, Result: ResultUnknown, Message: fmt.Sprintf("access review timed out after %s", timeout)}, nil
+		}
+		return ReviewResult{Review: review, Result: ResultUnknown, Message: err.Error()}, nil
+	}
+
+	reviewResult := ReviewResult{Review: review, Result: ResultDenied, Reason: result.Status.Reason, Message: result.Status.EvaluationError}
+	if result.Status.Allowed {
+		reviewResult.Result = ResultAllowed
+	} else if !result.Status.Denied && result.Status.EvaluationError != "" {
+		// When no authorizer had an opinion and one of them failed, e.g. because an authorization webhook wasn't
+		// reachable, the request could be allowed, so that we can not say that it is denied.
+		reviewResult.Result = ResultUnknown
+	}
+
+	return reviewResult, nil
+}
+
+// CanIBatch checks all reviews from the options in parallel via CanI. The results are returned in the same order as
+// the reviews, so that the app can call the function once for all actions on a screen.
+func CanIBatch(ctx context.Context, clientset kubernetes.Interface, options BatchOptions) ([]ReviewResult, error) {
+	if len(options.Reviews) == 0 {
+		return nil, fmt.Errorf("%w: at least one review is required", ErrInvalidOptions)
+	}
+	if len(options.Reviews) > MaxReviews {
+		return nil, fmt.Errorf("%w: at most %d reviews are allowed", ErrInvalidOptions, MaxReviews)
+	}
+	for i, review := range options.Reviews {
+		if err := validate(review); err != nil {
+			return nil, fmt.Errorf("review %d: %w", i, err)
+		}
+	}
+
+	results := make([]ReviewResult, len(options.Reviews))
+	timeout := time.Duration(options.TimeoutSeconds) * time.Second
+
+	var wg sync.WaitGroup
+	semaphore := make(chan struct{}, reviewConcurrency)
+
+	for i, review := range options.Reviews {
+		wg.Add(1)
+		semaphore <- struct{}{}
+
+		go func(i int, review Review) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+
+			// The review was already validated, so that CanI never returns an error here.
+			results[i], _ = CanI(ctx, clientset, review, timeout)
+		}(i, review)
+	}
+
+	wg.Wait()
+
+	return results, nil
+}
+
+func validate(review Review) error {
+	if review.Verb == "" {
+		return fmt.Errorf("%w: verb is required", ErrInvalidOptions)
+	}
+
+	if review.Path != "" {
+		if review.Group != "" || review.Resource != "" || review.Subresource != "" || review.Name != "" || review.Namespace != "" {
+			return fmt.Errorf("%w: a review for a non-resource url must not contain a resource", ErrInvalidOptions)
+		}
+		return nil
+	}
+
+	if review.Resource == "" {
+		return fmt.Errorf("%w: resource or path is required", ErrInvalidOptions)
+	}
+
+	return nil
+}
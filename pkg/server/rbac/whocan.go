@@ -0,0 +1,260 @@
+package rbac
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+)
+
+// WhoCanOptions are the options for a reverse lookup of the subjects, which are allowed to perform the verb on the
+// resource. The group is empty for the core API group. When the namespace is empty, only the ClusterRoleBindings are
+// evaluated, otherwise also the RoleBindings of the namespace. When the name is empty, rules which are restricted to
+// resource names are not matching, like it is done by the API server for a request without a name.
+type WhoCanOptions struct {
+	Verb        string `json:"verb"`
+	Group       string `json:"group"`
+	Resource    string `json:"resource"`
+	Subresource string `json:"subresource"`
+	Name        string `json:"name"`
+	Namespace   string `json:"namespace"`
+}
+
+// WhoCanResult contains all bindings, which grant the verb on the resource from the options, sorted by their kind,
+// namespace and name.
+type WhoCanResult struct {
+	Bindings []BindingMatch `json:"bindings"`
+}
+
+// BindingMatch is a RoleBinding or ClusterRoleBinding, whose subjects are allowed to perform the verb. "Rule" is the
+// first rule of the referenced role, which matched. For an aggregated ClusterRole "Source" is the name of the
+// ClusterRole, which contains the rule.
+type BindingMatch struct {
+	Kind      string            `json:"kind"`
+	Name      string            `json:"name"`
+	Namespace string            `json:"namespace,omitempty"`
+	RoleRef   rbacv1.RoleRef    `json:"roleRef"`
+	Subjects  []rbacv1.Subject  `json:"subjects"`
+	Rule      rbacv1.PolicyRule `json:"rule"`
+	Source    string            `json:"source,omitempty"`
+}
+
+// sourceRule is a rule of a role together with the name of the ClusterRole it is defined in, which differs from the
+// referenced role for aggregated ClusterRoles.
+type sourceRule struct {
+	rule   rbacv1.PolicyRule
+	source string
+}
+
+// WhoCan returns all bindings, which allow their subjects to perform the verb on the resource from the options. The
+// evaluation is done on the client via the listed Roles, ClusterRoles and their bindings, so that the user must be
+// allowed to list them. Rules for non-resource URLs are never matching.
+func WhoCan(ctx context.Context, clientset kubernetes.Interface, options WhoCanOptions) (*WhoCanResult, error) {
+	if options.Verb == "" || options.Resource == "" {
+		return nil, fmt.Errorf("%w: verb and resource are required", ErrInvalidOptions)
+	}
+
+	clusterRoles, err := clientset.RbacV1().ClusterRoles().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	clusterRoleRules, err := getClusterRoleRules(clusterRoles.Items)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &WhoCanResult{Bindings: []BindingMatch{}}
+
+	clusterRoleBindings, err := clientset.RbacV1().ClusterRoleBindings().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, binding := range clusterRoleBindings.Items {
+		if binding.RoleRef.Kind != "ClusterRole" {
+			continue
+		}
+
+		if rule, ok := matchRules(clusterRoleRules[binding.RoleRef.Name], options); ok {
+			result.Bindings = append(result.Bindings, newBindingMatch("ClusterRoleBinding", binding.ObjectMeta, binding.RoleRef, binding.Subjects, rule, binding.RoleRef.Name))
+		}
+	}
+
+	if options.Namespace != "" {
+		roles, err := clientset.RbacV1().Roles(options.Namespace).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, err
+		}
+
+		roleRules := make(map[string][]sourceRule, len(roles.Items))
+		for _, role := range roles.Items {
+			for _, rule := range role.Rules {
+				roleRules[role.Name] = append(roleRules[role.Name], sourceRule{rule: rule, source: role.Name})
+			}
+		}
+
+		roleBindings, err := clientset.RbacV1().RoleBindings(options.Namespace).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, binding := range roleBindings.Items {
+			var rules []sourceRule
+			switch binding.RoleRef.Kind {
+			case "Role":
+				rules = roleRules[binding.RoleRef.Name]
+			case "ClusterRole":
+				rules = clusterRoleRules[binding.RoleRef.Name]
+			}
+
+			if rule, ok := matchRules(rules, options); ok {
+				result.Bindings = append(result.Bindings, newBindingMatch("RoleBinding", binding.ObjectMeta, binding.RoleRef, binding.Subjects, rule, binding.RoleRef.Name))
+			}
+		}
+	}
+
+	sort.Slice(result.Bindings, func(i, j int) bool {
+		a, b := result.Bindings[i], result.Bindings[j]
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return a.Name < b.Name
+	})
+
+	return result, nil
+}
+
+func newBindingMatch(kind string, meta metav1.ObjectMeta, roleRef rbacv1.RoleRef, subjects []rbacv1.Subject, rule sourceRule, roleName string) BindingMatch {
+	match := BindingMatch{
+		Kind:      kind,
+		Name:      meta.Name,
+		Namespace: meta.Namespace,
+		RoleRef:   roleRef,
+		Subjects:  subjects,
+		Rule:      rule.rule,
+	}
+	if match.Subjects == nil {
+		match.Subjects = []rbacv1.Subject{}
+	}
+	if rule.source != roleName {
+		match.Source = rule.source
+	}
+
+	return match
+}
+
+// getClusterRoleRules returns the rules of all ClusterRoles by their name. The rules of an aggregated ClusterRole are
+// the rules of all ClusterRoles, which are selected by its aggregation rule. The aggregation controller writes them to
+// the aggregated ClusterRole, but we do not rely on it, so that we can also return the source of a rule. Like the
+// controller we only aggregate one level, the selected ClusterRoles contribute their own rules.
+func getClusterRoleRules(clusterRoles []rbacv1.ClusterRole) (map[string][]sourceRule, error) {
+	rules := make(map[string][]sourceRule, len(clusterRoles))
+
+	for _, clusterRole := range clusterRoles {
+		if clusterRole.AggregationRule == nil {
+			for _, rule := range clusterRole.Rules {
+				rules[clusterRole.Name] = append(rules[clusterRole.Name], sourceRule{rule: rule, source: clusterRole.Name})
+			}
+		}
+	}
+
+	for _, clusterRole := range clusterRoles {
+		if clusterRole.AggregationRule == nil {
+			continue
+		}
+
+		for _, labelSelector := range clusterRole.AggregationRule.ClusterRoleSelectors {
+			selector, err := metav1.LabelSelectorAsSelector(&labelSelector)
+			if err != nil {
+				return nil, fmt.Errorf("invalid aggregation rule of cluster role %s: %w", clusterRole.Name, err)
+			}
+
+			for _, selected := range clusterRoles {
+				if selected.Name == clusterRole.Name || !selector.Matches(labels.Set(selected.Labels)) {
+					continue
+				}
+
+				for _, rule := range selected.Rules {
+					rules[clusterRole.Name] = append(rules[clusterRole.Name], sourceRule{rule: rule, source: selected.Name})
+				}
+			}
+		}
+	}
+
+	return rules, nil
+}
+
+// matchRules returns the first rule, which allows the verb on the resource from the options.
+func matchRules(rules []sourceRule, options WhoCanOptions) (sourceRule, bool) {
+	for _, rule := range rules {
+		if ruleMatches(rule.rule, options) {
+			return rule, true
+		}
+	}
+
+	return sourceRule{}, false
+}
+
+// ruleMatches implements the matching of a policy rule like the RBAC authorizer of the API server: The verb, API group
+// and resource must be contained in the rule or the rule must contain the "*" wildcard. A rule with resource names
+// only matches requests for one of these names.
+func ruleMatches(rule rbacv1.PolicyRule, options WhoCanOptions) bool {
+	return containsOrWildcard(rule.Verbs, options.Verb) &&
+		containsOrWildcard(rule.APIGroups, options.Group) &&
+		resourceMatches(rule.Resources, options.Resource, options.Subresource) &&
+		resourceNameMatches(rule.ResourceNames, options.Name)
+}
+
+func containsOrWildcard(values []string, value string) bool {
+	for _, v := range values {
+		if v == rbacv1.VerbAll || v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// resourceMatches checks if the resource and subresource are matched by the resources of a rule. A subresource is
+// only matched by "resource/subresource", "*/subresource" or "*", but not by the resource alone.
+func resourceMatches(ruleResources []string, resource, subresource string) bool {
+	combined := resource
+	if subresource != "" {
+		combined = resource + "/" + subresource
+	}
+
+	for _, ruleResource := range ruleResources {
+		if ruleResource == rbacv1.ResourceAll || ruleResource == combined {
+			return true
+		}
+
+		if subresource != "" && strings.HasPrefix(ruleResource, "*/") && ruleResource[2:] == subresource {
+			return true
+		}
+	}
+
+	return false
+}
+
+func resourceNameMatches(resourceNames []string, name string) bool {
+	if len(resourceNames) == 0 {
+		return true
+	}
+
+	for _, resourceName := range resourceNames {
+		if resourceName == name {
+			return true
+		}
+	}
+
+	return false
+}
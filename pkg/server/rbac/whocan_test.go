@@ -0,0 +1,316 @@
+package rbac
+
+import (
+	"context"
+	"testing"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestRuleMatches(t *testing.T) {
+	getPods := WhoCanOptions{Verb: "get", Resource: "pods"}
+	getPod := WhoCanOptions{Verb: "get", Resource: "pods", Name: "nginx"}
+	getPodLog := WhoCanOptions{Verb: "get", Resource: "pods", Subresource: "log"}
+	listDeployments := WhoCanOptions{Verb: "list", Group: "apps", Resource: "deployments"}
+
+	for _, tc := range []struct {
+		name     string
+		rule     rbacv1.PolicyRule
+		options  WhoCanOptions
+		expected bool
+	}{
+		{
+			name:     "exact match",
+			rule:     rbacv1.PolicyRule{Verbs: []string{"get"}, APIGroups: []string{""}, Resources: []string{"pods"}},
+			options:  getPods,
+			expected: true,
+		},
+		{
+			name:     "other verb",
+			rule:     rbacv1.PolicyRule{Verbs: []string{"list"}, APIGroups: []string{""}, Resources: []string{"pods"}},
+			options:  getPods,
+			expected: false,
+		},
+		{
+			name:     "other group",
+			rule:     rbacv1.PolicyRule{Verbs: []string{"list"}, APIGroups: []string{""}, Resources: []string{"deployments"}},
+			options:  listDeployments,
+			expected: false,
+		},
+		{
+			name:     "wildcard verb",
+			rule:     rbacv1.PolicyRule{Verbs: []string{"*"}, APIGroups: []string{""}, Resources: []string{"pods"}},
+			options:  getPods,
+			expected: true,
+		},
+		{
+			name:     "wildcard group",
+			rule:     rbacv1.PolicyRule{Verbs: []string{"list"}, APIGroups: []string{"*"}, Resources: []string{"deployments"}},
+			options:  listDeployments,
+			expected: true,
+		},
+		{
+			name:     "wildcard resource",
+			rule:     rbacv1.PolicyRule{Verbs: []string{"list"}, APIGroups: []string{"apps"}, Resources: []string{"*"}},
+			options:  listDeployments,
+			expected: true,
+		},
+		{
+			name:     "resource names without name",
+			rule:     rbacv1.PolicyRule{Verbs: []string{"get"}, APIGroups: []string{""}, Resources: []string{"pods"}, ResourceNames: []string{"nginx"}},
+			options:  getPods,
+			expected: false,
+		},
+		{
+			name:     "resource names with matching name",
+			rule:     rbacv1.PolicyRule{Verbs: []string{"get"}, APIGroups: []string{""}, Resources: []string{"pods"}, ResourceNames: []string{"redis", "nginx"}},
+			options:  getPod,
+			expected: true,
+		},
+		{
+			name:     "resource names with other name",
+			rule:     rbacv1.PolicyRule{Verbs: []string{"get"}, APIGroups: []string{""}, Resources: []string{"pods"}, ResourceNames: []string{"redis"}},
+			options:  getPod,
+			expected: false,
+		},
+		{
+			name:     "no resource names with name",
+			rule:     rbacv1.PolicyRule{Verbs: []string{"get"}, APIGroups: []string{""}, Resources: []string{"pods"}},
+			options:  getPod,
+			expected: true,
+		},
+		{
+			name:     "resource/subresource",
+			rule:     rbacv1.PolicyRule{Verbs: []string{"get"}, APIGroups: []string{""}, Resources: []string{"pods/log"}},
+			options:  getPodLog,
+			expected: true,
+		},
+		{
+			name:     "*/subresource",
+			rule:     rbacv1.PolicyRule{Verbs: []string{"get"}, APIGroups: []string{""}, Resources: []string{"*/log"}},
+			options:  getPodLog,
+			expected: true,
+		},
+		{
+			name:     "*/subresource for other subresource",
+			rule:     rbacv1.PolicyRule{Verbs: []string{"get"}, APIGroups: []string{""}, Resources: []string{"*/exec"}},
+			options:  getPodLog,
+			expected: false,
+		},
+		{
+			name:     "*/subresource without subresource",
+			rule:     rbacv1.PolicyRule{Verbs: []string{"get"}, APIGroups: []string{""}, Resources: []string{"*/log"}},
+			options:  getPods,
+			expected: false,
+		},
+		{
+			name:     "resource does not match subresource",
+			rule:     rbacv1.PolicyRule{Verbs: []string{"get"}, APIGroups: []string{""}, Resources: []string{"pods"}},
+			options:  getPodLog,
+			expected: false,
+		},
+		{
+			name:     "subresource does not match resource",
+			rule:     rbacv1.PolicyRule{Verbs: []string{"get"}, APIGroups: []string{""}, Resources: []string{"pods/log"}},
+			options:  getPods,
+			expected: false,
+		},
+		{
+			name:     "wildcard resource matches subresource",
+			rule:     rbacv1.PolicyRule{Verbs: []string{"get"}, APIGroups: []string{""}, Resources: []string{"*"}},
+			options:  getPodLog,
+			expected: true,
+		},
+		{
+			name:     "non-resource urls only",
+			rule:     rbacv1.PolicyRule{Verbs: []string{"*"}, NonResourceURLs: []string{"*"}},
+			options:  getPods,
+			expected: false,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if actual := ruleMatches(tc.rule, tc.options); actual != tc.expected {
+				t.Errorf("expected %t, got %t", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestMatchRules(t *testing.T) {
+	rules := []sourceRule{
+		{rule: rbacv1.PolicyRule{Verbs: []string{"get"}, NonResourceURLs: []string{"/healthz"}}, source: "health"},
+		{rule: rbacv1.PolicyRule{Verbs: []string{"get"}, APIGroups: []string{""}, Resources: []string{"pods"}, ResourceNames: []string{"nginx"}}, source: "nginx"},
+		{rule: rbacv1.PolicyRule{Verbs: []string{"get", "list"}, APIGroups: []string{""}, Resources: []string{"pods"}}, source: "pods"},
+	}
+
+	for _, tc := range []struct {
+		name           string
+		options        WhoCanOptions
+		expectedSource string
+	}{
+		{name: "first matching rule with name", options: WhoCanOptions{Verb: "get", Resource: "pods", Name: "nginx"}, expectedSource: "nginx"},
+		{name: "skip rule with resource names", options: WhoCanOptions{Verb: "get", Resource: "pods"}, expectedSource: "pods"},
+		{name: "no matching rule", options: WhoCanOptions{Verb: "delete", Resource: "pods"}, expectedSource: ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rule, ok := matchRules(rules, tc.options)
+			if ok != (tc.expectedSource != "") || rule.source != tc.expectedSource {
+				t.Errorf("expected rule from %q, got %q (%t)", tc.expectedSource, rule.source, ok)
+			}
+		})
+	}
+}
+
+func TestGetClusterRoleRules(t *testing.T) {
+	aggregationRule := func(selectors ...map[string]string) *rbacv1.AggregationRule {
+		rule := &rbacv1.AggregationRule{}
+		for _, selector := range selectors {
+			rule.ClusterRoleSelectors = append(rule.ClusterRoleSelectors, metav1.LabelSelector{MatchLabels: selector})
+		}
+		return rule
+	}
+	podsRule := rbacv1.PolicyRule{Verbs: []string{"get"}, APIGroups: []string{""}, Resources: []string{"pods"}}
+	secretsRule := rbacv1.PolicyRule{Verbs: []string{"get"}, APIGroups: []string{""}, Resources: []string{"secrets"}}
+
+	clusterRoles := []rbacv1.ClusterRole{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "view-pods", Labels: map[string]string{"aggregate-to-view": "true"}},
+			Rules:      []rbacv1.PolicyRule{podsRule},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "edit-secrets", Labels: map[string]string{"aggregate-to-edit": "true"}},
+			Rules:      []rbacv1.PolicyRule{secretsRule},
+		},
+		{
+			// The aggregated ClusterRole matches its own selector and contains the rules written by the aggregation
+			// controller, which must not be returned twice.
+			ObjectMeta:      metav1.ObjectMeta{Name: "view", Labels: map[string]string{"aggregate-to-view": "true"}},
+			AggregationRule: aggregationRule(map[string]string{"aggregate-to-view": "true"}),
+			Rules:           []rbacv1.PolicyRule{podsRule},
+		},
+		{
+			ObjectMeta:      metav1.ObjectMeta{Name: "edit"},
+			AggregationRule: aggregationRule(map[string]string{"aggregate-to-view": "true"}, map[string]string{"aggregate-to-edit": "true"}),
+		},
+		{
+			ObjectMeta:      metav1.ObjectMeta{Name: "empty"},
+			AggregationRule: aggregationRule(map[string]string{"aggregate-to-admin": "true"}),
+		},
+	}
+
+	rules, err := getClusterRoleRules(clusterRoles)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	for _, tc := range []struct {
+		name            string
+		expectedSources []string
+	}{
+		{name: "view-pods", expectedSources: []string{"view-pods"}},
+		{name: "edit-secrets", expectedSources: []string{"edit-secrets"}},
+		{name: "view", expectedSources: []string{"view-pods"}},
+		{name: "edit", expectedSources: []string{"view-pods", "view", "edit-secrets"}},
+		{name: "empty", expectedSources: nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var sources []string
+			for _, rule := range rules[tc.name] {
+				sources = append(sources, rule.source)
+			}
+
+			if len(sources) != len(tc.expectedSources) {
+				t.Fatalf("expected sources %v, got %v", tc.expectedSources, sources)
+			}
+			for i := range sources {
+				if sources[i] != tc.expectedSources[i] {
+					t.Fatalf("expected sources %v, got %v", tc.expectedSources, sources)
+				}
+			}
+		})
+	}
+
+	invalid := []rbacv1.ClusterRole{{
+		ObjectMeta: metav1.ObjectMeta{Name: "invalid"},
+		AggregationRule: &rbacv1.AggregationRule{ClusterRoleSelectors: []metav1.LabelSelector{{
+			MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "a", Operator: "invalid"}},
+		}}},
+	}}
+	if _, err := getClusterRoleRules(invalid); err == nil {
+		t.Errorf("expected error for invalid aggregation rule")
+	}
+}
+
+func TestWhoCanRoleBindingToClusterRole(t *testing.T) {
+	clientset := fake.NewSimpleClientset(
+		&rbacv1.ClusterRole{
+			ObjectMeta: metav1.ObjectMeta{Name: "pod-reader"},
+			Rules:      []rbacv1.PolicyRule{{Verbs: []string{"get"}, APIGroups: []string{""}, Resources: []string{"pods"}}},
+		},
+		&rbacv1.ClusterRoleBinding{
+			ObjectMeta: metav1.ObjectMeta{Name: "cluster-readers"},
+			RoleRef:    rbacv1.RoleRef{Kind: "ClusterRole", Name: "pod-reader"},
+			Subjects:   []rbacv1.Subject{{Kind: "Group", Name: "readers"}},
+		},
+		&rbacv1.RoleBinding{
+			ObjectMeta: metav1.ObjectMeta{Name: "readers", Namespace: "default"},
+			RoleRef:    rbacv1.RoleRef{Kind: "ClusterRole", Name: "pod-reader"},
+			Subjects:   []rbacv1.Subject{{Kind: "User", Name: "alice"}},
+		},
+		&rbacv1.RoleBinding{
+			ObjectMeta: metav1.ObjectMeta{Name: "readers", Namespace: "kube-system"},
+			RoleRef:    rbacv1.RoleRef{Kind: "ClusterRole", Name: "pod-reader"},
+			Subjects:   []rbacv1.Subject{{Kind: "User", Name: "bob"}},
+		},
+		&rbacv1.Role{
+			ObjectMeta: metav1.ObjectMeta{Name: "pod-reader", Namespace: "default"},
+			Rules:      []rbacv1.PolicyRule{{Verbs: []string{"list"}, APIGroups: []string{""}, Resources: []string{"pods"}}},
+		},
+	)
+
+	for _, tc := range []struct {
+		name     string
+		options  WhoCanOptions
+		expected []string
+	}{
+		{
+			name:     "namespaced",
+			options:  WhoCanOptions{Verb: "get", Resource: "pods", Namespace: "default"},
+			expected: []string{"ClusterRoleBinding//cluster-readers", "RoleBinding/default/readers"},
+		},
+		{
+			name:     "cluster scoped",
+			options:  WhoCanOptions{Verb: "get", Resource: "pods"},
+			expected: []string{"ClusterRoleBinding//cluster-readers"},
+		},
+		{
+			// The RoleBinding references the ClusterRole and not the Role with the same name.
+			name:     "role with the same name",
+			options:  WhoCanOptions{Verb: "list", Resource: "pods", Namespace: "default"},
+			expected: nil,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := WhoCan(context.Background(), clientset, tc.options)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			var bindings []string
+			for _, binding := range result.Bindings {
+				bindings = append(bindings, binding.Kind+"/"+binding.Namespace+"/"+binding.Name)
+			}
+
+			if len(bindings) != len(tc.expected) {
+				t.Fatalf("expected bindings %v, got %v", tc.expected, bindings)
+			}
+			for i := range bindings {
+				if bindings[i] != tc.expected[i] {
+					t.Fatalf("expected bindings %v, got %v", tc.expected, bindings)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package requestcache
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// Requests deduplicates the concurrent GET requests of the KubernetesRequest function of the bindings.
+var Requests = NewGroup[[]byte]()
+
+// deduplicated is the number of requests, which were deduplicated by all groups.
+var deduplicated atomic.Int64
+
+// DedupStats are the statistics of the deduplication of requests, which are returned by the debug stats endpoint of the
+// server. "Deduplicated" is the number of requests, which shared the upstream call of another request.
+type DedupStats struct {
+	Deduplicated int64 `json:"deduplicated"`
+}
+
+// GetDedupStats returns the statistics of the deduplication of all groups.
+func GetDedupStats() DedupStats {
+	return DedupStats{Deduplicated: deduplicated.Load()}
+}
+
+// Group deduplicates concurrent identical requests, similar to the singleflight package: While a call for a key is in
+// flight, all other callers with the same key wait for the result of this call instead of starting a new one. The call
+// is removed from the group as soon as it completes, so that the results are never cached.
+//
+// In contrast to singleflight, the call is executed with its own context, which is only canceled when all callers
+// canceled their context, so that a canceled caller doesn't cancel the call for the other callers.
+type Group[T any] struct {
+	lock  sync.Mutex
+	calls map[string]*call[T]
+}
+
+type call[T any] struct {
+	done    chan struct{}
+	cancel  context.CancelFunc
+	waiters int
+
+	result T
+	err    error
+}
+
+// NewGroup returns a new Group.
+func NewGroup[T any]() *Group[T] {
+	return &Group[T]{calls: make(map[string]*call[T])}
+}
+
+// DedupKey returns the key for a request to the cluster. Only GET requests are deduplicated, for all other methods an
+// empty key is returned.
+func DedupKey(cluster, method, requestURL string) string {
+	if method != http.MethodGet {
+		return ""
+	}
+	return cluster + "\x00" + method + "\x00" + requestURL
+}
+
+// Do executes the function for the key and returns its result. If a call for the key is already in flight, the result
+// of this call is returned instead and "shared" is true. If the key is empty, the function is always executed. When
+// the context of the caller is canceled before the call completes, the error of the context is returned.
+func (g *Group[T]) Do(ctx context.Context, key string, fn func(ctx context.Context) (T, error)) (T, bool, error) {
+	if key == "" {
+		result, err := fn(ctx)
+		return result, false, err
+	}
+
+	g.lock.Lock()
+	c, shared := g.calls[key]
+	if shared {
+		deduplicated.Add(1)
+	} else {
+		callCtx, cancel := context.WithCancel(context.Background())
+		c = &call[T]{done: make(chan struct{}), cancel: cancel}
+		g.calls[key] = c
+		go g.run(callCtx, key, c, fn)
+	}
+	c.waiters++
+	g.lock.Unlock()
+
+	select {
+	case <-c.done:
+		return c.result, shared, c.err
+	case <-ctx.Done():
+		g.lock.Lock()
+		c.waiters--
+		if c.waiters == 0 {
+			c.cancel()
+			if g.calls[key] == c {
+				delete(g.calls, key)
+			}
+		}
+		g.lock.Unlock()
+
+		var result T
+		return result, shared, ctx.Err()
+	}
+}
+
+func (g *Group[T]) run(ctx context.Context, key string, c *call[T], fn func(ctx context.Context) (T, error)) {
+	defer c.cancel()
+
+	c.result, c.err = fn(ctx)
+
+	g.lock.Lock()
+	if g.calls[key] == c {
+		delete(g.calls, key)
+	}
+	g.lock.Unlock()
+
+	close(c.done)
+}
@@ -0,0 +1,357 @@
+package requestcache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultOfflineMaxSize is the default maximum size of all responses in the offline store.
+const DefaultOfflineMaxSize = 100 * 1024 * 1024
+
+// offlineFileExtension is the extension of the files of the offline store.
+const offlineFileExtension = ".json"
+
+// Offline is the offline store, which is used by the KubernetesRequest function of the bindings. It is disabled until
+// it is enabled via Enable.
+var Offline = &OfflineStore{}
+
+// ErrOfflineDisabled is returned by the functions of the offline store, which require an enabled store.
+var ErrOfflineDisabled = errors.New("offline cache is not enabled")
+
+// OfflineOptions are the options for the offline store. The directory should be located in the cache directory of the
+// app, so that it can be cleared by the operating system. If the maximum size (in bytes) is 0, DefaultOfflineMaxSize is
+// used. Responses for Secrets are only persisted, when "IncludeSecrets" is true.
+type OfflineOptions struct {
+	Dir            string `json:"dir"`
+	MaxSize        int64  `json:"maxSize"`
+	IncludeSecrets bool   `json:"includeSecrets"`
+}
+
+// OfflineEntry is a persisted response of a GET request. The cluster and url are stored together with the body, so that
+// the store can be reported and hash collisions can be detected.
+type OfflineEntry struct {
+	Cluster  string          `json:"cluster"`
+	URL      string          `json:"url"`
+	StoredAt time.Time       `json:"storedAt"`
+	Body     json.RawMessage `json:"body"`
+}
+
+// OfflineReport is the size report of the offline store for the settings screen of the app. "Clusters" contains the
+// number of entries and their size for each cluster.
+type OfflineReport struct {
+	Enabled  bool                           `json:"enabled"`
+	Entries  int                            `json:"entries"`
+	Size     int64                          `json:"size"`
+	MaxSize  int64                          `json:"maxSize"`
+	Oldest   *time.Time                     `json:"oldest,omitempty"`
+	Clusters map[string]OfflineClusterUsage `json:"clusters"`
+}
+
+// OfflineClusterUsage is the usage of the offline store by a single cluster.
+type OfflineClusterUsage struct {
+	Entries int   `json:"entries"`
+	Size    int64 `json:"size"`
+}
+
+// OfflineStore persists the last known responses of GET requests on disk, so that they can be returned when the
+// cluster isn't reachable. Each response is stored in its own file in a directory per cluster. When the size of all
+// files exceeds the maximum size, the oldest responses are removed.
+type OfflineStore struct {
+	lock    sync.Mutex
+	options *OfflineOptions
+}
+
+// Enable enables the offline store with the given options. The directory is created, when it doesn't exist. If
+// the store is already enabled the options are replaced, the existing entries are kept.
+func (s *OfflineStore) Enable(options OfflineOptions) error {
+	if options.Dir == "" {
+		return fmt.Errorf("offline cache directory is required")
+	}
+	if options.MaxSize <= 0 {
+		options.MaxSize = DefaultOfflineMaxSize
+	}
+
+	if err := os.MkdirAll(options.Dir, 0700); err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.options = &options
+	s.evict()
+	return nil
+}
+
+// Disable disables the offline store. The persisted responses are kept, until they are removed via Purge.
+func (s *OfflineStore) Disable() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.options = nil
+}
+
+// Set persists the response body of a GET request for the given cluster and url. Only responses for objects and lists
+// of the Kubernetes API are persisted, but no subresources like logs. If the store is disabled, nothing is done.
+func (s *OfflineStore) Set(cluster, requestURL string, body []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.options == nil {
+		return nil
+	}
+
+	resource, ok := offlineResource(apiPath(requestURL))
+	if !ok || (resource == "secrets" && !s.options.IncludeSecrets) {
+		return nil
+	}
+	if int64(len(body)) > s.options.MaxSize/maxEntryFraction || !json.Valid(body) {
+		return nil
+	}
+
+	data, err := json.Marshal(OfflineEntry{Cluster: cluster, URL: requestURL, StoredAt: time.Now(), Body: body})
+	if err != nil {
+		return err
+	}
+
+	dir, file := s.entryPath(cluster, requestURL)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	// The entry is written to a temporary file first, so that a crash never leaves a partial entry behind.
+	tmp, err := os.CreateTemp(dir, "entry-*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), file); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	s.evict()
+	return nil
+}
+
+// Get returns the persisted response for the given cluster and url, when it isn't older than the given staleness
+// tolerance. A tolerance of 0 returns the response regardless of its age.
+func (s *OfflineStore) Get(cluster, requestURL string, tolerance time.Duration) (*OfflineEntry, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.options == nil {
+		return nil, false
+	}
+
+	_, file := s.entryPath(cluster, requestURL)
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, false
+	}
+
+	var entry OfflineEntry
+	if err := json.Unmarshal(data, &entry); err != nil || entry.Cluster != cluster || entry.URL != requestURL {
+		return nil, false
+	}
+
+	if tolerance > 0 && time.Since(entry.StoredAt) > tolerance {
+		return nil, false
+	}
+
+	return &entry, true
+}
+
+// Purge removes all persisted responses of the given cluster. If the cluster is empty, the responses of all clusters
+// are removed.
+func (s *OfflineStore) Purge(cluster string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.options == nil {
+		return ErrOfflineDisabled
+	}
+
+	if cluster != "" {
+		dir, _ := s.entryPath(cluster, "")
+		return os.RemoveAll(dir)
+	}
+
+	dirs, err := os.ReadDir(s.options.Dir)
+	if err != nil {
+		return err
+	}
+	for _, dir := range dirs {
+		if err := os.RemoveAll(filepath.Join(s.options.Dir, dir.Name())); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Report returns the number of entries and the size of the offline store, in total and per cluster.
+func (s *OfflineStore) Report() (*OfflineReport, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	report := &OfflineReport{Clusters: make(map[string]OfflineClusterUsage)}
+	if s.options == nil {
+		return report, nil
+	}
+
+	report.Enabled = true
+	report.MaxSize = s.options.MaxSize
+
+	files, err := s.files()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, file := range files {
+		report.Entries++
+		report.Size += file.size
+		if report.Oldest == nil || file.modTime.Before(*report.Oldest) {
+			modTime := file.modTime
+			report.Oldest = &modTime
+		}
+
+		// The file names are hashes, so that the cluster must be read from the entry itself.
+		cluster := readCluster(file.path)
+		usage := report.Clusters[cluster]
+		usage.Entries++
+		usage.Size += file.size
+		report.Clusters[cluster] = usage
+	}
+
+	return report, nil
+}
+
+type offlineFile struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// files returns all entries of the store. The lock must be held by the caller.
+func (s *OfflineStore) files() ([]offlineFile, error) {
+	var files []offlineFile
+
+	err := filepath.WalkDir(s.options.Dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(path, offlineFileExtension) {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		files = append(files, offlineFile{path: path, size: info.Size(), modTime: info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
+
+// evict removes the oldest entries, until the size of all entries is below the maximum size. The lock must be held by
+// the caller.
+func (s *OfflineStore) evict() {
+	files, err := s.files()
+	if err != nil {
+		return
+	}
+
+	var size int64
+	for _, file := range files {
+		size += file.size
+	}
+	if size <= s.options.MaxSize {
+		return
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].modTime.Before(files[j].modTime)
+	})
+	for _, file := range files {
+		if size <= s.options.MaxSize {
+			break
+		}
+		if err := os.Remove(file.path); err == nil {
+			size -= file.size
+		}
+	}
+}
+
+// entryPath returns the directory of the cluster and the file of the entry for the given url. The cluster and url are
+// hashed, so that they can be used as file names. The lock must be held by the caller.
+func (s *OfflineStore) entryPath(cluster, requestURL string) (string, string) {
+	dir := filepath.Join(s.options.Dir, hash(cluster))
+	return dir, filepath.Join(dir, hash(requestURL)+offlineFileExtension)
+}
+
+func hash(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
+
+func readCluster(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+
+	var entry struct {
+		Cluster string `json:"cluster"`
+	}
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return ""
+	}
+	return entry.Cluster
+}
+
+// offlineResource returns the resource of the given API path, when the path is the path of a list or a single object.
+// For subresources (e.g. logs) and all other paths false is returned.
+func offlineResource(path string) (string, bool) {
+	segments := strings.Split(strings.TrimPrefix(path, "/"), "/")
+
+	switch {
+	case len(segments) >= 3 && segments[0] == "api":
+		segments = segments[2:]
+	case len(segments) >= 4 && segments[0] == "apis":
+		segments = segments[3:]
+	default:
+		return "", false
+	}
+
+	if segments[0] == "namespaces" && len(segments) >= 3 {
+		segments = segments[2:]
+	}
+
+	if len(segments) > 2 {
+		return "", false
+	}
+	return segments[0], true
+}
@@ -0,0 +1,320 @@
+// Package requestcache implements an optional cache for the GET requests against the Kubernetes API, which are made
+// via the KubernetesRequest function of the mobile and desktop bindings. The cache is opt-in per request via the cache
+// control parameter, so that screens which poll the same lists can reuse a recent response. The memory of the cache is
+// bounded, the least recently used entries are evicted when the size limit is reached.
+package requestcache
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"k8s.io/client-go/rest"
+)
+
+// The values of the cache control parameter. With an empty value the cache isn't used at all. "bypass" always sends
+// the request to the Kubernetes API, but stores the response, so that it can be used to refresh a cached list.
+// "prefer-cache" returns a cached response, which isn't older than the default TTL of the cache, and "max-age=<n>"
+// returns a cached response, which isn't older than n seconds.
+const (
+	CacheControlNone        = ""
+	CacheControlBypass      = "bypass"
+	CacheControlPreferCache = "prefer-cache"
+	cacheControlMaxAge      = "max-age="
+)
+
+// The defaults for the cache, which is used by the bindings.
+const (
+	DefaultTTL     = 30 * time.Second
+	DefaultMaxSize = 32 * 1024 * 1024
+)
+
+// maxEntryFraction is the fraction of the maximum size, which can be used by a single entry. Larger responses are not
+// cached, so that a single huge list can not evict all other entries.
+const maxEntryFraction = 8
+
+// Default is the cache, which is used by the KubernetesRequest function of the bindings.
+var Default = New(DefaultTTL, DefaultMaxSize)
+
+// Stats are the statistics of a cache, which are returned by the debug stats endpoint of the server.
+type Stats struct {
+	Entries   int   `json:"entries"`
+	Size      int64 `json:"size"`
+	MaxSize   int64 `json:"maxSize"`
+	Hits      int64 `json:"hits"`
+	Misses    int64 `json:"misses"`
+	Evictions int64 `json:"evictions"`
+}
+
+// Policy is the parsed cache control parameter of a request.
+type Policy struct {
+	// Enabled is true, when the response of the request should be stored in the cache.
+	Enabled bool
+	// MaxAge is the maximum age of a cached response, which can be returned for the request. If it is zero, the
+	// request is always sent to the Kubernetes API.
+	MaxAge time.Duration
+}
+
+// Entry is a cached response of a GET request.
+type Entry struct {
+	Body            []byte
+	ResourceVersion string
+	StoredAt        time.Time
+
+	key     string
+	cluster string
+	path    string
+}
+
+// Cache is a LRU cache for the responses of GET requests, which are stored by the cluster, the credentials (see
+// CredentialsKey) and the url of the request. The responses are never shared between different users of the same
+// cluster, but a mutation of one user invalidates the cached responses of all users.
+type Cache struct {
+	ttl     time.Duration
+	maxSize int64
+
+	lock    sync.Mutex
+	size    int64
+	entries map[string]*list.Element
+	lru     *list.List
+
+	hits      atomic.Int64
+	misses    atomic.Int64
+	evictions atomic.Int64
+}
+
+// New returns a new cache with the given default TTL and the maximum size of all cached response bodies in bytes.
+func New(ttl time.Duration, maxSize int64) *Cache {
+	return &Cache{
+		ttl:     ttl,
+		maxSize: maxSize,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+// ParsePolicy parses the given cache control parameter of a request.
+func (c *Cache) ParsePolicy(cacheControl string) (Policy, error) {
+	switch {
+	case cacheControl == CacheControlNone:
+		return Policy{}, nil
+	case cacheControl == CacheControlBypass:
+		return Policy{Enabled: true}, nil
+	case cacheControl == CacheControlPreferCache:
+		return Policy{Enabled: true, MaxAge: c.ttl}, nil
+	case strings.HasPrefix(cacheControl, cacheControlMaxAge):
+		seconds, err := strconv.ParseInt(strings.TrimPrefix(cacheControl, cacheControlMaxAge), 10, 64)
+		if err != nil || seconds < 0 {
+			return Policy{}, fmt.Errorf("invalid cache control %s", cacheControl)
+		}
+		return Policy{Enabled: true, MaxAge: time.Duration(seconds) * time.Second}, nil
+	default:
+		return Policy{}, fmt.Errorf("invalid cache control %s", cacheControl)
+	}
+}
+
+// Get returns the cached response for the given cluster, credentials and url, when it isn't older than the given
+// maximum age.
+func (c *Cache) Get(cluster, credentials, requestURL string, maxAge time.Duration) (*Entry, bool) {
+	if maxAge <= 0 {
+		c.misses.Add(1)
+		return nil, false
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	element, ok := c.entries[key(cluster, credentials, requestURL)]
+	if !ok {
+		c.misses.Add(1)
+		return nil, false
+	}
+
+	entry := element.Value.(*Entry)
+	if time.Since(entry.StoredAt) > maxAge {
+		c.misses.Add(1)
+		return nil, false
+	}
+
+	c.lru.MoveToFront(element)
+	c.hits.Add(1)
+	return entry, true
+}
+
+// Set stores the response body for the given cluster, credentials and url. The resource version of the returned object
+// or list is stored together with the body. Responses which are larger than the allowed size of a single entry are not
+// stored.
+func (c *Cache) Set(cluster, credentials, requestURL string, body []byte) {
+	size := int64(len(body))
+	if size > c.maxSize/maxEntryFraction {
+		return
+	}
+
+	entry := &Entry{
+		Body:            body,
+		ResourceVersion: resourceVersion(body),
+		StoredAt:        time.Now(),
+		key:             key(cluster, credentials, requestURL),
+		cluster:         cluster,
+		path:            apiPath(requestURL),
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if element, ok := c.entries[entry.key]; ok {
+		c.remove(element)
+	}
+
+	c.entries[entry.key] = c.lru.PushFront(entry)
+	c.size += size
+
+	for c.size > c.maxSize {
+		c.remove(c.lru.Back())
+		c.evictions.Add(1)
+	}
+}
+
+// Invalidate removes all cached responses of the cluster, which could contain the resource of the given url, for all
+// credentials. This are the responses for the resource itself and its subresources, the list of the resources in the
+// namespace and the list of the resources in all namespaces. If the url can not be parsed, all cached responses of the
+// cluster are removed.
+func (c *Cache) Invalidate(cluster, requestURL string) {
+	prefixes, ok := invalidationPrefixes(apiPath(requestURL))
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for _, element := range c.entries {
+		entry := element.Value.(*Entry)
+		if entry.cluster != cluster {
+			continue
+		}
+		if !ok || matchesPrefix(entry.path, prefixes) {
+			c.remove(element)
+		}
+	}
+}
+
+// Flush removes all cached responses.
+func (c *Cache) Flush() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.entries = make(map[string]*list.Element)
+	c.lru.Init()
+	c.size = 0
+}
+
+// Stats returns the current statistics of the cache.
+func (c *Cache) Stats() Stats {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return Stats{
+		Entries:   len(c.entries),
+		Size:      c.size,
+		MaxSize:   c.maxSize,
+		Hits:      c.hits.Load(),
+		Misses:    c.misses.Load(),
+		Evictions: c.evictions.Load(),
+	}
+}
+
+// remove removes the given element from the cache. The lock must be held by the caller.
+func (c *Cache) remove(element *list.Element) {
+	entry := c.lru.Remove(element).(*Entry)
+	delete(c.entries, entry.key)
+	c.size -= int64(len(entry.Body))
+}
+
+func key(cluster, credentials, requestURL string) string {
+	return cluster + "\x00" + credentials + "\x00" + requestURL
+}
+
+// CredentialsKey returns a hash of the credentials of the config, which is used in the keys of the cache and of the
+// deduplicated requests, so that responses are never shared between different users of the same cluster.
+func CredentialsKey(restConfig *rest.Config) string {
+	hash := sha256.New()
+	for _, value := range []string{restConfig.Host, restConfig.BearerToken, restConfig.BearerTokenFile, restConfig.Username, restConfig.Password, string(restConfig.CertData), restConfig.CertFile} {
+		hash.Write([]byte(value))
+		hash.Write([]byte{0})
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// apiPath returns the path of the Kubernetes API for the given request url, starting with "/api/" or "/apis/". The url
+// can contain the address of the API server, which can also have a path prefix (e.g. for clusters behind Rancher), so
+// that everything before the API path is removed.
+func apiPath(requestURL string) string {
+	path := requestURL
+	if u, err := url.Parse(requestURL); err == nil {
+		path = u.Path
+	}
+
+	for _, prefix := range []string{"/api/", "/apis/"} {
+		if index := strings.Index(path, prefix); index >= 0 {
+			return strings.TrimSuffix(path[index:], "/")
+		}
+	}
+	return strings.TrimSuffix(path, "/")
+}
+
+// invalidationPrefixes returns the paths, which are affected by a change of the resource with the given path, e.g. for
+// "/api/v1/namespaces/default/pods/nginx" these are "/api/v1/namespaces/default/pods" (the list, the Pod and its
+// subresources) and "/api/v1/pods" (the list of all Pods). If the path isn't a resource path or it is the path of a
+// Namespace, which also affects all resources in the Namespace, false is returned.
+func invalidationPrefixes(path string) ([]string, bool) {
+	segments := strings.Split(strings.TrimPrefix(path, "/"), "/")
+
+	var groupVersion []string
+	switch {
+	case len(segments) >= 3 && segments[0] == "api":
+		groupVersion, segments = segments[:2], segments[2:]
+	case len(segments) >= 4 && segments[0] == "apis":
+		groupVersion, segments = segments[:3], segments[3:]
+	default:
+		return nil, false
+	}
+	base := "/" + strings.Join(groupVersion, "/")
+
+	if len(segments) >= 3 && segments[0] == "namespaces" {
+		return []string{base + "/namespaces/" + segments[1] + "/" + segments[2], base + "/" + segments[2]}, true
+	}
+
+	if segments[0] == "namespaces" && len(segments) >= 2 {
+		return nil, false
+	}
+	return []string{base + "/" + segments[0]}, true
+}
+
+// matchesPrefix returns true, when the path is equal to one of the prefixes or a sub path of one of the prefixes.
+func matchesPrefix(path string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if path == prefix || strings.HasPrefix(path, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// resourceVersion returns the resource version from the metadata of the object or list in the given response body.
+func resourceVersion(body []byte) string {
+	var obj struct {
+		Metadata struct {
+			ResourceVersion string `json:"resourceVersion"`
+		} `json:"metadata"`
+	}
+	if err := json.Unmarshal(body, &obj); err != nil {
+		return ""
+	}
+	return obj.Metadata.ResourceVersion
+}
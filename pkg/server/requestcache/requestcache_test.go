@@ -0,0 +1,37 @@
+package requestcache
+
+import (
+	"testing"
+	"time"
+
+	"k8s.io/client-go/rest"
+)
+
+func TestCacheCredentials(t *testing.T) {
+	cache := New(time.Minute, 1024*1024)
+
+	admin := CredentialsKey(&rest.Config{Host: "https://cluster", BearerToken: "admin"})
+	viewer := CredentialsKey(&rest.Config{Host: "https://cluster", BearerToken: "viewer"})
+	if admin == viewer {
+		t.Fatalf("expected different credentials keys for different tokens")
+	}
+
+	cache.Set("cluster", admin, "/api/v1/namespaces/default/secrets", []byte(`{"items":[]}`))
+
+	if _, ok := cache.Get("cluster", viewer, "/api/v1/namespaces/default/secrets", time.Minute); ok {
+		t.Errorf("expected no cached response for other credentials")
+	}
+	if _, ok := cache.Get("cluster", admin, "/api/v1/namespaces/default/secrets", time.Minute); !ok {
+		t.Errorf("expected cached response for the same credentials")
+	}
+
+	// A mutation of one user invalidates the cached responses of all users of the cluster.
+	cache.Set("cluster", viewer, "/api/v1/namespaces/default/secrets", []byte(`{"items":[]}`))
+	cache.Invalidate("cluster", "/api/v1/namespaces/default/secrets/token")
+
+	for _, credentials := range []string{admin, viewer} {
+		if _, ok := cache.Get("cluster", credentials, "/api/v1/namespaces/default/secrets", time.Minute); ok {
+			t.Errorf("expected cached response to be invalidated")
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package resources
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// The status of a delete request. When the object was removed, the status is "deleted". When the object still exists,
+// e.g. because the foreground deletion is waiting for the dependents or the object has finalizers, the status is
+// "deleting" and the result contains the object with its deletion timestamp.
+const (
+	DeleteStatusDeleted  = "deleted"
+	DeleteStatusDeleting = "deleting"
+)
+
+// DeleteOptions are the options to delete a single object. The resource is identified in the same way as for a list.
+// The "PropagationPolicy" can be "Foreground", "Background" or "Orphan", if it is empty the default of the resource is
+// used. The "GracePeriodSeconds" and "Preconditions" are passed to the Kubernetes API without further validation, so
+// that the API server decides if they are valid for the resource, e.g. a grace period of 0 can be used to force delete
+// a Pod.
+type DeleteOptions struct {
+	Group              string `json:"group"`
+	Version            string `json:"version"`
+	Resource           string `json:"resource"`
+	Kind               string `json:"kind"`
+	Namespace          string `json:"namespace"`
+	Name               string `json:"name"`
+	PropagationPolicy  string `json:"propagationPolicy"`
+	GracePeriodSeconds *int64 `json:"gracePeriodSeconds"`
+	Preconditions      struct {
+		UID             string `json:"uid"`
+		ResourceVersion string `json:"resourceVersion"`
+	} `json:"preconditions"`
+}
+
+// DeleteResult is the result of a delete request. The object is only set, when the status is "deleting".
+type DeleteResult struct {
+	Resource ResourceInfo    `json:"resource"`
+	Status   string          `json:"status"`
+	Object   json.RawMessage `json:"object,omitempty"`
+}
+
+// Delete deletes the object from the options. The resource is resolved via the cached discovery data of the cluster,
+// the same as for Get. Errors of the Kubernetes API are returned unchanged, so that the caller can use the status code
+// of the API server.
+func Delete(ctx context.Context, cache *DiscoveryCache, clusterKey string, clientset kubernetes.Interface, options DeleteOptions) (*DeleteResult, error) {
+	if options.Name == "" || (options.Resource == "" && options.Kind == "") {
+		return nil, fmt.Errorf("%w: name and resource or kind are required", ErrInvalidOptions)
+	}
+
+	deleteOptions := metav1.DeleteOptions{
+		TypeMeta:           metav1.TypeMeta{APIVersion: "v1", Kind: "DeleteOptions"},
+		GracePeriodSeconds: options.GracePeriodSeconds,
+	}
+
+	switch policy := metav1.DeletionPropagation(options.PropagationPolicy); policy {
+	case "":
+	case metav1.DeletePropagationForeground, metav1.DeletePropagationBackground, metav1.DeletePropagationOrphan:
+		deleteOptions.PropagationPolicy = &policy
+	default:
+		return nil, fmt.Errorf("%w: invalid propagation policy %s", ErrInvalidOptions, options.PropagationPolicy)
+	}
+
+	if options.Preconditions.UID != "" || options.Preconditions.ResourceVersion != "" {
+		deleteOptions.Preconditions = &metav1.Preconditions{}
+		if options.Preconditions.UID != "" {
+			uid := types.UID(options.Preconditions.UID)
+			deleteOptions.Preconditions.UID = &uid
+		}
+		if options.Preconditions.ResourceVersion != "" {
+			deleteOptions.Preconditions.ResourceVersion = &options.Preconditions.ResourceVersion
+		}
+	}
+
+	info, err := cache.resolveOptions(clusterKey, clientset, ListOptions{Group: options.Group, Version: options.Version, Resource: options.Resource, Kind: options.Kind})
+	if err != nil {
+		return nil, err
+	}
+
+	namespace := options.Namespace
+	if !info.Namespaced {
+		namespace = ""
+	} else if namespace == "" {
+		return nil, fmt.Errorf("%w: namespace is required for resource %s", ErrInvalidOptions, info.Resource)
+	}
+
+	body, err := json.Marshal(deleteOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	// The error of the result contains the Status object of the API server, e.g. with the reason why a grace period
+	// isn't allowed, which is lost when only the raw response is used.
+	var statusCode int
+	result := clientset.CoreV1().RESTClient().Delete().AbsPath(ObjectPath(info, namespace, options.Name)).Body(body).SetHeader("Content-Type", "application/json").Do(ctx).StatusCode(&statusCode)
+	if err := result.Error(); err != nil {
+		return nil, err
+	}
+
+	data, err := result.Raw()
+	if err != nil {
+		return nil, err
+	}
+
+	return deleteResult(info, statusCode, data), nil
+}
+
+// deleteResult returns the result for the response of a delete request. The Kubernetes API returns a Status object,
+// when the object was removed immediately, otherwise the object is returned with a deletion timestamp (status code 200
+// or 202 when the deletion is processed asynchronously).
+func deleteResult(info ResourceInfo, statusCode int, data []byte) *DeleteResult {
+	var object struct {
+		Kind     string `json:"kind"`
+		Metadata struct {
+			DeletionTimestamp *metav1.Time `json:"deletionTimestamp"`
+		} `json:"metadata"`
+	}
+
+	if err := json.Unmarshal(data, &object); err == nil && object.Kind != "Status" && (statusCode == http.StatusAccepted || object.Metadata.DeletionTimestamp != nil) {
+		return &DeleteResult{Resource: info, Status: DeleteStatusDeleting, Object: data}
+	}
+
+	return &DeleteResult{Resource: info, Status: DeleteStatusDeleted}
+}
@@ -0,0 +1,182 @@
+package resources
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/restmapper"
+)
+
+// DiscoveryCache caches the discovery data and the REST mapper of each cluster in memory, so that we do not have to
+// fetch the discovery data of a cluster for each request.
+type DiscoveryCache struct {
+	lock     sync.Mutex
+	clusters map[string]*cachedDiscovery
+}
+
+type cachedDiscovery struct {
+	discovery discovery.CachedDiscoveryInterface
+	mapper    *restmapper.DeferredDiscoveryRESTMapper
+}
+
+// NewDiscoveryCache returns a new and empty discovery cache.
+func NewDiscoveryCache() *DiscoveryCache {
+	return &DiscoveryCache{
+		clusters: make(map[string]*cachedDiscovery),
+	}
+}
+
+// ResolveKind resolves the group, version and kind to the resource information via the cached discovery data of the
+// cluster. If the kind can not be resolved, the discovery data is fetched again once, because the kind could be a
+// custom resource, which was created after the discovery data was cached.
+func (c *DiscoveryCache) ResolveKind(clusterKey string, clientset kubernetes.Interface, gvk schema.GroupVersionKind) (ResourceInfo, error) {
+	return c.resolveOptions(clusterKey, clientset, ListOptions{Group: gvk.Group, Version: gvk.Version, Kind: gvk.Kind})
+}
+
+// resolveOptions resolves the resource from the options via the cached discovery data of the cluster. If the resource
+// can not be resolved, the discovery data is fetched again once.
+func (c *DiscoveryCache) resolveOptions(clusterKey string, clientset kubernetes.Interface, options ListOptions) (ResourceInfo, error) {
+	info, err := resolve(c.get(clusterKey, clientset), options)
+	if err != nil && meta.IsNoMatchError(err) {
+		return resolve(c.reset(clusterKey, clientset), options)
+	}
+
+	return info, err
+}
+
+// get returns the cached discovery data of the cluster with the given key. If the cluster isn't cached yet, the
+// discovery client of the given clientset is used to fetch the discovery data.
+func (c *DiscoveryCache) get(clusterKey string, clientset kubernetes.Interface) *cachedDiscovery {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if cached, ok := c.clusters[clusterKey]; ok {
+		return cached
+	}
+
+	cached := newCachedDiscovery(clientset)
+	c.clusters[clusterKey] = cached
+	return cached
+}
+
+// reset removes the cached discovery data of a cluster and returns new discovery data for the given clientset. This is
+// used when a resource can not be found, e.g. because the CRD was created after the discovery data was cached.
+func (c *DiscoveryCache) reset(clusterKey string, clientset kubernetes.Interface) *cachedDiscovery {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	cached := newCachedDiscovery(clientset)
+	c.clusters[clusterKey] = cached
+	return cached
+}
+
+func newCachedDiscovery(clientset kubernetes.Interface) *cachedDiscovery {
+	cachedDiscoveryClient := memory.NewMemCacheClient(clientset.Discovery())
+
+	return &cachedDiscovery{
+		discovery: cachedDiscoveryClient,
+		mapper:    restmapper.NewDeferredDiscoveryRESTMapper(cachedDiscoveryClient),
+	}
+}
+
+// GroupResources returns the resources of the preferred version of the given group via the cached discovery data of
+// the cluster. Subresources are not returned. If the group doesn't exist, the discovery data is fetched again once,
+// because the group could be created by a CRD after the discovery data was cached. If the group still doesn't exist,
+// no resources are returned.
+func (c *DiscoveryCache) GroupResources(clusterKey string, clientset kubernetes.Interface, group string) ([]ResourceInfo, error) {
+	infos, found, err := groupResources(c.get(clusterKey, clientset), group)
+	if err == nil && !found {
+		infos, _, err = groupResources(c.reset(clusterKey, clientset), group)
+	}
+
+	return infos, err
+}
+
+func groupResources(cached *cachedDiscovery, group string) ([]ResourceInfo, bool, error) {
+	// The cached discovery client returns no groups and no error, when the discovery data could not be fetched.
+	groups, err := cached.discovery.ServerGroups()
+	if err != nil {
+		return nil, false, err
+	}
+	if groups == nil {
+		return nil, false, fmt.Errorf("could not get api groups")
+	}
+
+	for _, apiGroup := range groups.Groups {
+		if apiGroup.Name != group {
+			continue
+		}
+
+		resourceList, err := cached.discovery.ServerResourcesForGroupVersion(apiGroup.PreferredVersion.GroupVersion)
+		if err != nil {
+			return nil, true, err
+		}
+
+		var infos []ResourceInfo
+		for _, resource := range resourceList.APIResources {
+			if strings.Contains(resource.Name, "/") {
+				continue
+			}
+
+			infos = append(infos, ResourceInfo{
+				Group:      group,
+				Version:    apiGroup.PreferredVersion.Version,
+				Resource:   resource.Name,
+				Kind:       resource.Kind,
+				Namespaced: resource.Namespaced,
+			})
+		}
+		return infos, true, nil
+	}
+
+	return nil, false, nil
+}
+
+// NamespacedResources returns the preferred version of all namespaced resources of the cluster, which can be listed.
+// Groups for which the discovery data can not be fetched (e.g. because of an unavailable aggregated API) are skipped,
+// so that the resources of all other groups are still returned.
+func (c *DiscoveryCache) NamespacedResources(clusterKey string, clientset kubernetes.Interface) ([]ResourceInfo, error) {
+	resourceLists, err := discovery.ServerPreferredNamespacedResources(c.get(clusterKey, clientset).discovery)
+	if err != nil && !discovery.IsGroupDiscoveryFailedError(err) {
+		return nil, err
+	}
+
+	var infos []ResourceInfo
+	for _, resourceList := range resourceLists {
+		gv, err := schema.ParseGroupVersion(resourceList.GroupVersion)
+		if err != nil {
+			continue
+		}
+
+		for _, resource := range resourceList.APIResources {
+			if strings.Contains(resource.Name, "/") || !hasVerb(resource.Verbs, "list") {
+				continue
+			}
+
+			infos = append(infos, ResourceInfo{
+				Group:      gv.Group,
+				Version:    gv.Version,
+				Resource:   resource.Name,
+				Kind:       resource.Kind,
+				Namespaced: true,
+			})
+		}
+	}
+
+	return infos, nil
+}
+
+func hasVerb(verbs []string, verb string) bool {
+	for _, v := range verbs {
+		if v == verb {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,313 @@
+// Package resources implements a generic list for all resources of a cluster, including custom resources. The url
+// for a resource is resolved via the discovery data of the cluster, so that the client only has to know the group,
+// version and resource or kind.
+package resources
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
+)
+
+// FieldManager is the name of the field manager, which is used for all changes made by kubenav.
+const FieldManager = "kubenav"
+
+// ErrInvalidOptions is returned when the options of a request are invalid. The ErrInvalidOptions errors of the other
+// packages wrap this error, so that the server can map all of them to a "400 Bad Request" error in one place.
+var ErrInvalidOptions = errors.New("invalid options")
+
+// The light representations, which can be requested via the "light" option.
+const (
+	LightTable    = "table"
+	LightMetadata = "metadata"
+)
+
+// acceptHeaders are the values for the "Accept" header, to get the light representations of a list. The plain JSON
+// representation is used as fallback, when the Kubernetes API doesn't support the requested representation.
+var acceptHeaders = map[string]string{
+	LightTable:    "application/json;as=Table;v=v1;g=meta.k8s.io,application/json",
+	LightMetadata: "application/json;as=PartialObjectMetadataList;v=v1;g=meta.k8s.io,application/json",
+}
+
+// ListOptions are the options for a generic list. The resource is identified by the group, version and resource or
+// kind. If the version is empty, the preferred version of the group is used. If the resource is empty, the kind is
+// used to resolve the resource.
+//
+// The "ResourceVersion" is the resource version of a list, which was already fetched by the client. When it is set, the
+// list is only fetched again, when something was changed since this version (see getConditional).
+type ListOptions struct {
+	Group           string
+	Version         string
+	Resource        string
+	Kind            string
+	Namespace       string
+	LabelSelector   string
+	FieldSelector   string
+	Limit           int64
+	Continue        string
+	Light           string
+	ResourceVersion string
+}
+
+// ListOptionsFromQuery returns the list options from the "group", "version", "resource", "kind", "namespace",
+// "labelSelector", "fieldSelector", "limit", "continue", "light" and "resourceVersion" query parameters.
+func ListOptionsFromQuery(query url.Values) (ListOptions, error) {
+	options := ListOptions{
+		Group:           query.Get("group"),
+		Version:         query.Get("version"),
+		Resource:        query.Get("resource"),
+		Kind:            query.Get("kind"),
+		Namespace:       query.Get("namespace"),
+		LabelSelector:   query.Get("labelSelector"),
+		FieldSelector:   query.Get("fieldSelector"),
+		Continue:        query.Get("continue"),
+		Light:           query.Get("light"),
+		ResourceVersion: query.Get("resourceVersion"),
+	}
+
+	if options.Resource == "" && options.Kind == "" {
+		return options, fmt.Errorf("resource or kind is required")
+	}
+
+	if limit := query.Get("limit"); limit != "" {
+		parsedLimit, err := strconv.ParseInt(limit, 10, 64)
+		if err != nil || parsedLimit < 0 {
+			return options, fmt.Errorf("invalid limit %s", limit)
+		}
+		options.Limit = parsedLimit
+	}
+
+	if _, ok := acceptHeaders[options.Light]; options.Light != "" && !ok {
+		return options, fmt.Errorf("invalid light option %s", options.Light)
+	}
+
+	if options.ResourceVersion != "" && options.Continue != "" {
+		return options, fmt.Errorf("resourceVersion can not be used together with continue")
+	}
+
+	return options, nil
+}
+
+// ResourceInfo is the resolved resource of a list.
+type ResourceInfo struct {
+	Group      string `json:"group"`
+	Version    string `json:"version"`
+	Resource   string `json:"resource"`
+	Kind       string `json:"kind"`
+	Namespaced bool   `json:"namespaced"`
+}
+
+// List is the result of a generic list. For the table representation the items are the rows of the table and the
+// column definitions are set. For all other representations the items are the objects of the list. If the list wasn't
+// changed since the resource version from the options, "unchanged" is true and the list doesn't contain any items.
+type List struct {
+	Resource          ResourceInfo                   `json:"resource"`
+	Unchanged         bool                           `json:"unchanged,omitempty"`
+	Metadata          metav1.ListMeta                `json:"metadata"`
+	ColumnDefinitions []metav1.TableColumnDefinition `json:"columnDefinitions,omitempty"`
+	Items             []json.RawMessage              `json:"items"`
+}
+
+// rawList is used to decode the response of the Kubernetes API. The items of a list are returned in the "items" field,
+// the rows of a table in the "rows" field.
+type rawList struct {
+	Kind              string                         `json:"kind"`
+	Metadata          metav1.ListMeta                `json:"metadata"`
+	ColumnDefinitions []metav1.TableColumnDefinition `json:"columnDefinitions"`
+	Items             []json.RawMessage              `json:"items"`
+	Rows              []json.RawMessage              `json:"rows"`
+}
+
+// Get resolves the resource for the given options via the cached discovery data of the cluster and lists the objects
+// of the resource. If the resource can not be resolved, the discovery data is fetched again once, because the resource
+// could be a custom resource, which was created after the discovery data was cached.
+func Get(ctx context.Context, cache *DiscoveryCache, clusterKey string, clientset kubernetes.Interface, options ListOptions) (*List, error) {
+	info, err := cache.resolveOptions(clusterKey, clientset, options)
+	if err != nil {
+		return nil, err
+	}
+
+	if !info.Namespaced && options.Namespace != "" {
+		return nil, fmt.Errorf("resource %s is not namespaced", info.Resource)
+	}
+
+	if options.ResourceVersion != "" {
+		return getConditional(ctx, clientset, info, options)
+	}
+
+	return list(ctx, clientset, info, options, nil)
+}
+
+// getConditional lists the objects of a resource, when they were changed since the resource version from the
+// options. To check if something was changed, the metadata of a single object is listed and the resource version of
+// the collection is compared with the resource version from the options. If they are equal, an empty list with
+// "unchanged" set to true is returned. The resource version of a collection can also change without a change of the
+// listed objects, so that the check can report a change which didn't happen, but it never misses a change.
+//
+// Otherwise the full list is fetched with "resourceVersionMatch=NotOlderThan", so that it can be served from the watch
+// cache of the API server, instead of a quorum read from etcd. When the resource version went backwards, e.g. because
+// etcd was restored from a backup, or the API server rejects the resource version as too old (after a compaction) or
+// too large, we fall back to a full quorum read.
+func getConditional(ctx context.Context, clientset kubernetes.Interface, info ResourceInfo, options ListOptions) (*List, error) {
+	probeOptions := options
+	probeOptions.Limit = 1
+	probeOptions.Light = LightMetadata
+
+	probe, err := list(ctx, clientset, info, probeOptions, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	current := probe.Metadata.ResourceVersion
+	if current != "" && current == options.ResourceVersion {
+		return &List{
+			Resource:  info,
+			Unchanged: true,
+			Metadata:  metav1.ListMeta{ResourceVersion: current},
+			Items:     []json.RawMessage{},
+		}, nil
+	}
+
+	if current == "" || isOlder(current, options.ResourceVersion) {
+		return list(ctx, clientset, info, options, nil)
+	}
+
+	result, err := list(ctx, clientset, info, options, map[string]string{
+		"resourceVersion":      options.ResourceVersion,
+		"resourceVersionMatch": string(metav1.ResourceVersionMatchNotOlderThan),
+	})
+	if err != nil {
+		if apierrors.IsResourceExpired(err) || apierrors.IsGone(err) || apierrors.HasStatusCause(err, metav1.CauseTypeResourceVersionTooLarge) {
+			return list(ctx, clientset, info, options, nil)
+		}
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// isOlder returns true, when the resource version a is older than the resource version b. Resource versions must be
+// treated as opaque strings by clients, but all API servers backed by etcd use the revision of etcd, so that we can use
+// them to detect when the resource version went backwards. If one of them isn't a number, false is returned.
+func isOlder(a, b string) bool {
+	parsedA, errA := strconv.ParseUint(a, 10, 64)
+	parsedB, errB := strconv.ParseUint(b, 10, 64)
+	return errA == nil && errB == nil && parsedA < parsedB
+}
+
+// list lists the objects of a resource with the given options and the additional query parameters.
+func list(ctx context.Context, clientset kubernetes.Interface, info ResourceInfo, options ListOptions, params map[string]string) (*List, error) {
+	request := clientset.CoreV1().RESTClient().Get().AbsPath(Path(info, options.Namespace))
+	if options.LabelSelector != "" {
+		request = request.Param("labelSelector", options.LabelSelector)
+	}
+	if options.FieldSelector != "" {
+		request = request.Param("fieldSelector", options.FieldSelector)
+	}
+	if options.Limit > 0 {
+		request = request.Param("limit", strconv.FormatInt(options.Limit, 10))
+	}
+	if options.Continue != "" {
+		request = request.Param("continue", options.Continue)
+	}
+	for key, value := range params {
+		request = request.Param(key, value)
+	}
+	if accept, ok := acceptHeaders[options.Light]; ok {
+		request = request.SetHeader("Accept", accept)
+	}
+
+	data, err := request.DoRaw(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var raw rawList
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+
+	list := &List{
+		Resource: info,
+		Metadata: raw.Metadata,
+		Items:    raw.Items,
+	}
+
+	if raw.Kind == "Table" {
+		list.ColumnDefinitions = raw.ColumnDefinitions
+		list.Items = raw.Rows
+	}
+
+	if list.Items == nil {
+		list.Items = []json.RawMessage{}
+	}
+
+	return list, nil
+}
+
+// resolve resolves the group, version and resource or kind from the options to the full resource information, via the
+// REST mapper of the cached discovery data.
+func resolve(cached *cachedDiscovery, options ListOptions) (ResourceInfo, error) {
+	var gvk schema.GroupVersionKind
+
+	if options.Resource != "" {
+		gvr, err := cached.mapper.ResourceFor(schema.GroupVersionResource{Group: options.Group, Version: options.Version, Resource: options.Resource})
+		if err != nil {
+			return ResourceInfo{}, err
+		}
+
+		gvk, err = cached.mapper.KindFor(gvr)
+		if err != nil {
+			return ResourceInfo{}, err
+		}
+	} else {
+		gvk = schema.GroupVersionKind{Group: options.Group, Version: options.Version, Kind: options.Kind}
+	}
+
+	var versions []string
+	if gvk.Version != "" {
+		versions = append(versions, gvk.Version)
+	}
+
+	mapping, err := cached.mapper.RESTMapping(gvk.GroupKind(), versions...)
+	if err != nil {
+		return ResourceInfo{}, err
+	}
+
+	return ResourceInfo{
+		Group:      mapping.Resource.Group,
+		Version:    mapping.Resource.Version,
+		Resource:   mapping.Resource.Resource,
+		Kind:       mapping.GroupVersionKind.Kind,
+		Namespaced: mapping.Scope.Name() == meta.RESTScopeNameNamespace,
+	}, nil
+}
+
+// Path returns the path to list the objects of a resource. Resources of the core group are served under "/api", all
+// other resources under "/apis".
+func Path(info ResourceInfo, namespace string) string {
+	path := "/api/" + info.Version
+	if info.Group != "" {
+		path = fmt.Sprintf("/apis/%s/%s", info.Group, info.Version)
+	}
+
+	if namespace != "" {
+		path = fmt.Sprintf("%s/namespaces/%s", path, url.PathEscape(namespace))
+	}
+
+	return path + "/" + info.Resource
+}
+
+// ObjectPath returns the path of a single object of a resource.
+func ObjectPath(info ResourceInfo, namespace, name string) string {
+	return Path(info, namespace) + "/" + url.PathEscape(name)
+}
@@ -0,0 +1,316 @@
+// Package rollout implements the streaming of the rollout status of a Deployment, StatefulSet or DaemonSet, similar
+// to "kubectl rollout status". The readiness of a rollout is computed in the same way as kubectl does it.
+package rollout
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/kubenav/kubenav/pkg/server/watch"
+
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/rest"
+)
+
+// DefaultTimeout is the default duration, after which we stop to wait for the rollout.
+const DefaultTimeout = 10 * time.Minute
+
+// The kinds of resources, for which the rollout status can be watched.
+const (
+	KindDeployment  = "deployment"
+	KindStatefulSet = "statefulset"
+	KindDaemonSet   = "daemonset"
+)
+
+// Message is a single message of the rollout status stream.
+//
+// OP        FIELD(S) USED  DESCRIPTION
+// ---------------------------------------------------------------------
+// progress  Message        The rollout is in progress, the message describes the current state
+// status    Message        The connection was lost ("reconnecting") or is restored ("resynced")
+// complete  Message        The rollout was completed successfully
+// failed    Message        The rollout failed (e.g. because the progress deadline was exceeded)
+// timeout   Message        The rollout didn't complete within the timeout
+// error     Message        The rollout status could not be watched
+//
+// All operations except "progress" and "status" are final, after them the stream is closed.
+type Message struct {
+	Op      string `json:"op"`
+	Kind    string `json:"kind"`
+	Name    string `json:"name"`
+	Message string `json:"message"`
+}
+
+// The operations of a Message.
+const (
+	OpProgress = "progress"
+	OpStatus   = "status"
+	OpComplete = "complete"
+	OpFailed   = "failed"
+	OpTimeout  = "timeout"
+	OpError    = "error"
+)
+
+// Options are the options to watch the rollout status of a resource.
+type Options struct {
+	Kind      string
+	Namespace string
+	Name      string
+	Timeout   time.Duration
+}
+
+// OptionsFromQuery returns the options from the "kind", "namespace", "name" and "timeout" query parameters. The kind
+// defaults to "deployment" and the timeout must be provided in seconds.
+func OptionsFromQuery(query url.Values) (Options, error) {
+	options := Options{
+		Kind:      query.Get("kind"),
+		Namespace: query.Get("namespace"),
+		Name:      query.Get("name"),
+		Timeout:   DefaultTimeout,
+	}
+
+	if options.Kind == "" {
+		options.Kind = KindDeployment
+	}
+	if options.Kind != KindDeployment && options.Kind != KindStatefulSet && options.Kind != KindDaemonSet {
+		return options, fmt.Errorf("invalid kind %s", options.Kind)
+	}
+
+	if options.Namespace == "" || options.Name == "" {
+		return options, fmt.Errorf("namespace and name are required")
+	}
+
+	if timeout := query.Get("timeout"); timeout != "" {
+		parsedTimeout, err := strconv.ParseInt(timeout, 10, 64)
+		if err != nil || parsedTimeout <= 0 {
+			return options, fmt.Errorf("invalid timeout %s", timeout)
+		}
+		options.Timeout = time.Duration(parsedTimeout) * time.Second
+	}
+
+	return options, nil
+}
+
+// status is the rollout status of a resource. When done is true, the rollout is completed. When err is set, the
+// rollout failed.
+type status struct {
+	message string
+	done    bool
+	err     error
+}
+
+// errFinished is returned by the send function of the watch, to stop the watch when the rollout is finished.
+var errFinished = errors.New("rollout finished")
+
+// Stream watches the resource from the options and sends a "progress" message each time the rollout status changes.
+// When the rollout is finished, fails or the timeout elapses, a final message is send and the function returns. The
+// watch is stopped, when the context is canceled. When the connection to the Kubernetes API is lost, the watch is
+// reconnected and the "reconnecting" and "resynced" statuses of the watch are send as "status" messages.
+func Stream(ctx context.Context, client rest.Interface, options Options, send func(Message) error) error {
+	timeout := options.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+
+	watchCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	resource := map[string]string{
+		KindDeployment:  "deployments",
+		KindStatefulSet: "statefulsets",
+		KindDaemonSet:   "daemonsets",
+	}[options.Kind]
+
+	query := url.Values{}
+	query.Set("fieldSelector", fields.OneTermEqualSelector("metadata.name", options.Name).String())
+	watchURL := fmt.Sprintf("/apis/apps/v1/namespaces/%s/%s?%s", url.PathEscape(options.Namespace), resource, query.Encode())
+
+	newMessage := func(op, message string) Message {
+		return Message{Op: op, Kind: options.Kind, Name: options.Name, Message: message}
+	}
+
+	lastMessage := ""
+
+	err := watch.Watch(watchCtx, client, watch.Options{URL: watchURL}, func(event watch.Event) error {
+		var object json.RawMessage
+
+		switch event.Type {
+		case watch.EventList, watch.EventResync:
+			if len(event.Items) == 0 {
+				return fmt.Errorf("%s %s not found", options.Kind, options.Name)
+			}
+			object = event.Items[0]
+		case watch.EventAdded, watch.EventModified:
+			object = event.Object
+		case watch.EventDeleted:
+			return fmt.Errorf("%s %s was deleted", options.Kind, options.Name)
+		case watch.EventStatus:
+			return send(newMessage(OpStatus, event.Data))
+		default:
+			return nil
+		}
+
+		s, err := getStatus(options.Kind, object)
+		if err != nil {
+			return err
+		}
+
+		if s.err != nil {
+			if err := send(newMessage(OpFailed, s.err.Error())); err != nil {
+				return err
+			}
+			return errFinished
+		}
+
+		if s.done {
+			if err := send(newMessage(OpComplete, s.message)); err != nil {
+				return err
+			}
+			return errFinished
+		}
+
+		if s.message != lastMessage {
+			lastMessage = s.message
+			return send(newMessage(OpProgress, s.message))
+		}
+
+		return nil
+	})
+
+	if errors.Is(err, errFinished) {
+		return nil
+	}
+
+	// When the parent context is still active, the watch was stopped because of our timeout, so that we send a final
+	// "timeout" message. If the parent context was canceled, the client closed the connection.
+	if ctx.Err() == nil && watchCtx.Err() != nil {
+		return send(newMessage(OpTimeout, fmt.Sprintf("timed out waiting for the rollout of %s %s to finish after %s", options.Kind, options.Name, timeout)))
+	}
+
+	return err
+}
+
+// GetStatus returns the current rollout status of the object for the given kind, without watching it. The operation of
+// the returned message is "complete", "failed" or "progress", the name of the message is not set.
+func GetStatus(kind string, object json.RawMessage) (Message, error) {
+	s, err := getStatus(kind, object)
+	if err != nil {
+		return Message{}, err
+	}
+
+	switch {
+	case s.err != nil:
+		return Message{Op: OpFailed, Kind: kind, Message: s.err.Error()}, nil
+	case s.done:
+		return Message{Op: OpComplete, Kind: kind, Message: s.message}, nil
+	default:
+		return Message{Op: OpProgress, Kind: kind, Message: s.message}, nil
+	}
+}
+
+// getStatus decodes the object and returns the rollout status for the given kind.
+func getStatus(kind string, object json.RawMessage) (status, error) {
+	switch kind {
+	case KindStatefulSet:
+		var sts appsv1.StatefulSet
+		if err := json.Unmarshal(object, &sts); err != nil {
+			return status{}, err
+		}
+		return getStatefulSetStatus(&sts), nil
+	case KindDaemonSet:
+		var ds appsv1.DaemonSet
+		if err := json.Unmarshal(object, &ds); err != nil {
+			return status{}, err
+		}
+		return getDaemonSetStatus(&ds), nil
+	default:
+		var deployment appsv1.Deployment
+		if err := json.Unmarshal(object, &deployment); err != nil {
+			return status{}, err
+		}
+		return getDeploymentStatus(&deployment), nil
+	}
+}
+
+// getDeploymentStatus returns the rollout status of a Deployment. This is the same logic as in the
+// "DeploymentStatusViewer" of kubectl.
+func getDeploymentStatus(deployment *appsv1.Deployment) status {
+	if deployment.Generation > deployment.Status.ObservedGeneration {
+		return status{message: "Waiting for deployment spec update to be observed..."}
+	}
+
+	for _, condition := range deployment.Status.Conditions {
+		if condition.Type == appsv1.DeploymentProgressing && condition.Reason == "ProgressDeadlineExceeded" {
+			return status{err: fmt.Errorf("deployment %q exceeded its progress deadline", deployment.Name)}
+		}
+	}
+
+	if deployment.Spec.Replicas != nil && deployment.Status.UpdatedReplicas < *deployment.Spec.Replicas {
+		return status{message: fmt.Sprintf("Waiting for deployment %q rollout to finish: %d out of %d new replicas have been updated...", deployment.Name, deployment.Status.UpdatedReplicas, *deployment.Spec.Replicas)}
+	}
+	if deployment.Status.Replicas > deployment.Status.UpdatedReplicas {
+		return status{message: fmt.Sprintf("Waiting for deployment %q rollout to finish: %d old replicas are pending termination...", deployment.Name, deployment.Status.Replicas-deployment.Status.UpdatedReplicas)}
+	}
+	if deployment.Status.AvailableReplicas < deployment.Status.UpdatedReplicas {
+		return status{message: fmt.Sprintf("Waiting for deployment %q rollout to finish: %d of %d updated replicas are available...", deployment.Name, deployment.Status.AvailableReplicas, deployment.Status.UpdatedReplicas)}
+	}
+
+	return status{message: fmt.Sprintf("deployment %q successfully rolled out", deployment.Name), done: true}
+}
+
+// getStatefulSetStatus returns the rollout status of a StatefulSet. This is the same logic as in the
+// "StatefulSetStatusViewer" of kubectl.
+func getStatefulSetStatus(sts *appsv1.StatefulSet) status {
+	if sts.Spec.UpdateStrategy.Type != appsv1.RollingUpdateStatefulSetStrategyType {
+		return status{err: fmt.Errorf("rollout status is only available for %s strategy type", appsv1.RollingUpdateStatefulSetStrategyType)}
+	}
+
+	if sts.Status.ObservedGeneration == 0 || sts.Generation > sts.Status.ObservedGeneration {
+		return status{message: "Waiting for statefulset spec update to be observed..."}
+	}
+
+	if sts.Spec.Replicas != nil && sts.Status.ReadyReplicas < *sts.Spec.Replicas {
+		return status{message: fmt.Sprintf("Waiting for %d pods to be ready...", *sts.Spec.Replicas-sts.Status.ReadyReplicas)}
+	}
+
+	if sts.Spec.UpdateStrategy.RollingUpdate != nil && sts.Spec.UpdateStrategy.RollingUpdate.Partition != nil {
+		if sts.Spec.Replicas != nil && sts.Status.UpdatedReplicas < *sts.Spec.Replicas-*sts.Spec.UpdateStrategy.RollingUpdate.Partition {
+			return status{message: fmt.Sprintf("Waiting for partitioned roll out to finish: %d out of %d new pods have been updated...", sts.Status.UpdatedReplicas, *sts.Spec.Replicas-*sts.Spec.UpdateStrategy.RollingUpdate.Partition)}
+		}
+
+		return status{message: fmt.Sprintf("partitioned roll out complete: %d new pods have been updated...", sts.Status.UpdatedReplicas), done: true}
+	}
+
+	if sts.Status.UpdateRevision != sts.Status.CurrentRevision {
+		return status{message: fmt.Sprintf("waiting for statefulset rolling update to complete %d pods at revision %s...", sts.Status.UpdatedReplicas, sts.Status.UpdateRevision)}
+	}
+
+	return status{message: fmt.Sprintf("statefulset rolling update complete %d pods at revision %s...", sts.Status.CurrentReplicas, sts.Status.CurrentRevision), done: true}
+}
+
+// getDaemonSetStatus returns the rollout status of a DaemonSet. This is the same logic as in the
+// "DaemonSetStatusViewer" of kubectl.
+func getDaemonSetStatus(ds *appsv1.DaemonSet) status {
+	if ds.Spec.UpdateStrategy.Type != appsv1.RollingUpdateDaemonSetStrategyType {
+		return status{err: fmt.Errorf("rollout status is only available for %s strategy type", appsv1.RollingUpdateDaemonSetStrategyType)}
+	}
+
+	if ds.Generation > ds.Status.ObservedGeneration {
+		return status{message: "Waiting for daemon set spec update to be observed..."}
+	}
+
+	if ds.Status.UpdatedNumberScheduled < ds.Status.DesiredNumberScheduled {
+		return status{message: fmt.Sprintf("Waiting for daemon set %q rollout to finish: %d out of %d new pods have been updated...", ds.Name, ds.Status.UpdatedNumberScheduled, ds.Status.DesiredNumberScheduled)}
+	}
+	if ds.Status.NumberAvailable < ds.Status.DesiredNumberScheduled {
+		return status{message: fmt.Sprintf("Waiting for daemon set %q rollout to finish: %d of %d updated pods are available...", ds.Name, ds.Status.NumberAvailable, ds.Status.DesiredNumberScheduled)}
+	}
+
+	return status{message: fmt.Sprintf("daemon set %q successfully rolled out", ds.Name), done: true}
+}
@@ -0,0 +1,117 @@
+// Package selfcheck implements a check of the capabilities, which are required by the features of the server. The
+// check is run when the server is started, so that the app can disable features which will not work on the platform of
+// the user, instead of letting the user discover it by a failure.
+package selfcheck
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// The names of the checked capabilities.
+const (
+	// CapabilityLoopbackListener checks that we can bind a listener on a random loopback port, which is required for
+	// port forwarding.
+	CapabilityLoopbackListener = "loopback-listener"
+	// CapabilityUnixSocket checks that we can listen on a Unix domain socket.
+	CapabilityUnixSocket = "unix-socket"
+	// CapabilityTemporaryFile checks that we can create a temporary file, which is required for downloads, recordings
+	// and audit logs.
+	CapabilityTemporaryFile = "temporary-file"
+	// CapabilityDNS checks that we can resolve a hostname via DNS, which is required to connect to most clusters.
+	CapabilityDNS = "dns"
+)
+
+// DNSHost is the hostname, which is resolved by the DNS check.
+var DNSHost = "kubenav.io"
+
+// Timeout is the maximum duration of the checks, so that the start of the server is not delayed, e.g. when the device
+// is offline and the DNS lookup never returns.
+var Timeout = 2 * time.Second
+
+// Result is the result of the check of a single capability. When the check failed, the message contains the reason.
+type Result struct {
+	Capability string `json:"capability"`
+	Passed     bool   `json:"passed"`
+	Message    string `json:"message,omitempty"`
+}
+
+// Run runs the checks for all capabilities concurrently and returns the results in a fixed order.
+func Run(ctx context.Context) []Result {
+	ctx, cancel := context.WithTimeout(ctx, Timeout)
+	defer cancel()
+
+	checks := []struct {
+		capability string
+		check      func(ctx context.Context) error
+	}{
+		{CapabilityLoopbackListener, checkLoopbackListener},
+		{CapabilityUnixSocket, checkUnixSocket},
+		{CapabilityTemporaryFile, checkTemporaryFile},
+		{CapabilityDNS, checkDNS},
+	}
+
+	results := make([]Result, len(checks))
+
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func(i int, capability string, check func(ctx context.Context) error) {
+			defer wg.Done()
+
+			results[i] = Result{Capability: capability, Passed: true}
+			if err := check(ctx); err != nil {
+				results[i].Passed = false
+				results[i].Message = err.Error()
+			}
+		}(i, c.capability, c.check)
+	}
+	wg.Wait()
+
+	return results
+}
+
+func checkLoopbackListener(ctx context.Context) error {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	return listener.Close()
+}
+
+func checkUnixSocket(ctx context.Context) error {
+	dir, err := os.MkdirTemp("", "kubenav-selfcheck")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	listener, err := net.Listen("unix", filepath.Join(dir, "selfcheck.sock"))
+	if err != nil {
+		return err
+	}
+	return listener.Close()
+}
+
+func checkTemporaryFile(ctx context.Context) error {
+	file, err := os.CreateTemp("", "kubenav-selfcheck")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	if _, err := file.Write([]byte("kubenav")); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func checkDNS(ctx context.Context) error {
+	_, err := net.DefaultResolver.LookupHost(ctx, DNSHost)
+	return err
+}
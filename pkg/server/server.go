@@ -1,28 +1,565 @@
 package server
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net"
 	"net/http"
+	"net/http/pprof"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/kubenav/kubenav/pkg/kube"
+	"github.com/kubenav/kubenav/pkg/server/audit"
+	"github.com/kubenav/kubenav/pkg/server/metrics"
 	"github.com/kubenav/kubenav/pkg/server/middleware"
+	"github.com/kubenav/kubenav/pkg/server/plugins"
+	"github.com/kubenav/kubenav/pkg/server/requestcache"
+	"github.com/kubenav/kubenav/pkg/server/resources"
+	"github.com/kubenav/kubenav/pkg/server/selfcheck"
+	"github.com/kubenav/kubenav/pkg/server/validation"
+
+	"github.com/gorilla/websocket"
+)
+
+// Options are the options for our internal http server. The zero value of the options can be used to start the server
+// with the default settings.
+//
+// The "MaxTerminalSessions" option is the maximum number of concurrent terminal sessions and the
+// "MaxTerminalSessionsPerCluster" option is the maximum number of concurrent terminal sessions for a single cluster. A
+// value of 0 means that the number of sessions is not limited.
+//
+// The "DisableWebSocketCompression" option can be used to disable the per message compression for all WebSocket
+// connections, e.g. in CPU constrained environments.
+//
+// The "ExecProtocol" option defines the protocol which is used to exec into a container. It can be "spdy" or
+// "websocket". If it is empty the SPDY protocol is used with a fallback to the WebSocket protocol.
+//
+// The "TerminalAuditLog" option enables the audit log for terminal sessions. It must be the path of the audit log file
+// or "stdout". When the "TerminalAuditLogInput" option is set, the input of the user is also written to the audit log
+// (aggregated per line). Be aware that this could include sensitive data, which is typed in the terminal. The
+// "AuditLogMaxSize" (in bytes), "AuditLogMaxBackups" and "AuditLogMaxAge" (in seconds) options are used for the
+// rotation of the audit log files.
+//
+// The "MutationAuditLog" option enables the audit log for mutating operations against a cluster, e.g. deleting,
+// scaling or patching a resource. It must be the path of the audit log file or "stdout". The entries contain the
+// cluster, the resource url or action and the outcome, but never the credentials or the request body. When the audit
+// log is written to a file, the last entries can be read via the "/api/audit" endpoint.
+//
+// The "LogMaxStreams" and "LogMaxBytesPerSecond" options limit the number of concurrent log streams and the bandwidth
+// of a single log connection, when the logs of all Pods matching a label selector are streamed. If they are 0 the
+// defaults from the logs package are used.
+//
+// The "WatchMaxSubscriptions" option is the maximum number of watches for a single connection, when multiple resources
+// are watched over the same connection. If it is 0 the default from the watch package is used.
+//
+// The "MetricsSampler" option enables the in-memory sampler for the metrics of Pods and Nodes, so that the app can
+// display the usage as chart without Prometheus. The "MetricsSampleInterval" (in seconds), "MetricsSampleSize" and
+// "MetricsTargetTTL" (in seconds) options are passed to the sampler, if they are 0 the defaults are used.
+//
+// The "Address" and "Port" options define where the server is listening. If the address is empty the server listens
+// on "127.0.0.1" and if the port is not set the port "14122" is used. The port can be 0, so that the operating system
+// chooses a free port, which is returned by Start. The server refuses to listen on a non-loopback address, unless the
+// "AllowRemoteAddress" option is set, because then the server can be used by everyone in the network.
+//
+// The "UnixSocket" option is the path of a Unix domain socket, on which the server is listening in addition to the
+// TCP address. The socket is only accessible by the current user and removed when the server is stopped. When the
+// "DisableTCP" option is set, the server only listens on the socket. Unix sockets are not supported on Windows.
+//
+// The "TLSCertFile" and "TLSKeyFile" options can be used to serve the TCP address via HTTPS. Instead of providing a
+// certificate, the "TLSSelfSigned" option can be set to generate a self-signed certificate at startup. The certificate
+// is valid for "localhost", the loopback addresses, the listen address and all hostnames and ip addresses from the
+// "TLSHosts" option. The SHA-256 fingerprint of the certificate is returned by Start, so that the client can pin it.
+//
+// All endpoints except the health endpoint require an auth token, so that other processes on the machine can not use
+// the server as proxy to the clusters of the user. The token can be provided via the "AuthToken" option, otherwise a
+// random token is generated at startup. The token is returned by Start and must be send in the "X-AUTH-TOKEN" header
+// or for WebSocket connections and Server-Sent Events in the "token" query parameter. The "DisableAuth" option
+// disables the auth token and should only be used, when the server is only accessible via a Unix socket.
+//
+// The "AllowedOrigins" option is the list of origins, which are allowed to open a WebSocket connection, e.g.
+// "tauri://localhost" or "null" for an app which is loaded from a file. Requests without an "Origin" header are always
+// allowed.
+//
+// The "Cors" option configures the cors headers, which are required when the web build of the frontend is served from
+// another origin, e.g. a development server. By default no cors headers are set.
+//
+// The "RequestLogLevel" option enables the request log, which is written as JSON lines to stderr. It can be "off",
+// "error", "info" or "debug". The credentials of the user are never written to the request log.
+//
+// Error responses are scrubbed, so that they do not contain the credentials of the user. The "LogOriginalErrors" option
+// logs the original errors, which can contain credentials, and should only be used for debugging on a trusted machine.
+//
+// The "RateLimit" option limits the number of requests, which can be made to the server. Requests which are proxied to
+// a cluster or start a new session have a separate budget from cheap requests like the health check. By default the
+// requests are not limited.
+//
+// The responses are compressed via gzip, when the client supports it and the response is larger than the
+// "CompressionMinSize" option (in bytes, default 1024). The compression can be disabled via the "DisableCompression"
+// option.
+//
+// The "PrometheusMetrics" option enables the "/metrics" endpoint, which exposes the metrics of the server (requests,
+// sessions, watches and the Go runtime) in the Prometheus format. The endpoint requires the auth token.
+//
+// The "Profiling" option enables the pprof endpoints under "/debug/pprof/", which can be used to diagnose memory leaks
+// on the machine of a user. The endpoints require the auth token and should never be enabled by default.
+//
+// The "DrainTimeout" option is the duration in seconds, which Stop waits for the sessions to be closed, before all
+// remaining connections are closed forcefully. If it is 0 a timeout of 10 seconds is used.
+//
+// The "MaxRequestBodySize" option is the maximum size of a request body and of a single WebSocket message in bytes.
+// Larger requests are rejected with a "413 Request Entity Too Large" error and WebSocket connections are closed. The
+// "MaxResponseSize" option is the maximum size in bytes of a response from the Kubernetes API, which is buffered by
+// the server, larger responses are truncated. If they are 0 the default values (3 MiB and 64 MiB) are used.
+//
+// The "DisableSelfCheck" option disables the self-check of the required capabilities (loopback listener, Unix sockets,
+// temporary files and DNS), which is run when the server is started. The self-check can take up to 2 seconds, when the
+// device is offline. It is always available via the "/api/selfcheck" endpoint.
+//
+// The "Plugins" option contains the location of the services, which are used by the plugins (e.g. Prometheus), for
+// each cluster. The key of the map is the cluster server or the context name and the value maps the name of a plugin to
+// its service. If a plugin isn't configured for a cluster, the service is autodetected.
+//
+// Some options (e.g. the log level, the limits and the allowed origins) can be changed while the server is running via
+// a PUT request to the "/api/config" endpoint, see reloadableOptions. All other options require a restart.
+type Options struct {
+	MaxTerminalSessions           int                         `json:"maxTerminalSessions"`
+	MaxTerminalSessionsPerCluster int                         `json:"maxTerminalSessionsPerCluster"`
+	DisableWebSocketCompression   bool                        `json:"disableWebSocketCompression"`
+	ExecProtocol                  string                      `json:"execProtocol"`
+	TerminalAuditLog              string                      `json:"terminalAuditLog"`
+	TerminalAuditLogInput         bool                        `json:"terminalAuditLogInput"`
+	AuditLogMaxSize               int64                       `json:"auditLogMaxSize"`
+	AuditLogMaxBackups            int                         `json:"auditLogMaxBackups"`
+	AuditLogMaxAge                int64                       `json:"auditLogMaxAge"`
+	MutationAuditLog              string                      `json:"mutationAuditLog"`
+	LogMaxStreams                 int                         `json:"logMaxStreams"`
+	LogMaxBytesPerSecond          int64                       `json:"logMaxBytesPerSecond"`
+	WatchMaxSubscriptions         int                         `json:"watchMaxSubscriptions"`
+	MetricsSampler                bool                        `json:"metricsSampler"`
+	MetricsSampleInterval         int64                       `json:"metricsSampleInterval"`
+	MetricsSampleSize             int                         `json:"metricsSampleSize"`
+	MetricsTargetTTL              int64                       `json:"metricsTargetTTL"`
+	Address                       string                      `json:"address"`
+	Port                          *int                        `json:"port"`
+	AllowRemoteAddress            bool                        `json:"allowRemoteAddress"`
+	UnixSocket                    string                      `json:"unixSocket"`
+	DisableTCP                    bool                        `json:"disableTCP"`
+	TLSCertFile                   string                      `json:"tlsCertFile"`
+	TLSKeyFile                    string                      `json:"tlsKeyFile"`
+	TLSSelfSigned                 bool                        `json:"tlsSelfSigned"`
+	TLSHosts                      []string                    `json:"tlsHosts"`
+	AuthToken                     string                      `json:"authToken"`
+	DisableAuth                   bool                        `json:"disableAuth"`
+	AllowedOrigins                []string                    `json:"allowedOrigins"`
+	Cors                          middleware.CorsOptions      `json:"cors"`
+	RequestLogLevel               string                      `json:"requestLogLevel"`
+	LogOriginalErrors             bool                        `json:"logOriginalErrors"`
+	RateLimit                     middleware.RateLimitOptions `json:"rateLimit"`
+	DisableCompression            bool                        `json:"disableCompression"`
+	CompressionMinSize            int                         `json:"compressionMinSize"`
+	PrometheusMetrics             bool                        `json:"prometheusMetrics"`
+	Profiling                     bool                        `json:"profiling"`
+	DrainTimeout                  int64                       `json:"drainTimeout"`
+	MaxRequestBodySize            int64                       `json:"maxRequestBodySize"`
+	MaxResponseSize               int64                       `json:"maxResponseSize"`
+	DisableSelfCheck              bool                        `json:"disableSelfCheck"`
+	Plugins                       plugins.Options             `json:"plugins"`
+}
+
+// Info contains the information about a started server, which is required by the client to connect to the server.
+// The Port is 0, when the server only listens on a Unix socket and the CertificateFingerprint is only set, when TLS is
+// enabled. The AuthToken is empty, when the auth token is disabled. The SelfCheck contains the results of the startup
+// self-check, so that the app can disable features which will not work on the platform of the user.
+type Info struct {
+	Port                   int                `json:"port"`
+	CertificateFingerprint string             `json:"certificateFingerprint,omitempty"`
+	AuthToken              string             `json:"authToken,omitempty"`
+	SelfCheck              []selfcheck.Result `json:"selfCheck,omitempty"`
+}
+
+// The default address and port of the server.
+const (
+	DefaultAddress = "127.0.0.1"
+	DefaultPort    = 14122
 )
 
 type server struct {
-	kubeClient kube.Client
+	kubeClient       kube.Client
+	options          atomic.Pointer[Options]
+	configLock       sync.Mutex
+	requestLogger    *middleware.Logger
+	rateLimiter      *middleware.RateLimiter
+	terminalAuditLog *audit.Writer
+	mutationAuditLog *audit.Writer
+	metricsSampler   *metrics.Sampler
+	discoveryCache   *resources.DiscoveryCache
+	proxyRequests    *requestcache.Group[*proxyResponse]
+	schemaCache      *validation.SchemaCache
+	port             int
+	authToken        string
+	httpServer       *http.Server
+	cancel           context.CancelFunc
+	done             chan struct{}
+	connections      map[*websocket.Conn]struct{}
+	connectionsLock  sync.Mutex
+	startup          atomic.Pointer[startup]
 }
 
-// Start creates all routes for our internal http server and starts the server on port "14122".
-func Start(kubeClient kube.Client) {
+// startup contains the info, start time and listen addresses of a server. It is published as a whole, when the
+// start-up of the server is complete, so that the handlers never see a partly initialised server.
+type startup struct {
+	info      *Info
+	startTime time.Time
+	addresses []string
+}
+
+// start creates all routes for our internal http server and starts the server on the address and port from the
+// options. The function returns as soon as the server is listening, the requests are served in the background. The
+// info of the returned server contains the port the server is listening on, which is important when the operating
+// system chooses the port.
+func start(kubeClient kube.Client, options Options) (*server, error) {
 	s := &server{
-		kubeClient: kubeClient,
+		kubeClient:     kubeClient,
+		discoveryCache: resources.NewDiscoveryCache(),
+		proxyRequests:  requestcache.NewGroup[*proxyResponse](),
+		schemaCache:    validation.NewSchemaCache(),
+		done:           make(chan struct{}),
+		connections:    make(map[*websocket.Conn]struct{}),
+	}
+	s.options.Store(&options)
+
+	requestLogger, err := middleware.NewLogger(options.RequestLogLevel, os.Stderr)
+	if err != nil {
+		return nil, err
+	}
+	s.requestLogger = requestLogger
+
+	middleware.LogOriginalErrors(options.LogOriginalErrors)
+
+	var listeners []net.Listener
+	closeListeners := func() {
+		for _, listener := range listeners {
+			listener.Close()
+		}
+	}
+
+	if !options.DisableAuth {
+		s.authToken = options.AuthToken
+		if s.authToken == "" {
+			authToken, err := generateAuthToken()
+			if err != nil {
+				return nil, fmt.Errorf("could not generate auth token: %w", err)
+			}
+			s.authToken = authToken
+		}
+	}
+
+	info := &Info{AuthToken: s.authToken}
+
+	if !options.DisableTCP {
+		tlsConfig, fingerprint, err := getTLSConfig(options)
+		if err != nil {
+			return nil, err
+		}
+
+		listener, err := listenTCP(options)
+		if err != nil {
+			return nil, err
+		}
+		s.port = listener.Addr().(*net.TCPAddr).Port
+
+		// When TLS is enabled, plain HTTP requests to the port are answered with a "400 Bad Request" error by the http
+		// server, which tells the client that it must use HTTPS.
+		if tlsConfig != nil {
+			listener = tls.NewListener(listener, tlsConfig)
+			info.CertificateFingerprint = fingerprint
+			log.Printf("Server certificate fingerprint (SHA-256): %s", fingerprint)
+		}
+
+		listeners = append(listeners, listener)
+		info.Port = s.port
+	}
+
+	if options.UnixSocket != "" {
+		listener, err := listenUnix(options.UnixSocket)
+		if err != nil {
+			closeListeners()
+			return nil, err
+		}
+		listeners = append(listeners, listener)
+	}
+
+	if len(listeners) == 0 {
+		return nil, fmt.Errorf("the UnixSocket option is required, when the DisableTCP option is set")
+	}
+
+	// When the audit log for terminal sessions is enabled, but we can not create the audit log, we do not start the
+	// server, because the user expects that all terminal sessions are recorded.
+	if options.TerminalAuditLog != "" {
+		terminalAuditLog, err := audit.New(options.TerminalAuditLog, options.AuditLogMaxSize, options.AuditLogMaxBackups, time.Duration(options.AuditLogMaxAge)*time.Second)
+		if err != nil {
+			closeListeners()
+			return nil, fmt.Errorf("could not create terminal audit log: %w", err)
+		}
+
+		s.terminalAuditLog = terminalAuditLog
+	}
+
+	if options.MutationAuditLog != "" {
+		mutationAuditLog, err := audit.New(options.MutationAuditLog, options.AuditLogMaxSize, options.AuditLogMaxBackups, time.Duration(options.AuditLogMaxAge)*time.Second)
+		if err != nil {
+			closeListeners()
+			s.terminalAuditLog.Close()
+			return nil, fmt.Errorf("could not create mutation audit log: %w", err)
+		}
+
+		s.mutationAuditLog = mutationAuditLog
+	}
+
+	if options.MetricsSampler {
+		s.metricsSampler = metrics.NewSampler(time.Duration(options.MetricsSampleInterval)*time.Second, options.MetricsSampleSize, time.Duration(options.MetricsTargetTTL)*time.Second)
+		s.metricsSampler.Start()
 	}
 
 	router := http.NewServeMux()
-	router.HandleFunc("/health", middleware.Cors(s.healthHandler))
-	router.HandleFunc("/portforwarding", middleware.Cors(s.portForwardingHandler))
-	router.HandleFunc("/terminal", middleware.Cors(s.terminalHandler))
 
-	if err := http.ListenAndServe(":14122", router); err != nil {
-		return
+	// All endpoints except the health endpoint require the auth token. The rate limiter is applied before the auth
+	// token is checked, so that the token can not be guessed via a large number of requests.
+	var serverMetrics *middleware.Metrics
+	removeMetricsHooks := func() {}
+	if options.PrometheusMetrics {
+		serverMetrics, removeMetricsHooks = newServerMetrics()
+	}
+
+	rateLimiter := middleware.NewRateLimiter(options.RateLimit)
+	s.rateLimiter = rateLimiter
+
+	router.HandleFunc("/health", serverMetrics.Instrument("/health", s.cors(rateLimiter.Cheap(s.healthHandler))))
+	for _, route := range s.routes(serverMetrics) {
+		router.HandleFunc(route.path, serverMetrics.Instrument(route.path, s.cors(route.limit(middleware.Auth(s.authToken, route.handler)))))
+	}
+
+	var handler http.Handler = router
+	if !options.DisableCompression {
+		handler = middleware.Gzip(options.CompressionMinSize, handler)
+	}
+
+	// The self-check and the info of the server must be completed before we start to serve the listeners, because they
+	// are used by the handlers, e.g. the health endpoint.
+	if !options.DisableSelfCheck {
+		info.SelfCheck = selfcheck.Run(context.Background())
+		for _, result := range info.SelfCheck {
+			if !result.Passed {
+				log.Printf("Self-check for %s failed: %s", result.Capability, result.Message)
+			}
+		}
+	}
+
+	addresses := make([]string, 0, len(listeners))
+	for _, listener := range listeners {
+		addresses = append(addresses, listener.Addr().String())
+	}
+	s.startup.Store(&startup{info: info, startTime: time.Now(), addresses: addresses})
+
+	// All listeners are served by the same http server. When one of the listeners fails, all other listeners are
+	// closed, so that the server is stopped completely.
+	//
+	// All requests use the same base context, which is canceled when the server is stopped, so that all running
+	// streams are stopped.
+	baseCtx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	s.httpServer = &http.Server{
+		Handler:     middleware.RequestID(requestLogger.Handler(handler)),
+		BaseContext: func(net.Listener) context.Context { return baseCtx },
+	}
+
+	go func() {
+		defer close(s.done)
+		defer cancel()
+
+		if s.terminalAuditLog != nil {
+			defer s.terminalAuditLog.Close()
+		}
+		if s.mutationAuditLog != nil {
+			defer s.mutationAuditLog.Close()
+		}
+		if s.metricsSampler != nil {
+			defer s.metricsSampler.Stop()
+		}
+		defer removeMetricsHooks()
+
+		errCh := make(chan error, len(listeners))
+		for _, listener := range listeners {
+			log.Printf("Server is listening on %s", listener.Addr().String())
+			go func(listener net.Listener) {
+				errCh <- s.httpServer.Serve(listener)
+			}(listener)
+		}
+
+		err := <-errCh
+		log.Printf("Server stopped: %s", err.Error())
+		s.httpServer.Close()
+		clearInstance(s)
+	}()
+
+	return s, nil
+}
+
+// route is an endpoint of the server, which requires the auth token. The limit is the rate limit of the endpoint.
+type route struct {
+	path    string
+	limit   func(http.HandlerFunc) http.HandlerFunc
+	handler http.HandlerFunc
+}
+
+// routes returns all endpoints of the server, which require the auth token. The health endpoint is the only endpoint
+// without auth token, so that it isn't contained in the returned routes. The metrics endpoint is only returned, when
+// the server metrics are enabled and the profiling endpoints only, when the "Profiling" option is set.
+func (s *server) routes(serverMetrics *middleware.Metrics) []route {
+	cheapGet := func(next http.HandlerFunc) http.HandlerFunc {
+		return s.rateLimiter.CheapMethods(next, http.MethodGet)
+	}
+
+	routes := []route{
+		{"/portforwarding", cheapGet, s.portForwardingHandler},
+		{"/terminal", s.rateLimiter.Expensive, s.terminalHandler},
+		{"/terminal/containers", s.rateLimiter.Expensive, s.terminalContainersHandler},
+		{"/api/files", s.rateLimiter.Expensive, s.filesHandler},
+		{"/api/files/download", s.rateLimiter.Expensive, s.filesDownloadHandler},
+		{"/api/processes", s.rateLimiter.Expensive, s.processesHandler},
+		{"/api/logs", s.rateLimiter.Expensive, s.logsHandler},
+		{"/api/logs/download", s.rateLimiter.Expensive, s.logsDownloadHandler},
+		{"/api/logs/sse", s.rateLimiter.Expensive, s.logsHandler},
+		{"/api/rollout", s.rateLimiter.Expensive, s.rolloutHandler},
+		{"/api/rollout/sse", s.rateLimiter.Expensive, s.rolloutHandler},
+		{"/api/wait", s.rateLimiter.Expensive, s.waitHandler},
+		{"/api/wait/sse", s.rateLimiter.Expensive, s.waitHandler},
+		{"/api/watch", s.rateLimiter.Expensive, s.watchHandler},
+		{"/api/watch/sse", s.rateLimiter.Expensive, s.watchHandler},
+		{"/api/events", s.rateLimiter.Expensive, s.eventsHandler},
+		{"/api/events/sse", s.rateLimiter.Expensive, s.eventsHandler},
+		{"/api/events/summary", s.rateLimiter.Expensive, s.eventsSummaryHandler},
+		{"/api/metrics/samples", s.rateLimiter.Cheap, s.metricsSamplesHandler},
+		{"/api/storage", s.rateLimiter.Expensive, s.storageSummaryHandler},
+		{"/api/storage/pvcs", s.rateLimiter.Expensive, s.storagePVCsHandler},
+		{"/api/top", s.rateLimiter.Expensive, s.topHandler},
+		{"/api/nodes/summary", s.rateLimiter.Expensive, s.nodesSummaryHandler},
+		{"/api/overview", s.rateLimiter.Expensive, s.overviewHandler},
+		{"/api/resources", s.rateLimiter.Expensive, s.resourcesHandler},
+		{"/api/resources/delete", s.rateLimiter.Expensive, s.resourcesDeleteHandler},
+		{"/api/pods/forcedelete", s.rateLimiter.Expensive, s.podsForceDeleteHandler},
+		{"/api/pods/images", s.rateLimiter.Expensive, s.podsImagesHandler},
+		{"/api/pods/unhealthy", s.rateLimiter.Expensive, s.podsUnhealthyHandler},
+		{"/api/cronjobs/suspend", s.rateLimiter.Expensive, s.cronJobsSuspendHandler},
+		{"/api/cronjobs/resume", s.rateLimiter.Expensive, s.cronJobsResumeHandler},
+		{"/api/rbac/cani", s.rateLimiter.Expensive, s.rbacCanIHandler},
+		{"/api/rbac/whocan", s.rateLimiter.Expensive, s.rbacWhoCanHandler},
+		{"/api/serviceaccounts/token", s.rateLimiter.Expensive, s.serviceAccountsTokenHandler},
+		{"/api/snapshots/export", s.rateLimiter.Expensive, s.snapshotsExportHandler},
+		{"/api/snapshots/restore", s.rateLimiter.Expensive, s.snapshotsRestoreHandler},
+		{"/api/debugbundle", s.rateLimiter.Expensive, s.debugBundleHandler},
+		{"/api/manifests/validate", s.rateLimiter.Expensive, s.manifestsValidateHandler},
+		{"/api/configdata", s.rateLimiter.Expensive, s.configDataUpdateHandler},
+		{"/api/namespaces/delete", s.rateLimiter.Expensive, s.namespacesDeleteHandler},
+		{"/api/namespaces/deletion", s.rateLimiter.Expensive, s.namespacesDeletionHandler},
+		{"/api/namespaces/deletion/sse", s.rateLimiter.Expensive, s.namespacesDeletionHandler},
+		{"/api/audit", s.rateLimiter.Cheap, s.auditHandler},
+		{"/api/proxy/", s.rateLimiter.Expensive, s.proxyHandler},
+		{"/api/config", s.rateLimiter.Cheap, s.configHandler},
+		{"/api/selfcheck", s.rateLimiter.Expensive, s.selfCheckHandler},
+		{"/api/plugins/prometheus/query", s.rateLimiter.Expensive, s.prometheusQueryHandler},
+		{"/api/plugins/prometheus/query_range", s.rateLimiter.Expensive, s.prometheusQueryRangeHandler},
+		{"/api/plugins/elasticsearch/search", s.rateLimiter.Expensive, s.elasticsearchSearchHandler},
+		{"/api/plugins/loki/query_range", s.rateLimiter.Expensive, s.lokiQueryRangeHandler},
+		{"/api/plugins/loki/labels", s.rateLimiter.Expensive, s.lokiLabelsHandler},
+		{"/api/plugins/loki/series", s.rateLimiter.Expensive, s.lokiSeriesHandler},
+		{"/api/plugins/loki/tail", s.rateLimiter.Expensive, s.lokiTailHandler},
+		{"/api/certificates", s.rateLimiter.Expensive, s.certificatesHandler},
+		{"/api/gatekeeper/violations", s.rateLimiter.Expensive, s.gatekeeperViolationsHandler},
+		{"/api/flux/resources", s.rateLimiter.Expensive, s.fluxResourcesHandler},
+		{"/api/flux/reconcile", s.rateLimiter.Expensive, s.fluxReconcileHandler},
+		{"/api/flux/suspend", s.rateLimiter.Expensive, s.fluxSuspendHandler},
+		{"/api/velero/resources", s.rateLimiter.Expensive, s.veleroResourcesHandler},
+		{"/api/velero/backup", s.rateLimiter.Expensive, s.veleroBackupHandler},
+		{"/api/velero/restore", s.rateLimiter.Expensive, s.veleroRestoreHandler},
+		{"/api/velero/watch", s.rateLimiter.Expensive, s.veleroWatchHandler},
+		{"/api/velero/logs", s.rateLimiter.Expensive, s.veleroLogsHandler},
+		{"/api/kustomize/render", s.rateLimiter.Expensive, s.kustomizeRenderHandler},
+		{"/api/helm/releases", s.rateLimiter.Expensive, s.helmReleasesHandler},
+		{"/api/helm/history", s.rateLimiter.Expensive, s.helmHistoryHandler},
+		{"/api/helm/values", s.rateLimiter.Expensive, s.helmValuesHandler},
+		{"/api/helm/manifest", s.rateLimiter.Expensive, s.helmManifestHandler},
+		{"/api/helm/diff", s.rateLimiter.Expensive, s.helmDiffHandler},
+		{"/api/helm/rollback", s.rateLimiter.Expensive, s.helmRollbackHandler},
+		{"/api/helm/uninstall", s.rateLimiter.Expensive, s.helmUninstallHandler},
+
+		{"/debug/stats", s.rateLimiter.Cheap, s.debugStatsHandler},
+	}
+
+	if serverMetrics != nil {
+		routes = append(routes, route{"/metrics", s.rateLimiter.Cheap, serverMetrics.Handler()})
 	}
+
+	if s.getOptions().Profiling {
+		routes = append(routes,
+			route{"/debug/pprof/", s.rateLimiter.Cheap, pprof.Index},
+			route{"/debug/pprof/cmdline", s.rateLimiter.Cheap, pprof.Cmdline},
+			route{"/debug/pprof/profile", s.rateLimiter.Cheap, pprof.Profile},
+			route{"/debug/pprof/symbol", s.rateLimiter.Cheap, pprof.Symbol},
+			route{"/debug/pprof/trace", s.rateLimiter.Cheap, pprof.Trace},
+		)
+	}
+
+	return routes
+}
+
+// generateAuthToken generates a random auth token with 32 bytes, which is returned as hex encoded string.
+func generateAuthToken() (string, error) {
+	token := make([]byte, 32)
+	if _, err := rand.Read(token); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(token), nil
+}
+
+// listenTCP creates the TCP listener for the server from the address and port in the options. If the address isn't a
+// loopback address, the "AllowRemoteAddress" option must be set.
+func listenTCP(options Options) (net.Listener, error) {
+	address := options.Address
+	if address == "" {
+		address = DefaultAddress
+	}
+
+	port := DefaultPort
+	if options.Port != nil {
+		port = *options.Port
+	}
+	if port < 0 || port > 65535 {
+		return nil, fmt.Errorf("invalid port %d", port)
+	}
+
+	if !options.AllowRemoteAddress && !isLoopbackAddress(address) {
+		return nil, fmt.Errorf("refusing to listen on the non-loopback address %s, the AllowRemoteAddress option must be set", address)
+	}
+
+	return net.Listen("tcp", net.JoinHostPort(address, strconv.Itoa(port)))
+}
+
+// isLoopbackAddress returns true, when the given address is "localhost" or a loopback ip address.
+func isLoopbackAddress(address string) bool {
+	if address == "localhost" {
+		return true
+	}
+
+	ip := net.ParseIP(address)
+	return ip != nil && ip.IsLoopback()
 }
@@ -0,0 +1,170 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kubenav/kubenav/pkg/server/middleware"
+
+	"github.com/gorilla/websocket"
+)
+
+// TestRoutesRequireAuthToken walks all routes of the server and checks that each route rejects requests without the
+// auth token and accepts requests with the auth token in the header or the query. The health endpoint is the only
+// endpoint, which can be used without the auth token.
+func TestRoutesRequireAuthToken(t *testing.T) {
+	api, execs := newFakeKubernetesAPI(t)
+
+	port := 0
+	info, err := Start(&fakeKubeClient{host: api.URL}, Options{
+		Port:              &port,
+		AuthToken:         "token",
+		ExecProtocol:      "websocket",
+		DisableSelfCheck:  true,
+		PrometheusMetrics: true,
+		Profiling:         true,
+	})
+	if err != nil {
+		t.Fatalf("could not start server: %v", err)
+	}
+	defer Stop()
+
+	address := fmt.Sprintf("127.0.0.1:%d", info.Port)
+
+	// The query parameters for the routes, which need them to succeed. The profile and trace endpoints would take 30
+	// seconds without the "seconds" parameter.
+	queries := map[string]string{
+		"/terminal":            "name=nginx&namespace=default&container=nginx",
+		"/api/logs":            "name=nginx&namespace=default&container=nginx",
+		"/api/watch":           "url=/api/v1/namespaces/default/pods",
+		"/debug/pprof/profile": "seconds=1",
+		"/debug/pprof/trace":   "seconds=1",
+	}
+
+	// The routes, which upgrade the connection to a WebSocket connection, are checked with a WebSocket client. The
+	// terminal route starts an exec session in the fake Kubernetes API.
+	webSockets := map[string]bool{
+		"/terminal":  true,
+		"/api/logs":  true,
+		"/api/watch": true,
+	}
+
+	get := func(t *testing.T, path, query string, header http.Header) *http.Response {
+		t.Helper()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+address+path+"?"+query, nil)
+		if err != nil {
+			t.Fatalf("could not create request: %v", err)
+		}
+		req.Header = header
+
+		// Only the status code is checked, the body of streaming responses is never read completely.
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("could not send request: %v", err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	dial := func(t *testing.T, path, query string, header http.Header) (*websocket.Conn, *http.Response, error) {
+		t.Helper()
+
+		conn, resp, err := websocket.DefaultDialer.Dial("ws://"+address+path+"?"+query, header)
+		if err == nil && path == "/terminal" {
+			select {
+			case <-execs:
+			case <-time.After(5 * time.Second):
+				t.Fatalf("terminal session was not started")
+			}
+		}
+		return conn, resp, err
+	}
+
+	if resp := get(t, "/health", "", nil); resp.StatusCode != http.StatusOK {
+		t.Errorf("expected status code %d for health endpoint without auth token, got %d", http.StatusOK, resp.StatusCode)
+	}
+
+	routes := getInstance().routes(middleware.NewMetrics())
+	if len(routes) < 70 {
+		t.Fatalf("expected all routes, got %d routes", len(routes))
+	}
+
+	for _, route := range routes {
+		path := route.path
+		if path == "/api/proxy/" {
+			path = "/api/proxy/api/v1/namespaces/default/pods"
+		}
+		query := queries[route.path]
+
+		t.Run(route.path, func(t *testing.T) {
+			if webSockets[route.path] {
+				for _, header := range []http.Header{nil, {middleware.AuthTokenHeader: []string{"wrong"}}} {
+					conn, resp, err := dial(t, path, query, header)
+					if err == nil {
+						conn.Close()
+					}
+					if err == nil || resp == nil || resp.StatusCode != http.StatusUnauthorized {
+						t.Errorf("expected status code %d for upgrade with header %v, got %v (%v)", http.StatusUnauthorized, header, resp, err)
+					}
+				}
+
+				for _, tc := range []struct {
+					query  string
+					header http.Header
+				}{
+					{query: query, header: http.Header{middleware.AuthTokenHeader: []string{"token"}}},
+					{query: query + "&token=token", header: nil},
+				} {
+					conn, resp, err := dial(t, path, tc.query, tc.header)
+					if err != nil {
+						t.Errorf("expected upgrade with auth token to succeed, got %v (%v)", resp, err)
+						continue
+					}
+					conn.Close()
+				}
+				return
+			}
+
+			for _, header := range []http.Header{{}, {middleware.AuthTokenHeader: []string{"wrong"}}} {
+				ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+				req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+address+path+"?"+query, nil)
+				if err != nil {
+					cancel()
+					t.Fatalf("could not create request: %v", err)
+				}
+				req.Header = header
+
+				resp, err := http.DefaultClient.Do(req)
+				if err != nil {
+					cancel()
+					t.Fatalf("could not send request: %v", err)
+				}
+
+				var response middleware.Error
+				json.NewDecoder(resp.Body).Decode(&response)
+				resp.Body.Close()
+				cancel()
+
+				if resp.StatusCode != http.StatusUnauthorized || response.Code != middleware.CodeUnauthorized {
+					t.Errorf("expected status code %d with code %s for header %v, got %d with code %q", http.StatusUnauthorized, middleware.CodeUnauthorized, header, resp.StatusCode, response.Code)
+				}
+			}
+
+			if resp := get(t, path, query, http.Header{middleware.AuthTokenHeader: []string{"token"}}); resp.StatusCode == http.StatusUnauthorized {
+				t.Errorf("expected request with auth token in the header to be accepted")
+			}
+			if resp := get(t, path, strings.TrimPrefix(query+"&token=token", "&"), nil); resp.StatusCode == http.StatusUnauthorized {
+				t.Errorf("expected request with auth token in the query to be accepted")
+			}
+		})
+	}
+}
@@ -0,0 +1,173 @@
+// Package serviceaccounts implements operations for ServiceAccounts, which are not covered by the generic resources of
+// the server, e.g. the generation of short-lived tokens via the TokenRequest API.
+package serviceaccounts
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/kubenav/kubenav/pkg/server/resources"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// The default and the minimum expiration of a token. The API server rejects tokens which are valid for less than 10
+// minutes and can shorten the expiration via the "--service-account-max-token-expiration" flag.
+const (
+	DefaultExpiration = time.Hour
+	MinExpiration     = 10 * time.Minute
+)
+
+// selfSubjectReviewVersions are the versions of the SelfSubjectReview API, which are tried to verify a token. The API
+// is alpha in Kubernetes 1.26, beta in 1.27 and stable since 1.28.
+var selfSubjectReviewVersions = []string{"v1", "v1beta1", "v1alpha1"}
+
+var (
+	// ErrInvalidOptions is returned when the options for a token are invalid.
+	ErrInvalidOptions = fmt.Errorf("%w", resources.ErrInvalidOptions)
+	// ErrNotSupported is returned when the cluster doesn't support the TokenRequest API.
+	ErrNotSupported = errors.New("the TokenRequest API is not supported by the cluster, Kubernetes 1.22 or later is required")
+)
+
+// TokenOptions are the options to generate a token for the ServiceAccount with the given namespace and name. The
+// expiration is set in seconds and defaults to DefaultExpiration. When the audiences are empty, the token is valid for
+// the API server. When "Verify" is true, the token is used to authenticate against the API server, to return the
+// username and groups of the token.
+type TokenOptions struct {
+	Namespace         string   `json:"namespace"`
+	Name              string   `json:"name"`
+	ExpirationSeconds int64    `json:"expirationSeconds"`
+	Audiences         []string `json:"audiences"`
+	Verify            bool     `json:"verify"`
+}
+
+// Token is a generated token for a ServiceAccount. The expiration timestamp is set by the API server, which can differ
+// from the requested expiration.
+type Token struct {
+	Token               string        `json:"token"`
+	ExpirationTimestamp metav1.Time   `json:"expirationTimestamp"`
+	Audiences           []string      `json:"audiences,omitempty"`
+	Verification        *Verification `json:"verification,omitempty"`
+}
+
+// Verification is the result of the verification of a token. When the token could be used to authenticate, the
+// username, uid and groups are the user info resolved by the API server, otherwise the message contains the error.
+type Verification struct {
+	Authenticated bool     `json:"authenticated"`
+	Username      string   `json:"username,omitempty"`
+	UID           string   `json:"uid,omitempty"`
+	Groups        []string `json:"groups,omitempty"`
+	Message       string   `json:"message,omitempty"`
+}
+
+// CreateToken generates a token for the ServiceAccount from the options via the token subresource. The config is the
+// config of the current user, which is required to create a client with the generated token for the verification.
+func CreateToken(ctx context.Context, config *rest.Config, clientset kubernetes.Interface, options TokenOptions) (*Token, error) {
+	if options.Namespace == "" || options.Name == "" {
+		return nil, fmt.Errorf("%w: namespace and name are required", ErrInvalidOptions)
+	}
+
+	expiration := DefaultExpiration
+	if options.ExpirationSeconds != 0 {
+		expiration = time.Duration(options.ExpirationSeconds) * time.Second
+	}
+	if expiration < MinExpiration {
+		return nil, fmt.Errorf("%w: expiration must be at least %d seconds", ErrInvalidOptions, int64(MinExpiration.Seconds()))
+	}
+
+	// The ServiceAccount is fetched first, because for a missing ServiceAccount and for a cluster without the token
+	// subresource the API server returns a "not found" error.
+	if _, err := clientset.CoreV1().ServiceAccounts(options.Namespace).Get(ctx, options.Name, metav1.GetOptions{}); err != nil {
+		return nil, err
+	}
+
+	expirationSeconds := int64(expiration.Seconds())
+	tokenRequest, err := clientset.CoreV1().ServiceAccounts(options.Namespace).CreateToken(ctx, options.Name, &authenticationv1.TokenRequest{
+		Spec: authenticationv1.TokenRequestSpec{
+			Audiences:         options.Audiences,
+			ExpirationSeconds: &expirationSeconds,
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) || apierrors.IsMethodNotSupported(err) {
+			return nil, fmt.Errorf("%w: %s", ErrNotSupported, err.Error())
+		}
+		return nil, err
+	}
+
+	token := &Token{
+		Token:               tokenRequest.Status.Token,
+		ExpirationTimestamp: tokenRequest.Status.ExpirationTimestamp,
+		Audiences:           tokenRequest.Spec.Audiences,
+	}
+
+	if options.Verify {
+		token.Verification = verify(ctx, config, clientset, token.Token)
+	}
+
+	return token, nil
+}
+
+// verify authenticates against the API server with the token via a SelfSubjectReview. On clusters without the
+// SelfSubjectReview API, the token is verified via a TokenReview with the credentials of the current user instead.
+func verify(ctx context.Context, config *rest.Config, clientset kubernetes.Interface, token string) *Verification {
+	tokenConfig := rest.AnonymousClientConfig(config)
+	tokenConfig.BearerToken = token
+
+	tokenClientset, err := kubernetes.NewForConfig(tokenConfig)
+	if err != nil {
+		return &Verification{Message: err.Error()}
+	}
+
+	for _, version := range selfSubjectReviewVersions {
+		data, err := tokenClientset.AuthenticationV1().RESTClient().Post().
+			AbsPath("/apis/authentication.k8s.io", version, "selfsubjectreviews").
+			Body([]byte(`{"apiVersion":"authentication.k8s.io/`+version+`","kind":"SelfSubjectReview"}`)).
+			SetHeader("Content-Type", "application/json").
+			DoRaw(ctx)
+		if err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return &Verification{Message: err.Error()}
+		}
+
+		var review struct {
+			Status struct {
+				UserInfo authenticationv1.UserInfo `json:"userInfo"`
+			} `json:"status"`
+		}
+		if err := json.Unmarshal(data, &review); err != nil {
+			return &Verification{Message: err.Error()}
+		}
+
+		return &Verification{
+			Authenticated: true,
+			Username:      review.Status.UserInfo.Username,
+			UID:           review.Status.UserInfo.UID,
+			Groups:        review.Status.UserInfo.Groups,
+		}
+	}
+
+	review, err := clientset.AuthenticationV1().TokenReviews().Create(ctx, &authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{Token: token},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return &Verification{Message: fmt.Sprintf("the SelfSubjectReview API is not available and the token review failed: %s", err.Error())}
+	}
+
+	return &Verification{
+		Authenticated: review.Status.Authenticated,
+		Username:      review.Status.User.Username,
+		UID:           review.Status.User.UID,
+		Groups:        review.Status.User.Groups,
+		Message:       review.Status.Error,
+	}
+}
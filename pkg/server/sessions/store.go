@@ -0,0 +1,159 @@
+// Package sessions implements a concurrency-safe store for the sessions of the server, which is used by the port
+// forwarding sessions and the terminal sessions. The sessions are distributed over multiple shards, each with its own
+// lock, so that the handlers, the monitoring goroutines and the metrics do not contend for a single lock.
+package sessions
+
+import (
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+)
+
+// shardCount is the number of shards of a store. It must be a power of two.
+const shardCount = 32
+
+// Hook is a function, which is called when a session is added to or removed from a store. Hooks are called after the
+// lock of the shard was released, so that they can call other methods of the store.
+type Hook[T any] func(id string, session T)
+
+// Store stores sessions of the type T by their id.
+type Store[T any] struct {
+	shards [shardCount]shard[T]
+	count  atomic.Int64
+
+	hooksLock     sync.RWMutex
+	hooksID       uint64
+	onAddHooks    map[uint64]Hook[T]
+	onRemoveHooks map[uint64]Hook[T]
+}
+
+type shard[T any] struct {
+	sessions map[string]T
+	lock     sync.RWMutex
+}
+
+// New returns a new empty store.
+func New[T any]() *Store[T] {
+	s := &Store[T]{
+		onAddHooks:    make(map[uint64]Hook[T]),
+		onRemoveHooks: make(map[uint64]Hook[T]),
+	}
+	for i := range s.shards {
+		s.shards[i].sessions = make(map[string]T)
+	}
+	return s
+}
+
+func (s *Store[T]) shard(id string) *shard[T] {
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	return &s.shards[h.Sum32()&(shardCount-1)]
+}
+
+// Get returns the session with the given id.
+func (s *Store[T]) Get(id string) (T, bool) {
+	sh := s.shard(id)
+	sh.lock.RLock()
+	defer sh.lock.RUnlock()
+
+	session, ok := sh.sessions[id]
+	return session, ok
+}
+
+// Set stores the session with the given id. If a session with the same id already exists, it is replaced and the
+// hooks are not called.
+func (s *Store[T]) Set(id string, session T) {
+	sh := s.shard(id)
+	sh.lock.Lock()
+	_, exists := sh.sessions[id]
+	sh.sessions[id] = session
+	sh.lock.Unlock()
+
+	if !exists {
+		s.count.Add(1)
+		s.callHooks(s.onAddHooks, id, session)
+	}
+}
+
+// Delete removes the session with the given id and returns it. The returned boolean is only true for the caller,
+// which actually removed the session, so that it can be used to release the resources of a session exactly once.
+func (s *Store[T]) Delete(id string) (T, bool) {
+	sh := s.shard(id)
+	sh.lock.Lock()
+	session, ok := sh.sessions[id]
+	if ok {
+		delete(sh.sessions, id)
+	}
+	sh.lock.Unlock()
+
+	if ok {
+		s.count.Add(-1)
+		s.callHooks(s.onRemoveHooks, id, session)
+	}
+	return session, ok
+}
+
+// Count returns the number of sessions in the store.
+func (s *Store[T]) Count() int {
+	return int(s.count.Load())
+}
+
+// Snapshot returns all sessions of the store. The shards are only locked while the sessions are copied, so that the
+// caller can iterate over the returned sessions without holding a lock, e.g. while writing them to a client. Sessions
+// which are added or removed while the snapshot is created may or may not be contained in the snapshot.
+func (s *Store[T]) Snapshot() []T {
+	sessions := make([]T, 0, s.Count())
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.lock.RLock()
+		for _, session := range sh.sessions {
+			sessions = append(sessions, session)
+		}
+		sh.lock.RUnlock()
+	}
+	return sessions
+}
+
+// OnAdd registers a hook, which is called for each session added to the store. The returned function removes the
+// hook again.
+func (s *Store[T]) OnAdd(hook Hook[T]) func() {
+	return s.addHook(s.onAddHooks, hook)
+}
+
+// OnRemove registers a hook, which is called for each session removed from the store. The returned function removes
+// the hook again.
+func (s *Store[T]) OnRemove(hook Hook[T]) func() {
+	return s.addHook(s.onRemoveHooks, hook)
+}
+
+func (s *Store[T]) addHook(hooks map[uint64]Hook[T], hook Hook[T]) func() {
+	s.hooksLock.Lock()
+	defer s.hooksLock.Unlock()
+
+	s.hooksID++
+	id := s.hooksID
+	hooks[id] = hook
+
+	return func() {
+		s.hooksLock.Lock()
+		defer s.hooksLock.Unlock()
+		delete(hooks, id)
+	}
+}
+
+func (s *Store[T]) callHooks(hooks map[uint64]Hook[T], id string, session T) {
+	s.hooksLock.RLock()
+	if len(hooks) == 0 {
+		s.hooksLock.RUnlock()
+		return
+	}
+	callbacks := make([]Hook[T], 0, len(hooks))
+	for _, hook := range hooks {
+		callbacks = append(callbacks, hook)
+	}
+	s.hooksLock.RUnlock()
+
+	for _, hook := range callbacks {
+		hook(id, session)
+	}
+}
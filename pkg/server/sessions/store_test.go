@@ -0,0 +1,144 @@
+package sessions
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestStore(t *testing.T) {
+	store := New[int]()
+
+	store.Set("a", 1)
+	store.Set("b", 2)
+	store.Set("a", 3)
+
+	if session, ok := store.Get("a"); !ok || session != 3 {
+		t.Errorf("expected session 3 for id a, got %d (%t)", session, ok)
+	}
+	if count := store.Count(); count != 2 {
+		t.Errorf("expected 2 sessions, got %d", count)
+	}
+	if snapshot := store.Snapshot(); len(snapshot) != 2 {
+		t.Errorf("expected 2 sessions in snapshot, got %d", len(snapshot))
+	}
+
+	if session, ok := store.Delete("a"); !ok || session != 3 {
+		t.Errorf("expected deleted session 3 for id a, got %d (%t)", session, ok)
+	}
+	if _, ok := store.Delete("a"); ok {
+		t.Errorf("expected second delete to return false")
+	}
+	if _, ok := store.Get("a"); ok {
+		t.Errorf("expected session a to be deleted")
+	}
+	if count := store.Count(); count != 1 {
+		t.Errorf("expected 1 session, got %d", count)
+	}
+}
+
+func TestStoreHooks(t *testing.T) {
+	store := New[int]()
+
+	var added, removed []string
+	removeOnAdd := store.OnAdd(func(id string, session int) {
+		added = append(added, id)
+		// Hooks are called without holding a lock, so they can use the store.
+		if _, ok := store.Get(id); !ok {
+			t.Errorf("expected session %s to be in the store in the add hook", id)
+		}
+	})
+	removeOnRemove := store.OnRemove(func(id string, session int) {
+		removed = append(removed, id)
+		if _, ok := store.Get(id); ok {
+			t.Errorf("expected session %s not to be in the store in the remove hook", id)
+		}
+	})
+
+	store.Set("a", 1)
+	store.Set("a", 2)
+	store.Delete("a")
+	store.Delete("a")
+
+	if len(added) != 1 || added[0] != "a" {
+		t.Errorf("expected add hook to be called once for a, got %v", added)
+	}
+	if len(removed) != 1 || removed[0] != "a" {
+		t.Errorf("expected remove hook to be called once for a, got %v", removed)
+	}
+
+	removeOnAdd()
+	removeOnRemove()
+
+	store.Set("b", 1)
+	store.Delete("b")
+
+	if len(added) != 1 || len(removed) != 1 {
+		t.Errorf("expected hooks not to be called after they were removed, got %v and %v", added, removed)
+	}
+}
+
+func TestStoreConcurrent(t *testing.T) {
+	const workers = 16
+	const iterations = 1000
+
+	store := New[int]()
+
+	var added, removed atomic.Int64
+	store.OnAdd(func(id string, session int) { added.Add(1) })
+	store.OnRemove(func(id string, session int) { removed.Add(1) })
+
+	// All workers delete the same ids, so that we can check that each session is only released once.
+	var released atomic.Int64
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+
+			for i := 0; i < iterations; i++ {
+				id := fmt.Sprintf("session-%d", i%100)
+
+				switch i % 4 {
+				case 0:
+					store.Set(id, w)
+				case 1:
+					store.Get(id)
+				case 2:
+					if _, ok := store.Delete(id); ok {
+						released.Add(1)
+					}
+				case 3:
+					store.Snapshot()
+					store.Count()
+				}
+			}
+		}(w)
+	}
+
+	// Hooks are registered and removed while the workers are running.
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		for i := 0; i < iterations; i++ {
+			remove := store.OnAdd(func(id string, session int) {})
+			remove()
+		}
+	}()
+
+	wg.Wait()
+
+	remaining := int64(store.Count())
+	if remaining != int64(len(store.Snapshot())) {
+		t.Errorf("expected count %d to match the snapshot %d", remaining, len(store.Snapshot()))
+	}
+	if added.Load() != removed.Load()+remaining {
+		t.Errorf("expected %d added sessions to be %d removed plus %d remaining sessions", added.Load(), removed.Load(), remaining)
+	}
+	if released.Load() != removed.Load() {
+		t.Errorf("expected %d released sessions to match %d removed sessions", released.Load(), removed.Load())
+	}
+}
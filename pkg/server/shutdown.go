@@ -0,0 +1,110 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/kubenav/kubenav/pkg/server/portforwarding"
+
+	"github.com/gorilla/websocket"
+)
+
+// DefaultDrainTimeout is the default duration, which we wait for the sessions to be closed, when the server is
+// stopped.
+const DefaultDrainTimeout = 10 * time.Second
+
+// shutdownCloseReason is the reason of the close message, which is send to all WebSocket connections, when the server
+// is stopped.
+const shutdownCloseReason = "server shutting down"
+
+func (s *server) shutdown(timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// The http server stops accepting new connections immediately and waits for all active requests. Hijacked
+	// connections (WebSockets) are not tracked by the http server, so that we have to close them on our own.
+	shutdownErrCh := make(chan error, 1)
+	go func() {
+		shutdownErrCh <- s.httpServer.Shutdown(ctx)
+	}()
+
+	s.connectionsLock.Lock()
+	for conn := range s.connections {
+		conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, shutdownCloseReason), time.Now().Add(time.Second))
+	}
+	s.connectionsLock.Unlock()
+
+	portforwarding.Sessions.StopAll()
+
+	// Canceling the base context of all requests stops all running streams (e.g. Server-Sent Events), which would
+	// otherwise block the shutdown of the http server until the timeout expires.
+	s.cancel()
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for s.countConnections() > 0 && ctx.Err() == nil {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+		}
+	}
+
+	err := <-shutdownErrCh
+	if ctx.Err() != nil {
+		log.Printf("Drain timeout of %s expired, closing remaining connections", timeout)
+		s.httpServer.Close()
+
+		s.connectionsLock.Lock()
+		for conn := range s.connections {
+			conn.Close()
+		}
+		s.connectionsLock.Unlock()
+	}
+
+	<-s.done
+
+	if err != nil && err != context.DeadlineExceeded {
+		return fmt.Errorf("could not stop server: %w", err)
+	}
+	return nil
+}
+
+// upgrade upgrades the http connection to a WebSocket connection via the given upgrader. The connection is added to
+// the list of active connections, so that it can be closed when the server is stopped. The handler must close the
+// connection via closeConnection.
+//
+// The close handler of the connection records the close code and reason of the client, so that they are added to the
+// request log.
+func (s *server) upgrade(upgrader websocket.Upgrader, w http.ResponseWriter, r *http.Request) (*websocket.Conn, error) {
+	c, err := upgradeConnection(upgrader, w, r)
+	if err != nil {
+		return nil, err
+	}
+	c.SetReadLimit(s.maxRequestBodySize())
+
+	s.connectionsLock.Lock()
+	s.connections[c] = struct{}{}
+	s.connectionsLock.Unlock()
+
+	return c, nil
+}
+
+// closeConnection closes the WebSocket connection and removes it from the list of active connections.
+func (s *server) closeConnection(c *websocket.Conn) {
+	s.connectionsLock.Lock()
+	delete(s.connections, c)
+	s.connectionsLock.Unlock()
+
+	c.Close()
+}
+
+func (s *server) countConnections() int {
+	s.connectionsLock.Lock()
+	defer s.connectionsLock.Unlock()
+
+	return len(s.connections)
+}
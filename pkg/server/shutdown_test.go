@@ -0,0 +1,266 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	remotecommandconsts "k8s.io/apimachinery/pkg/util/remotecommand"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// fakeKubeClient is a kube.Client, which returns a client for the given host for all requests. If err is set, the
+// error is returned instead of a client, like it is done for requests with missing credentials.
+type fakeKubeClient struct {
+	host string
+	err  error
+}
+
+func (c *fakeKubeClient) GetPlatform() string {
+	return "test"
+}
+
+func (c *fakeKubeClient) GetClusters() (string, map[string]string) {
+	return "", nil
+}
+
+func (c *fakeKubeClient) GetClient(contextName, clusterServer, clusterCertificateAuthorityData string, clusterInsecureSkipTLSVerify bool, userClientCertificateData, userClientKeyData, userToken, userUsername, userPassword, proxy string, timeout int64) (*rest.Config, *kubernetes.Clientset, error) {
+	if c.err != nil {
+		return nil, nil, c.err
+	}
+
+	restConfig := &rest.Config{Host: c.host}
+	clientset, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+	return restConfig, clientset, nil
+}
+
+// newFakeKubernetesAPI returns a fake Kubernetes API with a single running Pod "default/nginx" and the discovery data
+// for the core group. The exec, log and watch requests for the Pod are kept open until the client closes them. The
+// returned channel receives a value for each exec session.
+func newFakeKubernetesAPI(t *testing.T) (*httptest.Server, chan struct{}) {
+	t.Helper()
+
+	execs := make(chan struct{}, 1)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"kind":"APIVersions","versions":["v1"]}`)
+	})
+	mux.HandleFunc("/apis", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"kind":"APIGroupList","apiVersion":"v1","groups":[]}`)
+	})
+	mux.HandleFunc("/api/v1", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"kind":"APIResourceList","groupVersion":"v1","resources":[{"name":"pods","namespaced":true,"kind":"Pod","verbs":["get","list","watch"]}]}`)
+	})
+	mux.HandleFunc("/api/v1/namespaces/default/pods/nginx", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(corev1.Pod{
+			TypeMeta:   metav1.TypeMeta{Kind: "Pod", APIVersion: "v1"},
+			ObjectMeta: metav1.ObjectMeta{Name: "nginx", Namespace: "default"},
+			Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "nginx"}}},
+			Status: corev1.PodStatus{
+				Phase:             corev1.PodRunning,
+				ContainerStatuses: []corev1.ContainerStatus{{Name: "nginx", State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}}},
+			},
+		})
+	})
+	mux.HandleFunc("/api/v1/namespaces/default/pods/nginx/exec", func(w http.ResponseWriter, r *http.Request) {
+		upgrader := websocket.Upgrader{Subprotocols: []string{remotecommandconsts.StreamProtocolV4Name}}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		execs <- struct{}{}
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	})
+	mux.HandleFunc("/api/v1/namespaces/default/pods/nginx/log", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s hello world\n", time.Now().Format(time.RFC3339Nano))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	})
+	mux.HandleFunc("/api/v1/namespaces/default/pods", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Query().Get("watch") != "true" {
+			fmt.Fprint(w, `{"kind":"PodList","apiVersion":"v1","metadata":{"resourceVersion":"1"},"items":[]}`)
+			return
+		}
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	})
+
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	return srv, execs
+}
+
+// TestStopClosesAllSessions starts the server with an open terminal, log and watch stream and checks that Stop closes
+// all of them, so that no goroutines and listeners survive the shutdown.
+func TestStopClosesAllSessions(t *testing.T) {
+	api, execs := newFakeKubernetesAPI(t)
+	transport := &http.Transport{}
+	defer transport.CloseIdleConnections()
+
+	baseline := runtime.NumGoroutine()
+
+	port := 0
+	info, err := Start(&fakeKubeClient{host: api.URL}, Options{
+		Port:             &port,
+		AuthToken:        "token",
+		ExecProtocol:     "websocket",
+		DisableSelfCheck: true,
+		DrainTimeout:     2,
+	})
+	if err != nil {
+		t.Fatalf("could not start server: %v", err)
+	}
+	defer Stop()
+
+	address := fmt.Sprintf("127.0.0.1:%d", info.Port)
+	header := http.Header{"X-Auth-Token": []string{"token"}}
+
+	// All clients read until the connection is closed by the server, so that we can wait for them before we count the
+	// goroutines.
+	var clients sync.WaitGroup
+
+	terminalConn, _, err := websocket.DefaultDialer.Dial("ws://"+address+"/terminal?name=nginx&namespace=default&container=nginx", header)
+	if err != nil {
+		t.Fatalf("could not open terminal: %v", err)
+	}
+	clients.Add(1)
+	go func() {
+		defer clients.Done()
+		defer terminalConn.Close()
+		for {
+			if _, _, err := terminalConn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	select {
+	case <-execs:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("terminal session was not started")
+	}
+
+	logsConn, _, err := websocket.DefaultDialer.Dial("ws://"+address+"/api/logs?name=nginx&namespace=default&container=nginx", header)
+	if err != nil {
+		t.Fatalf("could not open log stream: %v", err)
+	}
+	if _, _, err := logsConn.ReadMessage(); err != nil {
+		t.Fatalf("could not read log stream: %v", err)
+	}
+	clients.Add(1)
+	go func() {
+		defer clients.Done()
+		defer logsConn.Close()
+		for {
+			if _, _, err := logsConn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	req, err := http.NewRequest(http.MethodGet, "http://"+address+"/api/watch/sse?url=/api/v1/namespaces/default/pods", nil)
+	if err != nil {
+		t.Fatalf("could not create watch request: %v", err)
+	}
+	req.Header = header.Clone()
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("could not open watch stream: %v", err)
+	}
+	reader := bufio.NewReader(resp.Body)
+	if line, err := reader.ReadString('\n'); err != nil || !strings.HasPrefix(line, "event:") && !strings.HasPrefix(line, "id:") {
+		t.Fatalf("could not read watch stream: %q, %v", line, err)
+	}
+	clients.Add(1)
+	go func() {
+		defer clients.Done()
+		defer resp.Body.Close()
+		for {
+			if _, err := reader.ReadString('\n'); err != nil {
+				return
+			}
+		}
+	}()
+
+	if status := GetStatus(); status.TerminalSessions != 1 || status.Watches != 1 {
+		t.Fatalf("expected 1 terminal session and 1 watch, got %d and %d", status.TerminalSessions, status.Watches)
+	}
+
+	start := time.Now()
+	if err := Stop(); err != nil {
+		t.Fatalf("could not stop server: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected the sessions to be closed before the drain timeout, stop took %s", elapsed)
+	}
+
+	clientsDone := make(chan struct{})
+	go func() {
+		clients.Wait()
+		close(clientsDone)
+	}()
+	select {
+	case <-clientsDone:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected all client connections to be closed by the server")
+	}
+
+	transport.CloseIdleConnections()
+	http.DefaultTransport.(*http.Transport).CloseIdleConnections()
+
+	if status := GetStatus(); status.Running || status.TerminalSessions != 0 || status.Watches != 0 {
+		t.Errorf("expected no running server and sessions, got %+v", status)
+	}
+
+	if conn, err := net.Dial("tcp", address); err == nil {
+		conn.Close()
+		t.Errorf("expected listener to be closed")
+	}
+
+	if n := waitForGoroutines(baseline); n > baseline {
+		buf := make([]byte, 1<<20)
+		t.Errorf("expected at most %d goroutines after stop, got %d\n%s", baseline, n, buf[:runtime.Stack(buf, true)])
+	}
+}
+
+// waitForGoroutines waits up to five seconds until the number of goroutines is at most max and returns the number of
+// goroutines.
+func waitForGoroutines(max int) int {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		n := runtime.NumGoroutine()
+		if n <= max || time.Now().After(deadline) {
+			return n
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,264 @@
+// Package snapshots implements the export of all objects of a namespace into a gzip compressed tar archive and the
+// restore of such an archive into a cluster. Each object is stored as its own YAML file, together with an index of all
+// exported objects and the resources which could not be exported.
+package snapshots
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kubenav/kubenav/pkg/server/resources"
+
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/yaml"
+)
+
+// IndexFile is the name of the index in the archive. The index is always the last file of the archive, because it
+// contains the errors of all resources.
+const IndexFile = "index.yaml"
+
+// exportPageSize is the number of objects, which are fetched per request for a resource.
+const exportPageSize = 500
+
+// ErrInvalidOptions is returned when the options for an export or restore are invalid.
+var ErrInvalidOptions = fmt.Errorf("%w", resources.ErrInvalidOptions)
+
+// skippedResources are the resources, which are never exported, because they are generated by the cluster and can not
+// be restored in a meaningful way.
+var skippedResources = map[string]bool{
+	"/events":                    true,
+	"events.k8s.io/events":       true,
+	"metrics.k8s.io/pods":        true,
+	"/bindings":                  true,
+	"coordination.k8s.io/leases": true,
+}
+
+// serverManagedFields are the fields of the metadata, which are set by the API server and must be removed, so that the
+// objects can be applied to another namespace or cluster.
+var serverManagedFields = []string{"uid", "resourceVersion", "managedFields", "creationTimestamp", "generation", "selfLink"}
+
+// ExportOptions are the options for the export of a namespace. "Kinds" can contain kinds (e.g. "Deployment") or
+// resources (e.g. "deployments") to export only some resources, if it is empty all resources are exported. Secrets are
+// only exported, when "IncludeSecrets" is true.
+type ExportOptions struct {
+	Namespace      string   `json:"namespace"`
+	Kinds          []string `json:"kinds"`
+	LabelSelector  string   `json:"labelSelector"`
+	IncludeSecrets bool     `json:"includeSecrets"`
+}
+
+// Index is the index of an exported archive. "Objects" contains all exported objects with the file they are stored in
+// and "Errors" contains the resources, which could not be listed, e.g. because the user isn't allowed to list them.
+type Index struct {
+	Namespace     string        `json:"namespace"`
+	CreatedAt     time.Time     `json:"createdAt"`
+	LabelSelector string        `json:"labelSelector,omitempty"`
+	Objects       []IndexObject `json:"objects"`
+	Errors        []IndexError  `json:"errors,omitempty"`
+}
+
+// IndexObject is a single exported object.
+type IndexObject struct {
+	File       string `json:"file"`
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Name       string `json:"name"`
+}
+
+// IndexError is a resource, which could not be exported.
+type IndexError struct {
+	Resource string `json:"resource"`
+	Error    string `json:"error"`
+}
+
+// ExportProgress is the progress of an export, which is reported after each resource. "Done" is the number of
+// resources, which were already exported, "Total" the number of all resources and "Objects" the number of exported
+// objects so far.
+type ExportProgress struct {
+	Resource string `json:"resource"`
+	Done     int    `json:"done"`
+	Total    int    `json:"total"`
+	Objects  int    `json:"objects"`
+}
+
+// ExportNamespace writes all objects of the namespace from the options as gzip compressed tar archive to the writer.
+// The listable resources are discovered via the discovery data of the cluster. Each object is stored in the file
+// "<group>/<resource>/<name>.yaml" (the group is "core" for the core API group), without the fields which are managed
+// by the API server and without its status. When a resource can not be listed, the error is recorded in the index and
+// the export is continued. The progress function can be nil.
+func ExportNamespace(ctx context.Context, cache *resources.DiscoveryCache, clusterKey string, clientset kubernetes.Interface, options ExportOptions, w io.Writer, progress func(ExportProgress)) (*Index, error) {
+	if options.Namespace == "" {
+		return nil, fmt.Errorf("%w: namespace is required", ErrInvalidOptions)
+	}
+
+	infos, err := cache.NamespacedResources(clusterKey, clientset)
+	if err != nil {
+		return nil, err
+	}
+	infos = filterResources(infos, options)
+
+	index := &Index{
+		Namespace:     options.Namespace,
+		CreatedAt:     time.Now().UTC(),
+		LabelSelector: options.LabelSelector,
+		Objects:       []IndexObject{},
+	}
+
+	gz := gzip.NewWriter(w)
+	archive := tar.NewWriter(gz)
+
+	for i, info := range infos {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		if err := exportResource(ctx, cache, clusterKey, clientset, info, options, archive, index); err != nil {
+			if errors.Is(err, errArchive) {
+				return nil, err
+			}
+			index.Errors = append(index.Errors, IndexError{Resource: resourceName(info), Error: err.Error()})
+		}
+
+		if progress != nil {
+			progress(ExportProgress{Resource: resourceName(info), Done: i + 1, Total: len(infos), Objects: len(index.Objects)})
+		}
+	}
+
+	data, err := yaml.Marshal(index)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeFile(archive, IndexFile, data, index.CreatedAt); err != nil {
+		return nil, err
+	}
+
+	if err := archive.Close(); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+
+	return index, nil
+}
+
+// errArchive is returned by exportResource, when the archive could not be written. In contrast to the errors of the
+// Kubernetes API, this error aborts the export.
+var errArchive = errors.New("could not write archive")
+
+// exportResource lists all objects of the resource page by page and writes them to the archive.
+func exportResource(ctx context.Context, cache *resources.DiscoveryCache, clusterKey string, clientset kubernetes.Interface, info resources.ResourceInfo, options ExportOptions, archive *tar.Writer, index *Index) error {
+	apiVersion := info.Version
+	if info.Group != "" {
+		apiVersion = info.Group + "/" + info.Version
+	}
+
+	group := info.Group
+	if group == "" {
+		group = "core"
+	}
+
+	continueToken := ""
+	for {
+		list, err := resources.Get(ctx, cache, clusterKey, clientset, resources.ListOptions{
+			Group:         info.Group,
+			Version:       info.Version,
+			Resource:      info.Resource,
+			Namespace:     options.Namespace,
+			LabelSelector: options.LabelSelector,
+			Limit:         exportPageSize,
+			Continue:      continueToken,
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, item := range list.Items {
+			var object map[string]interface{}
+			if err := json.Unmarshal(item, &object); err != nil {
+				return err
+			}
+
+			// The items of a list do not contain the api version and kind, so that we have to set them, before the
+			// object can be applied again.
+			object["apiVersion"] = apiVersion
+			object["kind"] = info.Kind
+			delete(object, "status")
+
+			metadata, _ := object["metadata"].(map[string]interface{})
+			name, _ := metadata["name"].(string)
+			for _, field := range serverManagedFields {
+				delete(metadata, field)
+			}
+
+			data, err := yaml.Marshal(object)
+			if err != nil {
+				return err
+			}
+
+			file := path.Join(group, info.Resource, name+".yaml")
+			if err := writeFile(archive, file, data, index.CreatedAt); err != nil {
+				return err
+			}
+
+			index.Objects = append(index.Objects, IndexObject{File: file, APIVersion: apiVersion, Kind: info.Kind, Name: name})
+		}
+
+		if list.Metadata.Continue == "" {
+			return nil
+		}
+		continueToken = list.Metadata.Continue
+	}
+}
+
+func writeFile(archive *tar.Writer, name string, data []byte, modTime time.Time) error {
+	if err := archive.WriteHeader(&tar.Header{Name: name, Mode: 0600, Size: int64(len(data)), ModTime: modTime, Typeflag: tar.TypeReg}); err != nil {
+		return fmt.Errorf("%w: %s", errArchive, err.Error())
+	}
+	if _, err := archive.Write(data); err != nil {
+		return fmt.Errorf("%w: %s", errArchive, err.Error())
+	}
+	return nil
+}
+
+// filterResources returns the resources, which should be exported for the options, sorted by their group and name.
+func filterResources(infos []resources.ResourceInfo, options ExportOptions) []resources.ResourceInfo {
+	kinds := make(map[string]bool, len(options.Kinds))
+	for _, kind := range options.Kinds {
+		kinds[strings.ToLower(kind)] = true
+	}
+
+	var filtered []resources.ResourceInfo
+	for _, info := range infos {
+		if skippedResources[resourceName(info)] {
+			continue
+		}
+		if info.Group == "" && info.Resource == "secrets" && !options.IncludeSecrets {
+			continue
+		}
+		if len(kinds) > 0 && !kinds[strings.ToLower(info.Kind)] && !kinds[info.Resource] {
+			continue
+		}
+
+		filtered = append(filtered, info)
+	}
+
+	sort.Slice(filtered, func(i, j int) bool {
+		return resourceName(filtered[i]) < resourceName(filtered[j])
+	})
+
+	return filtered
+}
+
+func resourceName(info resources.ResourceInfo) string {
+	return info.Group + "/" + info.Resource
+}
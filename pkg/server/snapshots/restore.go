@@ -0,0 +1,376 @@
+package snapshots
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/kubenav/kubenav/pkg/server/helm"
+	"github.com/kubenav/kubenav/pkg/server/resources"
+	"github.com/kubenav/kubenav/pkg/server/validation"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// The limits for an uploaded archive, so that a large or malicious archive can not use all the memory of the device.
+const (
+	maxArchiveFiles = 10000
+	maxArchiveSize  = 32 * 1024 * 1024
+)
+
+// The actions, which were taken for an object of the archive. Objects are "skipped", when the restore was aborted
+// because of a previous failure and "StopOnError" is true. Objects are "invalid", when they failed the validation
+// against the schema of the cluster, so that they were not sent to the API server.
+const (
+	ActionCreated    = "created"
+	ActionConfigured = "configured"
+	ActionUnchanged  = "unchanged"
+	ActionFailed     = "failed"
+	ActionSkipped    = "skipped"
+	ActionInvalid    = "invalid"
+)
+
+// RestoreOptions are the options to restore a gzip compressed tar archive, which was created via ExportNamespace or
+// contains any other YAML files. When the namespace is set, all namespaced objects are restored into this namespace,
+// instead of the namespace from their metadata. When "DryRun" is true, the objects are only sent to the API server as
+// dry run, so that the result shows which objects would be created or changed. When "StopOnError" is true, the restore
+// is aborted after the first failed object.
+//
+// When "Validate" is true, all objects are validated against the OpenAPI schema of the cluster before they are applied
+// and invalid objects are not applied. The validation errors can be overridden via "IgnoreValidationErrors", then the
+// invalid objects are applied anyway and the validation errors are only returned as part of the result.
+type RestoreOptions struct {
+	Archive                []byte `json:"archive"`
+	Namespace              string `json:"namespace"`
+	DryRun                 bool   `json:"dryRun"`
+	StopOnError            bool   `json:"stopOnError"`
+	Validate               bool   `json:"validate"`
+	IgnoreValidationErrors bool   `json:"ignoreValidationErrors"`
+}
+
+// RestoreResult is the result of a restore, with the result for each object of the archive in the order in which they
+// were applied.
+type RestoreResult struct {
+	DryRun  bool          `json:"dryRun"`
+	Message string        `json:"message"`
+	Objects []RestoreItem `json:"objects"`
+	Failed  int           `json:"failed"`
+}
+
+// RestoreItem is the result for a single object of the archive. The file, api version, kind and name are the same as
+// in the index of an exported archive. If the object could not be applied, the error contains the reason.
+type RestoreItem struct {
+	File             string             `json:"file"`
+	APIVersion       string             `json:"apiVersion"`
+	Kind             string             `json:"kind"`
+	Namespace        string             `json:"namespace,omitempty"`
+	Name             string             `json:"name"`
+	Action           string             `json:"action"`
+	Error            string             `json:"error,omitempty"`
+	ValidationErrors []validation.Error `json:"validationErrors,omitempty"`
+}
+
+// restoreObject is an object of the archive together with the file it was read from.
+type restoreObject struct {
+	file     string
+	resource helm.Resource
+}
+
+// RestoreArchive applies all objects of the archive from the options via server-side apply, so that existing objects
+// are updated instead of returning an error. The objects are applied in the following order: CustomResourceDefinitions,
+// Namespaces, all other objects and at last the webhook configurations, so that a webhook can not reject the objects
+// which are restored together with it. The "index.yaml" file of an exported archive and all files without a ".yaml",
+// ".yml" or ".json" extension are ignored.
+func RestoreArchive(ctx context.Context, cache *resources.DiscoveryCache, schemas *validation.SchemaCache, clusterKey string, clientset kubernetes.Interface, options RestoreOptions) (*RestoreResult, error) {
+	files, err := extract(options.Archive)
+	if err != nil {
+		return nil, err
+	}
+
+	objects, err := readObjects(files)
+	if err != nil {
+		return nil, err
+	}
+	if len(objects) == 0 {
+		return nil, fmt.Errorf("%w: archive doesn't contain any objects", ErrInvalidOptions)
+	}
+
+	var validationErrors map[string][]validation.Error
+	if options.Validate {
+		validationErrors, err = validateFiles(ctx, schemas, clusterKey, clientset, files, objects)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	result := &RestoreResult{DryRun: options.DryRun, Objects: make([]RestoreItem, 0, len(objects))}
+	for _, object := range objects {
+		item := RestoreItem{File: object.file, APIVersion: object.resource.APIVersion, Kind: object.resource.Kind, Namespace: object.resource.Namespace, Name: object.resource.Name}
+		item.ValidationErrors = validationErrors[validationKey(object.file, object.resource.APIVersion, object.resource.Kind, object.resource.Namespace, object.resource.Name)]
+
+		if options.StopOnError && result.Failed > 0 {
+			item.Action = ActionSkipped
+		} else if len(item.ValidationErrors) > 0 && !options.IgnoreValidationErrors {
+			item.Action = ActionInvalid
+			item.Error = fmt.Sprintf("object is invalid: %s", item.ValidationErrors[0].Message)
+			result.Failed++
+		} else {
+			item = apply(ctx, cache, clusterKey, clientset, object.resource, options, item)
+			if item.Action == ActionFailed {
+				result.Failed++
+			}
+		}
+
+		result.Objects = append(result.Objects, item)
+	}
+
+	switch {
+	case result.Failed > 0:
+		result.Message = fmt.Sprintf("Restore failed: %d of %d objects could not be applied.", result.Failed, len(result.Objects))
+	case options.DryRun:
+		result.Message = fmt.Sprintf("Dry run of the restore of %d objects.", len(result.Objects))
+	default:
+		result.Message = fmt.Sprintf("Restore of %d objects was successful.", len(result.Objects))
+	}
+
+	return result, nil
+}
+
+// validateFiles validates all files which contain the objects of the archive against the schema of the cluster. The
+// returned validation errors are grouped by the file and the api version, kind, namespace and name of the object.
+func validateFiles(ctx context.Context, schemas *validation.SchemaCache, clusterKey string, clientset kubernetes.Interface, files map[string][]byte, objects []restoreObject) (map[string][]validation.Error, error) {
+	validationErrors := make(map[string][]validation.Error)
+	validated := make(map[string]bool)
+
+	for _, object := range objects {
+		if validated[object.file] {
+			continue
+		}
+		validated[object.file] = true
+
+		result, err := validation.ValidateManifest(ctx, schemas, clusterKey, clientset, string(files[object.file]))
+		if err != nil {
+			if errors.Is(err, validation.ErrInvalidManifest) {
+				return nil, fmt.Errorf("%w: %s: %s", ErrInvalidOptions, object.file, err.Error())
+			}
+			return nil, err
+		}
+
+		for _, document := range result.Documents {
+			key := validationKey(object.file, document.APIVersion, document.Kind, document.Namespace, document.Name)
+			validationErrors[key] = append(validationErrors[key], document.Errors...)
+		}
+	}
+
+	return validationErrors, nil
+}
+
+func validationKey(file, apiVersion, kind, namespace, name string) string {
+	return strings.Join([]string{file, apiVersion, kind, namespace, name}, "/")
+}
+
+// apply applies a single object via server-side apply. The live object is fetched before, to decide if the object was
+// created, configured or is unchanged.
+func apply(ctx context.Context, cache *resources.DiscoveryCache, clusterKey string, clientset kubernetes.Interface, resource helm.Resource, options RestoreOptions, item RestoreItem) RestoreItem {
+	failed := func(err error) RestoreItem {
+		item.Action = ActionFailed
+		item.Error = err.Error()
+		return item
+	}
+
+	info, err := cache.ResolveKind(clusterKey, clientset, resource.GroupVersionKind())
+	if err != nil {
+		return failed(err)
+	}
+
+	var object map[string]interface{}
+	if err := json.Unmarshal(resource.JSON, &object); err != nil {
+		return failed(err)
+	}
+	delete(object, "status")
+
+	metadata, _ := object["metadata"].(map[string]interface{})
+	for _, field := range serverManagedFields {
+		delete(metadata, field)
+	}
+
+	namespace := ""
+	if info.Namespaced {
+		namespace = resource.Namespace
+		if options.Namespace != "" {
+			namespace = options.Namespace
+		}
+		if namespace == "" {
+			return failed(fmt.Errorf("namespace is required for %s %s", resource.Kind, resource.Name))
+		}
+		metadata["namespace"] = namespace
+	} else {
+		delete(metadata, "namespace")
+	}
+	item.Namespace = namespace
+
+	body, err := json.Marshal(object)
+	if err != nil {
+		return failed(err)
+	}
+
+	restClient := clientset.CoreV1().RESTClient()
+	objectPath := resources.ObjectPath(info, namespace, resource.Name)
+
+	current, err := restClient.Get().AbsPath(objectPath).DoRaw(ctx)
+	if err != nil {
+		if !apierrors.IsNotFound(err) {
+			return failed(err)
+		}
+		current = nil
+	}
+
+	request := restClient.Patch(types.ApplyPatchType).AbsPath(objectPath).Param("fieldManager", resources.FieldManager).Param("force", "true").Body(body)
+	if options.DryRun {
+		request = request.Param("dryRun", "All")
+	}
+
+	applied, err := request.DoRaw(ctx)
+	if err != nil {
+		return failed(err)
+	}
+
+	switch {
+	case current == nil:
+		item.Action = ActionCreated
+	case equalObjects(current, applied):
+		item.Action = ActionUnchanged
+	default:
+		item.Action = ActionConfigured
+	}
+
+	return item
+}
+
+// equalObjects compares the live object before and after the apply. The fields which are changed by the API server
+// for every request, e.g. the timestamps in the managed fields, are ignored.
+func equalObjects(a, b []byte) bool {
+	var objectA, objectB map[string]interface{}
+	if err := json.Unmarshal(a, &objectA); err != nil {
+		return false
+	}
+	if err := json.Unmarshal(b, &objectB); err != nil {
+		return false
+	}
+
+	for _, object := range []map[string]interface{}{objectA, objectB} {
+		if metadata, ok := object["metadata"].(map[string]interface{}); ok {
+			delete(metadata, "managedFields")
+			delete(metadata, "resourceVersion")
+			delete(metadata, "generation")
+		}
+	}
+
+	return reflect.DeepEqual(objectA, objectB)
+}
+
+// readObjects returns all objects of the files in the order in which they must be applied. Each file can contain
+// multiple YAML documents.
+func readObjects(files map[string][]byte) ([]restoreObject, error) {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		switch path.Ext(name) {
+		case ".yaml", ".yml", ".json":
+		default:
+			continue
+		}
+		if path.Clean(name) == IndexFile {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var objects []restoreObject
+	for _, name := range names {
+		manifest, err := helm.SplitManifest(string(files[name]))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %s", ErrInvalidOptions, name, err.Error())
+		}
+
+		for _, resource := range manifest {
+			objects = append(objects, restoreObject{file: name, resource: resource})
+		}
+	}
+
+	sort.SliceStable(objects, func(i, j int) bool {
+		return restoreOrder(objects[i].resource) < restoreOrder(objects[j].resource)
+	})
+
+	return objects, nil
+}
+
+// restoreOrder returns the position of an object in the restore order: CustomResourceDefinitions and Namespaces are
+// applied first, because other objects depend on them, and webhook configurations last.
+func restoreOrder(resource helm.Resource) int {
+	group := resource.GroupVersionKind().Group
+
+	switch {
+	case group == "apiextensions.k8s.io" && resource.Kind == "CustomResourceDefinition":
+		return 0
+	case group == "" && resource.Kind == "Namespace":
+		return 1
+	case group == "admissionregistration.k8s.io" && strings.HasSuffix(resource.Kind, "WebhookConfiguration"):
+		return 3
+	default:
+		return 2
+	}
+}
+
+// extract returns the regular files of a gzip compressed tar archive. All other entries (e.g. symlinks) are ignored.
+func extract(data []byte) (map[string][]byte, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid archive: %s", ErrInvalidOptions, err.Error())
+	}
+	defer gz.Close()
+
+	files := make(map[string][]byte)
+	var size int64
+
+	reader := tar.NewReader(gz)
+	for {
+		header, err := reader.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return files, nil
+			}
+			return nil, fmt.Errorf("%w: invalid archive: %s", ErrInvalidOptions, err.Error())
+		}
+
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		if len(files) >= maxArchiveFiles {
+			return nil, fmt.Errorf("%w: archive contains more than %d files", ErrInvalidOptions, maxArchiveFiles)
+		}
+
+		size += header.Size
+		if size > maxArchiveSize {
+			return nil, fmt.Errorf("%w: archive is larger than %d bytes", ErrInvalidOptions, maxArchiveSize)
+		}
+
+		content, err := io.ReadAll(io.LimitReader(reader, header.Size))
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid archive: %s", ErrInvalidOptions, err.Error())
+		}
+		files[header.Name] = content
+	}
+}
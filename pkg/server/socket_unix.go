@@ -0,0 +1,35 @@
+//go:build !windows
+
+package server
+
+import (
+	"fmt"
+	"net"
+	"os"
+)
+
+// listenUnix creates a listener for the Unix domain socket with the given path. A stale socket from a previous run is
+// removed, but we never remove other files. The permissions of the socket are set to 0600, so that only the current
+// user can connect to the server. The socket file is removed when the listener is closed.
+func listenUnix(path string) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("could not create unix socket: %s already exists and is not a socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("could not remove stale unix socket: %w", err)
+		}
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.Chmod(path, 0600); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("could not set permissions of unix socket: %w", err)
+	}
+
+	return listener, nil
+}
@@ -0,0 +1,14 @@
+//go:build windows
+
+package server
+
+import (
+	"fmt"
+	"net"
+)
+
+// listenUnix returns an error on Windows, because the server doesn't support Unix domain sockets or named pipes on
+// Windows. The server must be started with a TCP address instead.
+func listenUnix(path string) (net.Listener, error) {
+	return nil, fmt.Errorf("unix sockets are not supported on windows, use the address and port options instead")
+}
@@ -0,0 +1,68 @@
+package terminal
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+
+	"github.com/kubenav/kubenav/pkg/server/sessions"
+)
+
+// ErrSessionLimit is returned when a new terminal session should be added, but the maximum number of concurrent
+// sessions is already reached.
+var ErrSessionLimit = errors.New("maximum number of terminal sessions reached")
+
+// Sessions holds all active terminal sessions.
+var Sessions = SessionMap{Store: sessions.New[*Session]()}
+
+// SessionMap stores all terminal sessions. The sessions are stored in a sharded store, the lock is only used to
+// serialize the calls of Add, so that the limits can not be exceeded by concurrent calls.
+type SessionMap struct {
+	*sessions.Store[*Session]
+	addLock sync.Mutex
+}
+
+// Add stores a terminal session in the SessionMap. Before the session is stored we check that the number of sessions
+// doesn't exceed the given limits. The "maxSessions" argument is the maximum number of sessions across all clusters and
+// the "maxSessionsPerCluster" argument is the maximum number of sessions for the cluster of the session. A limit of 0
+// means that there is no limit. If a limit would be exceeded the session is not stored and ErrSessionLimit is
+// returned.
+//
+// The check and the insert are done while holding the add lock, so that concurrent calls can not exceed the limits.
+// Sessions can be deleted concurrently, which only decreases the number of sessions.
+func (sm *SessionMap) Add(session *Session, maxSessions, maxSessionsPerCluster int) error {
+	sm.addLock.Lock()
+	defer sm.addLock.Unlock()
+
+	if maxSessions > 0 && sm.Count() >= maxSessions {
+		return ErrSessionLimit
+	}
+
+	if maxSessionsPerCluster > 0 {
+		var clusterSessions int
+		for _, s := range sm.Snapshot() {
+			if s.Cluster == session.Cluster {
+				clusterSessions++
+			}
+		}
+
+		if clusterSessions >= maxSessionsPerCluster {
+			return ErrSessionLimit
+		}
+	}
+
+	sm.Set(session.ID, session)
+	return nil
+}
+
+// GenSessionID generates a random session ID string, which can be used as ID for a terminal session.
+func GenSessionID() (string, error) {
+	bytes := make([]byte, 16)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	id := make([]byte, hex.EncodedLen(len(bytes)))
+	hex.Encode(id, bytes)
+	return string(id), nil
+}
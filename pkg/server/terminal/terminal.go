@@ -5,18 +5,35 @@
 package terminal
 
 import (
+	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log"
+	"net/http"
 	"net/url"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/remotecommand"
+	"k8s.io/client-go/util/exec"
 )
 
 const END_OF_TRANSMISSION = "\u0004"
 
+// The protocols which can be used to execute a process in a container. When the protocol is empty we try to use the
+// SPDY protocol first and fallback to the WebSocket protocol, when the connection can not be upgraded to SPDY.
+const (
+	ProtocolSPDY      = "spdy"
+	ProtocolWebSocket = "websocket"
+)
+
 // PtyHandler is what remotecommand expects from a pty.
 type PtyHandler interface {
 	io.Reader
@@ -24,6 +41,12 @@ type PtyHandler interface {
 	remotecommand.TerminalSizeQueue
 }
 
+// StderrHandler can be implemented by a PtyHandler to handle the stderr output of a process separately from the stdout
+// output. It is only used for processes without a tty, because in a tty stdout and stderr are merged.
+type StderrHandler interface {
+	Stderr() io.Writer
+}
+
 // Message is the messaging protocol between the shell and terminal session.
 //
 // OP      DIRECTION  FIELD(S) USED  DESCRIPTION
@@ -31,21 +54,99 @@ type PtyHandler interface {
 // stdin   fe->be     Data           Keystrokes/paste buffer
 // resize  fe->be     Rows, Cols     New terminal size
 // stdout  be->fe     Data           Output from the process
+// stderr  be->fe     Data           Error output from the process (only without tty)
+// exit    be->fe     ExitCode, Data Final message when the process ends
+//
+// For processes with a tty the stdout and stderr output is merged and all output is send as "stdout" message. For
+// processes without a tty (one-off commands) the error output is send as "stderr" message. The order of the messages
+// is preserved per stream, but not across the two streams.
+//
+// The "exit" message is always the last message send by the backend, after it the WebSocket connection is closed with
+// a normal close frame. The ExitCode is 0 when the process finished successfully, the exit code of the process when it
+// exited with a non-zero code and -1 when the status of the process is unknown (e.g. when the stream failed). In the
+// last two cases Data contains the error message. The ExitCode field is always set for the "exit" message, also when
+// it is 0, and omitted for all other messages.
+//
+// By default Data contains the raw output of the process, which means that invalid UTF-8 sequences (e.g. when the
+// output is binary) are replaced during the JSON encoding. To transfer binary data without modifications, the frontend
+// can set the "encoding" query parameter to "base64" when it opens the WebSocket connection. Then the Data field of all
+// "stdout" messages is base64 encoded and the Encoding field is set to "base64". The frontend can also send base64
+// encoded "stdin" messages by setting the Encoding field to "base64", this works independently of the query parameter.
 type Message struct {
 	Op, Data   string
 	Rows, Cols uint16
+	ExitCode   *int   `json:",omitempty"`
+	Encoding   string `json:",omitempty"`
 }
 
+// EncodingBase64 is the value for the Encoding field of a Message, when the Data field is base64 encoded.
+const EncodingBase64 = "base64"
+
 // Session implements PtyHandler (using a WebSocket connection).
+//
+// When the InputRecorder is set, it is called for each line of input the user sends to the process. The input is
+// aggregated per line, control characters and escape sequences (e.g. for the arrow keys) are removed.
+//
+// The writeLock is used to serialize all writes to the WebSocket connection, because the stdout and stderr output of a
+// process is written concurrently and the WebSocket connection supports only one concurrent writer.
+//
+// The ID, Cluster, Name, Namespace, Container and Command fields describe the session in the session registry. The
+// Cluster is the cluster server or the context name of the cluster and is used to limit the sessions per cluster. The
+// RequestID is the id of the request, which opened the WebSocket connection for the session. The
+// protocol which is used to execute the process ("spdy" or "websocket") can be retrieved via GetProtocol.
+//
+// The SizeChan must only be set for sessions with a tty, because the terminal size is only read for these sessions. The
+// resize messages of sessions without a SizeChan are ignored.
+//
+// The Encoding defines the encoding for the output of the process and can be empty (raw output) or "base64". The
+// stdin buffer is used to store the data of a stdin message, which doesn't fit into the buffer provided by
+// remotecommand, so that the data is returned in the next call of Read.
 type Session struct {
+	ID        string
+	Cluster   string
+	Name      string
+	Namespace string
+	Container string
+	Command   []string
+	RequestID string
+	StartTime time.Time
 	WebSocket *websocket.Conn
 	SizeChan  chan remotecommand.TerminalSize
 	DoneChan  chan struct{}
+	Encoding  string
+
+	InputRecorder func(line string)
+
+	stdin     []byte
+	input     []byte
+	inEscape  bool
+	writeLock sync.Mutex
+	protocol  string
+	infoLock  sync.RWMutex
+}
+
+// ProtocolHandler can be implemented by a PtyHandler to get the protocol which is used to execute the process.
+type ProtocolHandler interface {
+	SetProtocol(protocol string)
+}
+
+// SetProtocol sets the protocol which is used to execute the process of the session.
+func (t *Session) SetProtocol(protocol string) {
+	t.infoLock.Lock()
+	defer t.infoLock.Unlock()
+	t.protocol = protocol
+}
+
+// GetProtocol returns the protocol which is used to execute the process of the session.
+func (t *Session) GetProtocol() string {
+	t.infoLock.RLock()
+	defer t.infoLock.RUnlock()
+	return t.protocol
 }
 
 // Next is called in a loop from remotecommand as long as the process is running.
 // TerminalSize handles pty->process resize events.
-func (t Session) Next() *remotecommand.TerminalSize {
+func (t *Session) Next() *remotecommand.TerminalSize {
 	select {
 	case size := <-t.SizeChan:
 		return &size
@@ -56,7 +157,13 @@ func (t Session) Next() *remotecommand.TerminalSize {
 
 // Read handles pty->process messages (stdin, resize).
 // Called in a loop from remotecommand as long as the process is running.
-func (t Session) Read(p []byte) (int, error) {
+func (t *Session) Read(p []byte) (int, error) {
+	if len(t.stdin) > 0 {
+		n := copy(p, t.stdin)
+		t.stdin = t.stdin[n:]
+		return n, nil
+	}
+
 	_, m, err := t.WebSocket.ReadMessage()
 	if err != nil {
 		// Send terminated signal to process to avoid resource leak.
@@ -70,52 +177,275 @@ func (t Session) Read(p []byte) (int, error) {
 
 	switch msg.Op {
 	case "stdin":
-		return copy(p, msg.Data), nil
+		data := []byte(msg.Data)
+		if msg.Encoding == EncodingBase64 {
+			data, err = base64.StdEncoding.DecodeString(msg.Data)
+			if err != nil {
+				return copy(p, END_OF_TRANSMISSION), err
+			}
+		}
+
+		t.recordInput(data)
+
+		n := copy(p, data)
+		t.stdin = data[n:]
+		return n, nil
 	case "resize":
-		t.SizeChan <- remotecommand.TerminalSize{Width: msg.Cols, Height: msg.Rows}
+		// The SizeChan is only read for processes with a tty, for all other processes the resize message is dropped,
+		// because the send would block forever.
+		if t.SizeChan == nil {
+			return 0, nil
+		}
+
+		select {
+		case t.SizeChan <- remotecommand.TerminalSize{Width: msg.Cols, Height: msg.Rows}:
+		case <-t.DoneChan:
+		}
 		return 0, nil
 	default:
 		return copy(p, END_OF_TRANSMISSION), fmt.Errorf("unknown message type '%s'", msg.Op)
 	}
 }
 
+// recordInput aggregates the given input per line and calls the InputRecorder for each finished line.
+func (t *Session) recordInput(data []byte) {
+	if t.InputRecorder == nil {
+		return
+	}
+
+	for _, b := range data {
+		switch {
+		case t.inEscape:
+			// Escape sequences are started by ESC, followed by "[" or "O" and end with a byte in the range 0x40-0x7e.
+			if b != '[' && b != 'O' && b >= 0x40 && b <= 0x7e {
+				t.inEscape = false
+			}
+		case b == 0x1b:
+			t.inEscape = true
+		case b == '\r' || b == '\n':
+			if len(t.input) > 0 {
+				t.InputRecorder(string(t.input))
+				t.input = t.input[:0]
+			}
+		case b == 0x7f || b == 0x08:
+			if len(t.input) > 0 {
+				t.input = t.input[:len(t.input)-1]
+			}
+		case b < 0x20:
+		default:
+			t.input = append(t.input, b)
+		}
+	}
+}
+
 // Write handles process->pty stdout.
 // Called from remotecommand whenever there is any output.
-func (t Session) Write(p []byte) (int, error) {
-	msg, err := json.Marshal(Message{
-		Op:   "stdout",
+func (t *Session) Write(p []byte) (int, error) {
+	return t.write("stdout", p)
+}
+
+// Stderr returns a writer, which handles the process->pty stderr output.
+func (t *Session) Stderr() io.Writer {
+	return stderrWriter{session: t}
+}
+
+// write sends the given output of the process as message with the given op to the frontend. If the session uses the
+// base64 encoding the output is base64 encoded.
+func (t *Session) write(op string, p []byte) (int, error) {
+	message := Message{
+		Op:   op,
 		Data: string(p),
-	})
-	if err != nil {
-		return 0, err
+	}
+	if t.Encoding == EncodingBase64 {
+		message.Data = base64.StdEncoding.EncodeToString(p)
+		message.Encoding = EncodingBase64
 	}
 
-	if err = t.WebSocket.WriteMessage(websocket.TextMessage, msg); err != nil {
+	if err := t.WriteMessage(message); err != nil {
 		return 0, err
 	}
 	return len(p), nil
 }
 
-// StartProcess executes the given command (cmd) in the container specified in the request and connects it up with the
-// ptyHandler (a session).
-func StartProcess(config *rest.Config, reqURL *url.URL, cmd []string, ptyHandler PtyHandler) error {
-	exec, err := remotecommand.NewSPDYExecutor(config, "POST", reqURL)
+// WriteMessage sends the given message to the frontend. It can be called concurrently.
+func (t *Session) WriteMessage(message Message) error {
+	msg, err := json.Marshal(message)
 	if err != nil {
 		return err
 	}
 
-	err = exec.Stream(remotecommand.StreamOptions{
-		Stdin:             ptyHandler,
-		Stdout:            ptyHandler,
-		Stderr:            ptyHandler,
-		TerminalSizeQueue: ptyHandler,
-		Tty:               true,
+	t.writeLock.Lock()
+	defer t.writeLock.Unlock()
+
+	return t.WebSocket.WriteMessage(websocket.TextMessage, msg)
+}
+
+// stderrWriter implements the io.Writer interface for the stderr output of a session.
+type stderrWriter struct {
+	session *Session
+}
+
+func (w stderrWriter) Write(p []byte) (int, error) {
+	return w.session.write("stderr", p)
+}
+
+// Exit sends the final "exit" message to the frontend and closes the WebSocket connection with a normal close frame.
+// The exit code and the error message are determined from the error returned by StartProcess.
+func (t *Session) Exit(err error) {
+	exitCode, data := GetExitStatus(err)
+
+	t.WriteMessage(Message{
+		Op:       "exit",
+		Data:     data,
+		ExitCode: &exitCode,
 	})
+
+	t.WebSocket.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(5*time.Second))
+}
+
+// StartProcess executes the command from the request url (reqURL) in the container specified in the request and
+// connects it up with the ptyHandler (a session). When tty is false the command is executed without a terminal, which
+// is used for one-off commands.
+//
+// The protocol can be "spdy" or "websocket" to use the corresponding executor. If the protocol is empty the SPDY
+// executor is used and when the connection can not be upgraded to SPDY (e.g. because of a proxy, which only supports
+// HTTP/2 or WebSockets), we fallback to the WebSocket executor. The used protocol is logged and passed to the
+// ptyHandler, when it implements the ProtocolHandler interface.
+//
+// The process is stopped, when the context is canceled, so the context should be canceled when the session ends.
+//
+// When the process exits with a non-zero exit code the returned error is an "exec.CodeExitError", which can be passed
+// to GetExitStatus to get the exit code of the process.
+func StartProcess(ctx context.Context, config *rest.Config, reqURL *url.URL, tty bool, protocol string, ptyHandler PtyHandler) error {
+	streamOptions := remotecommand.StreamOptions{
+		Stdin:  ptyHandler,
+		Stdout: ptyHandler,
+		Stderr: ptyHandler,
+		Tty:    tty,
+	}
+	if tty {
+		streamOptions.TerminalSizeQueue = ptyHandler
+	} else if stderrHandler, ok := ptyHandler.(StderrHandler); ok {
+		streamOptions.Stderr = stderrHandler.Stderr()
+	}
+
+	return execute(ctx, config, reqURL, protocol, streamOptions, ptyHandler)
+}
+
+// Exec executes the command in the given container without stdin and tty and writes the output of the command to
+// stdout and stderr. It is used to run commands in a container, where we are only interested in the output, e.g. to
+// list the files of a directory. The protocol is handled in the same way as in StartProcess and the command is
+// stopped, when the context is canceled.
+func Exec(ctx context.Context, config *rest.Config, namespace, name, container string, command []string, protocol string, stdout, stderr io.Writer) error {
+	return ExecWithStdin(ctx, config, namespace, name, container, command, protocol, nil, stdout, stderr)
+}
+
+// ExecWithStdin is the same as Exec, but the given reader is streamed to the stdin of the command, e.g. to upload a
+// file into a container. If the reader is nil the command is executed without stdin.
+func ExecWithStdin(ctx context.Context, config *rest.Config, namespace, name, container string, command []string, protocol string, stdin io.Reader, stdout, stderr io.Writer) error {
+	params := url.Values{}
+	params.Set("container", container)
+	for _, c := range command {
+		params.Add("command", c)
+	}
+	if stdin != nil {
+		params.Set("stdin", "true")
+	}
+	params.Set("stdout", "true")
+	params.Set("stderr", "true")
+
+	reqURL, err := url.Parse(fmt.Sprintf("%s/api/v1/namespaces/%s/pods/%s/exec?%s", config.Host, url.PathEscape(namespace), url.PathEscape(name), params.Encode()))
+	if err != nil {
+		return err
+	}
+
+	return execute(ctx, config, reqURL, protocol, remotecommand.StreamOptions{Stdin: stdin, Stdout: stdout, Stderr: stderr}, nil)
+}
+
+// execute streams the process via the given protocol. If the protocol is empty, we try the SPDY protocol first and
+// fallback to the WebSocket protocol, when the connection can not be upgraded.
+func execute(ctx context.Context, config *rest.Config, reqURL *url.URL, protocol string, streamOptions remotecommand.StreamOptions, ptyHandler PtyHandler) error {
+	if protocol == ProtocolWebSocket {
+		return stream(ctx, config, reqURL, ProtocolWebSocket, streamOptions, ptyHandler)
+	}
+
+	err := stream(ctx, config, reqURL, ProtocolSPDY, streamOptions, ptyHandler)
+	if protocol == "" && isUpgradeFailure(err) {
+		log.Printf("Could not upgrade connection to SPDY, fallback to WebSocket: %s", err.Error())
+		return stream(ctx, config, reqURL, ProtocolWebSocket, streamOptions, ptyHandler)
+	}
+
+	return err
+}
+
+// stream creates the executor for the given protocol and streams the process. The ptyHandler is optional and only
+// used to pass the used protocol to the handler.
+func stream(ctx context.Context, config *rest.Config, reqURL *url.URL, protocol string, streamOptions remotecommand.StreamOptions, ptyHandler PtyHandler) error {
+	var executor remotecommand.Executor
+	var err error
+
+	if protocol == ProtocolWebSocket {
+		executor, err = newWebSocketExecutor(config, reqURL)
+	} else {
+		executor, err = remotecommand.NewSPDYExecutor(config, "POST", reqURL)
+	}
 	if err != nil {
 		return err
 	}
 
-	return nil
+	log.Printf("Start process in container via %s: %s", protocol, reqURL.Path)
+	if protocolHandler, ok := ptyHandler.(ProtocolHandler); ok {
+		protocolHandler.SetProtocol(protocol)
+	}
+
+	return executor.StreamWithContext(ctx, streamOptions)
+}
+
+// isUpgradeFailure returns true, when the connection to the API server couldn't be upgraded. In this case no data was
+// transferred yet, so that we can retry the request with another protocol.
+//
+// The SPDY executor returns the status of the API server, when the upgrade was rejected with a status object. Only the
+// codes which are used when the protocol isn't supported are handled as upgrade failure, because e.g. a forbidden
+// request would also fail with the other protocol.
+func isUpgradeFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var upgradeErr *upgradeFailureError
+	if errors.As(err, &upgradeErr) {
+		return true
+	}
+
+	var apiStatus apierrors.APIStatus
+	if errors.As(err, &apiStatus) {
+		switch apiStatus.Status().Code {
+		case http.StatusBadRequest, http.StatusMethodNotAllowed, http.StatusUpgradeRequired:
+			return true
+		default:
+			return false
+		}
+	}
+
+	// The SPDY round tripper of the used client-go version returns all other upgrade failures as plain errors, so
+	// that we have to check the error message as last resort.
+	return strings.Contains(err.Error(), "unable to upgrade connection") || strings.Contains(err.Error(), "unable to upgrade: ")
+}
+
+// GetExitStatus returns the exit code and error message for the error returned by StartProcess. If the error is nil
+// the process finished successfully and the exit code is 0. If the process exited with a non-zero exit code we return
+// this code, for all other errors the exit code is -1, because we do not know how the process ended.
+func GetExitStatus(err error) (int, string) {
+	if err == nil {
+		return 0, ""
+	}
+
+	var exitErr exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.Exited() {
+		return exitErr.ExitStatus(), err.Error()
+	}
+
+	return -1, err.Error()
 }
 
 // IsValidShell checks if the user provided shell is an allowed one.
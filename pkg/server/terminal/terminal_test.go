@@ -0,0 +1,220 @@
+package terminal
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"k8s.io/client-go/tools/remotecommand"
+	"k8s.io/client-go/util/exec"
+)
+
+// newSessionPair returns a session, which is connected via a WebSocket connection to the returned client connection,
+// which acts as the frontend.
+func newSessionPair(t *testing.T) (*Session, *websocket.Conn) {
+	t.Helper()
+
+	serverConns := make(chan *websocket.Conn, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upgrader := websocket.Upgrader{}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		serverConns <- conn
+	}))
+	t.Cleanup(srv.Close)
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("could not dial: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	conn := <-serverConns
+	t.Cleanup(func() { conn.Close() })
+
+	return &Session{
+		WebSocket: conn,
+		SizeChan:  make(chan remotecommand.TerminalSize),
+		DoneChan:  make(chan struct{}),
+	}, client
+}
+
+func readMessage(t *testing.T, conn *websocket.Conn) (Message, map[string]json.RawMessage) {
+	t.Helper()
+
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("could not read message: %v", err)
+	}
+
+	var msg Message
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		t.Fatalf("could not decode message: %v", err)
+	}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		t.Fatalf("could not decode message: %v", err)
+	}
+	return msg, fields
+}
+
+func TestSessionExitCode(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		err      error
+		expected int
+	}{
+		{name: "success", err: nil, expected: 0},
+		{name: "non-zero exit code", err: exec.CodeExitError{Err: errors.New("command terminated with exit code 2"), Code: 2}, expected: 2},
+		{name: "unknown status", err: errors.New("stream failed"), expected: -1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			session, client := newSessionPair(t)
+			session.Exit(tc.err)
+
+			msg, fields := readMessage(t, client)
+			if msg.Op != "exit" {
+				t.Fatalf("expected exit message, got %s", msg.Op)
+			}
+			if _, ok := fields["ExitCode"]; !ok {
+				t.Fatalf("expected ExitCode field in exit message")
+			}
+			if msg.ExitCode == nil || *msg.ExitCode != tc.expected {
+				t.Errorf("expected exit code %d, got %v", tc.expected, msg.ExitCode)
+			}
+		})
+	}
+}
+
+func TestSessionOutputHasNoExitCode(t *testing.T) {
+	session, client := newSessionPair(t)
+	if _, err := session.Write([]byte("hello")); err != nil {
+		t.Fatalf("could not write: %v", err)
+	}
+
+	_, fields := readMessage(t, client)
+	if _, ok := fields["ExitCode"]; ok {
+		t.Errorf("expected no ExitCode field in stdout message")
+	}
+}
+
+func TestSessionBase64RoundTrip(t *testing.T) {
+	// The random data contains invalid UTF-8 sequences, which would be replaced during the JSON encoding without the
+	// base64 encoding.
+	expected := make([]byte, 64*1024)
+	rand.New(rand.NewSource(1)).Read(expected)
+
+	session, client := newSessionPair(t)
+	session.Encoding = EncodingBase64
+
+	go func() {
+		for data := expected; len(data) > 0; {
+			n := 5000
+			if n > len(data) {
+				n = len(data)
+			}
+			msg, _ := json.Marshal(Message{Op: "stdin", Data: base64.StdEncoding.EncodeToString(data[:n]), Encoding: EncodingBase64})
+			if err := client.WriteMessage(websocket.TextMessage, msg); err != nil {
+				return
+			}
+			data = data[n:]
+		}
+	}()
+
+	// The buffer is smaller than the stdin messages, so that the remaining data must be returned in the next calls.
+	var stdin []byte
+	buf := make([]byte, 1024)
+	for len(stdin) < len(expected) {
+		n, err := session.Read(buf)
+		if err != nil {
+			t.Fatalf("could not read stdin: %v", err)
+		}
+		stdin = append(stdin, buf[:n]...)
+	}
+	if !bytes.Equal(stdin, expected) {
+		t.Fatalf("expected stdin to match the sent data")
+	}
+
+	for _, tc := range []struct {
+		op     string
+		writer func(p []byte) (int, error)
+	}{
+		{op: "stdout", writer: session.Write},
+		{op: "stderr", writer: session.Stderr().Write},
+	} {
+		t.Run(tc.op, func(t *testing.T) {
+			go tc.writer(stdin)
+
+			msg, _ := readMessage(t, client)
+			if msg.Op != tc.op || msg.Encoding != EncodingBase64 {
+				t.Fatalf("expected base64 encoded %s message, got %s message with encoding %q", tc.op, msg.Op, msg.Encoding)
+			}
+
+			data, err := base64.StdEncoding.DecodeString(msg.Data)
+			if err != nil {
+				t.Fatalf("could not decode data: %v", err)
+			}
+			if !bytes.Equal(data, expected) {
+				t.Errorf("expected %s to match the sent data", tc.op)
+			}
+		})
+	}
+}
+
+func TestSessionResize(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		tty  bool
+	}{
+		{name: "without tty", tty: false},
+		{name: "with tty after the process ended", tty: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			session, client := newSessionPair(t)
+			if tc.tty {
+				close(session.DoneChan)
+			} else {
+				session.SizeChan = nil
+			}
+
+			// Nothing reads the terminal size, so that the resize message must not block the following stdin message.
+			for _, msg := range []Message{{Op: "resize", Rows: 24, Cols: 80}, {Op: "stdin", Data: "ls\n"}} {
+				data, _ := json.Marshal(msg)
+				if err := client.WriteMessage(websocket.TextMessage, data); err != nil {
+					t.Fatalf("could not write message: %v", err)
+				}
+			}
+
+			stdin := make(chan string, 1)
+			go func() {
+				buf := make([]byte, 1024)
+				for {
+					n, err := session.Read(buf)
+					if err != nil || n > 0 {
+						stdin <- string(buf[:n])
+						return
+					}
+				}
+			}()
+
+			select {
+			case data := <-stdin:
+				if data != "ls\n" {
+					t.Errorf("expected stdin %q, got %q", "ls\n", data)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("resize message blocked the session")
+			}
+		})
+	}
+}
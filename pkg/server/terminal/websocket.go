@@ -0,0 +1,292 @@
+package terminal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"github.com/gorilla/websocket"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	remotecommandconsts "k8s.io/apimachinery/pkg/util/remotecommand"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+	"k8s.io/client-go/util/exec"
+)
+
+// The channels of the "v4.channel.k8s.io" WebSocket protocol. Each message send over the WebSocket connection is
+// prefixed with one byte, which contains the channel of the message.
+const (
+	channelStdin byte = iota
+	channelStdout
+	channelStderr
+	channelError
+	channelResize
+)
+
+// upgradeFailureError is returned by the WebSocket executor, when the API server rejected the upgrade of the
+// connection. It is the same as the "httpstream.UpgradeFailureError" of newer client-go versions, which isn't available
+// in the used version.
+type upgradeFailureError struct {
+	Cause error
+}
+
+func (e *upgradeFailureError) Error() string {
+	return e.Cause.Error()
+}
+
+func (e *upgradeFailureError) Unwrap() error {
+	return e.Cause
+}
+
+// webSocketExecutor implements the remotecommand.Executor interface using the WebSocket protocol of the Kubernetes
+// API server ("v4.channel.k8s.io"). It can be used when the SPDY protocol is blocked, e.g. by a proxy which only
+// supports HTTP/2 or WebSockets.
+type webSocketExecutor struct {
+	config *rest.Config
+	url    *url.URL
+}
+
+// newWebSocketExecutor returns a new executor for the given request url, which uses the WebSocket protocol.
+func newWebSocketExecutor(config *rest.Config, reqURL *url.URL) (remotecommand.Executor, error) {
+	if reqURL.Scheme != "https" && reqURL.Scheme != "http" {
+		return nil, fmt.Errorf("unsupported url scheme %s", reqURL.Scheme)
+	}
+
+	return &webSocketExecutor{
+		config: config,
+		url:    reqURL,
+	}, nil
+}
+
+// Stream calls StreamWithContext with a background context.
+func (e *webSocketExecutor) Stream(options remotecommand.StreamOptions) error {
+	return e.StreamWithContext(context.Background(), options)
+}
+
+// StreamWithContext opens a WebSocket connection to the API server and transfers the streams of the process until the
+// connection is closed by the API server or the context is done.
+func (e *webSocketExecutor) StreamWithContext(ctx context.Context, options remotecommand.StreamOptions) error {
+	conn, err := e.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// The connection is closed when the context is done, so that the read loop below returns. The goroutine also exits
+	// when the stream ends before, so that it doesn't leak when the context is never done.
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	// All writes to the WebSocket connection (stdin and resize messages) are serialized via the write lock, because the
+	// connection supports only one concurrent writer.
+	var writeLock sync.Mutex
+	write := func(channel byte, data []byte) error {
+		writeLock.Lock()
+		defer writeLock.Unlock()
+		return conn.WriteMessage(websocket.BinaryMessage, append([]byte{channel}, data...))
+	}
+
+	if options.Stdin != nil {
+		go func() {
+			buf := make([]byte, 32*1024)
+			for {
+				n, err := options.Stdin.Read(buf)
+				if n > 0 {
+					if err := write(channelStdin, buf[:n]); err != nil {
+						return
+					}
+				}
+				if err != nil {
+					return
+				}
+			}
+		}()
+	}
+
+	if options.Tty && options.TerminalSizeQueue != nil {
+		go func() {
+			for {
+				size := options.TerminalSizeQueue.Next()
+				if size == nil {
+					return
+				}
+
+				data, err := json.Marshal(size)
+				if err != nil {
+					return
+				}
+				if err := write(channelResize, data); err != nil {
+					return
+				}
+			}
+		}()
+	}
+
+	// The messages from the API server are read until the connection is closed. The output is written to the stdout
+	// and stderr writers while the content of the error channel is collected and decoded, when the connection is
+	// closed. The first message of each channel is empty and only used to identify the channel, so we can skip it.
+	var errorData []byte
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			if websocket.IsCloseError(err, websocket.CloseNormalClosure) || errorData != nil {
+				return decodeErrorChannel(errorData)
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+
+		if len(data) < 2 {
+			continue
+		}
+
+		switch data[0] {
+		case channelStdout:
+			if options.Stdout != nil {
+				if _, err := options.Stdout.Write(data[1:]); err != nil {
+					return err
+				}
+			}
+		case channelStderr:
+			if options.Stderr != nil {
+				if _, err := options.Stderr.Write(data[1:]); err != nil {
+					return err
+				}
+			}
+		case channelError:
+			errorData = append(errorData, data[1:]...)
+		}
+	}
+}
+
+// dial creates the WebSocket connection to the API server. The TLS configuration and the authentication headers are
+// created from the rest config, so that all authentication methods supported by client-go can be used.
+func (e *webSocketExecutor) dial(ctx context.Context) (*websocket.Conn, error) {
+	tlsConfig, err := rest.TLSConfigFor(e.config)
+	if err != nil {
+		return nil, err
+	}
+
+	header, err := authHeadersFor(e.config)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := websocket.Dialer{
+		Proxy:           http.ProxyFromEnvironment,
+		TLSClientConfig: tlsConfig,
+		Subprotocols:    []string{remotecommandconsts.StreamProtocolV4Name},
+	}
+	if e.config.Proxy != nil {
+		dialer.Proxy = e.config.Proxy
+	}
+	if transport, ok := e.config.Transport.(*http.Transport); ok && transport.Proxy != nil {
+		dialer.Proxy = transport.Proxy
+	}
+
+	wsURL := *e.url
+	if wsURL.Scheme == "https" {
+		wsURL.Scheme = "wss"
+	} else {
+		wsURL.Scheme = "ws"
+	}
+
+	conn, resp, err := dialer.DialContext(ctx, wsURL.String(), header)
+	if err != nil {
+		if resp != nil {
+			body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+			resp.Body.Close()
+			return nil, &upgradeFailureError{Cause: fmt.Errorf("unable to upgrade connection: %s (%s)", resp.Status, string(body))}
+		}
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+// decodeErrorChannel decodes the content of the error channel, which contains a Kubernetes status object. If the
+// process exited with a non-zero exit code an "exec.CodeExitError" is returned, the same as for the SPDY executor.
+func decodeErrorChannel(data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+
+	var status metav1.Status
+	if err := json.Unmarshal(data, &status); err != nil {
+		return fmt.Errorf("error stream protocol error: %s in %q", err.Error(), string(data))
+	}
+
+	if status.Status == metav1.StatusSuccess {
+		return nil
+	}
+
+	if status.Reason == remotecommandconsts.NonZeroExitCodeReason && status.Details != nil {
+		for _, cause := range status.Details.Causes {
+			if cause.Type == remotecommandconsts.ExitCodeCauseType {
+				var exitCode int
+				if _, err := fmt.Sscanf(cause.Message, "%d", &exitCode); err == nil {
+					return exec.CodeExitError{
+						Err:  fmt.Errorf("command terminated with exit code %d", exitCode),
+						Code: exitCode,
+					}
+				}
+			}
+		}
+	}
+
+	return fmt.Errorf("error executing remote command: %s", status.Message)
+}
+
+// authHeadersFor returns the headers, which are required to authenticate against the API server. To get the headers
+// we are sending a fake request through the round trippers of client-go, which would add the authentication headers
+// (e.g. the bearer token or basic auth credentials) to a real request.
+func authHeadersFor(config *rest.Config) (http.Header, error) {
+	capture := &headerCapture{}
+
+	rt, err := rest.HTTPWrappersForConfig(config, capture)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodGet, config.Host, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	return capture.header, nil
+}
+
+// headerCapture is a http.RoundTripper, which doesn't send the request, but stores the headers of the request.
+type headerCapture struct {
+	header http.Header
+}
+
+func (h *headerCapture) RoundTrip(req *http.Request) (*http.Response, error) {
+	h.header = req.Header.Clone()
+
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       http.NoBody,
+		Request:    req,
+	}, nil
+}
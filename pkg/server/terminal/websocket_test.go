@@ -0,0 +1,366 @@
+package terminal
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	remotecommandconsts "k8s.io/apimachinery/pkg/util/remotecommand"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+	"k8s.io/client-go/util/exec"
+)
+
+// fakeAPIServer emulates the exec endpoint of the API server for the "v4.channel.k8s.io" protocol. All stdin frames
+// are echoed as stdout frames, until the stdin contains "exit". Then "bye" is written to stderr and the status from
+// the exitCode field is written to the error channel. When waitForResize is true, the process only exits after a
+// resize frame was also received. Requests which are not WebSocket upgrades (e.g. SPDY) are rejected, when rejectSPDY
+// is true. The rejection contains a Kubernetes status, when rejectSPDYWithStatus is also true.
+type fakeAPIServer struct {
+	exitCode             int
+	waitForResize        bool
+	rejectSPDY           bool
+	rejectSPDYWithStatus bool
+
+	lock   sync.Mutex
+	frames [][]byte
+}
+
+func (f *fakeAPIServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !websocket.IsWebSocketUpgrade(r) {
+		if f.rejectSPDY && f.rejectSPDYWithStatus {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(apierrors.NewBadRequest("Upgrade request required").Status())
+			return
+		}
+		if f.rejectSPDY {
+			http.Error(w, "upgrade to SPDY is not allowed", http.StatusBadRequest)
+			return
+		}
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	upgrader := websocket.Upgrader{Subprotocols: []string{remotecommandconsts.StreamProtocolV4Name}}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	// The API server sends an empty frame for each channel first, which must be ignored by the client.
+	for _, channel := range []byte{channelStdout, channelStderr, channelError} {
+		conn.WriteMessage(websocket.BinaryMessage, []byte{channel})
+	}
+
+	exited, resized := false, false
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		f.lock.Lock()
+		f.frames = append(f.frames, data)
+		f.lock.Unlock()
+
+		if len(data) == 0 {
+			continue
+		}
+
+		switch data[0] {
+		case channelStdin:
+			conn.WriteMessage(websocket.BinaryMessage, append([]byte{channelStdout}, data[1:]...))
+			exited = exited || bytes.Contains(data[1:], []byte("exit"))
+		case channelResize:
+			resized = true
+		}
+
+		if exited && (resized || !f.waitForResize) {
+			conn.WriteMessage(websocket.BinaryMessage, append([]byte{channelStderr}, []byte("bye")...))
+			conn.WriteMessage(websocket.BinaryMessage, append([]byte{channelError}, f.status()...))
+			conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+			return
+		}
+	}
+}
+
+func (f *fakeAPIServer) status() []byte {
+	status := metav1.Status{Status: metav1.StatusSuccess}
+	if f.exitCode != 0 {
+		status = metav1.Status{
+			Status: metav1.StatusFailure,
+			Reason: remotecommandconsts.NonZeroExitCodeReason,
+			Details: &metav1.StatusDetails{
+				Causes: []metav1.StatusCause{{Type: remotecommandconsts.ExitCodeCauseType, Message: fmt.Sprintf("%d", f.exitCode)}},
+			},
+			Message: fmt.Sprintf("command terminated with non-zero exit code: %d", f.exitCode),
+		}
+	}
+
+	data, _ := json.Marshal(status)
+	return data
+}
+
+func (f *fakeAPIServer) getFrames() [][]byte {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return append([][]byte(nil), f.frames...)
+}
+
+// sizeQueue returns the given sizes and then nil.
+type sizeQueue struct {
+	sizes []remotecommand.TerminalSize
+}
+
+func (q *sizeQueue) Next() *remotecommand.TerminalSize {
+	if len(q.sizes) == 0 {
+		return nil
+	}
+	size := q.sizes[0]
+	q.sizes = q.sizes[1:]
+	return &size
+}
+
+// protocolRecorder is a PtyHandler, which records the protocols passed to SetProtocol.
+type protocolRecorder struct {
+	sizeQueue
+	io.Reader
+	io.Writer
+	protocols []string
+}
+
+func (p *protocolRecorder) SetProtocol(protocol string) {
+	p.protocols = append(p.protocols, protocol)
+}
+
+func newTestServer(t *testing.T, f *fakeAPIServer) (*rest.Config, *url.URL) {
+	t.Helper()
+
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+
+	reqURL, err := url.Parse(srv.URL + "/api/v1/namespaces/default/pods/nginx/exec?command=sh&stdin=true&stdout=true&stderr=true")
+	if err != nil {
+		t.Fatalf("could not parse url: %v", err)
+	}
+
+	return &rest.Config{Host: srv.URL, BearerToken: "token"}, reqURL
+}
+
+func TestWebSocketExecutorFraming(t *testing.T) {
+	f := &fakeAPIServer{exitCode: 3, waitForResize: true}
+	config, reqURL := newTestServer(t, f)
+
+	executor, err := newWebSocketExecutor(config, reqURL)
+	if err != nil {
+		t.Fatalf("could not create executor: %v", err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	err = executor.StreamWithContext(context.Background(), remotecommand.StreamOptions{
+		Stdin:             strings.NewReader("hello exit"),
+		Stdout:            &stdout,
+		Stderr:            &stderr,
+		Tty:               true,
+		TerminalSizeQueue: &sizeQueue{sizes: []remotecommand.TerminalSize{{Width: 80, Height: 24}}},
+	})
+
+	var exitErr exec.CodeExitError
+	if !errors.As(err, &exitErr) || exitErr.Code != 3 {
+		t.Fatalf("expected exit code 3, got %v", err)
+	}
+	if stdout.String() != "hello exit" {
+		t.Errorf("expected stdout %q, got %q", "hello exit", stdout.String())
+	}
+	if stderr.String() != "bye" {
+		t.Errorf("expected stderr %q, got %q", "bye", stderr.String())
+	}
+
+	// The resize message can be send before or after the stdin message, so we only check that both frames are prefixed
+	// with the correct channel.
+	var stdinFrame, resizeFrame []byte
+	for _, frame := range f.getFrames() {
+		switch frame[0] {
+		case channelStdin:
+			stdinFrame = frame
+		case channelResize:
+			resizeFrame = frame
+		default:
+			t.Errorf("unexpected frame on channel %d: %q", frame[0], frame[1:])
+		}
+	}
+
+	if string(stdinFrame[1:]) != "hello exit" {
+		t.Errorf("expected stdin frame %q, got %q", "hello exit", stdinFrame[1:])
+	}
+
+	if resizeFrame == nil {
+		t.Fatalf("expected a resize frame")
+	}
+	var size remotecommand.TerminalSize
+	if err := json.Unmarshal(resizeFrame[1:], &size); err != nil {
+		t.Fatalf("could not decode resize frame: %v", err)
+	}
+	if size.Width != 80 || size.Height != 24 {
+		t.Errorf("expected size 80x24, got %dx%d", size.Width, size.Height)
+	}
+}
+
+func TestWebSocketExecutorSuccess(t *testing.T) {
+	config, reqURL := newTestServer(t, &fakeAPIServer{})
+
+	executor, err := newWebSocketExecutor(config, reqURL)
+	if err != nil {
+		t.Fatalf("could not create executor: %v", err)
+	}
+
+	var stdout bytes.Buffer
+	err = executor.StreamWithContext(context.Background(), remotecommand.StreamOptions{
+		Stdin:  strings.NewReader("exit"),
+		Stdout: &stdout,
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if stdout.String() != "exit" {
+		t.Errorf("expected stdout %q, got %q", "exit", stdout.String())
+	}
+}
+
+func TestWebSocketExecutorNoGoroutineLeak(t *testing.T) {
+	config, reqURL := newTestServer(t, &fakeAPIServer{})
+
+	executor, err := newWebSocketExecutor(config, reqURL)
+	if err != nil {
+		t.Fatalf("could not create executor: %v", err)
+	}
+
+	run := func() {
+		if err := executor.StreamWithContext(context.Background(), remotecommand.StreamOptions{
+			Stdin:  strings.NewReader("exit"),
+			Stdout: io.Discard,
+		}); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+
+	// The first stream warms up the connection pools of the http client and server.
+	run()
+	baseline := waitForGoroutines(0)
+
+	for i := 0; i < 20; i++ {
+		run()
+	}
+
+	if n := waitForGoroutines(baseline); n > baseline {
+		t.Errorf("expected at most %d goroutines after the streams ended, got %d", baseline, n)
+	}
+}
+
+// waitForGoroutines waits up to two seconds until the number of goroutines is at most max and returns the number of
+// goroutines. If max is 0, it only waits a short time to let the goroutines of the previous operations finish.
+func waitForGoroutines(max int) int {
+	deadline := time.Now().Add(2 * time.Second)
+	if max == 0 {
+		deadline = time.Now().Add(100 * time.Millisecond)
+	}
+
+	for {
+		n := runtime.NumGoroutine()
+		if (max > 0 && n <= max) || time.Now().After(deadline) {
+			return n
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestExecuteFallbackToWebSocket(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		f    *fakeAPIServer
+	}{
+		{name: "plain text rejection", f: &fakeAPIServer{rejectSPDY: true}},
+		{name: "status rejection", f: &fakeAPIServer{rejectSPDY: true, rejectSPDYWithStatus: true}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			config, reqURL := newTestServer(t, tc.f)
+
+			handler := &protocolRecorder{}
+			var stdout bytes.Buffer
+			err := execute(context.Background(), config, reqURL, "", remotecommand.StreamOptions{
+				Stdin:  strings.NewReader("exit"),
+				Stdout: &stdout,
+			}, handler)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			if strings.Join(handler.protocols, ",") != "spdy,websocket" {
+				t.Errorf("expected protocols spdy,websocket, got %v", handler.protocols)
+			}
+			if stdout.String() != "exit" {
+				t.Errorf("expected stdout %q, got %q", "exit", stdout.String())
+			}
+		})
+	}
+}
+
+func TestExecuteNoFallbackForExplicitProtocol(t *testing.T) {
+	config, reqURL := newTestServer(t, &fakeAPIServer{rejectSPDY: true})
+
+	handler := &protocolRecorder{}
+	err := execute(context.Background(), config, reqURL, ProtocolSPDY, remotecommand.StreamOptions{
+		Stdin:  strings.NewReader("exit"),
+		Stdout: io.Discard,
+	}, handler)
+	if !isUpgradeFailure(err) {
+		t.Fatalf("expected upgrade failure, got %v", err)
+	}
+
+	if strings.Join(handler.protocols, ",") != "spdy" {
+		t.Errorf("expected protocols spdy, got %v", handler.protocols)
+	}
+}
+
+func TestIsUpgradeFailure(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{name: "nil", err: nil, expected: false},
+		{name: "websocket upgrade", err: &upgradeFailureError{Cause: errors.New("400 Bad Request")}, expected: true},
+		{name: "wrapped websocket upgrade", err: fmt.Errorf("exec failed: %w", &upgradeFailureError{Cause: errors.New("400 Bad Request")}), expected: true},
+		{name: "upgrade required status", err: apierrors.NewBadRequest("Upgrade request required"), expected: true},
+		{name: "wrapped upgrade required status", err: fmt.Errorf("exec failed: %w", apierrors.NewBadRequest("Upgrade request required")), expected: true},
+		{name: "forbidden status", err: apierrors.NewForbidden(schema.GroupResource{Resource: "pods/exec"}, "nginx", errors.New("denied")), expected: false},
+		{name: "forbidden status with upgrade message", err: apierrors.NewForbidden(schema.GroupResource{Resource: "pods/exec"}, "nginx", errors.New("unable to upgrade connection")), expected: false},
+		{name: "spdy upgrade message", err: errors.New("unable to upgrade connection: upgrade to SPDY is not allowed"), expected: true},
+		{name: "spdy handshake message", err: errors.New("unable to upgrade: missing upgrade headers in request"), expected: true},
+		{name: "exit code", err: exec.CodeExitError{Err: errors.New("command terminated with exit code 1"), Code: 1}, expected: false},
+		{name: "connection refused", err: errors.New("dial tcp 127.0.0.1:6443: connect: connection refused"), expected: false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if actual := isUpgradeFailure(tc.err); actual != tc.expected {
+				t.Errorf("expected %t, got %t", tc.expected, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"net"
+	"strings"
+	"time"
+)
+
+// selfSignedValidity is the validity of a generated self-signed certificate.
+const selfSignedValidity = 365 * 24 * time.Hour
+
+// getTLSConfig returns the TLS configuration for the server and the fingerprint of the used certificate. If TLS isn't
+// enabled via the options, the returned configuration is nil.
+func getTLSConfig(options Options) (*tls.Config, string, error) {
+	var certificate tls.Certificate
+	var err error
+
+	if options.TLSCertFile != "" || options.TLSKeyFile != "" {
+		if options.TLSCertFile == "" || options.TLSKeyFile == "" {
+			return nil, "", fmt.Errorf("the TLSCertFile and TLSKeyFile options must be set together")
+		}
+
+		certificate, err = tls.LoadX509KeyPair(options.TLSCertFile, options.TLSKeyFile)
+		if err != nil {
+			return nil, "", fmt.Errorf("could not load tls certificate: %w", err)
+		}
+	} else if options.TLSSelfSigned {
+		certificate, err = generateCertificate(getTLSHosts(options))
+		if err != nil {
+			return nil, "", fmt.Errorf("could not generate tls certificate: %w", err)
+		}
+	} else {
+		return nil, "", nil
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	return config, getFingerprint(certificate.Certificate[0]), nil
+}
+
+// getTLSHosts returns the hosts for a generated certificate. These are always "localhost" and the loopback addresses,
+// the listen address of the server and the hosts from the "TLSHosts" option.
+func getTLSHosts(options Options) []string {
+	hosts := []string{"localhost", "127.0.0.1", "::1"}
+	if options.Address != "" {
+		hosts = append(hosts, options.Address)
+	}
+
+	return append(hosts, options.TLSHosts...)
+}
+
+// generateCertificate generates a self-signed certificate for the given hosts. Hosts which are valid ip addresses are
+// added as ip SANs, all other hosts as DNS SANs.
+func generateCertificate(hosts []string) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serialNumber,
+		Subject:               pkix.Name{Organization: []string{"kubenav"}, CommonName: "kubenav server"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	seen := make(map[string]bool)
+	for _, host := range hosts {
+		if host == "" || seen[host] {
+			continue
+		}
+		seen[host] = true
+
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
+
+// getFingerprint returns the SHA-256 fingerprint of the given DER encoded certificate, in the same format as it is
+// shown by openssl, e.g. "AB:CD:...".
+func getFingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+
+	return strings.Join(parts, ":")
+}
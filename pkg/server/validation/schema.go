@@ -0,0 +1,224 @@
+// Package validation implements the client-side validation of manifests against the OpenAPI schema of a cluster, so
+// that unknown fields, wrong types and missing required fields are reported with their line in the manifest, before
+// the manifest is sent to the Kubernetes API.
+package validation
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
+)
+
+// ErrNotSupported is returned when the cluster doesn't publish the OpenAPI v3 schema, which is available since
+// Kubernetes 1.24.
+var ErrNotSupported = errors.New("the OpenAPI v3 schema is not published by the cluster, Kubernetes 1.24 or later is required")
+
+// refPrefix is the prefix of the references to other schemas in an OpenAPI v3 document.
+const refPrefix = "#/components/schemas/"
+
+// Schema is a single schema of an OpenAPI v3 document. Only the fields which are required for the validation are
+// decoded. "additionalProperties" can be a boolean or a schema, so that it is decoded when it is used.
+type Schema struct {
+	Type                 string             `json:"type"`
+	Format               string             `json:"format"`
+	Properties           map[string]*Schema `json:"properties"`
+	Required             []string           `json:"required"`
+	Items                *Schema            `json:"items"`
+	AdditionalProperties json.RawMessage    `json:"additionalProperties"`
+	Ref                  string             `json:"$ref"`
+	AllOf                []*Schema          `json:"allOf"`
+	OneOf                []*Schema          `json:"oneOf"`
+	PreserveUnknown      bool               `json:"x-kubernetes-preserve-unknown-fields"`
+	IntOrString          bool               `json:"x-kubernetes-int-or-string"`
+	EmbeddedResource     bool               `json:"x-kubernetes-embedded-resource"`
+	GroupVersionKinds    []struct {
+		Group   string `json:"group"`
+		Version string `json:"version"`
+		Kind    string `json:"kind"`
+	} `json:"x-kubernetes-group-version-kind"`
+}
+
+// document is the OpenAPI v3 document of a single group version.
+type document struct {
+	Components struct {
+		Schemas map[string]*Schema `json:"schemas"`
+	} `json:"components"`
+}
+
+// SchemaCache caches the OpenAPI v3 documents of each cluster in memory. The index of the documents is fetched once per
+// cluster, the documents of the group versions are fetched when they are used the first time. Because the urls of the
+// documents contain a hash of their content, a changed document is fetched again, after the index was refreshed.
+type SchemaCache struct {
+	lock     sync.Mutex
+	clusters map[string]*cachedSchemas
+}
+
+type cachedSchemas struct {
+	// paths are the urls of the documents of all group versions, e.g. "apis/apps/v1".
+	paths     map[string]string
+	documents map[string]*document
+}
+
+// NewSchemaCache returns a new and empty schema cache.
+func NewSchemaCache() *SchemaCache {
+	return &SchemaCache{clusters: make(map[string]*cachedSchemas)}
+}
+
+// getSchema returns the schema of the given group, version and kind and the document which contains it, so that the
+// references of the schema can be resolved. If the group version or kind isn't known, the index is fetched again once,
+// because it could be created by a CRD after the index was cached. If the kind doesn't exist, nil is returned without
+// an error.
+func (c *SchemaCache) getSchema(ctx context.Context, clusterKey string, clientset kubernetes.Interface, gvk schema.GroupVersionKind) (*Schema, *document, error) {
+	doc, err := c.getDocument(ctx, clusterKey, clientset, gvk.GroupVersion(), false)
+	if err != nil || doc == nil {
+		return nil, nil, err
+	}
+
+	if s := findKind(doc, gvk); s != nil {
+		return s, doc, nil
+	}
+
+	// The document could be outdated, when the CRD of the kind was changed after the document was cached.
+	doc, err = c.getDocument(ctx, clusterKey, clientset, gvk.GroupVersion(), true)
+	if err != nil || doc == nil {
+		return nil, nil, err
+	}
+
+	return findKind(doc, gvk), doc, nil
+}
+
+// getDocument returns the document of the group version. If the index is not cached or doesn't contain the group
+// version, the index is fetched before. When the group version doesn't exist, nil is returned without an error.
+func (c *SchemaCache) getDocument(ctx context.Context, clusterKey string, clientset kubernetes.Interface, gv schema.GroupVersion, refresh bool) (*document, error) {
+	path := "apis/" + gv.String()
+	if gv.Group == "" {
+		path = "api/" + gv.Version
+	}
+
+	c.lock.Lock()
+	cached, ok := c.clusters[clusterKey]
+	c.lock.Unlock()
+
+	if !ok || refresh || cached.paths[path] == "" {
+		paths, err := fetchPaths(ctx, clientset)
+		if err != nil {
+			return nil, err
+		}
+
+		c.lock.Lock()
+		if !ok {
+			cached = &cachedSchemas{documents: make(map[string]*document)}
+			c.clusters[clusterKey] = cached
+		}
+		cached.paths = paths
+		c.lock.Unlock()
+	}
+
+	c.lock.Lock()
+	documentURL := cached.paths[path]
+	doc, ok := cached.documents[documentURL]
+	c.lock.Unlock()
+
+	if documentURL == "" {
+		return nil, nil
+	}
+	if ok {
+		return doc, nil
+	}
+
+	data, err := clientset.Discovery().RESTClient().Get().RequestURI(documentURL).SetHeader("Accept", "application/json").DoRaw(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	doc = &document{}
+	if err := json.Unmarshal(data, doc); err != nil {
+		return nil, fmt.Errorf("could not decode schema of %s: %w", gv.String(), err)
+	}
+
+	c.lock.Lock()
+	cached.documents[documentURL] = doc
+	c.lock.Unlock()
+
+	return doc, nil
+}
+
+// fetchPaths returns the urls of the documents of all group versions from the OpenAPI v3 index of the cluster.
+func fetchPaths(ctx context.Context, clientset kubernetes.Interface) (map[string]string, error) {
+	data, err := clientset.Discovery().RESTClient().Get().AbsPath("/openapi/v3").SetHeader("Accept", "application/json").DoRaw(ctx)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, ErrNotSupported
+		}
+		return nil, err
+	}
+
+	var index struct {
+		Paths map[string]struct {
+			ServerRelativeURL string `json:"serverRelativeURL"`
+		} `json:"paths"`
+	}
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("could not decode schema index: %w", err)
+	}
+
+	paths := make(map[string]string, len(index.Paths))
+	for path, value := range index.Paths {
+		paths[strings.TrimPrefix(path, "/")] = value.ServerRelativeURL
+	}
+	return paths, nil
+}
+
+// findKind returns the schema of the document, which is marked with the given group, version and kind.
+func findKind(doc *document, gvk schema.GroupVersionKind) *Schema {
+	for _, s := range doc.Components.Schemas {
+		for _, schemaGVK := range s.GroupVersionKinds {
+			if schemaGVK.Group == gvk.Group && schemaGVK.Version == gvk.Version && schemaGVK.Kind == gvk.Kind {
+				return s
+			}
+		}
+	}
+	return nil
+}
+
+// resolve returns the schema, which is referenced by the given schema. The Kubernetes API wraps references with a
+// default or description in "allOf", so that a single "allOf" entry is also resolved.
+func (d *document) resolve(s *Schema) *Schema {
+	for i := 0; s != nil && i < 32; i++ {
+		switch {
+		case s.Ref != "":
+			s = d.Components.Schemas[strings.TrimPrefix(s.Ref, refPrefix)]
+		case len(s.AllOf) == 1 && s.Type == "" && len(s.Properties) == 0:
+			s = s.AllOf[0]
+		default:
+			return s
+		}
+	}
+	return s
+}
+
+// additionalProperties returns the schema for the additional properties of an object and true, when additional
+// properties are allowed. When additional properties are allowed without a schema, the returned schema is nil.
+func (s *Schema) additionalProperties() (*Schema, bool) {
+	if len(s.AdditionalProperties) == 0 {
+		return nil, false
+	}
+
+	var allowed bool
+	if err := json.Unmarshal(s.AdditionalProperties, &allowed); err == nil {
+		return nil, allowed
+	}
+
+	var additional Schema
+	if err := json.Unmarshal(s.AdditionalProperties, &additional); err != nil {
+		return nil, true
+	}
+	return &additional, true
+}
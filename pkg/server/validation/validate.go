@@ -0,0 +1,301 @@
+package validation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
+)
+
+// ErrInvalidManifest is returned when the manifest is not valid YAML, so that it can not be validated at all.
+var ErrInvalidManifest = errors.New("invalid manifest")
+
+// The types of the validation errors.
+const (
+	ErrorUnknownField = "unknownField"
+	ErrorInvalidType  = "invalidType"
+	ErrorRequired     = "required"
+	ErrorUnknownKind  = "unknownKind"
+)
+
+// Error is a single validation error. The line is the line in the manifest (starting at 1) and the path is the JSON
+// path of the field, e.g. "spec.template.spec.containers[0].image".
+type Error struct {
+	Line    int    `json:"line"`
+	Path    string `json:"path"`
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+// Document is the validation result of a single YAML document of the manifest. The line is the first line of the
+// document.
+type Document struct {
+	Line       int     `json:"line"`
+	APIVersion string  `json:"apiVersion"`
+	Kind       string  `json:"kind"`
+	Namespace  string  `json:"namespace,omitempty"`
+	Name       string  `json:"name"`
+	Errors     []Error `json:"errors,omitempty"`
+}
+
+// Result is the validation result of a manifest. "Valid" is only true, when none of the documents contains an error.
+type Result struct {
+	Valid     bool       `json:"valid"`
+	Documents []Document `json:"documents"`
+}
+
+// ValidateManifest validates all YAML documents of the manifest against the OpenAPI v3 schema of the cluster, before
+// anything is sent to the Kubernetes API. It reports unknown fields, values with a wrong type and missing required
+// fields. Custom resources are validated against the structural schema of their CRD, which is published by the API
+// server. Fields with "x-kubernetes-preserve-unknown-fields" are not checked for unknown fields.
+//
+// An error is only returned, when the manifest is not valid YAML or the schema could not be fetched. All problems of
+// the objects are returned as part of the result.
+func ValidateManifest(ctx context.Context, cache *SchemaCache, clusterKey string, clientset kubernetes.Interface, manifest string) (*Result, error) {
+	result := &Result{Valid: true, Documents: []Document{}}
+
+	decoder := yaml.NewDecoder(strings.NewReader(manifest))
+	for {
+		var node yaml.Node
+		if err := decoder.Decode(&node); err != nil {
+			if errors.Is(err, io.EOF) {
+				return result, nil
+			}
+			return nil, fmt.Errorf("%w: %s", ErrInvalidManifest, err.Error())
+		}
+
+		if node.Kind != yaml.DocumentNode || len(node.Content) == 0 {
+			continue
+		}
+		root := resolveAlias(node.Content[0])
+		if root.Kind == yaml.ScalarNode && root.Tag == "!!null" {
+			continue
+		}
+
+		document, err := validateDocument(ctx, cache, clusterKey, clientset, root)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(document.Errors) > 0 {
+			result.Valid = false
+		}
+		result.Documents = append(result.Documents, document)
+	}
+}
+
+// validateDocument validates the root node of a single YAML document. The schema is selected by the "apiVersion" and
+// "kind" fields of the document.
+func validateDocument(ctx context.Context, cache *SchemaCache, clusterKey string, clientset kubernetes.Interface, root *yaml.Node) (Document, error) {
+	document := Document{Line: root.Line}
+
+	if root.Kind != yaml.MappingNode {
+		document.Errors = append(document.Errors, Error{Line: root.Line, Type: ErrorInvalidType, Message: fmt.Sprintf("document must be an object, got %s", nodeType(root))})
+		return document, nil
+	}
+
+	apiVersion := field(root, "apiVersion")
+	kind := field(root, "kind")
+	if metadata := field(root, "metadata"); metadata != nil {
+		document.Namespace = scalar(field(metadata, "namespace"))
+		document.Name = scalar(field(metadata, "name"))
+	}
+	document.APIVersion = scalar(apiVersion)
+	document.Kind = scalar(kind)
+
+	if document.APIVersion == "" || document.Kind == "" {
+		if document.APIVersion == "" {
+			document.Errors = append(document.Errors, Error{Line: root.Line, Path: "apiVersion", Type: ErrorRequired, Message: `missing required field "apiVersion"`})
+		}
+		if document.Kind == "" {
+			document.Errors = append(document.Errors, Error{Line: root.Line, Path: "kind", Type: ErrorRequired, Message: `missing required field "kind"`})
+		}
+		return document, nil
+	}
+
+	gv, err := schema.ParseGroupVersion(document.APIVersion)
+	if err != nil {
+		document.Errors = append(document.Errors, Error{Line: apiVersion.Line, Path: "apiVersion", Type: ErrorInvalidType, Message: err.Error()})
+		return document, nil
+	}
+
+	s, doc, err := cache.getSchema(ctx, clusterKey, clientset, gv.WithKind(document.Kind))
+	if err != nil {
+		return document, err
+	}
+	if s == nil {
+		document.Errors = append(document.Errors, Error{Line: kind.Line, Path: "kind", Type: ErrorUnknownKind, Message: fmt.Sprintf("no schema found for kind %q in %s", document.Kind, document.APIVersion)})
+		return document, nil
+	}
+
+	v := &validator{doc: doc}
+	v.validate(root, s, "")
+	document.Errors = v.errors
+
+	return document, nil
+}
+
+// validator walks a YAML node and its schema and collects all validation errors.
+type validator struct {
+	doc    *document
+	errors []Error
+}
+
+func (v *validator) add(node *yaml.Node, path, errorType, message string) {
+	v.errors = append(v.errors, Error{Line: node.Line, Path: path, Type: errorType, Message: message})
+}
+
+func (v *validator) validate(node *yaml.Node, s *Schema, path string) {
+	node = resolveAlias(node)
+	s = v.doc.resolve(s)
+	if s == nil {
+		return
+	}
+
+	actual := nodeType(node)
+	if actual == "null" {
+		return
+	}
+
+	// Values like IntOrString and Quantity accept multiple types, which are defined via "oneOf" or a format.
+	if s.IntOrString || s.Format == "int-or-string" {
+		if actual != "integer" && actual != "string" {
+			v.add(node, path, ErrorInvalidType, fmt.Sprintf("expected integer or string, got %s", actual))
+		}
+		return
+	}
+	if s.Type == "" && len(s.OneOf) > 0 {
+		var expected []string
+		for _, option := range s.OneOf {
+			if option = v.doc.resolve(option); option != nil {
+				if option.Type == "" || typeMatches(option.Type, actual) {
+					return
+				}
+				expected = append(expected, option.Type)
+			}
+		}
+		v.add(node, path, ErrorInvalidType, fmt.Sprintf("expected %s, got %s", strings.Join(expected, " or "), actual))
+		return
+	}
+
+	if s.Type != "" && !typeMatches(s.Type, actual) {
+		v.add(node, path, ErrorInvalidType, fmt.Sprintf("expected %s, got %s", s.Type, actual))
+		return
+	}
+
+	switch node.Kind {
+	case yaml.MappingNode:
+		v.validateObject(node, s, path)
+	case yaml.SequenceNode:
+		if s.Items != nil {
+			for i, item := range node.Content {
+				v.validate(item, s.Items, path+"["+strconv.Itoa(i)+"]")
+			}
+		}
+	}
+}
+
+// validateObject validates the fields of an object. Unknown fields are only reported, when the schema defines the
+// properties of the object and doesn't allow additional or unknown properties.
+func (v *validator) validateObject(node *yaml.Node, s *Schema, path string) {
+	additional, allowed := s.additionalProperties()
+	seen := make(map[string]bool)
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		if key.Tag == "!!merge" {
+			continue
+		}
+
+		fieldPath := joinPath(path, key.Value)
+		seen[key.Value] = true
+
+		if property, ok := s.Properties[key.Value]; ok {
+			v.validate(value, property, fieldPath)
+			continue
+		}
+		if additional != nil {
+			v.validate(value, additional, fieldPath)
+			continue
+		}
+		if allowed || s.PreserveUnknown || len(s.Properties) == 0 {
+			continue
+		}
+		if s.EmbeddedResource && (key.Value == "apiVersion" || key.Value == "kind" || key.Value == "metadata") {
+			continue
+		}
+
+		v.add(key, fieldPath, ErrorUnknownField, fmt.Sprintf("unknown field %q", key.Value))
+	}
+
+	for _, name := range s.Required {
+		if !seen[name] {
+			v.add(node, joinPath(path, name), ErrorRequired, fmt.Sprintf("missing required field %q", name))
+		}
+	}
+}
+
+// nodeType returns the JSON type of a YAML node. Scalars are typed by their resolved YAML tag, so that e.g. an unquoted
+// "1.10" is a number and not a string, like it is done when the manifest is converted to JSON.
+func nodeType(node *yaml.Node) string {
+	switch node.Kind {
+	case yaml.MappingNode:
+		return "object"
+	case yaml.SequenceNode:
+		return "array"
+	}
+
+	switch node.Tag {
+	case "!!int":
+		return "integer"
+	case "!!float":
+		return "number"
+	case "!!bool":
+		return "boolean"
+	case "!!null":
+		return "null"
+	default:
+		return "string"
+	}
+}
+
+func typeMatches(expected, actual string) bool {
+	return expected == actual || (expected == "number" && actual == "integer")
+}
+
+func resolveAlias(node *yaml.Node) *yaml.Node {
+	for node.Kind == yaml.AliasNode && node.Alias != nil {
+		node = node.Alias
+	}
+	return node
+}
+
+// field returns the value of the field with the given name of a mapping node or nil, when the field doesn't exist.
+func field(node *yaml.Node, name string) *yaml.Node {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == name {
+			return resolveAlias(node.Content[i+1])
+		}
+	}
+	return nil
+}
+
+func scalar(node *yaml.Node) string {
+	if node == nil || node.Kind != yaml.ScalarNode {
+		return ""
+	}
+	return node.Value
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
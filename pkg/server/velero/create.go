@@ -0,0 +1,170 @@
+package velero
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/kubenav/kubenav/pkg/server/resources"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/kubernetes"
+)
+
+// nameTimestampFormat is the format of the timestamp, which is appended to generated names. It is the same format as
+// it is used by the Velero CLI for Backups, which are created from a Schedule.
+const nameTimestampFormat = "20060102150405"
+
+// BackupOptions are the options to create a new Backup. When a Schedule is set, the spec of the Backup is copied from
+// the template of the Schedule and the other fields of the spec are ignored, like it is done by the
+// "velero backup create --from-schedule" command. If no name is set, it is generated from the Schedule name or the
+// "backup" prefix and the current time.
+type BackupOptions struct {
+	Namespace          string   `json:"namespace"`
+	Name               string   `json:"name"`
+	Schedule           string   `json:"schedule"`
+	IncludedNamespaces []string `json:"includedNamespaces"`
+	ExcludedNamespaces []string `json:"excludedNamespaces"`
+	StorageLocation    string   `json:"storageLocation"`
+	TTL                string   `json:"ttl"`
+}
+
+// RestoreOptions are the options to create a new Restore from the Backup with the given name. If no name is set, it
+// is generated from the Backup name and the current time.
+type RestoreOptions struct {
+	Namespace          string   `json:"namespace"`
+	Name               string   `json:"name"`
+	BackupName         string   `json:"backupName"`
+	IncludedNamespaces []string `json:"includedNamespaces"`
+	ExcludedNamespaces []string `json:"excludedNamespaces"`
+}
+
+// CreateBackup creates a new Backup with the given options and returns the created Backup.
+func CreateBackup(ctx context.Context, cache *resources.DiscoveryCache, clusterKey string, clientset kubernetes.Interface, options BackupOptions) (*Backup, error) {
+	if options.Namespace == "" {
+		options.Namespace = DefaultNamespace
+	}
+
+	metadata := map[string]interface{}{"namespace": options.Namespace}
+	var spec interface{}
+
+	if options.Schedule != "" {
+		var schedule scheduleObject
+		if err := get(ctx, cache, clusterKey, clientset, KindSchedule, options.Namespace, options.Schedule, &schedule); err != nil {
+			return nil, err
+		}
+
+		labels := make(map[string]string, len(schedule.Metadata.Labels)+1)
+		for key, value := range schedule.Metadata.Labels {
+			labels[key] = value
+		}
+		labels[scheduleNameLabel] = schedule.Metadata.Name
+		metadata["labels"] = labels
+
+		if schedule.Spec.UseOwnerReferencesInBackup {
+			controller := true
+			metadata["ownerReferences"] = []metav1.OwnerReference{{
+				APIVersion: schedule.APIVersion,
+				Kind:       KindSchedule,
+				Name:       schedule.Metadata.Name,
+				UID:        schedule.Metadata.UID,
+				Controller: &controller,
+			}}
+		}
+
+		spec = schedule.Spec.Template
+		if len(schedule.Spec.Template) == 0 {
+			spec = map[string]interface{}{}
+		}
+
+		if options.Name == "" {
+			options.Name = schedule.Metadata.Name + "-" + time.Now().UTC().Format(nameTimestampFormat)
+		}
+	} else {
+		if options.TTL != "" {
+			if _, err := time.ParseDuration(options.TTL); err != nil {
+				return nil, fmt.Errorf("%w: invalid ttl %s", ErrInvalidOptions, options.TTL)
+			}
+		}
+
+		spec = backupSpec{
+			IncludedNamespaces: options.IncludedNamespaces,
+			ExcludedNamespaces: options.ExcludedNamespaces,
+			StorageLocation:    options.StorageLocation,
+			TTL:                options.TTL,
+		}
+
+		if options.Name == "" {
+			options.Name = "backup-" + time.Now().UTC().Format(nameTimestampFormat)
+		}
+	}
+
+	if err := validateName(options.Name); err != nil {
+		return nil, err
+	}
+	metadata["name"] = options.Name
+
+	var created backupObject
+	if err := create(ctx, cache, clusterKey, clientset, KindBackup, options.Namespace, map[string]interface{}{"metadata": metadata, "spec": spec}, &created); err != nil {
+		return nil, err
+	}
+
+	backup := created.backup()
+	return &backup, nil
+}
+
+// CreateRestore creates a new Restore with the given options and returns the created Restore. The Backup must exist,
+// so that a typo in the name results in an error instead of a failed Restore.
+func CreateRestore(ctx context.Context, cache *resources.DiscoveryCache, clusterKey string, clientset kubernetes.Interface, options RestoreOptions) (*Restore, error) {
+	if options.BackupName == "" {
+		return nil, fmt.Errorf("%w: backupName is required", ErrInvalidOptions)
+	}
+	if options.Namespace == "" {
+		options.Namespace = DefaultNamespace
+	}
+	if options.Name == "" {
+		options.Name = options.BackupName + "-" + time.Now().UTC().Format(nameTimestampFormat)
+	}
+	if err := validateName(options.Name); err != nil {
+		return nil, err
+	}
+
+	var backup backupObject
+	if err := get(ctx, cache, clusterKey, clientset, KindBackup, options.Namespace, options.BackupName, &backup); err != nil {
+		return nil, err
+	}
+
+	spec := map[string]interface{}{"backupName": options.BackupName}
+	if len(options.IncludedNamespaces) > 0 {
+		spec["includedNamespaces"] = options.IncludedNamespaces
+	}
+	if len(options.ExcludedNamespaces) > 0 {
+		spec["excludedNamespaces"] = options.ExcludedNamespaces
+	}
+
+	object := map[string]interface{}{
+		"metadata": map[string]interface{}{"name": options.Name, "namespace": options.Namespace},
+		"spec":     spec,
+	}
+
+	var created restoreObject
+	if err := create(ctx, cache, clusterKey, clientset, KindRestore, options.Namespace, object, &created); err != nil {
+		return nil, err
+	}
+
+	restore := created.restore()
+	return &restore, nil
+}
+
+// validateName validates the name of a new Backup or Restore. The names of Backups and Restores are used as label
+// values by Velero, so that they must be valid label values and not only valid object names.
+func validateName(name string) error {
+	if errs := validation.IsDNS1123Subdomain(name); len(errs) > 0 {
+		return fmt.Errorf("%w: invalid name %s: %s", ErrInvalidOptions, name, errs[0])
+	}
+	if errs := validation.IsValidLabelValue(name); len(errs) > 0 {
+		return fmt.Errorf("%w: invalid name %s: %s", ErrInvalidOptions, name, errs[0])
+	}
+	return nil
+}
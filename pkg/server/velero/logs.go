@@ -0,0 +1,163 @@
+package velero
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/kubenav/kubenav/pkg/server/resources"
+
+	"k8s.io/client-go/kubernetes"
+)
+
+// The targets of a DownloadRequest, which are supported by kubenav.
+const (
+	TargetBackupLog  = "BackupLog"
+	TargetRestoreLog = "RestoreLog"
+)
+
+// downloadRequestTimeout is the maximum time we wait until a DownloadRequest is processed by Velero. This is the same
+// timeout as it is used by the Velero CLI.
+const downloadRequestTimeout = time.Minute
+
+// downloadRequestPollInterval is the interval to check the status of a DownloadRequest.
+const downloadRequestPollInterval = time.Second
+
+// LogsOptions are the options to download the logs of a Backup or Restore. The logs are downloaded via the url, which
+// is returned by Velero for a DownloadRequest. This url points to the object storage of the backup location, which
+// often uses a self-signed certificate, so that the TLS verification can be skipped via "InsecureSkipTLSVerify".
+type LogsOptions struct {
+	Namespace             string
+	Kind                  string
+	Name                  string
+	InsecureSkipTLSVerify bool
+}
+
+type downloadRequestObject struct {
+	Status struct {
+		Phase       string `json:"phase"`
+		DownloadURL string `json:"downloadURL"`
+	} `json:"status"`
+}
+
+// Logs returns the logs of a Backup or Restore. For that a DownloadRequest is created and we wait until Velero has
+// processed it, so that we can download the gzip compressed logs from the returned url. The DownloadRequest is deleted
+// afterwards. The returned reader contains the uncompressed logs and must be closed by the caller.
+func Logs(ctx context.Context, cache *resources.DiscoveryCache, clusterKey string, clientset kubernetes.Interface, options LogsOptions) (io.ReadCloser, error) {
+	var target string
+	switch NormalizeKind(options.Kind) {
+	case KindBackup:
+		target = TargetBackupLog
+	case KindRestore:
+		target = TargetRestoreLog
+	default:
+		return nil, fmt.Errorf("%w: unsupported kind %s", ErrInvalidOptions, options.Kind)
+	}
+	if options.Name == "" {
+		return nil, fmt.Errorf("%w: name is required", ErrInvalidOptions)
+	}
+	if options.Namespace == "" {
+		options.Namespace = DefaultNamespace
+	}
+
+	info, err := resolve(cache, clusterKey, clientset, KindDownloadRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	name := fmt.Sprintf("%s-%s", strings.ToLower(options.Name), time.Now().UTC().Format(nameTimestampFormat))
+
+	object := map[string]interface{}{
+		"metadata": map[string]interface{}{"name": name, "namespace": options.Namespace},
+		"spec": map[string]interface{}{
+			"target": map[string]interface{}{"kind": target, "name": options.Name},
+		},
+	}
+
+	var downloadRequest downloadRequestObject
+	if err := create(ctx, cache, clusterKey, clientset, KindDownloadRequest, options.Namespace, object, &downloadRequest); err != nil {
+		return nil, err
+	}
+
+	// The DownloadRequest is deleted with a new context, so that it is also deleted when the request was canceled. If
+	// the deletion fails, the DownloadRequest is removed by Velero, when it is expired.
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		clientset.CoreV1().RESTClient().Delete().AbsPath(resources.ObjectPath(info, options.Namespace, name)).DoRaw(ctx)
+	}()
+
+	waitCtx, cancel := context.WithTimeout(ctx, downloadRequestTimeout)
+	defer cancel()
+
+	ticker := time.NewTicker(downloadRequestPollInterval)
+	defer ticker.Stop()
+
+	for downloadRequest.Status.Phase != "Processed" || downloadRequest.Status.DownloadURL == "" {
+		select {
+		case <-waitCtx.Done():
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			return nil, fmt.Errorf("download request was not processed by velero within %s", downloadRequestTimeout)
+		case <-ticker.C:
+		}
+
+		if err := get(waitCtx, cache, clusterKey, clientset, KindDownloadRequest, options.Namespace, name, &downloadRequest); err != nil {
+			return nil, err
+		}
+	}
+
+	return download(ctx, downloadRequest.Status.DownloadURL, options.InsecureSkipTLSVerify)
+}
+
+// download downloads the gzip compressed logs from the given url and returns a reader for the uncompressed logs.
+func download(ctx context.Context, downloadURL string, insecureSkipTLSVerify bool) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, downloadURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if insecureSkipTLSVerify {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+
+	resp, err := (&http.Client{Transport: transport}).Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("could not download logs from the backup storage location: %w", err)
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%w: no logs found", ErrNotFound)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("could not download logs from the backup storage location: %s", resp.Status)
+	}
+
+	gz, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		resp.Body.Close()
+		return nil, fmt.Errorf("could not decompress logs: %w", err)
+	}
+
+	return &logsReader{Reader: gz, body: resp.Body}, nil
+}
+
+// logsReader reads the uncompressed logs and closes the gzip reader and the response body.
+type logsReader struct {
+	*gzip.Reader
+	body io.Closer
+}
+
+func (r *logsReader) Close() error {
+	r.Reader.Close()
+	return r.body.Close()
+}
@@ -0,0 +1,381 @@
+// Package velero implements the integration of Velero. The Backups, Restores and Schedules of Velero are custom
+// resources in the "velero.io" group, which are resolved via the discovery data of a cluster, so that clusters without
+// Velero can be detected before any request is made.
+package velero
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/kubenav/kubenav/pkg/server/resources"
+	"github.com/kubenav/kubenav/pkg/server/watch"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
+)
+
+// The kinds of Velero, which are used by kubenav.
+const (
+	KindBackup          = "Backup"
+	KindRestore         = "Restore"
+	KindSchedule        = "Schedule"
+	KindDownloadRequest = "DownloadRequest"
+)
+
+// DefaultNamespace is the namespace, in which Velero is installed by default.
+const DefaultNamespace = "velero"
+
+// group is the group of all custom resources of Velero.
+const group = "velero.io"
+
+// scheduleNameLabel is the label, which contains the name of the Schedule of a Backup.
+const scheduleNameLabel = "velero.io/schedule-name"
+
+// The errors of the velero package. ErrNotInstalled is returned, when the Velero CRDs are not installed in a cluster,
+// ErrInvalidOptions for invalid options and ErrNotFound, when Velero doesn't have logs for a Backup or Restore.
+var (
+	ErrNotInstalled   = errors.New("velero is not installed")
+	ErrInvalidOptions = fmt.Errorf("%w", resources.ErrInvalidOptions)
+	ErrNotFound       = errors.New("not found")
+)
+
+// finishedPhases are the phases of Backups and Restores, after which the phase doesn't change anymore.
+var finishedPhases = map[string]bool{
+	"Completed":        true,
+	"PartiallyFailed":  true,
+	"Failed":           true,
+	"FailedValidation": true,
+}
+
+// Backup is the status of a Velero Backup.
+type Backup struct {
+	Namespace           string   `json:"namespace"`
+	Name                string   `json:"name"`
+	Schedule            string   `json:"schedule,omitempty"`
+	Phase               string   `json:"phase"`
+	Errors              int      `json:"errors"`
+	Warnings            int      `json:"warnings"`
+	FailureReason       string   `json:"failureReason,omitempty"`
+	ValidationErrors    []string `json:"validationErrors,omitempty"`
+	IncludedNamespaces  []string `json:"includedNamespaces,omitempty"`
+	ExcludedNamespaces  []string `json:"excludedNamespaces,omitempty"`
+	StorageLocation     string   `json:"storageLocation,omitempty"`
+	TTL                 string   `json:"ttl,omitempty"`
+	Expiration          string   `json:"expiration,omitempty"`
+	StartTimestamp      string   `json:"startTimestamp,omitempty"`
+	CompletionTimestamp string   `json:"completionTimestamp,omitempty"`
+	CreationTimestamp   string   `json:"creationTimestamp"`
+}
+
+// Restore is the status of a Velero Restore.
+type Restore struct {
+	Namespace           string   `json:"namespace"`
+	Name                string   `json:"name"`
+	BackupName          string   `json:"backupName"`
+	Phase               string   `json:"phase"`
+	Errors              int      `json:"errors"`
+	Warnings            int      `json:"warnings"`
+	FailureReason       string   `json:"failureReason,omitempty"`
+	ValidationErrors    []string `json:"validationErrors,omitempty"`
+	IncludedNamespaces  []string `json:"includedNamespaces,omitempty"`
+	StartTimestamp      string   `json:"startTimestamp,omitempty"`
+	CompletionTimestamp string   `json:"completionTimestamp,omitempty"`
+	CreationTimestamp   string   `json:"creationTimestamp"`
+}
+
+// Schedule is a Velero Schedule, which can be used as template for a new Backup.
+type Schedule struct {
+	Namespace  string `json:"namespace"`
+	Name       string `json:"name"`
+	Schedule   string `json:"schedule"`
+	Paused     bool   `json:"paused"`
+	Phase      string `json:"phase,omitempty"`
+	LastBackup string `json:"lastBackup,omitempty"`
+}
+
+// List contains the Backups, Restores and Schedules of a namespace. The Backups and Restores are sorted by their
+// creation time, the newest first.
+type List struct {
+	Backups   []Backup   `json:"backups"`
+	Restores  []Restore  `json:"restores"`
+	Schedules []Schedule `json:"schedules"`
+}
+
+// backupSpec contains the fields of the spec of a Backup, which are used by kubenav.
+type backupSpec struct {
+	IncludedNamespaces []string `json:"includedNamespaces,omitempty"`
+	ExcludedNamespaces []string `json:"excludedNamespaces,omitempty"`
+	StorageLocation    string   `json:"storageLocation,omitempty"`
+	TTL                string   `json:"ttl,omitempty"`
+}
+
+type backupObject struct {
+	Metadata metav1.ObjectMeta `json:"metadata"`
+	Spec     backupSpec        `json:"spec"`
+	Status   struct {
+		Phase               string   `json:"phase"`
+		Errors              int      `json:"errors"`
+		Warnings            int      `json:"warnings"`
+		FailureReason       string   `json:"failureReason"`
+		ValidationErrors    []string `json:"validationErrors"`
+		Expiration          string   `json:"expiration"`
+		StartTimestamp      string   `json:"startTimestamp"`
+		CompletionTimestamp string   `json:"completionTimestamp"`
+	} `json:"status"`
+}
+
+type restoreObject struct {
+	Metadata metav1.ObjectMeta `json:"metadata"`
+	Spec     struct {
+		BackupName         string   `json:"backupName"`
+		IncludedNamespaces []string `json:"includedNamespaces"`
+	} `json:"spec"`
+	Status struct {
+		Phase               string   `json:"phase"`
+		Errors              int      `json:"errors"`
+		Warnings            int      `json:"warnings"`
+		FailureReason       string   `json:"failureReason"`
+		ValidationErrors    []string `json:"validationErrors"`
+		StartTimestamp      string   `json:"startTimestamp"`
+		CompletionTimestamp string   `json:"completionTimestamp"`
+	} `json:"status"`
+}
+
+type scheduleObject struct {
+	APIVersion string            `json:"apiVersion"`
+	Metadata   metav1.ObjectMeta `json:"metadata"`
+	Spec       struct {
+		Schedule                   string          `json:"schedule"`
+		Paused                     bool            `json:"paused"`
+		UseOwnerReferencesInBackup bool            `json:"useOwnerReferencesInBackup"`
+		Template                   json.RawMessage `json:"template"`
+	} `json:"spec"`
+	Status struct {
+		Phase      string `json:"phase"`
+		LastBackup string `json:"lastBackup"`
+	} `json:"status"`
+}
+
+func (o *backupObject) backup() Backup {
+	return Backup{
+		Namespace:           o.Metadata.Namespace,
+		Name:                o.Metadata.Name,
+		Schedule:            o.Metadata.Labels[scheduleNameLabel],
+		Phase:               o.Status.Phase,
+		Errors:              o.Status.Errors,
+		Warnings:            o.Status.Warnings,
+		FailureReason:       o.Status.FailureReason,
+		ValidationErrors:    o.Status.ValidationErrors,
+		IncludedNamespaces:  o.Spec.IncludedNamespaces,
+		ExcludedNamespaces:  o.Spec.ExcludedNamespaces,
+		StorageLocation:     o.Spec.StorageLocation,
+		TTL:                 o.Spec.TTL,
+		Expiration:          o.Status.Expiration,
+		StartTimestamp:      o.Status.StartTimestamp,
+		CompletionTimestamp: o.Status.CompletionTimestamp,
+		CreationTimestamp:   o.Metadata.CreationTimestamp.UTC().Format(metav1.RFC3339Micro),
+	}
+}
+
+func (o *restoreObject) restore() Restore {
+	return Restore{
+		Namespace:           o.Metadata.Namespace,
+		Name:                o.Metadata.Name,
+		BackupName:          o.Spec.BackupName,
+		Phase:               o.Status.Phase,
+		Errors:              o.Status.Errors,
+		Warnings:            o.Status.Warnings,
+		FailureReason:       o.Status.FailureReason,
+		ValidationErrors:    o.Status.ValidationErrors,
+		IncludedNamespaces:  o.Spec.IncludedNamespaces,
+		StartTimestamp:      o.Status.StartTimestamp,
+		CompletionTimestamp: o.Status.CompletionTimestamp,
+		CreationTimestamp:   o.Metadata.CreationTimestamp.UTC().Format(metav1.RFC3339Micro),
+	}
+}
+
+// resolve resolves the resource of the given Velero kind via the discovery data of the cluster. If the CRD isn't
+// installed, ErrNotInstalled is returned.
+func resolve(cache *resources.DiscoveryCache, clusterKey string, clientset kubernetes.Interface, kind string) (resources.ResourceInfo, error) {
+	info, err := cache.ResolveKind(clusterKey, clientset, schema.GroupVersionKind{Group: group, Kind: kind})
+	if err != nil {
+		if meta.IsNoMatchError(err) {
+			return info, fmt.Errorf("%w: the CRD for %s is not installed", ErrNotInstalled, kind)
+		}
+		return info, err
+	}
+
+	return info, nil
+}
+
+// GetList returns the Backups, Restores and Schedules of the given namespace.
+func GetList(ctx context.Context, cache *resources.DiscoveryCache, clusterKey string, clientset kubernetes.Interface, namespace string) (*List, error) {
+	if namespace == "" {
+		namespace = DefaultNamespace
+	}
+
+	var backups struct {
+		Items []backupObject `json:"items"`
+	}
+	if err := list(ctx, cache, clusterKey, clientset, KindBackup, namespace, &backups); err != nil {
+		return nil, err
+	}
+
+	var restores struct {
+		Items []restoreObject `json:"items"`
+	}
+	if err := list(ctx, cache, clusterKey, clientset, KindRestore, namespace, &restores); err != nil {
+		return nil, err
+	}
+
+	var schedules struct {
+		Items []scheduleObject `json:"items"`
+	}
+	if err := list(ctx, cache, clusterKey, clientset, KindSchedule, namespace, &schedules); err != nil {
+		return nil, err
+	}
+
+	result := &List{
+		Backups:   make([]Backup, 0, len(backups.Items)),
+		Restores:  make([]Restore, 0, len(restores.Items)),
+		Schedules: make([]Schedule, 0, len(schedules.Items)),
+	}
+
+	sort.SliceStable(backups.Items, func(i, j int) bool {
+		return backups.Items[j].Metadata.CreationTimestamp.Before(&backups.Items[i].Metadata.CreationTimestamp)
+	})
+	for i := range backups.Items {
+		result.Backups = append(result.Backups, backups.Items[i].backup())
+	}
+
+	sort.SliceStable(restores.Items, func(i, j int) bool {
+		return restores.Items[j].Metadata.CreationTimestamp.Before(&restores.Items[i].Metadata.CreationTimestamp)
+	})
+	for i := range restores.Items {
+		result.Restores = append(result.Restores, restores.Items[i].restore())
+	}
+
+	for _, item := range schedules.Items {
+		result.Schedules = append(result.Schedules, Schedule{
+			Namespace:  item.Metadata.Namespace,
+			Name:       item.Metadata.Name,
+			Schedule:   item.Spec.Schedule,
+			Paused:     item.Spec.Paused,
+			Phase:      item.Status.Phase,
+			LastBackup: item.Status.LastBackup,
+		})
+	}
+
+	return result, nil
+}
+
+// WatchURL returns the url to watch a single Backup or Restore via the watch package. The object is selected via a
+// field selector, so that the watch also works, when the object doesn't exist yet.
+func WatchURL(cache *resources.DiscoveryCache, clusterKey string, clientset kubernetes.Interface, kind, namespace, name string) (string, error) {
+	if kind != KindBackup && kind != KindRestore {
+		return "", fmt.Errorf("%w: unsupported kind %s", ErrInvalidOptions, kind)
+	}
+	if name == "" {
+		return "", fmt.Errorf("%w: name is required", ErrInvalidOptions)
+	}
+	if namespace == "" {
+		namespace = DefaultNamespace
+	}
+
+	info, err := resolve(cache, clusterKey, clientset, kind)
+	if err != nil {
+		return "", err
+	}
+
+	query := url.Values{}
+	query.Set("fieldSelector", "metadata.name="+name)
+	return resources.Path(info, namespace) + "?" + query.Encode(), nil
+}
+
+// IsFinished returns true, when the event of a watch contains a Backup or Restore, whose phase doesn't change anymore.
+func IsFinished(event watch.Event) bool {
+	objects := append([]json.RawMessage{event.Object}, event.Items...)
+	for _, object := range objects {
+		var obj struct {
+			Status struct {
+				Phase string `json:"phase"`
+			} `json:"status"`
+		}
+		if len(object) == 0 || json.Unmarshal(object, &obj) != nil {
+			continue
+		}
+		if finishedPhases[obj.Status.Phase] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// NormalizeKind returns the Velero kind for the given kind, which can be written in lower case (e.g. "backup").
+func NormalizeKind(kind string) string {
+	for _, k := range []string{KindBackup, KindRestore, KindSchedule} {
+		if strings.EqualFold(kind, k) {
+			return k
+		}
+	}
+	return kind
+}
+
+func list(ctx context.Context, cache *resources.DiscoveryCache, clusterKey string, clientset kubernetes.Interface, kind, namespace string, v any) error {
+	info, err := resolve(cache, clusterKey, clientset, kind)
+	if err != nil {
+		return err
+	}
+
+	data, err := clientset.CoreV1().RESTClient().Get().AbsPath(resources.Path(info, namespace)).DoRaw(ctx)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, v)
+}
+
+func get(ctx context.Context, cache *resources.DiscoveryCache, clusterKey string, clientset kubernetes.Interface, kind, namespace, name string, v any) error {
+	info, err := resolve(cache, clusterKey, clientset, kind)
+	if err != nil {
+		return err
+	}
+
+	data, err := clientset.CoreV1().RESTClient().Get().AbsPath(resources.ObjectPath(info, namespace, name)).DoRaw(ctx)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, v)
+}
+
+// create creates the given object of the kind and decodes the created object into v.
+func create(ctx context.Context, cache *resources.DiscoveryCache, clusterKey string, clientset kubernetes.Interface, kind, namespace string, object map[string]interface{}, v any) error {
+	info, err := resolve(cache, clusterKey, clientset, kind)
+	if err != nil {
+		return err
+	}
+
+	object["apiVersion"] = schema.GroupVersion{Group: info.Group, Version: info.Version}.String()
+	object["kind"] = kind
+
+	body, err := json.Marshal(object)
+	if err != nil {
+		return err
+	}
+
+	data, err := clientset.CoreV1().RESTClient().Post().AbsPath(resources.Path(info, namespace)).Param("fieldManager", resources.FieldManager).Body(body).DoRaw(ctx)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,356 @@
+// Package wait implements waiting for a condition of a Kubernetes object, similar to "kubectl wait". The object is
+// watched until one of the requested conditions holds or until it is deleted, so that the frontend doesn't have to
+// poll the Kubernetes API.
+package wait
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kubenav/kubenav/pkg/server/watch"
+
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/rest"
+)
+
+// DefaultTimeout is the default duration, after which we stop to wait for the condition.
+const DefaultTimeout = 5 * time.Minute
+
+// Message is a single message of the wait stream.
+//
+// OP        FIELD(S) USED              DESCRIPTION
+// ---------------------------------------------------------------------
+// progress  Message                    The condition doesn't hold yet, the message describes the current state
+// status    Message                    The connection was lost ("reconnecting") or is restored ("resynced")
+// met       Message, For, Object       The condition "For" holds, the object is the last version of the object
+// timeout   Message                    The condition didn't hold within the timeout
+// error     Message                    The object could not be watched or was deleted while waiting for a condition
+//
+// All operations except "progress" and "status" are final, after them the stream is closed. When the condition was
+// "delete", the object of the "met" message is the deleted object or empty, when the object didn't exist.
+type Message struct {
+	Op      string          `json:"op"`
+	Name    string          `json:"name"`
+	Message string          `json:"message"`
+	For     string          `json:"for,omitempty"`
+	Object  json.RawMessage `json:"object,omitempty"`
+}
+
+// The operations of a Message.
+const (
+	OpProgress = "progress"
+	OpStatus   = "status"
+	OpMet      = "met"
+	OpTimeout  = "timeout"
+	OpError    = "error"
+)
+
+// ForDelete is the condition to wait until the object is deleted.
+const ForDelete = "delete"
+
+// Condition is a condition to wait for. When Delete is true, we wait until the object is deleted, otherwise until
+// the status of the condition with the given type matches.
+type Condition struct {
+	Delete bool
+	Type   string
+	Status string
+}
+
+// String returns the condition in the same format as it is passed to ParseCondition.
+func (c Condition) String() string {
+	if c.Delete {
+		return ForDelete
+	}
+	return fmt.Sprintf("condition=%s=%s", c.Type, c.Status)
+}
+
+// ParseCondition parses a condition in the same format as the "--for" flag of "kubectl wait", e.g. "delete",
+// "condition=Ready" or "condition=Available=False". When the status is omitted, it defaults to "True".
+func ParseCondition(value string) (Condition, error) {
+	if value == ForDelete {
+		return Condition{Delete: true}, nil
+	}
+
+	condition := strings.TrimPrefix(value, "condition=")
+	if condition == value || condition == "" {
+		return Condition{}, fmt.Errorf("invalid condition %s", value)
+	}
+
+	conditionType, status, hasStatus := strings.Cut(condition, "=")
+	if conditionType == "" || (hasStatus && status == "") {
+		return Condition{}, fmt.Errorf("invalid condition %s", value)
+	}
+	if !hasStatus {
+		status = "True"
+	}
+
+	return Condition{Type: conditionType, Status: status}, nil
+}
+
+// Options are the options to wait for an object. The object is specified via the path of the Kubernetes API, e.g.
+// "/api/v1/namespaces/default/pods/nginx". The wait ends as soon as one of the conditions holds.
+type Options struct {
+	URL        string
+	Conditions []Condition
+	Timeout    time.Duration
+}
+
+// OptionsFromQuery returns the options from the "url", "for" and "timeout" query parameters. The "for" parameter can
+// be set multiple times, e.g. to wait until a Job is "condition=Complete" or "condition=Failed". The timeout must be
+// provided in seconds.
+func OptionsFromQuery(query url.Values) (Options, error) {
+	options := Options{
+		URL:     query.Get("url"),
+		Timeout: DefaultTimeout,
+	}
+
+	if err := watch.ValidateURL(options.URL); err != nil {
+		return options, err
+	}
+	if _, _, err := splitURL(options.URL); err != nil {
+		return options, err
+	}
+
+	if len(query["for"]) == 0 {
+		return options, fmt.Errorf("for is required")
+	}
+	for _, value := range query["for"] {
+		condition, err := ParseCondition(value)
+		if err != nil {
+			return options, err
+		}
+		options.Conditions = append(options.Conditions, condition)
+	}
+
+	if timeout := query.Get("timeout"); timeout != "" {
+		parsedTimeout, err := strconv.ParseInt(timeout, 10, 64)
+		if err != nil || parsedTimeout <= 0 {
+			return options, fmt.Errorf("invalid timeout %s", timeout)
+		}
+		options.Timeout = time.Duration(parsedTimeout) * time.Second
+	}
+
+	return options, nil
+}
+
+// splitURL splits the url of an object into the url of the collection and the name of the object, so that the
+// collection can be watched with a field selector for the name.
+func splitURL(objectURL string) (string, string, error) {
+	u, err := url.Parse(objectURL)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid url: %s", err.Error())
+	}
+
+	// The path of an object must contain at least the version, resource and name, e.g. "/api/v1/nodes/node1" or
+	// "/apis/apps/v1/namespaces/default/deployments/nginx".
+	objectPath := strings.TrimSuffix(u.Path, "/")
+	minSegments := 4
+	if strings.HasPrefix(objectPath, "/apis/") {
+		minSegments = 5
+	}
+	if strings.Count(objectPath, "/") < minSegments {
+		return "", "", fmt.Errorf("invalid url: must be the path of a single object")
+	}
+
+	collection, name := path.Split(objectPath)
+	collection = strings.TrimSuffix(collection, "/")
+
+	return collection, name, nil
+}
+
+// object contains only the fields of an object, which are required to check the conditions.
+type object struct {
+	Metadata struct {
+		Generation int64 `json:"generation"`
+	} `json:"metadata"`
+	Status struct {
+		ObservedGeneration int64 `json:"observedGeneration"`
+		Conditions         []struct {
+			Type               string `json:"type"`
+			Status             string `json:"status"`
+			Reason             string `json:"reason"`
+			Message            string `json:"message"`
+			ObservedGeneration int64  `json:"observedGeneration"`
+		} `json:"conditions"`
+	} `json:"status"`
+}
+
+// errFinished is returned by the send function of the watch, to stop the watch when a condition holds.
+var errFinished = errors.New("wait finished")
+
+// Stream watches the object from the options and sends a "progress" message each time the state of the conditions
+// changes. When a condition holds, the object is deleted or the timeout elapses, a final message is send and the
+// function returns. The watch is stopped, when the context is canceled. When the connection to the Kubernetes API is
+// lost, the watch is reconnected and the "reconnecting" and "resynced" statuses of the watch are send as "status"
+// messages.
+//
+// An object which doesn't exist yet is not an error, we wait until it is created. Only if the object is deleted while
+// we wait for a condition other than "delete" the function fails.
+func Stream(ctx context.Context, client rest.Interface, options Options, send func(Message) error) error {
+	timeout := options.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+
+	collection, name, err := splitURL(options.URL)
+	if err != nil {
+		return err
+	}
+
+	watchCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	query := url.Values{}
+	query.Set("fieldSelector", fields.OneTermEqualSelector("metadata.name", name).String())
+	watchURL := fmt.Sprintf("%s?%s", collection, query.Encode())
+
+	newMessage := func(op, message string) Message {
+		return Message{Op: op, Name: name, Message: message}
+	}
+
+	met := func(condition Condition, message string, object json.RawMessage) error {
+		msg := newMessage(OpMet, message)
+		msg.For = condition.String()
+		msg.Object = object
+		if err := send(msg); err != nil {
+			return err
+		}
+		return errFinished
+	}
+
+	lastMessage := ""
+	progress := func(message string) error {
+		if message == lastMessage {
+			return nil
+		}
+		lastMessage = message
+		return send(newMessage(OpProgress, message))
+	}
+
+	err = watch.Watch(watchCtx, client, watch.Options{URL: watchURL}, func(event watch.Event) error {
+		var data json.RawMessage
+
+		switch event.Type {
+		case watch.EventList, watch.EventResync:
+			if len(event.Items) == 0 {
+				if condition, ok := deleteCondition(options.Conditions); ok {
+					return met(condition, fmt.Sprintf("%s doesn't exist", name), nil)
+				}
+				return progress(fmt.Sprintf("Waiting for %s to be created...", name))
+			}
+			data = event.Items[0]
+		case watch.EventAdded, watch.EventModified:
+			data = event.Object
+		case watch.EventDeleted:
+			if condition, ok := deleteCondition(options.Conditions); ok {
+				return met(condition, fmt.Sprintf("%s was deleted", name), event.Object)
+			}
+			return fmt.Errorf("%s was deleted", name)
+		case watch.EventStatus:
+			return send(newMessage(OpStatus, event.Data))
+		default:
+			return nil
+		}
+
+		condition, message, ok, err := check(options.Conditions, data)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return met(condition, message, data)
+		}
+
+		return progress(message)
+	})
+
+	if errors.Is(err, errFinished) {
+		return nil
+	}
+
+	// When the parent context is still active, the watch was stopped because of our timeout, so that we send a final
+	// "timeout" message. If the parent context was canceled, the client closed the connection.
+	if ctx.Err() == nil && watchCtx.Err() != nil {
+		return send(newMessage(OpTimeout, fmt.Sprintf("timed out waiting for %s after %s", name, timeout)))
+	}
+
+	return err
+}
+
+// deleteCondition returns the "delete" condition, when it is one of the given conditions.
+func deleteCondition(conditions []Condition) (Condition, bool) {
+	for _, condition := range conditions {
+		if condition.Delete {
+			return condition, true
+		}
+	}
+	return Condition{}, false
+}
+
+// check checks if one of the conditions holds for the object and returns the first condition which holds. The
+// returned message describes the current state of all conditions, it is used for the "progress" messages. Like
+// kubectl we ignore conditions, which were not observed for the current generation of the object, so that we do not
+// return a stale condition directly after the object was changed.
+func check(conditions []Condition, data json.RawMessage) (Condition, string, bool, error) {
+	var obj object
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return Condition{}, "", false, err
+	}
+
+	if obj.Status.ObservedGeneration > 0 && obj.Metadata.Generation > obj.Status.ObservedGeneration {
+		return Condition{}, "Waiting for spec update to be observed...", false, nil
+	}
+
+	var states []string
+	for _, condition := range conditions {
+		if condition.Delete {
+			states = append(states, "waiting for deletion")
+			continue
+		}
+
+		state := fmt.Sprintf("%s=Unknown", condition.Type)
+		for _, c := range obj.Status.Conditions {
+			if !strings.EqualFold(c.Type, condition.Type) {
+				continue
+			}
+			if c.ObservedGeneration > 0 && obj.Metadata.Generation > c.ObservedGeneration {
+				break
+			}
+
+			if strings.EqualFold(c.Status, condition.Status) {
+				return condition, fmt.Sprintf("condition %s is %s", c.Type, c.Status), true, nil
+			}
+
+			state = fmt.Sprintf("%s=%s", c.Type, c.Status)
+			if c.Reason != "" {
+				state = fmt.Sprintf("%s (%s)", state, c.Reason)
+			}
+			if c.Message != "" {
+				state = fmt.Sprintf("%s: %s", state, c.Message)
+			}
+			break
+		}
+		states = append(states, state)
+	}
+
+	return Condition{}, fmt.Sprintf("Waiting for %s: %s", conditionsString(conditions), strings.Join(states, ", ")), false, nil
+}
+
+// conditionsString returns the conditions as human readable string, e.g. "Complete=True or Failed=True".
+func conditionsString(conditions []Condition) string {
+	var values []string
+	for _, condition := range conditions {
+		if condition.Delete {
+			values = append(values, ForDelete)
+		} else {
+			values = append(values, fmt.Sprintf("%s=%s", condition.Type, condition.Status))
+		}
+	}
+	return strings.Join(values, " or ")
+}
@@ -0,0 +1,290 @@
+package watch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	eventsv1 "k8s.io/api/events/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/rest"
+)
+
+// ObjectEvent is the normalized format of a Kubernetes event for a single object. The events from the "v1" and
+// "events.k8s.io/v1" API are converted to this format, so that the client doesn't have to handle both formats.
+type ObjectEvent struct {
+	UID                string `json:"uid"`
+	Name               string `json:"name"`
+	Type               string `json:"type"`
+	Reason             string `json:"reason"`
+	Message            string `json:"message"`
+	Count              int32  `json:"count"`
+	FirstTimestamp     string `json:"firstTimestamp,omitempty"`
+	LastTimestamp      string `json:"lastTimestamp,omitempty"`
+	Source             string `json:"source,omitempty"`
+	ResourceVersion    string `json:"resourceVersion,omitempty"`
+	lastTimestampValue int64
+	object             corev1.ObjectReference
+}
+
+// EventsOptions are the options to watch the events of a single object. The object is identified by the namespace,
+// name and uid. The uid is optional, but it should be set, so that we do not return the events of a previous object
+// with the same name.
+type EventsOptions struct {
+	Namespace       string
+	Name            string
+	UID             string
+	ResourceVersion string
+}
+
+// EventsOptionsFromQuery returns the options to watch the events of an object from the "namespace", "name", "uid" and
+// "resourceVersion" query parameters.
+func EventsOptionsFromQuery(query url.Values) (EventsOptions, error) {
+	options := EventsOptions{
+		Namespace:       query.Get("namespace"),
+		Name:            query.Get("name"),
+		UID:             query.Get("uid"),
+		ResourceVersion: query.Get("resourceVersion"),
+	}
+
+	if options.Name == "" {
+		return options, fmt.Errorf("name is required")
+	}
+
+	return options, nil
+}
+
+// WatchEvents watches the events for a single object. If the cluster supports the "events.k8s.io/v1" API it is used,
+// otherwise we fall back to the "v1" API. The events are converted to the ObjectEvent format. The initial list of
+// events is sorted by the last timestamp and events with the same reason and message are merged, by summing up their
+// count.
+func WatchEvents(ctx context.Context, client rest.Interface, options EventsOptions, send func(Event) error) error {
+	eventsURL, convert, err := getEventsURL(ctx, client, options)
+	if err != nil {
+		return err
+	}
+
+	return Watch(ctx, client, Options{URL: eventsURL, ResourceVersion: options.ResourceVersion}, func(event Event) error {
+		switch event.Type {
+		case EventList, EventResync:
+			var events []ObjectEvent
+			for _, item := range event.Items {
+				if objectEvent, err := convert(item); err == nil {
+					events = append(events, objectEvent)
+				}
+			}
+
+			items, err := marshalObjectEvents(deduplicateObjectEvents(events))
+			if err != nil {
+				return err
+			}
+			event.Items = items
+		case EventAdded, EventModified, EventDeleted:
+			objectEvent, err := convert(event.Object)
+			if err != nil {
+				return err
+			}
+
+			data, err := json.Marshal(objectEvent)
+			if err != nil {
+				return err
+			}
+			event.Object = data
+		}
+
+		return send(event)
+	})
+}
+
+// getEventsURL returns the url to list and watch the events for the object and the function to convert the events of
+// the selected API to the ObjectEvent format. The field selector for the object is encoded, so that names with special
+// characters are handled correctly.
+func getEventsURL(ctx context.Context, client rest.Interface, options EventsOptions) (string, func(json.RawMessage) (ObjectEvent, error), error) {
+	path, prefix, convert, err := getEventsAPI(ctx, client, options.Namespace)
+	if err != nil {
+		return "", nil, err
+	}
+
+	set := fields.Set{prefix + ".name": options.Name}
+	if options.Namespace != "" {
+		set[prefix+".namespace"] = options.Namespace
+	}
+	if options.UID != "" {
+		set[prefix+".uid"] = options.UID
+	}
+
+	query := url.Values{}
+	query.Set("fieldSelector", fields.SelectorFromSet(set).String())
+
+	return path + "?" + query.Encode(), convert, nil
+}
+
+// getEventsAPI returns the path to list the events in the given namespace, the prefix for the field selectors of the
+// involved object and the function to convert the events to the ObjectEvent format. If the cluster supports the
+// "events.k8s.io/v1" API it is used, otherwise we fall back to the "v1" API.
+func getEventsAPI(ctx context.Context, client rest.Interface, namespace string) (string, string, func(json.RawMessage) (ObjectEvent, error), error) {
+	if err := client.Get().AbsPath("/apis/events.k8s.io/v1").Do(ctx).Error(); err == nil {
+		if namespace != "" {
+			return fmt.Sprintf("/apis/events.k8s.io/v1/namespaces/%s/events", url.PathEscape(namespace)), "regarding", convertEventsEvent, nil
+		}
+		return "/apis/events.k8s.io/v1/events", "regarding", convertEventsEvent, nil
+	} else if !apierrors.IsNotFound(err) {
+		return "", "", nil, err
+	}
+
+	if namespace != "" {
+		return fmt.Sprintf("/api/v1/namespaces/%s/events", url.PathEscape(namespace)), "involvedObject", convertCoreEvent, nil
+	}
+	return "/api/v1/events", "involvedObject", convertCoreEvent, nil
+}
+
+func convertCoreEvent(data json.RawMessage) (ObjectEvent, error) {
+	var event corev1.Event
+	if err := json.Unmarshal(data, &event); err != nil {
+		return ObjectEvent{}, err
+	}
+
+	objectEvent := ObjectEvent{
+		UID:             string(event.UID),
+		Name:            event.Name,
+		Type:            event.Type,
+		Reason:          event.Reason,
+		Message:         event.Message,
+		Count:           event.Count,
+		Source:          event.Source.Component,
+		ResourceVersion: event.ResourceVersion,
+		object:          event.InvolvedObject,
+	}
+
+	if !event.FirstTimestamp.IsZero() {
+		objectEvent.FirstTimestamp = event.FirstTimestamp.UTC().Format(time.RFC3339)
+	}
+
+	lastTimestamp := event.LastTimestamp.Time
+	if lastTimestamp.IsZero() {
+		lastTimestamp = event.EventTime.Time
+	}
+	if !lastTimestamp.IsZero() {
+		objectEvent.LastTimestamp = lastTimestamp.UTC().Format(time.RFC3339)
+		objectEvent.lastTimestampValue = lastTimestamp.UnixNano()
+	}
+
+	if event.Series != nil && event.Series.Count > objectEvent.Count {
+		objectEvent.Count = event.Series.Count
+	}
+	if objectEvent.Count == 0 {
+		objectEvent.Count = 1
+	}
+	if objectEvent.Source == "" {
+		objectEvent.Source = event.ReportingController
+	}
+
+	return objectEvent, nil
+}
+
+func convertEventsEvent(data json.RawMessage) (ObjectEvent, error) {
+	var event eventsv1.Event
+	if err := json.Unmarshal(data, &event); err != nil {
+		return ObjectEvent{}, err
+	}
+
+	objectEvent := ObjectEvent{
+		UID:             string(event.UID),
+		Name:            event.Name,
+		Type:            event.Type,
+		Reason:          event.Reason,
+		Message:         event.Note,
+		Count:           event.DeprecatedCount,
+		Source:          event.ReportingController,
+		ResourceVersion: event.ResourceVersion,
+		object:          event.Regarding,
+	}
+
+	firstTimestamp := event.DeprecatedFirstTimestamp.Time
+	if firstTimestamp.IsZero() {
+		firstTimestamp = event.EventTime.Time
+	}
+	if !firstTimestamp.IsZero() {
+		objectEvent.FirstTimestamp = firstTimestamp.UTC().Format(time.RFC3339)
+	}
+
+	lastTimestamp := event.DeprecatedLastTimestamp.Time
+	if event.Series != nil && !event.Series.LastObservedTime.IsZero() {
+		lastTimestamp = event.Series.LastObservedTime.Time
+	}
+	if lastTimestamp.IsZero() {
+		lastTimestamp = event.EventTime.Time
+	}
+	if !lastTimestamp.IsZero() {
+		objectEvent.LastTimestamp = lastTimestamp.UTC().Format(time.RFC3339)
+		objectEvent.lastTimestampValue = lastTimestamp.UnixNano()
+	}
+
+	if event.Series != nil && event.Series.Count > objectEvent.Count {
+		objectEvent.Count = event.Series.Count
+	}
+	if objectEvent.Count == 0 {
+		objectEvent.Count = 1
+	}
+	if objectEvent.Source == "" {
+		objectEvent.Source = event.DeprecatedSource.Component
+	}
+
+	return objectEvent, nil
+}
+
+// deduplicateObjectEvents merges events with the same reason and message. The count of the merged events is summed up
+// and the first and last timestamp are adjusted. The returned events are sorted by their last timestamp.
+func deduplicateObjectEvents(events []ObjectEvent) []ObjectEvent {
+	var deduplicated []ObjectEvent
+	index := make(map[string]int)
+
+	for _, event := range events {
+		key := event.Reason + "\x00" + event.Message
+
+		i, ok := index[key]
+		if !ok {
+			index[key] = len(deduplicated)
+			deduplicated = append(deduplicated, event)
+			continue
+		}
+
+		existing := &deduplicated[i]
+		existing.Count += event.Count
+		if event.FirstTimestamp != "" && (existing.FirstTimestamp == "" || event.FirstTimestamp < existing.FirstTimestamp) {
+			existing.FirstTimestamp = event.FirstTimestamp
+		}
+		if event.lastTimestampValue > existing.lastTimestampValue {
+			existing.UID = event.UID
+			existing.Name = event.Name
+			existing.Type = event.Type
+			existing.LastTimestamp = event.LastTimestamp
+			existing.ResourceVersion = event.ResourceVersion
+			existing.lastTimestampValue = event.lastTimestampValue
+		}
+	}
+
+	sort.SliceStable(deduplicated, func(i, j int) bool {
+		return deduplicated[i].lastTimestampValue < deduplicated[j].lastTimestampValue
+	})
+
+	return deduplicated
+}
+
+func marshalObjectEvents(events []ObjectEvent) ([]json.RawMessage, error) {
+	items := make([]json.RawMessage, 0, len(events))
+	for _, event := range events {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, data)
+	}
+
+	return items, nil
+}
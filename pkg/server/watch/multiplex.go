@@ -0,0 +1,276 @@
+package watch
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"k8s.io/client-go/rest"
+)
+
+const (
+	// DefaultMaxSubscriptions is the default maximum number of subscriptions for a single connection.
+	DefaultMaxSubscriptions = 20
+
+	// queueSize is the maximum number of events, which are buffered for a single subscription. When the queue is
+	// full, the oldest event is dropped and the client is notified via a "LOSSY" event.
+	queueSize = 256
+)
+
+// EventLossy is send for a subscription, when events were dropped, because the client was to slow. The client should
+// subscribe again, to get the current state of the resources.
+const EventLossy = "LOSSY"
+
+// Control is a control message, which is send by the client to subscribe to a resource or to unsubscribe from a
+// resource, when multiple resources are watched over a single connection.
+//
+// OP           FIELD(S) USED     DESCRIPTION
+// ---------------------------------------------------------------------
+// subscribe    ID, URL           Start a new watch for the url, all events of the watch are tagged with the ID. The
+//
+//	watch can be resumed via the optional ResourceVersion.
+//
+// unsubscribe  ID                Stop the watch with the ID
+type Control struct {
+	Op              string `json:"op"`
+	ID              string `json:"id"`
+	URL             string `json:"url"`
+	ResourceVersion string `json:"resourceVersion"`
+}
+
+// The operations of a Control message.
+const (
+	ControlSubscribe   = "subscribe"
+	ControlUnsubscribe = "unsubscribe"
+)
+
+// Multiplexer runs multiple watches and sends the events of all watches to a single send function. Each watch has its
+// own queue, so that a single watch with a lot of events can not block the events of the other watches.
+type Multiplexer struct {
+	ctx              context.Context
+	client           rest.Interface
+	maxSubscriptions int
+	sendFn           func(Event) error
+
+	lock          sync.Mutex
+	subscriptions map[string]*subscription
+	order         []string
+	next          int
+	errors        []Event
+	notify        chan struct{}
+}
+
+// subscription is a single watch of a Multiplexer with the queue of the events, which were not send yet. When the
+// watch ended, the subscription is marked as done and removed, after all events from the queue were send.
+type subscription struct {
+	cancel context.CancelFunc
+	queue  []Event
+	lossy  bool
+	done   bool
+}
+
+// NewMultiplexer returns a new Multiplexer. The events of all watches are passed to the send function, when Run is
+// called. If "maxSubscriptions" is 0 the default limit is used.
+func NewMultiplexer(ctx context.Context, client rest.Interface, maxSubscriptions int, send func(Event) error) *Multiplexer {
+	if maxSubscriptions <= 0 {
+		maxSubscriptions = DefaultMaxSubscriptions
+	}
+
+	return &Multiplexer{
+		ctx:              ctx,
+		client:           client,
+		maxSubscriptions: maxSubscriptions,
+		sendFn:           send,
+		subscriptions:    make(map[string]*subscription),
+		notify:           make(chan struct{}, 1),
+	}
+}
+
+// Handle handles a control message from the client. If the control message is invalid, an "ERROR" event for the
+// subscription is send to the client.
+func (m *Multiplexer) Handle(control Control) {
+	switch control.Op {
+	case ControlSubscribe:
+		if err := m.subscribe(control.ID, Options{URL: control.URL, ResourceVersion: control.ResourceVersion}); err != nil {
+			m.sendError(control.ID, err)
+		}
+	case ControlUnsubscribe:
+		m.unsubscribe(control.ID)
+	default:
+		m.sendError(control.ID, fmt.Errorf("invalid operation %s", control.Op))
+	}
+}
+
+// Run sends the events of all watches to the send function, until the context is canceled or the send function
+// returns an error. The queues of the watches are processed in a round-robin fashion.
+func (m *Multiplexer) Run() error {
+	defer m.unsubscribeAll()
+
+	for {
+		select {
+		case <-m.ctx.Done():
+			return m.ctx.Err()
+		case <-m.notify:
+		}
+
+		for {
+			event, ok := m.dequeue()
+			if !ok {
+				break
+			}
+
+			if err := m.sendFn(event); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (m *Multiplexer) subscribe(id string, options Options) error {
+	if id == "" {
+		return fmt.Errorf("id is required")
+	}
+
+	if err := ValidateURL(options.URL); err != nil {
+		return err
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if _, ok := m.subscriptions[id]; ok {
+		return fmt.Errorf("subscription %s already exists", id)
+	}
+
+	if len(m.subscriptions) >= m.maxSubscriptions {
+		return fmt.Errorf("subscription limit of %d reached", m.maxSubscriptions)
+	}
+
+	ctx, cancel := context.WithCancel(m.ctx)
+	sub := &subscription{cancel: cancel}
+	m.subscriptions[id] = sub
+	m.order = append(m.order, id)
+
+	go func() {
+		err := Watch(ctx, m.client, options, func(event Event) error {
+			m.enqueue(sub, id, event)
+			return nil
+		})
+		if err != nil && ctx.Err() == nil {
+			m.enqueue(sub, id, Event{Type: EventError, Data: err.Error()})
+		}
+
+		m.lock.Lock()
+		sub.done = true
+		m.lock.Unlock()
+		m.signal()
+	}()
+
+	return nil
+}
+
+func (m *Multiplexer) unsubscribe(id string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.remove(id)
+}
+
+func (m *Multiplexer) unsubscribeAll() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	for id := range m.subscriptions {
+		m.remove(id)
+	}
+}
+
+// remove stops the watch with the given id and removes the queue of the watch. The caller must hold the lock.
+func (m *Multiplexer) remove(id string) {
+	sub, ok := m.subscriptions[id]
+	if !ok {
+		return
+	}
+
+	sub.cancel()
+	delete(m.subscriptions, id)
+
+	for i := range m.order {
+		if m.order[i] == id {
+			m.order = append(m.order[:i], m.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// enqueue adds an event to the queue of a subscription. When the queue is full, the oldest event is dropped and the
+// subscription is marked as lossy.
+func (m *Multiplexer) enqueue(sub *subscription, id string, event Event) {
+	event.ID = id
+
+	m.lock.Lock()
+	if len(sub.queue) >= queueSize {
+		sub.queue = sub.queue[1:]
+		sub.lossy = true
+	}
+	sub.queue = append(sub.queue, event)
+	m.lock.Unlock()
+
+	m.signal()
+}
+
+// sendError sends an error for an invalid control message. The errors are not part of a subscription, so that they
+// are added to a separate queue.
+func (m *Multiplexer) sendError(id string, err error) {
+	m.lock.Lock()
+	m.errors = append(m.errors, Event{Type: EventError, ID: id, Data: err.Error()})
+	m.lock.Unlock()
+
+	m.signal()
+}
+
+// signal notifies the Run function, that there are new events.
+func (m *Multiplexer) signal() {
+	select {
+	case m.notify <- struct{}{}:
+	default:
+	}
+}
+
+// dequeue returns the next event, which should be send. The subscriptions are processed in a round-robin fashion. If
+// events of a subscription were dropped, a "LOSSY" event is returned first.
+func (m *Multiplexer) dequeue() (Event, bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if len(m.errors) > 0 {
+		event := m.errors[0]
+		m.errors = m.errors[1:]
+		return event, true
+	}
+
+	for _, id := range append([]string{}, m.order...) {
+		if sub := m.subscriptions[id]; sub.done && !sub.lossy && len(sub.queue) == 0 {
+			m.remove(id)
+		}
+	}
+
+	for i := 0; i < len(m.order); i++ {
+		m.next = (m.next + 1) % len(m.order)
+		id := m.order[m.next]
+		sub := m.subscriptions[id]
+
+		if sub.lossy {
+			sub.lossy = false
+			return Event{Type: EventLossy, ID: id}, true
+		}
+
+		if len(sub.queue) > 0 {
+			event := sub.queue[0]
+			sub.queue = sub.queue[1:]
+			return event, true
+		}
+	}
+
+	return Event{}, false
+}
@@ -0,0 +1,92 @@
+package watch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// DefaultMaxDowntime is the default duration, after which a stream gives up to reconnect to the Kubernetes API.
+const DefaultMaxDowntime = 5 * time.Minute
+
+// The statuses, which are send to the client while a stream reconnects. "reconnecting" is send once, when the
+// connection is lost and "resynced" when the stream is connected again, so that the client can show an indicator
+// instead of reloading the whole view.
+const (
+	StatusReconnecting = "reconnecting"
+	StatusResynced     = "resynced"
+)
+
+// ErrMaxDowntime is returned, when a stream could not be reconnected within the maximum downtime.
+var ErrMaxDowntime = errors.New("could not reconnect to the Kubernetes API")
+
+// Reconnector implements the reconnection of a stream to the Kubernetes API, which is used by the watches, the log
+// streams and the rollout status streams. Between two attempts it waits with an exponential backoff and jitter. When
+// the stream could not be reconnected within the maximum downtime, the reconnection fails with ErrMaxDowntime.
+//
+// A Reconnector is not safe for concurrent use, each stream must use its own Reconnector.
+type Reconnector struct {
+	maxDowntime time.Duration
+	backoff     *wait.Backoff
+	downSince   time.Time
+}
+
+// NewReconnector returns a new Reconnector with the given maximum downtime. If the maximum downtime is 0,
+// DefaultMaxDowntime is used.
+func NewReconnector(maxDowntime time.Duration) *Reconnector {
+	if maxDowntime <= 0 {
+		maxDowntime = DefaultMaxDowntime
+	}
+
+	return &Reconnector{maxDowntime: maxDowntime, backoff: newBackoff()}
+}
+
+// Reconnecting returns true, when the connection was lost and the stream wasn't connected again since then.
+func (r *Reconnector) Reconnecting() bool {
+	return !r.downSince.IsZero()
+}
+
+// Connected must be called, when the stream was connected. It returns true, when the stream was reconnecting before,
+// so that the caller can send the "resynced" status.
+func (r *Reconnector) Connected() bool {
+	reconnected := r.Reconnecting()
+	r.downSince = time.Time{}
+	return reconnected
+}
+
+// ResetBackoff resets the backoff, it should be called when the stream received data again.
+func (r *Reconnector) ResetBackoff() {
+	r.backoff = newBackoff()
+}
+
+// Wait waits for the next attempt to connect the stream. If the error is nil, the stream was closed without an error
+// and we only wait for the backoff. Otherwise the connection is lost and the downtime starts, when it exceeds the
+// maximum downtime, ErrMaxDowntime is returned. If the context is canceled while waiting, the error of the context is
+// returned.
+func (r *Reconnector) Wait(ctx context.Context, err error) error {
+	if err != nil {
+		if r.downSince.IsZero() {
+			r.downSince = time.Now()
+		} else if time.Since(r.downSince) > r.maxDowntime {
+			return fmt.Errorf("%w within %s: %s", ErrMaxDowntime, r.maxDowntime, err.Error())
+		}
+	}
+
+	timer := time.NewTimer(r.backoff.Step())
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		timer.Stop()
+		return ctx.Err()
+	}
+}
+
+// IsTemporary returns true if a stream should be reconnected after the error, e.g. for network errors or when the
+// Kubernetes API is overloaded.
+func IsTemporary(err error) bool {
+	return isTemporary(err)
+}
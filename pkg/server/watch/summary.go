@@ -0,0 +1,230 @@
+package watch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	// DefaultMaxEventGroups is the default maximum number of groups, which are returned in an events summary.
+	DefaultMaxEventGroups = 100
+
+	// eventsListLimit is the number of events, which are fetched per request for an events summary.
+	eventsListLimit = 500
+)
+
+// SummaryOptions are the options for an events summary. If the namespace is empty, the events of all namespaces are
+// summarized. When Since is set, only events with a last timestamp within this duration are included.
+type SummaryOptions struct {
+	Namespace string
+	Since     time.Duration
+	MaxGroups int
+}
+
+// SummaryOptionsFromQuery returns the options for an events summary from the "namespace", "since" (in seconds) and
+// "maxGroups" query parameters.
+func SummaryOptionsFromQuery(query url.Values) (SummaryOptions, error) {
+	options := SummaryOptions{
+		Namespace: query.Get("namespace"),
+		MaxGroups: DefaultMaxEventGroups,
+	}
+
+	if since := query.Get("since"); since != "" {
+		parsedSince, err := strconv.ParseInt(since, 10, 64)
+		if err != nil || parsedSince <= 0 {
+			return options, fmt.Errorf("invalid since %s", since)
+		}
+		options.Since = time.Duration(parsedSince) * time.Second
+	}
+
+	if maxGroups := query.Get("maxGroups"); maxGroups != "" {
+		parsedMaxGroups, err := strconv.Atoi(maxGroups)
+		if err != nil || parsedMaxGroups <= 0 {
+			return options, fmt.Errorf("invalid maxGroups %s", maxGroups)
+		}
+		options.MaxGroups = parsedMaxGroups
+	}
+
+	return options, nil
+}
+
+// EventGroup is a group of events with the same involved object and reason. The count is the sum of the counts of all
+// events in the group and the message is the message of the latest event. The type is "Warning" when at least one
+// event of the group is a warning.
+type EventGroup struct {
+	Kind           string `json:"kind"`
+	Namespace      string `json:"namespace,omitempty"`
+	Name           string `json:"name"`
+	Reason         string `json:"reason"`
+	Type           string `json:"type"`
+	Message        string `json:"message"`
+	Count          int32  `json:"count"`
+	Events         int    `json:"events"`
+	FirstTimestamp string `json:"firstTimestamp,omitempty"`
+	LastTimestamp  string `json:"lastTimestamp,omitempty"`
+
+	firstTimestampValue int64
+	lastTimestampValue  int64
+}
+
+// EventsSummary is the summary of the events of a namespace or the whole cluster. The groups are limited to the
+// maximum number of groups from the options, the total number of groups is returned in the Total field.
+type EventsSummary struct {
+	Groups    []EventGroup `json:"groups"`
+	Total     int          `json:"total"`
+	Truncated bool         `json:"truncated"`
+}
+
+// GetEventsSummary lists all events in the namespace from the options and groups them by the involved object and the
+// reason. The "events.k8s.io/v1" API is used when it is available, otherwise we fall back to the "v1" API.
+func GetEventsSummary(ctx context.Context, client rest.Interface, options SummaryOptions) (*EventsSummary, error) {
+	path, _, convert, err := getEventsAPI(ctx, client, options.Namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []ObjectEvent
+
+	continueToken := ""
+	for {
+		request := client.Get().AbsPath(path).Param("limit", strconv.Itoa(eventsListLimit))
+		if continueToken != "" {
+			request = request.Param("continue", continueToken)
+		}
+
+		data, err := request.DoRaw(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		var list struct {
+			Metadata metav1.ListMeta   `json:"metadata"`
+			Items    []json.RawMessage `json:"items"`
+		}
+		if err := json.Unmarshal(data, &list); err != nil {
+			return nil, err
+		}
+
+		for _, item := range list.Items {
+			if event, err := convert(item); err == nil {
+				events = append(events, event)
+			}
+		}
+
+		if list.Metadata.Continue == "" {
+			break
+		}
+		continueToken = list.Metadata.Continue
+	}
+
+	var since time.Time
+	if options.Since > 0 {
+		since = time.Now().Add(-options.Since)
+	}
+
+	groups := summarizeObjectEvents(events, since)
+
+	maxGroups := options.MaxGroups
+	if maxGroups <= 0 {
+		maxGroups = DefaultMaxEventGroups
+	}
+
+	summary := &EventsSummary{Groups: groups, Total: len(groups)}
+	if len(summary.Groups) > maxGroups {
+		summary.Groups = summary.Groups[:maxGroups]
+		summary.Truncated = true
+	}
+
+	return summary, nil
+}
+
+// summarizeObjectEvents groups the events by the kind, namespace and name of the involved object and the reason. The
+// events must already be converted to the ObjectEvent format, so that the different counts of the "v1" and
+// "events.k8s.io/v1" APIs are already normalized. Events with a last timestamp before since are skipped, when since is
+// not zero.
+//
+// The returned groups are sorted by their type, so that warnings are returned first, and then by their last
+// timestamp, starting with the latest group.
+func summarizeObjectEvents(events []ObjectEvent, since time.Time) []EventGroup {
+	groups := []EventGroup{}
+	index := make(map[string]int)
+
+	for _, event := range events {
+		if !since.IsZero() && event.lastTimestampValue > 0 && event.lastTimestampValue < since.UnixNano() {
+			continue
+		}
+
+		key := event.object.Kind + "\x00" + event.object.Namespace + "\x00" + event.object.Name + "\x00" + event.Reason
+
+		i, ok := index[key]
+		if !ok {
+			index[key] = len(groups)
+			groups = append(groups, EventGroup{
+				Kind:                event.object.Kind,
+				Namespace:           event.object.Namespace,
+				Name:                event.object.Name,
+				Reason:              event.Reason,
+				Type:                event.Type,
+				Message:             event.Message,
+				Count:               event.Count,
+				Events:              1,
+				FirstTimestamp:      event.FirstTimestamp,
+				LastTimestamp:       event.LastTimestamp,
+				firstTimestampValue: parseTimestamp(event.FirstTimestamp),
+				lastTimestampValue:  event.lastTimestampValue,
+			})
+			continue
+		}
+
+		group := &groups[i]
+		group.Count += event.Count
+		group.Events++
+
+		if event.Type == "Warning" {
+			group.Type = event.Type
+		}
+
+		if firstTimestampValue := parseTimestamp(event.FirstTimestamp); firstTimestampValue > 0 && (group.firstTimestampValue == 0 || firstTimestampValue < group.firstTimestampValue) {
+			group.FirstTimestamp = event.FirstTimestamp
+			group.firstTimestampValue = firstTimestampValue
+		}
+
+		if event.lastTimestampValue > group.lastTimestampValue {
+			group.Message = event.Message
+			group.LastTimestamp = event.LastTimestamp
+			group.lastTimestampValue = event.lastTimestampValue
+		}
+	}
+
+	sort.SliceStable(groups, func(i, j int) bool {
+		if (groups[i].Type == "Warning") != (groups[j].Type == "Warning") {
+			return groups[i].Type == "Warning"
+		}
+		return groups[i].lastTimestampValue > groups[j].lastTimestampValue
+	})
+
+	return groups
+}
+
+// parseTimestamp parses a RFC3339 timestamp of an ObjectEvent and returns it as unix timestamp in nanoseconds. If the
+// timestamp is empty or invalid 0 is returned.
+func parseTimestamp(value string) int64 {
+	if value == "" {
+		return 0
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return 0
+	}
+
+	return t.UnixNano()
+}
@@ -0,0 +1,232 @@
+package watch
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	eventsv1 "k8s.io/api/events/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+var (
+	t1 = time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	t2 = t1.Add(time.Minute)
+	t3 = t1.Add(2 * time.Minute)
+)
+
+func coreEvent(name, kind, object, eventType, reason, message string, count int32, series int32, first, last time.Time) corev1.Event {
+	event := corev1.Event{
+		ObjectMeta:     metav1.ObjectMeta{Name: name, Namespace: "default"},
+		InvolvedObject: corev1.ObjectReference{Kind: kind, Namespace: "default", Name: object},
+		Type:           eventType,
+		Reason:         reason,
+		Message:        message,
+		Count:          count,
+		FirstTimestamp: metav1.NewTime(first),
+		LastTimestamp:  metav1.NewTime(last),
+	}
+	if series > 0 {
+		event.Series = &corev1.EventSeries{Count: series}
+	}
+	return event
+}
+
+func eventsEvent(name, kind, object, eventType, reason, note string, deprecatedCount int32, series int32, first, last time.Time) eventsv1.Event {
+	event := eventsv1.Event{
+		ObjectMeta:               metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Regarding:                corev1.ObjectReference{Kind: kind, Namespace: "default", Name: object},
+		Type:                     eventType,
+		Reason:                   reason,
+		Note:                     note,
+		DeprecatedCount:          deprecatedCount,
+		DeprecatedFirstTimestamp: metav1.NewTime(first),
+		DeprecatedLastTimestamp:  metav1.NewTime(last),
+	}
+	if series > 0 {
+		event.Series = &eventsv1.EventSeries{Count: series, LastObservedTime: metav1.NewMicroTime(last)}
+	}
+	return event
+}
+
+func convertEvents(t *testing.T, convert func(json.RawMessage) (ObjectEvent, error), events ...interface{}) []ObjectEvent {
+	t.Helper()
+
+	var objectEvents []ObjectEvent
+	for _, event := range events {
+		data, err := json.Marshal(event)
+		if err != nil {
+			t.Fatalf("could not encode event: %v", err)
+		}
+		objectEvent, err := convert(data)
+		if err != nil {
+			t.Fatalf("could not convert event: %v", err)
+		}
+		objectEvents = append(objectEvents, objectEvent)
+	}
+	return objectEvents
+}
+
+func TestSummarizeObjectEvents(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		events   []ObjectEvent
+		since    time.Time
+		expected []EventGroup
+	}{
+		{
+			name: "core events with count and series count",
+			events: convertEvents(t, convertCoreEvent,
+				coreEvent("a", "Pod", "nginx", "Normal", "Pulled", "pulled 1", 2, 0, t1, t2),
+				coreEvent("b", "Pod", "nginx", "Normal", "Pulled", "pulled 2", 1, 5, t2, t3),
+				coreEvent("c", "Pod", "nginx", "Normal", "Pulled", "pulled 0", 0, 0, time.Time{}, t1),
+			),
+			expected: []EventGroup{
+				{Kind: "Pod", Namespace: "default", Name: "nginx", Reason: "Pulled", Type: "Normal", Message: "pulled 2", Count: 8, Events: 3, FirstTimestamp: "2024-01-01T10:00:00Z", LastTimestamp: "2024-01-01T10:02:00Z"},
+			},
+		},
+		{
+			name: "events.k8s.io events with deprecated count and series count",
+			events: convertEvents(t, convertEventsEvent,
+				eventsEvent("a", "Pod", "nginx", "Normal", "Started", "started", 3, 0, t2, t2),
+				eventsEvent("b", "Pod", "nginx", "Normal", "Started", "started again", 0, 4, t1, t3),
+				eventsEvent("c", "Pod", "nginx", "Normal", "Started", "started once", 0, 0, t1, t1),
+			),
+			expected: []EventGroup{
+				{Kind: "Pod", Namespace: "default", Name: "nginx", Reason: "Started", Type: "Normal", Message: "started again", Count: 8, Events: 3, FirstTimestamp: "2024-01-01T10:00:00Z", LastTimestamp: "2024-01-01T10:02:00Z"},
+			},
+		},
+		{
+			name: "warnings first, then by last timestamp",
+			events: convertEvents(t, convertCoreEvent,
+				coreEvent("a", "Pod", "old", "Normal", "Pulled", "pulled", 1, 0, t1, t1),
+				coreEvent("b", "Pod", "new", "Normal", "Pulled", "pulled", 1, 0, t3, t3),
+				coreEvent("c", "Pod", "crash", "Warning", "BackOff", "back-off", 1, 0, t1, t1),
+				coreEvent("d", "Pod", "flaky", "Normal", "Unhealthy", "probe ok", 1, 0, t1, t2),
+				coreEvent("e", "Pod", "flaky", "Warning", "Unhealthy", "probe failed", 1, 0, t1, t1),
+			),
+			expected: []EventGroup{
+				{Kind: "Pod", Namespace: "default", Name: "flaky", Reason: "Unhealthy", Type: "Warning", Message: "probe ok", Count: 2, Events: 2, FirstTimestamp: "2024-01-01T10:00:00Z", LastTimestamp: "2024-01-01T10:01:00Z"},
+				{Kind: "Pod", Namespace: "default", Name: "crash", Reason: "BackOff", Type: "Warning", Message: "back-off", Count: 1, Events: 1, FirstTimestamp: "2024-01-01T10:00:00Z", LastTimestamp: "2024-01-01T10:00:00Z"},
+				{Kind: "Pod", Namespace: "default", Name: "new", Reason: "Pulled", Type: "Normal", Message: "pulled", Count: 1, Events: 1, FirstTimestamp: "2024-01-01T10:02:00Z", LastTimestamp: "2024-01-01T10:02:00Z"},
+				{Kind: "Pod", Namespace: "default", Name: "old", Reason: "Pulled", Type: "Normal", Message: "pulled", Count: 1, Events: 1, FirstTimestamp: "2024-01-01T10:00:00Z", LastTimestamp: "2024-01-01T10:00:00Z"},
+			},
+		},
+		{
+			name: "since skips older events",
+			events: convertEvents(t, convertCoreEvent,
+				coreEvent("a", "Pod", "nginx", "Normal", "Pulled", "old", 10, 0, t1, t1),
+				coreEvent("b", "Pod", "nginx", "Normal", "Pulled", "new", 1, 0, t3, t3),
+			),
+			since: t2,
+			expected: []EventGroup{
+				{Kind: "Pod", Namespace: "default", Name: "nginx", Reason: "Pulled", Type: "Normal", Message: "new", Count: 1, Events: 1, FirstTimestamp: "2024-01-01T10:02:00Z", LastTimestamp: "2024-01-01T10:02:00Z"},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			groups := summarizeObjectEvents(tc.events, tc.since)
+			if len(groups) != len(tc.expected) {
+				t.Fatalf("expected %d groups, got %d: %+v", len(tc.expected), len(groups), groups)
+			}
+
+			for i, group := range groups {
+				group.firstTimestampValue, group.lastTimestampValue = 0, 0
+				if group != tc.expected[i] {
+					t.Errorf("expected group %d to be %+v, got %+v", i, tc.expected[i], group)
+				}
+			}
+		})
+	}
+}
+
+func newEventsClient(t *testing.T, handler http.HandlerFunc) rest.Interface {
+	t.Helper()
+
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	clientset, err := kubernetes.NewForConfig(&rest.Config{Host: srv.URL})
+	if err != nil {
+		t.Fatalf("could not create client: %v", err)
+	}
+	return clientset.RESTClient()
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func TestGetEventsSummary(t *testing.T) {
+	t.Run("events.k8s.io api with pagination", func(t *testing.T) {
+		client := newEventsClient(t, func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/apis/events.k8s.io/v1":
+				writeJSON(w, metav1.APIResourceList{GroupVersion: "events.k8s.io/v1"})
+			case "/apis/events.k8s.io/v1/namespaces/default/events":
+				if r.URL.Query().Get("continue") == "" {
+					writeJSON(w, eventsv1.EventList{
+						ListMeta: metav1.ListMeta{Continue: "next"},
+						Items:    []eventsv1.Event{eventsEvent("a", "Pod", "nginx", "Normal", "Pulled", "pulled", 2, 0, t1, t1)},
+					})
+					return
+				}
+				writeJSON(w, eventsv1.EventList{
+					Items: []eventsv1.Event{
+						eventsEvent("b", "Pod", "nginx", "Normal", "Pulled", "pulled", 0, 3, t2, t2),
+						eventsEvent("c", "Pod", "redis", "Warning", "BackOff", "back-off", 1, 0, t1, t1),
+					},
+				})
+			default:
+				http.NotFound(w, r)
+			}
+		})
+
+		summary, err := GetEventsSummary(context.Background(), client, SummaryOptions{Namespace: "default", MaxGroups: 1})
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		if summary.Total != 2 || !summary.Truncated || len(summary.Groups) != 1 {
+			t.Fatalf("expected 1 of 2 groups, got %d of %d (truncated %t)", len(summary.Groups), summary.Total, summary.Truncated)
+		}
+		if group := summary.Groups[0]; group.Name != "redis" || group.Type != "Warning" {
+			t.Errorf("expected warning for redis first, got %+v", group)
+		}
+	})
+
+	t.Run("fallback to v1 api", func(t *testing.T) {
+		client := newEventsClient(t, func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/api/v1/events":
+				writeJSON(w, corev1.EventList{
+					Items: []corev1.Event{
+						coreEvent("a", "Pod", "nginx", "Normal", "Pulled", "pulled", 2, 0, t1, t1),
+						coreEvent("b", "Pod", "nginx", "Normal", "Pulled", "pulled", 1, 4, t2, t2),
+					},
+				})
+			default:
+				http.NotFound(w, r)
+			}
+		})
+
+		summary, err := GetEventsSummary(context.Background(), client, SummaryOptions{})
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		if summary.Total != 1 || summary.Truncated || len(summary.Groups) != 1 {
+			t.Fatalf("expected 1 group, got %d of %d (truncated %t)", len(summary.Groups), summary.Total, summary.Truncated)
+		}
+		if group := summary.Groups[0]; group.Count != 6 || group.Events != 2 {
+			t.Errorf("expected count 6 of 2 events, got %d of %d", group.Count, group.Events)
+		}
+	})
+}
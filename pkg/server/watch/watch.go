@@ -0,0 +1,413 @@
+// Package watch implements the watching of Kubernetes resources. The resources are listed and then watched via the
+// Kubernetes API and each change is passed as Event to a send function, so that the changes can be streamed to the
+// frontend, e.g. via a WebSocket connection.
+package watch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/rest"
+)
+
+// Event is the messaging protocol for the watch streams.
+//
+// TYPE      FIELD(S) USED     DESCRIPTION
+// ---------------------------------------------------------------------
+// LIST      Items             The initial list of all objects
+// RESYNC    Items             The watch was restarted, the client must replace all objects with the new list
+// ADDED     Object            An object was added
+// MODIFIED  Object            An object was modified
+// DELETED   Object            An object was deleted
+// BOOKMARK  ResourceVersion   The last seen resource version, which can be used to resume the watch
+// STATUS    Data              The connection to the Kubernetes API was lost ("reconnecting") or is restored ("resynced")
+// ERROR     Data              An error occurred, Data contains the error message
+//
+// The LIST and RESYNC events also contain the resource version of the list, the ADDED, MODIFIED and DELETED events
+// the resource version of the object.
+//
+// When multiple resources are watched over a single connection, each event contains the ID of the subscription.
+type Event struct {
+	ID              string            `json:"id,omitempty"`
+	Type            string            `json:"type"`
+	Object          json.RawMessage   `json:"object,omitempty"`
+	Items           []json.RawMessage `json:"items,omitempty"`
+	ResourceVersion string            `json:"resourceVersion,omitempty"`
+	Data            string            `json:"data,omitempty"`
+}
+
+// The types of an Event.
+const (
+	EventList     = "LIST"
+	EventResync   = "RESYNC"
+	EventAdded    = "ADDED"
+	EventModified = "MODIFIED"
+	EventDeleted  = "DELETED"
+	EventBookmark = "BOOKMARK"
+	EventStatus   = "STATUS"
+	EventError    = "ERROR"
+)
+
+// bookmarkInterval is the interval in which the last seen resource version is send to the client.
+const bookmarkInterval = 30 * time.Second
+
+// Options are the options for a watch. The options can be created from the query parameters of a request via
+// OptionsFromQuery.
+type Options struct {
+	URL             string
+	ResourceVersion string
+	MaxDowntime     time.Duration
+}
+
+// OptionsFromQuery returns the options for a watch from the given query parameters. The resource is specified via the
+// "url" parameter, which must be a relative path of the Kubernetes API, e.g. "/api/v1/namespaces/default/pods". The
+// url can contain additional query parameters like a label selector. The "resourceVersion" parameter can be used to
+// resume a watch from the resource version of a "BOOKMARK" event. The "maxDowntime" parameter is the duration in
+// seconds, after which the watch fails when the connection to the Kubernetes API can not be restored.
+func OptionsFromQuery(query url.Values) (Options, error) {
+	options := Options{
+		URL:             query.Get("url"),
+		ResourceVersion: query.Get("resourceVersion"),
+	}
+
+	if err := ValidateURL(options.URL); err != nil {
+		return options, err
+	}
+
+	if maxDowntime := query.Get("maxDowntime"); maxDowntime != "" {
+		seconds, err := strconv.ParseInt(maxDowntime, 10, 64)
+		if err != nil || seconds <= 0 {
+			return options, fmt.Errorf("invalid maxDowntime %s", maxDowntime)
+		}
+		options.MaxDowntime = time.Duration(seconds) * time.Second
+	}
+
+	return options, nil
+}
+
+// ValidateURL checks that the url is a relative path of the Kubernetes API, so that we never send the credentials of
+// the user to another host.
+func ValidateURL(resourceURL string) error {
+	if resourceURL == "" {
+		return fmt.Errorf("url is required")
+	}
+
+	u, err := url.Parse(resourceURL)
+	if err != nil {
+		return fmt.Errorf("invalid url: %s", err.Error())
+	}
+
+	if u.IsAbs() || u.Host != "" || !strings.HasPrefix(u.Path, "/") || strings.Contains(u.Path, "..") {
+		return fmt.Errorf("invalid url: must be a relative path")
+	}
+
+	if !strings.HasPrefix(u.Path, "/api/") && !strings.HasPrefix(u.Path, "/apis/") {
+		return fmt.Errorf("invalid url: must be a path of the Kubernetes API")
+	}
+
+	return nil
+}
+
+// Watch lists the resources from the options and sends them as "LIST" event. Afterwards the resources are watched
+// starting at the resource version of the list and each change is send as event. If a resource version is set in the
+// options, the initial list is skipped and the watch is resumed from this version.
+//
+// When the watch is closed by the Kubernetes API or fails with a temporary error, it is resumed from the last seen
+// resource version, with an exponential backoff between the attempts (see Reconnector). Only if the resource version
+// is too old (410 Gone) the resources are listed again and send as "RESYNC" event. While the connection is lost a
+// "STATUS" event with "reconnecting" is send and after the watch was resumed a "STATUS" event with "resynced". The last
+// seen resource version is send to the client in a periodic "BOOKMARK" event, so that the client can also resume the
+// watch later. The function returns, when the context is canceled, the send function returns an error, the watch fails
+// with a permanent error or the connection could not be restored within the maximum downtime.
+func Watch(ctx context.Context, client rest.Interface, options Options, send func(Event) error) error {
+	activeWatches.Add(1)
+	defer activeWatches.Add(-1)
+
+	w := &watcher{
+		client:          client,
+		options:         options,
+		sendFn:          send,
+		resourceVersion: options.ResourceVersion,
+		reconnector:     NewReconnector(options.MaxDowntime),
+	}
+
+	if w.resourceVersion == "" {
+		if err := w.list(ctx, EventList); err != nil {
+			return unwrapSendError(err)
+		}
+	}
+
+	bookmarkCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go w.sendBookmarks(bookmarkCtx)
+
+	for {
+		received, err := w.watch(ctx)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if received {
+			w.reconnector.ResetBackoff()
+		}
+
+		// Errors of the send function are never retried, because they are returned when the client is gone or when
+		// the caller wants to stop the watch.
+		if isSendError(err) {
+			return unwrapSendError(err)
+		}
+
+		// When the watch was closed without an error, it is resumed immediately. Only if the watch was closed before
+		// any event was received we use the backoff, so that we do not hammer the Kubernetes API.
+		if err == nil {
+			if received {
+				continue
+			}
+		} else if isGone(err) {
+			if err = w.list(ctx, EventResync); err == nil {
+				if err := w.connected(); err != nil {
+					return unwrapSendError(err)
+				}
+				continue
+			} else if isSendError(err) || !isTemporary(err) {
+				return unwrapSendError(err)
+			}
+		} else if !isTemporary(err) {
+			return err
+		}
+
+		if err != nil && !w.reconnector.Reconnecting() {
+			if err := w.send(Event{Type: EventStatus, Data: StatusReconnecting}); err != nil {
+				return unwrapSendError(err)
+			}
+		}
+
+		if err := w.reconnector.Wait(ctx, err); err != nil {
+			return err
+		}
+	}
+}
+
+var activeWatches atomic.Int64
+
+// ActiveWatches returns the number of running watches, including the watches of all multiplexed connections.
+func ActiveWatches() int64 {
+	return activeWatches.Load()
+}
+
+// watcher contains the state of a single watch. The send function is protected by a lock, because the events and the
+// periodic bookmarks are send from different goroutines.
+type watcher struct {
+	client  rest.Interface
+	options Options
+	sendFn  func(Event) error
+
+	// reconnector is only used by the goroutine, which runs the watch, so that it isn't protected by the lock.
+	reconnector *Reconnector
+
+	lock                    sync.Mutex
+	resourceVersion         string
+	bookmarkResourceVersion string
+}
+
+// newBackoff returns the backoff, which is used between the attempts to reconnect a stream. The first attempt is made
+// after one second, the maximum duration between two attempts is 30 seconds.
+func newBackoff() *wait.Backoff {
+	return &wait.Backoff{
+		Duration: time.Second,
+		Factor:   2,
+		Jitter:   0.5,
+		Steps:    10,
+		Cap:      30 * time.Second,
+	}
+}
+
+// send sends the event via the send function. Errors of the send function are wrapped in a sendError, so that they
+// can be distinguished from the errors of the Kubernetes API.
+func (w *watcher) send(event Event) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if err := w.sendFn(event); err != nil {
+		return &sendError{err: err}
+	}
+	return nil
+}
+
+// connected marks the watch as connected and sends the "resynced" status, when the connection was lost before.
+func (w *watcher) connected() error {
+	if w.reconnector.Connected() {
+		return w.send(Event{Type: EventStatus, Data: StatusResynced})
+	}
+	return nil
+}
+
+// sendError is an error, which was returned by the send function of a watch.
+type sendError struct {
+	err error
+}
+
+func (e *sendError) Error() string {
+	return e.err.Error()
+}
+
+func isSendError(err error) bool {
+	var sendErr *sendError
+	return errors.As(err, &sendErr)
+}
+
+// unwrapSendError returns the original error of the send function, if the error is a sendError.
+func unwrapSendError(err error) error {
+	var sendErr *sendError
+	if errors.As(err, &sendErr) {
+		return sendErr.err
+	}
+	return err
+}
+
+func (w *watcher) getResourceVersion() string {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	return w.resourceVersion
+}
+
+func (w *watcher) setResourceVersion(resourceVersion string) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	w.resourceVersion = resourceVersion
+}
+
+// sendBookmarks sends the last seen resource version in the bookmark interval, when it was changed since the last
+// bookmark.
+func (w *watcher) sendBookmarks(ctx context.Context) {
+	ticker := time.NewTicker(bookmarkInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			w.lock.Lock()
+			if w.resourceVersion != "" && w.resourceVersion != w.bookmarkResourceVersion {
+				w.bookmarkResourceVersion = w.resourceVersion
+				w.sendFn(Event{Type: EventBookmark, ResourceVersion: w.resourceVersion})
+			}
+			w.lock.Unlock()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// list lists all resources and sends them with the given event type. Afterwards the resource version of the list is
+// used for the watch.
+func (w *watcher) list(ctx context.Context, eventType string) error {
+	data, err := w.client.Get().RequestURI(w.options.URL).Do(ctx).Raw()
+	if err != nil {
+		return err
+	}
+
+	var objects struct {
+		Metadata metav1.ListMeta   `json:"metadata"`
+		Items    []json.RawMessage `json:"items"`
+	}
+	if err := json.Unmarshal(data, &objects); err != nil {
+		return err
+	}
+
+	if objects.Items == nil {
+		objects.Items = []json.RawMessage{}
+	}
+
+	w.setResourceVersion(objects.Metadata.ResourceVersion)
+
+	return w.send(Event{Type: eventType, Items: objects.Items, ResourceVersion: objects.Metadata.ResourceVersion})
+}
+
+// watch watches the resources starting at the last seen resource version, until the watch is closed. The function
+// returns true, when at least one event was received, so that the backoff can be reset. Bookmark events are not send
+// to the client, they are only used to update the resource version.
+func (w *watcher) watch(ctx context.Context) (bool, error) {
+	stream, err := w.client.Get().RequestURI(w.options.URL).Param("watch", "true").Param("allowWatchBookmarks", "true").Param("resourceVersion", w.getResourceVersion()).Stream(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer stream.Close()
+
+	if err := w.connected(); err != nil {
+		return false, err
+	}
+
+	received := false
+	decoder := json.NewDecoder(stream)
+	for {
+		var event struct {
+			Type   string          `json:"type"`
+			Object json.RawMessage `json:"object"`
+		}
+		if err := decoder.Decode(&event); err != nil {
+			// The stream is closed by the Kubernetes API after the watch timeout, in this case we return without an
+			// error, so that the watch is resumed immediately.
+			if err == io.EOF {
+				return received, nil
+			}
+			return received, err
+		}
+		received = true
+
+		if event.Type == EventError {
+			var status metav1.Status
+			if err := json.Unmarshal(event.Object, &status); err != nil {
+				return received, fmt.Errorf("could not decode watch error: %s", err.Error())
+			}
+			return received, &apierrors.StatusError{ErrStatus: status}
+		}
+
+		var object struct {
+			Metadata metav1.ObjectMeta `json:"metadata"`
+		}
+		if err := json.Unmarshal(event.Object, &object); err == nil && object.Metadata.ResourceVersion != "" {
+			w.setResourceVersion(object.Metadata.ResourceVersion)
+		}
+
+		if event.Type == EventBookmark {
+			continue
+		}
+
+		if err := w.send(Event{Type: event.Type, Object: event.Object, ResourceVersion: object.Metadata.ResourceVersion}); err != nil {
+			return received, err
+		}
+	}
+}
+
+// isTemporary returns true if the watch should be resumed after the error, e.g. for network errors or when the
+// Kubernetes API is overloaded. Errors which will not be solved by a retry, like missing permissions, are permanent.
+func isTemporary(err error) bool {
+	var status apierrors.APIStatus
+	if !errors.As(err, &status) {
+		return true
+	}
+
+	code := status.Status().Code
+	return code == http.StatusGone || code == http.StatusTooManyRequests || code >= http.StatusInternalServerError
+}
+
+// isGone returns true if the error is a 410 Gone error of the Kubernetes API, which is returned when the resource
+// version of a watch is too old.
+func isGone(err error) bool {
+	var status apierrors.APIStatus
+	return errors.As(err, &status) && status.Status().Code == http.StatusGone
+}
@@ -1,22 +1,25 @@
 package shared
 
 import (
-	"bytes"
-	"compress/gzip"
 	"context"
-	"encoding/base64"
 	"encoding/json"
 	"fmt"
-	"io"
+	"log"
 	"sort"
 	"strconv"
 	"time"
 
+	"github.com/kubenav/kubenav/pkg/server/helm"
+	"github.com/kubenav/kubenav/pkg/server/resources"
+
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 )
 
+// helmDiscoveryCache caches the discovery data of the clusters, which is required to apply the manifests of a release.
+var helmDiscoveryCache = resources.NewDiscoveryCache()
+
 // Release describes a deployment of a chart, together with the chart and the variables used to deploy that chart.
 type Release struct {
 	// Name is the name of the release
@@ -275,21 +278,16 @@ const (
 	HookBeforeHookCreation HookDeletePolicy = "before-hook-creation"
 )
 
-// secretDataToRelease converts the secret data from the "release" key to an actual Helm release. For this we have to
-// decode the base64 encoded secret data and the uncrompress it using the "decompress" function.
+// secretDataToRelease converts the secret data from the "release" key to an actual Helm release. The data is decoded
+// via the "Decode" function of the helm package, which decodes the base64 encoded and gzip compressed release data.
 func secretDataToRelease(secretData []byte) (*Release, error) {
-	releaseData, err := base64.StdEncoding.DecodeString(string(secretData))
-	if err != nil {
-		return nil, err
-	}
-
-	releaseDataUnzipped, err := decompress(releaseData)
+	releaseData, err := helm.Decode(secretData)
 	if err != nil {
 		return nil, err
 	}
 
 	var release Release
-	err = json.Unmarshal(releaseDataUnzipped, &release)
+	err = json.Unmarshal(releaseData, &release)
 	if err != nil {
 		return nil, err
 	}
@@ -297,25 +295,6 @@ func secretDataToRelease(secretData []byte) (*Release, error) {
 	return &release, nil
 }
 
-// decompress is used to unzip the gzipped release data for a Helm release.
-func decompress(data []byte) ([]byte, error) {
-	b := bytes.NewBuffer(data)
-
-	var r io.Reader
-	r, err := gzip.NewReader(b)
-	if err != nil {
-		return nil, err
-	}
-
-	var res bytes.Buffer
-	_, err = res.ReadFrom(r)
-	if err != nil {
-		return nil, err
-	}
-
-	return res.Bytes(), nil
-}
-
 // HelmListCharts returns a list of Helm charts for the given cluster and namespace. If an error occures during the
 // process the error is returned.
 func HelmListCharts(clientset *kubernetes.Clientset, namespace string) (string, error) {
@@ -356,7 +335,8 @@ func HelmListCharts(clientset *kubernetes.Clientset, namespace string) (string,
 	for _, secret := range secrets {
 		release, err := secretDataToRelease(secret[0].Data["release"])
 		if err != nil {
-			return "", err
+			log.Printf("Skipped Helm release %s/%s: %s", secret[0].Namespace, secret[0].Name, err.Error())
+			continue
 		}
 
 		releases = append(releases, release)
@@ -431,3 +411,131 @@ func HelmGetHistory(clientset *kubernetes.Clientset, namespace, name string) (st
 
 	return string(releasesBytes), nil
 }
+
+// HelmListReleases returns the releases for the given namespace, if the namespace is empty the releases of all
+// namespaces are returned. By default only the latest revision of each release is returned, when "allRevisions" is true
+// all revisions are returned. Release records which can not be decoded are skipped and returned as warnings.
+func HelmListReleases(clientset kubernetes.Interface, namespace string, allRevisions bool) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 120*time.Second)
+	defer cancel()
+
+	result, err := helm.List(ctx, clientset, namespace, allRevisions)
+	if err != nil {
+		return "", err
+	}
+
+	resultBytes, err := json.Marshal(result)
+	if err != nil {
+		return "", err
+	}
+
+	return string(resultBytes), nil
+}
+
+// HelmGetValues returns the user supplied and the computed values of a revision of a Helm release. If the revision is
+// 0 the values of the latest revision are returned. When "redact" is true all values which could contain a credential
+// are replaced.
+func HelmGetValues(clientset kubernetes.Interface, namespace, name string, revision int64, redact bool) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 120*time.Second)
+	defer cancel()
+
+	values, err := helm.GetValues(ctx, clientset, namespace, name, int(revision), redact)
+	if err != nil {
+		return "", err
+	}
+
+	valuesBytes, err := json.Marshal(values)
+	if err != nil {
+		return "", err
+	}
+
+	return string(valuesBytes), nil
+}
+
+// HelmGetManifest returns the rendered manifest of a revision of a Helm release. If the revision is 0 the manifest of
+// the latest revision is returned. If the manifest is larger than the given limit (in bytes) it is truncated.
+func HelmGetManifest(clientset kubernetes.Interface, namespace, name string, revision, limit int64) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 120*time.Second)
+	defer cancel()
+
+	manifest, err := helm.GetManifest(ctx, clientset, namespace, name, int(revision), int(limit))
+	if err != nil {
+		return "", err
+	}
+
+	manifestBytes, err := json.Marshal(manifest)
+	if err != nil {
+		return "", err
+	}
+
+	return string(manifestBytes), nil
+}
+
+// HelmRollback rolls back a Helm release to the given revision, if the revision is 0 the release is rolled back to the
+// previous revision. When "dryRun" is true only the diff between the current and the target revision is returned. The
+// "clusterKey" is used to cache the discovery data of the cluster.
+func HelmRollback(clientset kubernetes.Interface, clusterKey, namespace, name string, revision int64, dryRun bool) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 120*time.Second)
+	defer cancel()
+
+	result, err := helm.Rollback(ctx, helmDiscoveryCache, clusterKey, clientset, helm.RollbackOptions{
+		Namespace: namespace,
+		Name:      name,
+		Revision:  int(revision),
+		DryRun:    dryRun,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	resultBytes, err := json.Marshal(result)
+	if err != nil {
+		return "", err
+	}
+
+	return string(resultBytes), nil
+}
+
+// HelmUninstall uninstalls a Helm release. When "keepHistory" is true the records of the release are kept and the
+// latest revision is marked as uninstalled. When "dryRun" is true nothing is changed, the resources are only deleted
+// via a server-side dry run. The "clusterKey" is used to cache the discovery data of the cluster.
+func HelmUninstall(clientset kubernetes.Interface, clusterKey, namespace, name string, keepHistory, dryRun bool) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 120*time.Second)
+	defer cancel()
+
+	result, err := helm.Uninstall(ctx, helmDiscoveryCache, clusterKey, clientset, helm.UninstallOptions{
+		Namespace:   namespace,
+		Name:        name,
+		KeepHistory: keepHistory,
+		DryRun:      dryRun,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	resultBytes, err := json.Marshal(result)
+	if err != nil {
+		return "", err
+	}
+
+	return string(resultBytes), nil
+}
+
+// HelmDiffRevisions returns the diff between the rendered manifests of two revisions of a Helm release. The diff
+// contains an unified diff for each changed resource and a summary of the added, removed and changed resources.
+func HelmDiffRevisions(clientset kubernetes.Interface, namespace, name string, fromRevision, toRevision int64) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 120*time.Second)
+	defer cancel()
+
+	diff, err := helm.DiffRevisions(ctx, clientset, namespace, name, int(fromRevision), int(toRevision))
+	if err != nil {
+		return "", err
+	}
+
+	diffBytes, err := json.Marshal(diff)
+	if err != nil {
+		return "", err
+	}
+
+	return string(diffBytes), nil
+}
@@ -0,0 +1,198 @@
+package shared
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// DefaultJobLogsTailLines is the number of lines, which are returned for each container by KubernetesGetJobLogs, when
+// no tail lines are provided.
+const DefaultJobLogsTailLines = 500
+
+// jobLogsPod contains the information about a single Pod of a Job, which is returned together with the logs by
+// KubernetesGetJobLogs. The exit code is only set, when the Pod is completed.
+type jobLogsPod struct {
+	Name           string     `json:"name"`
+	Phase          string     `json:"phase"`
+	Failed         bool       `json:"failed"`
+	ExitCode       *int32     `json:"exitCode,omitempty"`
+	CompletionTime *time.Time `json:"completionTime,omitempty"`
+}
+
+// KubernetesGetJobLogs returns the logs of all Pods of a Job. The Pods are selected via the controller-uid label of
+// the Job and ordered by their completion time, Pods which are not completed yet are added at the end. The logs of each
+// container are prefixed with a header, which contains the name of the Pod and container and the exit code. For failed
+// Pods the logs of the previous container are also included, when the container was restarted. If "onlyFailed" is
+// true, only the logs of the failed Pods are returned.
+func KubernetesGetJobLogs(clientset *kubernetes.Clientset, clusterServer, namespace, name, container string, tailLines int64, onlyFailed bool) (string, error) {
+	ctx := context.Background()
+
+	if tailLines <= 0 {
+		tailLines = DefaultJobLogsTailLines
+	}
+
+	job, err := clientset.BatchV1().Jobs(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+
+	// The Pods are selected via the "controller-uid" label. If no Pods are found, the "batch.kubernetes.io/controller-uid"
+	// label is used, which is set in addition since Kubernetes 1.27 and will replace the old label.
+	pods, err := clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{LabelSelector: fmt.Sprintf("controller-uid=%s", job.UID)})
+	if err != nil {
+		return "", err
+	}
+	if len(pods.Items) == 0 {
+		pods, err = clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{LabelSelector: fmt.Sprintf("batch.kubernetes.io/controller-uid=%s", job.UID)})
+		if err != nil {
+			return "", err
+		}
+	}
+
+	var items []corev1.Pod
+	for _, pod := range pods.Items {
+		if onlyFailed && !jobPodFailed(pod) {
+			continue
+		}
+		items = append(items, pod)
+	}
+
+	sort.SliceStable(items, func(i, j int) bool {
+		ci, cj := jobPodCompletionTime(items[i]), jobPodCompletionTime(items[j])
+		if ci.IsZero() != cj.IsZero() {
+			return !ci.IsZero()
+		}
+		if ci.IsZero() && !items[i].CreationTimestamp.Equal(&items[j].CreationTimestamp) {
+			return items[i].CreationTimestamp.Before(&items[j].CreationTimestamp)
+		}
+		if !ci.Equal(cj) {
+			return ci.Before(cj)
+		}
+		return items[i].Name < items[j].Name
+	})
+
+	var logs strings.Builder
+	jobPods := make([]jobLogsPod, 0, len(items))
+
+	for _, pod := range items {
+		jobPod := jobLogsPod{
+			Name:   pod.Name,
+			Phase:  string(pod.Status.Phase),
+			Failed: jobPodFailed(pod),
+		}
+		if completionTime := jobPodCompletionTime(pod); !completionTime.IsZero() {
+			jobPod.CompletionTime = &completionTime
+		}
+
+		for _, status := range pod.Status.ContainerStatuses {
+			if container != "" && status.Name != container {
+				continue
+			}
+
+			if jobPod.Failed && status.RestartCount > 0 && status.LastTerminationState.Terminated != nil {
+				exitCode := status.LastTerminationState.Terminated.ExitCode
+				writeJobLogs(ctx, &logs, clientset, clusterServer, pod, status.Name, tailLines, true, &exitCode)
+			}
+
+			var exitCode *int32
+			if status.State.Terminated != nil {
+				exitCode = &status.State.Terminated.ExitCode
+				if jobPod.ExitCode == nil || *exitCode != 0 {
+					jobPod.ExitCode = exitCode
+				}
+			}
+			writeJobLogs(ctx, &logs, clientset, clusterServer, pod, status.Name, tailLines, false, exitCode)
+		}
+
+		jobPods = append(jobPods, jobPod)
+	}
+
+	data := struct {
+		Logs string       `json:"logs"`
+		Pods []jobLogsPod `json:"pods"`
+	}{
+		logs.String(),
+		jobPods,
+	}
+
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return "", err
+	}
+
+	return string(jsonData), nil
+}
+
+// writeJobLogs writes the header and the logs of a single container to "logs". When the logs can not be fetched, e.g.
+// because the Pod is still pending, the error is written instead of the logs, so that the logs of the other Pods are
+// still returned.
+func writeJobLogs(ctx context.Context, logs *strings.Builder, clientset *kubernetes.Clientset, clusterServer string, pod corev1.Pod, container string, tailLines int64, previous bool, exitCode *int32) {
+	header := fmt.Sprintf("===== %s/%s", pod.Name, container)
+	if previous {
+		header = header + " (previous)"
+	}
+	if exitCode != nil {
+		header = fmt.Sprintf("%s (exit code %d)", header, *exitCode)
+	}
+	logs.WriteString(header + " =====\n")
+
+	requestURL := fmt.Sprintf("%s/api/v1/namespaces/%s/pods/%s/log?container=%s&tailLines=%d&previous=%t", clusterServer, pod.Namespace, pod.Name, container, tailLines, previous)
+	responseBody, err := kubernetesFetchLogs(ctx, clientset, requestURL)
+	if err != nil {
+		logs.WriteString(fmt.Sprintf("Could not get logs: %s\n\n", strings.TrimSpace(err.Error())))
+		return
+	}
+
+	logs.Write(responseBody)
+	if len(responseBody) > 0 && responseBody[len(responseBody)-1] != '\n' {
+		logs.WriteString("\n")
+	}
+	logs.WriteString("\n")
+}
+
+// jobPodFailed returns true, when the Pod failed or one of its containers terminated with a non zero exit code.
+func jobPodFailed(pod corev1.Pod) bool {
+	if pod.Status.Phase == corev1.PodFailed {
+		return true
+	}
+
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.State.Terminated != nil && status.State.Terminated.ExitCode != 0 {
+			return true
+		}
+		if status.LastTerminationState.Terminated != nil && status.LastTerminationState.Terminated.ExitCode != 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// jobPodCompletionTime returns the time, when the last container of the Pod terminated. A zero time is returned, when
+// not all containers of the Pod are terminated.
+func jobPodCompletionTime(pod corev1.Pod) time.Time {
+	var completionTime time.Time
+
+	if len(pod.Status.ContainerStatuses) == 0 {
+		return completionTime
+	}
+
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.State.Terminated == nil {
+			return time.Time{}
+		}
+		if status.State.Terminated.FinishedAt.Time.After(completionTime) {
+			completionTime = status.State.Terminated.FinishedAt.Time
+		}
+	}
+
+	return completionTime
+}
@@ -3,56 +3,456 @@ package shared
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log"
+	"net"
 	"net/http"
+	"net/url"
+	"os"
 	"regexp"
+	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 
+	"github.com/kubenav/kubenav/pkg/server/requestcache"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 )
 
+// DefaultMaxResponseSize is the default maximum size of a response, which is read into memory by KubernetesRequest.
+const DefaultMaxResponseSize = 64 * 1024 * 1024
+
+// listPageSize is the number of items, which are requested per page, when a list exceeds the maximum response size.
+const listPageSize = 500
+
+// maxResponseSize is the maximum size of a response for KubernetesRequest, which can be changed via
+// SetMaxResponseSize.
+var maxResponseSize atomic.Int64
+
+// SetMaxResponseSize sets the maximum size of a response in bytes, which is read into memory by KubernetesRequest. If
+// the size is 0 or negative, DefaultMaxResponseSize is used.
+func SetMaxResponseSize(size int64) {
+	maxResponseSize.Store(size)
+}
+
+func getMaxResponseSize() int64 {
+	if size := maxResponseSize.Load(); size > 0 {
+		return size
+	}
+	return DefaultMaxResponseSize
+}
+
+// ResponseTooLargeError is returned by KubernetesRequest, when the response exceeds the maximum response size. The
+// reading of the response is aborted, so that "Read" is the number of bytes which were read until the limit was hit.
+type ResponseTooLargeError struct {
+	Limit int64
+	Read  int64
+}
+
+func (e *ResponseTooLargeError) Error() string {
+	return fmt.Sprintf("response too large: the response exceeds the limit of %d bytes (%d bytes read), use a paginated request via the \"limit\" and \"continue\" parameters or stream the response to a file", e.Limit, e.Read)
+}
+
 // KubernetesRequest is used to execute a request against a Kubernetes API. The Kubernetes API server and it's ca are
 // specified via the "clusterServer" and "clusterCertificateAuthorityData" arguments. To skip the tls verification the
 // request can set the "clusterInsecureSkipTLSVerify" argument to true. To handle the authentication against the API
 // server the "user*" arguments can be used.
 // The "requestMethod", "requestURL" and "requestBody" arguments are then used for the actually request. E.g. to get all
 // Pods from the Kubernetes API the method "GET" and the URL "/api/v1/pods" can be used.
-func KubernetesRequest(clientset *kubernetes.Clientset, requestMethod, requestURL, requestBody string) (string, error) {
-	var responseResult rest.Result
-	var statusCode int
-	ctx := context.Background()
+// GET requests can use the request cache via the "cacheControl" argument (see requestcache.CacheControlBypass for the
+// supported values), the responses are stored by the "cluster", the "credentials" (see requestcache.CredentialsKey) and
+// the url, so that they are never shared between different users of a cluster. All other requests invalidate the cached
+// responses of the mutated resource. When the offline cache is enabled, successful GET responses are also persisted
+// on disk (see KubernetesRequestWithOfflineFallback).
+// Responses are only read up to the maximum response size (see SetMaxResponseSize), larger responses return a
+// ResponseTooLargeError. For a list request without a limit, the list is fetched again page by page instead (see
+// kubernetesListPages). Concurrent GET requests for the same cluster, credentials and url are deduplicated (see
+// requestcache.Requests), so that they share a single request to the Kubernetes API.
+func KubernetesRequest(clientset *kubernetes.Clientset, cluster, credentials, cacheControl, requestMethod, requestURL, requestBody string) (string, error) {
+	return KubernetesRequestWithContext(context.Background(), clientset, cluster, credentials, cacheControl, requestMethod, requestURL, requestBody)
+}
+
+// KubernetesRequestWithContext is the same as KubernetesRequest, but the request is aborted when the given context is
+// canceled.
+func KubernetesRequestWithContext(ctx context.Context, clientset *kubernetes.Clientset, cluster, credentials, cacheControl, requestMethod, requestURL, requestBody string) (string, error) {
+	var request *rest.Request
+
+	policy, err := requestcache.Default.ParsePolicy(cacheControl)
+	if err != nil {
+		return "", err
+	}
+
+	if requestMethod == http.MethodGet && policy.Enabled {
+		if entry, ok := requestcache.Default.Get(cluster, credentials, requestURL, policy.MaxAge); ok {
+			return string(entry.Body), nil
+		}
+	} else if requestMethod != http.MethodGet {
+		defer requestcache.Default.Invalidate(cluster, requestURL)
+	}
 
 	if requestMethod == http.MethodGet {
-		responseResult = clientset.RESTClient().Get().RequestURI(requestURL).Do(ctx)
+		request = clientset.RESTClient().Get().RequestURI(requestURL)
 	} else if requestMethod == http.MethodDelete {
-		responseResult = clientset.RESTClient().Delete().RequestURI(requestURL).Body([]byte(requestBody)).Do(ctx)
+		request = clientset.RESTClient().Delete().RequestURI(requestURL).Body([]byte(requestBody))
 	} else if requestMethod == http.MethodPatch {
-		responseResult = clientset.RESTClient().Patch(types.JSONPatchType).RequestURI(requestURL).Body([]byte(requestBody)).Do(ctx)
+		request = clientset.RESTClient().Patch(types.JSONPatchType).RequestURI(requestURL).Body([]byte(requestBody))
 	} else if requestMethod == http.MethodPost {
-		responseResult = clientset.RESTClient().Post().RequestURI(requestURL).Body([]byte(requestBody)).Do(ctx)
+		request = clientset.RESTClient().Post().RequestURI(requestURL).Body([]byte(requestBody))
+	} else {
+		return "", fmt.Errorf("unsupported request method %s", requestMethod)
 	}
 
-	if responseResult.Error() != nil {
-		return "", responseResult.Error()
+	// Concurrent identical GET requests of the same user share a single request to the Kubernetes API. The credentials
+	// are part of the key, so that a user never receives a response, which was fetched with the credentials of another
+	// user. The responses are stored in the caches by the shared request, so that they are only stored once.
+	responseBody, _, err := requestcache.Requests.Do(ctx, requestcache.DedupKey(cluster+"\x00"+credentials, requestMethod, requestURL), func(ctx context.Context) ([]byte, error) {
+		responseBody, err := kubernetesRequest(ctx, clientset, request, requestMethod, requestURL)
+		if err != nil {
+			return nil, err
+		}
+
+		if requestMethod == http.MethodGet && policy.Enabled {
+			requestcache.Default.Set(cluster, credentials, requestURL, responseBody)
+		}
+		if requestMethod == http.MethodGet {
+			if err := requestcache.Offline.Set(cluster, requestURL, responseBody); err != nil {
+				log.Printf("Could not persist response for offline cache: %s", err.Error())
+			}
+		}
+
+		return responseBody, nil
+	})
+	if err != nil {
+		return "", err
 	}
 
-	responseResult = responseResult.StatusCode(&statusCode)
-	if statusCode == http.StatusUnauthorized {
-		return "", fmt.Errorf(http.StatusText(http.StatusUnauthorized))
+	return string(responseBody), nil
+}
+
+// kubernetesRequest executes the request and returns the response body. When the response of a list request without a
+// limit is too large, the list is fetched page by page instead.
+func kubernetesRequest(ctx context.Context, clientset *kubernetes.Clientset, request *rest.Request, requestMethod, requestURL string) ([]byte, error) {
+	limit := getMaxResponseSize()
+	responseBody, err := readResponse(ctx, request, limit)
+	if err != nil {
+		var tooLargeErr *ResponseTooLargeError
+		if requestMethod != http.MethodGet || !errors.As(err, &tooLargeErr) || !isUnlimitedList(requestURL) {
+			return nil, err
+		}
+
+		// Only the original error is returned, when the response isn't a list, e.g. for the logs of a container.
+		pagedBody, pageErr := kubernetesListPages(ctx, clientset, requestURL, limit)
+		if pageErr != nil {
+			return nil, err
+		}
+		responseBody = pagedBody
 	}
 
-	responseBody, err := responseResult.Raw()
+	return responseBody, nil
+}
+
+// OfflineResponse is the response of KubernetesRequestWithOfflineFallback. When the cluster wasn't reachable and a
+// persisted response was returned instead, "Stale" is true and the time when the response was stored and its age in
+// seconds are set.
+type OfflineResponse struct {
+	Body       json.RawMessage `json:"body"`
+	Stale      bool            `json:"stale"`
+	StoredAt   *time.Time      `json:"storedAt,omitempty"`
+	AgeSeconds int64           `json:"ageSeconds,omitempty"`
+}
+
+// KubernetesRequestWithOfflineFallback executes a GET request like KubernetesRequestWithContext. When the request fails
+// with a network error, the last response which was persisted in the offline cache (see requestcache.Offline) is
+// returned instead, if it isn't older than the given staleness tolerance. A tolerance of 0 accepts responses of any
+// age. Errors of the Kubernetes API (e.g. a forbidden request) are always returned.
+func KubernetesRequestWithOfflineFallback(ctx context.Context, clientset *kubernetes.Clientset, cluster, credentials, cacheControl, requestURL string, tolerance time.Duration) (*OfflineResponse, error) {
+	result, err := KubernetesRequestWithContext(ctx, clientset, cluster, credentials, cacheControl, http.MethodGet, requestURL, "")
+	if err == nil {
+		if !json.Valid([]byte(result)) {
+			return nil, fmt.Errorf("response is not valid JSON")
+		}
+		return &OfflineResponse{Body: json.RawMessage(result)}, nil
+	}
+
+	var netErr net.Error
+	if !errors.As(err, &netErr) {
+		return nil, err
+	}
+
+	entry, ok := requestcache.Offline.Get(cluster, requestURL, tolerance)
+	if !ok {
+		return nil, err
+	}
+
+	return &OfflineResponse{
+		Body:       entry.Body,
+		Stale:      true,
+		StoredAt:   &entry.StoredAt,
+		AgeSeconds: int64(time.Since(entry.StoredAt).Seconds()),
+	}, nil
+}
+
+// readResponse executes the request and reads the response body up to the given limit. Like for "Do", responses with a
+// status code outside of the 2xx range are returned as error of the Kubernetes API.
+func readResponse(ctx context.Context, request *rest.Request, limit int64) ([]byte, error) {
+	stream, err := request.Stream(ctx)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
+	defer stream.Close()
 
-	if statusCode < 200 || statusCode >= 300 {
-		return "", fmt.Errorf(string(responseBody))
+	data, err := io.ReadAll(io.LimitReader(stream, limit+1))
+	if err != nil {
+		return nil, err
 	}
 
-	return string(responseBody), nil
+	if int64(len(data)) > limit {
+		return nil, &ResponseTooLargeError{Limit: limit, Read: int64(len(data))}
+	}
+
+	return data, nil
+}
+
+// isUnlimitedList returns true, when the url could be a list request, which can be paginated, because it neither
+// contains a limit nor a continue token and isn't a watch request.
+func isUnlimitedList(requestURL string) bool {
+	parsedURL, err := url.Parse(requestURL)
+	if err != nil {
+		return false
+	}
+
+	query := parsedURL.Query()
+	return !query.Has("limit") && !query.Has("continue") && query.Get("watch") != "true" && query.Get("watch") != "1"
+}
+
+// kubernetesListPages fetches a list, which exceeded the maximum response size, page by page via the "limit" and
+// "continue" parameters. Pages are added to the returned list as long as the total size stays below the limit. When
+// not all items fit into the limit, the "continue" token of the returned list is set to the token of the last added
+// page, so that the caller can fetch the remaining items via a paginated request.
+func kubernetesListPages(ctx context.Context, clientset *kubernetes.Clientset, requestURL string, limit int64) ([]byte, error) {
+	parsedURL, err := url.Parse(requestURL)
+	if err != nil {
+		return nil, err
+	}
+
+	var result map[string]json.RawMessage
+	var resultMetadata map[string]json.RawMessage
+	var items []json.RawMessage
+	var size int64
+	continueToken := ""
+
+	for {
+		query := parsedURL.Query()
+		query.Set("limit", strconv.Itoa(listPageSize))
+		if continueToken != "" {
+			query.Set("continue", continueToken)
+		}
+		pageURL := *parsedURL
+		pageURL.RawQuery = query.Encode()
+
+		data, err := readResponse(ctx, clientset.RESTClient().Get().RequestURI(pageURL.String()), limit)
+		if err != nil {
+			return nil, err
+		}
+
+		var page struct {
+			Kind     string            `json:"kind"`
+			Metadata metav1.ListMeta   `json:"metadata"`
+			Items    []json.RawMessage `json:"items"`
+		}
+		if err := json.Unmarshal(data, &page); err != nil {
+			return nil, err
+		}
+		if !strings.HasSuffix(page.Kind, "List") {
+			return nil, fmt.Errorf("response is not a list")
+		}
+
+		if result != nil && size+int64(len(data)) > limit {
+			break
+		}
+
+		if result == nil {
+			if err := json.Unmarshal(data, &result); err != nil {
+				return nil, err
+			}
+			if metadata, ok := result["metadata"]; ok {
+				if err := json.Unmarshal(metadata, &resultMetadata); err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		size += int64(len(data))
+		items = append(items, page.Items...)
+		continueToken = page.Metadata.Continue
+		if continueToken == "" {
+			break
+		}
+	}
+
+	if resultMetadata == nil {
+		resultMetadata = make(map[string]json.RawMessage)
+	}
+	delete(resultMetadata, "continue")
+	delete(resultMetadata, "remainingItemCount")
+	if continueToken != "" {
+		resultMetadata["continue"], _ = json.Marshal(continueToken)
+	}
+
+	if items == nil {
+		items = []json.RawMessage{}
+	}
+
+	metadata, err := json.Marshal(resultMetadata)
+	if err != nil {
+		return nil, err
+	}
+	result["metadata"] = metadata
+
+	data, err := json.Marshal(items)
+	if err != nil {
+		return nil, err
+	}
+	result["items"] = data
+
+	return json.Marshal(result)
+}
+
+// maxErrorResponseSize is the maximum number of bytes, which are read from an error response of the Kubernetes API in
+// KubernetesRequestToFile.
+const maxErrorResponseSize = 1024 * 1024
+
+// fileProgressInterval is the number of bytes after which the progress of KubernetesRequestToFile is reported.
+const fileProgressInterval = 256 * 1024
+
+// FileResponse is the result of KubernetesRequestToFile. It contains the path of the file, to which the response body
+// was written, the size of the body in bytes and the content type of the response.
+type FileResponse struct {
+	Path        string `json:"path"`
+	Size        int64  `json:"size"`
+	ContentType string `json:"contentType"`
+}
+
+// KubernetesRequestToFile is the same as KubernetesRequestWithContext, but the response body is streamed to the file
+// at the given path instead of returning it, so that very large responses (e.g. all events of a cluster) must not be
+// kept in memory. The status code is checked before the file is created, so that errors of the Kubernetes API are
+// returned in the same way as by KubernetesRequest. When the request fails while the body is written, the partial file
+// is removed. The "progress" function is called with the number of written bytes and can be nil.
+func KubernetesRequestToFile(ctx context.Context, clientset *kubernetes.Clientset, requestMethod, requestURL, requestBody, path string, progress func(bytes int64)) (*FileResponse, error) {
+	if path == "" {
+		return nil, fmt.Errorf("path is required")
+	}
+
+	restClient, ok := clientset.RESTClient().(*rest.RESTClient)
+	if !ok || restClient.Client == nil {
+		return nil, fmt.Errorf("unsupported rest client")
+	}
+
+	var request *rest.Request
+	switch requestMethod {
+	case http.MethodGet:
+		request = restClient.Get()
+	case http.MethodDelete:
+		request = restClient.Delete()
+	case http.MethodPatch:
+		request = restClient.Patch(types.JSONPatchType)
+	case http.MethodPost:
+		request = restClient.Post()
+	default:
+		return nil, fmt.Errorf("unsupported request method %s", requestMethod)
+	}
+	request = request.RequestURI(requestURL)
+
+	var body io.Reader
+	if requestBody != "" {
+		body = strings.NewReader(requestBody)
+	}
+
+	httpRequest, err := http.NewRequestWithContext(ctx, requestMethod, request.URL().String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if requestBody != "" {
+		if requestMethod == http.MethodPatch {
+			httpRequest.Header.Set("Content-Type", string(types.JSONPatchType))
+		} else {
+			httpRequest.Header.Set("Content-Type", "application/json")
+		}
+	}
+
+	resp, err := restClient.Client.Do(httpRequest)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		return nil, fmt.Errorf(http.StatusText(http.StatusUnauthorized))
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		responseBody, err := io.ReadAll(io.LimitReader(resp.Body, maxErrorResponseSize))
+		if err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf(string(responseBody))
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	writer := &progressWriter{w: file, progress: progress}
+	if _, err := io.Copy(writer, resp.Body); err != nil {
+		file.Close()
+		os.Remove(path)
+		return nil, err
+	}
+
+	if err := file.Close(); err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+
+	if progress != nil {
+		progress(writer.bytes)
+	}
+
+	return &FileResponse{
+		Path:        path,
+		Size:        writer.bytes,
+		ContentType: resp.Header.Get("Content-Type"),
+	}, nil
+}
+
+// progressWriter counts the bytes written to the underlying writer and reports them to the progress function.
+type progressWriter struct {
+	w        io.Writer
+	progress func(bytes int64)
+	bytes    int64
+	reported int64
+}
+
+func (p *progressWriter) Write(data []byte) (int, error) {
+	n, err := p.w.Write(data)
+	p.bytes += int64(n)
+	if p.progress != nil && p.bytes-p.reported >= fileProgressInterval {
+		p.reported = p.bytes
+		p.progress(p.bytes)
+	}
+	return n, err
+}
+
+// KubernetesFlushCache removes all cached responses from the request cache.
+func KubernetesFlushCache() {
+	requestcache.Default.Flush()
 }
 
 // KubernetesGetLogs returns the logs for a list of pods. The names of the Pods are provided via the "names" parameter,
@@ -86,30 +486,12 @@ func KubernetesGetLogs(clientset *kubernetes.Clientset, clusterServer, names, na
 }
 
 func kubernetesGetLogs(clientset *kubernetes.Clientset, clusterServer, name, namespace, container string, since int64, filter string, previous, prefix bool) ([]string, error) {
-	var statusCode int
-	ctx := context.Background()
-
 	requestURL := fmt.Sprintf("%s/api/v1/namespaces/%s/pods/%s/log?container=%s&sinceSeconds=%d&previous=%t", clusterServer, namespace, name, container, since, previous)
-	responseResult := clientset.RESTClient().Get().RequestURI(requestURL).Do(ctx)
-
-	if responseResult.Error() != nil {
-		return nil, responseResult.Error()
-	}
-
-	responseResult = responseResult.StatusCode(&statusCode)
-	if statusCode == http.StatusUnauthorized {
-		return nil, fmt.Errorf(http.StatusText(http.StatusUnauthorized))
-	}
-
-	responseBody, err := responseResult.Raw()
+	responseBody, err := kubernetesFetchLogs(context.Background(), clientset, requestURL)
 	if err != nil {
 		return nil, err
 	}
 
-	if statusCode < 200 || statusCode >= 300 {
-		return nil, fmt.Errorf(string(responseBody))
-	}
-
 	if filter == "" {
 		if !prefix {
 			return strings.Split(string(responseBody), "\n"), nil
@@ -141,3 +523,30 @@ func kubernetesGetLogs(clientset *kubernetes.Clientset, clusterServer, name, nam
 
 	return logs, nil
 }
+
+// kubernetesFetchLogs is the core of the log requests: It executes the request for the logs of a container and returns
+// the logs. For a response with a status code outside of the 2xx range, the response body is returned as error.
+func kubernetesFetchLogs(ctx context.Context, clientset *kubernetes.Clientset, requestURL string) ([]byte, error) {
+	var statusCode int
+
+	responseResult := clientset.RESTClient().Get().RequestURI(requestURL).Do(ctx)
+	if responseResult.Error() != nil {
+		return nil, responseResult.Error()
+	}
+
+	responseResult = responseResult.StatusCode(&statusCode)
+	if statusCode == http.StatusUnauthorized {
+		return nil, fmt.Errorf(http.StatusText(http.StatusUnauthorized))
+	}
+
+	responseBody, err := responseResult.Raw()
+	if err != nil {
+		return nil, err
+	}
+
+	if statusCode < 200 || statusCode >= 300 {
+		return nil, fmt.Errorf(string(responseBody))
+	}
+
+	return responseBody, nil
+}
@@ -0,0 +1,11 @@
+package shared
+
+import (
+	"github.com/kubenav/kubenav/pkg/server/kustomize"
+)
+
+// KustomizeRender renders the kustomization in the given directory and returns the rendered multi-document manifest.
+// Remote bases are only used, when "allowRemoteBases" is true.
+func KustomizeRender(dir string, allowRemoteBases bool) (string, error) {
+	return kustomize.RenderDirectory(dir, kustomize.Options{AllowRemoteBases: allowRemoteBases})
+}
@@ -71,12 +71,7 @@ func PrometheusGetData(restConfig *rest.Config, clientset *kubernetes.Clientset,
 			return "", err
 		}
 
-		defer func() {
-			if session, ok := portforwarding.Sessions.Get(pf.ID); ok {
-				close(session.StopCh)
-				portforwarding.Sessions.Delete(pf.ID)
-			}
-		}()
+		defer portforwarding.Sessions.Stop(pf.ID)
 
 		requestData.Prometheus.Address = fmt.Sprintf("http://localhost:%d%s", pf.LocalPort, requestData.Prometheus.Path)
 	}
@@ -0,0 +1,11 @@
+// Package version contains the version and git commit of kubenav. The values are injected at build time via the
+// "-X github.com/kubenav/kubenav/pkg/version.Version=<version>" and
+// "-X github.com/kubenav/kubenav/pkg/version.Commit=<commit>" ldflags, see the Makefile.
+package version
+
+var (
+	// Version is the version of kubenav, which is set at build time.
+	Version = "dev"
+	// Commit is the git commit of kubenav, which is set at build time.
+	Commit = "unknown"
+)